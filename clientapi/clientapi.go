@@ -59,7 +59,7 @@ func AddPublicRoutes(
 	}
 
 	routing.Setup(
-		routers,
+		processContext, routers,
 		cfg, rsAPI, asAPI,
 		userAPI, userDirectoryProvider, federation,
 		syncProducer, transactionsCache, fsAPI,
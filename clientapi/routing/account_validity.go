@@ -0,0 +1,81 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+)
+
+type renewAccountResponse struct {
+	Renewed      bool           `json:"renewed"`
+	ExpirationTS spec.Timestamp `json:"expiration_ts"`
+}
+
+// RenewAccount implements GET /account_validity/renew, which is the link in
+// renewal emails. The renewal token in the link identifies the account.
+func RenewAccount(req *http.Request, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.MissingParam("Missing renewal token"),
+		}
+	}
+	var res userapi.PerformAccountRenewalResponse
+	if err := userAPI.PerformAccountRenewal(req.Context(), &userapi.PerformAccountRenewalRequest{
+		RenewalToken: token,
+	}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformAccountRenewal failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !res.Renewed {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("Unknown or already used renewal token"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: renewAccountResponse{
+			Renewed:      true,
+			ExpirationTS: res.ExpirationTS,
+		},
+	}
+}
+
+// SendRenewalEmail implements POST /account_validity/send_mail, which sends
+// the user a new renewal email. This works even if the account has expired.
+func SendRenewalEmail(req *http.Request, device *userapi.Device, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	if err := userAPI.PerformRenewalEmail(req.Context(), &userapi.PerformRenewalEmailRequest{
+		UserID: device.UserID,
+	}, &struct{}{}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformRenewalEmail failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
@@ -328,6 +328,128 @@ func AdminPurgeRoom(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI)
 	}
 }
 
+// AdminStateGC returns the progress of the state garbage collector, or on
+// POST starts a new pass over the rooms.
+func AdminStateGC(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	var progress roomserverAPI.StateGCProgress
+	if req.Method == http.MethodPost {
+		progress = rsAPI.PerformAdminStateGC(req.Context())
+	} else {
+		progress = rsAPI.QueryAdminStateGC(req.Context())
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: progress,
+	}
+}
+
+// AdminForwardExtremities lists the forward extremities of a room.
+func AdminForwardExtremities(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	extremities, err := rsAPI.QueryAdminForwardExtremities(req.Context(), vars["roomID"])
+	if err != nil {
+		return adminRoomStateError(err, vars["roomID"], "Failed to get forward extremities")
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"count":       len(extremities),
+			"extremities": extremities,
+		},
+	}
+}
+
+// AdminMergeForwardExtremities has a local member of a room send a dummy
+// event to merge the forward extremities of the room.
+func AdminMergeForwardExtremities(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	eventID, err := rsAPI.PerformAdminMergeForwardExtremities(req.Context(), vars["roomID"])
+	if err != nil {
+		return adminRoomStateError(err, vars["roomID"], "Failed to merge forward extremities")
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"event_id": eventID,
+		},
+	}
+}
+
+// AdminStateAfterEvent returns the state of a room after an event, and how
+// it differs from the current state.
+func AdminStateAfterEvent(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	res, err := rsAPI.QueryAdminStateAfterEvent(req.Context(), vars["roomID"], vars["eventID"])
+	if err != nil {
+		return adminRoomStateError(err, vars["roomID"], "Failed to get state after event")
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+// AdminResolveState runs state resolution over the state after the given
+// events, or the forward extremities if no event_ids are given, and shows
+// which events won. Nothing is stored.
+func AdminResolveState(req *http.Request, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	request := struct {
+		EventIDs []string `json:"event_ids"`
+	}{}
+	if req.ContentLength != 0 {
+		if err = json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.BadJSON("Failed to decode request body: " + err.Error()),
+			}
+		}
+	}
+	res, err := rsAPI.QueryAdminResolveState(req.Context(), vars["roomID"], request.EventIDs)
+	if err != nil {
+		return adminRoomStateError(err, vars["roomID"], "Failed to resolve state")
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
+
+func adminRoomStateError(err error, roomID, message string) util.JSONResponse {
+	switch e := err.(type) {
+	case eventutil.ErrRoomNoExists:
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound(e.Error()),
+		}
+	case roomserverAPI.ErrInvalidID:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(e.Error()),
+		}
+	case roomserverAPI.ErrNotAllowed:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.Forbidden(e.Error()),
+		}
+	default:
+		logrus.WithError(err).WithField("roomID", roomID).Error(message)
+		return util.ErrorResponse(err)
+	}
+}
+
 func AdminResetPassword(req *http.Request, cfg *config.ClientAPI, device *api.Device, userAPI userapi.ClientUserAPI) util.JSONResponse {
 	if req.Body == nil {
 		return util.JSONResponse{
@@ -423,6 +545,35 @@ func AdminReindex(req *http.Request, cfg *config.ClientAPI, device *api.Device,
 	}
 }
 
+// AdminUsageStatistics returns the usage statistics of this server. The
+// format query parameter selects either the "detailed" format, which is
+// the default, or the "phone_home" format.
+func AdminUsageStatistics(req *http.Request, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	format := req.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = config.ReportStatsFormatDetailed
+	case config.ReportStatsFormatDetailed, config.ReportStatsFormatPhoneHome:
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("format must be one of \"detailed\" or \"phone_home\""),
+		}
+	}
+	res := &userapi.QueryUsageStatisticsResponse{}
+	if err := userAPI.QueryUsageStatistics(req.Context(), &userapi.QueryUsageStatisticsRequest{Format: format}, res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryUsageStatistics failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res.Statistics,
+	}
+}
+
 func AdminMarkAsStale(req *http.Request, cfg *config.ClientAPI, keyAPI userapi.ClientKeyAPI) util.JSONResponse {
 	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 	if err != nil {
@@ -457,6 +608,87 @@ func AdminMarkAsStale(req *http.Request, cfg *config.ClientAPI, keyAPI userapi.C
 	}
 }
 
+// AdminMakeRoomAdmin has the most powerful local member of a room give a local
+// user the same power level, joining the user to the room first if needed. The
+// user defaults to the requester if no user_id is given.
+func AdminMakeRoomAdmin(req *http.Request, device *api.Device, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	request := struct {
+		UserID string `json:"user_id"`
+	}{}
+	if req.ContentLength != 0 {
+		if err = json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.BadJSON("Failed to decode request body: " + err.Error()),
+			}
+		}
+	}
+	if request.UserID == "" {
+		request.UserID = device.UserID
+	}
+	userID, err := spec.NewUserID(request.UserID, true)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Invalid user ID"),
+		}
+	}
+
+	roomID := vars["roomID"]
+	if len(roomID) > 0 && roomID[0] == '#' {
+		aliasRes := &roomserverAPI.GetRoomIDForAliasResponse{}
+		if err = rsAPI.GetRoomIDForAlias(req.Context(), &roomserverAPI.GetRoomIDForAliasRequest{
+			Alias:              roomID,
+			IncludeAppservices: true,
+		}, aliasRes); err != nil {
+			return util.ErrorResponse(err)
+		}
+		if aliasRes.RoomID == "" {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: spec.NotFound(fmt.Sprintf("Room alias %s not found", roomID)),
+			}
+		}
+		roomID = aliasRes.RoomID
+	}
+
+	grantedBy, err := rsAPI.PerformAdminMakeRoomAdmin(req.Context(), roomID, *userID)
+	switch e := err.(type) {
+	case nil:
+	case eventutil.ErrRoomNoExists:
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound(e.Error()),
+		}
+	case roomserverAPI.ErrInvalidID:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(e.Error()),
+		}
+	case roomserverAPI.ErrNotAllowed:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.Forbidden(e.Error()),
+		}
+	default:
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"userID": userID.String(),
+			"roomID": roomID,
+		}).Error("Failed to make user a room admin")
+		return util.ErrorResponse(err)
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"granted_by": grantedBy,
+		},
+	}
+}
+
 func AdminDownloadState(req *http.Request, device *api.Device, rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
 	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
 	if err != nil {
@@ -585,3 +817,108 @@ func parseUint64OrDefault(input string, defaultValue uint64) uint64 {
 	}
 	return v
 }
+
+// AdminGetAccountValidity returns when a local user's account expires.
+func AdminGetAccountValidity(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	userID := vars["userID"]
+	if _, _, err = cfg.Matrix.SplitLocalID('@', userID); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(err.Error()),
+		}
+	}
+	var res userapi.QueryAccountValidityResponse
+	if err = userAPI.QueryAccountValidity(req.Context(), &userapi.QueryAccountValidityRequest{
+		UserID: userID,
+	}, &res); err != nil {
+		logrus.WithError(err).Error("userAPI.QueryAccountValidity failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	response := map[string]interface{}{
+		"user_id": userID,
+		"expires": res.Expires,
+	}
+	if res.Expires {
+		response["expiration_ts"] = res.Validity.ExpirationTS
+		response["email_sent"] = res.Validity.EmailSent
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: response,
+	}
+}
+
+// AdminSetAccountValidity sets when a local user's account expires, which
+// defaults to one validity period from now. The user is taken from the path,
+// or from the user_id in the body for the Synapse-compatible endpoint.
+func AdminSetAccountValidity(req *http.Request, cfg *config.ClientAPI, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	request := struct {
+		UserID              string         `json:"user_id"`
+		ExpirationTS        spec.Timestamp `json:"expiration_ts"`
+		EnableRenewalEmails *bool          `json:"enable_renewal_emails"`
+	}{}
+	if req.ContentLength != 0 {
+		if err = json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.BadJSON("Failed to decode request body: " + err.Error()),
+			}
+		}
+	}
+	if userID, ok := vars["userID"]; ok {
+		request.UserID = userID
+	}
+	localpart, serverName, err := cfg.Matrix.SplitLocalID('@', request.UserID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(err.Error()),
+		}
+	}
+	accAvailableResp := &userapi.QueryAccountAvailabilityResponse{}
+	if err = userAPI.QueryAccountAvailability(req.Context(), &userapi.QueryAccountAvailabilityRequest{
+		Localpart:  localpart,
+		ServerName: serverName,
+	}, accAvailableResp); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if accAvailableResp.Available {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("User does not exist"),
+		}
+	}
+
+	var res userapi.PerformAccountValidityUpdateResponse
+	if err = userAPI.PerformAccountValidityUpdate(req.Context(), &userapi.PerformAccountValidityUpdateRequest{
+		UserID:              request.UserID,
+		ExpirationTS:        request.ExpirationTS,
+		EnableRenewalEmails: request.EnableRenewalEmails == nil || *request.EnableRenewalEmails,
+	}, &res); err != nil {
+		logrus.WithError(err).Error("userAPI.PerformAccountValidityUpdate failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"expiration_ts": res.ExpirationTS,
+		},
+	}
+}
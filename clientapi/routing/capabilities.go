@@ -21,11 +21,12 @@ import (
 	"github.com/jchv/maidtrix/internal/util"
 	roomserverAPI "github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/roomserver/version"
+	"github.com/jchv/maidtrix/setup/config"
 )
 
 // GetCapabilities returns information about the server's supported feature set
 // and other relevant capabilities to an authenticated user.
-func GetCapabilities(rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse {
+func GetCapabilities(rsAPI roomserverAPI.ClientRoomserverAPI, cfg *config.ClientAPI) util.JSONResponse {
 	versionsMap := map[gomatrixserverlib.RoomVersion]string{}
 	for v, desc := range version.SupportedRoomVersions() {
 		if desc.Stable() {
@@ -44,6 +45,9 @@ func GetCapabilities(rsAPI roomserverAPI.ClientRoomserverAPI) util.JSONResponse
 				"default":   rsAPI.DefaultRoomVersion(),
 				"available": versionsMap,
 			},
+			"m.get_login_token": map[string]bool{
+				"enabled": cfg.LoginViaExistingSession.Enabled,
+			},
 		},
 	}
 
@@ -0,0 +1,130 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+
+	appserviceAPI "github.com/jchv/maidtrix/appservice/api"
+	"github.com/jchv/maidtrix/clientapi/httputil"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/setup/config"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+	"github.com/sirupsen/logrus"
+)
+
+type consentResponse struct {
+	Version         string                 `json:"version"`
+	Policies        map[string]interface{} `json:"policies"`
+	AcceptedVersion string                 `json:"accepted_version,omitempty"`
+	ConsentRequired bool                   `json:"consent_required"`
+}
+
+type consentRequest struct {
+	Version string `json:"version"`
+}
+
+// GetConsent implements GET /consent, which returns the current policy
+// documents and the version of them the user accepted, if any.
+func GetConsent(req *http.Request, device *userapi.Device, cfg *config.UserConsent, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	var res userapi.QueryUserConsentResponse
+	if err := userAPI.QueryUserConsent(req.Context(), &userapi.QueryUserConsentRequest{
+		UserID: device.UserID,
+	}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryUserConsent failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: consentResponse{
+			Version:         cfg.Version,
+			Policies:        cfg.TermsParams()["policies"].(map[string]interface{}),
+			AcceptedVersion: res.ConsentVersion,
+			ConsentRequired: res.ConsentRequired,
+		},
+	}
+}
+
+// PostConsent implements POST /consent, which records that the user accepted
+// the current version of the policy documents.
+func PostConsent(req *http.Request, device *userapi.Device, cfg *config.UserConsent, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	var r consentRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if r.Version != cfg.Version {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Only the current version of the policy documents can be accepted"),
+		}
+	}
+	if err := userAPI.PerformUserConsent(req.Context(), &userapi.PerformUserConsentRequest{
+		UserID:  device.UserID,
+		Version: r.Version,
+	}, &struct{}{}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformUserConsent failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
+
+// sendConsentNotices sends a server notice to every user who hasn't accepted
+// the current version of the policy documents, unless they were sent one for
+// this version already.
+func sendConsentNotices(
+	ctx context.Context,
+	cfgConsent *config.UserConsent,
+	cfgClient *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) {
+	var res userapi.QueryConsentNoticeUsersResponse
+	if err := userAPI.QueryConsentNoticeUsers(ctx, &struct{}{}, &res); err != nil {
+		logrus.WithError(err).Error("Failed to get users to send consent notices to")
+		return
+	}
+	for _, userID := range res.UserIDs {
+		r := sendServerNoticeRequest{UserID: userID}
+		r.Content.MsgType = "m.text"
+		r.Content.Body = cfgConsent.ServerNoticeMessage
+		noticeRes := sendServerNotice(
+			ctx, r, &cfgClient.Matrix.ServerNotices, cfgClient, userAPI, rsAPI, asAPI,
+			senderDevice, cfgClient.Matrix.ServerName, nil,
+		)
+		if noticeRes.Code != http.StatusOK {
+			logrus.WithField("user_id", userID).Warnf("Failed to send consent notice: %+v", noticeRes.JSON)
+			continue
+		}
+		if err := userAPI.PerformConsentNoticeSent(ctx, &userapi.PerformConsentNoticeSentRequest{
+			UserID: userID,
+		}, &struct{}{}); err != nil {
+			logrus.WithError(err).WithField("user_id", userID).Error("Failed to record consent notice")
+		}
+	}
+}
@@ -24,6 +24,7 @@ import (
 
 	appserviceAPI "github.com/jchv/maidtrix/appservice/api"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	roomserverAPI "github.com/jchv/maidtrix/roomserver/api"
 	roomserverVersion "github.com/jchv/maidtrix/roomserver/version"
 	"github.com/jchv/maidtrix/userapi/api"
@@ -113,6 +114,7 @@ func CreateRoom(
 	cfg *config.ClientAPI,
 	profileAPI api.ClientUserAPI, rsAPI roomserverAPI.ClientRoomserverAPI,
 	asAPI appserviceAPI.AppServiceInternalAPI,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	var createRequest createRoomRequest
 	resErr := httputil.UnmarshalJSONRequest(req, &createRequest)
@@ -129,6 +131,9 @@ func CreateRoom(
 			JSON: spec.InvalidParam(err.Error()),
 		}
 	}
+	if res := spamChecker.UserMayCreateRoom(req.Context(), device.UserID, createRequest); !res.Allowed() {
+		return res.Forbidden()
+	}
 	return createRoom(req.Context(), createRequest, device, cfg, profileAPI, rsAPI, asAPI, evTime)
 }
 
@@ -225,10 +230,11 @@ func createRoom(
 		EventTime:       evTime,
 	}
 
-	roomAlias, createRes := rsAPI.PerformCreateRoom(ctx, *userID, *roomID, &req)
+	roomAlias, createdRoomID, createRes := rsAPI.PerformCreateRoom(ctx, *userID, *roomID, &req)
 	if createRes != nil {
 		return *createRes
 	}
+	roomID = createdRoomID
 
 	response := createRoomResponse{
 		RoomID:    roomID.String(),
@@ -0,0 +1,162 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jchv/maidtrix/clientapi/httputil"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/userapi/api"
+)
+
+type putDehydratedDeviceRequest struct {
+	DeviceID     string                     `json:"device_id"`
+	DeviceData   json.RawMessage            `json:"device_data"`
+	DisplayName  *string                    `json:"initial_device_display_name"`
+	DeviceKeys   json.RawMessage            `json:"device_keys"`
+	OneTimeKeys  map[string]json.RawMessage `json:"one_time_keys"`
+	FallbackKeys map[string]json.RawMessage `json:"fallback_keys"`
+}
+
+type dehydratedDeviceResponse struct {
+	DeviceID   string          `json:"device_id"`
+	DeviceData json.RawMessage `json:"device_data,omitempty"`
+}
+
+// PutDehydratedDevice implements PUT /dehydrated_device, as per MSC3814.
+// It replaces any existing dehydrated device for the user.
+func PutDehydratedDevice(req *http.Request, userAPI api.ClientUserAPI, device *api.Device) util.JSONResponse {
+	var r putDehydratedDeviceRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if r.DeviceID == "" || len(r.DeviceData) == 0 {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.BadJSON("device_id and device_data must be supplied"),
+		}
+	}
+
+	createReq := &api.PerformDehydratedDeviceCreationRequest{
+		UserID:      device.UserID,
+		DeviceID:    r.DeviceID,
+		DeviceData:  r.DeviceData,
+		DisplayName: r.DisplayName,
+	}
+	if r.DeviceKeys != nil {
+		createReq.DeviceKeys = &api.DeviceKeys{
+			UserID:   device.UserID,
+			DeviceID: r.DeviceID,
+			KeyJSON:  r.DeviceKeys,
+		}
+	}
+	if r.OneTimeKeys != nil {
+		createReq.OneTimeKeys = &api.OneTimeKeys{
+			UserID:   device.UserID,
+			DeviceID: r.DeviceID,
+			KeyJSON:  r.OneTimeKeys,
+		}
+	}
+	if r.FallbackKeys != nil {
+		createReq.FallbackKeys = &api.OneTimeKeys{
+			UserID:   device.UserID,
+			DeviceID: r.DeviceID,
+			KeyJSON:  r.FallbackKeys,
+		}
+	}
+	var createRes api.PerformDehydratedDeviceCreationResponse
+	if err := userAPI.PerformDehydratedDeviceCreation(req.Context(), createReq, &createRes); err != nil {
+		if _, ok := err.(*api.ErrorConflict); ok {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("device_id is already in use by another device"),
+			}
+		}
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformDehydratedDeviceCreation failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: dehydratedDeviceResponse{DeviceID: createRes.DeviceID},
+	}
+}
+
+// GetDehydratedDevice implements GET /dehydrated_device, as per MSC3814.
+func GetDehydratedDevice(req *http.Request, userAPI api.ClientUserAPI, device *api.Device) util.JSONResponse {
+	var queryRes api.QueryDehydratedDeviceResponse
+	if err := userAPI.QueryDehydratedDevice(req.Context(), &api.QueryDehydratedDeviceRequest{
+		UserID: device.UserID,
+	}, &queryRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryDehydratedDevice failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !queryRes.Exists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("No dehydrated device"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: dehydratedDeviceResponse{
+			DeviceID:   queryRes.DeviceID,
+			DeviceData: queryRes.DeviceData,
+		},
+	}
+}
+
+// DeleteDehydratedDevice implements DELETE /dehydrated_device, as per MSC3814.
+// The dehydrated device is deleted like any other device.
+func DeleteDehydratedDevice(req *http.Request, userAPI api.ClientUserAPI, device *api.Device) util.JSONResponse {
+	var queryRes api.QueryDehydratedDeviceResponse
+	if err := userAPI.QueryDehydratedDevice(req.Context(), &api.QueryDehydratedDeviceRequest{
+		UserID: device.UserID,
+	}, &queryRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryDehydratedDevice failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !queryRes.Exists {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("No dehydrated device"),
+		}
+	}
+	if err := userAPI.PerformDeviceDeletion(req.Context(), &api.PerformDeviceDeletionRequest{
+		UserID:    device.UserID,
+		DeviceIDs: []string{queryRes.DeviceID},
+	}, &api.PerformDeviceDeletionResponse{}); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformDeviceDeletion failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: dehydratedDeviceResponse{DeviceID: queryRes.DeviceID},
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jchv/maidtrix/clientapi/httputil"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/jchv/maidtrix/setup/config"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+	"github.com/sirupsen/logrus"
+)
+
+// delayQueryParam is the query parameter on the send and state endpoints
+// which asks for the event to be delayed, in milliseconds.
+const delayQueryParam = "org.matrix.msc4140.delay"
+
+type delayedEventResponse struct {
+	DelayID string `json:"delay_id"`
+}
+
+type maxDelayExceededError struct {
+	spec.MatrixError
+	MaxDelay int64 `json:"org.matrix.msc4140.max_delay"`
+}
+
+// DelayedEvents sends the MSC4140 delayed events of local users once their
+// delay has elapsed. Pending delayed events are stored by the user API, so
+// that they are rescheduled when the server restarts.
+type DelayedEvents struct {
+	cfg     *config.DelayedEvents
+	rsAPI   api.ClientRoomserverAPI
+	userAPI userapi.ClientUserAPI
+	mu      sync.Mutex
+	timers  map[string]*time.Timer // delay ID -> timer
+}
+
+func NewDelayedEvents(
+	cfg *config.ClientAPI,
+	rsAPI api.ClientRoomserverAPI,
+	userAPI userapi.ClientUserAPI,
+) *DelayedEvents {
+	return &DelayedEvents{
+		cfg:     &cfg.DelayedEvents,
+		rsAPI:   rsAPI,
+		userAPI: userAPI,
+		timers:  make(map[string]*time.Timer),
+	}
+}
+
+// Start schedules all of the delayed events which are pending in the
+// database. Events which became due while the server was down are sent
+// straight away.
+func (d *DelayedEvents) Start(ctx context.Context) error {
+	var res userapi.QueryDelayedEventsResponse
+	if err := d.userAPI.QueryDelayedEvents(ctx, &userapi.QueryDelayedEventsRequest{}, &res); err != nil {
+		return err
+	}
+	for i := range res.DelayedEvents {
+		d.schedule(&res.DelayedEvents[i])
+	}
+	return nil
+}
+
+// parseDelay returns the delay asked for by the request, or zero if the
+// event should be sent straight away.
+func (d *DelayedEvents) parseDelay(req *http.Request) (time.Duration, *util.JSONResponse) {
+	param := req.URL.Query().Get(delayQueryParam)
+	if param == "" {
+		return 0, nil
+	}
+	if d == nil {
+		return 0, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Delayed events are not enabled on this server"),
+		}
+	}
+	delayMS, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || delayMS <= 0 {
+		return 0, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(delayQueryParam + " must be a positive integer"),
+		}
+	}
+	if maxDelayMS := d.cfg.MaxDelay.Milliseconds(); delayMS > maxDelayMS {
+		return 0, &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: maxDelayExceededError{
+				MatrixError: spec.MatrixError{
+					ErrCode: "M_MAX_DELAY_EXCEEDED",
+					Err:     "The requested delay exceeds the allowed maximum",
+				},
+				MaxDelay: maxDelayMS,
+			},
+		}
+	}
+	return time.Duration(delayMS) * time.Millisecond, nil
+}
+
+// Create stores a new delayed event and schedules it to be sent.
+func (d *DelayedEvents) Create(
+	ctx context.Context, device *userapi.Device,
+	roomID, eventType string, stateKey *string,
+	content map[string]interface{}, delay time.Duration,
+) (string, error) {
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	var res userapi.PerformDelayedEventCreationResponse
+	if err = d.userAPI.PerformDelayedEventCreation(ctx, &userapi.PerformDelayedEventCreationRequest{
+		Event: userapi.DelayedEvent{
+			UserID:    device.UserID,
+			RoomID:    roomID,
+			EventType: eventType,
+			StateKey:  stateKey,
+			Delay:     delay.Milliseconds(),
+			Content:   rawContent,
+		},
+	}, &res); err != nil {
+		return "", err
+	}
+	d.schedule(&res.Event)
+	return res.Event.DelayID, nil
+}
+
+// CancelStateEvents cancels the delayed state events which would overwrite
+// the given state, because it was just set by the user.
+func (d *DelayedEvents) CancelStateEvents(ctx context.Context, roomID, eventType, stateKey string) {
+	var res userapi.PerformDelayedEventDeletionResponse
+	if err := d.userAPI.PerformDelayedEventDeletion(ctx, &userapi.PerformDelayedEventDeletionRequest{
+		RoomID:    roomID,
+		EventType: eventType,
+		StateKey:  &stateKey,
+	}, &res); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("failed to cancel delayed state events")
+		return
+	}
+	for _, delayID := range res.DelayIDs {
+		d.unschedule(delayID)
+	}
+}
+
+func (d *DelayedEvents) schedule(event *userapi.DelayedEvent) {
+	delayID := event.DelayID
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if timer, ok := d.timers[delayID]; ok {
+		timer.Stop()
+	}
+	d.timers[delayID] = time.AfterFunc(time.Until(event.SendAt()), func() {
+		d.fire(delayID)
+	})
+}
+
+func (d *DelayedEvents) unschedule(delayID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if timer, ok := d.timers[delayID]; ok {
+		timer.Stop()
+		delete(d.timers, delayID)
+	}
+}
+
+// fire sends the delayed event if it is still pending. The event is read
+// again from the database, as it may have been restarted or cancelled since
+// the timer was set.
+func (d *DelayedEvents) fire(delayID string) {
+	ctx := context.Background()
+	logger := logrus.WithField("delay_id", delayID)
+	var res userapi.QueryDelayedEventsResponse
+	if err := d.userAPI.QueryDelayedEvents(ctx, &userapi.QueryDelayedEventsRequest{DelayID: delayID}, &res); err != nil {
+		logger.WithError(err).Error("failed to query delayed event")
+		return
+	}
+	if len(res.DelayedEvents) == 0 {
+		d.unschedule(delayID)
+		return
+	}
+	event := &res.DelayedEvents[0]
+	if time.Now().Before(event.SendAt()) {
+		d.schedule(event)
+		return
+	}
+	d.unschedule(delayID)
+	if resErr := d.send(ctx, event); resErr != nil {
+		logger.WithField("error", resErr.JSON).Warn("failed to send delayed event")
+	}
+}
+
+// send sends the delayed event to the roomserver now, and removes it from
+// the database whether or not it could be sent.
+func (d *DelayedEvents) send(ctx context.Context, event *userapi.DelayedEvent) *util.JSONResponse {
+	defer func() {
+		if err := d.userAPI.PerformDelayedEventDeletion(ctx, &userapi.PerformDelayedEventDeletionRequest{
+			DelayID: event.DelayID,
+		}, &userapi.PerformDelayedEventDeletionResponse{}); err != nil {
+			util.GetLogger(ctx).WithError(err).Error("failed to delete delayed event")
+		}
+	}()
+
+	var content map[string]interface{}
+	if err := json.Unmarshal(event.Content, &content); err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	mutex, _ := userRoomSendMutexes.LoadOrStore(event.RoomID+event.UserID, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	defer mutex.(*sync.Mutex).Unlock()
+
+	device := &userapi.Device{UserID: event.UserID}
+	e, resErr := generateSendEvent(ctx, content, device, event.RoomID, event.EventType, event.StateKey, d.rsAPI, time.Now())
+	if resErr != nil {
+		return resErr
+	}
+	domain := device.UserDomain()
+	if err := api.SendEvents(
+		ctx, d.rsAPI,
+		api.KindNew,
+		[]*types.HeaderedEvent{
+			{PDU: e},
+		},
+		domain,
+		domain,
+		domain,
+		nil,
+		false,
+	); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("SendEvents failed")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return nil
+}
+
+// GetDelayedEvents implements GET /org.matrix.msc4140/delayed_events
+func GetDelayedEvents(req *http.Request, device *userapi.Device, userAPI userapi.ClientUserAPI) util.JSONResponse {
+	var res userapi.QueryDelayedEventsResponse
+	if err := userAPI.QueryDelayedEvents(req.Context(), &userapi.QueryDelayedEventsRequest{
+		UserID: device.UserID,
+	}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryDelayedEvents failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"delayed_events": res.DelayedEvents,
+		},
+	}
+}
+
+// UpdateDelayedEvent implements POST /org.matrix.msc4140/delayed_events/{delayID},
+// which restarts, cancels or sends a delayed event early.
+func UpdateDelayedEvent(
+	req *http.Request, device *userapi.Device,
+	userAPI userapi.ClientUserAPI, delayedEvents *DelayedEvents,
+	delayID string,
+) util.JSONResponse {
+	var body struct {
+		Action string `json:"action"`
+	}
+	if resErr := httputil.UnmarshalJSONRequest(req, &body); resErr != nil {
+		return *resErr
+	}
+	switch body.Action {
+	case "restart", "cancel", "send":
+	case "":
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.MissingParam("Missing action"),
+		}
+	default:
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("Unknown action " + strconv.Quote(body.Action)),
+		}
+	}
+
+	var queryRes userapi.QueryDelayedEventsResponse
+	if err := userAPI.QueryDelayedEvents(req.Context(), &userapi.QueryDelayedEventsRequest{
+		DelayID: delayID,
+		UserID:  device.UserID,
+	}, &queryRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryDelayedEvents failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if len(queryRes.DelayedEvents) == 0 {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("Unknown delayed event"),
+		}
+	}
+	event := &queryRes.DelayedEvents[0]
+
+	switch body.Action {
+	case "restart":
+		var res userapi.PerformDelayedEventRestartResponse
+		err := userAPI.PerformDelayedEventRestart(req.Context(), &userapi.PerformDelayedEventRestartRequest{
+			DelayID: delayID,
+		}, &res)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: spec.NotFound("Unknown delayed event"),
+			}
+		case err != nil:
+			util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformDelayedEventRestart failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		delayedEvents.schedule(&res.Event)
+	case "cancel":
+		if err := userAPI.PerformDelayedEventDeletion(req.Context(), &userapi.PerformDelayedEventDeletionRequest{
+			DelayID: delayID,
+		}, &userapi.PerformDelayedEventDeletionResponse{}); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformDelayedEventDeletion failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		delayedEvents.unschedule(delayID)
+	case "send":
+		delayedEvents.unschedule(delayID)
+		if resErr := delayedEvents.send(req.Context(), event); resErr != nil {
+			return *resErr
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: struct{}{},
+	}
+}
@@ -0,0 +1,51 @@
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/setup/config"
+)
+
+func TestParseDelay(t *testing.T) {
+	cfg := &config.ClientAPI{}
+	cfg.DelayedEvents.Enabled = true
+	cfg.DelayedEvents.MaxDelay = time.Minute
+	delayedEvents := NewDelayedEvents(cfg, nil, nil)
+
+	testCases := []struct {
+		name          string
+		delayedEvents *DelayedEvents
+		query         string
+		wantDelay     time.Duration
+		wantCode      int
+	}{
+		{name: "no delay", delayedEvents: delayedEvents},
+		{name: "no delay when disabled"},
+		{name: "delay", delayedEvents: delayedEvents, query: "?org.matrix.msc4140.delay=1500", wantDelay: 1500 * time.Millisecond},
+		{name: "delay when disabled", query: "?org.matrix.msc4140.delay=1500", wantCode: http.StatusBadRequest},
+		{name: "invalid delay", delayedEvents: delayedEvents, query: "?org.matrix.msc4140.delay=soon", wantCode: http.StatusBadRequest},
+		{name: "negative delay", delayedEvents: delayedEvents, query: "?org.matrix.msc4140.delay=-5", wantCode: http.StatusBadRequest},
+		{name: "delay too long", delayedEvents: delayedEvents, query: "?org.matrix.msc4140.delay=60001", wantCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/rooms/!room:test/send/m.room.message/1"+tc.query, nil)
+			delay, resErr := tc.delayedEvents.parseDelay(req)
+			if tc.wantCode != 0 {
+				if resErr == nil || resErr.Code != tc.wantCode {
+					t.Fatalf("expected error code %d, got %+v", tc.wantCode, resErr)
+				}
+				return
+			}
+			if resErr != nil {
+				t.Fatalf("unexpected error: %+v", resErr)
+			}
+			if delay != tc.wantDelay {
+				t.Fatalf("expected delay %s, got %s", tc.wantDelay, delay)
+			}
+		})
+	}
+}
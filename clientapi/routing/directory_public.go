@@ -18,6 +18,7 @@ import (
 	"context"
 	"math/rand"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -48,8 +49,10 @@ type PublicRoomReq struct {
 }
 
 type filter struct {
-	SearchTerms string   `json:"generic_search_term,omitempty"`
-	RoomTypes   []string `json:"room_types,omitempty"` // TODO: Implement filter on this
+	SearchTerms string `json:"generic_search_term,omitempty"`
+	// A null entry in room_types decodes to an empty string, which matches
+	// rooms without a type.
+	RoomTypes []string `json:"room_types,omitempty"`
 }
 
 // GetPostPublicRooms implements GET and POST /publicRooms
@@ -76,7 +79,7 @@ func GetPostPublicRooms(
 		res, err := federation.GetPublicRoomsFiltered(
 			req.Context(), cfg.Matrix.ServerName, serverName,
 			int(request.Limit), request.Since,
-			request.Filter.SearchTerms, false,
+			request.Filter.SearchTerms, request.Filter.RoomTypes, false,
 			"",
 		)
 		if err != nil {
@@ -135,10 +138,9 @@ func publicRooms(
 		rooms = getPublicRoomsFromCache()
 	}
 
+	rooms = filterRooms(rooms, request.Filter.SearchTerms, request.Filter.RoomTypes)
 	response.TotalRoomCountEstimate = len(rooms)
 
-	rooms = filterRooms(rooms, request.Filter.SearchTerms)
-
 	chunk, prev, next := sliceInto(rooms, offset, limit)
 	if prev >= 0 {
 		response.PrevBatch = "T" + strconv.Itoa(prev)
@@ -152,8 +154,8 @@ func publicRooms(
 	return &response, err
 }
 
-func filterRooms(rooms []fclient.PublicRoom, searchTerm string) []fclient.PublicRoom {
-	if searchTerm == "" {
+func filterRooms(rooms []fclient.PublicRoom, searchTerm string, roomTypes []string) []fclient.PublicRoom {
+	if searchTerm == "" && len(roomTypes) == 0 {
 		return rooms
 	}
 
@@ -161,6 +163,9 @@ func filterRooms(rooms []fclient.PublicRoom, searchTerm string) []fclient.Public
 
 	result := make([]fclient.PublicRoom, 0)
 	for _, room := range rooms {
+		if len(roomTypes) > 0 && !slices.Contains(roomTypes, room.RoomType) {
+			continue
+		}
 		if strings.Contains(strings.ToLower(room.Name), normalizedTerm) ||
 			strings.Contains(strings.ToLower(room.Topic), normalizedTerm) ||
 			strings.Contains(strings.ToLower(room.CanonicalAlias), normalizedTerm) {
@@ -275,6 +280,9 @@ func refreshPublicRoomCache(
 		util.GetLogger(ctx).WithError(err).Error("PopulatePublicRooms failed")
 		return publicRoomsCache
 	}
+	for i := range pubRooms {
+		pubRooms[i].RoomType = queryRes.RoomTypes[pubRooms[i].RoomID]
+	}
 	publicRoomsCache = []fclient.PublicRoom{}
 	publicRoomsCache = append(publicRoomsCache, pubRooms...)
 	publicRoomsCache = append(publicRoomsCache, extraRooms...)
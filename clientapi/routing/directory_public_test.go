@@ -56,3 +56,32 @@ func TestSliceInto(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterRooms(t *testing.T) {
+	space := fclient.PublicRoom{RoomID: "!space:test", Name: "Space", RoomType: "m.space"}
+	plain := fclient.PublicRoom{RoomID: "!plain:test", Name: "Plain", Topic: "About spaces"}
+	other := fclient.PublicRoom{RoomID: "!other:test", Name: "Other", CanonicalAlias: "#other:test"}
+	rooms := []fclient.PublicRoom{space, plain, other}
+
+	testCases := []struct {
+		name       string
+		searchTerm string
+		roomTypes  []string
+		want       []fclient.PublicRoom
+	}{
+		{name: "no filter", want: rooms},
+		{name: "spaces only", roomTypes: []string{"m.space"}, want: []fclient.PublicRoom{space}},
+		{name: "plain rooms only", roomTypes: []string{""}, want: []fclient.PublicRoom{plain, other}},
+		{name: "search matches name and topic", searchTerm: "SPACE", want: []fclient.PublicRoom{space, plain}},
+		{name: "search matches alias", searchTerm: "#other", want: []fclient.PublicRoom{other}},
+		{name: "search and room type", searchTerm: "space", roomTypes: []string{""}, want: []fclient.PublicRoom{plain}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := filterRooms(rooms, tc.searchTerm, tc.roomTypes)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("returned rooms are wrong, got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -27,8 +27,11 @@ import (
 )
 
 type uploadKeysRequest struct {
-	DeviceKeys  json.RawMessage            `json:"device_keys"`
-	OneTimeKeys map[string]json.RawMessage `json:"one_time_keys"`
+	DeviceKeys   json.RawMessage            `json:"device_keys"`
+	OneTimeKeys  map[string]json.RawMessage `json:"one_time_keys"`
+	FallbackKeys map[string]json.RawMessage `json:"fallback_keys"`
+	// Some clients still use the unstable prefix from MSC2732.
+	UnstableFallbackKeys map[string]json.RawMessage `json:"org.matrix.msc2732.fallback_keys"`
 }
 
 func UploadKeys(req *http.Request, keyAPI api.ClientKeyAPI, device *api.Device) util.JSONResponse {
@@ -61,6 +64,19 @@ func UploadKeys(req *http.Request, keyAPI api.ClientKeyAPI, device *api.Device)
 		}
 	}
 
+	if r.FallbackKeys == nil {
+		r.FallbackKeys = r.UnstableFallbackKeys
+	}
+	if r.FallbackKeys != nil {
+		uploadReq.FallbackKeys = []api.OneTimeKeys{
+			{
+				DeviceID: device.ID,
+				UserID:   device.UserID,
+				KeyJSON:  r.FallbackKeys,
+			},
+		}
+	}
+
 	var uploadRes api.PerformUploadKeysResponse
 	if err := keyAPI.PerformUploadKeys(req.Context(), uploadReq, &uploadRes); err != nil {
 		return util.ErrorResponse(err)
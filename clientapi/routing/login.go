@@ -39,6 +39,9 @@ type flows struct {
 
 type flow struct {
 	Type string `json:"type"`
+	// GetLoginToken is set on the m.login.token flow if users can get
+	// a login token from an existing session with /login/get_token.
+	GetLoginToken bool `json:"get_login_token,omitempty"`
 }
 
 // Login implements GET and POST /login
@@ -51,6 +54,9 @@ func Login(
 		if len(cfg.Derived.ApplicationServices) > 0 {
 			loginFlows = append(loginFlows, flow{Type: authtypes.LoginTypeApplicationService})
 		}
+		if cfg.LoginViaExistingSession.Enabled {
+			loginFlows = append(loginFlows, flow{Type: authtypes.LoginTypeToken, GetLoginToken: true})
+		}
 		// TODO: support other forms of login, depending on config options
 		return util.JSONResponse{
 			Code: http.StatusOK,
@@ -52,7 +52,7 @@ func TestLogin(t *testing.T) {
 		userAPI := userapi.NewInternalAPI(processCtx, cfg, cm, &natsInstance, rsAPI, nil, caching.DisableMetrics, testIsBlacklistedOrBackingOff)
 
 		// We mostly need the userAPI for this test, so nil for other APIs/caches etc.
-		Setup(routers, cfg, nil, nil, userAPI, nil, nil, nil, nil, nil, nil, nil, caching.DisableMetrics)
+		Setup(processCtx, routers, cfg, nil, nil, userAPI, nil, nil, nil, nil, nil, nil, nil, caching.DisableMetrics)
 
 		// Create password
 		password := util.RandomString(8)
@@ -196,3 +196,98 @@ func TestLogin(t *testing.T) {
 		}
 	})
 }
+
+func TestLoginViaExistingSession(t *testing.T) {
+	alice := test.NewUser(t)
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+		cfg.ClientAPI.RateLimiting.Enabled = false
+		cfg.ClientAPI.LoginViaExistingSession.Enabled = true
+		natsInstance := jetstream.NATSInstance{}
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		routers := httputil.NewRouters()
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+		userAPI := userapi.NewInternalAPI(processCtx, cfg, cm, &natsInstance, rsAPI, nil, caching.DisableMetrics, testIsBlacklistedOrBackingOff)
+		Setup(processCtx, routers, cfg, nil, nil, userAPI, nil, nil, nil, nil, nil, nil, nil, caching.DisableMetrics)
+
+		password := util.RandomString(8)
+		localpart, serverName, _ := gomatrixserverlib.SplitID('@', alice.ID)
+		if err := userAPI.PerformAccountCreation(ctx, &uapi.PerformAccountCreationRequest{
+			AccountType: alice.AccountType,
+			Localpart:   localpart,
+			ServerName:  serverName,
+			Password:    password,
+		}, &uapi.PerformAccountCreationResponse{}); err != nil {
+			t.Fatalf("failed to create account: %s", err)
+		}
+
+		login := func(t *testing.T, body map[string]interface{}) loginResponse {
+			req := test.NewRequest(t, http.MethodPost, "/_matrix/client/v3/login", test.WithJSONBody(t, body))
+			rec := httptest.NewRecorder()
+			routers.Client.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("failed to login: %s", rec.Body.String())
+			}
+			resp := loginResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			return resp
+		}
+		existing := login(t, map[string]interface{}{
+			"type": authtypes.LoginTypePassword,
+			"identifier": map[string]interface{}{
+				"type": "m.id.user",
+				"user": alice.ID,
+			},
+			"password": password,
+		})
+
+		// Without authenticating again, the user is asked to
+		req := test.NewRequest(t, http.MethodPost, "/_matrix/client/v1/login/get_token", test.WithJSONBody(t, map[string]interface{}{}))
+		req.Header.Set("Authorization", "Bearer "+existing.AccessToken)
+		rec := httptest.NewRecorder()
+		routers.Client.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected UIA challenge, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		req = test.NewRequest(t, http.MethodPost, "/_matrix/client/v1/login/get_token", test.WithJSONBody(t, map[string]interface{}{
+			"auth": map[string]interface{}{
+				"type": authtypes.LoginTypePassword,
+				"identifier": map[string]interface{}{
+					"type": "m.id.user",
+					"user": alice.ID,
+				},
+				"password": password,
+			},
+		}))
+		req.Header.Set("Authorization", "Bearer "+existing.AccessToken)
+		rec = httptest.NewRecorder()
+		routers.Client.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("failed to get login token: %s", rec.Body.String())
+		}
+		tokenResp := getLoginTokenResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tokenResp); err != nil {
+			t.Fatal(err)
+		}
+		if tokenResp.ExpiresInMS <= 0 || tokenResp.ExpiresInMS > uapi.DefaultLoginTokenLifetime.Milliseconds() {
+			t.Fatalf("unexpected token expiry: %d", tokenResp.ExpiresInMS)
+		}
+
+		// The token can be used to sign in a new device
+		newDevice := login(t, map[string]interface{}{
+			"type":  authtypes.LoginTypeToken,
+			"token": tokenResp.LoginToken,
+		})
+		if newDevice.UserID != alice.ID || newDevice.DeviceID == existing.DeviceID {
+			t.Fatalf("unexpected login response: %+v", newDevice)
+		}
+	})
+}
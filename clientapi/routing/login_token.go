@@ -0,0 +1,89 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jchv/maidtrix/clientapi/auth"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/userapi/api"
+)
+
+type getLoginTokenResponse struct {
+	LoginToken  string `json:"login_token"`
+	ExpiresInMS int64  `json:"expires_in_ms"`
+}
+
+// GetLoginToken implements POST /login/get_token. It issues a short-lived
+// m.login.token token with which the user can sign in a new device.
+func GetLoginToken(
+	req *http.Request,
+	userInteractiveAuth *auth.UserInteractive,
+	userAPI api.ClientUserAPI,
+	device *api.Device,
+	cfg *config.ClientAPI,
+) util.JSONResponse {
+	if !cfg.LoginViaExistingSession.Enabled {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.Unrecognized("Login via an existing session is not enabled"),
+		}
+	}
+
+	if cfg.LoginViaExistingSession.RequireUIAuth {
+		defer req.Body.Close() // nolint:errcheck
+		bodyBytes, err := io.ReadAll(req.Body)
+		if err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.BadJSON("The request body could not be read: " + err.Error()),
+			}
+		}
+		login, errRes := userInteractiveAuth.Verify(req.Context(), bodyBytes, device)
+		if errRes != nil {
+			return *errRes
+		}
+		if login.Username() != device.UserID {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: spec.Forbidden("Authenticated as a different user"),
+			}
+		}
+	}
+
+	var res api.PerformLoginTokenCreationResponse
+	if err := userAPI.PerformLoginTokenCreation(req.Context(), &api.PerformLoginTokenCreationRequest{
+		Data: api.LoginTokenData{UserID: device.UserID},
+	}, &res); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformLoginTokenCreation failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: getLoginTokenResponse{
+			LoginToken:  res.Metadata.Token,
+			ExpiresInMS: time.Until(res.Metadata.Expiration).Milliseconds(),
+		},
+	}
+}
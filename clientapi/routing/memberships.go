@@ -15,8 +15,10 @@
 package routing
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/util"
@@ -96,6 +98,10 @@ func GetJoinedMembers(
 		}
 	}
 
+	if resErr := waitForFullState(req.Context(), rsAPI, *validRoomID); resErr != nil {
+		return *resErr
+	}
+
 	// Get the current membership events
 	var membershipsForRoomResp api.QueryMembershipsForRoomResponse
 	if err = rsAPI.QueryMembershipsForRoom(req.Context(), &api.QueryMembershipsForRoomRequest{
@@ -137,3 +143,26 @@ func GetJoinedMembers(
 		JSON: res,
 	}
 }
+
+// waitForFullState gives the roomserver a chance to finish fetching the full
+// state of a room that was joined with partial state, since the memberships
+// in the room aren't known until then. Returns an error response if the room
+// still has partial state after waiting.
+func waitForFullState(ctx context.Context, rsAPI api.ClientRoomserverAPI, roomID spec.RoomID) *util.JSONResponse {
+	fullState, err := api.WaitForFullState(ctx, rsAPI, roomID, api.PartialStateWaitTimeout)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).Error("api.WaitForFullState failed")
+		return &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !fullState {
+		return &util.JSONResponse{
+			Code:    http.StatusServiceUnavailable,
+			JSON:    spec.LimitExceeded("The state of this room is still being fetched, try again later", api.PartialStateRetryAfter.Milliseconds()),
+			Headers: map[string]string{"Retry-After": strconv.Itoa(int(api.PartialStateRetryAfter.Seconds()))},
+		}
+	}
+	return nil
+}
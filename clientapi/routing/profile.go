@@ -29,6 +29,7 @@ import (
 	"github.com/jchv/maidtrix/clientapi/httputil"
 	"github.com/jchv/maidtrix/internal/eventutil"
 	"github.com/jchv/maidtrix/internal/matrix"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/roomserver/types"
@@ -93,6 +94,7 @@ func GetAvatarURL(
 func SetAvatarURL(
 	req *http.Request, profileAPI userapi.ProfileAPI,
 	device *userapi.Device, userID string, cfg *config.ClientAPI, rsAPI api.ClientRoomserverAPI,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	if userID != device.UserID {
 		return util.JSONResponse{
@@ -130,6 +132,10 @@ func SetAvatarURL(
 		}
 	}
 
+	if res := spamChecker.UserMayChangeProfile(req.Context(), userID, "avatar_url", r.AvatarURL); !res.Allowed() {
+		return res.Forbidden()
+	}
+
 	profile, changed, err := profileAPI.SetAvatarURL(req.Context(), localpart, domain, r.AvatarURL)
 	if err != nil {
 		util.GetLogger(req.Context()).WithError(err).Error("profileAPI.SetAvatarURL failed")
@@ -182,6 +188,7 @@ func GetDisplayName(
 func SetDisplayName(
 	req *http.Request, profileAPI userapi.ProfileAPI,
 	device *userapi.Device, userID string, cfg *config.ClientAPI, rsAPI api.ClientRoomserverAPI,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	if userID != device.UserID {
 		return util.JSONResponse{
@@ -219,6 +226,10 @@ func SetDisplayName(
 		}
 	}
 
+	if res := spamChecker.UserMayChangeProfile(req.Context(), userID, "displayname", r.DisplayName); !res.Allowed() {
+		return res.Forbidden()
+	}
+
 	profile, changed, err := profileAPI.SetDisplayName(req.Context(), localpart, domain, r.DisplayName)
 	if err != nil {
 		util.GetLogger(req.Context()).WithError(err).Error("profileAPI.SetDisplayName failed")
@@ -18,9 +18,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/jchv/maidtrix/clientapi/httputil"
 	"github.com/jchv/maidtrix/clientapi/producers"
+	"github.com/jchv/maidtrix/internal/eventutil"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 
 	"github.com/jchv/maidtrix/internal/util"
@@ -28,19 +31,51 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+type receiptRequest struct {
+	ThreadID string `json:"thread_id,omitempty"`
+}
+
+// SetReceipt implements POST /rooms/{roomId}/receipt/{receiptType}/{eventId}
 func SetReceipt(req *http.Request, userAPI userapi.ClientUserAPI, syncProducer *producers.SyncAPIProducer, device *userapi.Device, roomID, receiptType, eventID string) util.JSONResponse {
+	// Older clients don't send a body at all, so only look for a thread ID
+	// if there is one.
+	var r receiptRequest
+	if req.ContentLength != 0 {
+		if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+			return *resErr
+		}
+	}
+	if r.ThreadID != "" {
+		if receiptType == "m.fully_read" {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("thread_id is not supported for m.fully_read"),
+			}
+		}
+		if r.ThreadID != eventutil.MainThreadID && !strings.HasPrefix(r.ThreadID, "$") {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("thread_id must be \"main\" or an event ID"),
+			}
+		}
+	}
+	return setReceipt(req, userAPI, syncProducer, device, roomID, receiptType, eventID, r.ThreadID)
+}
+
+func setReceipt(req *http.Request, userAPI userapi.ClientUserAPI, syncProducer *producers.SyncAPIProducer, device *userapi.Device, roomID, receiptType, eventID, threadID string) util.JSONResponse {
 	timestamp := spec.AsTimestamp(time.Now())
 	logrus.WithFields(logrus.Fields{
 		"roomID":      roomID,
 		"receiptType": receiptType,
 		"eventID":     eventID,
+		"threadID":    threadID,
 		"userId":      device.UserID,
 		"timestamp":   timestamp,
 	}).Debug("Setting receipt")
 
 	switch receiptType {
 	case "m.read", "m.read.private":
-		if err := syncProducer.SendReceipt(req.Context(), device.UserID, roomID, eventID, receiptType, timestamp); err != nil {
+		if err := syncProducer.SendReceipt(req.Context(), device.UserID, roomID, eventID, receiptType, threadID, timestamp); err != nil {
 			return util.ErrorResponse(err)
 		}
 
@@ -24,6 +24,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -39,6 +40,7 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/matrixserver/tokens"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -467,6 +469,7 @@ func Register(
 	req *http.Request,
 	userAPI userapi.ClientUserAPI,
 	cfg *config.ClientAPI,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	defer req.Body.Close() // nolint: errcheck
 	reqBody, err := io.ReadAll(req.Body)
@@ -574,7 +577,7 @@ func Register(
 		"session_id": r.Auth.Session,
 	}).Info("Processing registration request")
 
-	return handleRegistrationFlow(req, r, sessionID, cfg, userAPI, accessToken, accessTokenErr)
+	return handleRegistrationFlow(req, r, sessionID, cfg, userAPI, accessToken, accessTokenErr, spamChecker)
 }
 
 func handleGuestRegistration(
@@ -669,6 +672,7 @@ func handleRegistrationFlow(
 	userAPI userapi.ClientUserAPI,
 	accessToken string,
 	accessTokenErr error,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	// TODO: Enable registration config flag
 	// TODO: Guest account upgrading
@@ -738,6 +742,11 @@ func handleRegistrationFlow(
 		// Add Dummy to the list of completed registration stages
 		sessions.addCompletedSessionStage(sessionID, authtypes.LoginTypeDummy)
 
+	case authtypes.LoginTypeTerms:
+		// The client has shown the policy documents to the user, who accepted them.
+		// This is recorded once the account exists, in checkAndCompleteFlow.
+		sessions.addCompletedSessionStage(sessionID, authtypes.LoginTypeTerms)
+
 	case "":
 		// An empty auth type means that we want to fetch the available
 		// flows. It can also mean that we want to register as an appservice
@@ -753,7 +762,7 @@ func handleRegistrationFlow(
 	// A response with current registration flow and remaining available methods
 	// will be returned if a flow has not been successfully completed yet
 	return checkAndCompleteFlow(sessions.getCompletedStages(sessionID),
-		req, r, sessionID, cfg, userAPI)
+		req, r, sessionID, cfg, userAPI, spamChecker)
 }
 
 // handleApplicationServiceRegistration handles the registration of an
@@ -810,14 +819,28 @@ func checkAndCompleteFlow(
 	sessionID string,
 	cfg *config.ClientAPI,
 	userAPI userapi.ClientUserAPI,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	if checkFlowCompleted(flow, cfg.Derived.Registration.Flows) {
 		// This flow was completed, registration can continue
-		return completeRegistration(
+		spamResult := spamChecker.CheckRegistration(req.Context(), r.Username, req.RemoteAddr, req.UserAgent(), string(r.Auth.Type))
+		if !spamResult.Allowed() {
+			return spamResult.Forbidden()
+		}
+		res := completeRegistration(
 			req.Context(), userAPI, r.Username, r.ServerName, "", r.Password, "", req.RemoteAddr,
 			req.UserAgent(), sessionID, r.InhibitLogin, r.InitialDisplayName, r.DeviceID,
 			userapi.AccountTypeUser,
 		)
+		if res.Code == http.StatusOK && slices.Contains(flow, authtypes.LoginTypeTerms) {
+			// Record that the user accepted the current version of the policy documents.
+			if err := userAPI.PerformUserConsent(req.Context(), &userapi.PerformUserConsentRequest{
+				UserID: userutil.MakeUserID(r.Username, r.ServerName),
+			}, &struct{}{}); err != nil {
+				util.GetLogger(req.Context()).WithError(err).Error("userAPI.PerformUserConsent failed")
+			}
+		}
+		return res
 	}
 	sessions.addParams(sessionID, r)
 	// There are still more stages to complete.
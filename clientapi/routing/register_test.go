@@ -487,7 +487,7 @@ func Test_register(t *testing.T) {
 
 				req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/?kind=%s", tc.kind), body)
 
-				resp := Register(req, userAPI, &cfg.ClientAPI)
+				resp := Register(req, userAPI, &cfg.ClientAPI, nil)
 				t.Logf("Resp: %+v", resp)
 
 				// The first request should return a userInteractiveResponse
@@ -558,7 +558,7 @@ func Test_register(t *testing.T) {
 
 				req = httptest.NewRequest(http.MethodPost, "/", body)
 
-				resp = Register(req, userAPI, &cfg.ClientAPI)
+				resp = Register(req, userAPI, &cfg.ClientAPI, nil)
 
 				switch rr := resp.JSON.(type) {
 				case spec.InternalServerError, spec.MatrixError, util.JSONResponse:
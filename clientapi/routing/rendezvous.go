@@ -0,0 +1,402 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/httputil"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/setup/config"
+)
+
+// rendezvousPath is the path of the rendezvous endpoint, relative to the
+// client API root. Sessions live beneath it.
+const rendezvousPath = "/_matrix/client/unstable/org.matrix.msc4108/rendezvous"
+
+type rendezvousSession struct {
+	client      string
+	etag        string
+	contentType string
+	data        []byte
+	expires     time.Time
+	modified    time.Time
+}
+
+// Rendezvous holds the sessions of the MSC4108 rendezvous endpoint, which
+// two devices use to exchange the messages needed to sign in by QR code.
+// Sessions are only kept in memory, and expire after a short while.
+type Rendezvous struct {
+	cfg            *config.Rendezvous
+	baseURL        string
+	rateLimits     *httputil.RateLimits
+	trustedProxies []netip.Prefix
+	mu             sync.Mutex
+	sessions       map[string]*rendezvousSession
+}
+
+func NewRendezvous(cfg *config.ClientAPI, rateLimits *httputil.RateLimits) *Rendezvous {
+	// The trusted proxies were already checked when the config was verified.
+	trustedProxies, _ := cfg.Rendezvous.TrustedProxyPrefixes()
+	return &Rendezvous{
+		cfg:            &cfg.Rendezvous,
+		baseURL:        strings.TrimSuffix(cfg.Matrix.WellKnownClientName, "/"),
+		rateLimits:     rateLimits,
+		trustedProxies: trustedProxies,
+		sessions:       make(map[string]*rendezvousSession),
+	}
+}
+
+// evictExpired removes expired sessions. The caller must hold r.mu.
+func (r *Rendezvous) evictExpired(now time.Time) {
+	for id, session := range r.sessions {
+		if now.After(session.expires) {
+			delete(r.sessions, id)
+		}
+	}
+}
+
+// clientSessions returns the number of sessions held by the given client.
+// The caller must hold r.mu.
+func (r *Rendezvous) clientSessions(client string) int {
+	count := 0
+	for _, session := range r.sessions {
+		if session.client == client {
+			count++
+		}
+	}
+	return count
+}
+
+// evictOldest removes the oldest sessions until there is room for another
+// one. The caller must hold r.mu.
+func (r *Rendezvous) evictOldest() {
+	for len(r.sessions) > 0 && len(r.sessions) >= r.cfg.MaxSessions {
+		var oldestID string
+		var oldest *rendezvousSession
+		for id, session := range r.sessions {
+			if oldest == nil || session.expires.Before(oldest.expires) {
+				oldestID, oldest = id, session
+			}
+		}
+		delete(r.sessions, oldestID)
+	}
+}
+
+// session returns the session with the given ID, or nil if it doesn't
+// exist or has expired. The caller must hold r.mu.
+func (r *Rendezvous) session(id string, now time.Time) *rendezvousSession {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil
+	}
+	if now.After(session.expires) {
+		delete(r.sessions, id)
+		return nil
+	}
+	return session
+}
+
+// Create implements POST /rendezvous, which creates a new session holding
+// the request body.
+func (r *Rendezvous) Create(w http.ResponseWriter, req *http.Request) {
+	setRendezvousCORSHeaders(w)
+	client := r.clientAddr(req)
+	if !r.limit(w, client) {
+		return
+	}
+	data, ok := r.readPayload(w, req)
+	if !ok {
+		return
+	}
+	id, err := randomRendezvousID()
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("randomRendezvousID failed")
+		writeRendezvousError(w, http.StatusInternalServerError, spec.InternalServerError{})
+		return
+	}
+	etag, err := randomRendezvousID()
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("randomRendezvousID failed")
+		writeRendezvousError(w, http.StatusInternalServerError, spec.InternalServerError{})
+		return
+	}
+
+	now := time.Now()
+	session := &rendezvousSession{
+		client:      client,
+		etag:        etag,
+		contentType: req.Header.Get("Content-Type"),
+		data:        data,
+		expires:     now.Add(r.cfg.SessionLifetime),
+		modified:    now,
+	}
+	r.mu.Lock()
+	r.evictExpired(now)
+	if r.clientSessions(client) >= r.cfg.MaxSessionsPerClient {
+		r.mu.Unlock()
+		writeRendezvousError(w, http.StatusTooManyRequests, spec.LimitExceeded("Too many rendezvous sessions", r.cfg.SessionLifetime.Milliseconds()))
+		return
+	}
+	r.evictOldest()
+	r.sessions[id] = session
+	r.mu.Unlock()
+
+	url := r.sessionURL(req, id)
+	setRendezvousSessionHeaders(w, session)
+	w.Header().Set("Location", url)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(struct {
+		URL string `json:"url"`
+	}{url})
+}
+
+// Get implements GET /rendezvous/{sessionID}, returning the current payload
+// of the session unless it matches the If-None-Match header.
+func (r *Rendezvous) Get(w http.ResponseWriter, req *http.Request, sessionID string) {
+	setRendezvousCORSHeaders(w)
+	if !r.limit(w, r.clientAddr(req)) {
+		return
+	}
+	r.mu.Lock()
+	session := r.session(sessionID, time.Now())
+	var copied rendezvousSession
+	if session != nil {
+		copied = *session
+	}
+	r.mu.Unlock()
+	if session == nil {
+		writeRendezvousError(w, http.StatusNotFound, spec.NotFound("Rendezvous session not found"))
+		return
+	}
+
+	setRendezvousSessionHeaders(w, &copied)
+	if req.Header.Get("If-None-Match") == copied.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if copied.contentType != "" {
+		w.Header().Set("Content-Type", copied.contentType)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(copied.data)
+}
+
+// Update implements PUT /rendezvous/{sessionID}, which replaces the payload
+// of the session as long as the If-Match header matches its current ETag.
+func (r *Rendezvous) Update(w http.ResponseWriter, req *http.Request, sessionID string) {
+	setRendezvousCORSHeaders(w)
+	if !r.limit(w, r.clientAddr(req)) {
+		return
+	}
+	ifMatch := req.Header.Get("If-Match")
+	if ifMatch == "" {
+		writeRendezvousError(w, http.StatusBadRequest, spec.MissingParam("Missing If-Match header"))
+		return
+	}
+	data, ok := r.readPayload(w, req)
+	if !ok {
+		return
+	}
+	etag, err := randomRendezvousID()
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("randomRendezvousID failed")
+		writeRendezvousError(w, http.StatusInternalServerError, spec.InternalServerError{})
+		return
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	session := r.session(sessionID, now)
+	if session == nil {
+		r.mu.Unlock()
+		writeRendezvousError(w, http.StatusNotFound, spec.NotFound("Rendezvous session not found"))
+		return
+	}
+	if session.etag != ifMatch {
+		r.mu.Unlock()
+		writeRendezvousError(w, http.StatusPreconditionFailed, spec.MatrixError{
+			ErrCode: "M_CONCURRENT_WRITE",
+			Err:     "The session was modified by someone else",
+		})
+		return
+	}
+	session.etag = etag
+	session.contentType = req.Header.Get("Content-Type")
+	session.data = data
+	session.modified = now
+	copied := *session
+	r.mu.Unlock()
+
+	setRendezvousSessionHeaders(w, &copied)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Delete implements DELETE /rendezvous/{sessionID}.
+func (r *Rendezvous) Delete(w http.ResponseWriter, req *http.Request, sessionID string) {
+	setRendezvousCORSHeaders(w)
+	if !r.limit(w, r.clientAddr(req)) {
+		return
+	}
+	r.mu.Lock()
+	session := r.session(sessionID, time.Now())
+	delete(r.sessions, sessionID)
+	r.mu.Unlock()
+	if session == nil {
+		writeRendezvousError(w, http.StatusNotFound, spec.NotFound("Rendezvous session not found"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Options answers CORS preflight requests, which must allow the conditional
+// request headers that the rendezvous endpoint relies on.
+func (r *Rendezvous) Options(w http.ResponseWriter, req *http.Request) {
+	setRendezvousCORSHeaders(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// limit applies the rate limits to the client, writing an error response
+// and returning false if the client is sending too many requests.
+func (r *Rendezvous) limit(w http.ResponseWriter, client string) bool {
+	if res := r.rateLimits.LimitCaller("rendezvous:" + client); res != nil {
+		writeRendezvousError(w, res.Code, res.JSON)
+		return false
+	}
+	return true
+}
+
+// trustedProxy returns true if the request came directly from one of the
+// configured reverse proxies.
+func (r *Rendezvous) trustedProxy(req *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return r.trusted(addrPort.Addr())
+}
+
+func (r *Rendezvous) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client that sent the request. The
+// X-Forwarded-For header is only used when the request came from a trusted
+// proxy, in which case the client is the last address in it that isn't one
+// of the trusted proxies.
+func (r *Rendezvous) clientAddr(req *http.Request) string {
+	client := req.RemoteAddr
+	if addrPort, err := netip.ParseAddrPort(req.RemoteAddr); err == nil {
+		client = addrPort.Addr().Unmap().String()
+	}
+	if !r.trustedProxy(req) {
+		return client
+	}
+	forwardedFor := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		forwarded := strings.TrimSpace(forwardedFor[i])
+		if forwarded == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(forwarded)
+		if err != nil {
+			return forwarded
+		}
+		client = addr.Unmap().String()
+		if !r.trusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+func (r *Rendezvous) readPayload(w http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, int64(r.cfg.MaxPayloadSize)+1))
+	if err != nil {
+		writeRendezvousError(w, http.StatusBadRequest, spec.BadJSON("The request body could not be read: "+err.Error()))
+		return nil, false
+	}
+	if len(data) > r.cfg.MaxPayloadSize {
+		writeRendezvousError(w, http.StatusRequestEntityTooLarge, spec.MatrixError{
+			ErrCode: "M_TOO_LARGE",
+			Err:     "Payload is too large",
+		})
+		return nil, false
+	}
+	return data, true
+}
+
+// sessionURL returns the absolute URL of the session. The configured
+// well-known client name is preferred, as the server may be behind a
+// reverse proxy, otherwise the URL is based on the request. The
+// X-Forwarded-Proto header is only honoured from trusted proxies.
+func (r *Rendezvous) sessionURL(req *http.Request, sessionID string) string {
+	base := r.baseURL
+	if base == "" {
+		scheme := "https"
+		if req.TLS == nil {
+			scheme = "http"
+		}
+		if proto := req.Header.Get("X-Forwarded-Proto"); (proto == "http" || proto == "https") && r.trustedProxy(req) {
+			scheme = proto
+		}
+		base = scheme + "://" + req.Host
+	}
+	return base + rendezvousPath + "/" + sessionID
+}
+
+func randomRendezvousID() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func setRendezvousCORSHeaders(w http.ResponseWriter) {
+	util.SetCORSHeaders(w)
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization, If-Match, If-None-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, Location, Expires, Last-Modified")
+}
+
+func setRendezvousSessionHeaders(w http.ResponseWriter, session *rendezvousSession) {
+	w.Header().Set("ETag", session.etag)
+	w.Header().Set("Expires", session.expires.UTC().Format(http.TimeFormat))
+	w.Header().Set("Last-Modified", session.modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+}
+
+func writeRendezvousError(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,195 @@
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/httputil"
+	"github.com/jchv/maidtrix/setup/config"
+)
+
+func TestRendezvous(t *testing.T) {
+	cfg := &config.ClientAPI{Matrix: &config.Global{WellKnownClientName: "https://example.com/"}}
+	cfg.Rendezvous.Defaults()
+	cfg.Rendezvous.Enabled = true
+	rendezvous := NewRendezvous(cfg, httputil.NewRateLimits(&config.RateLimiting{}))
+
+	req := httptest.NewRequest(http.MethodPost, rendezvousPath, strings.NewReader("hello"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	rendezvous.Create(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "https://example.com" + rendezvousPath + "/"
+	if !strings.HasPrefix(created.URL, prefix) {
+		t.Fatalf("unexpected session URL %q", created.URL)
+	}
+	sessionID := strings.TrimPrefix(created.URL, prefix)
+	etag := rec.Header().Get("ETag")
+
+	rec = httptest.NewRecorder()
+	rendezvous.Get(rec, httptest.NewRequest(http.MethodGet, created.URL, nil), sessionID)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("unexpected GET response %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, created.URL, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	rendezvous.Get(rec, req, sessionID)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+
+	// Updates must be based on the latest version of the session
+	req = httptest.NewRequest(http.MethodPut, created.URL, strings.NewReader("world"))
+	req.Header.Set("If-Match", "not-the-etag")
+	rec = httptest.NewRecorder()
+	rendezvous.Update(rec, req, sessionID)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, created.URL, strings.NewReader("world"))
+	req.Header.Set("If-Match", etag)
+	rec = httptest.NewRecorder()
+	rendezvous.Update(rec, req, sessionID)
+	if rec.Code != http.StatusAccepted || rec.Header().Get("ETag") == etag {
+		t.Fatalf("unexpected PUT response %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest(http.MethodPut, created.URL, strings.NewReader(strings.Repeat("a", cfg.Rendezvous.MaxPayloadSize+1)))
+	req.Header.Set("If-Match", etag)
+	rec = httptest.NewRecorder()
+	rendezvous.Update(rec, req, sessionID)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	rendezvous.Delete(rec, httptest.NewRequest(http.MethodDelete, created.URL, nil), sessionID)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	rendezvous.Get(rec, httptest.NewRequest(http.MethodGet, created.URL, nil), sessionID)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+
+	// Sessions expire
+	cfg.Rendezvous.SessionLifetime = -time.Second
+	rec = httptest.NewRecorder()
+	rendezvous.Create(rec, httptest.NewRequest(http.MethodPost, rendezvousPath, strings.NewReader("hello")))
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	rendezvous.Get(rec, httptest.NewRequest(http.MethodGet, created.URL, nil), strings.TrimPrefix(created.URL, prefix))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected expired session to be gone, got %d", rec.Code)
+	}
+}
+
+func TestRendezvousLimits(t *testing.T) {
+	cfg := &config.ClientAPI{Matrix: &config.Global{}}
+	cfg.Rendezvous.Defaults()
+	cfg.Rendezvous.Enabled = true
+	cfg.Rendezvous.MaxSessionsPerClient = 2
+	cfg.Rendezvous.TrustedProxies = []string{"10.0.0.1", "192.168.0.0/16"}
+	rendezvous := NewRendezvous(cfg, httputil.NewRateLimits(&config.RateLimiting{}))
+
+	create := func(remoteAddr, forwardedFor, forwardedProto string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, rendezvousPath, strings.NewReader("hello"))
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		if forwardedProto != "" {
+			req.Header.Set("X-Forwarded-Proto", forwardedProto)
+		}
+		rec := httptest.NewRecorder()
+		rendezvous.Create(rec, req)
+		return rec
+	}
+
+	// A client can only hold a few sessions, which stops it from pushing
+	// everyone else's sessions out. Spoofing X-Forwarded-For doesn't help
+	// unless the request came from a trusted proxy.
+	for i := 0; i < 2; i++ {
+		if rec := create("203.0.113.1:1234", "", ""); rec.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", rec.Code)
+		}
+	}
+	if rec := create("203.0.113.1:1234", "198.51.100.1", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if rec := create("203.0.113.2:1234", "", ""); rec.Code != http.StatusCreated {
+		t.Fatalf("expected another client to create a session, got %d", rec.Code)
+	}
+
+	// Behind the trusted proxies, clients are told apart by X-Forwarded-For
+	for i := 0; i < 2; i++ {
+		if rec := create("10.0.0.1:1234", "203.0.113.1, 198.51.100.1, 192.168.1.1", ""); rec.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", rec.Code)
+		}
+	}
+	if rec := create("10.0.0.1:1234", "198.51.100.1", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+
+	// X-Forwarded-Proto is only honoured from trusted proxies
+	rec := create("10.0.0.1:1234", "198.51.100.2", "https")
+	if !strings.HasPrefix(rec.Header().Get("Location"), "https://") {
+		t.Fatalf("expected an https URL, got %q", rec.Header().Get("Location"))
+	}
+	rec = create("198.51.100.3:1234", "", "https")
+	if !strings.HasPrefix(rec.Header().Get("Location"), "http://") {
+		t.Fatalf("expected an http URL, got %q", rec.Header().Get("Location"))
+	}
+
+	// A misconfigured session limit doesn't hang the endpoint
+	noSessions := NewRendezvous(&config.ClientAPI{Matrix: &config.Global{}, Rendezvous: config.Rendezvous{
+		MaxSessionsPerClient: 1,
+		MaxPayloadSize:       4096,
+		SessionLifetime:      time.Minute,
+	}}, httputil.NewRateLimits(&config.RateLimiting{}))
+	rec = httptest.NewRecorder()
+	noSessions.Create(rec, httptest.NewRequest(http.MethodPost, rendezvousPath, strings.NewReader("hello")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rec.Code)
+	}
+	configErrs := &config.ConfigErrors{}
+	(&config.Rendezvous{Enabled: true, SessionLifetime: time.Minute, MaxPayloadSize: 4096}).Verify(configErrs)
+	if len(*configErrs) != 2 {
+		t.Fatalf("expected zero session limits to be rejected, got %v", *configErrs)
+	}
+
+	// Requests are rate limited
+	rateLimited := NewRendezvous(cfg, httputil.NewRateLimits(&config.RateLimiting{
+		Enabled:   true,
+		Threshold: 1,
+		CooloffMS: 60000,
+	}))
+	rec = httptest.NewRecorder()
+	rateLimited.Get(rec, httptest.NewRequest(http.MethodGet, rendezvousPath+"/abc", nil), "abc")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	rateLimited.Get(rec, httptest.NewRequest(http.MethodGet, rendezvousPath+"/abc", nil), "abc")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	roomserverAPI "github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/jchv/maidtrix/setup/config"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+)
+
+// GetRoomSummary implements GET /_matrix/client/v1/room_summary/{roomIdOrAlias}
+// and its MSC3266 unstable equivalent.
+func GetRoomSummary(
+	req *http.Request, device *userapi.Device, roomIDOrAlias string,
+	cfg *config.ClientAPI, rsAPI roomserverAPI.ClientRoomserverAPI, federation fclient.FederationClient,
+) util.JSONResponse {
+	vias := req.URL.Query()["via"]
+
+	var roomID *spec.RoomID
+	if len(roomIDOrAlias) > 0 && roomIDOrAlias[0] == '#' {
+		_, domain, err := gomatrixserverlib.SplitID('#', roomIDOrAlias)
+		if err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("Room alias must be in the form '#localpart:domain'"),
+			}
+		}
+		queryRes := &roomserverAPI.GetRoomIDForAliasResponse{}
+		if err = rsAPI.GetRoomIDForAlias(req.Context(), &roomserverAPI.GetRoomIDForAliasRequest{
+			Alias:              roomIDOrAlias,
+			IncludeAppservices: true,
+		}, queryRes); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("rsAPI.GetRoomIDForAlias failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		resolvedRoomID := queryRes.RoomID
+		if resolvedRoomID == "" && !cfg.Matrix.IsLocalServerName(domain) {
+			fedRes, fedErr := federation.LookupRoomAlias(req.Context(), cfg.Matrix.ServerName, domain, roomIDOrAlias)
+			if fedErr != nil {
+				util.GetLogger(req.Context()).WithError(fedErr).Warn("federation.LookupRoomAlias failed")
+			} else {
+				resolvedRoomID = fedRes.RoomID
+				for _, serverName := range fedRes.Servers {
+					vias = append(vias, string(serverName))
+				}
+			}
+		}
+		if resolvedRoomID == "" {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: spec.NotFound(fmt.Sprintf("Room alias %s not found", roomIDOrAlias)),
+			}
+		}
+		if roomID, err = spec.NewRoomID(resolvedRoomID); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("alias resolved to an invalid room ID")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+	} else {
+		var err error
+		if roomID, err = spec.NewRoomID(roomIDOrAlias); err != nil {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("Invalid room ID or alias"),
+			}
+		}
+	}
+
+	// Fall back to the server from the room ID if we weren't told where to look.
+	if len(vias) == 0 && roomID.Domain() != "" {
+		vias = append(vias, string(roomID.Domain()))
+	}
+
+	summary, err := rsAPI.QueryRoomSummary(req.Context(), types.NewDeviceNotServerName(*device), *roomID, vias)
+	if err != nil {
+		var unknownErr roomserverAPI.ErrRoomUnknownOrNotAllowed
+		if errors.As(err, &unknownErr) {
+			return util.JSONResponse{
+				Code: http.StatusNotFound,
+				JSON: spec.NotFound("Room is unknown or not accessible"),
+			}
+		}
+		util.GetLogger(req.Context()).WithError(err).Error("rsAPI.QueryRoomSummary failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: summary,
+	}
+}
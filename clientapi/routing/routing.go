@@ -18,6 +18,7 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
@@ -38,10 +39,12 @@ import (
 	"github.com/jchv/maidtrix/clientapi/producers"
 	federationAPI "github.com/jchv/maidtrix/federationapi/api"
 	"github.com/jchv/maidtrix/internal/httputil"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/transactions"
 	roomserverAPI "github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/setup/config"
 	"github.com/jchv/maidtrix/setup/jetstream"
+	"github.com/jchv/maidtrix/setup/process"
 )
 
 type WellKnownClientHomeserver struct {
@@ -64,6 +67,7 @@ type WellKnownClientResponse struct {
 // applied:
 // nolint: gocyclo
 func Setup(
+	processContext *process.ProcessContext,
 	routers httputil.Routers,
 	dendriteCfg *config.Dendrite,
 	rsAPI roomserverAPI.ClientRoomserverAPI,
@@ -89,17 +93,31 @@ func Setup(
 	}
 
 	rateLimits := httputil.NewRateLimits(&cfg.RateLimiting)
+	consentCfg := &dendriteCfg.UserAPI.UserConsent
+	requireConsent := httputil.WithRequireConsent(consentCfg.ConsentURI)
 	userInteractiveAuth := auth.NewUserInteractive(userAPI, cfg)
+	spamChecker := spamcheck.New(&cfg.Matrix.SpamChecker)
 
 	unstableFeatures := map[string]bool{
 		"org.matrix.e2e_cross_signing": true,
 		"org.matrix.msc2285.stable":    true,
 		"org.matrix.msc3916.stable":    true,
+		"org.matrix.msc3814":           true,
+		"im.nheko.summary":             true,
 	}
 	for _, msc := range cfg.MSCs.MSCs {
 		unstableFeatures["org.matrix."+msc] = true
 	}
 
+	var delayedEvents *DelayedEvents
+	if cfg.DelayedEvents.Enabled {
+		delayedEvents = NewDelayedEvents(cfg, rsAPI, userAPI)
+		if err := delayedEvents.Start(context.Background()); err != nil {
+			logrus.WithError(err).Error("Failed to schedule pending delayed events")
+		}
+		unstableFeatures["org.matrix.msc4140"] = true
+	}
+
 	// singleflight protects /join endpoints from being invoked
 	// multiple times from the same user and room, otherwise
 	// a state reset can occur. This also avoids unneeded
@@ -227,6 +245,36 @@ func Setup(
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	dendriteAdminRouter.Handle("/admin/stateGC",
+		httputil.MakeAdminAPI("admin_state_gc", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminStateGC(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/forwardExtremities/{roomID}",
+		httputil.MakeAdminAPI("admin_forward_extremities", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminForwardExtremities(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/mergeForwardExtremities/{roomID}",
+		httputil.MakeAdminAPI("admin_merge_forward_extremities", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminMergeForwardExtremities(req, rsAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/stateAfterEvent/{roomID}/{eventID}",
+		httputil.MakeAdminAPI("admin_state_after_event", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminStateAfterEvent(req, rsAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/resolveState/{roomID}",
+		httputil.MakeAdminAPI("admin_resolve_state", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminResolveState(req, rsAPI)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
 	dendriteAdminRouter.Handle("/admin/resetPassword/{userID}",
 		httputil.MakeAdminAPI("admin_reset_password", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return AdminResetPassword(req, cfg, device, userAPI)
@@ -239,6 +287,23 @@ func Setup(
 		}),
 	).Methods(http.MethodGet, http.MethodOptions)
 
+	makeRoomAdmin := httputil.MakeAdminAPI("admin_make_room_admin", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		return AdminMakeRoomAdmin(req, device, rsAPI)
+	})
+	dendriteAdminRouter.Handle("/admin/makeRoomAdmin/{roomID}", makeRoomAdmin).Methods(http.MethodPost, http.MethodOptions)
+	synapseAdminRouter.Handle("/admin/v1/rooms/{roomID}/make_room_admin", makeRoomAdmin).Methods(http.MethodPost, http.MethodOptions)
+
+	dendriteAdminRouter.Handle("/admin/accountValidity/{userID}",
+		httputil.MakeAdminAPI("admin_get_account_validity", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminGetAccountValidity(req, cfg, userAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+	setAccountValidity := httputil.MakeAdminAPI("admin_set_account_validity", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		return AdminSetAccountValidity(req, cfg, userAPI)
+	})
+	dendriteAdminRouter.Handle("/admin/accountValidity/{userID}", setAccountValidity).Methods(http.MethodPost, http.MethodOptions)
+	synapseAdminRouter.Handle("/admin/v1/account_validity/validity", setAccountValidity).Methods(http.MethodPost, http.MethodOptions)
+
 	dendriteAdminRouter.Handle("/admin/fulltext/reindex",
 		httputil.MakeAdminAPI("admin_fultext_reindex", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return AdminReindex(req, cfg, device, natsClient)
@@ -251,6 +316,12 @@ func Setup(
 		}),
 	).Methods(http.MethodPost, http.MethodOptions)
 
+	dendriteAdminRouter.Handle("/admin/usageStatistics",
+		httputil.MakeAdminAPI("admin_usage_statistics", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return AdminUsageStatistics(req, userAPI)
+		}),
+	).Methods(http.MethodGet, http.MethodOptions)
+
 	// server notifications
 	if cfg.Matrix.ServerNotices.Enabled {
 		logrus.Info("Enabling server notices at /_synapse/admin/v1/send_server_notice")
@@ -259,6 +330,52 @@ func Setup(
 			logrus.WithError(err).Fatal("unable to get account for sending server notices")
 		}
 
+		if consentCfg.Enabled && consentCfg.ServerNoticeMessage != "" {
+			var sendNotices func()
+			sendNotices = func() {
+				sendConsentNotices(context.Background(), consentCfg, cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
+				time.AfterFunc(time.Hour, sendNotices)
+			}
+			time.AfterFunc(time.Minute, sendNotices)
+		}
+
+		if limitsCfg := &cfg.Matrix.ServerNotices.ResourceLimits; limitsCfg.Enabled() {
+			notifier := &resourceLimitNotifier{
+				cfg:          limitsCfg,
+				cfgClient:    cfg,
+				userAPI:      userAPI,
+				rsAPI:        rsAPI,
+				asAPI:        asAPI,
+				senderDevice: serverNotificationSender,
+			}
+			var checkLimits func()
+			checkLimits = func() {
+				ctx := processContext.Context()
+				if ctx.Err() != nil {
+					return
+				}
+				notifier.check(ctx)
+				time.AfterFunc(limitsCfg.CheckInterval, checkLimits)
+			}
+			time.AfterFunc(time.Minute, checkLimits)
+		}
+
+		broadcasts := newServerNoticeBroadcasts()
+		dendriteAdminRouter.Handle("/admin/serverNotices/broadcast",
+			httputil.MakeAdminAPI("admin_server_notice_broadcast", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				if req.Method == http.MethodPost {
+					return AdminBroadcastServerNotice(req, processContext, broadcasts, cfg, userAPI, rsAPI, asAPI, serverNotificationSender)
+				}
+				return AdminServerNoticeBroadcasts(broadcasts)
+			}),
+		).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+
+		dendriteAdminRouter.Handle("/admin/serverNotices/broadcast/{broadcastID}",
+			httputil.MakeAdminAPI("admin_server_notice_broadcast", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				return AdminServerNoticeBroadcast(req, broadcasts)
+			}),
+		).Methods(http.MethodGet, http.MethodDelete, http.MethodOptions)
+
 		synapseAdminRouter.Handle("/admin/v1/send_server_notice/{txnID}",
 			httputil.MakeAuthAPI("send_server_notice", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 				// not specced, but ensure we're rate limiting requests to this endpoint
@@ -310,8 +427,8 @@ func Setup(
 
 	v3mux.Handle("/createRoom",
 		httputil.MakeAuthAPI("createRoom", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
-			return CreateRoom(req, device, cfg, userAPI, rsAPI, asAPI)
-		}),
+			return CreateRoom(req, device, cfg, userAPI, rsAPI, asAPI, spamChecker)
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/join/{roomIDOrAlias}",
 		httputil.MakeAuthAPI(spec.Join, userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -333,7 +450,7 @@ func Setup(
 			// will be processed as usual.
 			sf.Forget(vars["roomIDOrAlias"] + device.UserID)
 			return resp.(util.JSONResponse)
-		}, httputil.WithAllowGuests()),
+		}, httputil.WithAllowGuests(), requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	if mscCfg.Enabled("msc2753") {
@@ -377,7 +494,7 @@ func Setup(
 			// will be processed as usual.
 			sf.Forget(vars["roomID"] + device.UserID)
 			return resp.(util.JSONResponse)
-		}, httputil.WithAllowGuests()),
+		}, httputil.WithAllowGuests(), requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/leave",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -411,7 +528,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			return SendBan(req, userAPI, device, vars["roomID"], cfg, rsAPI, asAPI)
-		}),
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/invite",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -422,8 +539,8 @@ func Setup(
 			if err != nil {
 				return util.ErrorResponse(err)
 			}
-			return SendInvite(req, userAPI, device, vars["roomID"], cfg, rsAPI, asAPI)
-		}),
+			return SendInvite(req, userAPI, device, vars["roomID"], cfg, rsAPI, asAPI, spamChecker)
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/kick",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -432,7 +549,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			return SendKick(req, userAPI, device, vars["roomID"], cfg, rsAPI, asAPI)
-		}),
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/unban",
 		httputil.MakeAuthAPI("membership", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -441,7 +558,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			return SendUnban(req, userAPI, device, vars["roomID"], cfg, rsAPI, asAPI)
-		}),
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/send/{eventType}",
 		httputil.MakeAuthAPI("send_message", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -449,8 +566,8 @@ func Setup(
 			if err != nil {
 				return util.ErrorResponse(err)
 			}
-			return SendEvent(req, device, vars["roomID"], vars["eventType"], nil, nil, cfg, rsAPI, nil)
-		}, httputil.WithAllowGuests()),
+			return SendEvent(req, device, vars["roomID"], vars["eventType"], nil, nil, cfg, rsAPI, nil, delayedEvents, spamChecker)
+		}, httputil.WithAllowGuests(), requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/send/{eventType}/{txnID}",
 		httputil.MakeAuthAPI("send_message", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -460,8 +577,8 @@ func Setup(
 			}
 			txnID := vars["txnID"]
 			return SendEvent(req, device, vars["roomID"], vars["eventType"], &txnID,
-				nil, cfg, rsAPI, transactionsCache)
-		}, httputil.WithAllowGuests()),
+				nil, cfg, rsAPI, transactionsCache, delayedEvents, spamChecker)
+		}, httputil.WithAllowGuests(), requireConsent),
 	).Methods(http.MethodPut, http.MethodOptions)
 
 	v3mux.Handle("/rooms/{roomID}/state", httputil.MakeAuthAPI("room_state", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -508,8 +625,8 @@ func Setup(
 			}
 			emptyString := ""
 			eventType := strings.TrimSuffix(vars["eventType"], "/")
-			return SendEvent(req, device, vars["roomID"], eventType, nil, &emptyString, cfg, rsAPI, nil)
-		}, httputil.WithAllowGuests()),
+			return SendEvent(req, device, vars["roomID"], eventType, nil, &emptyString, cfg, rsAPI, nil, delayedEvents, spamChecker)
+		}, httputil.WithAllowGuests(), requireConsent),
 	).Methods(http.MethodPut, http.MethodOptions)
 
 	v3mux.Handle("/rooms/{roomID}/state/{eventType}/{stateKey}",
@@ -519,8 +636,8 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			stateKey := vars["stateKey"]
-			return SendEvent(req, device, vars["roomID"], vars["eventType"], nil, &stateKey, cfg, rsAPI, nil)
-		}, httputil.WithAllowGuests()),
+			return SendEvent(req, device, vars["roomID"], vars["eventType"], nil, &stateKey, cfg, rsAPI, nil, delayedEvents, spamChecker)
+		}, httputil.WithAllowGuests(), requireConsent),
 	).Methods(http.MethodPut, http.MethodOptions)
 
 	// Defined outside of handler to persist between calls
@@ -536,11 +653,21 @@ func Setup(
 		}, httputil.WithAllowGuests()),
 	).Methods(http.MethodGet, http.MethodOptions)
 
+	roomSummary := httputil.MakeAuthAPI("room_summary", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+		if err != nil {
+			return util.ErrorResponse(err)
+		}
+		return GetRoomSummary(req, device, vars["roomIDOrAlias"], cfg, rsAPI, federation)
+	}, httputil.WithAllowGuests())
+	v1mux.Handle("/room_summary/{roomIDOrAlias}", roomSummary).Methods(http.MethodGet, http.MethodOptions)
+	unstableMux.Handle("/im.nheko.summary/summary/{roomIDOrAlias}", roomSummary).Methods(http.MethodGet, http.MethodOptions)
+
 	v3mux.Handle("/register", httputil.MakeExternalAPI("register", func(req *http.Request) util.JSONResponse {
 		if r := rateLimits.Limit(req, nil); r != nil {
 			return *r
 		}
-		return Register(req, userAPI, cfg)
+		return Register(req, userAPI, cfg, spamChecker)
 	})).Methods(http.MethodPost, http.MethodOptions)
 
 	v3mux.Handle("/register/available", httputil.MakeExternalAPI("registerAvailable", func(req *http.Request) util.JSONResponse {
@@ -628,13 +755,13 @@ func Setup(
 	v3mux.Handle("/logout",
 		httputil.MakeAuthAPI("logout", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return Logout(req, userAPI, device)
-		}),
+		}, httputil.WithAllowExpiredAccounts()),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	v3mux.Handle("/logout/all",
 		httputil.MakeAuthAPI("logout", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
 			return LogoutAll(req, userAPI, device)
-		}),
+		}, httputil.WithAllowExpiredAccounts()),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	v3mux.Handle("/rooms/{roomID}/typing/{userID}",
@@ -656,7 +783,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			return SendRedaction(req, device, vars["roomID"], vars["eventID"], cfg, rsAPI, nil, nil)
-		}),
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 	v3mux.Handle("/rooms/{roomID}/redact/{eventID}/{txnId}",
 		httputil.MakeAuthAPI("rooms_redact", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
@@ -666,7 +793,7 @@ func Setup(
 			}
 			txnID := vars["txnId"]
 			return SendRedaction(req, device, vars["roomID"], vars["eventID"], cfg, rsAPI, &txnID, transactionsCache)
-		}),
+		}, requireConsent),
 	).Methods(http.MethodPut, http.MethodOptions)
 
 	v3mux.Handle("/sendToDevice/{eventType}/{txnID}",
@@ -732,6 +859,40 @@ func Setup(
 		}),
 	).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
 
+	v1mux.Handle("/login/get_token",
+		httputil.MakeAuthAPI("login_get_token", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			if r := rateLimits.Limit(req, device); r != nil {
+				return *r
+			}
+			return GetLoginToken(req, userInteractiveAuth, userAPI, device, cfg)
+		}),
+	).Methods(http.MethodPost, http.MethodOptions)
+
+	if cfg.Rendezvous.Enabled {
+		rendezvous := NewRendezvous(cfg, rateLimits)
+		unstableMux.Handle("/org.matrix.msc4108/rendezvous",
+			httputil.MakeHTTPAPI("rendezvous_create", userAPI, enableMetrics, rendezvous.Create),
+		).Methods(http.MethodPost)
+		unstableMux.Handle("/org.matrix.msc4108/rendezvous/{sessionID}",
+			httputil.MakeHTTPAPI("rendezvous_get", userAPI, enableMetrics, func(w http.ResponseWriter, req *http.Request) {
+				rendezvous.Get(w, req, mux.Vars(req)["sessionID"])
+			}),
+		).Methods(http.MethodGet)
+		unstableMux.Handle("/org.matrix.msc4108/rendezvous/{sessionID}",
+			httputil.MakeHTTPAPI("rendezvous_update", userAPI, enableMetrics, func(w http.ResponseWriter, req *http.Request) {
+				rendezvous.Update(w, req, mux.Vars(req)["sessionID"])
+			}),
+		).Methods(http.MethodPut)
+		unstableMux.Handle("/org.matrix.msc4108/rendezvous/{sessionID}",
+			httputil.MakeHTTPAPI("rendezvous_delete", userAPI, enableMetrics, func(w http.ResponseWriter, req *http.Request) {
+				rendezvous.Delete(w, req, mux.Vars(req)["sessionID"])
+			}),
+		).Methods(http.MethodDelete)
+		unstableMux.Handle("/org.matrix.msc4108/rendezvous", http.HandlerFunc(rendezvous.Options)).Methods(http.MethodOptions)
+		unstableMux.Handle("/org.matrix.msc4108/rendezvous/{sessionID}", http.HandlerFunc(rendezvous.Options)).Methods(http.MethodOptions)
+		unstableFeatures["org.matrix.msc4108"] = true
+	}
+
 	v3mux.Handle("/auth/{authType}/fallback/web",
 		httputil.MakeHTTPAPI("auth_fallback", userAPI, enableMetrics, func(w http.ResponseWriter, req *http.Request) {
 			vars := mux.Vars(req)
@@ -906,7 +1067,7 @@ func Setup(
 			if err != nil {
 				return util.ErrorResponse(err)
 			}
-			return SetAvatarURL(req, userAPI, device, vars["userID"], cfg, rsAPI)
+			return SetAvatarURL(req, userAPI, device, vars["userID"], cfg, rsAPI, spamChecker)
 		}),
 	).Methods(http.MethodPut, http.MethodOptions)
 	// Browsers use the OPTIONS HTTP method to check if the CORS policy allows
@@ -931,7 +1092,7 @@ func Setup(
 			if err != nil {
 				return util.ErrorResponse(err)
 			}
-			return SetDisplayName(req, userAPI, device, vars["userID"], cfg, rsAPI)
+			return SetDisplayName(req, userAPI, device, vars["userID"], cfg, rsAPI, spamChecker)
 		}, httputil.WithAllowGuests()),
 	).Methods(http.MethodPut, http.MethodOptions)
 	// Browsers use the OPTIONS HTTP method to check if the CORS policy allows
@@ -1110,6 +1271,7 @@ func Setup(
 				req.Context(),
 				device,
 				rsAPI,
+				userAPI,
 				userDirectoryProvider,
 				postContent.SearchString,
 				postContent.Limit,
@@ -1152,7 +1314,7 @@ func Setup(
 				return util.ErrorResponse(err)
 			}
 			return UpgradeRoom(req, device, cfg, vars["roomID"], userAPI, rsAPI, asAPI)
-		}),
+		}, requireConsent),
 	).Methods(http.MethodPost, http.MethodOptions)
 
 	v3mux.Handle("/devices",
@@ -1272,7 +1434,7 @@ func Setup(
 			if r := rateLimits.Limit(req, device); r != nil {
 				return *r
 			}
-			return GetCapabilities(rsAPI)
+			return GetCapabilities(rsAPI, cfg)
 		}, httputil.WithAllowGuests()),
 	).Methods(http.MethodGet, http.MethodOptions)
 
@@ -1462,6 +1624,66 @@ func Setup(
 	unstableMux.Handle("/keys/device_signing/upload", postDeviceSigningKeys).Methods(http.MethodPost, http.MethodOptions)
 	unstableMux.Handle("/keys/signatures/upload", postDeviceSigningSignatures).Methods(http.MethodPost, http.MethodOptions)
 
+	if dendriteCfg.UserAPI.AccountValidity.Enabled {
+		unstableMux.Handle("/account_validity/renew",
+			httputil.MakeExternalAPI("account_validity_renew", func(req *http.Request) util.JSONResponse {
+				return RenewAccount(req, userAPI)
+			}),
+		).Methods(http.MethodGet, http.MethodOptions)
+		unstableMux.Handle("/account_validity/send_mail",
+			httputil.MakeAuthAPI("account_validity_send_mail", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				return SendRenewalEmail(req, device, userAPI)
+			}, httputil.WithAllowExpiredAccounts()),
+		).Methods(http.MethodPost, http.MethodOptions)
+	}
+
+	if delayedEvents != nil {
+		unstableMux.Handle("/org.matrix.msc4140/delayed_events",
+			httputil.MakeAuthAPI("delayed_events", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				return GetDelayedEvents(req, device, userAPI)
+			}),
+		).Methods(http.MethodGet, http.MethodOptions)
+		unstableMux.Handle("/org.matrix.msc4140/delayed_events/{delayID}",
+			httputil.MakeAuthAPI("delayed_events", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+				if err != nil {
+					return util.ErrorResponse(err)
+				}
+				return UpdateDelayedEvent(req, device, userAPI, delayedEvents, vars["delayID"])
+			}),
+		).Methods(http.MethodPost, http.MethodOptions)
+	}
+
+	if consentCfg.Enabled {
+		unstableMux.Handle("/consent",
+			httputil.MakeAuthAPI("consent", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				return GetConsent(req, device, consentCfg, userAPI)
+			}),
+		).Methods(http.MethodGet, http.MethodOptions)
+		unstableMux.Handle("/consent",
+			httputil.MakeAuthAPI("consent", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+				return PostConsent(req, device, consentCfg, userAPI)
+			}),
+		).Methods(http.MethodPost, http.MethodOptions)
+	}
+
+	// Dehydrated devices, as per MSC3814
+	unstableMux.Handle("/org.matrix.msc3814.v1/dehydrated_device",
+		httputil.MakeAuthAPI("put_dehydrated_device", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return PutDehydratedDevice(req, userAPI, device)
+		}),
+	).Methods(http.MethodPut, http.MethodOptions)
+	unstableMux.Handle("/org.matrix.msc3814.v1/dehydrated_device",
+		httputil.MakeAuthAPI("get_dehydrated_device", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return GetDehydratedDevice(req, userAPI, device)
+		}),
+	).Methods(http.MethodGet)
+	unstableMux.Handle("/org.matrix.msc3814.v1/dehydrated_device",
+		httputil.MakeAuthAPI("delete_dehydrated_device", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
+			return DeleteDehydratedDevice(req, userAPI, device)
+		}),
+	).Methods(http.MethodDelete)
+
 	// Supplying a device ID is deprecated.
 	v3mux.Handle("/keys/upload/{deviceID}",
 		httputil.MakeAuthAPI("keys_upload", userAPI, func(req *http.Request, device *userapi.Device) util.JSONResponse {
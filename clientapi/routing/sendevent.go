@@ -27,6 +27,7 @@ import (
 	"github.com/jchv/maidtrix/internal/eventutil"
 	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/transactions"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/roomserver/api"
@@ -69,6 +70,9 @@ var sendEventDuration = prometheus.NewHistogramVec(
 //	/rooms/{roomID}/send/{eventType}/{txnID}
 //	/rooms/{roomID}/state/{eventType}/{stateKey}
 //
+// If delayedEvents is given, the event may instead be delayed by the client
+// as per MSC4140, in which case a delay ID is returned rather than an event ID.
+//
 // nolint: gocyclo
 func SendEvent(
 	req *http.Request,
@@ -77,6 +81,8 @@ func SendEvent(
 	cfg *config.ClientAPI,
 	rsAPI api.ClientRoomserverAPI,
 	txnCache *transactions.Cache,
+	delayedEvents *DelayedEvents,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	roomVersion, err := rsAPI.QueryRoomVersionForRoom(req.Context(), roomID)
 	if err != nil {
@@ -93,6 +99,11 @@ func SendEvent(
 		}
 	}
 
+	delay, resErr := delayedEvents.parseDelay(req)
+	if resErr != nil {
+		return *resErr
+	}
+
 	// Translate user ID state keys to room keys in pseudo ID rooms
 	if roomVersion == gomatrixserverlib.RoomVersionPseudoIDs && stateKey != nil {
 		parsedRoomID, innerErr := spec.NewRoomID(roomID)
@@ -126,12 +137,12 @@ func SendEvent(
 	defer mutex.(*sync.Mutex).Unlock()
 
 	var r map[string]interface{} // must be a JSON object
-	resErr := httputil.UnmarshalJSONRequest(req, &r)
+	resErr = httputil.UnmarshalJSONRequest(req, &r)
 	if resErr != nil {
 		return *resErr
 	}
 
-	if stateKey != nil {
+	if stateKey != nil && delay == 0 {
 		// If the existing/new state content are equal, return the existing event_id, making the request idempotent.
 		if resp := stateEqual(req.Context(), rsAPI, eventType, *stateKey, roomID, r); resp != nil {
 			return *resp
@@ -166,6 +177,25 @@ func SendEvent(
 		}
 	}
 
+	if delay > 0 {
+		delayID, err := delayedEvents.Create(req.Context(), device, roomID, eventType, stateKey, r, delay)
+		if err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("delayedEvents.Create failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+		res := util.JSONResponse{
+			Code: http.StatusOK,
+			JSON: delayedEventResponse{delayID},
+		}
+		if txnID != nil {
+			txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
+		}
+		return res
+	}
+
 	e, resErr := generateSendEvent(req.Context(), r, device, roomID, eventType, stateKey, rsAPI, evTime)
 	if resErr != nil {
 		return *resErr
@@ -209,6 +239,17 @@ func SendEvent(
 		}
 	}
 
+	// Soft-failed events are still sent to the roomserver and the client
+	// gets an event ID back as normal, so that the sender doesn't find out
+	// that the event was caught by the spam checker.
+	spamResult := spamChecker.CheckEvent(req.Context(), e, domain)
+	switch spamResult.Verdict {
+	case spamcheck.VerdictDeny:
+		return spamResult.Forbidden()
+	case spamcheck.VerdictRedact:
+		e.Redact()
+	}
+
 	var txnAndSessionID *api.TransactionID
 	if txnID != nil {
 		txnAndSessionID = &api.TransactionID{
@@ -220,16 +261,19 @@ func SendEvent(
 	// pass the new event to the roomserver and receive the correct event ID
 	// event ID in case of duplicate transaction is discarded
 	startedSubmittingEvent := time.Now()
-	if err := api.SendEvents(
+	if err := api.SendInputRoomEvents(
 		req.Context(), rsAPI,
-		api.KindNew,
-		[]*types.HeaderedEvent{
-			{PDU: e},
-		},
 		device.UserDomain(),
-		domain,
-		domain,
-		txnAndSessionID,
+		[]api.InputRoomEvent{
+			{
+				Kind:          api.KindNew,
+				Event:         &types.HeaderedEvent{PDU: e},
+				Origin:        domain,
+				SendAsServer:  string(domain),
+				TransactionID: txnAndSessionID,
+				SoftFail:      spamResult.Verdict == spamcheck.VerdictSoftFail,
+			},
+		},
 		false,
 	); err != nil {
 		util.GetLogger(req.Context()).WithError(err).Error("SendEvents failed")
@@ -245,6 +289,11 @@ func SendEvent(
 		"room_version": roomVersion,
 	}).Info("Sent event to roomserver")
 
+	// Setting the state overrides any delayed events which would have set it later.
+	if stateKey != nil && delayedEvents != nil {
+		delayedEvents.CancelStateEvents(req.Context(), roomID, eventType, *stateKey)
+	}
+
 	res := util.JSONResponse{
 		Code: http.StatusOK,
 		JSON: sendEventResponse{e.EventID()},
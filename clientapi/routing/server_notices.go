@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/jchv/maidtrix/internal/matrix"
@@ -79,7 +80,6 @@ func SendServerNotice(
 		}
 	}
 
-	ctx := req.Context()
 	var r sendServerNoticeRequest
 	resErr := httputil.UnmarshalJSONRequest(req, &r)
 	if resErr != nil {
@@ -94,21 +94,73 @@ func SendServerNotice(
 		}
 	}
 
-	userID, err := spec.NewUserID(r.UserID, true)
-	if err != nil {
-		return util.JSONResponse{
-			Code: http.StatusBadRequest,
-			JSON: spec.InvalidParam("invalid user ID"),
+	var txnAndSessionID *api.TransactionID
+	if txnID != nil {
+		txnAndSessionID = &api.TransactionID{
+			TransactionID: *txnID,
+			SessionID:     device.SessionID,
 		}
 	}
 
+	res := sendServerNotice(
+		req.Context(), r, cfgNotices, cfgClient, userAPI, rsAPI, asAPI,
+		senderDevice, device.UserDomain(), txnAndSessionID,
+	)
+	// Add response to transactionsCache
+	if txnID != nil && res.Code == http.StatusOK {
+		txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
+	}
+	return res
+}
+
+// sendServerNotice sends the notice to the user in their server notices
+// room, creating the room or re-inviting the user to it first if needed.
+func sendServerNotice(
+	ctx context.Context,
+	r sendServerNoticeRequest,
+	cfgNotices *config.ServerNotices,
+	cfgClient *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+	origin spec.ServerName,
+	txnAndSessionID *api.TransactionID,
+) util.JSONResponse {
+	roomID, resErr := serverNoticeRoom(ctx, r.UserID, cfgNotices, cfgClient, userAPI, rsAPI, asAPI, senderDevice)
+	if resErr != nil {
+		return *resErr
+	}
+	content := map[string]interface{}{
+		"body":    r.Content.Body,
+		"msgtype": r.Content.MsgType,
+	}
+	eventID, resErr := sendServerNoticeEvent(ctx, roomID, "m.room.message", nil, content, cfgClient, rsAPI, senderDevice, origin, txnAndSessionID)
+	if resErr != nil {
+		return *resErr
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: sendEventResponse{eventID},
+	}
+}
+
+// findServerNoticeRoom returns the ID of the user's server notices room, or
+// an empty string if they don't have one yet.
+func findServerNoticeRoom(
+	ctx context.Context,
+	userID spec.UserID,
+	cfgNotices *config.ServerNotices,
+	cfgClient *config.ClientAPI,
+	rsAPI api.ClientRoomserverAPI,
+) (string, error) {
 	// get rooms for specified user
 	allUserRooms := []spec.RoomID{}
 	// Get rooms the user is either joined, invited or has left.
 	for _, membership := range []string{"join", "invite", "leave"} {
-		userRooms, queryErr := rsAPI.QueryRoomsForUser(ctx, *userID, membership)
-		if queryErr != nil {
-			return util.ErrorResponse(err)
+		userRooms, err := rsAPI.QueryRoomsForUser(ctx, userID, membership)
+		if err != nil {
+			return "", err
 		}
 		allUserRooms = append(allUserRooms, userRooms...)
 	}
@@ -116,14 +168,11 @@ func SendServerNotice(
 	// get rooms of the sender
 	senderUserID, err := spec.NewUserID(fmt.Sprintf("@%s:%s", cfgNotices.LocalPart, cfgClient.Matrix.ServerName), true)
 	if err != nil {
-		return util.JSONResponse{
-			Code: http.StatusInternalServerError,
-			JSON: spec.Unknown("internal server error"),
-		}
+		return "", err
 	}
 	senderRooms, err := rsAPI.QueryRoomsForUser(ctx, *senderUserID, "join")
 	if err != nil {
-		return util.ErrorResponse(err)
+		return "", err
 	}
 
 	// check if we have rooms in common
@@ -136,113 +185,175 @@ func SendServerNotice(
 		}
 	}
 
-	if len(commonRooms) > 1 {
-		return util.ErrorResponse(fmt.Errorf("expected to find one room, but got %d", len(commonRooms)))
+	switch len(commonRooms) {
+	case 0:
+		return "", nil
+	case 1:
+		return commonRooms[0].String(), nil
+	default:
+		return "", fmt.Errorf("expected to find one room, but got %d", len(commonRooms))
 	}
+}
 
-	var (
-		roomID      string
-		roomVersion = rsAPI.DefaultRoomVersion()
-	)
+// serverNoticeRoomLocks ensure that concurrent senders don't both create a
+// server notices room for the same user.
+var serverNoticeRoomLocks = &userLocks{locks: map[string]*userLock{}}
 
-	// create a new room for the user
-	if len(commonRooms) == 0 {
-		powerLevelContent := eventutil.InitialPowerLevelsContent(senderUserID.String())
-		powerLevelContent.Users[r.UserID] = -10 // taken from Synapse
-		pl, err := json.Marshal(powerLevelContent)
-		if err != nil {
-			return util.ErrorResponse(err)
-		}
-		createContent := map[string]interface{}{}
-		createContent["m.federate"] = false
-		cc, err := json.Marshal(createContent)
-		if err != nil {
-			return util.ErrorResponse(err)
-		}
-		crReq := createRoomRequest{
-			Invite:                    []string{r.UserID},
-			Name:                      cfgNotices.RoomName,
-			Visibility:                "private",
-			Preset:                    spec.PresetPrivateChat,
-			CreationContent:           cc,
-			RoomVersion:               roomVersion,
-			PowerLevelContentOverride: pl,
-		}
+type userLock struct {
+	sync.Mutex
+	refs int // how many callers hold or are waiting for the lock
+}
 
-		roomRes := createRoom(ctx, crReq, senderDevice, cfgClient, userAPI, rsAPI, asAPI, time.Now())
-
-		switch data := roomRes.JSON.(type) {
-		case createRoomResponse:
-			roomID = data.RoomID
-
-			// tag the room, so we can later check if the user tries to reject an invite
-			serverAlertTag := gomatrix.TagContent{Tags: map[string]gomatrix.TagProperties{
-				"m.server_notice": {
-					Order: 1.0,
-				},
-			}}
-			if err = saveTagData(req, r.UserID, roomID, userAPI, serverAlertTag); err != nil {
-				util.GetLogger(ctx).WithError(err).Error("saveTagData failed")
-				return util.JSONResponse{
-					Code: http.StatusInternalServerError,
-					JSON: spec.InternalServerError{},
-				}
-			}
+// userLocks is a mutex per user. The mutex of a user is dropped once nobody
+// holds or waits for it, so that the map doesn't grow with every user that
+// was ever locked.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
 
-		default:
-			// if we didn't get a createRoomResponse, we probably received an error, so return that.
-			return roomRes
+// lock locks the mutex of the user, and returns the function to unlock it.
+func (l *userLocks) lock(userID string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[userID]
+	if !ok {
+		lock = &userLock{}
+		l.locks[userID] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, userID)
 		}
-	} else {
-		// we've found a room in common, check the membership
-		deviceUserID, err := spec.NewUserID(r.UserID, true)
-		if err != nil {
-			return util.JSONResponse{
-				Code: http.StatusForbidden,
-				JSON: spec.Forbidden("userID doesn't have power level to change visibility"),
-			}
+		l.mu.Unlock()
+	}
+}
+
+// serverNoticeRoom returns the ID of the user's server notices room, creating
+// the room or re-inviting the user to it first if needed.
+func serverNoticeRoom(
+	ctx context.Context,
+	userIDStr string,
+	cfgNotices *config.ServerNotices,
+	cfgClient *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) (string, *util.JSONResponse) {
+	userID, err := spec.NewUserID(userIDStr, true)
+	if err != nil {
+		return "", &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid user ID"),
 		}
+	}
+	unlock := serverNoticeRoomLocks.lock(userID.String())
+	defer unlock()
+
+	roomID, err := findServerNoticeRoom(ctx, *userID, cfgNotices, cfgClient, rsAPI)
+	if err != nil {
+		resErr := util.ErrorResponse(err)
+		return "", &resErr
+	}
 
-		roomID = commonRooms[0].String()
+	if roomID != "" {
+		// we've found a room in common, check the membership
 		membershipRes := api.QueryMembershipForUserResponse{}
-		err = rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{UserID: *deviceUserID, RoomID: roomID}, &membershipRes)
+		err = rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{UserID: *userID, RoomID: roomID}, &membershipRes)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("unable to query membership for user")
-			return util.JSONResponse{
+			return "", &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
 		}
 		if !membershipRes.IsInRoom {
 			// re-invite the user
-			res, err := sendInvite(ctx, senderDevice, roomID, r.UserID, "Server notice room", cfgClient, rsAPI, time.Now())
+			res, err := sendInvite(ctx, senderDevice, roomID, userIDStr, "Server notice room", cfgClient, rsAPI, time.Now())
 			if err != nil {
-				return res
+				return "", &res
 			}
 		}
+		return roomID, nil
 	}
 
-	startedGeneratingEvent := time.Now()
+	// create a new room for the user
+	powerLevelContent := eventutil.InitialPowerLevelsContent(senderDevice.UserID)
+	powerLevelContent.Users[userIDStr] = -10 // taken from Synapse
+	pl, err := json.Marshal(powerLevelContent)
+	if err != nil {
+		resErr := util.ErrorResponse(err)
+		return "", &resErr
+	}
+	createContent := map[string]interface{}{}
+	createContent["m.federate"] = false
+	cc, err := json.Marshal(createContent)
+	if err != nil {
+		resErr := util.ErrorResponse(err)
+		return "", &resErr
+	}
+	crReq := createRoomRequest{
+		Invite:                    []string{userIDStr},
+		Name:                      cfgNotices.RoomName,
+		Visibility:                "private",
+		Preset:                    spec.PresetPrivateChat,
+		CreationContent:           cc,
+		RoomVersion:               rsAPI.DefaultRoomVersion(),
+		PowerLevelContentOverride: pl,
+	}
 
-	request := map[string]interface{}{
-		"body":    r.Content.Body,
-		"msgtype": r.Content.MsgType,
+	roomRes := createRoom(ctx, crReq, senderDevice, cfgClient, userAPI, rsAPI, asAPI, time.Now())
+
+	data, ok := roomRes.JSON.(createRoomResponse)
+	if !ok {
+		// if we didn't get a createRoomResponse, we probably received an error, so return that.
+		return "", &roomRes
+	}
+
+	// tag the room, so we can later check if the user tries to reject an invite
+	serverAlertTag := gomatrix.TagContent{Tags: map[string]gomatrix.TagProperties{
+		"m.server_notice": {
+			Order: 1.0,
+		},
+	}}
+	if err = saveTagData(ctx, userIDStr, data.RoomID, userAPI, serverAlertTag); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("saveTagData failed")
+		return "", &util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
 	}
-	e, resErr := generateSendEvent(ctx, request, senderDevice, roomID, "m.room.message", nil, rsAPI, time.Now())
+	return data.RoomID, nil
+}
+
+// sendServerNoticeEvent sends an event into a server notices room as the
+// server notices user, returning the ID of the new event.
+func sendServerNoticeEvent(
+	ctx context.Context,
+	roomID, eventType string, stateKey *string,
+	content map[string]interface{},
+	cfgClient *config.ClientAPI,
+	rsAPI api.ClientRoomserverAPI,
+	senderDevice *userapi.Device,
+	origin spec.ServerName,
+	txnAndSessionID *api.TransactionID,
+) (string, *util.JSONResponse) {
+	startedGeneratingEvent := time.Now()
+
+	e, resErr := generateSendEvent(ctx, content, senderDevice, roomID, eventType, stateKey, rsAPI, time.Now())
 	if resErr != nil {
 		logrus.Errorf("failed to send message: %+v", resErr)
-		return *resErr
+		return "", resErr
 	}
 	timeToGenerateEvent := time.Since(startedGeneratingEvent)
 
-	var txnAndSessionID *api.TransactionID
-	if txnID != nil {
-		txnAndSessionID = &api.TransactionID{
-			TransactionID: *txnID,
-			SessionID:     device.SessionID,
-		}
-	}
-
 	// pass the new event to the roomserver and receive the correct event ID
 	// event ID in case of duplicate transaction is discarded
 	startedSubmittingEvent := time.Now()
@@ -252,14 +363,14 @@ func SendServerNotice(
 		[]*types.HeaderedEvent{
 			{PDU: e},
 		},
-		device.UserDomain(),
+		origin,
 		cfgClient.Matrix.ServerName,
 		cfgClient.Matrix.ServerName,
 		txnAndSessionID,
 		false,
 	); err != nil {
 		util.GetLogger(ctx).WithError(err).Error("SendEvents failed")
-		return util.JSONResponse{
+		return "", &util.JSONResponse{
 			Code: http.StatusInternalServerError,
 			JSON: spec.InternalServerError{},
 		}
@@ -267,25 +378,16 @@ func SendServerNotice(
 	util.GetLogger(ctx).WithFields(logrus.Fields{
 		"event_id":     e.EventID(),
 		"room_id":      roomID,
-		"room_version": roomVersion,
+		"room_version": e.Version(),
 	}).Info("Sent event to roomserver")
 	timeToSubmitEvent := time.Since(startedSubmittingEvent)
 
-	res := util.JSONResponse{
-		Code: http.StatusOK,
-		JSON: sendEventResponse{e.EventID()},
-	}
-	// Add response to transactionsCache
-	if txnID != nil {
-		txnCache.AddTransaction(device.AccessToken, *txnID, req.URL, &res)
-	}
-
 	// Take a note of how long it took to generate the event vs submit
 	// it to the roomserver.
 	sendEventDuration.With(prometheus.Labels{"action": "build"}).Observe(float64(timeToGenerateEvent.Milliseconds()))
 	sendEventDuration.With(prometheus.Labels{"action": "submit"}).Observe(float64(timeToSubmitEvent.Milliseconds()))
 
-	return res
+	return e.EventID(), nil
 }
 
 func (r sendServerNoticeRequest) valid() (ok bool) {
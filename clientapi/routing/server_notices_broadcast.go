@@ -0,0 +1,355 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	appserviceAPI "github.com/jchv/maidtrix/appservice/api"
+	clientutil "github.com/jchv/maidtrix/clientapi/httputil"
+	"github.com/jchv/maidtrix/internal/httputil"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/setup/process"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	broadcastStatusRunning   = "running"
+	broadcastStatusFinished  = "finished"
+	broadcastStatusCancelled = "cancelled"
+	broadcastStatusFailed    = "failed"
+
+	// Finished broadcasts are forgotten once they are older than
+	// broadcastRetention, or when there are more than maxFinishedBroadcasts.
+	broadcastRetention    = time.Hour * 24 * 7
+	maxFinishedBroadcasts = 100
+)
+
+// serverNoticeBroadcastRequest is the request to send a server notice to
+// all of the local users matching the filter.
+type serverNoticeBroadcastRequest struct {
+	Content struct {
+		MsgType string `json:"msgtype,omitempty"`
+		Body    string `json:"body,omitempty"`
+	} `json:"content,omitempty"`
+	Filter serverNoticeBroadcastFilter `json:"filter"`
+}
+
+type serverNoticeBroadcastFilter struct {
+	// Only send to users who were active in this many days, if set.
+	ActiveDays int `json:"active_days,omitempty"`
+	// Only send to admins.
+	AdminsOnly bool `json:"admins_only,omitempty"`
+	// Only send to users whose localpart matches this regular expression, if set.
+	LocalpartRegex string `json:"localpart_regex,omitempty"`
+}
+
+func (r serverNoticeBroadcastRequest) validate() error {
+	if r.Content.MsgType == "" || r.Content.Body == "" {
+		return fmt.Errorf("content.msgtype and content.body are required")
+	}
+	if r.Filter.ActiveDays < 0 {
+		return fmt.Errorf("filter.active_days must not be negative")
+	}
+	if _, err := regexp.Compile(r.Filter.LocalpartRegex); err != nil {
+		return fmt.Errorf("filter.localpart_regex is invalid: %w", err)
+	}
+	return nil
+}
+
+// serverNoticeBroadcast is the progress of a broadcast.
+type serverNoticeBroadcast struct {
+	ID       string                      `json:"id"`
+	Status   string                      `json:"status"`
+	Filter   serverNoticeBroadcastFilter `json:"filter"`
+	Total    int                         `json:"total"`
+	Sent     int                         `json:"sent"`
+	Failed   int                         `json:"failed"`
+	Started  spec.Timestamp              `json:"started_ts"`
+	Finished spec.Timestamp              `json:"finished_ts,omitempty"`
+	Error    string                      `json:"error,omitempty"`
+}
+
+// serverNoticeBroadcasts keeps track of the broadcasts since startup. They
+// aren't persisted, so a broadcast which is interrupted by a restart has to
+// be started again. Finished broadcasts are only kept for a while.
+type serverNoticeBroadcasts struct {
+	mu      sync.Mutex
+	nextID  int
+	jobs    map[string]*serverNoticeBroadcast
+	cancels map[string]context.CancelFunc
+}
+
+func newServerNoticeBroadcasts() *serverNoticeBroadcasts {
+	return &serverNoticeBroadcasts{
+		jobs:    map[string]*serverNoticeBroadcast{},
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// prune forgets finished broadcasts which are older than broadcastRetention,
+// and then the oldest finished broadcasts until there are no more than
+// maxFinishedBroadcasts. The caller must hold b.mu.
+func (b *serverNoticeBroadcasts) prune(now time.Time) {
+	oldest := spec.AsTimestamp(now.Add(-broadcastRetention))
+	finished := make([]*serverNoticeBroadcast, 0, len(b.jobs))
+	for id, job := range b.jobs {
+		if job.Status == broadcastStatusRunning {
+			continue
+		}
+		if job.Finished < oldest {
+			delete(b.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+	if len(finished) <= maxFinishedBroadcasts {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Finished < finished[j].Finished
+	})
+	for _, job := range finished[:len(finished)-maxFinishedBroadcasts] {
+		delete(b.jobs, job.ID)
+	}
+}
+
+func (b *serverNoticeBroadcasts) start(filter serverNoticeBroadcastFilter, total int, cancel context.CancelFunc) serverNoticeBroadcast {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(time.Now())
+	b.nextID++
+	job := &serverNoticeBroadcast{
+		ID:      fmt.Sprintf("%d-%s", b.nextID, util.RandomString(8)),
+		Status:  broadcastStatusRunning,
+		Filter:  filter,
+		Total:   total,
+		Started: spec.AsTimestamp(time.Now()),
+	}
+	b.jobs[job.ID] = job
+	b.cancels[job.ID] = cancel
+	return *job
+}
+
+func (b *serverNoticeBroadcasts) update(id string, f func(job *serverNoticeBroadcast)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if job, ok := b.jobs[id]; ok {
+		f(job)
+	}
+}
+
+// finish records how the broadcast ended, unless it was cancelled already.
+// A broadcast which ended because its context was cancelled, e.g. on
+// shutdown, is recorded as cancelled.
+func (b *serverNoticeBroadcasts) finish(id string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if !ok {
+		return
+	}
+	if cancel, ok := b.cancels[id]; ok {
+		cancel()
+		delete(b.cancels, id)
+	}
+	if job.Status != broadcastStatusRunning {
+		return
+	}
+	job.Finished = spec.AsTimestamp(time.Now())
+	switch {
+	case err == nil:
+		job.Status = broadcastStatusFinished
+	case errors.Is(err, context.Canceled):
+		job.Status = broadcastStatusCancelled
+	default:
+		job.Status = broadcastStatusFailed
+		job.Error = err.Error()
+	}
+}
+
+// cancel stops the broadcast if it is still running. Returns false if there
+// is no such broadcast.
+func (b *serverNoticeBroadcasts) cancel(id string) (serverNoticeBroadcast, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if !ok {
+		return serverNoticeBroadcast{}, false
+	}
+	if cancel, ok := b.cancels[id]; ok {
+		cancel()
+		delete(b.cancels, id)
+	}
+	if job.Status == broadcastStatusRunning {
+		job.Status = broadcastStatusCancelled
+		job.Finished = spec.AsTimestamp(time.Now())
+	}
+	return *job, true
+}
+
+func (b *serverNoticeBroadcasts) get(id string) (serverNoticeBroadcast, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if !ok {
+		return serverNoticeBroadcast{}, false
+	}
+	return *job, true
+}
+
+// list returns the broadcasts, newest first.
+func (b *serverNoticeBroadcasts) list() []serverNoticeBroadcast {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.prune(time.Now())
+	jobs := make([]serverNoticeBroadcast, 0, len(b.jobs))
+	for _, job := range b.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Started > jobs[j].Started
+	})
+	return jobs
+}
+
+// AdminBroadcastServerNotice starts sending a server notice to all of the
+// local users matching the filter in the background.
+func AdminBroadcastServerNotice(
+	req *http.Request,
+	processContext *process.ProcessContext,
+	broadcasts *serverNoticeBroadcasts,
+	cfgClient *config.ClientAPI,
+	userAPI userapi.ClientUserAPI,
+	rsAPI api.ClientRoomserverAPI,
+	asAPI appserviceAPI.AppServiceInternalAPI,
+	senderDevice *userapi.Device,
+) util.JSONResponse {
+	var r serverNoticeBroadcastRequest
+	if resErr := clientutil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	if err := r.validate(); err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam(err.Error()),
+		}
+	}
+
+	recipientsReq := &userapi.QueryServerNoticeRecipientsRequest{
+		AdminsOnly:     r.Filter.AdminsOnly,
+		LocalpartRegex: r.Filter.LocalpartRegex,
+	}
+	if r.Filter.ActiveDays > 0 {
+		recipientsReq.ActiveSince = spec.AsTimestamp(time.Now().AddDate(0, 0, -r.Filter.ActiveDays))
+	}
+	var recipientsRes userapi.QueryServerNoticeRecipientsResponse
+	if err := userAPI.QueryServerNoticeRecipients(req.Context(), recipientsReq, &recipientsRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryServerNoticeRecipients failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+
+	// The broadcast outlives the request, so its context is derived from the
+	// process instead, which stops it on shutdown.
+	ctx, cancel := context.WithCancel(processContext.Context())
+	job := broadcasts.start(r.Filter, len(recipientsRes.UserIDs), cancel)
+
+	go func() {
+		logger := logrus.WithField("broadcast_id", job.ID)
+		logger.WithField("recipients", job.Total).Info("Starting server notice broadcast")
+		for _, userID := range recipientsRes.UserIDs {
+			if err := ctx.Err(); err != nil {
+				broadcasts.finish(job.ID, err)
+				logger.Info("Server notice broadcast cancelled")
+				return
+			}
+			notice := sendServerNoticeRequest{UserID: userID}
+			notice.Content.MsgType = r.Content.MsgType
+			notice.Content.Body = r.Content.Body
+			res := sendServerNotice(
+				ctx, notice, &cfgClient.Matrix.ServerNotices, cfgClient, userAPI, rsAPI, asAPI,
+				senderDevice, cfgClient.Matrix.ServerName, nil,
+			)
+			broadcasts.update(job.ID, func(j *serverNoticeBroadcast) {
+				if res.Code == http.StatusOK {
+					j.Sent++
+				} else {
+					j.Failed++
+				}
+			})
+			if res.Code != http.StatusOK {
+				logger.WithField("user_id", userID).Warnf("Failed to send server notice: %+v", res.JSON)
+			}
+		}
+		broadcasts.finish(job.ID, nil)
+		logger.Info("Finished server notice broadcast")
+	}()
+
+	return util.JSONResponse{
+		Code: http.StatusAccepted,
+		JSON: job,
+	}
+}
+
+// AdminServerNoticeBroadcasts lists the broadcasts since startup.
+func AdminServerNoticeBroadcasts(broadcasts *serverNoticeBroadcasts) util.JSONResponse {
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: map[string]interface{}{
+			"broadcasts": broadcasts.list(),
+		},
+	}
+}
+
+// AdminServerNoticeBroadcast returns the progress of a broadcast, or cancels
+// it if the request is a DELETE.
+func AdminServerNoticeBroadcast(req *http.Request, broadcasts *serverNoticeBroadcasts) util.JSONResponse {
+	vars, err := httputil.URLDecodeMapValues(mux.Vars(req))
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	var job serverNoticeBroadcast
+	var ok bool
+	if req.Method == http.MethodDelete {
+		job, ok = broadcasts.cancel(vars["broadcastID"])
+	} else {
+		job, ok = broadcasts.get(vars["broadcastID"])
+	}
+	if !ok {
+		return util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound("Unknown broadcast"),
+		}
+	}
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: job,
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	appserviceAPI "github.com/jchv/maidtrix/appservice/api"
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/setup/config"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mRoomPinnedEvents = "m.room.pinned_events"
+	// https://spec.matrix.org/v1.7/client-server-api/#server-notices
+	serverNoticeTypeUsageLimit = "m.server_notice.usage_limit_reached"
+	// The only limit type in the spec. Our own limit types are namespaced.
+	limitTypeMonthlyActiveUsers = "monthly_active_user"
+	limitTypeMediaStorage       = "org.matrix.dendrite.media_storage"
+)
+
+// resourceLimitNotifier pins a notice in the server notices room of the
+// affected users for each resource limit which is exceeded, and unpins them
+// again once the limit is no longer exceeded. The pinned events are the
+// record of which notices have been sent, so nothing is lost on restart.
+type resourceLimitNotifier struct {
+	cfg          *config.ResourceLimitNotices
+	cfgClient    *config.ClientAPI
+	userAPI      userapi.ClientUserAPI
+	rsAPI        api.ClientRoomserverAPI
+	asAPI        appserviceAPI.AppServiceInternalAPI
+	senderDevice *userapi.Device
+	// Whether the last check found no limits exceeded and no notices left
+	// pinned, in which case there is nothing to do until a limit is exceeded.
+	cleared bool
+	// The limit types which each user is known to have notices pinned for,
+	// as a limitTypesKey. Users whose entry already matches are skipped, so
+	// the roomserver is only queried for everyone when the exceeded limits
+	// change, and otherwise only for new users.
+	pinned map[string]string
+}
+
+// limitTypesKey returns the limit types of the exceeded limits, sorted and
+// joined so that they can be compared.
+func limitTypesKey(exceeded map[string]string) string {
+	limitTypes := make([]string, 0, len(exceeded))
+	for limitType := range exceeded {
+		limitTypes = append(limitTypes, limitType)
+	}
+	sort.Strings(limitTypes)
+	return strings.Join(limitTypes, ",")
+}
+
+// exceededResourceLimits returns the message to send for each limit type
+// which the resource usage exceeds.
+func exceededResourceLimits(cfg *config.ResourceLimitNotices, usage *userapi.QueryResourceUsageResponse) map[string]string {
+	exceeded := map[string]string{}
+	if cfg.MaxMonthlyActiveUsers > 0 && usage.MonthlyActiveUsers >= cfg.MaxMonthlyActiveUsers {
+		exceeded[limitTypeMonthlyActiveUsers] = cfg.MonthlyActiveUsersMessage
+	}
+	if cfg.MaxMediaSize > 0 && usage.MediaSize >= int64(cfg.MaxMediaSize) {
+		exceeded[limitTypeMediaStorage] = cfg.MediaSizeMessage
+	}
+	return exceeded
+}
+
+// updatePinnedEvents returns the pinned events without the unpinned ones and
+// with the new ones added to the end, keeping the order of the rest.
+func updatePinnedEvents(pinned []string, unpin map[string]struct{}, pin []string) []string {
+	updated := make([]string, 0, len(pinned)+len(pin))
+	for _, eventID := range pinned {
+		if _, ok := unpin[eventID]; !ok {
+			updated = append(updated, eventID)
+		}
+	}
+	return append(updated, pin...)
+}
+
+func (n *resourceLimitNotifier) check(ctx context.Context) {
+	usage := &userapi.QueryResourceUsageResponse{}
+	if err := n.userAPI.QueryResourceUsage(ctx, &userapi.QueryResourceUsageRequest{}, usage); err != nil {
+		logrus.WithError(err).Error("Failed to get resource usage to check resource limits")
+		return
+	}
+	exceeded := exceededResourceLimits(n.cfg, usage)
+	if len(exceeded) == 0 && n.cleared {
+		return
+	}
+
+	// Everyone is checked so that notices are unpinned even for users who
+	// wouldn't be sent them any more, e.g. if notify_all_users was turned off.
+	var allRes, notifyRes userapi.QueryServerNoticeRecipientsResponse
+	if err := n.userAPI.QueryServerNoticeRecipients(ctx, &userapi.QueryServerNoticeRecipientsRequest{}, &allRes); err != nil {
+		logrus.WithError(err).Error("Failed to get users to send resource limit notices to")
+		return
+	}
+	if err := n.userAPI.QueryServerNoticeRecipients(ctx, &userapi.QueryServerNoticeRecipientsRequest{
+		AdminsOnly: !n.cfg.NotifyAllUsers,
+	}, &notifyRes); err != nil {
+		logrus.WithError(err).Error("Failed to get users to send resource limit notices to")
+		return
+	}
+	notify := make(map[string]struct{}, len(notifyRes.UserIDs))
+	for _, userID := range notifyRes.UserIDs {
+		notify[userID] = struct{}{}
+	}
+
+	if n.pinned == nil {
+		n.pinned = make(map[string]string, len(allRes.UserIDs))
+	}
+	failed := false
+	users := make(map[string]struct{}, len(allRes.UserIDs))
+	for _, userID := range allRes.UserIDs {
+		users[userID] = struct{}{}
+		userExceeded := exceeded
+		if _, ok := notify[userID]; !ok {
+			userExceeded = nil
+		}
+		key := limitTypesKey(userExceeded)
+		if pinned, ok := n.pinned[userID]; ok && pinned == key {
+			continue
+		}
+		if err := n.updateUser(ctx, userID, userExceeded); err != nil {
+			logrus.WithError(err).WithField("user_id", userID).Error("Failed to update resource limit notices")
+			delete(n.pinned, userID)
+			failed = true
+			continue
+		}
+		n.pinned[userID] = key
+	}
+	for userID := range n.pinned {
+		if _, ok := users[userID]; !ok {
+			delete(n.pinned, userID)
+		}
+	}
+	n.cleared = len(exceeded) == 0 && !failed
+}
+
+// updateUser sends and pins a notice for each exceeded limit which the user
+// doesn't have pinned already, and unpins the notices for all other limits.
+func (n *resourceLimitNotifier) updateUser(ctx context.Context, userIDStr string, exceeded map[string]string) error {
+	userID, err := spec.NewUserID(userIDStr, true)
+	if err != nil {
+		return err
+	}
+	roomID, err := findServerNoticeRoom(ctx, *userID, &n.cfgClient.Matrix.ServerNotices, n.cfgClient, n.rsAPI)
+	if err != nil {
+		return err
+	}
+	if roomID == "" && len(exceeded) == 0 {
+		return nil
+	}
+
+	var pinned []string
+	pinnedLimits := map[string]string{} // event ID -> limit type
+	if roomID != "" {
+		if pinned, pinnedLimits, err = n.pinnedNotices(ctx, roomID); err != nil {
+			return err
+		}
+	}
+	unpin := map[string]struct{}{}
+	have := map[string]struct{}{}
+	for eventID, limitType := range pinnedLimits {
+		if _, ok := exceeded[limitType]; ok {
+			have[limitType] = struct{}{}
+		} else {
+			unpin[eventID] = struct{}{}
+		}
+	}
+	var send []string
+	for limitType := range exceeded {
+		if _, ok := have[limitType]; !ok {
+			send = append(send, limitType)
+		}
+	}
+	if len(send) == 0 && len(unpin) == 0 {
+		return nil
+	}
+	sort.Strings(send)
+
+	var pin []string
+	if len(send) > 0 {
+		// Re-invite the user or create the room if needed.
+		var resErr *util.JSONResponse
+		roomID, resErr = serverNoticeRoom(ctx, userIDStr, &n.cfgClient.Matrix.ServerNotices, n.cfgClient, n.userAPI, n.rsAPI, n.asAPI, n.senderDevice)
+		if resErr != nil {
+			return fmt.Errorf("serverNoticeRoom: %+v", resErr.JSON)
+		}
+		for _, limitType := range send {
+			content := map[string]interface{}{
+				"msgtype":            "m.server_notice",
+				"body":               exceeded[limitType],
+				"server_notice_type": serverNoticeTypeUsageLimit,
+				"admin_contact":      n.cfg.AdminContact,
+				"limit_type":         limitType,
+			}
+			eventID, resErr := sendServerNoticeEvent(ctx, roomID, "m.room.message", nil, content, n.cfgClient, n.rsAPI, n.senderDevice, n.cfgClient.Matrix.ServerName, nil)
+			if resErr != nil {
+				return fmt.Errorf("sendServerNoticeEvent: %+v", resErr.JSON)
+			}
+			pin = append(pin, eventID)
+		}
+	}
+
+	stateKey := ""
+	content := map[string]interface{}{
+		"pinned": updatePinnedEvents(pinned, unpin, pin),
+	}
+	if _, resErr := sendServerNoticeEvent(ctx, roomID, mRoomPinnedEvents, &stateKey, content, n.cfgClient, n.rsAPI, n.senderDevice, n.cfgClient.Matrix.ServerName, nil); resErr != nil {
+		return fmt.Errorf("sendServerNoticeEvent: %+v", resErr.JSON)
+	}
+	logrus.WithFields(logrus.Fields{
+		"user_id":  userIDStr,
+		"pinned":   len(pin),
+		"unpinned": len(unpin),
+	}).Info("Updated resource limit notices")
+	return nil
+}
+
+// pinnedNotices returns the pinned events of the room, along with the limit
+// type of each of those which is a resource limit notice.
+func (n *resourceLimitNotifier) pinnedNotices(ctx context.Context, roomID string) ([]string, map[string]string, error) {
+	tuple := gomatrixserverlib.StateKeyTuple{EventType: mRoomPinnedEvents, StateKey: ""}
+	stateRes := &api.QueryCurrentStateResponse{}
+	if err := n.rsAPI.QueryCurrentState(ctx, &api.QueryCurrentStateRequest{
+		RoomID:      roomID,
+		StateTuples: []gomatrixserverlib.StateKeyTuple{tuple},
+	}, stateRes); err != nil {
+		return nil, nil, fmt.Errorf("n.rsAPI.QueryCurrentState: %w", err)
+	}
+	limits := map[string]string{}
+	pinnedEvent, ok := stateRes.StateEvents[tuple]
+	if !ok || pinnedEvent == nil {
+		return nil, limits, nil
+	}
+	var pinnedContent struct {
+		Pinned []string `json:"pinned"`
+	}
+	if err := json.Unmarshal(pinnedEvent.Content(), &pinnedContent); err != nil {
+		// Replace whatever is there if it is malformed.
+		return nil, limits, nil
+	}
+	if len(pinnedContent.Pinned) == 0 {
+		return nil, limits, nil
+	}
+
+	eventsRes := &api.QueryEventsByIDResponse{}
+	if err := n.rsAPI.QueryEventsByID(ctx, &api.QueryEventsByIDRequest{
+		RoomID:   roomID,
+		EventIDs: pinnedContent.Pinned,
+	}, eventsRes); err != nil {
+		return nil, nil, fmt.Errorf("n.rsAPI.QueryEventsByID: %w", err)
+	}
+	for _, event := range eventsRes.Events {
+		var notice struct {
+			NoticeType string `json:"server_notice_type"`
+			LimitType  string `json:"limit_type"`
+		}
+		if err := json.Unmarshal(event.Content(), &notice); err != nil {
+			continue
+		}
+		if notice.NoticeType == serverNoticeTypeUsageLimit && notice.LimitType != "" {
+			limits[event.EventID()] = notice.LimitType
+		}
+	}
+	return pinnedContent.Pinned, limits, nil
+}
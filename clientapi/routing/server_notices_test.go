@@ -1,7 +1,33 @@
 package routing
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/appservice"
+	appserviceAPI "github.com/jchv/maidtrix/appservice/api"
+	"github.com/jchv/maidtrix/internal/caching"
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/roomserver"
+	roomserverAPI "github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/setup/jetstream"
+	"github.com/jchv/maidtrix/setup/process"
+	"github.com/jchv/maidtrix/test"
+	"github.com/jchv/maidtrix/test/testrig"
+	"github.com/jchv/maidtrix/userapi"
+	uapi "github.com/jchv/maidtrix/userapi/api"
 )
 
 func Test_sendServerNoticeRequest_validate(t *testing.T) {
@@ -81,3 +107,397 @@ func Test_sendServerNoticeRequest_validate(t *testing.T) {
 		})
 	}
 }
+
+func Test_serverNoticeBroadcastRequest_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		filter  serverNoticeBroadcastFilter
+		wantErr bool
+	}{
+		{name: "no body", wantErr: true},
+		{name: "no filter", body: "Hello world!"},
+		{name: "all filters", body: "Hello world!", filter: serverNoticeBroadcastFilter{ActiveDays: 30, AdminsOnly: true, LocalpartRegex: "^staff-"}},
+		{name: "negative active days", body: "Hello world!", filter: serverNoticeBroadcastFilter{ActiveDays: -1}, wantErr: true},
+		{name: "invalid regex", body: "Hello world!", filter: serverNoticeBroadcastFilter{LocalpartRegex: "(["}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := serverNoticeBroadcastRequest{Filter: tt.filter}
+			r.Content.MsgType = "m.text"
+			r.Content.Body = tt.body
+			if err := r.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_serverNoticeBroadcasts(t *testing.T) {
+	broadcasts := newServerNoticeBroadcasts()
+	ctx, cancel := context.WithCancel(context.Background())
+	first := broadcasts.start(serverNoticeBroadcastFilter{}, 2, cancel)
+	second := broadcasts.start(serverNoticeBroadcastFilter{AdminsOnly: true}, 1, func() {})
+
+	broadcasts.update(first.ID, func(job *serverNoticeBroadcast) { job.Sent++ })
+	job, ok := broadcasts.cancel(first.ID)
+	if !ok || job.Status != broadcastStatusCancelled || job.Sent != 1 {
+		t.Fatalf("unexpected cancelled broadcast: %+v", job)
+	}
+	if ctx.Err() == nil {
+		t.Fatalf("cancelling the broadcast didn't cancel its context")
+	}
+	// A cancelled broadcast stays cancelled.
+	broadcasts.finish(first.ID, nil)
+	if job, _ = broadcasts.get(first.ID); job.Status != broadcastStatusCancelled {
+		t.Fatalf("expected broadcast to stay cancelled, got %q", job.Status)
+	}
+
+	broadcasts.finish(second.ID, nil)
+	if job, _ = broadcasts.get(second.ID); job.Status != broadcastStatusFinished || job.Finished == 0 {
+		t.Fatalf("unexpected finished broadcast: %+v", job)
+	}
+	if _, ok = broadcasts.get("unknown"); ok {
+		t.Fatalf("expected unknown broadcast not to be found")
+	}
+	if jobs := broadcasts.list(); len(jobs) != 2 {
+		t.Fatalf("expected 2 broadcasts, got %d", len(jobs))
+	}
+
+	// A broadcast stopped by shutdown is recorded as cancelled.
+	third := broadcasts.start(serverNoticeBroadcastFilter{}, 1, func() {})
+	broadcasts.finish(third.ID, context.Canceled)
+	if job, _ = broadcasts.get(third.ID); job.Status != broadcastStatusCancelled || job.Finished == 0 {
+		t.Fatalf("unexpected broadcast stopped by shutdown: %+v", job)
+	}
+}
+
+func Test_serverNoticeBroadcasts_prune(t *testing.T) {
+	broadcasts := newServerNoticeBroadcasts()
+	running := broadcasts.start(serverNoticeBroadcastFilter{}, 1, func() {})
+	old := broadcasts.start(serverNoticeBroadcastFilter{}, 1, func() {})
+	broadcasts.finish(old.ID, nil)
+	broadcasts.update(old.ID, func(job *serverNoticeBroadcast) {
+		job.Finished = spec.AsTimestamp(time.Now().Add(-broadcastRetention * 2))
+	})
+	if _, ok := broadcasts.get(old.ID); !ok {
+		t.Fatalf("expected the old broadcast to be kept until the next prune")
+	}
+	for i := 0; i < maxFinishedBroadcasts+10; i++ {
+		job := broadcasts.start(serverNoticeBroadcastFilter{}, 1, func() {})
+		broadcasts.finish(job.ID, nil)
+	}
+	jobs := broadcasts.list()
+	if len(jobs) != maxFinishedBroadcasts+1 {
+		t.Fatalf("expected %d broadcasts, got %d", maxFinishedBroadcasts+1, len(jobs))
+	}
+	if _, ok := broadcasts.get(old.ID); ok {
+		t.Fatalf("expected the old broadcast to be forgotten")
+	}
+	if _, ok := broadcasts.get(running.ID); !ok {
+		t.Fatalf("expected the running broadcast to be kept")
+	}
+}
+
+func Test_userLocks(t *testing.T) {
+	locks := &userLocks{locks: map[string]*userLock{}}
+	unlock := locks.lock("@alice:test")
+	locked := make(chan struct{})
+	go func() {
+		defer locks.lock("@alice:test")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("expected the second caller to wait for the lock")
+	case <-time.After(time.Millisecond * 50):
+	}
+	unlock()
+	<-locked
+	locks.lock("@bob:test")()
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Fatalf("expected unused locks to be dropped, got %d", len(locks.locks))
+	}
+}
+
+func Test_exceededResourceLimits(t *testing.T) {
+	cfg := &config.ResourceLimitNotices{}
+	cfg.Defaults()
+	usage := &uapi.QueryResourceUsageResponse{MonthlyActiveUsers: 100, MediaSize: 1000}
+
+	if exceeded := exceededResourceLimits(cfg, usage); len(exceeded) != 0 {
+		t.Fatalf("expected disabled limits not to be exceeded, got %v", exceeded)
+	}
+
+	cfg.MaxMonthlyActiveUsers = 100
+	cfg.MaxMediaSize = 1001
+	want := map[string]string{limitTypeMonthlyActiveUsers: cfg.MonthlyActiveUsersMessage}
+	if exceeded := exceededResourceLimits(cfg, usage); !reflect.DeepEqual(exceeded, want) {
+		t.Fatalf("exceededResourceLimits() = %v, want %v", exceeded, want)
+	}
+
+	usage.MediaSize = 2000
+	want[limitTypeMediaStorage] = cfg.MediaSizeMessage
+	if exceeded := exceededResourceLimits(cfg, usage); !reflect.DeepEqual(exceeded, want) {
+		t.Fatalf("exceededResourceLimits() = %v, want %v", exceeded, want)
+	}
+}
+
+func Test_limitTypesKey(t *testing.T) {
+	if key := limitTypesKey(nil); key != "" {
+		t.Fatalf("limitTypesKey(nil) = %q, want empty", key)
+	}
+	a := limitTypesKey(map[string]string{limitTypeMonthlyActiveUsers: "a", limitTypeMediaStorage: "b"})
+	b := limitTypesKey(map[string]string{limitTypeMediaStorage: "c", limitTypeMonthlyActiveUsers: "d"})
+	if a != b {
+		t.Fatalf("limitTypesKey() differs by message or order: %q != %q", a, b)
+	}
+	if c := limitTypesKey(map[string]string{limitTypeMediaStorage: "b"}); c == a {
+		t.Fatalf("limitTypesKey() = %q for different limit types", c)
+	}
+}
+
+func Test_updatePinnedEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		pinned []string
+		unpin  map[string]struct{}
+		pin    []string
+		want   []string
+	}{
+		{name: "nothing pinned", pin: []string{"$a"}, want: []string{"$a"}},
+		{name: "keeps existing order", pinned: []string{"$b", "$a"}, pin: []string{"$c"}, want: []string{"$b", "$a", "$c"}},
+		{name: "unpins", pinned: []string{"$a", "$b", "$c"}, unpin: map[string]struct{}{"$b": {}}, want: []string{"$a", "$c"}},
+		{name: "unpins everything", pinned: []string{"$a"}, unpin: map[string]struct{}{"$a": {}}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := updatePinnedEvents(tt.pinned, tt.unpin, tt.pin); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("updatePinnedEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// serverNoticesTestAPIs creates the APIs needed to send server notices, and
+// accounts for the given users.
+func serverNoticesTestAPIs(t *testing.T, dbType test.DBType, users ...*test.User) (
+	*config.Dendrite, *process.ProcessContext, uapi.ClientUserAPI, roomserverAPI.ClientRoomserverAPI, appserviceAPI.AppServiceInternalAPI, *uapi.Device, func(),
+) {
+	t.Helper()
+	ctx := context.Background()
+	cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+	cfg.Global.ServerNotices.Enabled = true
+	cfg.Global.ServerNotices.LocalPart = "_server"
+	cfg.Global.ServerNotices.DisplayName = "Server Alert"
+	cfg.Global.ServerNotices.RoomName = "Server Alert"
+	cfg.MediaAPI.AbsBasePath = config.Path(t.TempDir())
+
+	cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+	caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+	natsInstance := jetstream.NATSInstance{}
+	rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+	rsAPI.SetFederationAPI(nil, nil)
+	userAPI := userapi.NewInternalAPI(processCtx, cfg, cm, &natsInstance, rsAPI, nil, caching.DisableMetrics, testIsBlacklistedOrBackingOff)
+	asAPI := appservice.NewInternalAPI(processCtx, cfg, &natsInstance, userAPI, rsAPI)
+
+	for _, u := range users {
+		localpart, serverName, _ := gomatrixserverlib.SplitID('@', u.ID)
+		if err := userAPI.PerformAccountCreation(ctx, &uapi.PerformAccountCreationRequest{
+			AccountType: u.AccountType,
+			Localpart:   localpart,
+			ServerName:  serverName,
+			Password:    "someRandomPassword",
+		}, &uapi.PerformAccountCreationResponse{}); err != nil {
+			t.Fatalf("failed to create account: %s", err)
+		}
+	}
+
+	senderDevice, err := getSenderDevice(ctx, rsAPI, userAPI, &cfg.ClientAPI)
+	if err != nil {
+		t.Fatalf("failed to get the sender device: %s", err)
+	}
+	return cfg, processCtx, userAPI, rsAPI, asAPI, senderDevice, close
+}
+
+// pinnedLimitTypes returns the limit types of the notices pinned in the
+// user's server notices room, or nil if they don't have one.
+func pinnedLimitTypes(t *testing.T, ctx context.Context, cfg *config.ClientAPI, rsAPI roomserverAPI.ClientRoomserverAPI, user *test.User) []string {
+	t.Helper()
+	userID, err := spec.NewUserID(user.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roomID, err := findServerNoticeRoom(ctx, *userID, &cfg.Matrix.ServerNotices, cfg, rsAPI)
+	if err != nil {
+		t.Fatalf("failed to find the server notices room: %s", err)
+	}
+	if roomID == "" {
+		return nil
+	}
+
+	tuple := gomatrixserverlib.StateKeyTuple{EventType: mRoomPinnedEvents, StateKey: ""}
+	stateRes := &roomserverAPI.QueryCurrentStateResponse{}
+	if err = rsAPI.QueryCurrentState(ctx, &roomserverAPI.QueryCurrentStateRequest{
+		RoomID:      roomID,
+		StateTuples: []gomatrixserverlib.StateKeyTuple{tuple},
+	}, stateRes); err != nil {
+		t.Fatalf("failed to query the pinned events: %s", err)
+	}
+	pinnedEvent, ok := stateRes.StateEvents[tuple]
+	if !ok {
+		t.Fatalf("expected %s in %s", mRoomPinnedEvents, roomID)
+	}
+	var content struct {
+		Pinned []string `json:"pinned"`
+	}
+	if err = json.Unmarshal(pinnedEvent.Content(), &content); err != nil {
+		t.Fatalf("failed to unmarshal the pinned events: %s", err)
+	}
+	limitTypes := []string{}
+	if len(content.Pinned) == 0 {
+		return limitTypes
+	}
+
+	eventsRes := &roomserverAPI.QueryEventsByIDResponse{}
+	if err = rsAPI.QueryEventsByID(ctx, &roomserverAPI.QueryEventsByIDRequest{
+		RoomID:   roomID,
+		EventIDs: content.Pinned,
+	}, eventsRes); err != nil {
+		t.Fatalf("failed to query the pinned notices: %s", err)
+	}
+	if len(eventsRes.Events) != len(content.Pinned) {
+		t.Fatalf("expected %d pinned notices, got %d", len(content.Pinned), len(eventsRes.Events))
+	}
+	for _, event := range eventsRes.Events {
+		var notice struct {
+			NoticeType string `json:"server_notice_type"`
+			LimitType  string `json:"limit_type"`
+		}
+		if err = json.Unmarshal(event.Content(), &notice); err != nil {
+			t.Fatalf("failed to unmarshal the pinned notice: %s", err)
+		}
+		if notice.NoticeType != serverNoticeTypeUsageLimit {
+			t.Fatalf("expected server_notice_type %q, got %q", serverNoticeTypeUsageLimit, notice.NoticeType)
+		}
+		limitTypes = append(limitTypes, notice.LimitType)
+	}
+	sort.Strings(limitTypes)
+	return limitTypes
+}
+
+func TestResourceLimitNotifier(t *testing.T) {
+	admin := test.NewUser(t, test.WithAccountType(uapi.AccountTypeAdmin))
+	user := test.NewUser(t, test.WithAccountType(uapi.AccountTypeUser))
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, _, userAPI, rsAPI, asAPI, senderDevice, close := serverNoticesTestAPIs(t, dbType, admin, user)
+		defer close()
+
+		// The sender device was just seen, so there is one monthly active user.
+		if err := os.WriteFile(filepath.Join(string(cfg.MediaAPI.AbsBasePath), "file"), make([]byte, 10), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		limits := &config.ResourceLimitNotices{}
+		limits.Defaults()
+		limits.MaxMonthlyActiveUsers = 1
+		limits.MaxMediaSize = 10
+		notifier := &resourceLimitNotifier{
+			cfg:          limits,
+			cfgClient:    &cfg.ClientAPI,
+			userAPI:      userAPI,
+			rsAPI:        rsAPI,
+			asAPI:        asAPI,
+			senderDevice: senderDevice,
+		}
+
+		want := []string{limitTypeMonthlyActiveUsers, limitTypeMediaStorage}
+		notifier.check(ctx)
+		if got := pinnedLimitTypes(t, ctx, &cfg.ClientAPI, rsAPI, admin); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected the admin to have %v notices pinned, got %v", want, got)
+		}
+		if got := pinnedLimitTypes(t, ctx, &cfg.ClientAPI, rsAPI, user); got != nil {
+			t.Fatalf("expected the user not to be sent notices, got %v", got)
+		}
+
+		// Checking again, e.g. after a restart, doesn't pin the notices twice.
+		notifier.pinned = nil
+		notifier.check(ctx)
+		if got := pinnedLimitTypes(t, ctx, &cfg.ClientAPI, rsAPI, admin); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected the admin to have %v notices pinned, got %v", want, got)
+		}
+
+		// Only the notice for the limit which is no longer exceeded is unpinned.
+		limits.MaxMediaSize = 100
+		notifier.check(ctx)
+		want = []string{limitTypeMonthlyActiveUsers}
+		if got := pinnedLimitTypes(t, ctx, &cfg.ClientAPI, rsAPI, admin); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected the admin to have %v notices pinned, got %v", want, got)
+		}
+
+		limits.MaxMonthlyActiveUsers = 100
+		notifier.check(ctx)
+		if got := pinnedLimitTypes(t, ctx, &cfg.ClientAPI, rsAPI, admin); len(got) != 0 {
+			t.Fatalf("expected the notices to be unpinned, got %v", got)
+		}
+		if !notifier.cleared {
+			t.Fatalf("expected the notifier to be cleared")
+		}
+	})
+}
+
+func TestAdminBroadcastServerNotice(t *testing.T) {
+	admin := test.NewUser(t, test.WithAccountType(uapi.AccountTypeAdmin))
+	user := test.NewUser(t, test.WithAccountType(uapi.AccountTypeUser))
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, userAPI, rsAPI, asAPI, senderDevice, close := serverNoticesTestAPIs(t, dbType, admin, user)
+		defer close()
+
+		broadcasts := newServerNoticeBroadcasts()
+		body := `{"content":{"msgtype":"m.text","body":"Hello world!"},"filter":{}}`
+		req := httptest.NewRequest(http.MethodPost, "/_synapse/admin/v1/send_server_notice/broadcast", strings.NewReader(body))
+		res := AdminBroadcastServerNotice(req, processCtx, broadcasts, &cfg.ClientAPI, userAPI, rsAPI, asAPI, senderDevice)
+		if res.Code != http.StatusAccepted {
+			t.Fatalf("expected status %d, got %d: %+v", http.StatusAccepted, res.Code, res.JSON)
+		}
+		job, ok := res.JSON.(serverNoticeBroadcast)
+		if !ok {
+			t.Fatalf("response is not a serverNoticeBroadcast: %+v", res.JSON)
+		}
+		if job.Total != 2 {
+			t.Fatalf("expected 2 recipients, got %d", job.Total)
+		}
+
+		deadline := time.Now().Add(time.Second * 30)
+		for job.Status == broadcastStatusRunning {
+			if time.Now().After(deadline) {
+				t.Fatalf("broadcast didn't finish: %+v", job)
+			}
+			time.Sleep(time.Millisecond * 50)
+			if job, ok = broadcasts.get(job.ID); !ok {
+				t.Fatalf("broadcast %s was forgotten", job.ID)
+			}
+		}
+		if job.Status != broadcastStatusFinished || job.Sent != job.Total || job.Failed != 0 {
+			t.Fatalf("expected all notices to be sent, got %+v", job)
+		}
+
+		for _, u := range []*test.User{admin, user} {
+			userID, err := spec.NewUserID(u.ID, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roomID, err := findServerNoticeRoom(ctx, *userID, &cfg.ClientAPI.Matrix.ServerNotices, &cfg.ClientAPI, rsAPI)
+			if err != nil {
+				t.Fatalf("failed to find the server notices room: %s", err)
+			}
+			if roomID == "" {
+				t.Fatalf("expected %s to have a server notices room", u.ID)
+			}
+		}
+	})
+}
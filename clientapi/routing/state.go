@@ -47,6 +47,19 @@ func OnIncomingStateRequest(ctx context.Context, device *userapi.Device, rsAPI a
 	var worldReadable bool
 	var wantLatestState bool
 
+	// The state of a room that was joined with partial state is missing the
+	// memberships until the roomserver has finished fetching the full state.
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("RoomID is invalid"),
+		}
+	}
+	if resErr := waitForFullState(ctx, rsAPI, *validRoomID); resErr != nil {
+		return *resErr
+	}
+
 	// First of all, get the latest state of the room. We need to do this
 	// so that we can look at the history visibility of the room. If the
 	// room is world-readable then we will always return the latest state.
@@ -19,6 +19,7 @@ import (
 	"database/sql"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/jchv/maidtrix/clientapi/auth/authtypes"
@@ -36,10 +37,14 @@ type UserDirectoryResponse struct {
 	Limited bool                              `json:"limited"`
 }
 
+// SearchUserDirectory implements POST /user_directory/search. It returns the
+// users found in the user directory, followed by the users who share a room
+// with the searcher, ranked by how well they match the search string.
 func SearchUserDirectory(
 	ctx context.Context,
 	device *userapi.Device,
 	rsAPI api.ClientRoomserverAPI,
+	userAPI userapi.ClientUserAPI,
 	provider userapi.QuerySearchProfilesAPI,
 	searchString string,
 	limit int,
@@ -56,6 +61,19 @@ func SearchUserDirectory(
 		Limited: false,
 	}
 
+	// Get users from the user directory
+	directoryRes := &userapi.QuerySearchUserDirectoryResponse{}
+	if err := userAPI.QuerySearchUserDirectory(ctx, &userapi.QuerySearchUserDirectoryRequest{
+		SearchString: searchString,
+		Limit:        limit,
+	}, directoryRes); err != nil {
+		return util.ErrorResponse(fmt.Errorf("userAPI.QuerySearchUserDirectory: %w", err))
+	}
+	for _, profile := range directoryRes.Profiles {
+		results[profile.UserID] = profile
+	}
+	response.Limited = directoryRes.Limited
+
 	// Get users we share a room with
 	knownUsersReq := &api.QueryKnownUsersRequest{
 		UserID: device.UserID,
@@ -73,6 +91,9 @@ knownUsersLoop:
 			break
 		}
 		userID := profile.UserID
+		if _, ok := results[userID]; ok {
+			continue
+		}
 		// get the full profile of the local user
 		localpart, serverName, _ := gomatrixserverlib.SplitID('@', userID)
 		if serverName == localServerName {
@@ -131,9 +152,38 @@ knownUsersLoop:
 	for _, result := range results {
 		response.Results = append(response.Results, result)
 	}
+	sort.Slice(response.Results, func(i, j int) bool {
+		ri := searchRank(response.Results[i], searchString)
+		rj := searchRank(response.Results[j], searchString)
+		if ri != rj {
+			return ri < rj
+		}
+		return response.Results[i].UserID < response.Results[j].UserID
+	})
 
 	return util.JSONResponse{
 		Code: 200,
 		JSON: response,
 	}
 }
+
+// searchRank returns how well the profile matches the search string, lower
+// is better: the exact localpart, then a localpart starting with the search
+// string, then a display name with a word starting with it, then anything
+// else. This is the same ranking as the user directory uses.
+func searchRank(profile authtypes.FullyQualifiedProfile, searchString string) int {
+	searchString = strings.ToLower(searchString)
+	localpart, _, _ := gomatrixserverlib.SplitID('@', profile.UserID)
+	localpart = strings.ToLower(localpart)
+	displayName := strings.ToLower(profile.DisplayName)
+	switch {
+	case localpart == searchString:
+		return 0
+	case strings.HasPrefix(localpart, searchString):
+		return 1
+	case strings.HasPrefix(displayName, searchString) || strings.Contains(displayName, " "+searchString):
+		return 2
+	default:
+		return 3
+	}
+}
@@ -115,7 +115,7 @@ func main() {
 	// setup tracing
 	closer, err := cfg.SetupTracing()
 	if err != nil {
-		logrus.WithError(err).Panicf("failed to start opentracing")
+		logrus.WithError(err).Panicf("failed to start tracing")
 	}
 	defer closer.Close() // nolint: errcheck
 
@@ -184,6 +184,7 @@ func main() {
 		UserAPI:       userAPI,
 	}
 	monolith.AddAllPublicRoutes(processCtx, cfg, routers, cm, &natsInstance, caches, caching.EnableMetrics)
+	basepkg.RegisterHealthChecks(processCtx, cfg)
 
 	if len(cfg.MSCs.MSCs) > 0 {
 		if err := mscs.Enable(cfg, cm, routers, &monolith, caches); err != nil {
@@ -96,7 +96,7 @@ func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msgs []*nats.Ms
 
 	// Only handle events we care about, avoids unneeded unmarshalling
 	switch receivedType {
-	case api.OutputTypeNewRoomEvent, api.OutputTypeNewInboundPeek, api.OutputTypePurgeRoom:
+	case api.OutputTypeNewRoomEvent, api.OutputTypeNewInboundPeek, api.OutputTypePurgeRoom, api.OutputTypeRoomStateResynced:
 	default:
 		return true
 	}
@@ -112,7 +112,7 @@ func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msgs []*nats.Ms
 	switch output.Type {
 	case api.OutputTypeNewRoomEvent:
 		ev := output.NewRoomEvent.Event
-		if err := s.processMessage(*output.NewRoomEvent, output.NewRoomEvent.RewritesState); err != nil {
+		if err := s.processMessage(ctx, *output.NewRoomEvent, output.NewRoomEvent.RewritesState); err != nil {
 			// panic rather than continue with an inconsistent database
 			log.WithFields(log.Fields{
 				"event_id":   ev.EventID(),
@@ -132,6 +132,15 @@ func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msgs []*nats.Ms
 			return false
 		}
 
+	case api.OutputTypeRoomStateResynced:
+		if err := s.processRoomStateResynced(ctx, *output.RoomStateResynced); err != nil {
+			log.WithFields(log.Fields{
+				"room_id":    output.RoomStateResynced.RoomID,
+				log.ErrorKey: err,
+			}).Error("roomserver output log: failed to update joined hosts after partial state resync")
+			return false
+		}
+
 	case api.OutputTypePurgeRoom:
 		log.WithField("room_id", output.PurgeRoom.RoomID).Warn("Purging room from federation API")
 		if err := s.db.PurgeRoom(ctx, output.PurgeRoom.RoomID); err != nil {
@@ -168,7 +177,7 @@ func (s *OutputRoomEventConsumer) processInboundPeek(orp api.OutputNewInboundPee
 
 // processMessage updates the list of currently joined hosts in the room
 // and then sends the event to the hosts that were joined before the event.
-func (s *OutputRoomEventConsumer) processMessage(ore api.OutputNewRoomEvent, rewritesState bool) error {
+func (s *OutputRoomEventConsumer) processMessage(ctx context.Context, ore api.OutputNewRoomEvent, rewritesState bool) error {
 
 	addsStateEvents, missingEventIDs := ore.NeededStateEventIDs()
 
@@ -247,10 +256,39 @@ func (s *OutputRoomEventConsumer) processMessage(ore api.OutputNewRoomEvent, rew
 
 	// Send the event.
 	return s.queues.SendEvent(
-		ore.Event, spec.ServerName(ore.SendAsServer), joinedHostsAtEvent,
+		ctx, ore.Event, spec.ServerName(ore.SendAsServer), joinedHostsAtEvent,
 	)
 }
 
+// processRoomStateResynced updates the joined hosts with the memberships that
+// were missing from a room that was joined with partial state, now that the
+// roomserver has fetched the full state. The servers that we were using as
+// the joined hosts in the meantime are no longer needed.
+func (s *OutputRoomEventConsumer) processRoomStateResynced(ctx context.Context, ors api.OutputRoomStateResynced) error {
+	var evs []gomatrixserverlib.PDU
+	if len(ors.AddsStateEventIDs) > 0 {
+		eventsReq := &api.QueryEventsByIDRequest{
+			RoomID:   ors.RoomID,
+			EventIDs: ors.AddsStateEventIDs,
+		}
+		eventsRes := &api.QueryEventsByIDResponse{}
+		if err := s.rsAPI.QueryEventsByID(ctx, eventsReq, eventsRes); err != nil {
+			return fmt.Errorf("s.rsAPI.QueryEventsByID: %w", err)
+		}
+		for _, ev := range eventsRes.Events {
+			evs = append(evs, ev.PDU)
+		}
+	}
+	addsJoinedHosts, err := JoinedHostsFromEvents(ctx, evs, s.rsAPI)
+	if err != nil {
+		return err
+	}
+	if _, err = s.db.UpdateRoom(ctx, ors.RoomID, addsJoinedHosts, ors.RemovesStateEventIDs, false); err != nil {
+		return fmt.Errorf("s.db.UpdateRoom: %w", err)
+	}
+	return s.db.ClearPartialStateServers(ctx, ors.RoomID)
+}
+
 func (s *OutputRoomEventConsumer) sendPresence(roomID string, addedJoined []types.JoinedHost) {
 	joined := make([]spec.ServerName, 0, len(addedJoined))
 	for _, added := range addedJoined {
@@ -384,6 +422,16 @@ func (s *OutputRoomEventConsumer) joinedHostsAtEvent(
 		joined[inboundPeek.ServerName] = true
 	}
 
+	// handle rooms that were joined with partial state, where we don't know
+	// all of the members yet
+	partialStateServers, err := s.db.GetPartialStateServers(s.ctx, ore.Event.PDU.RoomID().String())
+	if err != nil {
+		return nil, err
+	}
+	for _, serverName := range partialStateServers {
+		joined[serverName] = true
+	}
+
 	var result []spec.ServerName
 	for serverName, include := range joined {
 		if include {
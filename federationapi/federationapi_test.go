@@ -162,6 +162,12 @@ func (f *fedClient) SendJoin(ctx context.Context, origin, s spec.ServerName, eve
 	return
 }
 
+// SendJoinPartialState behaves like a resident server that doesn't support
+// partial state joins, which responds with the full state.
+func (f *fedClient) SendJoinPartialState(ctx context.Context, origin, s spec.ServerName, event gomatrixserverlib.PDU) (res fclient.RespSendJoin, err error) {
+	return f.SendJoin(ctx, origin, s, event)
+}
+
 func (f *fedClient) SendTransaction(ctx context.Context, t gomatrixserverlib.Transaction) (res fclient.RespSend, err error) {
 	f.fedClientMutex.Lock()
 	defer f.fedClientMutex.Unlock()
@@ -38,6 +38,18 @@ func (a *FederationInternalAPI) SendJoin(
 	return &ires, nil
 }
 
+func (a *FederationInternalAPI) SendJoinPartialState(
+	ctx context.Context, origin, s spec.ServerName, event gomatrixserverlib.PDU,
+) (res gomatrixserverlib.SendJoinResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+	ires, err := a.federation.SendJoinPartialState(ctx, origin, s, event)
+	if err != nil {
+		return &fclient.RespSendJoin{}, err
+	}
+	return &ires, nil
+}
+
 func (a *FederationInternalAPI) GetEventAuth(
 	ctx context.Context, origin, s spec.ServerName,
 	roomVersion gomatrixserverlib.RoomVersion, roomID, eventID string,
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/jchv/maidtrix/internal/matrix"
@@ -165,6 +166,10 @@ func (r *FederationInternalAPI) performJoinUsingServer(
 		PrivateKey: r.cfg.Matrix.PrivateKey,
 		KeyID:      r.cfg.Matrix.KeyID,
 		KeyRing:    r.keyRing,
+		// Ask the resident server to leave out the member events so that we
+		// don't have to wait for the full state of large rooms. The rest of
+		// the state is fetched in the background by the roomserver.
+		PartialState: true,
 		EventProvider: federatedEventProvider(ctx, r.federation, r.keyRing, user.Domain(), serverName, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 			return r.rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
 		}),
@@ -222,6 +227,32 @@ func (r *FederationInternalAPI) performJoinUsingServer(
 		return fmt.Errorf("UpdatedRoom: failed to update room with joined hosts: %s", err)
 	}
 
+	if response.PartialState {
+		// We don't know who is in the room yet, so make sure that we keep
+		// talking to the servers that were in the room when we joined until
+		// the roomserver has fetched the full state.
+		servers := response.ServersInRoom
+		if !slices.Contains(servers, serverName) {
+			servers = append(servers, serverName)
+		}
+		if err = r.db.SetPartialStateServers(context.Background(), roomID, servers); err != nil {
+			return fmt.Errorf("SetPartialStateServers: %w", err)
+		}
+		if err = roomserverAPI.SendEventWithPartialState(
+			context.Background(),
+			r.rsAPI,
+			user.Domain(),
+			response.StateSnapshot,
+			&types.HeaderedEvent{PDU: response.JoinEvent},
+			serverName,
+			servers,
+			false,
+		); err != nil {
+			return fmt.Errorf("roomserverAPI.SendEventWithPartialState: %w", err)
+		}
+		return nil
+	}
+
 	// TODO: Can I change this to not take respState but instead just take an opaque list of events?
 	if err = roomserverAPI.SendEventWithState(
 		context.Background(),
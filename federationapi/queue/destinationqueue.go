@@ -27,10 +27,14 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/jchv/maidtrix/federationapi/statistics"
 	"github.com/jchv/maidtrix/federationapi/storage"
 	"github.com/jchv/maidtrix/federationapi/storage/shared/receipt"
+	"github.com/jchv/maidtrix/internal"
 	"github.com/jchv/maidtrix/roomserver/types"
 	"github.com/jchv/maidtrix/setup/process"
 )
@@ -70,7 +74,7 @@ type destinationQueue struct {
 // Send event adds the event to the pending queue for the destination.
 // If the queue is empty then it starts a background goroutine to
 // start sending events to that destination.
-func (oq *destinationQueue) sendEvent(event *types.HeaderedEvent, dbReceipt *receipt.Receipt) {
+func (oq *destinationQueue) sendEvent(event *types.HeaderedEvent, dbReceipt *receipt.Receipt, spanContext trace.SpanContext) {
 	if event == nil {
 		logrus.Errorf("attempt to send nil PDU with destination %q", oq.destination)
 		return
@@ -84,9 +88,11 @@ func (oq *destinationQueue) sendEvent(event *types.HeaderedEvent, dbReceipt *rec
 		oq.pendingMutex.Lock()
 		if len(oq.pendingPDUs) < maxPDUsInMemory {
 			oq.pendingPDUs = append(oq.pendingPDUs, &queuedPDU{
-				pdu:       event,
-				dbReceipt: dbReceipt,
+				pdu:         event,
+				dbReceipt:   dbReceipt,
+				spanContext: spanContext,
 			})
+			destinationQueuePendingPDUs.Inc()
 		} else {
 			oq.overflowed.Store(true)
 		}
@@ -118,6 +124,7 @@ func (oq *destinationQueue) sendEDU(event *gomatrixserverlib.EDU, dbReceipt *rec
 				edu:       event,
 				dbReceipt: dbReceipt,
 			})
+			destinationQueuePendingEDUs.Inc()
 		} else {
 			oq.overflowed.Store(true)
 		}
@@ -212,6 +219,11 @@ func (oq *destinationQueue) getPendingFromDatabase() {
 	for _, pdu := range oq.pendingPDUs {
 		gotPDUs[pdu.dbReceipt.String()] = struct{}{}
 	}
+	pduCount, eduCount := len(oq.pendingPDUs), len(oq.pendingEDUs)
+	defer func() {
+		destinationQueuePendingPDUs.Add(float64(len(oq.pendingPDUs) - pduCount))
+		destinationQueuePendingEDUs.Add(float64(len(oq.pendingEDUs) - eduCount))
+	}()
 	for _, edu := range oq.pendingEDUs {
 		gotEDUs[edu.dbReceipt.String()] = struct{}{}
 	}
@@ -227,7 +239,7 @@ func (oq *destinationQueue) getPendingFromDatabase() {
 				if _, ok := gotPDUs[receipt.String()]; ok {
 					continue
 				}
-				oq.pendingPDUs = append(oq.pendingPDUs, &queuedPDU{receipt, pdu})
+				oq.pendingPDUs = append(oq.pendingPDUs, &queuedPDU{dbReceipt: receipt, pdu: pdu})
 				retrieved = true
 				if len(oq.pendingPDUs) == maxPDUsInMemory {
 					break
@@ -413,6 +425,24 @@ func (oq *destinationQueue) nextTransaction(
 	ctx, cancel := context.WithTimeout(oq.process.Context(), time.Minute*5)
 	defer cancel()
 
+	// Continue the trace of the first traced PDU in the transaction and
+	// link to the traces of the rest, so that the delivery of each event
+	// shows up in the trace that produced it.
+	ctx, span := startTransactionSpan(ctx, pdus)
+	span.SetAttributes(
+		attribute.String("destination", string(oq.destination)),
+		attribute.String("txn_id", string(t.TransactionID)),
+		attribute.Int("pdus", len(t.PDUs)),
+		attribute.Int("edus", len(t.EDUs)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	relayServers := oq.statistics.KnownRelayServers()
 	hasRelayServers := len(relayServers) > 0
 	shouldSendToRelays := oq.statistics.AssumedOffline() && hasRelayServers
@@ -562,6 +592,8 @@ func (oq *destinationQueue) blacklistDestination() {
 	logrus.Warnf("Blacklisting %q due to exceeding backoff threshold", oq.destination)
 
 	oq.pendingMutex.Lock()
+	destinationQueuePendingPDUs.Sub(float64(len(oq.pendingPDUs)))
+	destinationQueuePendingEDUs.Sub(float64(len(oq.pendingEDUs)))
 	for i := range oq.pendingPDUs {
 		oq.pendingPDUs[i] = nil
 	}
@@ -596,6 +628,8 @@ func (oq *destinationQueue) handleTransactionSuccess(pduCount int, eduCount int,
 	}
 	oq.pendingPDUs = oq.pendingPDUs[pduCount:]
 	oq.pendingEDUs = oq.pendingEDUs[eduCount:]
+	destinationQueuePendingPDUs.Sub(float64(pduCount))
+	destinationQueuePendingEDUs.Sub(float64(eduCount))
 
 	if len(oq.pendingPDUs) > 0 || len(oq.pendingEDUs) > 0 {
 		select {
@@ -604,3 +638,28 @@ func (oq *destinationQueue) handleTransactionSuccess(pduCount int, eduCount int,
 		}
 	}
 }
+
+// startTransactionSpan starts a span for sending a transaction containing
+// the given PDUs.
+func startTransactionSpan(ctx context.Context, pdus []*queuedPDU) (context.Context, trace.Span) {
+	var parent trace.SpanContext
+	var links []trace.Link
+	for _, pdu := range pdus {
+		if pdu == nil || !pdu.spanContext.IsValid() {
+			continue
+		}
+		if !parent.IsValid() {
+			parent = pdu.spanContext
+			continue
+		}
+		links = append(links, trace.Link{SpanContext: pdu.spanContext})
+	}
+	if parent.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, parent)
+	}
+	return internal.Tracer().Start(
+		ctx, "SendTransaction",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+	)
+}
@@ -15,6 +15,7 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
@@ -27,6 +28,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/jchv/maidtrix/federationapi/statistics"
 	"github.com/jchv/maidtrix/federationapi/storage"
@@ -52,7 +54,8 @@ type OutgoingQueues struct {
 func init() {
 	prometheus.MustRegister(
 		destinationQueueTotal, destinationQueueRunning,
-		destinationQueueBackingOff,
+		destinationQueueBackingOff, destinationQueuePendingPDUs,
+		destinationQueuePendingEDUs,
 	)
 }
 
@@ -80,6 +83,24 @@ var destinationQueueBackingOff = prometheus.NewGauge(
 	},
 )
 
+var destinationQueuePendingPDUs = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "federationapi",
+		Name:      "destination_queues_pending_pdus",
+		Help:      "Number of PDUs held in memory across all destination queues",
+	},
+)
+
+var destinationQueuePendingEDUs = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "federationapi",
+		Name:      "destination_queues_pending_edus",
+		Help:      "Number of EDUs held in memory across all destination queues",
+	},
+)
+
 // NewOutgoingQueues makes a new OutgoingQueues
 func NewOutgoingQueues(
 	db storage.Database,
@@ -137,6 +158,9 @@ func NewOutgoingQueues(
 type queuedPDU struct {
 	dbReceipt *receipt.Receipt
 	pdu       *types.HeaderedEvent
+	// The span context at the time the PDU was queued, if any, so that
+	// the transaction which eventually sends it can continue the trace.
+	spanContext trace.SpanContext
 }
 
 type queuedEDU struct {
@@ -182,7 +206,7 @@ func (oqs *OutgoingQueues) clearQueue(oq *destinationQueue) {
 
 // SendEvent sends an event to the destinations
 func (oqs *OutgoingQueues) SendEvent(
-	ev *types.HeaderedEvent, origin spec.ServerName,
+	ctx context.Context, ev *types.HeaderedEvent, origin spec.ServerName,
 	destinations []spec.ServerName,
 ) error {
 	if oqs.disabled {
@@ -251,8 +275,9 @@ func (oqs *OutgoingQueues) SendEvent(
 	// them, otherwise this is technically a race.
 	// If the send completes before they are associated then they won't
 	// get properly cleaned up in the database.
+	spanContext := trace.SpanContextFromContext(ctx)
 	for _, queue := range destQueues {
-		queue.sendEvent(ev, nid)
+		queue.sendEvent(ev, nid, spanContext)
 	}
 
 	return nil
@@ -142,7 +142,7 @@ func TestSendPDUOnSuccessRemovedFromDB(t *testing.T) {
 	}()
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -200,7 +200,7 @@ func TestSendPDUOnFailStoredInDB(t *testing.T) {
 	}()
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -260,7 +260,7 @@ func TestSendPDUAgainDoesntInterruptBackoff(t *testing.T) {
 	}()
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -279,7 +279,7 @@ func TestSendPDUAgainDoesntInterruptBackoff(t *testing.T) {
 
 	fc.shouldTxSucceed = true
 	ev = mustCreatePDU(t)
-	err = queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err = queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	pollEnd := time.Now().Add(1 * time.Second)
@@ -362,7 +362,7 @@ func TestSendPDUMultipleFailuresBlacklisted(t *testing.T) {
 	}()
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -428,7 +428,7 @@ func TestSendPDUBlacklistedWithPriorExternalFailure(t *testing.T) {
 	queues.statistics.ForServer(destination).Failure()
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -497,7 +497,7 @@ func TestRetryServerSendsPDUSuccessfully(t *testing.T) {
 	// before it is blacklisted and deleted.
 	dest := queues.getQueue(destination)
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	checkBlacklisted := func(log poll.LogT) poll.Result {
@@ -610,7 +610,7 @@ func TestSendPDUBatches(t *testing.T) {
 	}
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -780,7 +780,7 @@ func TestQueueInteractsWithRealDatabasePDUAndEDU(t *testing.T) {
 		// before it is blacklisted and deleted.
 		dest := queues.getQueue(destination)
 		ev := mustCreatePDU(t)
-		err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+		err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 		assert.NoError(t, err)
 
 		// NOTE : The server can be blacklisted before this, so manually inject the event
@@ -842,7 +842,7 @@ func TestSendPDUMultipleFailuresAssumedOffline(t *testing.T) {
 	}()
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
@@ -911,7 +911,7 @@ func TestSendPDUOnRelaySuccessRemovedFromDB(t *testing.T) {
 	queues.statistics.ForServer(destination).AddRelayServers(relayServers)
 
 	ev := mustCreatePDU(t)
-	err := queues.SendEvent(ev, "localhost", []spec.ServerName{destination})
+	err := queues.SendEvent(context.Background(), ev, "localhost", []spec.ServerName{destination})
 	assert.NoError(t, err)
 
 	check := func(log poll.LogT) poll.Result {
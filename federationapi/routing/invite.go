@@ -315,6 +315,12 @@ func handleInviteResult(ctx context.Context, inviteEvent gomatrixserverlib.PDU,
 
 	headeredInvite := &types.HeaderedEvent{PDU: inviteEvent}
 	if err = rsAPI.HandleInvite(ctx, headeredInvite); err != nil {
+		if _, ok := err.(api.ErrNotAllowed); ok {
+			return nil, &util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: spec.Forbidden(err.Error()),
+			}
+		}
 		util.GetLogger(ctx).WithError(err).Error("HandleInvite failed")
 		return nil, &util.JSONResponse{
 			Code: http.StatusInternalServerError,
@@ -51,6 +51,19 @@ func MakeJoin(
 		}
 	}
 
+	// We can't build a join event that the joining server can verify
+	// until we know the full state of the room ourselves.
+	if resErr := ErrorIfRoomPartialState(httpReq.Context(), rsAPI, roomID.String()); resErr != nil {
+		return *resErr
+	}
+
+	if rsAPI.IsBannedByPolicyList(httpReq.Context(), roomID.String(), userID, spec.Join) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("You are not allowed to join this room"),
+		}
+	}
+
 	req := api.QueryServerJoinedToRoomRequest{
 		ServerName: request.Destination(),
 		RoomID:     roomID.String(),
@@ -275,6 +288,14 @@ func SendJoin(
 
 	}
 
+	joiningUserID, err := rsAPI.QueryUserIDForSender(httpReq.Context(), roomID, spec.SenderID(*response.JoinEvent.StateKey()))
+	if err == nil && joiningUserID != nil && rsAPI.IsBannedByPolicyList(httpReq.Context(), roomID.String(), *joiningUserID, spec.Join) {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("You are not allowed to join this room"),
+		}
+	}
+
 	// Fetch the state and auth chain. We do this before we send the events
 	// on, in case this fails.
 	var stateAndAuthChainResponse api.QueryStateAndAuthChainResponse
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
@@ -73,12 +74,26 @@ func publicRooms(
 	var queryRes roomserverAPI.QueryPublishedRoomsResponse
 	err = rsAPI.QueryPublishedRooms(ctx, &roomserverAPI.QueryPublishedRoomsRequest{
 		NetworkID: request.NetworkID,
+		RoomTypes: request.Filter.RoomTypes,
 	}, &queryRes)
 	if err != nil {
 		util.GetLogger(ctx).WithError(err).Error("QueryPublishedRooms failed")
 		return nil, err
 	}
-	response.TotalRoomCountEstimate = len(queryRes.RoomIDs)
+
+	// Searching needs the state of every published room, so fill in all of
+	// them before paginating. Otherwise only fill in the requested page.
+	var rooms []fclient.PublicRoom
+	total := len(queryRes.RoomIDs)
+	if request.Filter.SearchTerms != "" {
+		rooms, err = fillInRooms(ctx, queryRes.RoomIDs, queryRes.RoomTypes, rsAPI)
+		if err != nil {
+			return nil, err
+		}
+		rooms = searchRooms(rooms, request.Filter.SearchTerms)
+		total = len(rooms)
+	}
+	response.TotalRoomCountEstimate = total
 
 	if offset > 0 {
 		response.PrevBatch = strconv.Itoa(int(offset) - 1)
@@ -91,14 +106,36 @@ func publicRooms(
 	if offset < 0 {
 		offset = 0
 	}
-	if nextIndex > len(queryRes.RoomIDs) {
-		nextIndex = len(queryRes.RoomIDs)
+	if nextIndex > total {
+		nextIndex = total
+	}
+	if offset > int64(nextIndex) {
+		offset = int64(nextIndex)
+	}
+	if rooms != nil {
+		response.Chunk = rooms[offset:nextIndex]
+		return &response, nil
 	}
 	roomIDs := queryRes.RoomIDs[offset:nextIndex]
-	response.Chunk, err = fillInRooms(ctx, roomIDs, rsAPI)
+	response.Chunk, err = fillInRooms(ctx, roomIDs, queryRes.RoomTypes, rsAPI)
 	return &response, err
 }
 
+// searchRooms returns the rooms whose name, topic or canonical alias contain
+// the search term, ignoring case.
+func searchRooms(rooms []fclient.PublicRoom, searchTerm string) []fclient.PublicRoom {
+	normalizedTerm := strings.ToLower(searchTerm)
+	result := make([]fclient.PublicRoom, 0, len(rooms))
+	for _, room := range rooms {
+		if strings.Contains(strings.ToLower(room.Name), normalizedTerm) ||
+			strings.Contains(strings.ToLower(room.Topic), normalizedTerm) ||
+			strings.Contains(strings.ToLower(room.CanonicalAlias), normalizedTerm) {
+			result = append(result, room)
+		}
+	}
+	return result
+}
+
 // fillPublicRoomsReq fills the Limit, Since and Filter attributes of a GET or POST request
 // on /publicRooms by parsing the incoming HTTP request
 // Filter is only filled for POST requests
@@ -128,7 +165,7 @@ func fillPublicRoomsReq(httpReq *http.Request, request *PublicRoomReq) *util.JSO
 }
 
 // due to lots of switches
-func fillInRooms(ctx context.Context, roomIDs []string, rsAPI roomserverAPI.FederationRoomserverAPI) ([]fclient.PublicRoom, error) {
+func fillInRooms(ctx context.Context, roomIDs []string, roomTypes map[string]string, rsAPI roomserverAPI.FederationRoomserverAPI) ([]fclient.PublicRoom, error) {
 	avatarTuple := gomatrixserverlib.StateKeyTuple{EventType: "m.room.avatar", StateKey: ""}
 	nameTuple := gomatrixserverlib.StateKeyTuple{EventType: "m.room.name", StateKey: ""}
 	canonicalTuple := gomatrixserverlib.StateKeyTuple{EventType: spec.MRoomCanonicalAlias, StateKey: ""}
@@ -154,7 +191,8 @@ func fillInRooms(ctx context.Context, roomIDs []string, rsAPI roomserverAPI.Fede
 	i := 0
 	for roomID, data := range stateRes.Rooms {
 		pub := fclient.PublicRoom{
-			RoomID: roomID,
+			RoomID:   roomID,
+			RoomType: roomTypes[roomID],
 		}
 		joinCount := 0
 		var joinRule, guestAccess string
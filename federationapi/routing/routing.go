@@ -31,6 +31,7 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/roomserver/api"
 	roomserverAPI "github.com/jchv/maidtrix/roomserver/api"
@@ -135,12 +136,13 @@ func Setup(
 	v2keysmux.Handle("/query/{serverName}/{keyID}", notaryKeys).Methods(http.MethodGet)
 
 	mu := internal.NewMutexByRoom()
+	spamChecker := spamcheck.New(&cfg.Matrix.SpamChecker)
 	v1fedmux.Handle("/send/{txnID}", MakeFedAPI(
 		"federation_send", cfg.Matrix.ServerName, cfg.Matrix.IsLocalServerName, keys, wakeup,
 		func(httpReq *http.Request, request *fclient.FederationRequest, vars map[string]string) util.JSONResponse {
 			return Send(
 				httpReq, request, gomatrixserverlib.TransactionID(vars["txnID"]),
-				cfg, rsAPI, userAPI, keys, federation, mu, producer,
+				cfg, rsAPI, userAPI, keys, federation, mu, producer, spamChecker,
 			)
 		},
 	)).Methods(http.MethodPut, http.MethodOptions).Name(SendRouteName)
@@ -630,6 +632,35 @@ func ErrorIfLocalServerNotInRoom(
 	return nil
 }
 
+// ErrorIfRoomPartialState returns an error if the room was joined with partial
+// state and we haven't fetched the full state yet, since we can't answer
+// requests that depend on the state of the room correctly until then.
+func ErrorIfRoomPartialState(
+	ctx context.Context,
+	rsAPI api.FederationRoomserverAPI,
+	roomID string,
+) *util.JSONResponse {
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return &util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid room ID"),
+		}
+	}
+	partialState, err := rsAPI.IsRoomPartialState(ctx, *validRoomID)
+	if err != nil {
+		res := util.ErrorResponse(err)
+		return &res
+	}
+	if partialState {
+		return &util.JSONResponse{
+			Code: http.StatusNotFound,
+			JSON: spec.NotFound(fmt.Sprintf("This server is not fully joined to room %s", roomID)),
+		}
+	}
+	return nil
+}
+
 // MakeFedAPI makes an http.Handler that checks matrix federation authentication.
 func MakeFedAPI(
 	metricsName string, serverName spec.ServerName,
@@ -645,6 +676,12 @@ func MakeFedAPI(
 		if fedReq == nil {
 			return errResp
 		}
+		// add the origin and any room or event IDs to the logger
+		logger := util.GetLogger(req.Context()).WithFields(logrus.Fields{
+			"component": "federationapi",
+			"origin":    fedReq.Origin(),
+		}).WithFields(httputil.RequestLogFields(req))
+		req = req.WithContext(util.ContextWithLogger(req.Context(), logger))
 		// add the user to Sentry, if enabled
 		hub := sentry.GetHubFromContext(req.Context())
 		if hub != nil {
@@ -702,6 +739,12 @@ func MakeFedHTTPAPI(
 			}
 			return
 		}
+		// add the origin and any room or event IDs to the logger
+		logger = logger.WithFields(logrus.Fields{
+			"component": "federationapi",
+			"origin":    fedReq.Origin(),
+		}).WithFields(httputil.RequestLogFields(req))
+		req = req.WithContext(util.ContextWithLogger(req.Context(), logger))
 		// add the user to Sentry, if enabled
 		hub := sentry.GetHubFromContext(req.Context())
 		if hub != nil {
@@ -28,6 +28,7 @@ import (
 	"github.com/jchv/maidtrix/federationapi/producers"
 	"github.com/jchv/maidtrix/internal"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/setup/config"
 	userAPI "github.com/jchv/maidtrix/userapi/api"
@@ -64,6 +65,7 @@ func Send(
 	federation fclient.FederationClient,
 	mu *internal.MutexByRoom,
 	producer *producers.SyncAPIProducer,
+	spamChecker *spamcheck.Checker,
 ) util.JSONResponse {
 	// First we should check if this origin has already submitted this
 	// txn ID to us. If they have and the txnIDs map contains an entry,
@@ -124,6 +126,7 @@ func Send(
 		mu,
 		producer,
 		cfg.Matrix.Presence.EnableInbound,
+		spamChecker,
 		txnEvents.PDUs,
 		txnEvents.EDUs,
 		request.Origin(),
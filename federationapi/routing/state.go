@@ -107,6 +107,9 @@ func getState(
 	if err := ErrorIfLocalServerNotInRoom(ctx, rsAPI, roomID); err != nil {
 		return nil, nil, err
 	}
+	if err := ErrorIfRoomPartialState(ctx, rsAPI, roomID); err != nil {
+		return nil, nil, err
+	}
 
 	event, resErr := fetchEvent(ctx, rsAPI, roomID, eventID)
 	if resErr != nil {
@@ -8,12 +8,44 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/jchv/maidtrix/federationapi/storage"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 )
 
+func init() {
+	prometheus.MustRegister(backoffsStarted, serversAssumedOffline, serversBlacklisted)
+}
+
+var backoffsStarted = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "federationapi",
+		Name:      "backoffs_started_total",
+		Help:      "Number of times a backoff was started for a destination after a failure",
+	},
+)
+
+var serversAssumedOffline = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "federationapi",
+		Name:      "servers_assumed_offline_total",
+		Help:      "Number of times a destination was marked as assumed offline",
+	},
+)
+
+var serversBlacklisted = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "federationapi",
+		Name:      "servers_blacklisted_total",
+		Help:      "Number of times a destination was blacklisted after too many failures",
+	},
+)
+
 // Statistics contains information about all of the remote federated
 // hosts that we have interacted with. It is basically a threadsafe
 // wrapper.
@@ -198,7 +230,9 @@ func (s *ServerStatistics) Failure() (time.Time, bool) {
 		backoffCount := s.backoffCount.Add(1)
 
 		if backoffCount >= s.statistics.FailuresUntilAssumedOffline {
-			s.assumedOffline.CompareAndSwap(false, true)
+			if s.assumedOffline.CompareAndSwap(false, true) {
+				serversAssumedOffline.Inc()
+			}
 			if s.statistics.DB != nil {
 				if err := s.statistics.DB.SetServerAssumedOffline(context.Background(), s.serverName); err != nil {
 					logrus.WithError(err).Errorf("Failed to set %q as assumed offline", s.serverName)
@@ -208,6 +242,7 @@ func (s *ServerStatistics) Failure() (time.Time, bool) {
 
 		if backoffCount >= s.statistics.FailuresUntilBlacklist {
 			s.blacklisted.Store(true)
+			serversBlacklisted.Inc()
 			if s.statistics.DB != nil {
 				if err := s.statistics.DB.AddServerToBlacklist(s.serverName); err != nil {
 					logrus.WithError(err).Errorf("Failed to add %q to blacklist", s.serverName)
@@ -219,6 +254,7 @@ func (s *ServerStatistics) Failure() (time.Time, bool) {
 
 		// We're starting a new back off so work out what the next interval
 		// will be.
+		backoffsStarted.Inc()
 		count := s.backoffCount.Load()
 		until := time.Now().Add(s.duration(count))
 		s.backoffUntil.Store(until)
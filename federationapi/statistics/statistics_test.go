@@ -7,6 +7,7 @@ import (
 
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -115,3 +116,26 @@ func TestRelayServersListing(t *testing.T) {
 	relayServers = server.KnownRelayServers()
 	assert.Equal(t, []spec.ServerName{"relay1", "relay2"}, relayServers)
 }
+
+func TestBackoffMetrics(t *testing.T) {
+	stats := NewStatistics(nil, FailuresUntilBlacklist, FailuresUntilAssumedOffline, false)
+	server := ServerStatistics{
+		statistics: &stats,
+		serverName: "metrics.test",
+	}
+
+	started := testutil.ToFloat64(backoffsStarted)
+	offline := testutil.ToFloat64(serversAssumedOffline)
+	blacklisted := testutil.ToFloat64(serversBlacklisted)
+
+	// Each failure starts a new backoff, since we clear the backoff in
+	// between, until the server is blacklisted.
+	for i := 0; i < FailuresUntilBlacklist; i++ {
+		server.Failure()
+		server.backoffStarted.Store(false)
+	}
+
+	assert.Equal(t, started+FailuresUntilBlacklist-1, testutil.ToFloat64(backoffsStarted))
+	assert.Equal(t, offline+1, testutil.ToFloat64(serversAssumedOffline))
+	assert.Equal(t, blacklisted+1, testutil.ToFloat64(serversBlacklisted))
+}
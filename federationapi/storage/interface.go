@@ -37,6 +37,11 @@ type Database interface {
 	// GetJoinedHostsForRooms returns the complete set of servers in the rooms given.
 	GetJoinedHostsForRooms(ctx context.Context, roomIDs []string, excludeSelf, excludeBlacklisted bool) ([]spec.ServerName, error)
 
+	// SetPartialStateServers stores the servers that were in a room when it was joined with partial state.
+	SetPartialStateServers(ctx context.Context, roomID string, serverNames []spec.ServerName) error
+	GetPartialStateServers(ctx context.Context, roomID string) ([]spec.ServerName, error)
+	ClearPartialStateServers(ctx context.Context, roomID string) error
+
 	StoreJSON(ctx context.Context, js string) (*receipt.Receipt, error)
 
 	GetPendingPDUs(ctx context.Context, serverName spec.ServerName, limit int) (pdus map[*receipt.Receipt]*rstypes.HeaderedEvent, err error)
@@ -0,0 +1,121 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/lib/pq"
+)
+
+const partialStateServersSchema = `
+-- The partial_state_servers table stores the servers that were in a room
+-- when we joined it with partial state. Until the full state of the room
+-- has been fetched we don't know the memberships in the room, so these
+-- are used as the joined hosts in addition to those in the joined_hosts
+-- table.
+CREATE TABLE IF NOT EXISTS federationsender_partial_state_servers (
+    -- The string ID of the room.
+    room_id TEXT NOT NULL,
+    -- The name of a server that was in the room when we joined.
+    server_name TEXT NOT NULL,
+    CONSTRAINT federationsender_partial_state_servers_unique UNIQUE (room_id, server_name)
+);
+`
+
+const insertPartialStateServerSQL = "" +
+	"INSERT INTO federationsender_partial_state_servers (room_id, server_name)" +
+	" VALUES ($1, $2) ON CONFLICT DO NOTHING"
+
+const deletePartialStateServersSQL = "" +
+	"DELETE FROM federationsender_partial_state_servers WHERE room_id = $1"
+
+const selectPartialStateServersForRoomsSQL = "" +
+	"SELECT DISTINCT server_name FROM federationsender_partial_state_servers WHERE room_id = ANY($1)"
+
+const selectPartialStateServersForRoomsExcludingBlacklistedSQL = "" +
+	"SELECT DISTINCT server_name FROM federationsender_partial_state_servers p WHERE room_id = ANY($1) AND NOT EXISTS (" +
+	"  SELECT server_name FROM federationsender_blacklist WHERE p.server_name = server_name" +
+	")"
+
+type partialStateServersStatements struct {
+	db                                                        *sql.DB
+	insertPartialStateServerStmt                              *sql.Stmt
+	deletePartialStateServersStmt                             *sql.Stmt
+	selectPartialStateServersForRoomsStmt                     *sql.Stmt
+	selectPartialStateServersForRoomsExcludingBlacklistedStmt *sql.Stmt
+}
+
+func NewPostgresPartialStateServersTable(db *sql.DB) (s *partialStateServersStatements, err error) {
+	s = &partialStateServersStatements{
+		db: db,
+	}
+	_, err = db.Exec(partialStateServersSchema)
+	if err != nil {
+		return
+	}
+
+	return s, sqlutil.StatementList{
+		{&s.insertPartialStateServerStmt, insertPartialStateServerSQL},
+		{&s.deletePartialStateServersStmt, deletePartialStateServersSQL},
+		{&s.selectPartialStateServersForRoomsStmt, selectPartialStateServersForRoomsSQL},
+		{&s.selectPartialStateServersForRoomsExcludingBlacklistedStmt, selectPartialStateServersForRoomsExcludingBlacklistedSQL},
+	}.Prepare(db)
+}
+
+func (s *partialStateServersStatements) InsertPartialStateServer(
+	ctx context.Context, txn *sql.Tx, roomID string, serverName spec.ServerName,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.insertPartialStateServerStmt)
+	_, err := stmt.ExecContext(ctx, roomID, serverName)
+	return err
+}
+
+func (s *partialStateServersStatements) DeletePartialStateServers(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deletePartialStateServersStmt)
+	_, err := stmt.ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *partialStateServersStatements) SelectPartialStateServersForRooms(
+	ctx context.Context, roomIDs []string, excludingBlacklisted bool,
+) ([]spec.ServerName, error) {
+	stmt := s.selectPartialStateServersForRoomsStmt
+	if excludingBlacklisted {
+		stmt = s.selectPartialStateServersForRoomsExcludingBlacklistedStmt
+	}
+	rows, err := stmt.QueryContext(ctx, pq.StringArray(roomIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectPartialStateServersForRoomsStmt: rows.close() failed")
+
+	var result []spec.ServerName
+	for rows.Next() {
+		var serverName string
+		if err = rows.Scan(&serverName); err != nil {
+			return nil, err
+		}
+		result = append(result, spec.ServerName(serverName))
+	}
+
+	return result, rows.Err()
+}
@@ -50,6 +50,10 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 	if err != nil {
 		return nil, err
 	}
+	partialStateServers, err := NewPostgresPartialStateServersTable(d.db)
+	if err != nil {
+		return nil, err
+	}
 	queuePDUs, err := NewPostgresQueuePDUsTable(d.db)
 	if err != nil {
 		return nil, err
@@ -103,22 +107,23 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 		return nil, err
 	}
 	d.Database = shared.Database{
-		DB:                       d.db,
-		IsLocalServerName:        isLocalServerName,
-		Cache:                    cache,
-		Writer:                   d.writer,
-		FederationJoinedHosts:    joinedHosts,
-		FederationQueuePDUs:      queuePDUs,
-		FederationQueueEDUs:      queueEDUs,
-		FederationQueueJSON:      queueJSON,
-		FederationBlacklist:      blacklist,
-		FederationAssumedOffline: assumedOffline,
-		FederationRelayServers:   relayServers,
-		FederationInboundPeeks:   inboundPeeks,
-		FederationOutboundPeeks:  outboundPeeks,
-		NotaryServerKeysJSON:     notaryJSON,
-		NotaryServerKeysMetadata: notaryMetadata,
-		ServerSigningKeys:        serverSigningKeys,
+		DB:                            d.db,
+		IsLocalServerName:             isLocalServerName,
+		Cache:                         cache,
+		Writer:                        d.writer,
+		FederationJoinedHosts:         joinedHosts,
+		FederationPartialStateServers: partialStateServers,
+		FederationQueuePDUs:           queuePDUs,
+		FederationQueueEDUs:           queueEDUs,
+		FederationQueueJSON:           queueJSON,
+		FederationBlacklist:           blacklist,
+		FederationAssumedOffline:      assumedOffline,
+		FederationRelayServers:        relayServers,
+		FederationInboundPeeks:        inboundPeeks,
+		FederationOutboundPeeks:       outboundPeeks,
+		NotaryServerKeysJSON:          notaryJSON,
+		NotaryServerKeysMetadata:      notaryMetadata,
+		ServerSigningKeys:             serverSigningKeys,
 	}
 	return &d, nil
 }
@@ -18,6 +18,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/jchv/maidtrix/federationapi/storage/shared/receipt"
@@ -30,22 +31,23 @@ import (
 )
 
 type Database struct {
-	DB                       *sql.DB
-	IsLocalServerName        func(spec.ServerName) bool
-	Cache                    caching.FederationCache
-	Writer                   sqlutil.Writer
-	FederationQueuePDUs      tables.FederationQueuePDUs
-	FederationQueueEDUs      tables.FederationQueueEDUs
-	FederationQueueJSON      tables.FederationQueueJSON
-	FederationJoinedHosts    tables.FederationJoinedHosts
-	FederationBlacklist      tables.FederationBlacklist
-	FederationAssumedOffline tables.FederationAssumedOffline
-	FederationRelayServers   tables.FederationRelayServers
-	FederationOutboundPeeks  tables.FederationOutboundPeeks
-	FederationInboundPeeks   tables.FederationInboundPeeks
-	NotaryServerKeysJSON     tables.FederationNotaryServerKeysJSON
-	NotaryServerKeysMetadata tables.FederationNotaryServerKeysMetadata
-	ServerSigningKeys        tables.FederationServerSigningKeys
+	DB                            *sql.DB
+	IsLocalServerName             func(spec.ServerName) bool
+	Cache                         caching.FederationCache
+	Writer                        sqlutil.Writer
+	FederationQueuePDUs           tables.FederationQueuePDUs
+	FederationQueueEDUs           tables.FederationQueueEDUs
+	FederationQueueJSON           tables.FederationQueueJSON
+	FederationJoinedHosts         tables.FederationJoinedHosts
+	FederationPartialStateServers tables.FederationPartialStateServers
+	FederationBlacklist           tables.FederationBlacklist
+	FederationAssumedOffline      tables.FederationAssumedOffline
+	FederationRelayServers        tables.FederationRelayServers
+	FederationOutboundPeeks       tables.FederationOutboundPeeks
+	FederationInboundPeeks        tables.FederationInboundPeeks
+	NotaryServerKeysJSON          tables.FederationNotaryServerKeysJSON
+	NotaryServerKeysMetadata      tables.FederationNotaryServerKeysMetadata
+	ServerSigningKeys             tables.FederationServerSigningKeys
 }
 
 // UpdateRoom updates the joined hosts for a room and returns what the joined
@@ -95,7 +97,21 @@ func (d *Database) UpdateRoom(
 func (d *Database) GetJoinedHosts(
 	ctx context.Context, roomID string,
 ) ([]types.JoinedHost, error) {
-	return d.FederationJoinedHosts.SelectJoinedHosts(ctx, roomID)
+	joinedHosts, err := d.FederationJoinedHosts.SelectJoinedHosts(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	// If the room was joined with partial state then we don't know all of
+	// the members yet, so include the servers that were in the room when
+	// we joined. These have no member event.
+	partialStateServers, err := d.FederationPartialStateServers.SelectPartialStateServersForRooms(ctx, []string{roomID}, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, serverName := range partialStateServers {
+		joinedHosts = append(joinedHosts, types.JoinedHost{ServerName: serverName})
+	}
+	return joinedHosts, nil
 }
 
 // GetAllJoinedHosts returns the currently joined hosts for
@@ -117,6 +133,15 @@ func (d *Database) GetJoinedHostsForRooms(
 	if err != nil {
 		return nil, err
 	}
+	partialStateServers, err := d.FederationPartialStateServers.SelectPartialStateServersForRooms(ctx, roomIDs, excludeBlacklisted)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range partialStateServers {
+		if !slices.Contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
 	if excludeSelf {
 		for i, server := range servers {
 			if d.IsLocalServerName(server) {
@@ -129,6 +154,40 @@ func (d *Database) GetJoinedHostsForRooms(
 	return servers, nil
 }
 
+// SetPartialStateServers stores the servers that were in a room when it
+// was joined with partial state, so that they can be treated as joined
+// hosts until the full state of the room is known.
+func (d *Database) SetPartialStateServers(
+	ctx context.Context, roomID string, serverNames []spec.ServerName,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		for _, serverName := range serverNames {
+			if err := d.FederationPartialStateServers.InsertPartialStateServer(ctx, txn, roomID, serverName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPartialStateServers returns the servers that were in a room when it
+// was joined with partial state, or nothing if the room has full state.
+func (d *Database) GetPartialStateServers(
+	ctx context.Context, roomID string,
+) ([]spec.ServerName, error) {
+	return d.FederationPartialStateServers.SelectPartialStateServersForRooms(ctx, []string{roomID}, false)
+}
+
+// ClearPartialStateServers forgets the servers that were in a room when it
+// was joined with partial state, once the full state of the room is known.
+func (d *Database) ClearPartialStateServers(
+	ctx context.Context, roomID string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.FederationPartialStateServers.DeletePartialStateServers(ctx, txn, roomID)
+	})
+}
+
 // StoreJSON adds a JSON blob into the queue JSON table and returns
 // a NID. The NID will then be used when inserting the per-destination
 // metadata entries.
@@ -380,6 +439,9 @@ func (d *Database) PurgeRoom(ctx context.Context, roomID string) error {
 		if err := d.FederationJoinedHosts.DeleteJoinedHostsForRoom(ctx, txn, roomID); err != nil {
 			return fmt.Errorf("failed to purge joined hosts: %w", err)
 		}
+		if err := d.FederationPartialStateServers.DeletePartialStateServers(ctx, txn, roomID); err != nil {
+			return fmt.Errorf("failed to purge partial state servers: %w", err)
+		}
 		if err := d.FederationInboundPeeks.DeleteInboundPeeks(ctx, txn, roomID); err != nil {
 			return fmt.Errorf("failed to purge inbound peeks: %w", err)
 		}
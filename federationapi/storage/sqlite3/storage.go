@@ -48,6 +48,10 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 	if err != nil {
 		return nil, err
 	}
+	partialStateServers, err := NewSQLitePartialStateServersTable(d.db)
+	if err != nil {
+		return nil, err
+	}
 	queuePDUs, err := NewSQLiteQueuePDUsTable(d.db)
 	if err != nil {
 		return nil, err
@@ -101,22 +105,23 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 		return nil, err
 	}
 	d.Database = shared.Database{
-		DB:                       d.db,
-		IsLocalServerName:        isLocalServerName,
-		Cache:                    cache,
-		Writer:                   d.writer,
-		FederationJoinedHosts:    joinedHosts,
-		FederationQueuePDUs:      queuePDUs,
-		FederationQueueEDUs:      queueEDUs,
-		FederationQueueJSON:      queueJSON,
-		FederationBlacklist:      blacklist,
-		FederationAssumedOffline: assumedOffline,
-		FederationRelayServers:   relayServers,
-		FederationOutboundPeeks:  outboundPeeks,
-		FederationInboundPeeks:   inboundPeeks,
-		NotaryServerKeysJSON:     notaryKeys,
-		NotaryServerKeysMetadata: notaryKeysMetadata,
-		ServerSigningKeys:        serverSigningKeys,
+		DB:                            d.db,
+		IsLocalServerName:             isLocalServerName,
+		Cache:                         cache,
+		Writer:                        d.writer,
+		FederationJoinedHosts:         joinedHosts,
+		FederationPartialStateServers: partialStateServers,
+		FederationQueuePDUs:           queuePDUs,
+		FederationQueueEDUs:           queueEDUs,
+		FederationQueueJSON:           queueJSON,
+		FederationBlacklist:           blacklist,
+		FederationAssumedOffline:      assumedOffline,
+		FederationRelayServers:        relayServers,
+		FederationOutboundPeeks:       outboundPeeks,
+		FederationInboundPeeks:        inboundPeeks,
+		NotaryServerKeysJSON:          notaryKeys,
+		NotaryServerKeysMetadata:      notaryKeysMetadata,
+		ServerSigningKeys:             serverSigningKeys,
 	}
 	return &d, nil
 }
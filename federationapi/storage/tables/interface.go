@@ -73,6 +73,12 @@ type FederationJoinedHosts interface {
 	SelectJoinedHostsForRooms(ctx context.Context, roomIDs []string, excludingBlacklisted bool) ([]spec.ServerName, error)
 }
 
+type FederationPartialStateServers interface {
+	InsertPartialStateServer(ctx context.Context, txn *sql.Tx, roomID string, serverName spec.ServerName) error
+	DeletePartialStateServers(ctx context.Context, txn *sql.Tx, roomID string) error
+	SelectPartialStateServersForRooms(ctx context.Context, roomIDs []string, excludingBlacklisted bool) ([]spec.ServerName, error)
+}
+
 type FederationBlacklist interface {
 	InsertBlacklist(ctx context.Context, txn *sql.Tx, serverName spec.ServerName) error
 	SelectBlacklist(ctx context.Context, txn *sql.Tx, serverName spec.ServerName) (bool, error)
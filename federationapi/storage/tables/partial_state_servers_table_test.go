@@ -0,0 +1,70 @@
+package tables_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jchv/maidtrix/federationapi/storage/postgres"
+	"github.com/jchv/maidtrix/federationapi/storage/sqlite3"
+	"github.com/jchv/maidtrix/federationapi/storage/tables"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCreatePartialStateServersTable(t *testing.T, dbType test.DBType) (tables.FederationPartialStateServers, func()) {
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	var tab tables.FederationPartialStateServers
+	switch dbType {
+	case test.DBTypePostgres:
+		tab, err = postgres.NewPostgresPartialStateServersTable(db)
+	case test.DBTypeSQLite:
+		tab, err = sqlite3.NewSQLitePartialStateServersTable(db)
+	}
+	if err != nil {
+		t.Fatalf("failed to create table: %s", err)
+	}
+	return tab, close
+}
+
+func TestPartialStateServersTable(t *testing.T) {
+	ctx := context.Background()
+	alice := test.NewUser(t)
+	room1 := test.NewRoom(t, alice)
+	room2 := test.NewRoom(t, alice)
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab, closeDB := mustCreatePartialStateServersTable(t, dbType)
+		defer closeDB()
+
+		for _, serverName := range []spec.ServerName{"a.test", "b.test", "a.test"} {
+			if err := tab.InsertPartialStateServer(ctx, nil, room1.ID, serverName); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tab.InsertPartialStateServer(ctx, nil, room2.ID, "c.test"); err != nil {
+			t.Fatal(err)
+		}
+
+		servers, err := tab.SelectPartialStateServersForRooms(ctx, []string{room1.ID}, false)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []spec.ServerName{"a.test", "b.test"}, servers)
+
+		servers, err = tab.SelectPartialStateServersForRooms(ctx, []string{room1.ID, room2.ID}, false)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []spec.ServerName{"a.test", "b.test", "c.test"}, servers)
+
+		// Once the room has full state the servers are forgotten.
+		assert.NoError(t, tab.DeletePartialStateServers(ctx, nil, room1.ID))
+		servers, err = tab.SelectPartialStateServersForRooms(ctx, []string{room1.ID}, false)
+		assert.NoError(t, err)
+		assert.Empty(t, servers)
+	})
+}
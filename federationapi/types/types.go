@@ -62,7 +62,8 @@ type FederationReceiptData struct {
 }
 
 type ReceiptTS struct {
-	TS spec.Timestamp `json:"ts"`
+	TS       spec.Timestamp `json:"ts"`
+	ThreadID string         `json:"thread_id,omitempty"`
 }
 
 type Presence struct {
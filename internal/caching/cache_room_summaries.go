@@ -0,0 +1,18 @@
+package caching
+
+import "github.com/jchv/maidtrix/internal/matrixserver/fclient"
+
+// RoomSummaryCache caches room summaries (MSC3266) of rooms which were fetched
+// over federation because this server isn't joined to them.
+type RoomSummaryCache interface {
+	GetRoomSummary(roomID string) (r fclient.RoomHierarchyRoom, ok bool)
+	StoreRoomSummary(roomID string, r fclient.RoomHierarchyRoom)
+}
+
+func (c Caches) GetRoomSummary(roomID string) (r fclient.RoomHierarchyRoom, ok bool) {
+	return c.RoomSummaries.Get(roomID)
+}
+
+func (c Caches) StoreRoomSummary(roomID string, r fclient.RoomHierarchyRoom) {
+	c.RoomSummaries.Set(roomID, r)
+}
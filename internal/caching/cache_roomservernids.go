@@ -9,6 +9,7 @@ type RoomServerCaches interface {
 	RoomVersionCache
 	RoomServerEventsCache
 	RoomHierarchyCache
+	RoomSummaryCache
 	EventStateKeyCache
 	EventTypeCache
 }
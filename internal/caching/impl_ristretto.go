@@ -45,6 +45,36 @@ const (
 	eventTypeCache
 	eventTypeNIDCache
 	eventStateKeyNIDCache
+	roomSummariesCache
+)
+
+// cachePartitionNames maps the partition prefixes above to the names used
+// for the partition label in metrics.
+var cachePartitionNames = map[byte]string{
+	roomVersionsCache:      "room_versions",
+	serverKeysCache:        "server_keys",
+	roomNIDsCache:          "room_nids",
+	roomIDsCache:           "room_ids",
+	roomEventsCache:        "room_events",
+	federationPDUsCache:    "federation_pdus",
+	federationEDUsCache:    "federation_edus",
+	spaceSummaryRoomsCache: "space_summary_rooms",
+	lazyLoadingCache:       "lazy_loading",
+	eventStateKeyCache:     "event_state_keys",
+	eventTypeCache:         "event_types",
+	eventTypeNIDCache:      "event_type_nids",
+	eventStateKeyNIDCache:  "event_state_key_nids",
+	roomSummariesCache:     "room_summaries",
+}
+
+var cacheLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "caching_ristretto",
+		Name:      "lookups_total",
+		Help:      "Number of cache lookups by partition and whether they were a hit or a miss",
+	},
+	[]string{"partition", "result"},
 )
 
 const (
@@ -66,6 +96,7 @@ func NewRistrettoCache(maxCost config.DataUnit, maxAge time.Duration, enableProm
 		panic(err)
 	}
 	if enablePrometheus {
+		prometheus.MustRegister(cacheLookups)
 		promauto.NewGaugeFunc(prometheus.GaugeOpts{
 			Namespace: "dendrite",
 			Subsystem: "caching_ristretto",
@@ -159,6 +190,12 @@ func NewRistrettoCache(maxCost config.DataUnit, maxAge time.Duration, enableProm
 			Mutable: true,
 			MaxAge:  maxAge,
 		},
+		RoomSummaries: &RistrettoCachePartition[string, fclient.RoomHierarchyRoom]{ // room ID -> room summary
+			cache:   cache,
+			Prefix:  roomSummariesCache,
+			Mutable: true,
+			MaxAge:  maxAge,
+		},
 	}
 }
 
@@ -210,9 +247,11 @@ func (c *RistrettoCachePartition[K, V]) Get(key K) (value V, ok bool) {
 	bkey := fmt.Sprintf("%c%v", c.Prefix, key)
 	v, ok := c.cache.Get(bkey)
 	if !ok || v == nil {
+		cacheLookups.WithLabelValues(cachePartitionNames[c.Prefix], "miss").Inc()
 		var empty V
 		return empty, false
 	}
+	cacheLookups.WithLabelValues(cachePartitionNames[c.Prefix], "hit").Inc()
 	value, ok = v.(V)
 	return
 }
@@ -35,6 +35,10 @@ type ReadMarkerJSON struct {
 	ReadPrivate string `json:"m.read.private"`
 }
 
+// MainThreadID is the thread ID which refers to the main timeline of a
+// room, rather than a specific thread.
+const MainThreadID = "main"
+
 // NotificationData contains statistics about notifications, sent from
 // the Push Server to the Sync API server.
 type NotificationData struct {
@@ -49,6 +53,18 @@ type NotificationData struct {
 	// UnreadNotificationCount is the total number of unread
 	// notifications.
 	UnreadNotificationCount int `json:"unread_notification_count"`
+
+	// UnreadThreadNotifications breaks the counts down by thread, keyed
+	// by thread root event ID. Notifications which aren't part of a
+	// thread are counted under MainThreadID.
+	UnreadThreadNotifications map[string]ThreadNotificationData `json:"unread_thread_notifications,omitempty"`
+}
+
+// ThreadNotificationData contains statistics about notifications in a
+// single thread.
+type ThreadNotificationData struct {
+	UnreadHighlightCount    int `json:"unread_highlight_count"`
+	UnreadNotificationCount int `json:"unread_notification_count"`
 }
 
 // UserProfile is a struct containing all known user profile data
@@ -15,6 +15,7 @@
 package httputil
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -25,11 +26,16 @@ import (
 	"strings"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/jchv/maidtrix/clientapi/auth"
 	"github.com/jchv/maidtrix/internal"
@@ -44,8 +50,11 @@ type BasicAuth struct {
 }
 
 type AuthAPIOpts struct {
-	GuestAccessAllowed bool
-	WithAuth           bool
+	GuestAccessAllowed   bool
+	ExpiredAccessAllowed bool
+	ConsentRequired      bool
+	ConsentURI           string
+	WithAuth             bool
 }
 
 // AuthAPIOption is an option to MakeAuthAPI to add additional checks (e.g. guest access) to verify
@@ -59,6 +68,23 @@ func WithAllowGuests() AuthAPIOption {
 	}
 }
 
+// WithAllowExpiredAccounts lets users whose account has expired use this endpoint,
+// e.g. to ask for their account to be renewed.
+func WithAllowExpiredAccounts() AuthAPIOption {
+	return func(opts *AuthAPIOpts) {
+		opts.ExpiredAccessAllowed = true
+	}
+}
+
+// WithRequireConsent stops users who haven't accepted the current version of the
+// policy documents from using this endpoint, pointing them at the consent URI.
+func WithRequireConsent(consentURI string) AuthAPIOption {
+	return func(opts *AuthAPIOpts) {
+		opts.ConsentRequired = true
+		opts.ConsentURI = consentURI
+	}
+}
+
 // WithAuth is an option to MakeHTTPAPI to add authentication.
 func WithAuth() AuthAPIOption {
 	return func(opts *AuthAPIOpts) {
@@ -79,8 +105,8 @@ func MakeAuthAPI(
 			logger.Debugf("VerifyUserFromRequest %s -> HTTP %d", req.RemoteAddr, err.Code)
 			return *err
 		}
-		// add the user ID to the logger
-		logger = logger.WithField("user_id", device.UserID)
+		// add the user ID and any room or event IDs to the logger
+		logger = logger.WithField("user_id", device.UserID).WithFields(RequestLogFields(req))
 		req = req.WithContext(util.ContextWithLogger(req.Context(), logger))
 		// add the user to Sentry, if enabled
 		hub := sentry.GetHubFromContext(req.Context())
@@ -116,6 +142,20 @@ func MakeAuthAPI(
 			}
 		}
 
+		if !opts.ExpiredAccessAllowed && device.AccountExpired {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: accountExpired(),
+			}
+		}
+
+		if opts.ConsentRequired && device.ConsentRequired {
+			return util.JSONResponse{
+				Code: http.StatusForbidden,
+				JSON: consentNotGiven(opts.ConsentURI),
+			}
+		}
+
 		jsonRes := f(req, device)
 		// do not log 4xx as errors as they are client fails, not server fails
 		if hub != nil && jsonRes.Code >= 500 {
@@ -127,6 +167,56 @@ func MakeAuthAPI(
 	return MakeExternalAPI(metricsName, h)
 }
 
+// accountExpired is the error returned to users whose account has expired,
+// using the error code which clients already recognise.
+func accountExpired() spec.MatrixError {
+	return spec.MatrixError{
+		ErrCode: "ORG_MATRIX_EXPIRED_ACCOUNT",
+		Err:     "User account has expired",
+	}
+}
+
+// consentNotGivenError is the error returned to users who haven't accepted
+// the current version of the policy documents.
+type consentNotGivenError struct {
+	spec.MatrixError
+	ConsentURI string `json:"consent_uri,omitempty"`
+}
+
+func consentNotGiven(consentURI string) consentNotGivenError {
+	msg := "You must accept the terms and conditions of this server before continuing"
+	if consentURI != "" {
+		msg += ": " + consentURI
+	}
+	return consentNotGivenError{
+		MatrixError: spec.MatrixError{
+			ErrCode: "M_CONSENT_NOT_GIVEN",
+			Err:     msg,
+		},
+		ConsentURI: consentURI,
+	}
+}
+
+// RequestLogFields returns the room ID and event ID from the path of the
+// request, if present, using the same field names as the rest of the logs.
+func RequestLogFields(req *http.Request) logrus.Fields {
+	fields := logrus.Fields{}
+	vars := mux.Vars(req)
+	for _, key := range []string{"roomID", "roomId", "roomid"} {
+		if roomID, ok := vars[key]; ok {
+			fields["room_id"] = roomID
+			break
+		}
+	}
+	for _, key := range []string{"eventID", "eventId"} {
+		if eventID, ok := vars[key]; ok {
+			fields["event_id"] = eventID
+			break
+		}
+	}
+	return fields
+}
+
 // MakeAdminAPI is a wrapper around MakeAuthAPI which enforces that the request can only be
 // completed by a user that is a server administrator.
 func MakeAdminAPI(
@@ -196,7 +286,7 @@ func MakeExternalAPI(metricsName string, f func(*http.Request) util.JSONResponse
 			}
 		}
 
-		trace, ctx := internal.StartTask(req.Context(), metricsName)
+		trace, ctx := startServerTask(req, metricsName)
 		defer trace.EndTask()
 		req = req.WithContext(ctx)
 		h.ServeHTTP(nextWriter, req)
@@ -206,6 +296,20 @@ func MakeExternalAPI(metricsName string, f func(*http.Request) util.JSONResponse
 	return http.HandlerFunc(withSpan)
 }
 
+// startServerTask starts a trace for an incoming request, continuing the
+// trace of the caller if the request carries a trace context.
+func startServerTask(req *http.Request, name string) (internal.Trace, context.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return internal.StartTask(
+		ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+}
+
 // MakeHTTPAPI adds Span metrics to the HTML Handler function
 // This is used to serve HTML alongside JSON error messages
 func MakeHTTPAPI(metricsName string, userAPI userapi.QueryAcccessTokenAPI, enableMetrics bool, f func(http.ResponseWriter, *http.Request), checks ...AuthAPIOption) http.Handler {
@@ -216,7 +320,7 @@ func MakeHTTPAPI(metricsName string, userAPI userapi.QueryAcccessTokenAPI, enabl
 			return
 		}
 
-		trace, ctx := internal.StartTask(req.Context(), metricsName)
+		trace, ctx := startServerTask(req, metricsName)
 		defer trace.EndTask()
 		req = req.WithContext(ctx)
 
@@ -228,7 +332,13 @@ func MakeHTTPAPI(metricsName string, userAPI userapi.QueryAcccessTokenAPI, enabl
 
 		if opts.WithAuth {
 			logger := util.GetLogger(req.Context())
-			_, jsonErr := auth.VerifyUserFromRequest(req, userAPI)
+			device, jsonErr := auth.VerifyUserFromRequest(req, userAPI)
+			if jsonErr == nil && !opts.ExpiredAccessAllowed && device.AccountExpired {
+				jsonErr = &util.JSONResponse{
+					Code: http.StatusForbidden,
+					JSON: accountExpired(),
+				}
+			}
 			if jsonErr != nil {
 				w.WriteHeader(jsonErr.Code)
 				if err := json.NewEncoder(w).Encode(jsonErr.JSON); err != nil {
@@ -15,9 +15,14 @@
 package httputil
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/jchv/maidtrix/internal/util"
+	userapi "github.com/jchv/maidtrix/userapi/api"
 )
 
 func TestWrapHandlerInBasicAuth(t *testing.T) {
@@ -107,3 +112,84 @@ func TestWrapHandlerInBasicAuth(t *testing.T) {
 		})
 	}
 }
+
+type expiredAccountAPI struct{}
+
+func (expiredAccountAPI) QueryAccessToken(ctx context.Context, req *userapi.QueryAccessTokenRequest, res *userapi.QueryAccessTokenResponse) error {
+	res.Device = &userapi.Device{
+		UserID:         "@alice:test",
+		AccountType:    userapi.AccountTypeUser,
+		AccountExpired: true,
+	}
+	return nil
+}
+
+func TestMakeAuthAPIExpiredAccount(t *testing.T) {
+	handler := func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		return util.JSONResponse{Code: http.StatusOK, JSON: struct{}{}}
+	}
+	tests := []struct {
+		name string
+		opts []AuthAPIOption
+		want int
+	}{
+		{name: "expired accounts are rejected", want: http.StatusForbidden},
+		{name: "expired accounts are allowed", opts: []AuthAPIOption{WithAllowExpiredAccounts()}, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+			MakeAuthAPI("test", expiredAccountAPI{}, handler, tt.opts...).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected HTTP %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+type noConsentAPI struct{}
+
+func (noConsentAPI) QueryAccessToken(ctx context.Context, req *userapi.QueryAccessTokenRequest, res *userapi.QueryAccessTokenResponse) error {
+	res.Device = &userapi.Device{
+		UserID:          "@alice:test",
+		AccountType:     userapi.AccountTypeUser,
+		ConsentRequired: true,
+	}
+	return nil
+}
+
+func TestMakeAuthAPIConsentRequired(t *testing.T) {
+	handler := func(req *http.Request, device *userapi.Device) util.JSONResponse {
+		return util.JSONResponse{Code: http.StatusOK, JSON: struct{}{}}
+	}
+	tests := []struct {
+		name     string
+		opts     []AuthAPIOption
+		want     int
+		wantBody string
+	}{
+		{name: "consent is not required", want: http.StatusOK, wantBody: `{}`},
+		{
+			name:     "consent is required",
+			opts:     []AuthAPIOption{WithRequireConsent("https://example.com/consent")},
+			want:     http.StatusForbidden,
+			wantBody: `{"errcode":"M_CONSENT_NOT_GIVEN","error":"You must accept the terms and conditions of this server before continuing: https://example.com/consent","consent_uri":"https://example.com/consent"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+			MakeAuthAPI("test", noConsentAPI{}, handler, tt.opts...).ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("expected HTTP %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tt.wantBody {
+				t.Errorf("expected body %s, got %s", tt.wantBody, got)
+			}
+		})
+	}
+}
@@ -64,13 +64,6 @@ func (l *RateLimits) Limit(req *http.Request, device *userapi.Device) *util.JSON
 		return nil
 	}
 
-	// Take a read lock out on the cleaner mutex. The cleaner expects to
-	// be able to take a write lock, which isn't possible while there are
-	// readers, so this has the effect of blocking the cleaner goroutine
-	// from doing its work until there are no requests in flight.
-	l.cleanMutex.RLock()
-	defer l.cleanMutex.RUnlock()
-
 	// First of all, work out if X-Forwarded-For was sent to us. If not
 	// then we'll just use the IP address of the caller.
 	var caller string
@@ -94,6 +87,23 @@ func (l *RateLimits) Limit(req *http.Request, device *userapi.Device) *util.JSON
 			caller = req.RemoteAddr
 		}
 	}
+	return l.LimitCaller(caller)
+}
+
+// LimitCaller rate-limits requests from the given caller, for endpoints that
+// identify their callers themselves rather than by device or address.
+func (l *RateLimits) LimitCaller(caller string) *util.JSONResponse {
+	// If rate limiting is disabled then do nothing.
+	if !l.enabled {
+		return nil
+	}
+
+	// Take a read lock out on the cleaner mutex. The cleaner expects to
+	// be able to take a write lock, which isn't possible while there are
+	// readers, so this has the effect of blocking the cleaner goroutine
+	// from doing its work until there are no requests in flight.
+	l.cleanMutex.RLock()
+	defer l.cleanMutex.RUnlock()
 
 	// Look up the caller's channel, if they have one.
 	l.limitsMutex.RLock()
@@ -49,20 +49,136 @@ func (f utcFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return f.Formatter.Format(entry)
 }
 
+// defaultComponentField is the name of the field that the component is
+// written to in structured log lines, unless the hook configures another.
+const defaultComponentField = "component"
+
+// structuredFormatter adds the component to each log entry and renames
+// well-known fields so that structured log lines have stable field names.
+type structuredFormatter struct {
+	logrus.Formatter
+	// The name of the field to write the component to.
+	componentField string
+}
+
+// structuredFieldNames maps field names used throughout the codebase onto
+// the stable names used in structured log output.
+var structuredFieldNames = map[string]string{
+	"req.id": "request_id",
+}
+
+func (f structuredFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	// The entry is shared between all hooks, so work on a copy rather
+	// than modifying the fields that other hooks will see.
+	e := *entry
+	e.Data = make(logrus.Fields, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		if renamed, ok := structuredFieldNames[k]; ok {
+			k = renamed
+		}
+		e.Data[k] = v
+	}
+	if component := entryComponent(entry); component != "" {
+		delete(e.Data, "component")
+		e.Data[f.componentField] = component
+	}
+	return f.Formatter.Format(&e)
+}
+
+// modulePrefix is the prefix of all Dendrite package paths.
+const modulePrefix = "github.com/jchv/maidtrix/"
+
+// entryComponent returns the component that the log entry belongs to. This is
+// the "component" field if set, otherwise it is the package path of the caller
+// relative to the module root, e.g. "federationapi/queue".
+func entryComponent(entry *logrus.Entry) string {
+	if component, ok := entry.Data["component"].(string); ok {
+		return component
+	}
+	if entry.Caller == nil {
+		return ""
+	}
+	return packageComponent(entry.Caller.Function)
+}
+
+// packageComponent returns the package path relative to the module root of
+// a fully qualified function name.
+func packageComponent(function string) string {
+	if !strings.HasPrefix(function, modulePrefix) {
+		return ""
+	}
+	pkg := strings.TrimPrefix(function, modulePrefix)
+	slash := strings.LastIndex(pkg, "/")
+	if dot := strings.Index(pkg[slash+1:], "."); dot >= 0 {
+		pkg = pkg[:slash+1+dot]
+	}
+	return pkg
+}
+
+// componentLevels holds per-component log level overrides, keyed by the
+// component or package path, e.g. "federationapi" or "roomserver/internal/input".
+type componentLevels map[string]logrus.Level
+
+// parseComponentLevels parses the per-component levels from the config.
+func parseComponentLevels(components map[string]string) componentLevels {
+	if len(components) == 0 {
+		return nil
+	}
+	levels := make(componentLevels, len(components))
+	for component, l := range components {
+		level, err := logrus.ParseLevel(l)
+		if err != nil {
+			logrus.Fatalf("Unrecognised logging level %s for component %s: %q", l, component, err)
+		}
+		levels[strings.Trim(component, "/")] = level
+	}
+	return levels
+}
+
+// levelFor returns the level override for the most specific matching component,
+// or false if there is no override for the component.
+func (c componentLevels) levelFor(component string) (logrus.Level, bool) {
+	for component != "" {
+		if level, ok := c[component]; ok {
+			return level, true
+		}
+		slash := strings.LastIndex(component, "/")
+		if slash < 0 {
+			break
+		}
+		component = component[:slash]
+	}
+	return 0, false
+}
+
+// maxLevel returns the most verbose level out of the given level and all
+// of the component overrides.
+func (c componentLevels) maxLevel(level logrus.Level) logrus.Level {
+	for _, l := range c {
+		if l > level {
+			level = l
+		}
+	}
+	return level
+}
+
 // Logrus hook which wraps another hook and filters log entries according to their level.
 // (Note that we cannot use solely logrus.SetLevel, because Dendrite supports multiple
 // levels of logging at the same time.)
 type logLevelHook struct {
 	level logrus.Level
 	logrus.Hook
+	// Per-component overrides of the level, if any.
+	components componentLevels
 }
 
 // Levels returns all the levels supported by this hook.
 func (h *logLevelHook) Levels() []logrus.Level {
 	levels := make([]logrus.Level, 0)
+	maxLevel := h.components.maxLevel(h.level)
 
 	for _, level := range logrus.AllLevels {
-		if level <= h.level {
+		if level <= maxLevel {
 			levels = append(levels, level)
 		}
 	}
@@ -70,6 +186,59 @@ func (h *logLevelHook) Levels() []logrus.Level {
 	return levels
 }
 
+// Fire passes the entry on to the wrapped hook if the entry is enabled for
+// the level of the component it belongs to.
+func (h *logLevelHook) Fire(entry *logrus.Entry) error {
+	if len(h.components) > 0 {
+		level, ok := h.components.levelFor(entryComponent(entry))
+		if !ok {
+			level = h.level
+		}
+		if entry.Level > level {
+			return nil
+		}
+	}
+	return h.Hook.Fire(entry)
+}
+
+// hookFormatter returns the formatter for a hook. Each hook gets its own
+// formatter, so hooks with different formats can be used at the same time.
+func hookFormatter(hook config.LogrusHook, text *logrus.TextFormatter) logrus.Formatter {
+	switch hook.Format {
+	case "json":
+		componentField := hook.ComponentField
+		if componentField == "" {
+			componentField = defaultComponentField
+		}
+		return &utcFormatter{
+			structuredFormatter{
+				&logrus.JSONFormatter{
+					TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
+					CallerPrettyfier: jsonCallerPrettyfier,
+				},
+				componentField,
+			},
+		}
+	default:
+		return &utcFormatter{text}
+	}
+}
+
+// jsonCallerPrettyfier returns the function name and the shortened file
+// path of the caller for use in structured logs.
+func jsonCallerPrettyfier(f *runtime.Frame) (string, string) {
+	return f.Function, fmt.Sprintf("%s:%d", path.Base(f.File), f.Line)
+}
+
+// setupLevels makes sure that logrus will produce entries for the given
+// level and any component overrides, since the hooks can only filter out
+// entries that logrus has produced.
+func setupLevels(level logrus.Level, components componentLevels) {
+	if maxLevel := components.maxLevel(level); logrus.GetLevel() < maxLevel {
+		logrus.SetLevel(maxLevel)
+	}
+}
+
 // callerPrettyfier is a function that given a runtime.Frame object, will
 // extract the calling function's name and file, and return them in a nicely
 // formatted way
@@ -104,15 +273,18 @@ func SetupStdLogging() {
 	levelLogAddedMu.Lock()
 	defer levelLogAddedMu.Unlock()
 	logrus.SetReportCaller(true)
-	logrus.SetFormatter(&utcFormatter{
-		&logrus.TextFormatter{
-			TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
-			FullTimestamp:    true,
-			DisableColors:    false,
-			DisableTimestamp: false,
-			QuoteEmptyFields: true,
-			CallerPrettyfier: callerPrettyfier,
-		},
+	logrus.SetFormatter(stdFormatter(config.LogrusHook{}))
+}
+
+// stdFormatter returns the formatter used for logging to standard output.
+func stdFormatter(hook config.LogrusHook) logrus.Formatter {
+	return hookFormatter(hook, &logrus.TextFormatter{
+		TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
+		FullTimestamp:    true,
+		DisableColors:    false,
+		DisableTimestamp: false,
+		QuoteEmptyFields: true,
+		CallerPrettyfier: callerPrettyfier,
 	})
 }
 
@@ -129,7 +301,7 @@ func checkFileHookParams(params map[string]interface{}) {
 }
 
 // Add a new FSHook to the logger. Each component will log in its own file
-func setupFileHook(hook config.LogrusHook, level logrus.Level) {
+func setupFileHook(hook config.LogrusHook, level logrus.Level, components componentLevels) {
 	dirPath := (hook.Params["path"]).(string)
 	fullPath := filepath.Join(dirPath, "dendrite.log")
 
@@ -141,17 +313,16 @@ func setupFileHook(hook config.LogrusHook, level logrus.Level) {
 		level,
 		dugong.NewFSHook(
 			fullPath,
-			&utcFormatter{
-				&logrus.TextFormatter{
-					TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
-					DisableColors:    true,
-					DisableTimestamp: false,
-					DisableSorting:   false,
-					QuoteEmptyFields: true,
-				},
-			},
+			hookFormatter(hook, &logrus.TextFormatter{
+				TimestampFormat:  "2006-01-02T15:04:05.000000000Z07:00",
+				DisableColors:    true,
+				DisableTimestamp: false,
+				DisableSorting:   false,
+				QuoteEmptyFields: true,
+			}),
 			&dugong.DailyRotationSchedule{GZip: true},
 		),
+		components,
 	})
 }
 
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jchv/maidtrix/setup/config"
+)
+
+func TestPackageComponent(t *testing.T) {
+	tests := map[string]string{
+		"github.com/jchv/maidtrix/federationapi/queue.(*destinationQueue).backgroundSend": "federationapi/queue",
+		"github.com/jchv/maidtrix/internal.SetupStdLogging":                               "internal",
+		"github.com/jchv/maidtrix/roomserver/internal/input.(*worker)._next.func1":        "roomserver/internal/input",
+		"github.com/nats-io/nats.go.(*Conn).Publish":                                      "",
+	}
+	for function, want := range tests {
+		if got := packageComponent(function); got != want {
+			t.Errorf("packageComponent(%q): got %q, want %q", function, got, want)
+		}
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	levels := parseComponentLevels(map[string]string{
+		"federationapi":       "debug",
+		"federationapi/queue": "error",
+	})
+	tests := []struct {
+		component string
+		level     logrus.Level
+		ok        bool
+	}{
+		{"federationapi", logrus.DebugLevel, true},
+		{"federationapi/routing", logrus.DebugLevel, true},
+		{"federationapi/queue", logrus.ErrorLevel, true},
+		{"federationapiother", 0, false},
+		{"roomserver", 0, false},
+	}
+	for _, tt := range tests {
+		level, ok := levels.levelFor(tt.component)
+		if ok != tt.ok || level != tt.level {
+			t.Errorf("levelFor(%q): got %v %v, want %v %v", tt.component, level, ok, tt.level, tt.ok)
+		}
+	}
+	if got := levels.maxLevel(logrus.InfoLevel); got != logrus.DebugLevel {
+		t.Errorf("maxLevel: got %v, want %v", got, logrus.DebugLevel)
+	}
+}
+
+type recordingHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *recordingHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *recordingHook) Fire(entry *logrus.Entry) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func TestLogLevelHookComponents(t *testing.T) {
+	rec := &recordingHook{}
+	hook := &logLevelHook{
+		level:      logrus.InfoLevel,
+		Hook:       rec,
+		components: componentLevels{"federationapi": logrus.DebugLevel},
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.DebugLevel)
+
+	fire := func(level logrus.Level, component string) {
+		entry := logrus.NewEntry(logger).WithField("component", component)
+		entry.Level = level
+		if err := hook.Fire(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fire(logrus.DebugLevel, "federationapi")
+	fire(logrus.DebugLevel, "roomserver")
+	fire(logrus.InfoLevel, "roomserver")
+
+	if len(rec.entries) != 2 {
+		t.Fatalf("expected 2 entries to pass through, got %d", len(rec.entries))
+	}
+	if len(hook.Levels()) != len(logrus.AllLevels)-1 {
+		t.Errorf("expected hook to accept all levels up to debug, got %v", hook.Levels())
+	}
+}
+
+func TestJSONFormatterStableFields(t *testing.T) {
+	logger := logrus.New()
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	logger.SetFormatter(hookFormatter(config.LogrusHook{Format: "json"}, &logrus.TextFormatter{}))
+	logger.SetReportCaller(true)
+
+	entry := logger.WithFields(logrus.Fields{
+		"req.id":  "abcdef",
+		"user_id": "@alice:localhost",
+	})
+	entry.Info("hello")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("failed to parse JSON log line %q: %s", buf.String(), err)
+	}
+	for key, want := range map[string]string{
+		"msg":        "hello",
+		"request_id": "abcdef",
+		"user_id":    "@alice:localhost",
+		"component":  "internal",
+	} {
+		if got := line[key]; got != want {
+			t.Errorf("field %q: got %v, want %q", key, got, want)
+		}
+	}
+	if _, ok := line["req.id"]; ok {
+		t.Errorf("expected req.id to be renamed")
+	}
+	if _, ok := entry.Data["request_id"]; ok {
+		t.Errorf("expected original entry to be left untouched")
+	}
+}
+
+func TestHookFormattersAreIndependent(t *testing.T) {
+	jsonHook := config.LogrusHook{Format: "json", ComponentField: "module"}
+	formatters := []logrus.Formatter{
+		hookFormatter(jsonHook, &logrus.TextFormatter{}),
+		hookFormatter(config.LogrusHook{Format: "json"}, &logrus.TextFormatter{}),
+		hookFormatter(config.LogrusHook{}, &logrus.TextFormatter{DisableTimestamp: true}),
+	}
+	entry := logrus.NewEntry(logrus.New()).WithField("component", "jetstream")
+	entry.Message = "hello"
+
+	var lines []map[string]interface{}
+	for _, formatter := range formatters[:2] {
+		out, err := formatter.Format(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var line map[string]interface{}
+		if err = json.Unmarshal(out, &line); err != nil {
+			t.Fatalf("failed to parse JSON log line %q: %s", out, err)
+		}
+		lines = append(lines, line)
+	}
+	if lines[0]["module"] != "jetstream" || lines[0]["component"] != nil {
+		t.Errorf("expected the component in the configured field, got %v", lines[0])
+	}
+	if lines[1]["component"] != "jetstream" {
+		t.Errorf("expected the component in the default field, got %v", lines[1])
+	}
+	out, err := formatters[2].Format(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json.Valid(out) {
+		t.Errorf("expected a text log line, got %q", out)
+	}
+}
@@ -41,26 +41,26 @@ func SetupHookLogging(hooks []config.LogrusHook) {
 			logrus.Fatalf("Unrecognised logging level %s: %q", hook.Level, err)
 		}
 
+		components := parseComponentLevels(hook.Components)
+
 		// Perform a first filter on the logs according to the lowest level of all
 		// (Eg: If we have hook for info and above, prevent logrus from processing debug logs)
-		if logrus.GetLevel() < level {
-			logrus.SetLevel(level)
-		}
+		setupLevels(level, components)
 
 		switch hook.Type {
 		case "file":
 			checkFileHookParams(hook.Params)
-			setupFileHook(hook, level)
+			setupFileHook(hook, level, components)
 		case "syslog":
 			checkSyslogHookParams(hook.Params)
-			setupSyslogHook(hook, level)
+			setupSyslogHook(hook, level, components)
 		case "std":
-			setupStdLogHook(level)
+			setupStdLogHook(hook, level, components)
 		default:
 			logrus.Fatalf("Unrecognised logging hook type: %s", hook.Type)
 		}
 	}
-	setupStdLogHook(logrus.InfoLevel)
+	setupStdLogHook(config.LogrusHook{}, logrus.InfoLevel, nil)
 	// Hooks are now configured for stdout/err, so throw away the default logger output
 	logrus.SetOutput(io.Discard)
 }
@@ -86,17 +86,24 @@ func checkSyslogHookParams(params map[string]interface{}) {
 
 }
 
-func setupStdLogHook(level logrus.Level) {
-	if stdLevelLogAdded[level] {
+func setupStdLogHook(hook config.LogrusHook, level logrus.Level, components componentLevels) {
+	// Hooks with component overrides are always added, since they may log
+	// components at a different level to an existing hook at this level.
+	if stdLevelLogAdded[level] && len(components) == 0 {
 		return
 	}
-	logrus.AddHook(&logLevelHook{level, stdemuxerhook.New(logrus.StandardLogger())})
 	stdLevelLogAdded[level] = true
+	// The demuxer takes its formatter from the logger it is given, so give it
+	// a logger of its own rather than changing the standard logger's formatter.
+	formatLogger := logrus.New()
+	formatLogger.SetFormatter(stdFormatter(hook))
+	formatLogger.SetLevel(logrus.TraceLevel)
+	logrus.AddHook(&logLevelHook{level, stdemuxerhook.New(formatLogger), components})
 }
 
-func setupSyslogHook(hook config.LogrusHook, level logrus.Level) {
+func setupSyslogHook(hook config.LogrusHook, level logrus.Level, components componentLevels) {
 	syslogHook, err := lSyslog.NewSyslogHook(hook.Params["protocol"].(string), hook.Params["address"].(string), syslog.LOG_INFO, "dendrite")
 	if err == nil {
-		logrus.AddHook(&logLevelHook{level, syslogHook})
+		logrus.AddHook(&logLevelHook{level, syslogHook, components})
 	}
 }
@@ -31,16 +31,16 @@ func SetupHookLogging(hooks []config.LogrusHook) {
 			logrus.Fatalf("Unrecognised logging level %s: %q", hook.Level, err)
 		}
 
+		components := parseComponentLevels(hook.Components)
+
 		// Perform a first filter on the logs according to the lowest level of all
 		// (Eg: If we have hook for info and above, prevent logrus from processing debug logs)
-		if logrus.GetLevel() < level {
-			logrus.SetLevel(level)
-		}
+		setupLevels(level, components)
 
 		switch hook.Type {
 		case "file":
 			checkFileHookParams(hook.Params)
-			setupFileHook(hook, level)
+			setupFileHook(hook, level, components)
 		default:
 			logrus.Fatalf("Unrecognised logging hook type: %s", hook.Type)
 		}
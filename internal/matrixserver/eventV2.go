@@ -17,16 +17,63 @@ type eventV2 struct {
 	eventV1
 	PrevEvents []string `json:"prev_events"`
 	AuthEvents []string `json:"auth_events"`
+
+	// Whether the room version derives the room ID from the create event.
+	domainlessRoomIDs bool
 }
 
 func (e *eventV2) PrevEventIDs() []string {
 	return e.PrevEvents
 }
 
+// AuthEventIDs returns the auth events of the event. In room versions with
+// domainless room IDs, the create event is never listed in the auth_events
+// but is always an auth event, so it is included here.
 func (e *eventV2) AuthEventIDs() []string {
+	if createEventID := e.impliedCreateEventID(); createEventID != "" {
+		return append([]string{createEventID}, e.AuthEvents...)
+	}
 	return e.AuthEvents
 }
 
+// RoomID returns the room ID of the event. In room versions with domainless
+// room IDs, the create event has no room_id and the room ID is derived from
+// the event ID instead.
+func (e *eventV2) RoomID() spec.RoomID {
+	if e.eventFields.RoomID == "" && e.eventFields.Type == spec.MRoomCreate && e.domainlessRoomIDs {
+		roomID, err := spec.NewRoomID("!" + e.EventID()[1:])
+		if err != nil {
+			panic(fmt.Errorf("RoomID is invalid: %w", err))
+		}
+		return *roomID
+	}
+	return e.eventV1.RoomID()
+}
+
+// impliedCreateEventID returns the ID of the create event if it is implied
+// in the auth events, or an empty string otherwise.
+func (e *eventV2) impliedCreateEventID() string {
+	if e.eventFields.Type == spec.MRoomCreate || len(e.eventFields.RoomID) < 2 || !e.domainlessRoomIDs {
+		return ""
+	}
+	return "$" + e.eventFields.RoomID[1:]
+}
+
+// checkRoomIDV2 checks the room ID of the event, which may be domainless or
+// missing altogether from the create event in newer room versions.
+func checkRoomIDV2(res *eventV2, roomVersion IRoomVersion) error {
+	if !roomVersion.DomainlessRoomIDs() {
+		return checkID(res.eventFields.RoomID, "room", '!')
+	}
+	if res.eventFields.Type == spec.MRoomCreate && res.eventFields.RoomID == "" {
+		return nil
+	}
+	if _, err := spec.NewRoomID(res.eventFields.RoomID); err != nil {
+		return fmt.Errorf("gomatrixserverlib: invalid room ID: %w", err)
+	}
+	return nil
+}
+
 // MarshalJSON implements json.Marshaller
 func (e *eventV2) MarshalJSON() ([]byte, error) {
 	if e.eventJSON == nil {
@@ -101,6 +148,7 @@ func (e *eventV2) Redact() {
 	res.redacted = true
 	res.eventJSON = eventJSON
 	res.roomVersion = e.roomVersion
+	res.domainlessRoomIDs = e.domainlessRoomIDs
 	*e = res
 }
 
@@ -141,11 +189,21 @@ func newEventFromUntrustedJSONV2(eventJSON []byte, roomVersion IRoomVersion) (PD
 		return nil, err
 	}
 
-	if err := checkID(res.eventFields.RoomID, "room", '!'); err != nil {
+	if err := checkRoomIDV2(res, roomVersion); err != nil {
 		return nil, err
 	}
 
 	res.roomVersion = roomVersion.Version()
+	res.domainlessRoomIDs = roomVersion.DomainlessRoomIDs()
+
+	// The create event is implied, so it must not be listed explicitly.
+	if createEventID := res.impliedCreateEventID(); createEventID != "" {
+		for _, authEventID := range res.AuthEvents {
+			if authEventID == createEventID {
+				return nil, fmt.Errorf("gomatrixserverlib: auth_events must not contain the create event in room version %s", res.roomVersion)
+			}
+		}
+	}
 
 	// We know the JSON must be valid here.
 	eventJSON = CanonicalJSONAssumeValid(eventJSON)
@@ -197,6 +255,7 @@ var lenientByteLimitRoomVersions = map[RoomVersion]struct{}{
 	RoomVersionV9:        {},
 	RoomVersionV10:       {},
 	RoomVersionV11:       {},
+	RoomVersionV12:       {},
 	RoomVersionPseudoIDs: {},
 	"org.matrix.msc3787": {},
 	"org.matrix.msc3667": {},
@@ -268,11 +327,12 @@ func newEventFromTrustedJSONV2(eventJSON []byte, redacted bool, roomVersion IRoo
 		return nil, err
 	}
 
-	if err := checkID(res.eventFields.RoomID, "room", '!'); err != nil {
+	if err := checkRoomIDV2(&res, roomVersion); err != nil {
 		return nil, err
 	}
 
 	res.roomVersion = roomVersion.Version()
+	res.domainlessRoomIDs = roomVersion.DomainlessRoomIDs()
 	res.redacted = redacted
 	res.eventJSON = eventJSON
 	return &res, nil
@@ -284,11 +344,12 @@ func newEventFromTrustedJSONWithEventIDV2(eventID string, eventJSON []byte, reda
 		return nil, err
 	}
 
-	if err := checkID(res.eventFields.RoomID, "room", '!'); err != nil {
+	if err := checkRoomIDV2(res, roomVersion); err != nil {
 		return nil, err
 	}
 
 	res.roomVersion = roomVersion.Version()
+	res.domainlessRoomIDs = roomVersion.DomainlessRoomIDs()
 	res.eventJSON = eventJSON
 	res.EventIDRaw = eventID
 	res.redacted = redacted
@@ -168,3 +168,87 @@ func TestCheckFields(t *testing.T) {
 		})
 	}
 }
+
+func TestRoomVersionV12(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	verImpl := MustGetRoomVersion(RoomVersionV12)
+	userIDForSender := func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		return spec.NewUserID(string(senderID), true)
+	}
+	build := func(proto *ProtoEvent, content interface{}, authEvents *AuthEvents) PDU {
+		t.Helper()
+		builder := verImpl.NewEventBuilderFromProtoEvent(proto)
+		assert.NoError(t, builder.SetContent(content))
+		assert.NoError(t, builder.AddAuthEvents(authEvents))
+		ev, buildErr := builder.Build(time.Now(), "localhost", "ed25519:1", sk)
+		assert.NoError(t, buildErr)
+		assert.NoError(t, Allowed(ev, authEvents, userIDForSender))
+		assert.NoError(t, authEvents.AddEvent(ev))
+		return ev
+	}
+
+	authEvents, err := NewAuthEvents(nil)
+	assert.NoError(t, err)
+	emptyStateKey := ""
+	creator := "@alice:localhost"
+	createEvent := build(&ProtoEvent{
+		SenderID: creator,
+		RoomID:   "!ignored:localhost",
+		Type:     spec.MRoomCreate,
+		StateKey: &emptyStateKey,
+		Depth:    1,
+	}, map[string]interface{}{
+		"room_version":        RoomVersionV12,
+		"additional_creators": []string{"@bob:localhost"},
+	}, authEvents)
+
+	// The room ID is derived from the create event, and isn't in the event.
+	roomID := createEvent.RoomID()
+	assert.Equal(t, "!"+createEvent.EventID()[1:], roomID.String())
+	assert.Equal(t, spec.ServerName(""), roomID.Domain())
+	assert.NotContains(t, string(createEvent.JSON()), `"room_id"`)
+
+	member := build(&ProtoEvent{
+		SenderID:   creator,
+		RoomID:     roomID.String(),
+		Type:       spec.MRoomMember,
+		StateKey:   &creator,
+		PrevEvents: []string{createEvent.EventID()},
+		Depth:      2,
+	}, MemberContent{Membership: spec.Join}, authEvents)
+
+	// The create event is implied by the room ID rather than listed.
+	assert.Contains(t, member.AuthEventIDs(), createEvent.EventID())
+	assert.Contains(t, string(member.JSON()), `"auth_events":[]`)
+
+	// Creators can't be given a power level.
+	builder := verImpl.NewEventBuilderFromProtoEvent(&ProtoEvent{
+		SenderID:   creator,
+		RoomID:     roomID.String(),
+		Type:       spec.MRoomPowerLevels,
+		StateKey:   &emptyStateKey,
+		PrevEvents: []string{member.EventID()},
+		Depth:      3,
+	})
+	assert.NoError(t, builder.SetContent(PowerLevelContent{Users: map[string]int64{"@bob:localhost": 100}}))
+	assert.NoError(t, builder.AddAuthEvents(authEvents))
+	ev, err := builder.Build(time.Now(), "localhost", "ed25519:1", sk)
+	assert.NoError(t, err)
+	assert.Error(t, Allowed(ev, authEvents, userIDForSender))
+
+	// Creators have an infinite power level.
+	powerLevels := build(&ProtoEvent{
+		SenderID:   creator,
+		RoomID:     roomID.String(),
+		Type:       spec.MRoomPowerLevels,
+		StateKey:   &emptyStateKey,
+		PrevEvents: []string{member.EventID()},
+		Depth:      3,
+	}, PowerLevelContent{Users: map[string]int64{"@charlie:localhost": 100}}, authEvents)
+	pl, err := NewPowerLevelContentFromEvent(powerLevels)
+	assert.NoError(t, err)
+	pl.SetCreators(PrivilegedCreators(createEvent))
+	assert.Greater(t, pl.UserLevel("@bob:localhost"), pl.UserLevel("@charlie:localhost"))
+	assert.Greater(t, pl.UserLevel(spec.SenderID(creator)), pl.UserLevel("@charlie:localhost"))
+}
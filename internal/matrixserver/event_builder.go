@@ -3,6 +3,7 @@ package gomatrixserverlib
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
@@ -173,6 +174,18 @@ func (eb *EventBuilder) Build(
 		}
 	}
 
+	isCreateEvent := eventStruct.Type == spec.MRoomCreate && eventStruct.StateKey != nil && *eventStruct.StateKey == ""
+	if eb.version.DomainlessRoomIDs() && !isCreateEvent && len(eventStruct.RoomID) > 1 {
+		// The create event is implied in the auth events, so remove it if the
+		// caller has included it.
+		createEventID := "$" + eventStruct.RoomID[1:]
+		if authEvents, ok := eventStruct.AuthEvents.([]string); ok {
+			eventStruct.AuthEvents = slices.DeleteFunc(slices.Clone(authEvents), func(eventID string) bool {
+				return eventID == createEventID
+			})
+		}
+	}
+
 	if eventStruct.StateKey != nil {
 		// In early versions of the matrix protocol state events
 		// had a "prev_state" key that listed the state events with
@@ -194,6 +207,13 @@ func (eb *EventBuilder) Build(
 		}
 	}
 
+	// The room ID is derived from the create event, so it has no room_id.
+	if eb.version.DomainlessRoomIDs() && isCreateEvent {
+		if eventJSON, err = sjson.DeleteBytes(eventJSON, "room_id"); err != nil {
+			return
+		}
+	}
+
 	if eventJSON, err = addContentHashesToEvent(eventJSON); err != nil {
 		return
 	}
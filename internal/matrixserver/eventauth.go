@@ -368,10 +368,12 @@ func (a *allowerContext) update(provider AuthEventProvider) {
 		a.provider = provider
 		a.createEvent, a.powerLevelsEvent, a.joinRuleEvent = nil, nil, nil
 	}
+	updatedCreators := false
 	if e, _ := provider.Create(); a.createEvent == nil || a.createEvent != e {
 		if c, err := NewCreateContentFromAuthEvents(provider, a.userIDQuerier); err == nil {
 			a.createEvent = e
 			a.create = c
+			updatedCreators = true
 		}
 	}
 	if e, _ := provider.PowerLevels(); a.powerLevelsEvent == nil || a.powerLevelsEvent != e {
@@ -382,6 +384,12 @@ func (a *allowerContext) update(provider AuthEventProvider) {
 		if p, err := NewPowerLevelContentFromAuthEvents(provider, creator); err == nil {
 			a.powerLevelsEvent = e
 			a.powerLevels = p
+			updatedCreators = true
+		}
+	}
+	if updatedCreators && a.createEvent != nil {
+		if verImpl, err := GetRoomVersion(a.createEvent.Version()); err == nil && verImpl.PrivilegedCreators() {
+			a.powerLevels.SetCreators(a.create.Creators())
 		}
 	}
 	if e, _ := provider.JoinRules(); a.joinRuleEvent == nil || a.joinRuleEvent != e {
@@ -433,18 +441,21 @@ func (a *allowerContext) createEventAllowed(event PDU) error {
 	if len(event.PrevEventIDs()) > 0 {
 		return errorf("create event must be the first event in the room: found %d prev_events", len(event.PrevEventIDs()))
 	}
-	sender, err := a.userIDQuerier(a.roomID, event.SenderID())
-	if err != nil {
-		return err
-	}
-	if sender.Domain() != event.RoomID().Domain() {
-		return errorf("create event room ID domain does not match sender: %q != %q", event.RoomID().Domain(), sender.String())
-	}
-
 	verImpl, err := GetRoomVersion(event.Version())
 	if err != nil {
 		return nil
 	}
+	// Domainless room IDs are derived from the create event, so there is
+	// nothing to check the sender against.
+	if !verImpl.DomainlessRoomIDs() {
+		sender, err := a.userIDQuerier(a.roomID, event.SenderID())
+		if err != nil {
+			return err
+		}
+		if sender.Domain() != event.RoomID().Domain() {
+			return errorf("create event room ID domain does not match sender: %q != %q", event.RoomID().Domain(), sender.String())
+		}
+	}
 	if err = verImpl.CheckCreateEvent(event, KnownRoomVersion); err != nil {
 		return err
 	}
@@ -536,6 +547,13 @@ func (a *allowerContext) powerLevelsEventAllowed(event PDU) error {
 		}
 	}
 
+	// Creators have an infinite power level, so they can't be given a level.
+	for _, creator := range a.create.Creators() {
+		if _, ok := newPowerLevels.Users[creator]; ok && a.powerLevels.IsCreator(spec.SenderID(creator)) {
+			return errorf("power levels must not contain the room creator %q", creator)
+		}
+	}
+
 	// Grab the old levels so that we can compare new the levels against them.
 	oldPowerLevels := a.powerLevels
 	senderLevel := oldPowerLevels.UserLevel(event.SenderID())
@@ -672,6 +690,11 @@ func checkUserLevels(senderLevel int64, senderID spec.SenderID, oldPowerLevels,
 
 	// Check each of the levels in the list.
 	for userSenderID, level := range userLevelChecks {
+		// Privileged creators always have an infinite level, whatever the
+		// power levels say.
+		if oldPowerLevels.IsCreator(userSenderID) {
+			continue
+		}
 		// Check if the level is being changed.
 		if level.old == level.new {
 			// Levels are always allowed to stay the same.
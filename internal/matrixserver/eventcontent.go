@@ -19,10 +19,12 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/tidwall/gjson"
 	"golang.org/x/crypto/ed25519"
 )
 
@@ -32,6 +34,8 @@ import (
 type CreateContent struct {
 	// We need the domain of the create event when checking federatability.
 	senderDomain string
+	// We need the sender of the create event to work out the room creators.
+	senderID string
 	// We need the roomID to check that events are in the same room as the create event.
 	roomID string
 	// We need the eventID to check the first join event in the room.
@@ -46,6 +50,9 @@ type CreateContent struct {
 	Predecessor *PreviousRoom `json:"predecessor,omitempty"`
 	// The room type.
 	RoomType string `json:"type,omitempty"`
+	// Additional users who, along with the sender, are creators of the room.
+	// From room version 12, creators have an infinite power level.
+	AdditionalCreators []string `json:"additional_creators,omitempty"`
 }
 
 // PreviousRoom is the "Previous Room" structure defined at https://matrix.org/docs/spec/client_server/r0.5.0#m-room-create
@@ -81,9 +88,28 @@ func NewCreateContentFromAuthEvents(authEvents AuthEventProvider, userIDForSende
 		return
 	}
 	c.senderDomain = string(sender.Domain())
+	c.senderID = string(createEvent.SenderID())
 	return
 }
 
+// Creators returns the sender of the create event and any additional creators.
+func (c *CreateContent) Creators() []string {
+	return append([]string{c.senderID}, c.AdditionalCreators...)
+}
+
+// PrivilegedCreators returns the creators of the room from the given create
+// event, or nil if the room version doesn't give creators infinite power.
+func PrivilegedCreators(createEvent PDU) []string {
+	verImpl, err := GetRoomVersion(createEvent.Version())
+	if err != nil || !verImpl.PrivilegedCreators() {
+		return nil
+	}
+	c := CreateContent{senderID: string(createEvent.SenderID())}
+	// The create event content has already been validated by event auth.
+	_ = json.Unmarshal(createEvent.Content(), &c)
+	return c.Creators()
+}
+
 // DomainAllowed checks whether the domain is allowed in the room by the
 // "m.federate" flag.
 func (c *CreateContent) DomainAllowed(domain string) error {
@@ -366,10 +392,31 @@ type PowerLevelContent struct {
 	EventsDefault int64            `json:"events_default"`
 	StateDefault  int64            `json:"state_default"`
 	Notifications map[string]int64 `json:"notifications"`
+
+	// The room creators, if they are privileged by the room version.
+	creators map[string]struct{}
+}
+
+// SetCreators marks the given users as room creators, which have an infinite
+// power level in room versions with privileged creators.
+func (c *PowerLevelContent) SetCreators(creators []string) {
+	c.creators = make(map[string]struct{}, len(creators))
+	for _, creator := range creators {
+		c.creators[creator] = struct{}{}
+	}
+}
+
+// IsCreator returns true if the user has been marked as a privileged creator.
+func (c *PowerLevelContent) IsCreator(senderID spec.SenderID) bool {
+	_, ok := c.creators[string(senderID)]
+	return ok
 }
 
 // UserLevel returns the power level a user has in the room.
 func (c *PowerLevelContent) UserLevel(senderID spec.SenderID) int64 {
+	if c.IsCreator(senderID) {
+		return math.MaxInt64
+	}
 	level, ok := c.Users[string(senderID)]
 	if ok {
 		return level
@@ -585,6 +632,38 @@ func noCheckCreateEvent(event PDU, knownRoomVersion knownRoomVersionFunc) error
 	return nil
 }
 
+// checkCreateEventV12 checks the create event for room versions where the room
+// ID is derived from the create event.
+func checkCreateEventV12(event PDU, knownRoomVersion knownRoomVersionFunc) error {
+	if gjson.GetBytes(event.JSON(), "room_id").Exists() {
+		return errorf("create event must not have a room_id")
+	}
+	c := struct {
+		RoomVersion        *RoomVersion    `json:"room_version"`
+		AdditionalCreators json.RawMessage `json:"additional_creators"`
+	}{}
+	if err := json.Unmarshal(event.Content(), &c); err != nil {
+		return errorf("create event has invalid content: %s", err.Error())
+	}
+	if c.RoomVersion != nil {
+		if !knownRoomVersion(*c.RoomVersion) {
+			return errorf("create event has unrecognised room version %q", *c.RoomVersion)
+		}
+	}
+	if c.AdditionalCreators != nil {
+		var additionalCreators []string
+		if err := json.Unmarshal(c.AdditionalCreators, &additionalCreators); err != nil {
+			return errorf("create event additional_creators is not a list of user IDs")
+		}
+		for _, userID := range additionalCreators {
+			if _, err := spec.NewUserID(userID, true); err != nil {
+				return errorf("create event additional_creators contains invalid user ID %q", userID)
+			}
+		}
+	}
+	return nil
+}
+
 func checkCreateEvent(event PDU, knownRoomVersion knownRoomVersionFunc) error {
 	c := struct {
 		Creator     *string      `json:"creator"`
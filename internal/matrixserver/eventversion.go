@@ -34,6 +34,8 @@ type IRoomVersion interface {
 	CheckCanonicalJSON(input []byte) error
 	ParsePowerLevels(contentBytes []byte, c *PowerLevelContent) error
 	CheckCreateEvent(event PDU, knownRoomVersion knownRoomVersionFunc) error
+	DomainlessRoomIDs() bool
+	PrivilegedCreators() bool
 }
 
 type knownRoomVersionFunc func(RoomVersion) bool
@@ -62,6 +64,7 @@ const (
 	RoomVersionV9        RoomVersion = "9"
 	RoomVersionV10       RoomVersion = "10"
 	RoomVersionV11       RoomVersion = "11"
+	RoomVersionV12       RoomVersion = "12"
 	RoomVersionPseudoIDs RoomVersion = "org.matrix.msc4014"
 )
 
@@ -80,8 +83,9 @@ const (
 
 // State resolution constants.
 const (
-	StateResV1 StateResAlgorithm = iota + 1 // state resolution v1
-	StateResV2                              // state resolution v2
+	StateResV1  StateResAlgorithm = iota + 1 // state resolution v1
+	StateResV2                               // state resolution v2
+	StateResV21                              // state resolution v2.1
 )
 
 var roomVersionMeta = map[RoomVersion]IRoomVersion{
@@ -305,6 +309,28 @@ var roomVersionMeta = map[RoomVersion]IRoomVersion{
 		newEventFromTrustedJSONFunc:            newEventFromTrustedJSONV2,
 		newEventFromTrustedJSONWithEventIDFunc: newEventFromTrustedJSONWithEventIDV2,
 	},
+	RoomVersionV12: RoomVersionImpl{
+		ver:                                    RoomVersionV12,
+		stable:                                 true,
+		stateResAlgorithm:                      StateResV21,
+		eventFormat:                            EventFormatV2,
+		eventIDFormat:                          EventIDFormatV3,
+		redactionAlgorithm:                     redactEventJSONV5,
+		signatureValidityCheckFunc:             StrictValiditySignatureCheck,
+		canonicalJSONCheck:                     verifyEnforcedCanonicalJSON,
+		notificationLevelCheck:                 checkNotificationLevels,
+		restrictedJoinServernameFunc:           extractAuthorisedViaServerName,
+		checkRestrictedJoin:                    checkRestrictedJoin,
+		parsePowerLevelsFunc:                   parseIntegerPowerLevels,
+		checkKnockingAllowedFunc:               checkKnocking,
+		checkRestrictedJoinAllowedFunc:         allowRestrictedJoins,
+		checkCreateEvent:                       checkCreateEventV12,
+		newEventFromUntrustedJSONFunc:          newEventFromUntrustedJSONV2,
+		newEventFromTrustedJSONFunc:            newEventFromTrustedJSONV2,
+		newEventFromTrustedJSONWithEventIDFunc: newEventFromTrustedJSONWithEventIDV2,
+		domainlessRoomIDs:                      true,
+		privilegedCreators:                     true,
+	},
 	RoomVersionPseudoIDs: RoomVersionImpl{ // currently, just a copy of V10
 		ver:                                    RoomVersionPseudoIDs,
 		stable:                                 false,
@@ -445,6 +471,8 @@ type RoomVersionImpl struct {
 	newEventFromUntrustedJSONFunc          func(eventJSON []byte, roomVersion IRoomVersion) (result PDU, err error)
 	newEventFromTrustedJSONFunc            func(eventJSON []byte, redacted bool, roomVersion IRoomVersion) (result PDU, err error)
 	newEventFromTrustedJSONWithEventIDFunc func(eventID string, eventJSON []byte, redacted bool, roomVersion IRoomVersion) (result PDU, err error)
+	domainlessRoomIDs                      bool
+	privilegedCreators                     bool
 }
 
 type restrictedJoinCheckFunc func(ctx context.Context, localServerName spec.ServerName, roomQuerier RestrictedRoomJoinQuerier, roomID spec.RoomID, senderID spec.SenderID) (string, error)
@@ -512,6 +540,20 @@ func (v RoomVersionImpl) CheckCreateEvent(event PDU, knownRoomVersion knownRoomV
 	return v.checkCreateEvent(event, knownRoomVersion)
 }
 
+// DomainlessRoomIDs returns true if the room ID is derived from the reference
+// hash of the create event, rather than being chosen by the creating server.
+// The create event has no room_id and is implied in the auth_events of every
+// other event in the room.
+func (v RoomVersionImpl) DomainlessRoomIDs() bool {
+	return v.domainlessRoomIDs
+}
+
+// PrivilegedCreators returns true if the room creators have an infinite power
+// level, which can't be changed by the m.room.power_levels event.
+func (v RoomVersionImpl) PrivilegedCreators() bool {
+	return v.privilegedCreators
+}
+
 func (v RoomVersionImpl) CheckRestrictedJoin(
 	ctx context.Context,
 	localServerName spec.ServerName,
@@ -36,8 +36,17 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName is the instrumentation name used for spans created by the
+// federation client.
+const tracerName = "github.com/jchv/maidtrix/internal/matrixserver/fclient"
+
 // Default HTTPS request timeout
 const requestTimeout time.Duration = time.Duration(30) * time.Second
 
@@ -652,12 +661,33 @@ func (fc *Client) DoHTTPRequest(ctx context.Context, req *http.Request) (*http.R
 		req.Header.Set("User-Agent", fc.userAgent)
 	}
 
+	// Start a client span for the request and pass the trace context on
+	// to the remote server in the request headers. The headers are not
+	// covered by the federation request signature, so this is safe.
+	newCtx, span := otel.Tracer(tracerName).Start(
+		newCtx, req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	defer span.End()
+	otel.GetTextMapPropagator().Inject(newCtx, propagation.HeaderCarrier(req.Header))
+
 	start := time.Now()
 	resp, err := fc.client.Do(req.WithContext(newCtx))
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		logger.WithContext(ctx).WithField("error", err).Debug("Outgoing request failed")
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
 
 	// we haven't yet read the body, so this is slightly premature, but it's the easiest place.
 	logger.WithFields(logrus.Fields{
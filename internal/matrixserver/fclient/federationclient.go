@@ -56,7 +56,7 @@ type FederationClient interface {
 		includeAllNetworks bool, thirdPartyInstanceID string,
 	) (res RespPublicRooms, err error)
 	GetPublicRoomsFiltered(
-		ctx context.Context, origin, s spec.ServerName, limit int, since, filter string,
+		ctx context.Context, origin, s spec.ServerName, limit int, since, filter string, roomTypes []string,
 		includeAllNetworks bool, thirdPartyInstanceID string,
 	) (res RespPublicRooms, err error)
 
@@ -544,12 +544,13 @@ func (ac *federationClient) GetPublicRooms(
 	ctx context.Context, origin, s spec.ServerName, limit int, since string,
 	includeAllNetworks bool, thirdPartyInstanceID string,
 ) (res RespPublicRooms, err error) {
-	return ac.GetPublicRoomsFiltered(ctx, origin, s, limit, since, "", includeAllNetworks, thirdPartyInstanceID)
+	return ac.GetPublicRoomsFiltered(ctx, origin, s, limit, since, "", nil, includeAllNetworks, thirdPartyInstanceID)
 }
 
 // searchTerm is used when querying e.g. remote public rooms
 type searchTerm struct {
-	GenericSearchTerm string `json:"generic_search_term,omitempty"`
+	GenericSearchTerm string    `json:"generic_search_term,omitempty"`
+	RoomTypes         []*string `json:"room_types,omitempty"`
 }
 
 // postPublicRoomsReq is a request to /publicRooms
@@ -564,16 +565,27 @@ type postPublicRoomsReq struct {
 // GetPublicRoomsFiltered gets a filtered public rooms list from the target homeserver's directory.
 // Spec: https://spec.matrix.org/v1.1/server-server-api/#post_matrixfederationv1publicrooms
 // thirdPartyInstanceID can only be non-empty if includeAllNetworks is false.
+// If roomTypes isn't empty then only rooms of those types are returned, where an
+// empty room type matches rooms without a type.
 func (ac *federationClient) GetPublicRoomsFiltered(
-	ctx context.Context, origin, s spec.ServerName, limit int, since, filter string,
+	ctx context.Context, origin, s spec.ServerName, limit int, since, filter string, roomTypes []string,
 	includeAllNetworks bool, thirdPartyInstanceID string,
 ) (res RespPublicRooms, err error) {
 	if includeAllNetworks && thirdPartyInstanceID != "" {
 		return res, fmt.Errorf("thirdPartyInstanceID can only be used if includeAllNetworks is false")
 	}
 
+	var types []*string
+	for i := range roomTypes {
+		if roomTypes[i] == "" {
+			// Rooms without a type are matched by null.
+			types = append(types, nil)
+		} else {
+			types = append(types, &roomTypes[i])
+		}
+	}
 	roomsReq := postPublicRoomsReq{
-		PublicRoomsFilter:    searchTerm{GenericSearchTerm: filter},
+		PublicRoomsFilter:    searchTerm{GenericSearchTerm: filter, RoomTypes: types},
 		Limit:                limit,
 		IncludeAllNetworks:   includeAllNetworks,
 		ThirdPartyInstanceID: thirdPartyInstanceID,
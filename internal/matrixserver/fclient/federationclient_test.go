@@ -282,3 +282,52 @@ func jsonify(x interface{}) string {
 	b, _ := json.Marshal(x)
 	return string(b)
 }
+
+func TestGetPublicRoomsFilteredSendsRoomTypes(t *testing.T) {
+	serverName := spec.ServerName("local.server.name")
+	targetServerName := spec.ServerName("target.server.name")
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+
+	var gotFilter json.RawMessage
+	fc := fclient.NewFederationClient(
+		[]*fclient.SigningIdentity{
+			{
+				ServerName: serverName,
+				KeyID:      gomatrixserverlib.KeyID("ed25519:auto"),
+				PrivateKey: privateKey,
+			},
+		},
+		fclient.WithSkipVerify(true),
+		fclient.WithTransport(
+			&roundTripper{
+				fn: func(req *http.Request) (*http.Response, error) {
+					if req.URL.Path != "/_matrix/federation/v1/publicRooms" {
+						return nil, fmt.Errorf("test: unexpected url path: %s", req.URL.Path)
+					}
+					var body struct {
+						Filter json.RawMessage `json:"filter"`
+					}
+					if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+						return nil, err
+					}
+					gotFilter = body.Filter
+					return &http.Response{
+						StatusCode: 200,
+						Body:       io.NopCloser(strings.NewReader(`{"chunk":[]}`)),
+					}, nil
+				},
+			},
+		),
+	)
+
+	_, err := fc.GetPublicRoomsFiltered(
+		context.Background(), serverName, targetServerName, 10, "", "term", []string{"m.space", ""}, false, "",
+	)
+	if err != nil {
+		t.Fatalf("GetPublicRoomsFiltered returned an error: %s", err)
+	}
+	want := `{"generic_search_term":"term","room_types":["m.space",null]}`
+	if string(gotFilter) != want {
+		t.Fatalf("got filter %s, want %s", gotFilter, want)
+	}
+}
@@ -12,6 +12,12 @@ type FederatedJoinClient interface {
 	SendJoin(ctx context.Context, origin, s spec.ServerName, event PDU) (res SendJoinResponse, err error)
 }
 
+// FederatedPartialStateJoinClient is implemented by join clients which can
+// ask the remote server to omit membership events from the send_join response.
+type FederatedPartialStateJoinClient interface {
+	SendJoinPartialState(ctx context.Context, origin, s spec.ServerName, event PDU) (res SendJoinResponse, err error)
+}
+
 type RestrictedRoomJoinInfo struct {
 	LocalServerInRoom bool
 	UserJoinedToRoom  bool
@@ -18,6 +18,10 @@ type PerformJoinInput struct {
 	Content    map[string]interface{} // The membership event content
 	Unsigned   map[string]interface{} // The event unsigned content, if any
 
+	// PartialState requests a join which omits the membership events from
+	// the returned state, if the federation client supports it.
+	PartialState bool
+
 	PrivateKey ed25519.PrivateKey // Used to sign the join event
 	KeyID      KeyID              // Used to sign the join event
 	KeyRing    *KeyRing           // Used to verify the response from send_join
@@ -31,6 +35,13 @@ type PerformJoinInput struct {
 type PerformJoinResponse struct {
 	JoinEvent     PDU
 	StateSnapshot StateResponse
+
+	// PartialState is true if the remote server omitted membership events
+	// from the state snapshot. The full state must then be fetched later.
+	PartialState bool
+	// ServersInRoom is the list of servers that were in the room at the time
+	// of the join, as reported by the remote server for partial state joins.
+	ServersInRoom []spec.ServerName
 }
 
 // PerformJoin provides high level functionality that will attempt a federated room
@@ -196,13 +207,27 @@ func PerformJoin(
 	}
 
 	var respState StateResponse
-	// Try to perform a send_join using the newly built event.
-	respSendJoin, err := fedClient.SendJoin(
-		context.Background(),
-		origOrigin,
-		input.ServerName,
-		event,
-	)
+	// Try to perform a send_join using the newly built event. Partial state
+	// joins aren't attempted for pseudo ID rooms, as we need the membership
+	// events of everyone in the room to learn their mxid_mappings.
+	var respSendJoin SendJoinResponse
+	partialClient, supportsPartialState := fedClient.(FederatedPartialStateJoinClient)
+	partialState := input.PartialState && supportsPartialState && roomVersion != RoomVersionPseudoIDs
+	if partialState {
+		respSendJoin, err = partialClient.SendJoinPartialState(
+			context.Background(),
+			origOrigin,
+			input.ServerName,
+			event,
+		)
+	} else {
+		respSendJoin, err = fedClient.SendJoin(
+			context.Background(),
+			origOrigin,
+			input.ServerName,
+			event,
+		)
+	}
 	if err != nil {
 		return nil, &FederationError{
 			ServerName: input.ServerName,
@@ -287,10 +312,17 @@ func PerformJoin(
 		}
 	}
 
-	return &PerformJoinResponse{
+	response := &PerformJoinResponse{
 		JoinEvent:     event,
 		StateSnapshot: respState,
-	}, nil
+	}
+	if partialState && respSendJoin.GetMembersOmitted() {
+		response.PartialState = true
+		for _, serverName := range respSendJoin.GetServersInRoom() {
+			response.ServersInRoom = append(response.ServersInRoom, spec.ServerName(serverName))
+		}
+	}
+	return response, nil
 }
 
 func storeMXIDMappings(
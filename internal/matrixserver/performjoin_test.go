@@ -81,6 +81,16 @@ func (t *TestFederatedJoinClient) SendJoin(ctx context.Context, origin, s spec.S
 	return &TestSendJoinResponse{createEvent: t.createEvent, joinEvent: t.joinEvent}, nil
 }
 
+type TestPartialStateJoinClient struct {
+	TestFederatedJoinClient
+	sentPartialState bool
+}
+
+func (t *TestPartialStateJoinClient) SendJoinPartialState(ctx context.Context, origin, s spec.ServerName, event PDU) (res SendJoinResponse, err error) {
+	t.sentPartialState = true
+	return t.SendJoin(ctx, origin, s, event)
+}
+
 type joinKeyDatabase struct{ key ed25519.PublicKey }
 
 func (db joinKeyDatabase) FetcherName() string {
@@ -304,9 +314,34 @@ func TestPerformJoin(t *testing.T) {
 				if res.JoinEvent.Version() != tc.ExpectedRoomVersion {
 					t.Fatalf("Expected room version %v, got %v", tc.ExpectedRoomVersion, res.JoinEvent.Version())
 				}
+				if res.PartialState {
+					t.Fatalf("Expected full state join")
+				}
 			}
 		})
 	}
+
+	t.Run("partial_state_join", func(t *testing.T) {
+		fedClient := &TestPartialStateJoinClient{
+			TestFederatedJoinClient: TestFederatedJoinClient{roomVersion: RoomVersionV10, createEvent: createEvent, joinEvent: joinEvent, joinEventBuilder: joinProto},
+		}
+		res, err := PerformJoin(context.Background(), fedClient, PerformJoinInput{
+			UserID:        userID,
+			RoomID:        roomID,
+			PrivateKey:    sk,
+			KeyID:         keyID,
+			KeyRing:       &KeyRing{[]KeyFetcher{&TestRequestKeyDummy{}}, &joinKeyDatabase{key: pk}},
+			EventProvider: eventProvider,
+			UserIDQuerier: UserIDForSenderTest,
+			PartialState:  true,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected err: %v", err)
+		}
+		assert.True(t, fedClient.sentPartialState)
+		assert.True(t, res.PartialState)
+		assert.Equal(t, []spec.ServerName{"server"}, res.ServersInRoom)
+	})
 }
 
 func TestPerformJoinPseudoID(t *testing.T) {
@@ -29,7 +29,8 @@ func (room RoomID) OpaqueID() string {
 	return room.opaqueID
 }
 
-// Returns just the domain of the roomID
+// Returns just the domain of the roomID. This is empty for room IDs that
+// are derived from the create event, from room version 12 onwards.
 func (room RoomID) Domain() ServerName {
 	return ServerName(room.domain)
 }
@@ -47,6 +48,11 @@ func parseAndValidateRoomID(id string) (*RoomID, error) {
 
 	opaqueID, domain, found := strings.Cut(id[1:], string(localDomainSeparator))
 	if !found {
+		// From room version 12, the room ID is the reference hash of the
+		// create event and has no domain.
+		if isReferenceHash(opaqueID) {
+			return &RoomID{raw: id, opaqueID: opaqueID}, nil
+		}
 		return nil, fmt.Errorf("at least one '%c' is expected in the room id", localDomainSeparator)
 	}
 	if _, _, ok := ParseAndValidateServerName(ServerName(domain)); !ok {
@@ -66,3 +72,19 @@ func parseAndValidateRoomID(id string) (*RoomID, error) {
 	}
 	return roomID, nil
 }
+
+// isReferenceHash returns true if the string is an unpadded URL-safe base64
+// encoded SHA-256 hash, as used for event IDs and domainless room IDs.
+func isReferenceHash(s string) bool {
+	if len(s) != 43 {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
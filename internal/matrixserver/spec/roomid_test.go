@@ -63,6 +63,30 @@ func TestInvalidRoomIDs(t *testing.T) {
 	}
 }
 
+func TestDomainlessRoomIDs(t *testing.T) {
+	raw := "!31hneApxJ_1o-63DmFrpeqnkFfWppnzWso1JvH3ogLM"
+	roomID, err := spec.NewRoomID(raw)
+	if err != nil {
+		t.Fatalf("valid roomID should not fail: %s", err.Error())
+	}
+	if roomID.OpaqueID() != raw[1:] {
+		t.Fatalf("OpaqueID - Expected: %s Actual: %s ", raw[1:], roomID.OpaqueID())
+	}
+	if roomID.Domain() != "" {
+		t.Fatalf("Domain - Expected no domain, Actual: %s ", roomID.Domain())
+	}
+
+	for _, invalid := range []string{
+		"!31hneApxJ_1o-63DmFrpeqnkFfWppnzWso1JvH3ogL",   // too short
+		"!31hneApxJ_1o-63DmFrpeqnkFfWppnzWso1JvH3ogLM=", // padded
+		"!31hneApxJ/1o+63DmFrpeqnkFfWppnzWso1JvH3ogLM",  // not URL-safe
+	} {
+		if _, err = spec.NewRoomID(invalid); err == nil {
+			t.Fatalf("roomID %q is not valid, it shouldn't parse", invalid)
+		}
+	}
+}
+
 func TestSameRoomIDsAreEqual(t *testing.T) {
 	id := "!localpart:domain"
 
@@ -391,6 +391,8 @@ func ResolveConflicts(
 		resolved = append(resolved, notConflicted...)
 	case StateResV2:
 		resolved = ResolveStateConflictsV2(conflicted, notConflicted, authEvents, userIDForSender, isRejectedFn)
+	case StateResV21:
+		resolved = ResolveStateConflictsV21(conflicted, notConflicted, authEvents, userIDForSender, isRejectedFn)
 	default:
 		return nil, fmt.Errorf("unsupported state resolution algorithm %v", stateResAlgo)
 	}
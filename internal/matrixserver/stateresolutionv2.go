@@ -60,6 +60,29 @@ func ResolveStateConflictsV2(
 	authEvents []PDU,
 	userIDForSender spec.UserIDForSender,
 	isRejectedFn IsRejected,
+) []PDU {
+	return resolveStateConflictsV2(conflicted, unconflicted, authEvents, userIDForSender, isRejectedFn, false)
+}
+
+// ResolveStateConflictsV21 is ResolveStateConflictsV2 with the changes from
+// state resolution v2.1: the conflicted state subgraph is added to the full
+// conflicted set, and the iterative auth checks start from empty state rather
+// than from the unconflicted state.
+func ResolveStateConflictsV21(
+	conflicted, unconflicted,
+	authEvents []PDU,
+	userIDForSender spec.UserIDForSender,
+	isRejectedFn IsRejected,
+) []PDU {
+	return resolveStateConflictsV2(conflicted, unconflicted, authEvents, userIDForSender, isRejectedFn, true)
+}
+
+func resolveStateConflictsV2(
+	conflicted, unconflicted,
+	authEvents []PDU,
+	userIDForSender spec.UserIDForSender,
+	isRejectedFn IsRejected,
+	stateResV21 bool,
 ) []PDU {
 	// Prepare the state resolver.
 	conflictedControlEvents := make([]PDU, 0, len(conflicted))
@@ -110,6 +133,9 @@ func ResolveStateConflictsV2(
 	// Get the full conflicted set, that is the conflicted events and the
 	// auth difference (events that don't appear in all auth chains).
 	fullConflictedSet := append(conflicted, r.calculateAuthDifference()...)
+	if stateResV21 {
+		fullConflictedSet = append(fullConflictedSet, r.calculateConflictedSubgraph()...)
+	}
 
 	// The full power set function returns the event and all of its auth
 	// events that also happen to appear in the conflicted set. This will
@@ -165,8 +191,13 @@ func ResolveStateConflictsV2(
 	// authing them. The successfully authed events will form the real initial partial
 	// state. We will then keep the successfully authed unconflicted events so that
 	// they can be reapplied later.
+	// In state resolution v2.1, the iterative auth checks start from empty
+	// state instead, so that unconflicted state can't be used to auth events
+	// that it shouldn't have been able to.
 	unconflicted = r.reverseTopologicalOrdering(unconflicted, TopologicalOrderByAuthEvents)
-	r.applyEvents(unconflicted...)
+	if !stateResV21 {
+		r.applyEvents(unconflicted...)
+	}
 
 	// Then order the conflicted power level events topologically and then also
 	// auth those too. The successfully authed events will be layered on top of
@@ -339,6 +370,60 @@ func (r *stateResolverV2) calculateAuthDifference() []PDU {
 	return authDifference
 }
 
+// calculateConflictedSubgraph returns the events in the auth DAG which are
+// both descendants of one conflicted event and ancestors of another, i.e.
+// those events which lie on an auth chain between two conflicted events.
+func (r *stateResolverV2) calculateConflictedSubgraph() []PDU {
+	// reachesConflicted caches whether an event has a conflicted event
+	// somewhere in its auth chain.
+	reachesConflicted := make(map[string]bool, len(r.authEventMap))
+	var reaches func(event PDU) bool
+	reaches = func(event PDU) bool {
+		if result, ok := reachesConflicted[event.EventID()]; ok {
+			return result
+		}
+		// Guard against cycles in malformed auth DAGs.
+		reachesConflicted[event.EventID()] = false
+		result := false
+		for _, authEventID := range event.AuthEventIDs() {
+			if _, ok := r.conflictedEventMap[authEventID]; ok {
+				result = true
+			}
+			if authEvent, ok := r.authEventMap[authEventID]; ok && reaches(authEvent) {
+				result = true
+			}
+		}
+		reachesConflicted[event.EventID()] = result
+		return result
+	}
+
+	// Walk the auth chains of the conflicted events, keeping any event that
+	// itself has a conflicted event in its auth chain.
+	subgraph := make([]PDU, 0, len(r.conflictedEventMap))
+	visited := make(map[string]struct{}, len(r.authEventMap))
+	var iter func(event PDU)
+	iter = func(event PDU) {
+		for _, authEventID := range event.AuthEventIDs() {
+			if _, ok := visited[authEventID]; ok {
+				continue
+			}
+			visited[authEventID] = struct{}{}
+			authEvent, ok := r.authEventMap[authEventID]
+			if !ok {
+				continue
+			}
+			if _, conflicted := r.conflictedEventMap[authEventID]; !conflicted && reaches(authEvent) {
+				subgraph = append(subgraph, authEvent)
+			}
+			iter(authEvent)
+		}
+	}
+	for _, conflictedEvent := range r.conflictedEventMap {
+		iter(conflictedEvent)
+	}
+	return subgraph
+}
+
 // createPowerLevelMainline generates the mainline of power level events,
 // starting at the currently resolved power level event from the topological
 // ordering and working our way back to the room creation. Note that we populate
@@ -459,7 +544,7 @@ func (r *stateResolverV2) authAndApplyEvents(events ...PDU) {
 			if authEv.Type() != eventType || !authEv.StateKeyEquals(stateKey) {
 				continue
 			}
-			_ = r.authProvider.AddEvent(event)
+			_ = r.authProvider.AddEvent(authEv)
 		}
 	}
 
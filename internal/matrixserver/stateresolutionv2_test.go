@@ -306,6 +306,96 @@ func TestStateResolutionBanVsPowerLevel(t *testing.T) {
 	}, expected)
 }
 
+// TestStateResolutionV21AuthEventsFromEvent checks that events are authed
+// against their own auth events when nothing has been resolved yet, which is
+// always the case at the start of state resolution v2.1.
+func TestStateResolutionV21AuthEventsFromEvent(t *testing.T) {
+	expected := []string{
+		"$CREATE:example.com", "$IJR:example.com", "$PB:example.com",
+		"$IMA:example.com", "$IMB:example.com", "$IMC:example.com",
+	}
+
+	runStateResolutionV21(t, []PDU{
+		&eventV1{
+			roomVersion: RoomVersionV2,
+			EventIDRaw:  "$PA:example.com",
+			eventFields: eventFields{
+				RoomID:         "!ROOM:example.com",
+				Type:           spec.MRoomPowerLevels,
+				OriginServerTS: 7,
+				SenderID:       ALICE,
+				StateKey:       &emptyStateKey,
+				Content: []byte(`{"users": {
+					"` + ALICE + `": 100,
+					"` + BOB + `": 50
+				}}`),
+			},
+			PrevEvents: []eventReference{
+				{EventID: "$IMC:example.com"},
+			},
+			AuthEvents: []eventReference{
+				{EventID: "$CREATE:example.com"},
+				{EventID: "$IMA:example.com"},
+				{EventID: "$IPOWER:example.com"},
+			},
+		},
+		&eventV1{
+			roomVersion: RoomVersionV2,
+			EventIDRaw:  "$PB:example.com",
+			eventFields: eventFields{
+				RoomID:         "!ROOM:example.com",
+				Type:           spec.MRoomPowerLevels,
+				OriginServerTS: 8,
+				SenderID:       ALICE,
+				StateKey:       &emptyStateKey,
+				Content: []byte(`{"users": {
+					"` + ALICE + `": 100,
+					"` + CHARLIE + `": 50
+				}}`),
+			},
+			PrevEvents: []eventReference{
+				{EventID: "$PA:example.com"},
+			},
+			AuthEvents: []eventReference{
+				{EventID: "$CREATE:example.com"},
+				{EventID: "$IMA:example.com"},
+				{EventID: "$PA:example.com"},
+			},
+		},
+	}, expected)
+}
+
+func TestStateResolutionV21Base(t *testing.T) {
+	expected := []string{
+		"$CREATE:example.com", "$IJR:example.com", "$IPOWER:example.com",
+		"$IMA:example.com", "$IMB:example.com", "$IMC:example.com",
+	}
+
+	runStateResolutionV21(t, []PDU{}, expected)
+}
+
+func TestCalculateConflictedSubgraph(t *testing.T) {
+	input := getBaseStateResV2Graph()
+	authEventMap := eventMapFromEvents(input)
+	// $IPOWER and $IJR lie on the auth chain from $IMB back to $IMA, but
+	// $CREATE is only an ancestor of both and $IMC isn't an ancestor of either.
+	r := stateResolverV2{
+		authEventMap: authEventMap,
+		conflictedEventMap: eventMapFromEvents([]PDU{
+			authEventMap["$IMA:example.com"], authEventMap["$IMB:example.com"],
+		}),
+	}
+	var got []string
+	for _, event := range r.calculateConflictedSubgraph() {
+		got = append(got, event.EventID())
+	}
+	slices.Sort(got)
+	expected := []string{"$IJR:example.com", "$IPOWER:example.com"}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("got conflicted subgraph %v, expected %v", got, expected)
+	}
+}
+
 func TestStateResolutionJoinRuleEvasion(t *testing.T) {
 	expected := []string{
 		"$CREATE:example.com", "$JR:example.com", "$IPOWER:example.com",
@@ -485,11 +575,25 @@ func TestStateResolutionOtherEventDoesntOverpowerPowerEvent(t *testing.T) {
 }
 
 func runStateResolutionV2(t *testing.T, additional []PDU, expected []string) {
+	t.Helper()
+	runStateResolution(t, ResolveStateConflictsV2, additional, expected)
+}
+
+func runStateResolutionV21(t *testing.T, additional []PDU, expected []string) {
+	t.Helper()
+	runStateResolution(t, ResolveStateConflictsV21, additional, expected)
+}
+
+func runStateResolution(
+	t *testing.T,
+	resolve func(conflicted, unconflicted, authEvents []PDU, userIDForSender spec.UserIDForSender, isRejectedFn IsRejected) []PDU,
+	additional []PDU, expected []string,
+) {
 	t.Helper()
 	input := append(getBaseStateResV2Graph(), additional...)
 	conflicted, unconflicted := separate(input)
 
-	result := ResolveStateConflictsV2(
+	result := resolve(
 		conflicted,   // conflicted set
 		unconflicted, // unconflicted set
 		input,        // full auth set
@@ -0,0 +1,225 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spamcheck asks an external anti-spam service whether events,
+// invites, registrations and so on should be accepted.
+package spamcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/setup/config"
+)
+
+// Verdict is the decision returned by the spam checker.
+type Verdict string
+
+const (
+	// VerdictAllow accepts the action as normal.
+	VerdictAllow Verdict = "allow"
+	// VerdictDeny rejects the action.
+	VerdictDeny Verdict = "deny"
+	// VerdictSoftFail stores the event but soft-fails it, so that it isn't
+	// sent to clients or used as a forward extremity. Only valid for events.
+	VerdictSoftFail Verdict = "soft_fail"
+	// VerdictRedact accepts the event but redacts its content before it is
+	// stored. Only valid for events.
+	VerdictRedact Verdict = "redact"
+)
+
+// The callbacks that the spam checker is called with. The callback name is
+// appended to the configured URL.
+const (
+	CallbackCheckEventForSpam        = "check_event_for_spam"
+	CallbackUserMayInvite            = "user_may_invite"
+	CallbackUserMayCreateRoom        = "user_may_create_room"
+	CallbackCheckRegistrationForSpam = "check_registration_for_spam"
+	CallbackUserMayChangeProfile     = "user_may_change_profile"
+	CallbackCheckMediaFileForSpam    = "check_media_file_for_spam"
+)
+
+// Result is the response from the spam checker.
+type Result struct {
+	Verdict Verdict `json:"verdict"`
+	// An optional human readable reason, which is returned to the client
+	// if the action is denied.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Allowed returns true if the action should go ahead.
+func (r Result) Allowed() bool {
+	return r.Verdict == VerdictAllow
+}
+
+// Forbidden returns the response to send to the client if the action
+// was not allowed.
+func (r Result) Forbidden() util.JSONResponse {
+	reason := r.Reason
+	if reason == "" {
+		reason = "This request has been rejected as spam"
+	}
+	return util.JSONResponse{
+		Code: http.StatusForbidden,
+		JSON: spec.Forbidden(reason),
+	}
+}
+
+// Checker calls out to the spam checking service. A nil *Checker is valid
+// and allows everything, so callers don't need to check whether spam
+// checking is enabled.
+type Checker struct {
+	url      string
+	failOpen bool
+	hc       *http.Client
+}
+
+// New creates a spam checker from the given configuration, or returns nil
+// if spam checking is disabled.
+func New(cfg *config.SpamChecker) *Checker {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &Checker{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		failOpen: cfg.FailOpen,
+		hc: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
+		},
+	}
+}
+
+// CheckEvent checks an event sent by a local user, or received over
+// federation from the origin server. Any of the verdicts may be returned.
+func (c *Checker) CheckEvent(ctx context.Context, event gomatrixserverlib.PDU, origin spec.ServerName) Result {
+	return c.check(ctx, CallbackCheckEventForSpam, struct {
+		Event  json.RawMessage `json:"event"`
+		Origin spec.ServerName `json:"origin"`
+	}{event.JSON(), origin}, true)
+}
+
+// UserMayInvite checks whether the local inviter may invite the invitee
+// to the room.
+func (c *Checker) UserMayInvite(ctx context.Context, inviter, invitee, roomID string) Result {
+	return c.check(ctx, CallbackUserMayInvite, struct {
+		Inviter string `json:"inviter"`
+		Invitee string `json:"invitee"`
+		RoomID  string `json:"room_id"`
+	}{inviter, invitee, roomID}, false)
+}
+
+// UserMayCreateRoom checks whether the local user may create a room with
+// the given /createRoom request body.
+func (c *Checker) UserMayCreateRoom(ctx context.Context, userID string, request interface{}) Result {
+	return c.check(ctx, CallbackUserMayCreateRoom, struct {
+		UserID  string      `json:"user_id"`
+		Request interface{} `json:"request"`
+	}{userID, request}, false)
+}
+
+// CheckRegistration checks whether an account may be registered with the
+// given localpart from the given IP address.
+func (c *Checker) CheckRegistration(ctx context.Context, localpart, ipAddress, userAgent, authType string) Result {
+	return c.check(ctx, CallbackCheckRegistrationForSpam, struct {
+		Localpart string `json:"localpart"`
+		IPAddress string `json:"ip_address"`
+		UserAgent string `json:"user_agent"`
+		AuthType  string `json:"auth_type"`
+	}{localpart, ipAddress, userAgent, authType}, false)
+}
+
+// UserMayChangeProfile checks whether the local user may change a profile
+// field, i.e. "displayname" or "avatar_url", to the given value.
+func (c *Checker) UserMayChangeProfile(ctx context.Context, userID, field, value string) Result {
+	return c.check(ctx, CallbackUserMayChangeProfile, struct {
+		UserID string `json:"user_id"`
+		Field  string `json:"field"`
+		Value  string `json:"value"`
+	}{userID, field, value}, false)
+}
+
+// CheckMediaFile checks whether the local user may upload a file.
+func (c *Checker) CheckMediaFile(ctx context.Context, userID, contentType, uploadName, base64SHA256 string, size int64) Result {
+	return c.check(ctx, CallbackCheckMediaFileForSpam, struct {
+		UserID       string `json:"user_id"`
+		ContentType  string `json:"content_type"`
+		UploadName   string `json:"upload_name"`
+		Base64SHA256 string `json:"sha256"`
+		Size         int64  `json:"size"`
+	}{userID, contentType, uploadName, base64SHA256, size}, false)
+}
+
+// check calls the callback and returns the verdict. If the service fails
+// to respond properly then the configured fail-open policy decides the
+// verdict. Only event checks may return verdicts other than allow or deny;
+// for anything else they are treated as a deny.
+func (c *Checker) check(ctx context.Context, callback string, req interface{}, isEvent bool) Result {
+	if c == nil {
+		return Result{Verdict: VerdictAllow}
+	}
+	res, err := c.call(ctx, callback, req)
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).WithField("callback", callback).Warn("Spam checker failed")
+		if c.failOpen {
+			return Result{Verdict: VerdictAllow}
+		}
+		return Result{Verdict: VerdictDeny, Reason: "Unable to check this request for spam"}
+	}
+	if !isEvent && res.Verdict != VerdictAllow {
+		res.Verdict = VerdictDeny
+	}
+	return res
+}
+
+func (c *Checker) call(ctx context.Context, callback string, req interface{}) (Result, error) {
+	var res Result
+	body, err := json.Marshal(req)
+	if err != nil {
+		return res, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/"+callback, bytes.NewReader(body))
+	if err != nil {
+		return res, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+
+	hresp, err := c.hc.Do(hreq)
+	if err != nil {
+		return res, err
+	}
+	defer hresp.Body.Close() // nolint: errcheck
+
+	if hresp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("spam checker: %d from %s", hresp.StatusCode, callback)
+	}
+	if err = json.NewDecoder(hresp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("spam checker: invalid response from %s: %w", callback, err)
+	}
+	switch res.Verdict {
+	case VerdictAllow, VerdictDeny, VerdictSoftFail, VerdictRedact:
+		return res, nil
+	default:
+		return res, fmt.Errorf("spam checker: unknown verdict %q from %s", res.Verdict, callback)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spamcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStandIn starts a stand-in spam checking service which returns the
+// verdict for each callback, or a 500 if there isn't one.
+func newStandIn(t *testing.T, verdicts map[string]Result, delay time.Duration) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		time.Sleep(delay)
+		res, ok := verdicts[r.URL.Path[1:]]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNilCheckerAllows(t *testing.T) {
+	c := New(&config.SpamChecker{})
+	assert.Nil(t, c)
+	assert.True(t, c.UserMayCreateRoom(context.Background(), "@alice:test", nil).Allowed())
+}
+
+func TestVerdicts(t *testing.T) {
+	srv := newStandIn(t, map[string]Result{
+		CallbackUserMayInvite:            {Verdict: VerdictAllow},
+		CallbackUserMayCreateRoom:        {Verdict: VerdictDeny, Reason: "no rooms for you"},
+		CallbackCheckRegistrationForSpam: {Verdict: VerdictSoftFail},
+		CallbackUserMayChangeProfile:     {Verdict: "something_else"},
+	}, 0)
+	c := New(&config.SpamChecker{URL: srv.URL + "/", Timeout: time.Second, FailOpen: true})
+	ctx := context.Background()
+
+	assert.Equal(t, Result{Verdict: VerdictAllow}, c.UserMayInvite(ctx, "@alice:test", "@bob:test", "!room:test"))
+
+	res := c.UserMayCreateRoom(ctx, "@alice:test", map[string]string{"name": "spam"})
+	assert.Equal(t, Result{Verdict: VerdictDeny, Reason: "no rooms for you"}, res)
+	assert.Equal(t, http.StatusForbidden, res.Forbidden().Code)
+
+	// Only events can be soft-failed or redacted, so anything else is a deny.
+	assert.Equal(t, VerdictDeny, c.CheckRegistration(ctx, "alice", "127.0.0.1", "", "m.login.dummy").Verdict)
+
+	// Unknown verdicts are treated as a failure, which is fail-open here.
+	assert.Equal(t, VerdictAllow, c.UserMayChangeProfile(ctx, "@alice:test", "displayname", "spam").Verdict)
+
+	// The stand-in returns an error for callbacks without a verdict.
+	assert.Equal(t, VerdictAllow, c.CheckMediaFile(ctx, "@alice:test", "image/png", "spam.png", "", 1).Verdict)
+}
+
+func TestFailurePolicy(t *testing.T) {
+	srv := newStandIn(t, map[string]Result{
+		CallbackUserMayInvite: {Verdict: VerdictAllow},
+	}, time.Millisecond*200)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name     string
+		failOpen bool
+		want     Verdict
+	}{
+		{name: "fail open", failOpen: true, want: VerdictAllow},
+		{name: "fail closed", failOpen: false, want: VerdictDeny},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := New(&config.SpamChecker{URL: srv.URL, Timeout: time.Millisecond * 50, FailOpen: tc.failOpen})
+			require.NotNil(t, c)
+			assert.Equal(t, tc.want, c.UserMayInvite(ctx, "@alice:test", "@bob:test", "!room:test").Verdict)
+		})
+	}
+}
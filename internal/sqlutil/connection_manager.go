@@ -15,9 +15,13 @@
 package sqlutil
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"regexp"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jchv/maidtrix/setup/config"
 	"github.com/jchv/maidtrix/setup/process"
@@ -27,6 +31,7 @@ type Connections struct {
 	globalConfig        config.DatabaseOptions
 	processContext      *process.ProcessContext
 	existingConnections sync.Map
+	componentDatabases  atomic.Int32
 }
 
 type con struct {
@@ -70,6 +75,11 @@ func (c *Connections) Connection(dbProperties *config.DatabaseOptions) (*sql.DB,
 		return nil, nil, err
 	}
 	c.existingConnections.Store(dbProperties.ConnectionString, &con{db: db, writer: writer})
+	if c.processContext != nil {
+		c.processContext.RegisterHealthCheck(c.healthCheckName(dbProperties), func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		})
+	}
 	go func() {
 		if c.processContext == nil {
 			return
@@ -84,3 +94,54 @@ func (c *Connections) Connection(dbProperties *config.DatabaseOptions) (*sql.DB,
 	return db, writer, nil
 
 }
+
+// healthCheckName returns the name of the readiness check for a database.
+// The name mustn't reveal anything about the connection string, as the
+// readiness endpoint is unauthenticated, so the global database is called
+// "database" and the databases of individual components are numbered in
+// the order they are opened.
+func (c *Connections) healthCheckName(dbProperties *config.DatabaseOptions) string {
+	if dbProperties.ConnectionString == c.globalConfig.ConnectionString {
+		return "database"
+	}
+	return "database/" + strconv.Itoa(int(c.componentDatabases.Add(1)))
+}
+
+// DatabaseSizes returns the on-disk size in bytes of each database that has
+// been opened by this connection manager, keyed by the connection string with
+// any credentials removed.
+func (c *Connections) DatabaseSizes(ctx context.Context) (map[string]int64, error) {
+	sizes := map[string]int64{}
+	var err error
+	c.existingConnections.Range(func(key, value any) bool {
+		dataSource, conn := key.(config.DataSource), value.(*con)
+		if conn.db == nil {
+			return true
+		}
+		query := "SELECT pg_database_size(current_database())"
+		if dataSource.IsSQLite() {
+			query = "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+		}
+		var size int64
+		if err = conn.db.QueryRowContext(ctx, query).Scan(&size); err != nil {
+			err = fmt.Errorf("failed to get size of database %q: %w", redactDataSource(dataSource), err)
+			return false
+		}
+		sizes[redactDataSource(dataSource)] = size
+		return true
+	})
+	return sizes, err
+}
+
+var (
+	dataSourceURLCredentials   = regexp.MustCompile(`://[^@]*@`)
+	dataSourceKeyValuePassword = regexp.MustCompile(`password\s*=\s*(?:'(?:[^'\\]|\\.)*'|\S*)`)
+)
+
+// redactDataSource removes any credentials from the connection string so
+// that it can be safely shown to server administrators. Passwords in
+// key/value connection strings may be quoted, e.g. password='a b'.
+func redactDataSource(dataSource config.DataSource) string {
+	redacted := dataSourceURLCredentials.ReplaceAllLiteralString(string(dataSource), "://")
+	return dataSourceKeyValuePassword.ReplaceAllLiteralString(redacted, "password=***")
+}
@@ -4,6 +4,28 @@ import (
 	"database/sql"
 	"errors"
 	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	prometheus.MustRegister(exclusiveWriterQueueWait)
+}
+
+// exclusiveWriterQueueWait measures how long tasks wait for their turn on an
+// ExclusiveWriter before they start running.
+var exclusiveWriterQueueWait = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Namespace: "dendrite",
+		Subsystem: "sqlutil",
+		Name:      "exclusive_writer_queue_wait_millis",
+		Help:      "How long database writes wait in the exclusive writer queue before running",
+		Buckets: []float64{ // milliseconds
+			0.1, 0.5, 1, 5, 10, 25, 50, 100, 250, 500,
+			1000, 2500, 5000, 10000,
+		},
+	},
 )
 
 // ExclusiveWriter implements sqlutil.Writer.
@@ -27,6 +49,9 @@ type transactionWriterTask struct {
 	txn  *sql.Tx
 	f    func(txn *sql.Tx) error
 	wait chan error
+	// queued is the time at which the task was submitted, so that we
+	// can measure how long it waited before running.
+	queued time.Time
 }
 
 // Do queues a task to be run by a TransactionWriter. The function
@@ -42,10 +67,11 @@ func (w *ExclusiveWriter) Do(db *sql.DB, txn *sql.Tx, f func(txn *sql.Tx) error)
 		go w.run()
 	}
 	task := transactionWriterTask{
-		db:   db,
-		txn:  txn,
-		f:    f,
-		wait: make(chan error, 1),
+		db:     db,
+		txn:    txn,
+		f:      f,
+		wait:   make(chan error, 1),
+		queued: time.Now(),
 	}
 	w.todo <- task
 	return <-task.wait
@@ -62,6 +88,7 @@ func (w *ExclusiveWriter) run() {
 
 	defer w.running.Store(false)
 	for task := range w.todo {
+		exclusiveWriterQueueWait.Observe(float64(time.Since(task.queued).Microseconds()) / 1000)
 		if task.db != nil && task.txn != nil {
 			task.wait <- task.f(task.txn)
 		} else if task.db != nil && task.txn == nil {
@@ -16,29 +16,43 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"runtime/trace"
 
-	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
+// TracerName is the instrumentation name used for all spans created by
+// Dendrite.
+const TracerName = "github.com/jchv/maidtrix"
+
+// Tracer returns the tracer from the globally registered tracer provider.
+// If tracing has not been set up then this will be a no-op tracer.
+func Tracer() oteltrace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
 type Trace struct {
-	span   opentracing.Span
+	span   oteltrace.Span
 	region *trace.Region
 	task   *trace.Task
 }
 
-func StartTask(inCtx context.Context, name string) (Trace, context.Context) {
+func StartTask(inCtx context.Context, name string, opts ...oteltrace.SpanStartOption) (Trace, context.Context) {
 	ctx, task := trace.NewTask(inCtx, name)
-	span, ctx := opentracing.StartSpanFromContext(ctx, name)
+	ctx, span := Tracer().Start(ctx, name, opts...)
 	return Trace{
 		span: span,
 		task: task,
 	}, ctx
 }
 
-func StartRegion(inCtx context.Context, name string) (Trace, context.Context) {
+func StartRegion(inCtx context.Context, name string, opts ...oteltrace.SpanStartOption) (Trace, context.Context) {
 	region := trace.StartRegion(inCtx, name)
-	span, ctx := opentracing.StartSpanFromContext(inCtx, name)
+	ctx, span := Tracer().Start(inCtx, name, opts...)
 	return Trace{
 		span:   span,
 		region: region,
@@ -46,19 +60,51 @@ func StartRegion(inCtx context.Context, name string) (Trace, context.Context) {
 }
 
 func (t Trace) EndRegion() {
-	t.span.Finish()
+	t.span.End()
 	if t.region != nil {
 		t.region.End()
 	}
 }
 
 func (t Trace) EndTask() {
-	t.span.Finish()
+	t.span.End()
 	if t.task != nil {
 		t.task.End()
 	}
 }
 
 func (t Trace) SetTag(key string, value any) {
-	t.span.SetTag(key, value)
+	t.span.SetAttributes(traceAttribute(key, value))
+}
+
+// SetError records the error on the span and marks the span as failed.
+func (t Trace) SetError(err error) {
+	if err == nil {
+		return
+	}
+	t.span.RecordError(err)
+	t.span.SetStatus(codes.Error, err.Error())
+}
+
+// traceAttribute converts an arbitrary value into an OpenTelemetry
+// attribute, falling back to the string representation of the value.
+func traceAttribute(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case []string:
+		return attribute.StringSlice(key, v)
+	case fmt.Stringer:
+		return attribute.String(key, v.String())
+	default:
+		return attribute.String(key, fmt.Sprintf("%v", v))
+	}
 }
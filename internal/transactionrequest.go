@@ -26,6 +26,7 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/roomserver/api"
 	rstypes "github.com/jchv/maidtrix/roomserver/types"
@@ -64,6 +65,7 @@ type TxnReq struct {
 	roomsMu                *MutexByRoom
 	producer               *producers.SyncAPIProducer
 	inboundPresenceEnabled bool
+	spamChecker            *spamcheck.Checker
 }
 
 func NewTxnReq(
@@ -74,6 +76,7 @@ func NewTxnReq(
 	roomsMu *MutexByRoom,
 	producer *producers.SyncAPIProducer,
 	inboundPresenceEnabled bool,
+	spamChecker *spamcheck.Checker,
 	pdus []json.RawMessage,
 	edus []gomatrixserverlib.EDU,
 	origin spec.ServerName,
@@ -88,6 +91,7 @@ func NewTxnReq(
 		roomsMu:                roomsMu,
 		producer:               producer,
 		inboundPresenceEnabled: inboundPresenceEnabled,
+		spamChecker:            spamChecker,
 	}
 
 	t.PDUs = pdus
@@ -177,20 +181,34 @@ func (t *TxnReq) ProcessTransaction(ctx context.Context) (*fclient.RespSend, *ut
 			continue
 		}
 
+		spamResult := t.spamChecker.CheckEvent(ctx, event, t.Origin)
+		switch spamResult.Verdict {
+		case spamcheck.VerdictDeny:
+			util.GetLogger(ctx).Infof("Transaction: Dropping event %q denied by spam checker", event.EventID())
+			results[event.EventID()] = fclient.PDUResult{
+				Error: "Rejected by spam checker",
+			}
+			continue
+		case spamcheck.VerdictRedact:
+			event.Redact()
+		}
+
 		// pass the event to the roomserver which will do auth checks
 		// If the event fail auth checks, gmsl.NotAllowed error will be returned which we be silently
 		// discarded by the caller of this function
-		if err = api.SendEvents(
+		if err = api.SendInputRoomEvents(
 			ctx,
 			t.rsAPI,
-			api.KindNew,
-			[]*rstypes.HeaderedEvent{
-				{PDU: event},
-			},
 			t.Destination,
-			t.Origin,
-			api.DoNotSendToOtherServers,
-			nil,
+			[]api.InputRoomEvent{
+				{
+					Kind:         api.KindNew,
+					Event:        &rstypes.HeaderedEvent{PDU: event},
+					Origin:       t.Origin,
+					SendAsServer: string(api.DoNotSendToOtherServers),
+					SoftFail:     spamResult.Verdict == spamcheck.VerdictSoftFail,
+				},
+			},
 			true,
 		); err != nil {
 			util.GetLogger(ctx).WithError(err).Errorf("Transaction: Couldn't submit event %q to input queue: %s", event.EventID(), err)
@@ -286,7 +304,7 @@ func (t *TxnReq) processEDUs(ctx context.Context) {
 						util.GetLogger(ctx).Debugf("Dropping receipt event where sender domain (%q) doesn't match origin (%q)", domain, t.Origin)
 						continue
 					}
-					if err := t.processReceiptEvent(ctx, userID, roomID, "m.read", mread.Data.TS, mread.EventIDs); err != nil {
+					if err := t.processReceiptEvent(ctx, userID, roomID, "m.read", mread.Data.ThreadID, mread.Data.TS, mread.EventIDs); err != nil {
 						util.GetLogger(ctx).WithError(err).WithFields(logrus.Fields{
 							"sender":  t.Origin,
 							"user_id": userID,
@@ -341,7 +359,7 @@ func (t *TxnReq) processPresence(ctx context.Context, e gomatrixserverlib.EDU) e
 
 // processReceiptEvent sends receipt events to JetStream
 func (t *TxnReq) processReceiptEvent(ctx context.Context,
-	userID, roomID, receiptType string,
+	userID, roomID, receiptType, threadID string,
 	timestamp spec.Timestamp,
 	eventIDs []string,
 ) error {
@@ -354,7 +372,7 @@ func (t *TxnReq) processReceiptEvent(ctx context.Context,
 	}
 	// store every event
 	for _, eventID := range eventIDs {
-		if err := t.producer.SendReceipt(ctx, userID, roomID, eventID, receiptType, timestamp); err != nil {
+		if err := t.producer.SendReceipt(ctx, userID, roomID, eventID, receiptType, threadID, timestamp); err != nil {
 			return fmt.Errorf("unable to set receipt event: %w", err)
 		}
 	}
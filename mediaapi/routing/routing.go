@@ -25,6 +25,7 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/mediaapi/storage"
 	"github.com/jchv/maidtrix/mediaapi/types"
@@ -64,6 +65,7 @@ func Setup(
 	activeThumbnailGeneration := &types.ActiveThumbnailGeneration{
 		PathToResult: map[string]*types.ThumbnailGenerationResult{},
 	}
+	spamChecker := spamcheck.New(&cfg.Global.SpamChecker)
 
 	uploadHandler := httputil.MakeAuthAPI(
 		"upload", userAPI,
@@ -71,7 +73,7 @@ func Setup(
 			if r := rateLimits.Limit(req, dev); r != nil {
 				return *r
 			}
-			return Upload(req, &cfg.MediaAPI, dev, db, activeThumbnailGeneration)
+			return Upload(req, &cfg.MediaAPI, dev, db, activeThumbnailGeneration, spamChecker)
 		},
 	)
 
@@ -28,6 +28,7 @@ import (
 
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/spamcheck"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/mediaapi/fileutils"
 	"github.com/jchv/maidtrix/mediaapi/storage"
@@ -57,13 +58,13 @@ type uploadResponse struct {
 // This implementation supports a configurable maximum file size limit in bytes. If a user tries to upload more than this, they will receive an error that their upload is too large.
 // Uploaded files are processed piece-wise to avoid DoS attacks which would starve the server of memory.
 // TODO: We should time out requests if they have not received any data within a configured timeout period.
-func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db storage.Database, activeThumbnailGeneration *types.ActiveThumbnailGeneration) util.JSONResponse {
+func Upload(req *http.Request, cfg *config.MediaAPI, dev *userapi.Device, db storage.Database, activeThumbnailGeneration *types.ActiveThumbnailGeneration, spamChecker *spamcheck.Checker) util.JSONResponse {
 	r, resErr := parseAndValidateRequest(req, cfg, dev)
 	if resErr != nil {
 		return *resErr
 	}
 
-	if resErr = r.doUpload(req.Context(), req.Body, cfg, db, activeThumbnailGeneration); resErr != nil {
+	if resErr = r.doUpload(req.Context(), req.Body, cfg, db, activeThumbnailGeneration, spamChecker); resErr != nil {
 		return *resErr
 	}
 
@@ -130,6 +131,7 @@ func (r *uploadRequest) doUpload(
 	cfg *config.MediaAPI,
 	db storage.Database,
 	activeThumbnailGeneration *types.ActiveThumbnailGeneration,
+	spamChecker *spamcheck.Checker,
 ) *util.JSONResponse {
 	r.Logger.WithFields(log.Fields{
 		"UploadName":    r.MediaMetadata.UploadName,
@@ -175,6 +177,16 @@ func (r *uploadRequest) doUpload(
 		return requestEntityTooLargeJSONResponse(cfg.MaxFileSizeBytes)
 	}
 
+	spamResult := spamChecker.CheckMediaFile(
+		ctx, string(r.MediaMetadata.UserID), string(r.MediaMetadata.ContentType),
+		string(r.MediaMetadata.UploadName), string(hash), int64(bytesWritten),
+	)
+	if !spamResult.Allowed() {
+		fileutils.RemoveDir(tmpDir, r.Logger)
+		res := spamResult.Forbidden()
+		return &res
+	}
+
 	// Look up the media by the file hash. If we already have the file but under a
 	// different media ID then we won't upload the file again - instead we'll just
 	// add a new metadata entry that refers to the same file.
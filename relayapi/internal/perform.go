@@ -147,6 +147,7 @@ func (r *RelayInternalAPI) processTransaction(txn *gomatrixserverlib.Transaction
 		mu,
 		r.producer,
 		r.presenceEnabledInbound,
+		nil,
 		txn.PDUs,
 		txn.EDUs,
 		txn.Origin,
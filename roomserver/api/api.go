@@ -165,9 +165,17 @@ type QueryMembershipAPI interface {
 	) (map[string]*types.HeaderedEvent, error)
 }
 
+type QueryPartialStateAPI interface {
+	// IsRoomPartialState returns true if the room was joined with partial state
+	// and the full state of the room hasn't been fetched yet. Membership lists
+	// are incomplete until then.
+	IsRoomPartialState(ctx context.Context, roomID spec.RoomID) (bool, error)
+}
+
 // API functions required by the syncapi
 type SyncRoomserverAPI interface {
 	QueryLatestEventsAndStateAPI
+	QueryPartialStateAPI
 	QueryBulkStateContentAPI
 	QuerySenderIDAPI
 	QueryMembershipAPI
@@ -228,6 +236,7 @@ type ClientRoomserverAPI interface {
 	UserRoomPrivateKeyCreator
 	QueryRoomHierarchyAPI
 	DefaultRoomVersionAPI
+	QueryPartialStateAPI
 
 	QueryMembershipForUser(ctx context.Context, req *QueryMembershipForUserRequest, res *QueryMembershipForUserResponse) error
 	QueryMembershipsForRoom(ctx context.Context, req *QueryMembershipsForRoomRequest, res *QueryMembershipsForRoomResponse) error
@@ -237,17 +246,31 @@ type ClientRoomserverAPI interface {
 	QueryKnownUsers(ctx context.Context, req *QueryKnownUsersRequest, res *QueryKnownUsersResponse) error
 	QueryRoomVersionForRoom(ctx context.Context, roomID string) (gomatrixserverlib.RoomVersion, error)
 	QueryPublishedRooms(ctx context.Context, req *QueryPublishedRoomsRequest, res *QueryPublishedRoomsResponse) error
+	// QueryRoomSummary returns a summary of the room for the caller, asking the
+	// given servers over federation if this server isn't joined to the room.
+	// Returns ErrRoomUnknownOrNotAllowed if the caller may not see the room.
+	QueryRoomSummary(ctx context.Context, caller types.DeviceOrServerName, roomID spec.RoomID, vias []string) (*RoomSummary, error)
 
 	GetRoomIDForAlias(ctx context.Context, req *GetRoomIDForAliasRequest, res *GetRoomIDForAliasResponse) error
 	GetAliasesForRoomID(ctx context.Context, req *GetAliasesForRoomIDRequest, res *GetAliasesForRoomIDResponse) error
 
-	PerformCreateRoom(ctx context.Context, userID spec.UserID, roomID spec.RoomID, createRequest *PerformCreateRoomRequest) (string, *util.JSONResponse)
+	// PerformCreateRoom creates a new room, returning the room alias (if any)
+	// and the ID of the new room, which may differ from the given room ID.
+	PerformCreateRoom(ctx context.Context, userID spec.UserID, roomID spec.RoomID, createRequest *PerformCreateRoomRequest) (string, *spec.RoomID, *util.JSONResponse)
 	// PerformRoomUpgrade upgrades a room to a newer version
 	PerformRoomUpgrade(ctx context.Context, roomID string, userID spec.UserID, roomVersion gomatrixserverlib.RoomVersion) (newRoomID string, err error)
 	PerformAdminEvacuateRoom(ctx context.Context, roomID string) (affected []string, err error)
 	PerformAdminEvacuateUser(ctx context.Context, userID string) (affected []string, err error)
 	PerformAdminPurgeRoom(ctx context.Context, roomID string) error
+	// QueryAdminStateGC returns the progress of the state garbage collector.
+	QueryAdminStateGC(ctx context.Context) StateGCProgress
+	// PerformAdminStateGC starts a pass of the state garbage collector, unless
+	// one is already in progress.
+	PerformAdminStateGC(ctx context.Context) StateGCProgress
 	PerformAdminDownloadState(ctx context.Context, roomID, userID string, serverName spec.ServerName) error
+	// PerformAdminMakeRoomAdmin has the most powerful local member of the room give the user
+	// the same power level, returning the user ID of that member.
+	PerformAdminMakeRoomAdmin(ctx context.Context, roomID string, userID spec.UserID) (grantedBy string, err error)
 	PerformPeek(ctx context.Context, req *PerformPeekRequest) (roomID string, err error)
 	PerformUnpeek(ctx context.Context, roomID, userID, deviceID string) error
 	PerformInvite(ctx context.Context, req *PerformInviteRequest) error
@@ -279,6 +302,19 @@ type ClientRoomserverAPI interface {
 	QueryAdminEventReports(ctx context.Context, from, limit uint64, backwards bool, userID, roomID string) ([]QueryAdminEventReportsResponse, int64, error)
 	QueryAdminEventReport(ctx context.Context, reportID uint64) (QueryAdminEventReportResponse, error)
 	PerformAdminDeleteEventReport(ctx context.Context, reportID uint64) error
+	// QueryAdminForwardExtremities returns the forward extremities of the room.
+	QueryAdminForwardExtremities(ctx context.Context, roomID string) ([]AdminForwardExtremity, error)
+	// QueryAdminStateAfterEvent returns the state after the event and how it
+	// differs from the current state of the room.
+	QueryAdminStateAfterEvent(ctx context.Context, roomID, eventID string) (*AdminStateAfterEvent, error)
+	// QueryAdminResolveState runs state resolution over the state after the
+	// given events, or the forward extremities if none are given, without
+	// storing the result.
+	QueryAdminResolveState(ctx context.Context, roomID string, eventIDs []string) (*AdminResolvedState, error)
+	// PerformAdminMergeForwardExtremities has a local member of the room send
+	// a dummy event which references the forward extremities, returning the
+	// ID of the dummy event, or an empty string if there was nothing to merge.
+	PerformAdminMergeForwardExtremities(ctx context.Context, roomID string) (eventID string, err error)
 }
 
 type UserRoomserverAPI interface {
@@ -290,6 +326,9 @@ type UserRoomserverAPI interface {
 	PerformAdminEvacuateUser(ctx context.Context, userID string) (affected []string, err error)
 	PerformJoin(ctx context.Context, req *PerformJoinRequest) (roomID string, joinedVia spec.ServerName, err error)
 	JoinedUserCount(ctx context.Context, roomID string) (int, error)
+	QueryRoomsForUser(ctx context.Context, userID spec.UserID, desiredMembership string) ([]spec.RoomID, error)
+	// QueryRoomStatistics returns aggregate counts of the rooms that we know about.
+	QueryRoomStatistics(ctx context.Context) (*types.RoomStatistics, error)
 }
 
 type FederationRoomserverAPI interface {
@@ -300,11 +339,15 @@ type FederationRoomserverAPI interface {
 	QuerySenderIDAPI
 	QueryRoomHierarchyAPI
 	QueryMembershipAPI
+	QueryPartialStateAPI
 	UserRoomPrivateKeyCreator
 	AssignRoomNID(ctx context.Context, roomID spec.RoomID, roomVersion gomatrixserverlib.RoomVersion) (roomNID types.RoomNID, err error)
 	SigningIdentityFor(ctx context.Context, roomID spec.RoomID, senderID spec.UserID) (fclient.SigningIdentity, error)
 	// QueryServerBannedFromRoom returns whether a server is banned from a room by server ACLs.
 	QueryServerBannedFromRoom(ctx context.Context, req *QueryServerBannedFromRoomRequest, res *QueryServerBannedFromRoomResponse) error
+	// IsBannedByPolicyList returns whether the user, or the room itself, is banned by one of the
+	// configured moderation policy lists, in which case the membership should be refused.
+	IsBannedByPolicyList(ctx context.Context, roomID string, userID spec.UserID, membership string) bool
 	GetRoomIDForAlias(ctx context.Context, req *GetRoomIDForAliasRequest, res *GetRoomIDForAliasResponse) error
 	// QueryEventsByID queries a list of events by event ID for one room. If no room is specified, it will try to determine
 	// which room to use by querying the first events roomID.
@@ -85,6 +85,15 @@ type InputRoomEvent struct {
 	// The transaction ID of the send request if sent by a local user and one
 	// was specified
 	TransactionID *TransactionID `json:"transaction_id"`
+	// If set, the state supplied with HasState is only partial, as returned
+	// by a join which omitted the membership events. The room will be marked
+	// as having partial state and the full state will be fetched from these
+	// servers in the background.
+	PartialStateServers []spec.ServerName `json:"partial_state_servers,omitempty"`
+	// Soft-fail the event even if it passes the auth checks against the
+	// current room state, e.g. because the spam checker flagged it. It will
+	// be stored but not sent to clients or used as a forward extremity.
+	SoftFail bool `json:"soft_fail,omitempty"`
 }
 
 // TransactionID contains the transaction ID sent by a client when sending an
@@ -59,6 +59,8 @@ const (
 	OutputTypeRetirePeek OutputType = "retire_peek"
 	// OutputTypePurgeRoom indicates the event is an OutputPurgeRoom
 	OutputTypePurgeRoom OutputType = "purge_room"
+	// OutputTypeRoomStateResynced indicates the event is an OutputRoomStateResynced
+	OutputTypeRoomStateResynced OutputType = "room_state_resynced"
 )
 
 // An OutputEvent is an entry in the roomserver output kafka log.
@@ -84,6 +86,8 @@ type OutputEvent struct {
 	RetirePeek *OutputRetirePeek `json:"retire_peek,omitempty"`
 	// The content of the event with type OutputPurgeRoom
 	PurgeRoom *OutputPurgeRoom `json:"purge_room,omitempty"`
+	// The content of the event with type OutputTypeRoomStateResynced
+	RoomStateResynced *OutputRoomStateResynced `json:"room_state_resynced,omitempty"`
 }
 
 // Type of the OutputNewRoomEvent.
@@ -269,3 +273,15 @@ type OutputRetirePeek struct {
 type OutputPurgeRoom struct {
 	RoomID string
 }
+
+// An OutputRoomStateResynced is written when the full state of a room which
+// was joined with partial state has been fetched. The current state of the
+// room changes without any new event being added to the timeline, so the
+// state delta is given on its own. No other servers need to be told about it.
+type OutputRoomStateResynced struct {
+	RoomID string `json:"room_id"`
+	// The state event IDs that were added to the current state of the room.
+	AddsStateEventIDs []string `json:"adds_state_event_ids,omitempty"`
+	// The state event IDs that were removed from the current state of the room.
+	RemovesStateEventIDs []string `json:"removes_state_event_ids,omitempty"`
+}
@@ -171,3 +171,23 @@ type PerformForgetRequest struct {
 }
 
 type PerformForgetResponse struct{}
+
+// StateGCProgress describes the progress of the state garbage collector.
+type StateGCProgress struct {
+	// Whether the collector runs periodically by itself.
+	Enabled bool `json:"enabled"`
+	// Whether a pass over the rooms is in progress.
+	Running bool `json:"running"`
+	// How many passes have been completed since startup.
+	Passes int64 `json:"passes"`
+	// When the current or last pass started and finished.
+	LastStarted  spec.Timestamp `json:"last_started_ts,omitempty"`
+	LastFinished spec.Timestamp `json:"last_finished_ts,omitempty"`
+	// The error which stopped the last pass, if any.
+	LastError string `json:"last_error,omitempty"`
+	// Totals for the current or last pass.
+	RoomsProcessed   int64 `json:"rooms_processed"`
+	SnapshotsMerged  int64 `json:"snapshots_merged"`
+	SnapshotsDeleted int64 `json:"snapshots_deleted"`
+	BlocksDeleted    int64 `json:"blocks_deleted"`
+}
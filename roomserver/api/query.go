@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/util"
 
@@ -264,11 +265,17 @@ type QueryPublishedRoomsRequest struct {
 	RoomID             string
 	NetworkID          string
 	IncludeAllNetworks bool
+	// Optional. If specified, only returns rooms with one of these room
+	// types. An empty string matches rooms without a type.
+	RoomTypes []string
 }
 
 type QueryPublishedRoomsResponse struct {
 	// The list of published rooms.
 	RoomIDs []string
+	// The room type of each published room, keyed by room ID. Rooms
+	// without a type are omitted.
+	RoomTypes map[string]string
 }
 
 type QueryAuthChainRequest struct {
@@ -368,6 +375,52 @@ type QueryAdminEventReportResponse struct {
 	EventJSON json.RawMessage `json:"event_json"`
 }
 
+// AdminStateEntry is a single entry of room state, as shown by the admin API.
+type AdminStateEntry struct {
+	Type     string `json:"type"`
+	StateKey string `json:"state_key"`
+	EventID  string `json:"event_id"`
+}
+
+// AdminForwardExtremity describes one of the forward extremities of a room.
+type AdminForwardExtremity struct {
+	EventID        string         `json:"event_id"`
+	Type           string         `json:"type"`
+	Sender         string         `json:"sender"`
+	Depth          int64          `json:"depth"`
+	OriginServerTS spec.Timestamp `json:"origin_server_ts"`
+	AgeMS          int64          `json:"age_ms"`
+	// How many state entries differ between the state after this event and
+	// the current state of the room.
+	StateDifferences int `json:"state_differences"`
+}
+
+// AdminStateAfterEvent is the state after an event, along with the entries
+// which were added or removed relative to the current state of the room.
+type AdminStateAfterEvent struct {
+	EventID string            `json:"event_id"`
+	State   []AdminStateEntry `json:"state"`
+	Added   []AdminStateEntry `json:"added"`
+	Removed []AdminStateEntry `json:"removed"`
+}
+
+// AdminStateConflict is a state key which the events being resolved
+// disagreed on, along with the event which state resolution picked.
+type AdminStateConflict struct {
+	Type       string   `json:"type"`
+	StateKey   string   `json:"state_key"`
+	Candidates []string `json:"candidates"`
+	Winner     string   `json:"winner,omitempty"`
+}
+
+// AdminResolvedState is the result of resolving the state after a set of
+// events.
+type AdminResolvedState struct {
+	EventIDs  []string             `json:"event_ids"`
+	State     []AdminStateEntry    `json:"state"`
+	Conflicts []AdminStateConflict `json:"conflicts"`
+}
+
 // MarshalJSON stringifies the room ID and StateKeyTuple keys so they can be sent over the wire in HTTP API mode.
 func (r *QueryBulkStateContentResponse) MarshalJSON() ([]byte, error) {
 	se := make(map[string]string)
@@ -502,6 +555,18 @@ func (mq *MembershipQuerier) CurrentMembership(ctx context.Context, roomID spec.
 	return membership, err
 }
 
+// RoomSummary is the summary of a room which can be shown before joining it,
+// as described by MSC3266.
+type RoomSummary struct {
+	fclient.PublicRoom
+	// The room version and encryption algorithm are only known for rooms
+	// that this server is joined to.
+	RoomVersion    gomatrixserverlib.RoomVersion `json:"room_version,omitempty"`
+	Encryption     string                        `json:"encryption,omitempty"`
+	Membership     string                        `json:"membership,omitempty"`
+	AllowedRoomIDs []string                      `json:"allowed_room_ids,omitempty"`
+}
+
 type QueryRoomHierarchyRequest struct {
 	SuggestedOnly bool `json:"suggested_only"`
 	Limit         int  `json:"limit"`
@@ -16,6 +16,7 @@ package api
 
 import (
 	"context"
+	"time"
 
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
@@ -55,6 +56,29 @@ func SendEventWithState(
 	state gomatrixserverlib.StateResponse, event *types.HeaderedEvent,
 	origin spec.ServerName, haveEventIDs map[string]bool, async bool,
 ) error {
+	ires := inputEventsWithState(ctx, kind, state, event, origin, haveEventIDs)
+	return SendInputRoomEvents(ctx, rsAPI, virtualHost, ires, async)
+}
+
+// SendEventWithPartialState is like SendEventWithState, but the state is
+// only partial, as returned by a join which omitted the membership events.
+// The roomserver will fetch the full state from the given servers later.
+func SendEventWithPartialState(
+	ctx context.Context, rsAPI InputRoomEventsAPI,
+	virtualHost spec.ServerName,
+	state gomatrixserverlib.StateResponse, event *types.HeaderedEvent,
+	origin spec.ServerName, serversInRoom []spec.ServerName, async bool,
+) error {
+	ires := inputEventsWithState(ctx, KindNew, state, event, origin, nil)
+	ires[len(ires)-1].PartialStateServers = serversInRoom
+	return SendInputRoomEvents(ctx, rsAPI, virtualHost, ires, async)
+}
+
+func inputEventsWithState(
+	ctx context.Context, kind Kind,
+	state gomatrixserverlib.StateResponse, event *types.HeaderedEvent,
+	origin spec.ServerName, haveEventIDs map[string]bool,
+) []InputRoomEvent {
 	outliers := gomatrixserverlib.LineariseStateResponse(event.Version(), state)
 	ires := make([]InputRoomEvent, 0, len(outliers))
 	for _, outlier := range outliers {
@@ -81,15 +105,13 @@ func SendEventWithState(
 		"state_ids": len(stateEventIDs),
 	}).Infof("Submitting %q event to roomserver with state snapshot", event.Type())
 
-	ires = append(ires, InputRoomEvent{
+	return append(ires, InputRoomEvent{
 		Kind:          kind,
 		Event:         event,
 		Origin:        origin,
 		HasState:      true,
 		StateEventIDs: stateEventIDs,
 	})
-
-	return SendInputRoomEvents(ctx, rsAPI, virtualHost, ires, async)
 }
 
 // SendInputRoomEvents to the roomserver.
@@ -143,6 +165,39 @@ func GetStateEvent(ctx context.Context, rsAPI QueryEventsAPI, roomID string, tup
 	return nil
 }
 
+// PartialStateWaitTimeout is how long client requests wait for the full state
+// of a room that was joined with partial state before giving up, after which
+// clients are told to retry after PartialStateRetryAfter.
+const (
+	PartialStateWaitTimeout = time.Second * 30
+	PartialStateRetryAfter  = time.Second * 5
+)
+
+// WaitForFullState waits for up to the given timeout for a room that was
+// joined with partial state to have its full state fetched. Returns true
+// if the room has full state.
+func WaitForFullState(ctx context.Context, rsAPI QueryPartialStateAPI, roomID spec.RoomID, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		partialState, err := rsAPI.IsRoomPartialState(ctx, roomID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, nil
+			}
+			return false, err
+		}
+		if !partialState {
+			return true, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+}
+
 // IsServerBannedFromRoom returns whether the server is banned from a room by server ACLs.
 func IsServerBannedFromRoom(ctx context.Context, rsAPI FederationRoomserverAPI, roomID string, serverName spec.ServerName) bool {
 	req := &QueryServerBannedFromRoomRequest{
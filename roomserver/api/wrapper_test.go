@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+)
+
+// partialStateAPI is slow to answer while the room has partial state, so
+// that the wait deadline expires during the query.
+type partialStateAPI struct {
+	partial bool
+}
+
+func (p *partialStateAPI) IsRoomPartialState(ctx context.Context, roomID spec.RoomID) (bool, error) {
+	if p.partial {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return false, nil
+}
+
+func TestWaitForFullState(t *testing.T) {
+	roomID, err := spec.NewRoomID("!room:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsAPI := &partialStateAPI{partial: true}
+	fullState, err := WaitForFullState(context.Background(), rsAPI, *roomID, time.Millisecond*50)
+	if err != nil {
+		t.Fatalf("expected no error when the timeout is reached, got %s", err)
+	}
+	if fullState {
+		t.Fatalf("expected the room to still have partial state")
+	}
+
+	rsAPI.partial = false
+	fullState, err = WaitForFullState(context.Background(), rsAPI, *roomID, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fullState {
+		t.Fatalf("expected the room to have full state")
+	}
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"crypto/ed25519"
+	"fmt"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/jchv/maidtrix/internal/matrixserver"
@@ -20,6 +21,8 @@ import (
 	"github.com/jchv/maidtrix/roomserver/internal/input"
 	"github.com/jchv/maidtrix/roomserver/internal/perform"
 	"github.com/jchv/maidtrix/roomserver/internal/query"
+	"github.com/jchv/maidtrix/roomserver/internal/stategc"
+	"github.com/jchv/maidtrix/roomserver/policylists"
 	"github.com/jchv/maidtrix/roomserver/producers"
 	"github.com/jchv/maidtrix/roomserver/storage"
 	"github.com/jchv/maidtrix/roomserver/types"
@@ -52,6 +55,8 @@ type RoomserverInternalAPI struct {
 	ServerName             spec.ServerName
 	KeyRing                gomatrixserverlib.JSONVerifier
 	ServerACLs             *acls.ServerACLs
+	PolicyLists            *policylists.PolicyLists
+	StateGC                *stategc.Collector
 	fsAPI                  fsAPI.RoomserverFederationAPI
 	asAPI                  asAPI.AppServiceInternalAPI
 	NATSClient             *nats.Conn
@@ -74,6 +79,7 @@ func NewRoomserverAPI(
 	}
 
 	serverACLs := acls.NewServerACLs(roomserverDB)
+	policyLists := policylists.NewPolicyLists(roomserverDB, dendriteCfg.RoomServer.PolicyLists.Rooms)
 	producer := &producers.RoomEventProducer{
 		Topic:     string(dendriteCfg.Global.JetStream.Prefixed(jetstream.OutputRoomEvent)),
 		JetStream: js,
@@ -92,6 +98,8 @@ func NewRoomserverAPI(
 		NATSClient:             nc,
 		Durable:                dendriteCfg.Global.JetStream.Durable("RoomserverInputConsumer"),
 		ServerACLs:             serverACLs,
+		PolicyLists:            policyLists,
+		StateGC:                stategc.NewCollector(processContext, &dendriteCfg.RoomServer.StateGC, roomserverDB),
 		enableMetrics:          enableMetrics,
 		defaultRoomVersion:     dendriteCfg.RoomServer.DefaultRoomVersion,
 		// perform-er structs + queryer struct get initialised when we have a federation sender to use
@@ -111,6 +119,7 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 		Cache:             r.Cache,
 		IsLocalServerName: r.Cfg.Global.IsLocalServerName,
 		ServerACLs:        r.ServerACLs,
+		PolicyLists:       r.PolicyLists,
 		Cfg:               r.Cfg,
 		FSAPI:             fsAPI,
 	}
@@ -130,6 +139,8 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 		RSAPI:               r,
 		KeyRing:             keyRing,
 		ACLs:                r.ServerACLs,
+		PolicyLists:         r.PolicyLists,
+		StateGC:             r.StateGC,
 		Queryer:             r.Queryer,
 		EnableMetrics:       r.enableMetrics,
 	}
@@ -181,6 +192,7 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 		FSAPI:             r.fsAPI,
 		Querier:           r.Queryer,
 		KeyRing:           r.KeyRing,
+		StateGC:           r.StateGC,
 		// Perspective servers are trusted to not lie about server keys, so we will also
 		// prefer these servers when backfilling (assuming they are in the room) rather
 		// than trying random servers
@@ -199,6 +211,8 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 		Inputer: r.Inputer,
 		Queryer: r.Queryer,
 		Leaver:  r.Leaver,
+		Inviter: r.Inviter,
+		Joiner:  r.Joiner,
 	}
 	r.Creator = &perform.Creator{
 		DB:    r.DB,
@@ -209,6 +223,7 @@ func (r *RoomserverInternalAPI) SetFederationAPI(fsAPI fsAPI.RoomserverFederatio
 	if err := r.Inputer.Start(); err != nil {
 		logrus.WithError(err).Panic("failed to start roomserver input API")
 	}
+	r.StateGC.Start()
 }
 
 func (r *RoomserverInternalAPI) SetUserAPI(userAPI userapi.RoomserverUserAPI) {
@@ -235,16 +250,21 @@ func (r *RoomserverInternalAPI) StateQuerier() gomatrixserverlib.StateQuerier {
 func (r *RoomserverInternalAPI) HandleInvite(
 	ctx context.Context, inviteEvent *types.HeaderedEvent,
 ) error {
+	// Refuse invites from users that are banned by the policy lists.
+	inviter, err := r.QueryUserIDForSender(ctx, inviteEvent.RoomID(), inviteEvent.SenderID())
+	if err == nil && inviter != nil && r.IsBannedByPolicyList(ctx, inviteEvent.RoomID().String(), *inviter, spec.Invite) {
+		return api.ErrNotAllowed{Err: fmt.Errorf("the invite is not allowed by this server's policy lists")}
+	}
 	outputEvents, err := r.Inviter.ProcessInviteMembership(ctx, inviteEvent)
 	if err != nil {
 		return err
 	}
-	return r.OutputProducer.ProduceRoomEvents(inviteEvent.RoomID().String(), outputEvents)
+	return r.OutputProducer.ProduceRoomEvents(ctx, inviteEvent.RoomID().String(), outputEvents)
 }
 
 func (r *RoomserverInternalAPI) PerformCreateRoom(
 	ctx context.Context, userID spec.UserID, roomID spec.RoomID, createRequest *api.PerformCreateRoomRequest,
-) (string, *util.JSONResponse) {
+) (string, *spec.RoomID, *util.JSONResponse) {
 	return r.Creator.PerformCreateRoom(ctx, userID, roomID, createRequest)
 }
 
@@ -268,7 +288,19 @@ func (r *RoomserverInternalAPI) PerformLeave(
 	if len(outputEvents) == 0 {
 		return nil
 	}
-	return r.OutputProducer.ProduceRoomEvents(req.RoomID, outputEvents)
+	return r.OutputProducer.ProduceRoomEvents(ctx, req.RoomID, outputEvents)
+}
+
+// QueryAdminStateGC returns the progress of the state garbage collector.
+func (r *RoomserverInternalAPI) QueryAdminStateGC(ctx context.Context) api.StateGCProgress {
+	return r.StateGC.Progress()
+}
+
+// PerformAdminStateGC starts a pass of the state garbage collector, unless
+// one is already in progress.
+func (r *RoomserverInternalAPI) PerformAdminStateGC(ctx context.Context) api.StateGCProgress {
+	r.StateGC.Trigger()
+	return r.StateGC.Progress()
 }
 
 func (r *RoomserverInternalAPI) PerformForget(
@@ -33,11 +33,15 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	oteltrace "go.opentelemetry.io/otel/trace"
 
 	fedapi "github.com/jchv/maidtrix/federationapi/api"
+	"github.com/jchv/maidtrix/internal"
 	"github.com/jchv/maidtrix/roomserver/acls"
 	"github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/roomserver/internal/query"
+	"github.com/jchv/maidtrix/roomserver/internal/stategc"
+	"github.com/jchv/maidtrix/roomserver/policylists"
 	"github.com/jchv/maidtrix/roomserver/producers"
 	"github.com/jchv/maidtrix/roomserver/storage"
 	"github.com/jchv/maidtrix/roomserver/types"
@@ -86,9 +90,14 @@ type Inputer struct {
 	RSAPI               api.RoomserverInternalAPI
 	KeyRing             gomatrixserverlib.JSONVerifier
 	ACLs                *acls.ServerACLs
+	PolicyLists         *policylists.PolicyLists
+	StateGC             *stategc.Collector
 	InputRoomEventTopic string
 	OutputProducer      *producers.RoomEventProducer
 	workers             sync.Map // room ID -> *worker
+	partialStateLocks   sync.Map // room ID -> *sync.Mutex
+	partialStateResyncs sync.Map // room ID -> struct{}, for resyncs in progress
+	extremityPruner     extremityPruner
 
 	Queryer       *query.Queryer
 	UserAPI       userapi.RoomserverUserAPI
@@ -216,6 +225,7 @@ func (r *Inputer) startWorkerForRoom(roomID string) {
 
 		// Go and start pulling messages off the queue.
 		w.subscription = sub
+		roomserverInputWorkers.Inc()
 		w.Act(nil, w._next)
 	}
 }
@@ -228,7 +238,14 @@ func (r *Inputer) startWorkerForRoom(roomID string) {
 // own consumer. If we don't, we'll start one.
 func (r *Inputer) Start() error {
 	if r.EnableMetrics {
-		prometheus.MustRegister(roomserverInputBackpressure, processRoomEventDuration)
+		prometheus.MustRegister(
+			roomserverInputBackpressure, roomserverInputWorkers,
+			roomserverInputQueueWait, processRoomEventDuration,
+			roomserverForwardExtremities, roomserverDummyEvents,
+		)
+	}
+	if r.Cfg.ExtremityPruning.Enabled {
+		r.startExtremityPruner()
 	}
 	_, err := r.JetStream.Subscribe(
 		"", // This is blank because we specified it in BindStream.
@@ -257,8 +274,13 @@ func (r *Inputer) Start() error {
 			}
 		}
 	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	// Pick up fetching the full state for any rooms that were still
+	// partial state when we shut down.
+	return r.resumePartialStateResyncs()
 }
 
 // _next is called by the worker for the room. It must only be called
@@ -294,6 +316,7 @@ func (w *worker) _next() {
 		w.Lock()
 		w.subscription = nil
 		w.Unlock()
+		roomserverInputWorkers.Dec()
 		return
 
 	default:
@@ -308,6 +331,7 @@ func (w *worker) _next() {
 		w.Lock()
 		w.subscription = nil
 		w.Unlock()
+		roomserverInputWorkers.Dec()
 		return
 	}
 
@@ -318,6 +342,9 @@ func (w *worker) _next() {
 	// fails then we'll terminate the message — this notifies NATS that
 	// we are done with the message and never want to see it again.
 	msg := msgs[0]
+	if meta, merr := msg.Metadata(); merr == nil {
+		roomserverInputQueueWait.Observe(float64(time.Since(meta.Timestamp).Milliseconds()))
+	}
 	var inputRoomEvent api.InputRoomEvent
 	if err = json.Unmarshal(msg.Data, &inputRoomEvent); err != nil {
 		// using AckWait here makes the call synchronous; 5 seconds is the default value used by NATS
@@ -334,11 +361,23 @@ func (w *worker) _next() {
 	// a string, because we might want to return that to the caller if
 	// it was a synchronous request.
 	var errString string
-	if err = w.r.processRoomEvent(
-		w.r.ProcessContext.Context(),
+	trace, processCtx := internal.StartTask(
+		jetstream.ExtractTraceContext(w.r.ProcessContext.Context(), msg),
+		"ProcessInputRoomEvent",
+		oteltrace.WithSpanKind(oteltrace.SpanKindConsumer),
+	)
+	trace.SetTag("room_id", w.roomID)
+	trace.SetTag("event_id", inputRoomEvent.Event.EventID())
+	defer trace.EndTask()
+	releaseStateGC := w.r.StateGC.Hold(w.roomID)
+	err = w.r.processRoomEvent(
+		processCtx,
 		spec.ServerName(msg.Header.Get("virtual_host")),
 		&inputRoomEvent,
-	); err != nil {
+	)
+	releaseStateGC()
+	if err != nil {
+		trace.SetError(err)
 		switch err.(type) {
 		case types.RejectedError:
 			// Don't send events that were rejected to Sentry
@@ -424,6 +463,7 @@ func (r *Inputer) queueInputRoomEvents(
 			msg.Header.Set("sync", replyTo)
 		}
 		msg.Header.Set("virtual_host", string(request.VirtualHost))
+		jetstream.InjectTraceContext(ctx, msg)
 		msg.Data, err = json.Marshal(e)
 		if err != nil {
 			return nil, fmt.Errorf("json.Marshal: %w", err)
@@ -488,3 +528,25 @@ var roomserverInputBackpressure = prometheus.NewGaugeVec(
 	},
 	[]string{"room_id"},
 )
+
+var roomserverInputWorkers = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "roomserver",
+		Name:      "input_workers",
+		Help:      "How many rooms currently have an active input worker subscription",
+	},
+)
+
+var roomserverInputQueueWait = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Namespace: "dendrite",
+		Subsystem: "roomserver",
+		Name:      "input_queue_wait_millis",
+		Help:      "How long events wait in the input queue before the room worker picks them up",
+		Buckets: []float64{ // milliseconds
+			1, 5, 10, 25, 50, 100, 250, 500,
+			1000, 2500, 5000, 10000, 30000, 60000,
+		},
+	},
+)
@@ -136,6 +136,24 @@ func (r *Inputer) processRoomEvent(
 	if err != nil {
 		return fmt.Errorf("failed getting userID for sender %q. %w", event.SenderID(), err)
 	}
+
+	// If the room was joined with partial state then we don't know most of the
+	// memberships in the room yet, so new events can only be checked against
+	// their auth events until the full state has been fetched. Hold the room's
+	// partial state lock so that the resync can't swap the state out from under
+	// us while the event is being processed.
+	partialState := false
+	if roomInfo != nil && input.Kind == api.KindNew {
+		var joinEventID string
+		if joinEventID, _, err = r.DB.PartialStateRoom(ctx, roomInfo.RoomNID); err != nil {
+			return fmt.Errorf("r.DB.PartialStateRoom: %w", err)
+		}
+		if partialState = joinEventID != ""; partialState {
+			mu := r.partialStateLock(event.RoomID().String())
+			mu.Lock()
+			defer mu.Unlock()
+		}
+	}
 	senderDomain := spec.ServerName("")
 	if sender != nil {
 		senderDomain = sender.Domain()
@@ -340,14 +358,18 @@ func (r *Inputer) processRoomEvent(
 	}
 
 	var softfail bool
-	if input.Kind == api.KindNew && !isCreateEvent {
+	if input.Kind == api.KindNew && !isCreateEvent && !partialState {
 		// Check that the event passes authentication checks based on the
-		// current room state.
+		// current room state. With partial state this has to wait for the
+		// resync, see reauthPartialStateEvents.
 		softfail, err = helpers.CheckForSoftFail(ctx, r.DB, roomInfo, headered, input.StateEventIDs, r.Queryer)
 		if err != nil {
 			logger.WithError(err).Warn("Error authing soft-failed event")
 		}
 	}
+	if input.Kind == api.KindNew && input.SoftFail {
+		softfail = true
+	}
 
 	// Get the state before the event so that we can work out if the event was
 	// allowed at the time, and also to get the history visibility. We won't
@@ -355,7 +377,7 @@ func (r *Inputer) processRoomEvent(
 	// burning CPU time.
 	historyVisibility := gomatrixserverlib.HistoryVisibilityShared // Default to shared.
 	if input.Kind != api.KindOutlier && rejectionErr == nil && !isRejected && !isCreateEvent {
-		historyVisibility, rejectionErr, err = r.processStateBefore(ctx, roomInfo, input, missingPrev)
+		historyVisibility, rejectionErr, err = r.processStateBefore(ctx, roomInfo, input, missingPrev, partialState)
 		if err != nil {
 			return fmt.Errorf("r.processStateBefore: %w", err)
 		}
@@ -471,6 +493,11 @@ func (r *Inputer) processRoomEvent(
 
 	switch input.Kind {
 	case api.KindNew:
+		if len(input.PartialStateServers) > 0 {
+			if err = r.DB.SetRoomPartialState(ctx, roomInfo.RoomNID, event.EventID(), input.PartialStateServers); err != nil {
+				return fmt.Errorf("r.DB.SetRoomPartialState: %w", err)
+			}
+		}
 		if err = r.updateLatestEvents(
 			ctx,                 // context
 			roomInfo,            // room info for the room being updated
@@ -483,8 +510,11 @@ func (r *Inputer) processRoomEvent(
 		); err != nil {
 			return fmt.Errorf("r.updateLatestEvents: %w", err)
 		}
+		if len(input.PartialStateServers) > 0 {
+			r.startPartialStateResync(event.RoomID().String())
+		}
 	case api.KindOld:
-		err = r.OutputProducer.ProduceRoomEvents(event.RoomID().String(), []api.OutputEvent{
+		err = r.OutputProducer.ProduceRoomEvents(ctx, event.RoomID().String(), []api.OutputEvent{
 			{
 				Type: api.OutputTypeOldRoomEvent,
 				OldRoomEvent: &api.OutputOldRoomEvent{
@@ -537,7 +567,7 @@ func (r *Inputer) processRoomEvent(
 	// so notify downstream components to redact this event - they should have it if they've
 	// been tracking our output log.
 	if redactedEventID != "" {
-		err = r.OutputProducer.ProduceRoomEvents(event.RoomID().String(), []api.OutputEvent{
+		err = r.OutputProducer.ProduceRoomEvents(ctx, event.RoomID().String(), []api.OutputEvent{
 			{
 				Type: api.OutputTypeRedactedEvent,
 				RedactedEvent: &api.OutputRedactedEvent{
@@ -558,6 +588,11 @@ func (r *Inputer) processRoomEvent(
 		}
 	}
 
+	// Apply any changes to the policy lists, or enforce them against new joins.
+	if input.Kind == api.KindNew {
+		r.handlePolicyLists(ctx, event)
+	}
+
 	// Everything was OK — the latest events updater didn't error and
 	// we've sent output events. Finally, generate a hook call.
 	hooks.Run(hooks.KindNewEventPersisted, headered)
@@ -581,7 +616,7 @@ func (r *Inputer) processStateBefore(
 	ctx context.Context,
 	roomInfo *types.RoomInfo,
 	input *api.InputRoomEvent,
-	missingPrev bool,
+	missingPrev, partialState bool,
 ) (historyVisibility gomatrixserverlib.HistoryVisibility, rejectionErr error, err error) {
 	historyVisibility = gomatrixserverlib.HistoryVisibilityShared // Default to shared.
 	event := input.Event.PDU
@@ -660,8 +695,16 @@ func (r *Inputer) processStateBefore(
 	if rejectionErr = gomatrixserverlib.Allowed(event, stateBeforeAuth, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 		return r.Queryer.QueryUserIDForSender(ctx, roomID, senderID)
 	}); rejectionErr != nil {
-		rejectionErr = fmt.Errorf("Allowed() failed for stateBeforeEvent: %w", rejectionErr)
-		return
+		if !partialState {
+			rejectionErr = fmt.Errorf("Allowed() failed for stateBeforeEvent: %w", rejectionErr)
+			return
+		}
+		// The state before the event is missing most of the memberships if
+		// the room was joined with partial state, so this check can't be
+		// trusted. The event has already passed the auth event checks, and
+		// will be checked again once the resync has fetched the full state.
+		util.GetLogger(ctx).WithError(rejectionErr).Debugf("Ignoring state before event %s in partial state room", event.EventID())
+		rejectionErr = nil
 	}
 	// Work out what the history visibility was at the time of the
 	// event.
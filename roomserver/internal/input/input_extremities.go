@@ -0,0 +1,267 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package input
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/eventutil"
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// dummyEventType is the type of the events which are sent to merge forward
+// extremities. They have no content and clients are expected to ignore them.
+const dummyEventType = "org.matrix.dummy_event"
+
+// extremityPruner keeps track of the rooms which need a dummy event to merge
+// their forward extremities, and when each room was last sent one.
+type extremityPruner struct {
+	mu       sync.Mutex
+	pending  map[string]struct{}  // room ID -> struct{}
+	lastSent map[string]time.Time // room ID -> when a dummy event was last queued
+	wake     chan struct{}
+}
+
+// checkForwardExtremities queues a dummy event for the room if it has more
+// forward extremities than allowed, unless the room was sent one recently.
+// It must only be called once the new forward extremities are committed.
+func (r *Inputer) checkForwardExtremities(roomID string, extremities int) {
+	roomserverForwardExtremities.Observe(float64(extremities))
+	cfg := &r.Cfg.ExtremityPruning
+	if !cfg.Enabled || extremities <= cfg.Threshold {
+		return
+	}
+	p := &r.extremityPruner
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending == nil {
+		// The pruner isn't running.
+		return
+	}
+	if _, ok := p.pending[roomID]; ok {
+		return
+	}
+	if lastSent, ok := p.lastSent[roomID]; ok && time.Since(lastSent) < cfg.MinInterval {
+		roomserverDummyEvents.With(prometheus.Labels{"outcome": "rate_limited"}).Inc()
+		return
+	}
+	p.pending[roomID] = struct{}{}
+	p.lastSent[roomID] = time.Now()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startExtremityPruner sends the dummy events queued by checkForwardExtremities
+// in the background, so that the room workers aren't held up.
+func (r *Inputer) startExtremityPruner() {
+	p := &r.extremityPruner
+	p.mu.Lock()
+	p.pending = map[string]struct{}{}
+	p.lastSent = map[string]time.Time{}
+	p.wake = make(chan struct{}, 1)
+	p.mu.Unlock()
+
+	go func() {
+		ctx := r.ProcessContext.Context()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wake:
+			}
+			for _, roomID := range p.take(r.Cfg.ExtremityPruning.MinInterval) {
+				r.pruneForwardExtremities(ctx, roomID)
+			}
+		}
+	}()
+}
+
+// take returns the queued rooms, and forgets about rooms which are no longer
+// rate limited.
+func (p *extremityPruner) take(minInterval time.Duration) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	roomIDs := make([]string, 0, len(p.pending))
+	for roomID := range p.pending {
+		roomIDs = append(roomIDs, roomID)
+		delete(p.pending, roomID)
+	}
+	for roomID, lastSent := range p.lastSent {
+		if time.Since(lastSent) >= minInterval {
+			delete(p.lastSent, roomID)
+		}
+	}
+	return roomIDs
+}
+
+func (r *Inputer) pruneForwardExtremities(ctx context.Context, roomIDStr string) {
+	logger := logrus.WithField("room_id", roomIDStr)
+	roomID, err := spec.NewRoomID(roomIDStr)
+	if err != nil {
+		return
+	}
+	eventID, err := r.SendDummyEvent(ctx, *roomID)
+	var notAllowed api.ErrNotAllowed
+	switch {
+	case errors.As(err, &notAllowed):
+		roomserverDummyEvents.With(prometheus.Labels{"outcome": "not_allowed"}).Inc()
+		logger.WithError(err).Debug("Unable to merge forward extremities")
+	case err != nil:
+		roomserverDummyEvents.With(prometheus.Labels{"outcome": "failed"}).Inc()
+		logger.WithError(err).Error("Failed to send dummy event to merge forward extremities")
+	case eventID != "":
+		roomserverDummyEvents.With(prometheus.Labels{"outcome": "sent"}).Inc()
+		logger.WithField("event_id", eventID).Debug("Sent dummy event to merge forward extremities")
+	}
+}
+
+// SendDummyEvent has a local member of the room send a dummy event which
+// references the forward extremities of the room, so that they are merged
+// into one. Returns the ID of the dummy event, or an empty string if the room
+// only has one forward extremity.
+func (r *Inputer) SendDummyEvent(ctx context.Context, roomID spec.RoomID) (eventID string, err error) {
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID.String())
+	if err != nil {
+		return "", fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo == nil || roomInfo.IsStub() {
+		return "", eventutil.ErrRoomNoExists{}
+	}
+	latestEventIDs, _, _, err := r.DB.LatestEventIDs(ctx, roomInfo.RoomNID)
+	if err != nil {
+		return "", fmt.Errorf("r.DB.LatestEventIDs: %w", err)
+	}
+	if len(latestEventIDs) <= 1 {
+		return "", nil
+	}
+
+	sender, senderID, err := r.dummyEventSender(ctx, roomID, roomInfo)
+	if err != nil {
+		return "", err
+	}
+	if sender == nil {
+		return "", api.ErrNotAllowed{Err: fmt.Errorf("no local user in room %s can send a dummy event", roomID.String())}
+	}
+
+	proto := &gomatrixserverlib.ProtoEvent{
+		RoomID:   roomID.String(),
+		Type:     dummyEventType,
+		SenderID: string(senderID),
+		Content:  []byte("{}"),
+	}
+	eventsNeeded, err := gomatrixserverlib.StateNeededForProtoEvent(proto)
+	if err != nil {
+		return "", err
+	}
+	latestRes := &api.QueryLatestEventsAndStateResponse{}
+	if err = r.Queryer.QueryLatestEventsAndState(ctx, &api.QueryLatestEventsAndStateRequest{
+		RoomID:       roomID.String(),
+		StateToFetch: eventsNeeded.Tuples(),
+	}, latestRes); err != nil {
+		return "", err
+	}
+	signingIdentity, err := r.SigningIdentity(ctx, roomID, *sender)
+	if err != nil {
+		return "", err
+	}
+	event, err := eventutil.BuildEvent(ctx, proto, &signingIdentity, time.Now(), &eventsNeeded, latestRes)
+	if err != nil {
+		return "", err
+	}
+
+	inputRes := &api.InputRoomEventsResponse{}
+	r.InputRoomEvents(ctx, &api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{{
+			Kind:         api.KindNew,
+			Event:        event,
+			Origin:       sender.Domain(),
+			SendAsServer: string(sender.Domain()),
+		}},
+	}, inputRes)
+	if err = inputRes.Err(); err != nil {
+		return "", err
+	}
+	return event.EventID(), nil
+}
+
+// dummyEventSender returns a local member of the room who is allowed to send
+// a dummy event, or nil if there isn't one.
+func (r *Inputer) dummyEventSender(ctx context.Context, roomID spec.RoomID, roomInfo *types.RoomInfo) (*spec.UserID, spec.SenderID, error) {
+	var powerLevels *gomatrixserverlib.PowerLevelContent
+	plEvent, err := r.DB.GetStateEvent(ctx, roomID.String(), spec.MRoomPowerLevels, "")
+	if err != nil {
+		return nil, "", fmt.Errorf("r.DB.GetStateEvent: %w", err)
+	}
+	if plEvent != nil {
+		if powerLevels, err = plEvent.PowerLevels(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	memberNIDs, err := r.DB.GetMembershipEventNIDsForRoom(ctx, roomInfo.RoomNID, true, true)
+	if err != nil {
+		return nil, "", fmt.Errorf("r.DB.GetMembershipEventNIDsForRoom: %w", err)
+	}
+	memberEvents, err := r.DB.Events(ctx, roomInfo.RoomVersion, memberNIDs)
+	if err != nil {
+		return nil, "", fmt.Errorf("r.DB.Events: %w", err)
+	}
+	for _, memberEvent := range memberEvents {
+		if memberEvent.StateKey() == nil {
+			continue
+		}
+		senderID := spec.SenderID(*memberEvent.StateKey())
+		if powerLevels != nil && powerLevels.UserLevel(senderID) < powerLevels.EventLevel(dummyEventType, false) {
+			continue
+		}
+		userID, err := r.Queryer.QueryUserIDForSender(ctx, roomID, senderID)
+		if err != nil || userID == nil {
+			continue
+		}
+		return userID, senderID, nil
+	}
+	return nil, "", nil
+}
+
+var roomserverForwardExtremities = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Namespace: "dendrite",
+		Subsystem: "roomserver",
+		Name:      "forward_extremities",
+		Help:      "How many forward extremities rooms have after new events are processed",
+		Buckets:   []float64{1, 2, 3, 5, 10, 20, 50, 100, 250},
+	},
+)
+
+var roomserverDummyEvents = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "dendrite",
+		Subsystem: "roomserver",
+		Name:      "dummy_events_total",
+		Help:      "How many times a dummy event was needed to merge forward extremities, by outcome",
+	},
+	[]string{"outcome"},
+)
@@ -68,8 +68,6 @@ func (r *Inputer) updateLatestEvents(
 		return fmt.Errorf("r.DB.GetRoomUpdater: %w", err)
 	}
 
-	defer sqlutil.EndTransactionWithCheck(updater, &succeeded, &err)
-
 	u := latestEventsUpdater{
 		ctx:               ctx,
 		api:               r,
@@ -83,6 +81,15 @@ func (r *Inputer) updateLatestEvents(
 		historyVisibility: historyVisibility,
 	}
 
+	// Deferred before the transaction is ended so that it runs afterwards,
+	// once the new forward extremities have been committed.
+	defer func() {
+		if err == nil && u.latest != nil {
+			r.checkForwardExtremities(event.RoomID().String(), len(u.latest))
+		}
+	}()
+	defer sqlutil.EndTransactionWithCheck(updater, &succeeded, &err)
+
 	if err = u.doUpdateLatestEvents(); err != nil {
 		return fmt.Errorf("u.doUpdateLatestEvents: %w", err)
 	}
@@ -197,7 +204,7 @@ func (u *latestEventsUpdater) doUpdateLatestEvents() error {
 	// send the event asynchronously but we would need to ensure that 1) the events are written to the log in
 	// the correct order, 2) that pending writes are resent across restarts. In order to avoid writing all the
 	// necessary bookkeeping we'll keep the event sending synchronous for now.
-	if err = u.api.OutputProducer.ProduceRoomEvents(u.event.RoomID().String(), updates); err != nil {
+	if err = u.api.OutputProducer.ProduceRoomEvents(u.ctx, u.event.RoomID().String(), updates); err != nil {
 		return fmt.Errorf("u.api.WriteOutputEvents: %w", err)
 	}
 
@@ -0,0 +1,541 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package input
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jchv/maidtrix/internal"
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/state"
+	"github.com/jchv/maidtrix/roomserver/storage/shared"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	partialStateResyncMinBackoff = time.Second * 10
+	partialStateResyncMaxBackoff = time.Minute * 10
+)
+
+// partialStateLock returns the lock that serialises new events in a partial
+// state room with the resync that replaces the partial state.
+func (r *Inputer) partialStateLock(roomID string) *sync.Mutex {
+	mu, _ := r.partialStateLocks.LoadOrStore(roomID, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// resumePartialStateResyncs restarts the resync for any rooms that were
+// still partial state when the server was last shut down.
+func (r *Inputer) resumePartialStateResyncs() error {
+	roomIDs, err := r.DB.PartialStateRoomIDs(r.ProcessContext.Context())
+	if err != nil {
+		return fmt.Errorf("r.DB.PartialStateRoomIDs: %w", err)
+	}
+	for _, roomID := range roomIDs {
+		r.startPartialStateResync(roomID)
+	}
+	return nil
+}
+
+// startPartialStateResync starts fetching the full state of a room that
+// was joined with partial state in the background, unless a resync is
+// already running for the room. The resync is retried with a backoff
+// until it succeeds or the server shuts down.
+func (r *Inputer) startPartialStateResync(roomID string) {
+	if _, running := r.partialStateResyncs.LoadOrStore(roomID, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer r.partialStateResyncs.Delete(roomID)
+		logger := logrus.WithField("room_id", roomID)
+		backoff := partialStateResyncMinBackoff
+		for {
+			err := r.resyncPartialState(r.ProcessContext.Context(), roomID)
+			if err == nil {
+				logger.Info("Finished fetching full state for partial state room")
+				r.partialStateLocks.Delete(roomID)
+				return
+			}
+			logger.WithError(err).Warnf("Failed to fetch full state for partial state room, retrying in %s", backoff)
+			select {
+			case <-r.ProcessContext.Context().Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > partialStateResyncMaxBackoff {
+				backoff = partialStateResyncMaxBackoff
+			}
+		}
+	}()
+}
+
+// resyncPartialState fetches the full state of the room at our join event
+// from the servers that were in the room when we joined, and then fills in
+// the state before the forward extremities and the current room state with
+// it. The events that were accepted in the meantime are checked against the
+// full state and rejected if they aren't allowed. Only the forward
+// extremities are updated, so the events between the join and the
+// extremities will keep their partial state.
+// nolint:gocyclo
+func (r *Inputer) resyncPartialState(ctx context.Context, roomID string) (err error) {
+	trace, ctx := internal.StartRegion(ctx, "resyncPartialState")
+	defer trace.EndRegion()
+
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo == nil {
+		return fmt.Errorf("room %s does not exist", roomID)
+	}
+	joinEventID, servers, err := r.DB.PartialStateRoom(ctx, roomInfo.RoomNID)
+	if err != nil {
+		return fmt.Errorf("r.DB.PartialStateRoom: %w", err)
+	}
+	if joinEventID == "" {
+		return nil
+	}
+
+	joinEvents, err := r.DB.EventsFromIDs(ctx, roomInfo, []string{joinEventID})
+	if err != nil {
+		return fmt.Errorf("r.DB.EventsFromIDs: %w", err)
+	}
+	if len(joinEvents) != 1 || joinEvents[0].StateKey() == nil {
+		return fmt.Errorf("join event %s not found", joinEventID)
+	}
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return err
+	}
+	joiner, err := r.Queryer.QueryUserIDForSender(ctx, *validRoomID, spec.SenderID(*joinEvents[0].StateKey()))
+	if err != nil || joiner == nil {
+		return fmt.Errorf("failed to find the user who joined the room: %w", err)
+	}
+
+	// Ask the remote servers for the full state before our join event and
+	// store all of the events that we didn't already have as outliers.
+	req := missingStateReq{
+		log:         logrus.WithField("room_id", roomID),
+		virtualHost: joiner.Domain(),
+		inputer:     r,
+		db:          r.DB,
+		roomInfo:    roomInfo,
+		federation:  r.FSAPI,
+		keys:        r.KeyRing,
+		roomsMu:     internal.NewMutexByRoom(),
+		servers:     servers,
+		hadEvents:   map[string]bool{},
+		haveEvents:  map[string]gomatrixserverlib.PDU{},
+	}
+	resolvedState, err := req.lookupMissingStateViaStateIDs(ctx, roomID, joinEventID, roomInfo.RoomVersion)
+	if err != nil {
+		return fmt.Errorf("req.lookupMissingStateViaStateIDs: %w", err)
+	}
+	for _, outlier := range resolvedState.Events() {
+		if req.hadEvents[outlier.EventID()] {
+			continue
+		}
+		err = r.processRoomEvent(ctx, joiner.Domain(), &api.InputRoomEvent{
+			Kind:  api.KindOutlier,
+			Event: &types.HeaderedEvent{PDU: outlier},
+		})
+		if err != nil {
+			if _, ok := err.(types.RejectedError); !ok {
+				return fmt.Errorf("r.processRoomEvent (outlier): %w", err)
+			}
+		}
+	}
+
+	stateEventIDs := make([]string, 0, len(resolvedState.StateEvents)+1)
+	for _, event := range resolvedState.StateEvents {
+		stateEventIDs = append(stateEventIDs, event.EventID())
+	}
+	stateEventIDs = append(stateEventIDs, joinEventID)
+	stateAtJoin, err := r.DB.StateEntriesForEventIDs(ctx, stateEventIDs, true)
+	if err != nil {
+		return fmt.Errorf("r.DB.StateEntriesForEventIDs: %w", err)
+	}
+
+	// From here on we are rewriting the state of the room, so make sure that
+	// no new events are being processed at the same time.
+	mu := r.partialStateLock(roomID)
+	mu.Lock()
+	defer mu.Unlock()
+	defer r.StateGC.Hold(roomID)()
+
+	// The new state is only sent downstream once it has been committed.
+	updates, err := r.replacePartialState(ctx, roomInfo, joinEvents[0], resolvedState.StateEvents, stateAtJoin)
+	if err != nil {
+		return err
+	}
+	if err = r.OutputProducer.ProduceRoomEvents(ctx, roomID, updates); err != nil {
+		return fmt.Errorf("r.OutputProducer.ProduceRoomEvents: %w", err)
+	}
+	return nil
+}
+
+// replacePartialState fills in the state before the forward extremities and
+// the current room state with the full state at our join event, and marks
+// the room as having full state. Returns the output events to send once the
+// changes have been committed.
+// nolint:gocyclo
+func (r *Inputer) replacePartialState(
+	ctx context.Context, roomInfo *types.RoomInfo, joinEvent types.Event,
+	stateEventsAtJoin []gomatrixserverlib.PDU, stateAtJoin []types.StateEntry,
+) (updates []api.OutputEvent, err error) {
+	roomID := joinEvent.RoomID().String()
+
+	var succeeded bool
+	updater, err := r.DB.GetRoomUpdater(ctx, roomInfo)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.GetRoomUpdater: %w", err)
+	}
+	defer sqlutil.EndTransactionWithCheck(updater, &succeeded, &err)
+	roomState := state.NewStateResolution(updater, roomInfo, r.Queryer)
+
+	// The events that arrived while the room had partial state were only
+	// checked against their own auth events, so check them again now that
+	// we know the full state and reject the ones that aren't allowed.
+	window, err := partialStateWindow(ctx, updater, roomInfo, joinEvent, updater.LatestEvents())
+	if err != nil {
+		return nil, fmt.Errorf("partialStateWindow: %w", err)
+	}
+	stateAtJoinEvents := make([]gomatrixserverlib.PDU, 0, len(stateEventsAtJoin)+1)
+	stateAtJoinEvents = append(stateAtJoinEvents, stateEventsAtJoin...)
+	stateAtJoinEvents = append(stateAtJoinEvents, joinEvent.PDU)
+	rejected, err := r.reauthPartialStateEvents(ctx, updater, stateAtJoinEvents, window)
+	if err != nil {
+		return nil, fmt.Errorf("r.reauthPartialStateEvents: %w", err)
+	}
+	latest, err := replaceRejectedExtremities(ctx, updater, joinEvent, window, rejected)
+	if err != nil {
+		return nil, fmt.Errorf("replaceRejectedExtremities: %w", err)
+	}
+
+	// The state that we already know about before each forward extremity
+	// takes precedence over the state at the join, since it may contain
+	// state changes that happened after we joined, unless those were
+	// rejected above.
+	latestStateAtEvents := make([]types.StateAtEvent, len(latest))
+	for i := range latest {
+		var known []types.StateEntry
+		if known, err = roomState.LoadStateAtSnapshot(ctx, latest[i].BeforeStateSnapshotNID); err != nil {
+			return nil, fmt.Errorf("roomState.LoadStateAtSnapshot: %w", err)
+		}
+		merged := make(map[types.StateKeyTuple]types.StateEntry, len(stateAtJoin)+len(known))
+		for _, entry := range stateAtJoin {
+			merged[entry.StateKeyTuple] = entry
+		}
+		for _, entry := range known {
+			if replacement, ok := rejected[entry.EventNID]; ok {
+				if replacement == 0 {
+					continue
+				}
+				entry.EventNID = replacement
+			}
+			merged[entry.StateKeyTuple] = entry
+		}
+		entries := make([]types.StateEntry, 0, len(merged))
+		for _, entry := range merged {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(a, b int) bool {
+			return entries[a].LessThan(entries[b])
+		})
+		if latest[i].BeforeStateSnapshotNID, err = updater.AddState(ctx, roomInfo.RoomNID, nil, entries); err != nil {
+			return nil, fmt.Errorf("updater.AddState: %w", err)
+		}
+		if err = updater.SetState(ctx, latest[i].EventNID, latest[i].BeforeStateSnapshotNID); err != nil {
+			return nil, fmt.Errorf("updater.SetState: %w", err)
+		}
+		latestStateAtEvents[i] = latest[i].StateAtEvent
+	}
+
+	oldStateNID := updater.CurrentStateSnapshotNID()
+	newStateNID, err := roomState.CalculateAndStoreStateAfterEvents(ctx, latestStateAtEvents)
+	if err != nil {
+		return nil, fmt.Errorf("roomState.CalculateAndStoreStateAfterEvents: %w", err)
+	}
+	removed, added, err := roomState.DifferenceBetweeenStateSnapshots(ctx, oldStateNID, newStateNID)
+	if err != nil {
+		return nil, fmt.Errorf("roomState.DifferenceBetweeenStateSnapshots: %w", err)
+	}
+
+	updates, err = r.updateMemberships(ctx, updater, removed, added)
+	if err != nil {
+		return nil, fmt.Errorf("r.updateMemberships: %w", err)
+	}
+
+	lastEventSent, err := updater.StateAtEventIDs(ctx, []string{updater.LastEventIDSent()})
+	if err != nil || len(lastEventSent) != 1 {
+		return nil, fmt.Errorf("failed to find the last event sent: %w", err)
+	}
+	if err = updater.SetLatestEvents(roomInfo.RoomNID, latest, lastEventSent[0].EventNID, newStateNID); err != nil {
+		return nil, fmt.Errorf("updater.SetLatestEvents: %w", err)
+	}
+	if err = updater.ClearPartialState(); err != nil {
+		return nil, fmt.Errorf("updater.ClearPartialState: %w", err)
+	}
+
+	eventNIDs := make([]types.EventNID, 0, len(removed)+len(added))
+	for _, entry := range removed {
+		eventNIDs = append(eventNIDs, entry.EventNID)
+	}
+	for _, entry := range added {
+		eventNIDs = append(eventNIDs, entry.EventNID)
+	}
+	eventIDs, err := updater.EventIDs(ctx, eventNIDs)
+	if err != nil {
+		return nil, fmt.Errorf("updater.EventIDs: %w", err)
+	}
+	resynced := &api.OutputRoomStateResynced{
+		RoomID: roomID,
+	}
+	for _, entry := range removed {
+		resynced.RemovesStateEventIDs = append(resynced.RemovesStateEventIDs, eventIDs[entry.EventNID])
+	}
+	for _, entry := range added {
+		resynced.AddsStateEventIDs = append(resynced.AddsStateEventIDs, eventIDs[entry.EventNID])
+	}
+	updates = append(updates, api.OutputEvent{
+		Type:              api.OutputTypeRoomStateResynced,
+		RoomStateResynced: resynced,
+	})
+	succeeded = true
+	return updates, nil
+}
+
+// partialStateWindow returns the events that were accepted after our join
+// event while the room had partial state, found by walking back through the
+// prev events from the forward extremities. Outliers and events that were
+// already rejected are left out. The events are sorted oldest first.
+func partialStateWindow(
+	ctx context.Context, updater *shared.RoomUpdater, roomInfo *types.RoomInfo,
+	joinEvent types.Event, latest []types.StateAtEventAndReference,
+) ([]types.Event, error) {
+	seen := map[string]struct{}{joinEvent.EventID(): {}}
+	next := make([]string, 0, len(latest))
+	for _, extremity := range latest {
+		if _, ok := seen[extremity.EventID]; !ok {
+			seen[extremity.EventID] = struct{}{}
+			next = append(next, extremity.EventID)
+		}
+	}
+	var found []types.Event
+	for len(next) > 0 {
+		events, err := updater.EventsFromIDs(ctx, roomInfo, next)
+		if err != nil {
+			return nil, fmt.Errorf("updater.EventsFromIDs: %w", err)
+		}
+		next = nil
+		for _, event := range events {
+			// Events that were stored before our join can't have been
+			// accepted with partial state.
+			if event.EventNID <= joinEvent.EventNID {
+				continue
+			}
+			found = append(found, event)
+			for _, prevEventID := range event.PrevEventIDs() {
+				if _, ok := seen[prevEventID]; !ok {
+					seen[prevEventID] = struct{}{}
+					next = append(next, prevEventID)
+				}
+			}
+		}
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+
+	eventIDs := make([]string, 0, len(found))
+	for _, event := range found {
+		eventIDs = append(eventIDs, event.EventID())
+	}
+	snapshots, err := updater.BulkSelectSnapshotsFromEventIDs(ctx, eventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("updater.BulkSelectSnapshotsFromEventIDs: %w", err)
+	}
+	outliers := make(map[string]struct{}, len(snapshots[0]))
+	for _, eventID := range snapshots[0] {
+		outliers[eventID] = struct{}{}
+	}
+	eventIDs = eventIDs[:0]
+	for _, event := range found {
+		if _, ok := outliers[event.EventID()]; !ok {
+			eventIDs = append(eventIDs, event.EventID())
+		}
+	}
+	if len(eventIDs) == 0 {
+		return nil, nil
+	}
+	stateAtEvents, err := updater.StateAtEventIDs(ctx, eventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("updater.StateAtEventIDs: %w", err)
+	}
+	accepted := make(map[types.EventNID]struct{}, len(stateAtEvents))
+	for _, stateAtEvent := range stateAtEvents {
+		if !stateAtEvent.IsRejected {
+			accepted[stateAtEvent.EventNID] = struct{}{}
+		}
+	}
+	window := make([]types.Event, 0, len(accepted))
+	for _, event := range found {
+		if _, ok := accepted[event.EventNID]; ok {
+			window = append(window, event)
+		}
+	}
+	sort.Slice(window, func(a, b int) bool {
+		if window[a].Depth() != window[b].Depth() {
+			return window[a].Depth() < window[b].Depth()
+		}
+		return window[a].EventNID < window[b].EventNID
+	})
+	return window, nil
+}
+
+// reauthPartialStateEvents replays the events that were accepted while the
+// room had partial state on top of the full state at our join event, and
+// marks the ones that aren't allowed by it as rejected. The rejected events
+// are returned, mapped to the last allowed event with the same state key
+// after our join, or to 0 if there was none.
+func (r *Inputer) reauthPartialStateEvents(
+	ctx context.Context, updater *shared.RoomUpdater,
+	stateAtJoin []gomatrixserverlib.PDU, window []types.Event,
+) (map[types.EventNID]types.EventNID, error) {
+	rejected := map[types.EventNID]types.EventNID{}
+	if len(window) == 0 {
+		return rejected, nil
+	}
+	authEvents, err := gomatrixserverlib.NewAuthEvents(stateAtJoin)
+	if err != nil {
+		return nil, fmt.Errorf("gomatrixserverlib.NewAuthEvents: %w", err)
+	}
+	allowed := map[gomatrixserverlib.StateKeyTuple]types.EventNID{}
+	for _, event := range window {
+		authErr := gomatrixserverlib.Allowed(event.PDU, authEvents, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+			return r.Queryer.QueryUserIDForSender(ctx, roomID, senderID)
+		})
+		if authErr == nil {
+			if event.StateKey() != nil {
+				if err = authEvents.AddEvent(event.PDU); err != nil {
+					return nil, fmt.Errorf("authEvents.AddEvent: %w", err)
+				}
+				allowed[gomatrixserverlib.StateKeyTuple{EventType: event.Type(), StateKey: *event.StateKey()}] = event.EventNID
+			}
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"room_id":  event.RoomID().String(),
+			"event_id": event.EventID(),
+		}).WithError(authErr).Warn("Rejecting event that was accepted while the room had partial state")
+		if err = updater.MarkEventAsRejected(event.EventNID); err != nil {
+			return nil, fmt.Errorf("updater.MarkEventAsRejected: %w", err)
+		}
+		rejected[event.EventNID] = 0
+		if event.StateKey() != nil {
+			rejected[event.EventNID] = allowed[gomatrixserverlib.StateKeyTuple{EventType: event.Type(), StateKey: *event.StateKey()}]
+		}
+	}
+	return rejected, nil
+}
+
+// replaceRejectedExtremities returns the forward extremities of the room with
+// the rejected events replaced by their closest allowed ancestors, falling
+// back to our join event.
+func replaceRejectedExtremities(
+	ctx context.Context, updater *shared.RoomUpdater, joinEvent types.Event,
+	window []types.Event, rejected map[types.EventNID]types.EventNID,
+) ([]types.StateAtEventAndReference, error) {
+	latest := updater.LatestEvents()
+	if len(rejected) == 0 {
+		return latest, nil
+	}
+	byID := make(map[string]types.Event, len(window))
+	referenced := map[string]struct{}{}
+	for _, event := range window {
+		byID[event.EventID()] = event
+		if _, ok := rejected[event.EventNID]; !ok {
+			for _, prevEventID := range event.PrevEventIDs() {
+				referenced[prevEventID] = struct{}{}
+			}
+		}
+	}
+
+	kept := make([]types.StateAtEventAndReference, 0, len(latest))
+	have := map[string]struct{}{}
+	for _, extremity := range latest {
+		if _, ok := rejected[extremity.EventNID]; !ok {
+			kept = append(kept, extremity)
+			have[extremity.EventID] = struct{}{}
+		}
+	}
+	var replacements []string
+	var walk func(event types.Event)
+	walk = func(event types.Event) {
+		for _, prevEventID := range event.PrevEventIDs() {
+			prev, inWindow := byID[prevEventID]
+			if _, ok := rejected[prev.EventNID]; inWindow && ok {
+				walk(prev)
+				continue
+			}
+			if !inWindow && prevEventID != joinEvent.EventID() {
+				continue
+			}
+			if _, ok := referenced[prevEventID]; ok {
+				continue
+			}
+			if _, ok := have[prevEventID]; !ok {
+				have[prevEventID] = struct{}{}
+				replacements = append(replacements, prevEventID)
+			}
+		}
+	}
+	for _, extremity := range latest {
+		if _, ok := rejected[extremity.EventNID]; ok {
+			walk(byID[extremity.EventID])
+		}
+	}
+	if len(kept) == 0 && len(replacements) == 0 {
+		replacements = append(replacements, joinEvent.EventID())
+	}
+	if len(replacements) == 0 {
+		return kept, nil
+	}
+
+	stateAtEvents, err := updater.StateAtEventIDs(ctx, replacements)
+	if err != nil {
+		return nil, fmt.Errorf("updater.StateAtEventIDs: %w", err)
+	}
+	eventIDs := make(map[types.EventNID]string, len(window)+1)
+	eventIDs[joinEvent.EventNID] = joinEvent.EventID()
+	for _, event := range window {
+		eventIDs[event.EventNID] = event.EventID()
+	}
+	for _, stateAtEvent := range stateAtEvents {
+		kept = append(kept, types.StateAtEventAndReference{
+			StateAtEvent: stateAtEvent,
+			EventID:      eventIDs[stateAtEvent.EventNID],
+		})
+	}
+	return kept, nil
+}
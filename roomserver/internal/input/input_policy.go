@@ -0,0 +1,244 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package input
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/eventutil"
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/policylists"
+	"github.com/jchv/maidtrix/roomserver/storage/tables"
+	"github.com/sirupsen/logrus"
+)
+
+// handlePolicyLists keeps the policy list rules up to date as events arrive
+// in the watched policy list rooms, and auto-bans matching users if a
+// moderator account has been configured.
+func (r *Inputer) handlePolicyLists(ctx context.Context, event gomatrixserverlib.PDU) {
+	if r.PolicyLists == nil || event.StateKey() == nil {
+		return
+	}
+	roomID := event.RoomID()
+
+	if r.PolicyLists.IsPolicyRoom(roomID.String()) && policylists.IsPolicyRule(event.Type()) {
+		rule := r.PolicyLists.OnPolicyRuleUpdate(tables.StrippedEvent{
+			RoomID:       roomID.String(),
+			EventType:    event.Type(),
+			StateKey:     *event.StateKey(),
+			ContentValue: string(event.Content()),
+		})
+		if rule != nil && r.Cfg.PolicyLists.AutoBanUserID != "" {
+			// Scanning every room that the moderator is in can take a while,
+			// so don't hold up the input of the policy list room.
+			go r.enforcePolicyRule(context.Background(), rule)
+		}
+		return
+	}
+
+	if event.Type() != spec.MRoomMember {
+		return
+	}
+	if membership, _ := event.Membership(); membership != spec.Join {
+		return
+	}
+	userID, err := r.Queryer.QueryUserIDForSender(ctx, roomID, spec.SenderID(*event.StateKey()))
+	if err != nil || userID == nil {
+		return
+	}
+
+	// If we've just joined one of the policy list rooms then we may not have
+	// seen its rules yet.
+	if r.PolicyLists.IsPolicyRoom(roomID.String()) {
+		if r.Cfg.Matrix.IsLocalServerName(userID.Domain()) {
+			r.PolicyLists.Load(ctx, r.Queryer.DB, roomID.String())
+		}
+		return
+	}
+
+	if r.Cfg.PolicyLists.AutoBanUserID == "" {
+		return
+	}
+	if rule := r.PolicyLists.MatchUser(*userID); rule != nil {
+		if err = r.autoBan(ctx, roomID, *userID, rule); err != nil {
+			logrus.WithError(err).WithField("room_id", roomID.String()).Error("Failed to auto-ban user")
+		}
+	}
+}
+
+// enforcePolicyRule bans the users matched by a new rule from all of the rooms
+// that the moderator is joined to.
+func (r *Inputer) enforcePolicyRule(ctx context.Context, rule *policylists.Rule) {
+	logger := logrus.WithFields(logrus.Fields{
+		"policy_room_id": rule.PolicyRoomID,
+		"policy_entity":  rule.Entity,
+	})
+	moderator, err := spec.NewUserID(r.Cfg.PolicyLists.AutoBanUserID, true)
+	if err != nil {
+		logger.WithError(err).Error("Invalid policy list moderator")
+		return
+	}
+	roomIDs, err := r.Queryer.DB.GetRoomsByMembership(ctx, *moderator, spec.Join)
+	if err != nil {
+		logger.WithError(err).Error("Failed to get rooms for policy list moderator")
+		return
+	}
+	for _, roomIDStr := range roomIDs {
+		if r.PolicyLists.IsPolicyRoom(roomIDStr) {
+			continue
+		}
+		roomID, err := spec.NewRoomID(roomIDStr)
+		if err != nil {
+			continue
+		}
+		roomInfo, err := r.DB.RoomInfo(ctx, roomIDStr)
+		if err != nil || roomInfo == nil {
+			continue
+		}
+		membershipNIDs, err := r.DB.GetMembershipEventNIDsForRoom(ctx, roomInfo.RoomNID, true, false)
+		if err != nil {
+			logger.WithError(err).WithField("room_id", roomIDStr).Error("Failed to get room members")
+			continue
+		}
+		memberEvents, err := r.DB.Events(ctx, roomInfo.RoomVersion, membershipNIDs)
+		if err != nil {
+			logger.WithError(err).WithField("room_id", roomIDStr).Error("Failed to get room members")
+			continue
+		}
+		for _, memberEvent := range memberEvents {
+			if memberEvent.StateKey() == nil {
+				continue
+			}
+			userID, err := r.Queryer.QueryUserIDForSender(ctx, *roomID, spec.SenderID(*memberEvent.StateKey()))
+			if err != nil || userID == nil || !rule.MatchesUser(*userID) {
+				continue
+			}
+			if err = r.autoBan(ctx, *roomID, *userID, rule); err != nil {
+				logger.WithError(err).WithField("room_id", roomIDStr).Error("Failed to auto-ban user")
+			}
+		}
+	}
+}
+
+// autoBan bans the target from the room as the configured moderator, as long
+// as the moderator has enough power to do so.
+func (r *Inputer) autoBan(ctx context.Context, roomID spec.RoomID, target spec.UserID, rule *policylists.Rule) error {
+	moderator, err := spec.NewUserID(r.Cfg.PolicyLists.AutoBanUserID, true)
+	if err != nil {
+		return err
+	}
+	if moderator.String() == target.String() {
+		return nil
+	}
+	moderatorSenderID, err := r.Queryer.QuerySenderIDForUser(ctx, roomID, *moderator)
+	if err != nil || moderatorSenderID == nil {
+		return fmt.Errorf("r.Queryer.QuerySenderIDForUser: %w", err)
+	}
+	targetSenderID, err := r.Queryer.QuerySenderIDForUser(ctx, roomID, target)
+	if err != nil || targetSenderID == nil {
+		return fmt.Errorf("r.Queryer.QuerySenderIDForUser: %w", err)
+	}
+
+	stateKey := string(*targetSenderID)
+	content, err := json.Marshal(gomatrixserverlib.MemberContent{
+		Membership: spec.Ban,
+		Reason:     rule.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	proto := &gomatrixserverlib.ProtoEvent{
+		RoomID:   roomID.String(),
+		Type:     spec.MRoomMember,
+		StateKey: &stateKey,
+		SenderID: string(*moderatorSenderID),
+		Content:  content,
+	}
+	eventsNeeded, err := gomatrixserverlib.StateNeededForProtoEvent(proto)
+	if err != nil {
+		return err
+	}
+	latestReq := &api.QueryLatestEventsAndStateRequest{
+		RoomID:       roomID.String(),
+		StateToFetch: eventsNeeded.Tuples(),
+	}
+	latestRes := &api.QueryLatestEventsAndStateResponse{}
+	if err = r.Queryer.QueryLatestEventsAndState(ctx, latestReq, latestRes); err != nil {
+		return err
+	}
+	if !latestRes.RoomExists {
+		return nil
+	}
+
+	// Only ban if the moderator is joined to the room and outranks the target,
+	// otherwise the ban would just be rejected.
+	moderatorJoined := false
+	var powerLevels *gomatrixserverlib.PowerLevelContent
+	for _, ev := range latestRes.StateEvents {
+		switch {
+		case ev.Type() == spec.MRoomMember && ev.StateKeyEquals(string(*moderatorSenderID)):
+			membership, _ := ev.Membership()
+			moderatorJoined = membership == spec.Join
+		case ev.Type() == spec.MRoomMember && ev.StateKeyEquals(stateKey):
+			if membership, _ := ev.Membership(); membership == spec.Ban {
+				return nil
+			}
+		case ev.Type() == spec.MRoomPowerLevels && ev.StateKeyEquals(""):
+			pl, plErr := gomatrixserverlib.NewPowerLevelContentFromEvent(ev)
+			if plErr != nil {
+				return plErr
+			}
+			powerLevels = &pl
+		}
+	}
+	if !moderatorJoined || powerLevels == nil {
+		return nil
+	}
+	moderatorLevel := powerLevels.UserLevel(*moderatorSenderID)
+	if moderatorLevel < powerLevels.Ban || moderatorLevel <= powerLevels.UserLevel(*targetSenderID) {
+		return nil
+	}
+
+	signingIdentity, err := r.SigningIdentity(ctx, roomID, *moderator)
+	if err != nil {
+		return err
+	}
+	event, err := eventutil.BuildEvent(ctx, proto, &signingIdentity, time.Now(), &eventsNeeded, latestRes)
+	if err != nil {
+		return err
+	}
+
+	inputReq := &api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{{
+			Kind:         api.KindNew,
+			Event:        event,
+			Origin:       moderator.Domain(),
+			SendAsServer: string(moderator.Domain()),
+		}},
+		Asynchronous: true, // Needs to be async, as we otherwise create a deadlock
+	}
+	inputRes := &api.InputRoomEventsResponse{}
+	r.InputRoomEvents(ctx, inputReq, inputRes)
+	if err = inputRes.Err(); err != nil {
+		return err
+	}
+	rule.Audit("ban", target.String(), roomID.String())
+	return nil
+}
@@ -32,6 +32,8 @@ import (
 	"github.com/jchv/maidtrix/roomserver/types"
 	"github.com/jchv/maidtrix/setup/config"
 	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 type Admin struct {
@@ -40,6 +42,8 @@ type Admin struct {
 	Queryer *query.Queryer
 	Inputer *input.Inputer
 	Leaver  *Leaver
+	Inviter *Inviter
+	Joiner  *Joiner
 }
 
 // PerformAdminEvacuateRoom will remove all local users from the given room.
@@ -187,7 +191,7 @@ func (r *Admin) PerformAdminEvacuateUser(
 		if len(outputEvents) == 0 {
 			continue
 		}
-		if err := r.Inputer.OutputProducer.ProduceRoomEvents(roomID, outputEvents); err != nil {
+		if err := r.Inputer.OutputProducer.ProduceRoomEvents(ctx, roomID, outputEvents); err != nil {
 			return nil, err
 		}
 	}
@@ -212,7 +216,7 @@ func (r *Admin) PerformAdminPurgeRoom(
 
 	logrus.WithField("room_id", roomID).Warn("Room purged from roomserver, informing other components")
 
-	return r.Inputer.OutputProducer.ProduceRoomEvents(roomID, []api.OutputEvent{
+	return r.Inputer.OutputProducer.ProduceRoomEvents(ctx, roomID, []api.OutputEvent{
 		{
 			Type: api.OutputTypePurgeRoom,
 			PurgeRoom: &api.OutputPurgeRoom{
@@ -358,3 +362,186 @@ func (r *Admin) PerformAdminDownloadState(
 func (r *Admin) PerformAdminDeleteEventReport(ctx context.Context, reportID uint64) error {
 	return r.DB.AdminDeleteEventReport(ctx, reportID)
 }
+
+// PerformAdminMakeRoomAdmin gives the user the same power level as the local
+// member with the highest power level in the room, inviting and joining the
+// user first if needed. Returns the user ID of the member that granted the
+// power, or api.ErrNotAllowed if no local member can change the power levels.
+func (r *Admin) PerformAdminMakeRoomAdmin(
+	ctx context.Context,
+	roomID string,
+	userID spec.UserID,
+) (grantedBy string, err error) {
+	if !r.Cfg.Matrix.IsLocalServerName(userID.Domain()) {
+		return "", api.ErrInvalidID{Err: fmt.Errorf("can only make local users room admins")}
+	}
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return "", api.ErrInvalidID{Err: err}
+	}
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	if roomInfo == nil || roomInfo.IsStub() {
+		return "", eventutil.ErrRoomNoExists{}
+	}
+
+	plEvent, err := r.DB.GetStateEvent(ctx, roomID, spec.MRoomPowerLevels, "")
+	if err != nil {
+		return "", err
+	}
+	if plEvent == nil {
+		return "", fmt.Errorf("room %s has no power levels", roomID)
+	}
+	powerLevels, err := plEvent.PowerLevels()
+	if err != nil {
+		return "", err
+	}
+
+	// Find the local member with the highest power level.
+	memberNIDs, err := r.DB.GetMembershipEventNIDsForRoom(ctx, roomInfo.RoomNID, true, true)
+	if err != nil {
+		return "", err
+	}
+	memberEvents, err := r.DB.Events(ctx, roomInfo.RoomVersion, memberNIDs)
+	if err != nil {
+		return "", err
+	}
+	var admin *spec.UserID
+	var adminLevel int64
+	for _, memberEvent := range memberEvents {
+		if memberEvent.StateKey() == nil {
+			continue
+		}
+		senderID := spec.SenderID(*memberEvent.StateKey())
+		level := powerLevels.UserLevel(senderID)
+		if admin != nil && level <= adminLevel {
+			continue
+		}
+		memberUserID, queryErr := r.Queryer.QueryUserIDForSender(ctx, *validRoomID, senderID)
+		if queryErr != nil || memberUserID == nil {
+			continue
+		}
+		admin, adminLevel = memberUserID, level
+	}
+	if admin == nil || adminLevel < powerLevels.EventLevel(spec.MRoomPowerLevels, true) {
+		return "", api.ErrNotAllowed{Err: fmt.Errorf("no local user in room %s has enough power to change the power levels", roomID)}
+	}
+
+	// Make sure the user is joined to the room, inviting them first if
+	// they can't join on their own.
+	membershipRes := &api.QueryMembershipForUserResponse{}
+	if err = r.Queryer.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{
+		RoomID: roomID,
+		UserID: userID,
+	}, membershipRes); err != nil {
+		return "", err
+	}
+	if membershipRes.Membership != spec.Join {
+		joinRule := spec.Invite
+		if joinRuleEvent, _ := r.DB.GetStateEvent(ctx, roomID, spec.MRoomJoinRules, ""); joinRuleEvent != nil {
+			joinRule, _ = joinRuleEvent.JoinRule()
+		}
+		if joinRule != spec.Public && membershipRes.Membership != spec.Invite {
+			identity, identityErr := r.Cfg.Matrix.SigningIdentityFor(admin.Domain())
+			if identityErr != nil {
+				return "", identityErr
+			}
+			if err = r.Inviter.PerformInvite(ctx, &api.PerformInviteRequest{
+				InviteInput: api.InviteInput{
+					RoomID:     *validRoomID,
+					Inviter:    *admin,
+					Invitee:    userID,
+					KeyID:      identity.KeyID,
+					PrivateKey: identity.PrivateKey,
+					EventTime:  time.Now(),
+				},
+				SendAsServer: string(admin.Domain()),
+			}); err != nil {
+				return "", fmt.Errorf("failed to invite %s: %w", userID.String(), err)
+			}
+		}
+		if _, _, err = r.Joiner.PerformJoin(ctx, &api.PerformJoinRequest{
+			RoomIDOrAlias: roomID,
+			UserID:        userID.String(),
+		}); err != nil {
+			return "", fmt.Errorf("failed to join %s: %w", userID.String(), err)
+		}
+	}
+
+	targetSenderID, err := r.Queryer.QuerySenderIDForUser(ctx, *validRoomID, userID)
+	if err != nil {
+		return "", err
+	} else if targetSenderID == nil {
+		return "", fmt.Errorf("sender ID not found for %s in %s", userID.String(), roomID)
+	}
+	if powerLevels.UserLevel(*targetSenderID) >= adminLevel {
+		return admin.String(), nil
+	}
+	adminSenderID, err := r.Queryer.QuerySenderIDForUser(ctx, *validRoomID, *admin)
+	if err != nil {
+		return "", err
+	} else if adminSenderID == nil {
+		return "", fmt.Errorf("sender ID not found for %s in %s", admin.String(), roomID)
+	}
+
+	// Only change the user's entry, so that any fields we don't know about
+	// are kept as they are.
+	content, err := sjson.SetBytes(plEvent.Content(), "users."+gjson.Escape(string(*targetSenderID)), adminLevel)
+	if err != nil {
+		return "", err
+	}
+	emptyStateKey := ""
+	proto := &gomatrixserverlib.ProtoEvent{
+		RoomID:   roomID,
+		Type:     spec.MRoomPowerLevels,
+		StateKey: &emptyStateKey,
+		SenderID: string(*adminSenderID),
+		Content:  content,
+	}
+	eventsNeeded, err := gomatrixserverlib.StateNeededForProtoEvent(proto)
+	if err != nil {
+		return "", err
+	}
+	latestRes := &api.QueryLatestEventsAndStateResponse{}
+	if err = r.Queryer.QueryLatestEventsAndState(ctx, &api.QueryLatestEventsAndStateRequest{
+		RoomID:       roomID,
+		StateToFetch: eventsNeeded.Tuples(),
+	}, latestRes); err != nil {
+		return "", err
+	}
+	identity, err := r.Cfg.Matrix.SigningIdentityFor(admin.Domain())
+	if err != nil {
+		return "", err
+	}
+	event, err := eventutil.BuildEvent(ctx, proto, identity, time.Now(), &eventsNeeded, latestRes)
+	if err != nil {
+		return "", err
+	}
+
+	inputRes := &api.InputRoomEventsResponse{}
+	r.Inputer.InputRoomEvents(ctx, &api.InputRoomEventsRequest{
+		InputRoomEvents: []api.InputRoomEvent{{
+			Kind:         api.KindNew,
+			Event:        event,
+			Origin:       admin.Domain(),
+			SendAsServer: string(admin.Domain()),
+		}},
+	}, inputRes)
+	if err = inputRes.Err(); err != nil {
+		return "", err
+	}
+	return admin.String(), nil
+}
+
+// PerformAdminMergeForwardExtremities has a local member of the room send a
+// dummy event which references the forward extremities of the room. Returns
+// the ID of the dummy event, or an empty string if there was nothing to merge.
+func (r *Admin) PerformAdminMergeForwardExtremities(ctx context.Context, roomID string) (eventID string, err error) {
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return "", api.ErrInvalidID{Err: err}
+	}
+	return r.Inputer.SendDummyEvent(ctx, *validRoomID)
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/roomserver/auth"
 	"github.com/jchv/maidtrix/roomserver/internal/helpers"
+	"github.com/jchv/maidtrix/roomserver/internal/stategc"
 	"github.com/jchv/maidtrix/roomserver/state"
 	"github.com/jchv/maidtrix/roomserver/storage"
 	"github.com/jchv/maidtrix/roomserver/types"
@@ -43,6 +44,7 @@ type Backfiller struct {
 	FSAPI             federationAPI.RoomserverFederationAPI
 	KeyRing           gomatrixserverlib.JSONVerifier
 	Querier           api.QuerySenderIDAPI
+	StateGC           *stategc.Collector
 
 	// The servers which should be preferred above other servers when backfilling
 	PreferServers []spec.ServerName
@@ -138,6 +140,7 @@ func (r *Backfiller) backfillViaFederation(ctx context.Context, req *api.Perform
 	// persist these new events - auth checks have already been done
 	roomNID, backfilledEventMap := persistEvents(ctx, r.DB, r.Querier, events)
 
+	defer r.StateGC.Hold(req.RoomID)()
 	for _, ev := range backfilledEventMap {
 		// now add state for these events
 		stateIDs, ok := requester.eventIDToBeforeStateIDs[ev.EventID()]
@@ -45,11 +45,13 @@ type Creator struct {
 }
 
 // PerformCreateRoom handles all the steps necessary to create a new room.
+// Room versions with domainless room IDs ignore the given room ID and derive
+// it from the create event instead, so callers should use the returned ID.
 // nolint: gocyclo
-func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roomID spec.RoomID, createRequest *api.PerformCreateRoomRequest) (string, *util.JSONResponse) {
+func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roomID spec.RoomID, createRequest *api.PerformCreateRoomRequest) (string, *spec.RoomID, *util.JSONResponse) {
 	verImpl, err := gomatrixserverlib.GetRoomVersion(createRequest.RoomVersion)
 	if err != nil {
-		return "", &util.JSONResponse{
+		return "", nil, &util.JSONResponse{
 			Code: http.StatusBadRequest,
 			JSON: spec.BadJSON("unknown room version"),
 		}
@@ -59,19 +61,23 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	if len(createRequest.CreationContent) > 0 {
 		if err = json.Unmarshal(createRequest.CreationContent, &createContent); err != nil {
 			util.GetLogger(ctx).WithError(err).Error("json.Unmarshal for creation_content failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusBadRequest,
 				JSON: spec.BadJSON("invalid create content"),
 			}
 		}
 	}
 
-	_, err = c.DB.AssignRoomNID(ctx, roomID, createRequest.RoomVersion)
-	if err != nil {
-		util.GetLogger(ctx).WithError(err).Error("failed to assign roomNID")
-		return "", &util.JSONResponse{
-			Code: http.StatusInternalServerError,
-			JSON: spec.InternalServerError{},
+	// Domainless room IDs aren't known until the create event has been built,
+	// so the room NID is assigned later on for those.
+	if !verImpl.DomainlessRoomIDs() {
+		_, err = c.DB.AssignRoomNID(ctx, roomID, createRequest.RoomVersion)
+		if err != nil {
+			util.GetLogger(ctx).WithError(err).Error("failed to assign roomNID")
+			return "", nil, &util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
 		}
 	}
 
@@ -81,7 +87,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		key, keyErr := c.RSAPI.GetOrCreateUserRoomPrivateKey(ctx, userID, roomID)
 		if keyErr != nil {
 			util.GetLogger(ctx).WithError(keyErr).Error("GetOrCreateUserRoomPrivateKey failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -94,7 +100,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	// TODO: Maybe, at some point, GMSL should return the events to create, so we can define the version
 	// entirely there.
 	switch createRequest.RoomVersion {
-	case gomatrixserverlib.RoomVersionV11:
+	case gomatrixserverlib.RoomVersionV11, gomatrixserverlib.RoomVersionV12:
 		// RoomVersionV11 removed the creator field from the create content: https://github.com/matrix-org/matrix-spec-proposals/pull/2175
 	default:
 		createContent["creator"] = senderID
@@ -102,6 +108,10 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 
 	createContent["room_version"] = createRequest.RoomVersion
 	powerLevelContent := eventutil.InitialPowerLevelsContent(string(senderID))
+	if verImpl.PrivilegedCreators() {
+		// Only the creators should be able to upgrade the room by default.
+		powerLevelContent.Events["m.room.tombstone"] = 150
+	}
 	joinRuleContent := gomatrixserverlib.JoinRuleContent{
 		JoinRule: spec.Invite,
 	}
@@ -114,7 +124,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		err = json.Unmarshal(createRequest.PowerLevelContentOverride, &powerLevelContent)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("json.Unmarshal for power_level_content_override failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusBadRequest,
 				JSON: spec.BadJSON("malformed power_level_content_override"),
 			}
@@ -130,8 +140,15 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	case spec.PresetTrustedPrivateChat:
 		joinRuleContent.JoinRule = spec.Invite
 		historyVisibilityContent.HistoryVisibility = historyVisibilityShared
-		for _, invitee := range createRequest.InvitedUsers {
-			powerLevelContent.Users[invitee] = 100
+		if verImpl.PrivilegedCreators() {
+			// Invitees become creators rather than getting a power level.
+			if len(createRequest.InvitedUsers) > 0 {
+				createContent["additional_creators"] = createRequest.InvitedUsers
+			}
+		} else {
+			for _, invitee := range createRequest.InvitedUsers {
+				powerLevelContent.Users[invitee] = 100
+			}
 		}
 		guestsCanJoin = true
 	case spec.PresetPublicChat:
@@ -139,6 +156,17 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		historyVisibilityContent.HistoryVisibility = historyVisibilityShared
 	}
 
+	if verImpl.PrivilegedCreators() {
+		// Creators have infinite power, and may not be listed in the power
+		// levels at all, so remove them in case an override added them back.
+		delete(powerLevelContent.Users, string(senderID))
+		if createRequest.StatePreset == spec.PresetTrustedPrivateChat {
+			for _, invitee := range createRequest.InvitedUsers {
+				delete(powerLevelContent.Users, invitee)
+			}
+		}
+	}
+
 	createEvent := gomatrixserverlib.FledglingEvent{
 		Type:    spec.MRoomCreate,
 		Content: createContent,
@@ -170,7 +198,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	identity, err := c.Cfg.Matrix.SigningIdentityFor(userID.Domain()) // we MUST use the server signing mxid_mapping
 	if err != nil {
 		logrus.WithError(err).WithField("domain", userID.Domain()).Error("unable to find signing identity for domain")
-		return "", &util.JSONResponse{
+		return "", nil, &util.JSONResponse{
 			Code: http.StatusInternalServerError,
 			JSON: spec.InternalServerError{},
 		}
@@ -182,7 +210,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		pseudoIDKey, err = c.RSAPI.GetOrCreateUserRoomPrivateKey(ctx, userID, roomID)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("GetOrCreateUserRoomPrivateKey failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -195,7 +223,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 
 		// Sign the mapping with the server identity
 		if err = mapping.Sign(identity.ServerName, identity.KeyID, identity.PrivateKey); err != nil {
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -257,13 +285,13 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		err = c.RSAPI.GetRoomIDForAlias(ctx, &hasAliasReq, &aliasResp)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("aliasAPI.GetRoomIDForAlias failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
 		}
 		if aliasResp.RoomID != "" {
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusBadRequest,
 				JSON: spec.RoomInUse("Room ID already exists."),
 			}
@@ -354,7 +382,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	authEvents, err := gomatrixserverlib.NewAuthEvents(nil)
 	if err != nil {
 		util.GetLogger(ctx).WithError(err).Error("gomatrixserverlib.NewAuthEvents failed")
-		return "", &util.JSONResponse{
+		return "", nil, &util.JSONResponse{
 			Code: http.StatusInternalServerError,
 			JSON: spec.InternalServerError{},
 		}
@@ -372,7 +400,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		err = builder.SetContent(e.Content)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("builder.SetContent failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -383,7 +411,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		var ev gomatrixserverlib.PDU
 		if err = builder.AddAuthEvents(authEvents); err != nil {
 			util.GetLogger(ctx).WithError(err).Error("AddAuthEvents failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -391,7 +419,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		ev, err = builder.Build(createRequest.EventTime, identity.ServerName, identity.KeyID, identity.PrivateKey)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("buildEvent failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -401,18 +429,29 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 			return c.RSAPI.QueryUserIDForSender(ctx, roomID, senderID)
 		}); err != nil {
 			util.GetLogger(ctx).WithError(err).Error("gomatrixserverlib.Allowed failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
 		}
 
+		if i == 0 && verImpl.DomainlessRoomIDs() {
+			roomID = ev.RoomID()
+			if _, err = c.DB.AssignRoomNID(ctx, roomID, createRequest.RoomVersion); err != nil {
+				util.GetLogger(ctx).WithError(err).Error("failed to assign roomNID")
+				return "", nil, &util.JSONResponse{
+					Code: http.StatusInternalServerError,
+					JSON: spec.InternalServerError{},
+				}
+			}
+		}
+
 		// Add the event to the list of auth events
 		builtEvents = append(builtEvents, &types.HeaderedEvent{PDU: ev})
 		err = authEvents.AddEvent(ev)
 		if err != nil {
 			util.GetLogger(ctx).WithError(err).Error("authEvents.AddEvent failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -432,7 +471,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	// send the events to the roomserver
 	if err = api.SendInputRoomEvents(ctx, c.RSAPI, userID.Domain(), inputs, false); err != nil {
 		util.GetLogger(ctx).WithError(err).Error("roomserverAPI.SendInputRoomEvents failed")
-		return "", &util.JSONResponse{
+		return "", nil, &util.JSONResponse{
 			Code: http.StatusInternalServerError,
 			JSON: spec.InternalServerError{},
 		}
@@ -445,14 +484,14 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 		aliasAlreadyExists, aliasErr := c.RSAPI.SetRoomAlias(ctx, senderID, roomID, roomAlias)
 		if aliasErr != nil {
 			util.GetLogger(ctx).WithError(aliasErr).Error("aliasAPI.SetRoomAlias failed")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
 		}
 
 		if aliasAlreadyExists {
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusBadRequest,
 				JSON: spec.RoomInUse("Room alias already exists."),
 			}
@@ -495,7 +534,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 			inviteeUserID, userIDErr := spec.NewUserID(invitee, true)
 			if userIDErr != nil {
 				util.GetLogger(ctx).WithError(userIDErr).Error("invalid UserID")
-				return "", &util.JSONResponse{
+				return "", nil, &util.JSONResponse{
 					Code: http.StatusInternalServerError,
 					JSON: spec.InternalServerError{},
 				}
@@ -517,12 +556,12 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 			})
 			switch e := err.(type) {
 			case api.ErrInvalidID:
-				return "", &util.JSONResponse{
+				return "", nil, &util.JSONResponse{
 					Code: http.StatusBadRequest,
 					JSON: spec.Unknown(e.Error()),
 				}
 			case api.ErrNotAllowed:
-				return "", &util.JSONResponse{
+				return "", nil, &util.JSONResponse{
 					Code: http.StatusForbidden,
 					JSON: spec.Forbidden(e.Error()),
 				}
@@ -530,7 +569,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 			default:
 				util.GetLogger(ctx).WithError(err).Error("PerformInvite failed")
 				sentry.CaptureException(err)
-				return "", &util.JSONResponse{
+				return "", nil, &util.JSONResponse{
 					Code: http.StatusInternalServerError,
 					JSON: spec.InternalServerError{},
 				}
@@ -545,7 +584,7 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 			Visibility: spec.Public,
 		}); err != nil {
 			util.GetLogger(ctx).WithError(err).Error("failed to publish room")
-			return "", &util.JSONResponse{
+			return "", nil, &util.JSONResponse{
 				Code: http.StatusInternalServerError,
 				JSON: spec.InternalServerError{},
 			}
@@ -556,5 +595,5 @@ func (c *Creator) PerformCreateRoom(ctx context.Context, userID spec.UserID, roo
 	// TODO: Create room alias association
 	// Make sure this doesn't fall into an application service's namespace though!
 
-	return roomAlias, nil
+	return roomAlias, &roomID, nil
 }
@@ -157,6 +157,13 @@ func (r *Inviter) PerformInvite(
 		return api.ErrInvalidID{Err: fmt.Errorf("the invite must be from a local user")}
 	}
 
+	// Refuse invites from or to users that are banned by the policy lists.
+	roomID := req.InviteInput.RoomID.String()
+	if r.RSAPI.IsBannedByPolicyList(ctx, roomID, req.InviteInput.Inviter, spec.Invite) ||
+		r.RSAPI.IsBannedByPolicyList(ctx, roomID, req.InviteInput.Invitee, spec.Invite) {
+		return api.ErrNotAllowed{Err: fmt.Errorf("the invite is not allowed by this server's policy lists")}
+	}
+
 	isTargetLocal := r.Cfg.Matrix.IsLocalServerName(req.InviteInput.Invitee.Domain())
 
 	signingKey := req.InviteInput.PrivateKey
@@ -172,8 +172,9 @@ func (r *Joiner) performJoinRoomByID(
 
 	// If the server name in the room ID isn't ours then it's a
 	// possible candidate for finding the room via federation. Add
-	// it to the list of servers to try.
-	if !r.Cfg.Matrix.IsLocalServerName(roomID.Domain()) {
+	// it to the list of servers to try. Room IDs in newer room
+	// versions don't have a server name at all.
+	if roomID.Domain() != "" && !r.Cfg.Matrix.IsLocalServerName(roomID.Domain()) {
 		req.ServerNames = append(req.ServerNames, roomID.Domain())
 	}
 
@@ -194,6 +195,11 @@ func (r *Joiner) performJoinRoomByID(
 		return "", "", rsAPI.ErrInvalidID{Err: fmt.Errorf("user ID %q is invalid: %w", req.UserID, err)}
 	}
 
+	// Refuse joins to rooms, or by users, that are banned by the policy lists.
+	if r.Queryer.IsBannedByPolicyList(ctx, roomID.String(), *userID, spec.Join) {
+		return "", "", rsAPI.ErrNotAllowed{Err: fmt.Errorf("joining this room is not allowed by this server's policy lists")}
+	}
+
 	// Look up the room NID for the supplied room ID.
 	var senderID spec.SenderID
 	checkInvitePending := false
@@ -380,8 +386,9 @@ func (r *Joiner) performJoinRoomByID(
 	case eventutil.ErrRoomNoExists:
 		// The room doesn't exist locally. If the room ID looks like it should
 		// be ours then this probably means that we've nuked our database at
-		// some point.
-		if r.Cfg.Matrix.IsLocalServerName(roomID.Domain()) {
+		// some point. Room IDs without a server name could belong to anyone,
+		// so we can only look for them on the servers we were given.
+		if roomID.Domain() == "" || r.Cfg.Matrix.IsLocalServerName(roomID.Domain()) {
 			// If there are no more server names to try then give up here.
 			// Otherwise we'll try a federated join as normal, since it's quite
 			// possible that the room still exists on other servers.
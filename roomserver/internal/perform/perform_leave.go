@@ -106,6 +106,10 @@ func (r *Leaver) performLeaveRoomByID(
 		} else {
 			domain = sender.Domain()
 		}
+		if domain == "" {
+			// Room IDs in newer room versions don't have a server name.
+			return nil, fmt.Errorf("unable to find a server to reject the invite to %s through", req.RoomID)
+		}
 		if !r.Cfg.Matrix.IsLocalServerName(domain) {
 			return r.performFederatedRejectInvite(ctx, req, res, domain, eventID, *leaver)
 		}
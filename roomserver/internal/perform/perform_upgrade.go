@@ -72,9 +72,19 @@ func (r *Upgrader) performRoomUpgrade(
 		return "", api.ErrNotAllowed{Err: fmt.Errorf("You don't have permission to upgrade the room, power level too low.")}
 	}
 
+	verImpl, err := gomatrixserverlib.GetRoomVersion(roomVersion)
+	if err != nil {
+		return "", err
+	}
+
 	// TODO (#267): Check room ID doesn't clash with an existing one, and we
 	//              probably shouldn't be using pseudo-random strings, maybe GUIDs?
-	newRoomID := fmt.Sprintf("!%s:%s", util.RandomString(16), userID.Domain())
+	// Domainless room IDs are derived from the create event of the new room
+	// instead, so aren't known until the setup events have been built.
+	var newRoomID string
+	if !verImpl.DomainlessRoomIDs() {
+		newRoomID = fmt.Sprintf("!%s:%s", util.RandomString(16), userID.Domain())
+	}
 
 	// Get the existing room state for the old room.
 	oldRoomReq := &api.QueryLatestEventsAndStateRequest{
@@ -85,10 +95,16 @@ func (r *Upgrader) performRoomUpgrade(
 		return "", fmt.Errorf("Failed to get latest state: %s", err)
 	}
 
-	// Make the tombstone event
-	tombstoneEvent, pErr := r.makeTombstoneEvent(ctx, evTime, *senderID, userID.Domain(), roomID, newRoomID)
-	if pErr != nil {
-		return "", pErr
+	// Make the tombstone event. If the new room ID isn't known yet then the
+	// predecessor in the new create event can't refer to the tombstone, so
+	// it is made after the new room instead.
+	var tombstoneEvent *types.HeaderedEvent
+	var pErr error
+	if newRoomID != "" {
+		tombstoneEvent, pErr = r.makeTombstoneEvent(ctx, evTime, *senderID, userID.Domain(), roomID, newRoomID)
+		if pErr != nil {
+			return "", pErr
+		}
 	}
 
 	// Generate the initial events we need to send into the new room. This includes copied state events and bans
@@ -99,10 +115,17 @@ func (r *Upgrader) performRoomUpgrade(
 	}
 
 	// Send the setup events to the new room
-	if pErr = r.sendInitialEvents(ctx, evTime, *senderID, userID.Domain(), newRoomID, roomVersion, eventsToMake); pErr != nil {
+	if newRoomID, pErr = r.sendInitialEvents(ctx, evTime, *senderID, userID.Domain(), newRoomID, roomVersion, eventsToMake); pErr != nil {
 		return "", pErr
 	}
 
+	if tombstoneEvent == nil {
+		tombstoneEvent, pErr = r.makeTombstoneEvent(ctx, evTime, *senderID, userID.Domain(), roomID, newRoomID)
+		if pErr != nil {
+			return "", pErr
+		}
+	}
+
 	// 5. Send the tombstone event to the old room
 	if pErr = r.sendHeaderedEvent(ctx, userID.Domain(), tombstoneEvent, string(userID.Domain())); pErr != nil {
 		return "", pErr
@@ -304,6 +327,15 @@ func (r *Upgrader) userIsAuthorized(ctx context.Context, senderID spec.SenderID,
 	if err != nil {
 		return false
 	}
+	createEvent := api.GetStateEvent(ctx, r.URSAPI, roomID, gomatrixserverlib.StateKeyTuple{
+		EventType: spec.MRoomCreate,
+		StateKey:  "",
+	})
+	if createEvent != nil {
+		if creators := gomatrixserverlib.PrivilegedCreators(createEvent); creators != nil {
+			pl.SetCreators(creators)
+		}
+	}
 	// Check for power level required to send tombstone event (marks the current room as obsolete),
 	// if not found, use the StateDefault power level
 	return pl.UserLevel(senderID) >= pl.EventLevel("m.room.tombstone", true)
@@ -370,7 +402,7 @@ func (r *Upgrader) generateInitialEvents(ctx context.Context, oldRoom *api.Query
 	_ = json.Unmarshal(oldCreateEvent.Content(), &newCreateContent)
 
 	switch newVersion {
-	case gomatrixserverlib.RoomVersionV11:
+	case gomatrixserverlib.RoomVersionV11, gomatrixserverlib.RoomVersionV12:
 		// RoomVersionV11 removed the creator field from the create content: https://github.com/matrix-org/matrix-spec-proposals/pull/2175
 		// So if we are upgrading from pre v11, we need to remove the field.
 		delete(newCreateContent, "creator")
@@ -379,9 +411,16 @@ func (r *Upgrader) generateInitialEvents(ctx context.Context, oldRoom *api.Query
 	}
 
 	newCreateContent["room_version"] = newVersion
-	newCreateContent["predecessor"] = gomatrixserverlib.PreviousRoom{
-		EventID: tombstoneEvent.EventID(),
-		RoomID:  roomID,
+	if tombstoneEvent != nil {
+		newCreateContent["predecessor"] = gomatrixserverlib.PreviousRoom{
+			EventID: tombstoneEvent.EventID(),
+			RoomID:  roomID,
+		}
+	} else {
+		// The tombstone hasn't been made yet, so only refer to the old room.
+		newCreateContent["predecessor"] = map[string]interface{}{
+			"room_id": roomID,
+		}
 	}
 	newCreateEvent := gomatrixserverlib.FledglingEvent{
 		Type:     spec.MRoomCreate,
@@ -413,7 +452,33 @@ func (r *Upgrader) generateInitialEvents(ctx context.Context, oldRoom *api.Query
 		return nil, fmt.Errorf("Power level event content was invalid")
 	}
 
-	tempPowerLevelsEvent, powerLevelsOverridden := createTemporaryPowerLevels(powerLevelContent, senderID)
+	newVerImpl, err := gomatrixserverlib.GetRoomVersion(newVersion)
+	if err != nil {
+		return nil, err
+	}
+	var tempPowerLevelsEvent gomatrixserverlib.FledglingEvent
+	var powerLevelsOverridden bool
+	if newVerImpl.PrivilegedCreators() {
+		// Creators have infinite power in the new room, and may not be listed
+		// in the power levels at all, so there is no need for temporary ones.
+		creators := []string{string(senderID)}
+		if additionalCreators, ok := newCreateContent["additional_creators"].([]interface{}); ok {
+			for _, creator := range additionalCreators {
+				if creator, ok := creator.(string); ok {
+					creators = append(creators, creator)
+				}
+			}
+		}
+		for _, creator := range creators {
+			delete(powerLevelContent.Users, creator)
+		}
+		tempPowerLevelsEvent = gomatrixserverlib.FledglingEvent{
+			Type:    spec.MRoomPowerLevels,
+			Content: powerLevelContent,
+		}
+	} else {
+		tempPowerLevelsEvent, powerLevelsOverridden = createTemporaryPowerLevels(powerLevelContent, senderID)
+	}
 
 	// Now do the join rules event, same as the create and membership
 	// events. We'll set a sane default of "invite" so that if the
@@ -475,12 +540,15 @@ func (r *Upgrader) generateInitialEvents(ctx context.Context, oldRoom *api.Query
 	return eventsToMake, nil
 }
 
-func (r *Upgrader) sendInitialEvents(ctx context.Context, evTime time.Time, senderID spec.SenderID, userDomain spec.ServerName, newRoomID string, newVersion gomatrixserverlib.RoomVersion, eventsToMake []gomatrixserverlib.FledglingEvent) error {
+// sendInitialEvents builds and sends the setup events for the new room. It
+// returns the new room ID, which is derived from the create event if the
+// room version has domainless room IDs.
+func (r *Upgrader) sendInitialEvents(ctx context.Context, evTime time.Time, senderID spec.SenderID, userDomain spec.ServerName, newRoomID string, newVersion gomatrixserverlib.RoomVersion, eventsToMake []gomatrixserverlib.FledglingEvent) (string, error) {
 	var err error
 	var builtEvents []*types.HeaderedEvent
 	authEvents, err := gomatrixserverlib.NewAuthEvents(nil)
 	if err != nil {
-		return err
+		return "", err
 	}
 	for i, e := range eventsToMake {
 		depth := i + 1 // depth starts at 1
@@ -494,7 +562,7 @@ func (r *Upgrader) sendInitialEvents(ctx context.Context, evTime time.Time, send
 		}
 		err = proto.SetContent(e.Content)
 		if err != nil {
-			return fmt.Errorf("failed to set content of new %q event: %w", proto.Type, err)
+			return "", fmt.Errorf("failed to set content of new %q event: %w", proto.Type, err)
 		}
 		if i > 0 {
 			proto.PrevEvents = []string{builtEvents[i-1].EventID()}
@@ -503,31 +571,35 @@ func (r *Upgrader) sendInitialEvents(ctx context.Context, evTime time.Time, send
 		var verImpl gomatrixserverlib.IRoomVersion
 		verImpl, err = gomatrixserverlib.GetRoomVersion(newVersion)
 		if err != nil {
-			return err
+			return "", err
 		}
 		builder := verImpl.NewEventBuilderFromProtoEvent(&proto)
 		if err = builder.AddAuthEvents(authEvents); err != nil {
-			return err
+			return "", err
 		}
 
 		var event gomatrixserverlib.PDU
 		event, err = builder.Build(evTime, userDomain, r.Cfg.Matrix.KeyID, r.Cfg.Matrix.PrivateKey)
 		if err != nil {
-			return fmt.Errorf("failed to build new %q event: %w", builder.Type, err)
+			return "", fmt.Errorf("failed to build new %q event: %w", builder.Type, err)
 
 		}
 
 		if err = gomatrixserverlib.Allowed(event, authEvents, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 			return r.URSAPI.QueryUserIDForSender(ctx, roomID, senderID)
 		}); err != nil {
-			return fmt.Errorf("Failed to auth new %q event: %w", builder.Type, err)
+			return "", fmt.Errorf("Failed to auth new %q event: %w", builder.Type, err)
+		}
+
+		if i == 0 {
+			newRoomID = event.RoomID().String()
 		}
 
 		// Add the event to the list of auth events
 		builtEvents = append(builtEvents, &types.HeaderedEvent{PDU: event})
 		err = authEvents.AddEvent(event)
 		if err != nil {
-			return fmt.Errorf("failed to add new %q event to auth set: %w", builder.Type, err)
+			return "", fmt.Errorf("failed to add new %q event to auth set: %w", builder.Type, err)
 		}
 	}
 
@@ -541,9 +613,9 @@ func (r *Upgrader) sendInitialEvents(ctx context.Context, evTime time.Time, send
 		})
 	}
 	if err = api.SendInputRoomEvents(ctx, r.URSAPI, userDomain, inputs, false); err != nil {
-		return fmt.Errorf("failed to send new room %q to roomserver: %w", newRoomID, err)
+		return "", fmt.Errorf("failed to send new room %q to roomserver: %w", newRoomID, err)
 	}
-	return nil
+	return newRoomID, nil
 }
 
 func (r *Upgrader) makeTombstoneEvent(
@@ -20,6 +20,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
 
 	//"github.com/jchv/maidtrix/roomserver/internal"
 	"github.com/jchv/maidtrix/internal/matrixserver"
@@ -37,6 +38,7 @@ import (
 	"github.com/jchv/maidtrix/roomserver/acls"
 	"github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/roomserver/internal/helpers"
+	"github.com/jchv/maidtrix/roomserver/policylists"
 	"github.com/jchv/maidtrix/roomserver/state"
 	"github.com/jchv/maidtrix/roomserver/storage"
 	"github.com/jchv/maidtrix/roomserver/types"
@@ -47,6 +49,7 @@ type Queryer struct {
 	Cache             caching.RoomServerCaches
 	IsLocalServerName func(spec.ServerName) bool
 	ServerACLs        *acls.ServerACLs
+	PolicyLists       *policylists.PolicyLists
 	Cfg               *config.Dendrite
 	FSAPI             fsAPI.RoomserverFederationAPI
 }
@@ -812,6 +815,17 @@ func (r *Queryer) QueryRoomVersionForRoom(ctx context.Context, roomID string) (g
 	return info.RoomVersion, nil
 }
 
+// IsRoomPartialState returns true if the room was joined with partial state
+// and the full state of the room hasn't been fetched yet.
+func (r *Queryer) IsRoomPartialState(ctx context.Context, roomID spec.RoomID) (bool, error) {
+	info, err := r.DB.RoomInfo(ctx, roomID.String())
+	if err != nil || info == nil {
+		return false, err
+	}
+	joinEventID, _, err := r.DB.PartialStateRoom(ctx, info.RoomNID)
+	return joinEventID != "", err
+}
+
 func (r *Queryer) QueryPublishedRooms(
 	ctx context.Context,
 	req *api.QueryPublishedRoomsRequest,
@@ -829,7 +843,17 @@ func (r *Queryer) QueryPublishedRooms(
 	if err != nil {
 		return err
 	}
-	res.RoomIDs = rooms
+	res.RoomIDs = make([]string, 0, len(rooms))
+	res.RoomTypes = make(map[string]string)
+	for _, room := range rooms {
+		if len(req.RoomTypes) > 0 && !slices.Contains(req.RoomTypes, room.RoomType) {
+			continue
+		}
+		res.RoomIDs = append(res.RoomIDs, room.RoomID)
+		if room.RoomType != "" {
+			res.RoomTypes[room.RoomID] = room.RoomType
+		}
+	}
 	return nil
 }
 
@@ -958,6 +982,18 @@ func (r *Queryer) QueryServerBannedFromRoom(ctx context.Context, req *api.QueryS
 	return nil
 }
 
+func (r *Queryer) IsBannedByPolicyList(ctx context.Context, roomID string, userID spec.UserID, membership string) bool {
+	rule := r.PolicyLists.MatchRoom(roomID)
+	if rule == nil {
+		rule = r.PolicyLists.MatchUser(userID)
+	}
+	if rule == nil {
+		return false
+	}
+	rule.Audit("refuse_"+membership, userID.String(), roomID)
+	return true
+}
+
 func (r *Queryer) QueryAuthChain(ctx context.Context, req *api.QueryAuthChainRequest, res *api.QueryAuthChainResponse) error {
 	chain, err := GetAuthChain(ctx, r.DB.EventsFromIDs, nil, req.EventIDs)
 	if err != nil {
@@ -1105,6 +1141,11 @@ func (r *Queryer) RoomsWithACLs(ctx context.Context) ([]string, error) {
 	return r.DB.RoomsWithACLs(ctx)
 }
 
+// QueryRoomStatistics returns aggregate counts of the rooms that we know about.
+func (r *Queryer) QueryRoomStatistics(ctx context.Context) (*types.RoomStatistics, error) {
+	return r.DB.RoomStatistics(ctx)
+}
+
 // QueryAdminEventReports returns event reports given a filter.
 func (r *Queryer) QueryAdminEventReports(ctx context.Context, from uint64, limit uint64, backwards bool, userID, roomID string) ([]api.QueryAdminEventReportsResponse, int64, error) {
 	return r.DB.QueryAdminEventReports(ctx, from, limit, backwards, userID, roomID)
@@ -0,0 +1,307 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/eventutil"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/state"
+	"github.com/jchv/maidtrix/roomserver/types"
+)
+
+// QueryAdminForwardExtremities returns the forward extremities of the room,
+// newest first, along with how far the state after each of them has drifted
+// from the current state of the room.
+func (r *Queryer) QueryAdminForwardExtremities(ctx context.Context, roomID string) ([]api.AdminForwardExtremity, error) {
+	roomInfo, err := r.adminRoomInfo(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	latestEventIDs, _, _, err := r.DB.LatestEventIDs(ctx, roomInfo.RoomNID)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.LatestEventIDs: %w", err)
+	}
+	events, err := r.DB.EventsFromIDs(ctx, roomInfo, latestEventIDs)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.EventsFromIDs: %w", err)
+	}
+
+	roomState := state.NewStateResolution(r.DB, roomInfo, r)
+	currentState, err := roomState.LoadStateAtSnapshot(ctx, roomInfo.StateSnapshotNID())
+	if err != nil {
+		return nil, fmt.Errorf("roomState.LoadStateAtSnapshot: %w", err)
+	}
+
+	now := time.Now()
+	extremities := make([]api.AdminForwardExtremity, 0, len(events))
+	for _, event := range events {
+		stateAfter, err := r.stateAfterEvent(ctx, &roomState, event.EventID())
+		if err != nil {
+			return nil, err
+		}
+		added, removed := diffStateEntries(currentState, stateAfter)
+		extremities = append(extremities, api.AdminForwardExtremity{
+			EventID:          event.EventID(),
+			Type:             event.Type(),
+			Sender:           r.adminSender(ctx, event.RoomID(), event.SenderID()),
+			Depth:            event.Depth(),
+			OriginServerTS:   event.OriginServerTS(),
+			AgeMS:            now.Sub(event.OriginServerTS().Time()).Milliseconds(),
+			StateDifferences: len(added) + len(removed),
+		})
+	}
+	sort.SliceStable(extremities, func(i, j int) bool {
+		return extremities[i].Depth > extremities[j].Depth
+	})
+	return extremities, nil
+}
+
+// QueryAdminStateAfterEvent returns the state after the event, along with the
+// entries which were added or removed relative to the current state.
+func (r *Queryer) QueryAdminStateAfterEvent(ctx context.Context, roomID, eventID string) (*api.AdminStateAfterEvent, error) {
+	roomInfo, err := r.adminRoomInfo(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.checkAdminEventsInRoom(ctx, roomInfo, roomID, []string{eventID}); err != nil {
+		return nil, err
+	}
+
+	roomState := state.NewStateResolution(r.DB, roomInfo, r)
+	currentState, err := roomState.LoadStateAtSnapshot(ctx, roomInfo.StateSnapshotNID())
+	if err != nil {
+		return nil, fmt.Errorf("roomState.LoadStateAtSnapshot: %w", err)
+	}
+	stateAfter, err := r.stateAfterEvent(ctx, &roomState, eventID)
+	if err != nil {
+		return nil, err
+	}
+	added, removed := diffStateEntries(currentState, stateAfter)
+
+	entries, err := r.adminStateEntries(ctx, roomInfo, stateAfter, added, removed)
+	if err != nil {
+		return nil, err
+	}
+	return &api.AdminStateAfterEvent{
+		EventID: eventID,
+		State:   entries.list(stateAfter),
+		Added:   entries.list(added),
+		Removed: entries.list(removed),
+	}, nil
+}
+
+// QueryAdminResolveState resolves the state after the given events, or the
+// forward extremities of the room if no events are given. The result is not
+// stored, so this is safe to run against any set of events in the room.
+func (r *Queryer) QueryAdminResolveState(ctx context.Context, roomID string, eventIDs []string) (*api.AdminResolvedState, error) {
+	roomInfo, err := r.adminRoomInfo(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if len(eventIDs) == 0 {
+		if eventIDs, _, _, err = r.DB.LatestEventIDs(ctx, roomInfo.RoomNID); err != nil {
+			return nil, fmt.Errorf("r.DB.LatestEventIDs: %w", err)
+		}
+	}
+	if err = r.checkAdminEventsInRoom(ctx, roomInfo, roomID, eventIDs); err != nil {
+		return nil, err
+	}
+
+	prevStates, err := r.DB.StateAtEventIDs(ctx, eventIDs)
+	if err != nil {
+		return nil, adminStateError(err)
+	}
+	roomState := state.NewStateResolution(r.DB, roomInfo, r)
+	resolved, conflicts, err := roomState.ResolveStateAfterEvents(ctx, prevStates)
+	if err != nil {
+		return nil, fmt.Errorf("roomState.ResolveStateAfterEvents: %w", err)
+	}
+
+	entries, err := r.adminStateEntries(ctx, roomInfo, resolved, conflicts)
+	if err != nil {
+		return nil, err
+	}
+	winners := make(map[types.StateKeyTuple]types.EventNID, len(resolved))
+	for _, entry := range resolved {
+		winners[entry.StateKeyTuple] = entry.EventNID
+	}
+	res := &api.AdminResolvedState{
+		EventIDs:  eventIDs,
+		State:     entries.list(resolved),
+		Conflicts: []api.AdminStateConflict{},
+	}
+	// The conflicts are sorted by state key, so each conflicted state key
+	// forms a run of entries.
+	for i := 0; i < len(conflicts); {
+		j := i
+		conflict := api.AdminStateConflict{}
+		for ; j < len(conflicts) && conflicts[j].StateKeyTuple == conflicts[i].StateKeyTuple; j++ {
+			entry := entries[conflicts[j].EventNID]
+			conflict.Type, conflict.StateKey = entry.Type, entry.StateKey
+			conflict.Candidates = append(conflict.Candidates, entry.EventID)
+		}
+		if winner, ok := winners[conflicts[i].StateKeyTuple]; ok {
+			conflict.Winner = entries[winner].EventID
+		}
+		res.Conflicts = append(res.Conflicts, conflict)
+		i = j
+	}
+	return res, nil
+}
+
+func (r *Queryer) adminRoomInfo(ctx context.Context, roomID string) (*types.RoomInfo, error) {
+	if _, err := spec.NewRoomID(roomID); err != nil {
+		return nil, api.ErrInvalidID{Err: err}
+	}
+	roomInfo, err := r.DB.RoomInfo(ctx, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.RoomInfo: %w", err)
+	}
+	if roomInfo == nil || roomInfo.IsStub() {
+		return nil, eventutil.ErrRoomNoExists{}
+	}
+	return roomInfo, nil
+}
+
+// checkAdminEventsInRoom makes sure that we have all of the events and that
+// they belong to the room, so that we don't resolve state across rooms.
+func (r *Queryer) checkAdminEventsInRoom(ctx context.Context, roomInfo *types.RoomInfo, roomID string, eventIDs []string) error {
+	events, err := r.DB.EventsFromIDs(ctx, roomInfo, eventIDs)
+	if err != nil {
+		return fmt.Errorf("r.DB.EventsFromIDs: %w", err)
+	}
+	found := make(map[string]struct{}, len(events))
+	for _, event := range events {
+		if event.RoomID().String() != roomID {
+			return api.ErrInvalidID{Err: fmt.Errorf("event %s is not in room %s", event.EventID(), roomID)}
+		}
+		found[event.EventID()] = struct{}{}
+	}
+	for _, eventID := range eventIDs {
+		if _, ok := found[eventID]; !ok {
+			return api.ErrInvalidID{Err: fmt.Errorf("unknown event %s", eventID)}
+		}
+	}
+	return nil
+}
+
+func (r *Queryer) stateAfterEvent(ctx context.Context, roomState *state.StateResolution, eventID string) ([]types.StateEntry, error) {
+	stateAt, err := r.DB.StateAtEventIDs(ctx, []string{eventID})
+	if err != nil {
+		return nil, adminStateError(err)
+	}
+	stateAfter, err := roomState.LoadCombinedStateAfterEvents(ctx, stateAt)
+	if err != nil {
+		return nil, fmt.Errorf("roomState.LoadCombinedStateAfterEvents: %w", err)
+	}
+	return stateAfter, nil
+}
+
+// adminStateError reports events which we don't have the state for, such as
+// outliers, as a bad request rather than as an internal error.
+func adminStateError(err error) error {
+	var missingEvent types.MissingEventError
+	var missingState types.MissingStateError
+	if errors.As(err, &missingEvent) || errors.As(err, &missingState) {
+		return api.ErrInvalidID{Err: err}
+	}
+	return fmt.Errorf("r.DB.StateAtEventIDs: %w", err)
+}
+
+// adminSender returns the user ID of the sender if it is known, or the
+// sender ID otherwise.
+func (r *Queryer) adminSender(ctx context.Context, roomID spec.RoomID, senderID spec.SenderID) string {
+	userID, err := r.QueryUserIDForSender(ctx, roomID, senderID)
+	if err != nil || userID == nil {
+		return string(senderID)
+	}
+	return userID.String()
+}
+
+type adminStateEntries map[types.EventNID]api.AdminStateEntry
+
+func (e adminStateEntries) list(stateEntries []types.StateEntry) []api.AdminStateEntry {
+	list := make([]api.AdminStateEntry, 0, len(stateEntries))
+	for _, stateEntry := range stateEntries {
+		if entry, ok := e[stateEntry.EventNID]; ok {
+			list = append(list, entry)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Type != list[j].Type {
+			return list[i].Type < list[j].Type
+		}
+		return list[i].StateKey < list[j].StateKey
+	})
+	return list
+}
+
+// adminStateEntries looks up the type, state key and event ID of all of the
+// given state entries.
+func (r *Queryer) adminStateEntries(ctx context.Context, roomInfo *types.RoomInfo, stateEntries ...[]types.StateEntry) (adminStateEntries, error) {
+	var eventNIDs []types.EventNID
+	for _, list := range stateEntries {
+		for _, entry := range list {
+			eventNIDs = append(eventNIDs, entry.EventNID)
+		}
+	}
+	events, err := r.DB.Events(ctx, roomInfo.RoomVersion, eventNIDs)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.Events: %w", err)
+	}
+	entries := make(adminStateEntries, len(events))
+	for _, event := range events {
+		if event.StateKey() == nil {
+			continue
+		}
+		entries[event.EventNID] = api.AdminStateEntry{
+			Type:     event.Type(),
+			StateKey: *event.StateKey(),
+			EventID:  event.EventID(),
+		}
+	}
+	return entries, nil
+}
+
+// diffStateEntries returns the entries of the other state which aren't in
+// the current state, and the entries of the current state which aren't in
+// the other state. A state key which points at a different event in each
+// appears in both.
+func diffStateEntries(current, other []types.StateEntry) (added, removed []types.StateEntry) {
+	currentMap := make(map[types.StateKeyTuple]types.EventNID, len(current))
+	for _, entry := range current {
+		currentMap[entry.StateKeyTuple] = entry.EventNID
+	}
+	otherMap := make(map[types.StateKeyTuple]types.EventNID, len(other))
+	for _, entry := range other {
+		otherMap[entry.StateKeyTuple] = entry.EventNID
+		if eventNID, ok := currentMap[entry.StateKeyTuple]; !ok || eventNID != entry.EventNID {
+			added = append(added, entry)
+		}
+	}
+	for _, entry := range current {
+		if eventNID, ok := otherMap[entry.StateKeyTuple]; !ok || eventNID != entry.EventNID {
+			removed = append(removed, entry)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,145 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	roomserver "github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/tidwall/gjson"
+)
+
+// QueryRoomSummary returns a summary of the room (MSC3266). The same visibility
+// rules as the room hierarchy apply, except that rooms which can be joined or
+// knocked on are always visible. If this server isn't joined to the room, the
+// summary is fetched from the /hierarchy endpoint of the given servers.
+func (querier *Queryer) QueryRoomSummary(ctx context.Context, caller types.DeviceOrServerName, roomID spec.RoomID, vias []string) (*roomserver.RoomSummary, error) {
+	if !roomExists(ctx, querier, roomID) {
+		return federatedRoomSummary(ctx, querier, caller, roomID, vias)
+	}
+	if !summaryAuthorised(ctx, querier, caller, roomID) {
+		return nil, roomserver.ErrRoomUnknownOrNotAllowed{Err: fmt.Errorf("room is unknown/forbidden")}
+	}
+
+	pubRoom := publicRoomsChunk(ctx, querier, roomID)
+	if pubRoom == nil {
+		return nil, fmt.Errorf("unable to get public room information for %s", roomID)
+	}
+	summary := &roomserver.RoomSummary{
+		PublicRoom: *pubRoom,
+	}
+
+	if create := stateEvent(ctx, querier, roomID, spec.MRoomCreate, ""); create != nil {
+		var createContent gomatrixserverlib.CreateContent
+		if err := json.Unmarshal(create.Content(), &createContent); err != nil {
+			util.GetLogger(ctx).WithError(err).WithField("create_content", create.Content()).Warn("failed to unmarshal m.room.create event")
+		}
+		summary.RoomType = createContent.RoomType
+	}
+	if encryption := stateEvent(ctx, querier, roomID, spec.MRoomEncryption, ""); encryption != nil {
+		summary.Encryption = gjson.GetBytes(encryption.Content(), "algorithm").Str
+	}
+	if joinRuleEv := stateEvent(ctx, querier, roomID, spec.MRoomJoinRules, ""); joinRuleEv != nil {
+		for _, allowed := range restrictedJoinRuleAllowedRooms(ctx, joinRuleEv) {
+			summary.AllowedRoomIDs = append(summary.AllowedRoomIDs, allowed.String())
+		}
+	}
+	if device := caller.Device(); device != nil {
+		if memberEv := stateEvent(ctx, querier, roomID, spec.MRoomMember, device.UserID); memberEv != nil {
+			summary.Membership, _ = memberEv.Membership()
+		}
+	}
+
+	roomVersion, err := querier.QueryRoomVersionForRoom(ctx, roomID.String())
+	if err != nil {
+		return nil, err
+	}
+	summary.RoomVersion = roomVersion
+	return summary, nil
+}
+
+// summaryAuthorised returns true if the caller may see the summary of the room, which
+// is when the hierarchy rules allow it, or when the caller could join or knock on the
+// room without an invite.
+func summaryAuthorised(ctx context.Context, querier *Queryer, caller types.DeviceOrServerName, roomID spec.RoomID) bool {
+	if authed, _, _ := authorised(ctx, querier, caller, roomID, nil); authed {
+		return true
+	}
+	joinRuleEv := stateEvent(ctx, querier, roomID, spec.MRoomJoinRules, "")
+	if joinRuleEv == nil {
+		return false
+	}
+	rule, err := joinRuleEv.JoinRule()
+	if err != nil {
+		util.GetLogger(ctx).WithError(err).WithField("room_id", roomID).Warn("failed to get join rule")
+		return false
+	}
+	switch rule {
+	case spec.Public, spec.Knock, spec.KnockRestricted:
+		return true
+	case spec.Restricted:
+		// The caller can see the room if they are joined to any of the rooms
+		// that allow joining it.
+		for _, allowedRoomID := range restrictedJoinRuleAllowedRooms(ctx, joinRuleEv) {
+			if authed, _, _ := authorised(ctx, querier, caller, roomID, &allowedRoomID); authed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// federatedRoomSummary returns the summary of a room which this server isn't joined
+// to, using the room from the /hierarchy response of the first server that answers.
+func federatedRoomSummary(ctx context.Context, querier *Queryer, caller types.DeviceOrServerName, roomID spec.RoomID, vias []string) (*roomserver.RoomSummary, error) {
+	// only do federated requests for client requests
+	if caller.Device() == nil {
+		return nil, roomserver.ErrRoomUnknownOrNotAllowed{Err: fmt.Errorf("room is unknown/forbidden")}
+	}
+	room, ok := querier.Cache.GetRoomSummary(roomID.String())
+	if !ok {
+		var found bool
+		for _, serverName := range vias {
+			if querier.Cfg.Global.IsLocalServerName(spec.ServerName(serverName)) {
+				continue
+			}
+			res, err := querier.FSAPI.RoomHierarchies(ctx, querier.Cfg.Global.ServerName, spec.ServerName(serverName), roomID.String(), false)
+			if err != nil {
+				util.GetLogger(ctx).WithError(err).Warnf("failed to call RoomHierarchies on server %s", serverName)
+				continue
+			}
+			room, found = res.Room, true
+			break
+		}
+		if !found {
+			return nil, roomserver.ErrRoomUnknownOrNotAllowed{Err: fmt.Errorf("room is unknown/forbidden")}
+		}
+		querier.Cache.StoreRoomSummary(roomID.String(), room)
+	}
+
+	summary := &roomserver.RoomSummary{
+		PublicRoom:     room.PublicRoom,
+		AllowedRoomIDs: room.AllowedRoomIDs,
+	}
+	summary.RoomID = roomID.String()
+	summary.RoomType = room.RoomType
+	return summary, nil
+}
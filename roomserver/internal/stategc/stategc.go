@@ -0,0 +1,306 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stategc deletes state snapshots which are no longer referenced by
+// any event or room, along with the state blocks that only they used.
+package stategc
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/state"
+	"github.com/jchv/maidtrix/roomserver/storage"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/setup/process"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How many rooms to fetch from the database at a time.
+	roomsPerQuery = 100
+	// How many locks the rooms are spread over. Collecting a room only holds
+	// up event input for the other rooms which share its lock.
+	lockStripes = 64
+	// How long to wait before trying to lock a room again.
+	lockRetryInterval = 10 * time.Millisecond
+)
+
+// Collector works through the rooms, deleting unreferenced state snapshots
+// and state blocks a few at a time.
+type Collector struct {
+	DB             storage.Database
+	Cfg            *config.StateGC
+	ProcessContext *process.ProcessContext
+
+	locks    [lockStripes]sync.RWMutex
+	trigger  chan struct{}
+	mu       sync.Mutex
+	progress api.StateGCProgress
+}
+
+func NewCollector(processContext *process.ProcessContext, cfg *config.StateGC, db storage.Database) *Collector {
+	return &Collector{
+		DB:             db,
+		Cfg:            cfg,
+		ProcessContext: processContext,
+		trigger:        make(chan struct{}, 1),
+		progress: api.StateGCProgress{
+			Enabled: cfg.Enabled,
+		},
+	}
+}
+
+// Hold stops the collector from deleting any of the room's state snapshots
+// until the returned function is called. Anything which stores a new state
+// snapshot and then refers to it must hold the room, otherwise the snapshot
+// could be deleted in between.
+func (c *Collector) Hold(roomID string) (release func()) {
+	if c == nil {
+		return func() {}
+	}
+	lock := c.lockFor(roomID)
+	lock.RLock()
+	return lock.RUnlock
+}
+
+func (c *Collector) lockFor(roomID string) *sync.RWMutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(roomID))
+	return &c.locks[h.Sum32()%lockStripes]
+}
+
+// lock takes the room's lock for writing. TryLock is used rather than Lock,
+// as a pending Lock would block any new holders of the lock, which would
+// deadlock if an existing holder were waiting on one of them.
+func (c *Collector) lock(ctx context.Context, roomID string) (*sync.RWMutex, error) {
+	lock := c.lockFor(roomID)
+	for !lock.TryLock() {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+	return lock, nil
+}
+
+// Start runs the collector in the background. If it isn't enabled in the
+// config then it only runs when triggered.
+func (c *Collector) Start() {
+	go func() {
+		var ticker <-chan time.Time
+		if c.Cfg.Enabled {
+			t := time.NewTicker(c.Cfg.Interval)
+			defer t.Stop()
+			ticker = t.C
+		}
+		for {
+			select {
+			case <-c.ProcessContext.Context().Done():
+				return
+			case <-ticker:
+			case <-c.trigger:
+			}
+			c.run(c.ProcessContext.Context())
+		}
+	}()
+}
+
+// Trigger starts a pass over the rooms, unless one is already in progress.
+func (c *Collector) Trigger() {
+	if c.Progress().Running {
+		return
+	}
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Progress returns the progress of the current or last pass.
+func (c *Collector) Progress() api.StateGCProgress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.progress
+}
+
+func (c *Collector) updateProgress(f func(p *api.StateGCProgress)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&c.progress)
+}
+
+func (c *Collector) run(ctx context.Context) {
+	c.updateProgress(func(p *api.StateGCProgress) {
+		*p = api.StateGCProgress{
+			Enabled:     p.Enabled,
+			Running:     true,
+			Passes:      p.Passes,
+			LastStarted: spec.AsTimestamp(time.Now()),
+		}
+	})
+	logrus.Info("Starting state garbage collection")
+
+	err := c.collect(ctx)
+
+	c.updateProgress(func(p *api.StateGCProgress) {
+		p.Running = false
+		p.Passes++
+		p.LastFinished = spec.AsTimestamp(time.Now())
+		if err != nil {
+			p.LastError = err.Error()
+		}
+	})
+	progress := c.Progress()
+	logger := logrus.WithFields(logrus.Fields{
+		"rooms":             progress.RoomsProcessed,
+		"snapshots_merged":  progress.SnapshotsMerged,
+		"snapshots_deleted": progress.SnapshotsDeleted,
+		"blocks_deleted":    progress.BlocksDeleted,
+	})
+	if err != nil {
+		logger.WithError(err).Error("State garbage collection failed")
+		return
+	}
+	logger.Info("Finished state garbage collection")
+}
+
+func (c *Collector) collect(ctx context.Context) error {
+	var afterRoomNID types.RoomNID
+	for {
+		roomNIDs, roomIDs, err := c.DB.StateGCRooms(ctx, afterRoomNID, roomsPerQuery)
+		if err != nil {
+			return fmt.Errorf("c.DB.StateGCRooms: %w", err)
+		}
+		if len(roomNIDs) == 0 {
+			return nil
+		}
+		for i, roomNID := range roomNIDs {
+			if err = c.collectRoom(ctx, roomNID, roomIDs[i]); err != nil {
+				return fmt.Errorf("room %s: %w", roomIDs[i], err)
+			}
+			c.updateProgress(func(p *api.StateGCProgress) {
+				p.RoomsProcessed++
+			})
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.Cfg.Throttle):
+			}
+		}
+		afterRoomNID = roomNIDs[len(roomNIDs)-1]
+	}
+}
+
+// collectRoom deletes the room's unreferenced state snapshots in batches,
+// releasing the room in between so that event input can continue.
+func (c *Collector) collectRoom(ctx context.Context, roomNID types.RoomNID, roomID string) error {
+	if c.Cfg.DedupeThreshold > 0 {
+		if err := c.dedupeRoom(ctx, roomNID, roomID); err != nil {
+			return err
+		}
+	}
+	for {
+		lock, err := c.lock(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		snapshots, blocks, err := c.DB.CollectStateSnapshots(ctx, roomNID, c.Cfg.BatchSize)
+		lock.Unlock()
+		if err != nil {
+			return fmt.Errorf("c.DB.CollectStateSnapshots: %w", err)
+		}
+		c.updateProgress(func(p *api.StateGCProgress) {
+			p.SnapshotsDeleted += snapshots
+			p.BlocksDeleted += blocks
+		})
+		if snapshots < int64(c.Cfg.BatchSize) {
+			return nil
+		}
+	}
+}
+
+// dedupeRoom merges together the room's state snapshots which contain the
+// same state but were built from different state blocks, so that the
+// duplicates can be collected. Snapshots never change once stored, so the
+// state is compared without holding the room.
+func (c *Collector) dedupeRoom(ctx context.Context, roomNID types.RoomNID, roomID string) error {
+	snapshots, err := c.DB.StateSnapshotsForRoom(ctx, roomNID)
+	if err != nil {
+		return fmt.Errorf("c.DB.StateSnapshotsForRoom: %w", err)
+	}
+	if len(snapshots) < c.Cfg.DedupeThreshold {
+		return nil
+	}
+	roomInfo, err := c.DB.RoomInfo(ctx, roomID)
+	if err != nil || roomInfo == nil {
+		return fmt.Errorf("c.DB.RoomInfo: %w", err)
+	}
+	roomState := state.NewStateResolution(c.DB, roomInfo, nil)
+
+	// Look at the current state first, so that events are merged into it
+	// rather than the other way around.
+	currentStateNID := roomInfo.StateSnapshotNID()
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].StateSnapshotNID == currentStateNID && snapshots[j].StateSnapshotNID != currentStateNID
+	})
+
+	merge := map[types.StateSnapshotNID]types.StateSnapshotNID{}
+	seen := map[string]types.StateSnapshotNID{}
+	for _, snapshot := range snapshots {
+		if len(snapshot.StateBlockNIDs) == 0 {
+			continue
+		}
+		entries, err := roomState.LoadStateAtSnapshot(ctx, snapshot.StateSnapshotNID)
+		if err != nil {
+			return fmt.Errorf("roomState.LoadStateAtSnapshot: %w", err)
+		}
+		eventNIDs := make(types.EventNIDs, len(entries))
+		for i := range entries {
+			eventNIDs[i] = entries[i].EventNID
+		}
+		sort.Sort(eventNIDs)
+		hash := string(eventNIDs.Hash())
+		if existing, ok := seen[hash]; ok {
+			merge[snapshot.StateSnapshotNID] = existing
+			continue
+		}
+		seen[hash] = snapshot.StateSnapshotNID
+	}
+	if len(merge) == 0 {
+		return nil
+	}
+
+	lock, err := c.lock(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	merged, err := c.DB.MergeStateSnapshots(ctx, roomNID, merge)
+	lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("c.DB.MergeStateSnapshots: %w", err)
+	}
+	c.updateProgress(func(p *api.StateGCProgress) {
+		p.SnapshotsMerged += merged
+	})
+	return nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policylists
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/roomserver/storage/tables"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	MPolicyRuleUser   = "m.policy.rule.user"
+	MPolicyRuleRoom   = "m.policy.rule.room"
+	MPolicyRuleServer = "m.policy.rule.server"
+
+	// RecommendationBan is the only recommendation defined by the spec, and
+	// the only one that we act on.
+	RecommendationBan = "m.ban"
+)
+
+// IsPolicyRule returns true if the event type is one of the policy rule types.
+func IsPolicyRule(eventType string) bool {
+	switch eventType {
+	case MPolicyRuleUser, MPolicyRuleRoom, MPolicyRuleServer:
+		return true
+	}
+	return false
+}
+
+type PolicyListDatabase interface {
+	// GetBulkStateContent returns all state events which match a given room ID and a given state key tuple. Both must be satisfied for a match.
+	// If a tuple has the StateKey of '*' and allowWildcards=true then all state events with the EventType should be returned.
+	GetBulkStateContent(ctx context.Context, roomIDs []string, tuples []gomatrixserverlib.StateKeyTuple, allowWildcards bool) ([]tables.StrippedEvent, error)
+}
+
+// Rule is an m.ban recommendation from one of the watched policy list rooms.
+type Rule struct {
+	PolicyRoomID string
+	Type         string
+	Entity       string
+	Reason       string
+	entity       *regexp.Regexp
+}
+
+// Matches returns true if the glob in the rule matches the given entity.
+func (r *Rule) Matches(entity string) bool {
+	return r.entity.MatchString(entity)
+}
+
+// MatchesUser returns true if the rule bans the user, either by user ID or
+// by server name.
+func (r *Rule) MatchesUser(userID spec.UserID) bool {
+	switch r.Type {
+	case MPolicyRuleUser:
+		return r.Matches(userID.String())
+	case MPolicyRuleServer:
+		return r.Matches(stripPort(userID.Domain()))
+	}
+	return false
+}
+
+// Audit records that the rule was enforced against the target.
+func (r *Rule) Audit(action, target, roomID string) {
+	logrus.WithFields(logrus.Fields{
+		"policy_room_id": r.PolicyRoomID,
+		"policy_type":    r.Type,
+		"policy_entity":  r.Entity,
+		"policy_reason":  r.Reason,
+		"action":         action,
+		"target":         target,
+		"room_id":        roomID,
+	}).Warn("Enforced policy list rule")
+}
+
+type ruleKey struct {
+	roomID   string
+	stateKey string
+}
+
+// PolicyLists holds the m.ban rules from the policy list rooms that the
+// server has been configured to watch. A nil *PolicyLists has no rules.
+type PolicyLists struct {
+	rooms      map[string]struct{}
+	rules      map[string]map[ruleKey]*Rule // event type -> rule
+	rulesMutex sync.RWMutex                 // protects the above
+}
+
+func NewPolicyLists(db PolicyListDatabase, roomIDs []string) *PolicyLists {
+	p := &PolicyLists{
+		rooms: make(map[string]struct{}, len(roomIDs)),
+		rules: map[string]map[ruleKey]*Rule{
+			MPolicyRuleUser:   {},
+			MPolicyRuleRoom:   {},
+			MPolicyRuleServer: {},
+		},
+	}
+	for _, roomID := range roomIDs {
+		p.rooms[roomID] = struct{}{}
+	}
+	if len(roomIDs) > 0 {
+		p.Load(context.TODO(), db, roomIDs...)
+	}
+	return p
+}
+
+// IsPolicyRoom returns true if the room is one of the watched policy lists.
+func (p *PolicyLists) IsPolicyRoom(roomID string) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.rooms[roomID]
+	return ok
+}
+
+// Load reads the current rules from the given policy list rooms, e.g. once
+// the server has joined them.
+func (p *PolicyLists) Load(ctx context.Context, db PolicyListDatabase, roomIDs ...string) {
+	tuples := []gomatrixserverlib.StateKeyTuple{
+		{EventType: MPolicyRuleUser, StateKey: "*"},
+		{EventType: MPolicyRuleRoom, StateKey: "*"},
+		{EventType: MPolicyRuleServer, StateKey: "*"},
+	}
+	events, err := db.GetBulkStateContent(ctx, roomIDs, tuples, true)
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to get policy list rules")
+		return
+	}
+	for _, event := range events {
+		p.OnPolicyRuleUpdate(event)
+	}
+}
+
+// OnPolicyRuleUpdate adds, replaces or removes the rule for the given policy
+// rule state event. The new rule is returned if the event is an m.ban
+// recommendation.
+func (p *PolicyLists) OnPolicyRuleUpdate(strippedEvent tables.StrippedEvent) *Rule {
+	if !p.IsPolicyRoom(strippedEvent.RoomID) || !IsPolicyRule(strippedEvent.EventType) {
+		return nil
+	}
+	key := ruleKey{roomID: strippedEvent.RoomID, stateKey: strippedEvent.StateKey}
+
+	var content struct {
+		Entity         string `json:"entity"`
+		Recommendation string `json:"recommendation"`
+		Reason         string `json:"reason"`
+	}
+	var rule *Rule
+	// Rules are removed by replacing the state event with one that has no
+	// content, so a rule that we can't understand also removes the old one.
+	if err := json.Unmarshal([]byte(strippedEvent.ContentValue), &content); err == nil &&
+		content.Entity != "" && content.Recommendation == RecommendationBan {
+		rule = &Rule{
+			PolicyRoomID: strippedEvent.RoomID,
+			Type:         strippedEvent.EventType,
+			Entity:       content.Entity,
+			Reason:       content.Reason,
+			entity:       compileGlob(content.Entity),
+		}
+	}
+
+	p.rulesMutex.Lock()
+	defer p.rulesMutex.Unlock()
+	if rule == nil {
+		delete(p.rules[strippedEvent.EventType], key)
+	} else {
+		p.rules[strippedEvent.EventType][key] = rule
+	}
+	return rule
+}
+
+// MatchUser returns the first rule that bans the user, either by user ID or
+// by server name, or nil if there isn't one.
+func (p *PolicyLists) MatchUser(userID spec.UserID) *Rule {
+	if rule := p.match(MPolicyRuleUser, userID.String()); rule != nil {
+		return rule
+	}
+	return p.MatchServer(userID.Domain())
+}
+
+// MatchServer returns the first rule that bans the server, or nil if there
+// isn't one.
+func (p *PolicyLists) MatchServer(serverName spec.ServerName) *Rule {
+	return p.match(MPolicyRuleServer, stripPort(serverName))
+}
+
+// stripPort removes the port from the server name since, as with server
+// ACLs, the port isn't part of the server name for policy rules.
+func stripPort(serverName spec.ServerName) string {
+	if host, _, err := net.SplitHostPort(string(serverName)); err == nil {
+		return host
+	}
+	return string(serverName)
+}
+
+// MatchRoom returns the first rule that bans the room, or nil if there isn't
+// one.
+func (p *PolicyLists) MatchRoom(roomID string) *Rule {
+	return p.match(MPolicyRuleRoom, roomID)
+}
+
+func (p *PolicyLists) match(eventType, entity string) *Rule {
+	if p == nil {
+		return nil
+	}
+	p.rulesMutex.RLock()
+	defer p.rulesMutex.RUnlock()
+	for _, rule := range p.rules[eventType] {
+		if rule.Matches(entity) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// compileGlob turns a policy rule entity into a regular expression, where
+// * matches zero or more characters and ? matches exactly one.
+func compileGlob(glob string) *regexp.Regexp {
+	escaped := regexp.QuoteMeta(glob)
+	escaped = strings.ReplaceAll(escaped, "\\?", ".")
+	escaped = strings.ReplaceAll(escaped, "\\*", ".*")
+	return regexp.MustCompile("^" + escaped + "$")
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policylists
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/roomserver/storage/tables"
+	"github.com/stretchr/testify/assert"
+)
+
+const policyRoomID = "!policies:test"
+
+type dummyPolicyDB struct{}
+
+func (d dummyPolicyDB) GetBulkStateContent(ctx context.Context, roomIDs []string, tuples []gomatrixserverlib.StateKeyTuple, allowWildcards bool) ([]tables.StrippedEvent, error) {
+	return []tables.StrippedEvent{
+		{
+			RoomID:       policyRoomID,
+			EventType:    MPolicyRuleUser,
+			StateKey:     "rule1",
+			ContentValue: `{"entity":"@spam*:example.com","recommendation":"m.ban","reason":"spam"}`,
+		},
+		{
+			RoomID:       policyRoomID,
+			EventType:    MPolicyRuleServer,
+			StateKey:     "rule2",
+			ContentValue: `{"entity":"*.evil.com","recommendation":"m.ban","reason":"abuse"}`,
+		},
+		{
+			RoomID:       policyRoomID,
+			EventType:    MPolicyRuleRoom,
+			StateKey:     "rule3",
+			ContentValue: `{"entity":"!bad?:example.com","recommendation":"m.ban"}`,
+		},
+		{
+			// Rules from rooms that we don't watch are ignored.
+			RoomID:       "!other:test",
+			EventType:    MPolicyRuleUser,
+			StateKey:     "rule4",
+			ContentValue: `{"entity":"*","recommendation":"m.ban"}`,
+		},
+	}, nil
+}
+
+func mustUserID(t *testing.T, userID string) spec.UserID {
+	t.Helper()
+	u, err := spec.NewUserID(userID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *u
+}
+
+func TestPolicyListMatching(t *testing.T) {
+	p := NewPolicyLists(dummyPolicyDB{}, []string{policyRoomID})
+
+	rule := p.MatchUser(mustUserID(t, "@spammer:example.com"))
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, "spam", rule.Reason)
+	}
+	assert.Nil(t, p.MatchUser(mustUserID(t, "@alice:example.com")))
+	assert.Nil(t, p.MatchUser(mustUserID(t, "@xspammer:example.com")))
+
+	// Users are also matched by their server name.
+	rule = p.MatchUser(mustUserID(t, "@alice:matrix.evil.com"))
+	if assert.NotNil(t, rule) {
+		assert.Equal(t, MPolicyRuleServer, rule.Type)
+	}
+	assert.NotNil(t, p.MatchServer("matrix.evil.com:8448"))
+	assert.Nil(t, p.MatchServer("evil.com"))
+
+	assert.NotNil(t, p.MatchRoom("!bad1:example.com"))
+	assert.Nil(t, p.MatchRoom("!bad12:example.com"))
+}
+
+func TestPolicyListUpdates(t *testing.T) {
+	p := NewPolicyLists(dummyPolicyDB{}, []string{policyRoomID})
+	bob := mustUserID(t, "@bob:example.com")
+
+	rule := p.OnPolicyRuleUpdate(tables.StrippedEvent{
+		RoomID:       policyRoomID,
+		EventType:    MPolicyRuleUser,
+		StateKey:     "bob",
+		ContentValue: `{"entity":"@bob:example.com","recommendation":"m.ban","reason":"bob"}`,
+	})
+	assert.NotNil(t, rule)
+	assert.Equal(t, rule, p.MatchUser(bob))
+
+	// Recommendations other than m.ban are ignored.
+	rule = p.OnPolicyRuleUpdate(tables.StrippedEvent{
+		RoomID:       policyRoomID,
+		EventType:    MPolicyRuleUser,
+		StateKey:     "bob",
+		ContentValue: `{"entity":"@bob:example.com","recommendation":"org.example.mute"}`,
+	})
+	assert.Nil(t, rule)
+	assert.Nil(t, p.MatchUser(bob))
+
+	// Removing the rule by sending empty content unbans the user.
+	p.OnPolicyRuleUpdate(tables.StrippedEvent{
+		RoomID:       policyRoomID,
+		EventType:    MPolicyRuleUser,
+		StateKey:     "rule1",
+		ContentValue: `{}`,
+	})
+	assert.Nil(t, p.MatchUser(mustUserID(t, "@spammer:example.com")))
+}
+
+func TestNilPolicyLists(t *testing.T) {
+	var p *PolicyLists
+	assert.False(t, p.IsPolicyRoom(policyRoomID))
+	assert.Nil(t, p.MatchUser(mustUserID(t, "@bob:example.com")))
+	assert.Nil(t, p.MatchRoom("!room:example.com"))
+}
@@ -15,6 +15,7 @@
 package producers
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/jchv/maidtrix/roomserver/storage/tables"
@@ -39,12 +40,13 @@ type RoomEventProducer struct {
 	JetStream nats.JetStreamContext
 }
 
-func (r *RoomEventProducer) ProduceRoomEvents(roomID string, updates []api.OutputEvent) error {
+func (r *RoomEventProducer) ProduceRoomEvents(ctx context.Context, roomID string, updates []api.OutputEvent) error {
 	var err error
 	for _, update := range updates {
 		msg := nats.NewMsg(r.Topic)
 		msg.Header.Set(jetstream.RoomEventType, string(update.Type))
 		msg.Header.Set(jetstream.RoomID, roomID)
+		jetstream.InjectTraceContext(ctx, msg)
 		msg.Data, err = json.Marshal(update)
 		if err != nil {
 			return err
@@ -86,7 +88,7 @@ func (r *RoomEventProducer) ProduceRoomEvents(roomID string, updates []api.Outpu
 			}
 		}
 		logger.Tracef("Producing to topic '%s'", r.Topic)
-		if _, err := r.JetStream.PublishMsg(msg); err != nil {
+		if _, err := r.JetStream.PublishMsg(msg, nats.Context(ctx)); err != nil {
 			logger.WithError(err).Errorf("Failed to produce to topic '%s': %s", r.Topic, err)
 			return err
 		}
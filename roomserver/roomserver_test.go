@@ -7,11 +7,13 @@ import (
 	"testing"
 	"time"
 
+	fsAPI "github.com/jchv/maidtrix/federationapi/api"
 	"github.com/jchv/maidtrix/federationapi/statistics"
 	"github.com/jchv/maidtrix/internal/caching"
 	"github.com/jchv/maidtrix/internal/eventutil"
 	"github.com/jchv/maidtrix/internal/httputil"
 	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/sqlutil"
 	"github.com/jchv/maidtrix/roomserver/internal/input"
@@ -228,6 +230,130 @@ func Test_QueryLeftUsers(t *testing.T) {
 	})
 }
 
+func TestQueryRoomSummary(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	publicRoom := test.NewRoom(t, alice)
+	publicRoom.CreateAndInsert(t, alice, spec.MRoomEncryption, map[string]interface{}{
+		"algorithm": "m.megolm.v1.aes-sha2",
+	}, test.WithStateKey(""))
+	privateRoom := test.NewRoom(t, alice, test.RoomPreset(test.PresetPrivateChat))
+	unknownRoomID, _ := spec.NewRoomID("!unknown:test")
+
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		natsInstance := jetstream.NATSInstance{}
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		// SetFederationAPI starts the room event input consumer
+		rsAPI.SetFederationAPI(nil, nil)
+
+		for _, room := range []*test.Room{publicRoom, privateRoom} {
+			if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+				t.Fatalf("failed to send events: %v", err)
+			}
+		}
+
+		aliceCaller := types.NewDeviceNotServerName(userAPI.Device{UserID: alice.ID})
+		bobCaller := types.NewDeviceNotServerName(userAPI.Device{UserID: bob.ID})
+		publicRoomID, _ := spec.NewRoomID(publicRoom.ID)
+		privateRoomID, _ := spec.NewRoomID(privateRoom.ID)
+
+		// Bob isn't joined, but can see the public room
+		summary, err := rsAPI.QueryRoomSummary(ctx, bobCaller, *publicRoomID, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, publicRoom.ID, summary.RoomID)
+		assert.Equal(t, spec.Public, summary.JoinRule)
+		assert.Equal(t, 1, summary.JoinedMembersCount)
+		assert.Equal(t, "m.megolm.v1.aes-sha2", summary.Encryption)
+		assert.Equal(t, publicRoom.Version, summary.RoomVersion)
+		assert.Equal(t, "", summary.Membership)
+
+		summary, err = rsAPI.QueryRoomSummary(ctx, aliceCaller, *publicRoomID, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, spec.Join, summary.Membership)
+
+		// Only Alice can see the private room
+		_, err = rsAPI.QueryRoomSummary(ctx, bobCaller, *privateRoomID, nil)
+		assert.ErrorAs(t, err, &api.ErrRoomUnknownOrNotAllowed{})
+		summary, err = rsAPI.QueryRoomSummary(ctx, aliceCaller, *privateRoomID, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, spec.Invite, summary.JoinRule)
+
+		// Rooms we don't know about and can't ask anyone about are unknown
+		_, err = rsAPI.QueryRoomSummary(ctx, aliceCaller, *unknownRoomID, nil)
+		assert.ErrorAs(t, err, &api.ErrRoomUnknownOrNotAllowed{})
+	})
+}
+
+func TestPerformAdminMakeRoomAdmin(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	charlie := test.NewUser(t)
+	privateRoom := test.NewRoom(t, alice, test.RoomPreset(test.PresetPrivateChat))
+
+	// Alice leaves the room, leaving only Bob who can't change the power levels.
+	abandonedRoom := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+	abandonedRoom.CreateAndInsert(t, bob, spec.MRoomMember, map[string]interface{}{
+		"membership": spec.Join,
+	}, test.WithStateKey(bob.ID))
+	abandonedRoom.CreateAndInsert(t, alice, spec.MRoomMember, map[string]interface{}{
+		"membership": spec.Leave,
+	}, test.WithStateKey(alice.ID))
+
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		natsInstance := jetstream.NATSInstance{}
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		// SetFederationAPI starts the room event input consumer
+		rsAPI.SetFederationAPI(nil, nil)
+
+		for _, room := range []*test.Room{privateRoom, abandonedRoom} {
+			if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+				t.Fatalf("failed to send events: %v", err)
+			}
+		}
+
+		charlieID, _ := spec.NewUserID(charlie.ID, true)
+		grantedBy, err := rsAPI.PerformAdminMakeRoomAdmin(ctx, privateRoom.ID, *charlieID)
+		assert.NoError(t, err)
+		assert.Equal(t, alice.ID, grantedBy)
+
+		// Charlie was invited and joined, and now has the same power as Alice
+		membershipRes := &api.QueryMembershipForUserResponse{}
+		err = rsAPI.QueryMembershipForUser(ctx, &api.QueryMembershipForUserRequest{
+			RoomID: privateRoom.ID,
+			UserID: *charlieID,
+		}, membershipRes)
+		assert.NoError(t, err)
+		assert.Equal(t, spec.Join, membershipRes.Membership)
+
+		privateRoomID, _ := spec.NewRoomID(privateRoom.ID)
+		plEvent, err := rsAPI.CurrentStateEvent(ctx, *privateRoomID, spec.MRoomPowerLevels, "")
+		assert.NoError(t, err)
+		powerLevels, err := plEvent.PowerLevels()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(100), powerLevels.UserLevel(spec.SenderID(charlie.ID)))
+
+		// Nobody left in the room can grant power
+		_, err = rsAPI.PerformAdminMakeRoomAdmin(ctx, abandonedRoom.ID, *charlieID)
+		assert.ErrorAs(t, err, &api.ErrNotAllowed{})
+
+		// Rooms we don't know about can't be taken over
+		_, err = rsAPI.PerformAdminMakeRoomAdmin(ctx, "!unknown:test", *charlieID)
+		assert.ErrorIs(t, err, eventutil.ErrRoomNoExists{})
+	})
+}
+
 func TestPurgeRoom(t *testing.T) {
 	alice := test.NewUser(t)
 	bob := test.NewUser(t)
@@ -849,6 +975,7 @@ func TestUpgrade(t *testing.T) {
 		roomFunc     func(rsAPI api.RoomserverInternalAPI) string
 		validateFunc func(t *testing.T, oldRoomID, newRoomID string, rsAPI api.RoomserverInternalAPI)
 		wantNewRoom  bool
+		roomVersion  gomatrixserverlib.RoomVersion
 	}{
 		{
 			name:        "invalid roomID",
@@ -1045,6 +1172,51 @@ func TestUpgrade(t *testing.T) {
 			wantNewRoom:  true,
 			validateFunc: validate,
 		},
+		{
+			name:        "successful upgrade to room version with domainless room IDs",
+			upgradeUser: alice.ID,
+			roomVersion: gomatrixserverlib.RoomVersionV12,
+			roomFunc: func(rsAPI api.RoomserverInternalAPI) string {
+				r := test.NewRoom(t, alice)
+				r.CreateAndInsert(t, bob, spec.MRoomMember, map[string]interface{}{"membership": spec.Join}, test.WithStateKey(bob.ID))
+				r.CreateAndInsert(t, alice, spec.MRoomPowerLevels, gomatrixserverlib.PowerLevelContent{
+					Users: map[string]int64{
+						alice.ID: 100,
+						bob.ID:   50,
+					},
+				}, test.WithStateKey(""))
+				if err := api.SendEvents(ctx, rsAPI, api.KindNew, r.Events(), "test", "test", "test", nil, false); err != nil {
+					t.Errorf("failed to send events: %v", err)
+				}
+				return r.ID
+			},
+			wantNewRoom: true,
+			validateFunc: func(t *testing.T, oldRoomID, newRoomID string, rsAPI api.RoomserverInternalAPI) {
+				validate(t, oldRoomID, newRoomID, rsAPI)
+				parsedRoomID, err := spec.NewRoomID(newRoomID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if parsedRoomID.Domain() != "" {
+					t.Fatalf("expected a domainless room ID, got %q", newRoomID)
+				}
+				// The creator can't be listed in the power levels of the new room.
+				ev := api.GetStateEvent(ctx, rsAPI, newRoomID, gomatrixserverlib.StateKeyTuple{EventType: spec.MRoomPowerLevels})
+				if ev == nil {
+					t.Fatalf("new room has no power levels")
+				}
+				pl, err := ev.PowerLevels()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := pl.Users[alice.ID]; ok {
+					t.Fatalf("creator should not be in the power levels of the new room")
+				}
+				if pl.Users[bob.ID] != 50 {
+					t.Fatalf("expected bob to keep power level 50, got %d", pl.Users[bob.ID])
+				}
+			},
+		},
 	}
 
 	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
@@ -1074,7 +1246,10 @@ func TestUpgrade(t *testing.T) {
 				if err != nil {
 					t.Fatalf("upgrade userID is invalid")
 				}
-				newRoomID, err := rsAPI.PerformRoomUpgrade(processCtx.Context(), roomID, *userID, rsAPI.DefaultRoomVersion())
+				if tc.roomVersion == "" {
+					tc.roomVersion = rsAPI.DefaultRoomVersion()
+				}
+				newRoomID, err := rsAPI.PerformRoomUpgrade(processCtx.Context(), roomID, *userID, tc.roomVersion)
 				if err != nil && tc.wantNewRoom {
 					t.Fatal(err)
 				}
@@ -1318,3 +1493,473 @@ func TestRoomsWithACLs(t *testing.T) {
 		assert.Equal(t, []string{aclRoom.ID}, roomsWithACLs)
 	})
 }
+
+func TestStateGC(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	ctx := context.Background()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+		cfg.RoomServer.StateGC.Throttle = 0
+		cfg.RoomServer.StateGC.DedupeThreshold = 1
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+		db, err := storage.Open(processCtx.Context(), cm, &cfg.RoomServer.Database, caches)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		room.CreateAndInsert(t, bob, spec.MRoomMember, map[string]any{"membership": "join"}, test.WithStateKey(bob.ID))
+		lastEvent := room.CreateAndInsert(t, alice, spec.MRoomName, map[string]any{"name": "state gc"}, test.WithStateKey(""))
+		if err = api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		roomInfo, err := db.RoomInfo(ctx, room.ID)
+		if err != nil || roomInfo == nil {
+			t.Fatalf("failed to get room info: %v", err)
+		}
+		roomState := state.NewStateResolution(db, roomInfo, rsAPI)
+		currentState, err := roomState.LoadStateAtSnapshot(ctx, roomInfo.StateSnapshotNID())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A snapshot which nothing refers to should be deleted.
+		unreferenced, err := db.AddState(ctx, roomInfo.RoomNID, nil, currentState[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A snapshot with the same state as the one before the last event, but
+		// made of a single block, should be merged into the original.
+		lastEventSnapshot, err := db.SnapshotNIDFromEventID(ctx, lastEvent.EventID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lastEventState, err := roomState.LoadStateAtSnapshot(ctx, lastEventSnapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Snapshots are deduplicated by their blocks, so the duplicate is made
+		// of a different number of blocks to be sure it is a new snapshot.
+		blocks, err := db.StateBlockNIDs(ctx, []types.StateSnapshotNID{lastEventSnapshot})
+		if err != nil || len(blocks) != 1 {
+			t.Fatalf("failed to get state blocks: %v", err)
+		}
+		var duplicate types.StateSnapshotNID
+		if len(blocks[0].StateBlockNIDs) == 1 {
+			var first types.StateSnapshotNID
+			first, err = db.AddState(ctx, roomInfo.RoomNID, nil, lastEventState[:1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var firstBlocks []types.StateBlockNIDList
+			if firstBlocks, err = db.StateBlockNIDs(ctx, []types.StateSnapshotNID{first}); err != nil || len(firstBlocks) != 1 {
+				t.Fatalf("failed to get state blocks: %v", err)
+			}
+			duplicate, err = db.AddState(ctx, roomInfo.RoomNID, firstBlocks[0].StateBlockNIDs, lastEventState[1:])
+		} else {
+			duplicate, err = db.AddState(ctx, roomInfo.RoomNID, nil, lastEventState)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if duplicate == lastEventSnapshot {
+			t.Fatalf("duplicate snapshot %d is the same as the original", duplicate)
+		}
+		nids, err := db.EventNIDs(ctx, []string{lastEvent.EventID()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.SetState(ctx, nids[lastEvent.EventID()].EventNID, duplicate); err != nil {
+			t.Fatal(err)
+		}
+
+		rsAPI.PerformAdminStateGC(ctx)
+		deadline := time.Now().Add(10 * time.Second)
+		var progress api.StateGCProgress
+		for progress = rsAPI.QueryAdminStateGC(ctx); progress.Passes == 0; progress = rsAPI.QueryAdminStateGC(ctx) {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the state garbage collector")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Empty(t, progress.LastError)
+		assert.EqualValues(t, 1, progress.RoomsProcessed)
+		assert.GreaterOrEqual(t, progress.SnapshotsDeleted, int64(1))
+		assert.EqualValues(t, 1, progress.SnapshotsMerged)
+
+		snapshots, err := db.StateSnapshotsForRoom(ctx, roomInfo.RoomNID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remaining := map[types.StateSnapshotNID]struct{}{}
+		for _, snapshot := range snapshots {
+			remaining[snapshot.StateSnapshotNID] = struct{}{}
+		}
+		assert.NotContains(t, remaining, unreferenced)
+		assert.Contains(t, remaining, roomInfo.StateSnapshotNID())
+		assert.Contains(t, remaining, lastEventSnapshot)
+		assert.NotContains(t, remaining, duplicate)
+
+		// The state at every event, and the current state, must still load.
+		for _, ev := range room.Events() {
+			if _, err = roomState.LoadStateAtEvent(ctx, ev.EventID()); err != nil {
+				t.Fatalf("failed to load state at %s: %v", ev.EventID(), err)
+			}
+		}
+		got, err := roomState.LoadStateAtEvent(ctx, lastEvent.EventID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.ElementsMatch(t, lastEventState, got)
+		if _, err = roomState.LoadStateAtSnapshot(ctx, roomInfo.StateSnapshotNID()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestAdminForwardExtremities(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	ctx := context.Background()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+
+		room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		room.CreateAndInsert(t, bob, spec.MRoomMember, map[string]any{"membership": "join"}, test.WithStateKey(bob.ID))
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		// Nothing to merge while the room has a single forward extremity.
+		eventID, err := rsAPI.PerformAdminMergeForwardExtremities(ctx, room.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, eventID)
+
+		// Fork the room by renaming it twice from the same prev event.
+		forkA := room.CreateEvent(t, alice, spec.MRoomName, map[string]any{"name": "fork a"}, test.WithStateKey(""))
+		forkB := room.CreateEvent(t, alice, spec.MRoomName, map[string]any{"name": "fork b"}, test.WithStateKey(""))
+		if err = api.SendEvents(ctx, rsAPI, api.KindNew, []*types.HeaderedEvent{forkA, forkB}, "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		extremities, err := rsAPI.QueryAdminForwardExtremities(ctx, room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(extremities) != 2 {
+			t.Fatalf("expected 2 forward extremities, got %d", len(extremities))
+		}
+		differences := map[string]int{}
+		for _, extremity := range extremities {
+			assert.Equal(t, spec.MRoomName, extremity.Type)
+			assert.Equal(t, alice.ID, extremity.Sender)
+			differences[extremity.EventID] = extremity.StateDifferences
+		}
+		assert.ElementsMatch(t, []string{forkA.EventID(), forkB.EventID()}, []string{extremities[0].EventID, extremities[1].EventID})
+
+		// One of the forks won, so the state after the other differs from the
+		// current state by its m.room.name.
+		resolved, err := rsAPI.QueryAdminResolveState(ctx, room.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.ElementsMatch(t, []string{forkA.EventID(), forkB.EventID()}, resolved.EventIDs)
+		if len(resolved.Conflicts) != 1 {
+			t.Fatalf("expected 1 conflict, got %+v", resolved.Conflicts)
+		}
+		conflict := resolved.Conflicts[0]
+		assert.Equal(t, spec.MRoomName, conflict.Type)
+		assert.ElementsMatch(t, []string{forkA.EventID(), forkB.EventID()}, conflict.Candidates)
+		winner, loser := forkA, forkB
+		if conflict.Winner == forkB.EventID() {
+			winner, loser = forkB, forkA
+		}
+		assert.Equal(t, winner.EventID(), conflict.Winner)
+		assert.Equal(t, 0, differences[winner.EventID()])
+		assert.Equal(t, 2, differences[loser.EventID()])
+
+		after, err := rsAPI.QueryAdminStateAfterEvent(ctx, room.ID, loser.EventID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []api.AdminStateEntry{{Type: spec.MRoomName, EventID: loser.EventID()}}, after.Added)
+		assert.Equal(t, []api.AdminStateEntry{{Type: spec.MRoomName, EventID: winner.EventID()}}, after.Removed)
+		assert.Contains(t, after.State, api.AdminStateEntry{Type: spec.MRoomName, EventID: loser.EventID()})
+
+		// Events which we don't know about, or which are in other rooms, are rejected.
+		_, err = rsAPI.QueryAdminResolveState(ctx, room.ID, []string{"$unknown:test"})
+		assert.ErrorAs(t, err, &api.ErrInvalidID{})
+		_, err = rsAPI.QueryAdminForwardExtremities(ctx, "!unknown:test")
+		assert.ErrorAs(t, err, &eventutil.ErrRoomNoExists{})
+
+		// Merging sends a dummy event which references both forks.
+		eventID, err = rsAPI.PerformAdminMergeForwardExtremities(ctx, room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NotEmpty(t, eventID)
+		extremities, err = rsAPI.QueryAdminForwardExtremities(ctx, room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, extremities, 1) {
+			assert.Equal(t, eventID, extremities[0].EventID)
+			assert.Equal(t, "org.matrix.dummy_event", extremities[0].Type)
+			assert.Equal(t, 0, extremities[0].StateDifferences)
+		}
+	})
+}
+
+func TestExtremityPruning(t *testing.T) {
+	alice := test.NewUser(t)
+	ctx := context.Background()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+		cfg.RoomServer.ExtremityPruning.Enabled = true
+		cfg.RoomServer.ExtremityPruning.Threshold = 1
+		cfg.RoomServer.ExtremityPruning.MinInterval = time.Hour
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+
+		room := test.NewRoom(t, alice, test.RoomPreset(test.PresetPublicChat))
+		if err := api.SendEvents(ctx, rsAPI, api.KindNew, room.Events(), "test", "test", "test", nil, false); err != nil {
+			t.Fatalf("failed to send events: %v", err)
+		}
+
+		// Fork the room, which takes it over the threshold.
+		fork := func(name string) {
+			forkA := room.CreateEvent(t, alice, spec.MRoomName, map[string]any{"name": name + " a"}, test.WithStateKey(""))
+			forkB := room.CreateEvent(t, alice, spec.MRoomName, map[string]any{"name": name + " b"}, test.WithStateKey(""))
+			room.InsertEvent(t, forkA)
+			if err := api.SendEvents(ctx, rsAPI, api.KindNew, []*types.HeaderedEvent{forkA, forkB}, "test", "test", "test", nil, false); err != nil {
+				t.Fatalf("failed to send events: %v", err)
+			}
+		}
+		fork("first")
+
+		// A dummy event should be sent to merge the extremities.
+		var extremities []api.AdminForwardExtremity
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			var err error
+			if extremities, err = rsAPI.QueryAdminForwardExtremities(ctx, room.ID); err != nil {
+				t.Fatal(err)
+			}
+			if len(extremities) == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for forward extremities to be merged, have %d", len(extremities))
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, "org.matrix.dummy_event", extremities[0].Type)
+		assert.Equal(t, alice.ID, extremities[0].Sender)
+
+		// The room was sent a dummy event too recently for another one, so
+		// forking it again should leave the extremities alone.
+		res := &api.QueryEventsByIDResponse{}
+		if err := rsAPI.QueryEventsByID(ctx, &api.QueryEventsByIDRequest{
+			RoomID:   room.ID,
+			EventIDs: []string{extremities[0].EventID},
+		}, res); err != nil || len(res.Events) != 1 {
+			t.Fatalf("failed to get dummy event: %v", err)
+		}
+		room.InsertEvent(t, res.Events[0])
+		fork("second")
+		time.Sleep(100 * time.Millisecond)
+		extremities, err := rsAPI.QueryAdminForwardExtremities(ctx, room.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, extremities, 2)
+	})
+}
+
+// partialStateFederationAPI answers the /state_ids request of a partial state
+// resync once it is released.
+type partialStateFederationAPI struct {
+	fsAPI.RoomserverFederationAPI
+	release  chan struct{}
+	stateIDs fclient.RespStateIDs
+}
+
+func (f *partialStateFederationAPI) LookupStateIDs(
+	ctx context.Context, origin, s spec.ServerName, roomID, eventID string,
+) (gomatrixserverlib.StateIDResponse, error) {
+	select {
+	case <-f.release:
+		return f.stateIDs, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *partialStateFederationAPI) QueryJoinedHostServerNamesInRoom(
+	ctx context.Context, req *fsAPI.QueryJoinedHostServerNamesInRoomRequest, res *fsAPI.QueryJoinedHostServerNamesInRoomResponse,
+) error {
+	res.ServerNames = []spec.ServerName{"remote"}
+	return nil
+}
+
+func TestPartialStateResyncRejectsForbiddenEvents(t *testing.T) {
+	alice := test.NewUser(t)
+	_, sk, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	bob := test.NewUser(t, test.WithSigningServer("remote", "ed25519:remote", sk))
+	charlie := test.NewUser(t, test.WithSigningServer("remote", "ed25519:remote", sk))
+
+	// Charlie is banned before we join, but the partial state that we get
+	// when joining doesn't include the memberships of anyone but the creator.
+	room := test.NewRoom(t, bob)
+	partialState := append([]*types.HeaderedEvent{}, room.Events()...)
+	charlieJoin := room.CreateAndInsert(t, charlie, spec.MRoomMember, map[string]string{"membership": spec.Join}, test.WithStateKey(charlie.ID))
+	charlieBan := room.CreateAndInsert(t, bob, spec.MRoomMember, map[string]string{"membership": spec.Ban}, test.WithStateKey(charlie.ID))
+	fullStateIDs := make([]string, 0, len(room.CurrentState()))
+	for _, event := range room.CurrentState() {
+		fullStateIDs = append(fullStateIDs, event.EventID())
+	}
+	aliceJoin := room.CreateAndInsert(t, alice, spec.MRoomMember, map[string]string{"membership": spec.Join}, test.WithStateKey(alice.ID))
+
+	// Charlie's server sends a message which cites charlie's join instead of
+	// the ban as its auth event.
+	authEventIDs := []string{charlieJoin.EventID()}
+	for _, event := range partialState {
+		if event.Type() == spec.MRoomCreate || event.Type() == spec.MRoomPowerLevels {
+			authEventIDs = append(authEventIDs, event.EventID())
+		}
+	}
+	builder := gomatrixserverlib.MustGetRoomVersion(room.Version).NewEventBuilderFromProtoEvent(&gomatrixserverlib.ProtoEvent{
+		SenderID:   charlie.ID,
+		RoomID:     room.ID,
+		Type:       "m.room.message",
+		Depth:      aliceJoin.Depth() + 1,
+		PrevEvents: []string{aliceJoin.EventID()},
+		AuthEvents: authEventIDs,
+	})
+	assert.NoError(t, builder.SetContent(map[string]string{"msgtype": "m.text", "body": "hello"}))
+	forbidden, err := builder.Build(time.Now(), "remote", "ed25519:remote", sk)
+	assert.NoError(t, err)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, closeDB := testrig.CreateConfig(t, dbType)
+		defer closeDB()
+		ctx := processCtx.Context()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		db, err := storage.Open(ctx, cm, &cfg.RoomServer.Database, caches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, &natsInstance, caches, caching.DisableMetrics)
+		fedAPI := &partialStateFederationAPI{
+			release: make(chan struct{}),
+			stateIDs: fclient.RespStateIDs{
+				StateEventIDs: fullStateIDs,
+				AuthEventIDs:  append(fullStateIDs, charlieJoin.EventID()),
+			},
+		}
+		rsAPI.SetFederationAPI(fedAPI, nil)
+
+		partialStateEvents := make([]gomatrixserverlib.PDU, 0, len(partialState))
+		for _, event := range partialState {
+			partialStateEvents = append(partialStateEvents, event.PDU)
+		}
+		partialStateResponse := &fclient.RespState{
+			StateEvents: gomatrixserverlib.NewEventJSONsFromEvents(partialStateEvents),
+			AuthEvents:  gomatrixserverlib.NewEventJSONsFromEvents(partialStateEvents),
+		}
+		if err = api.SendEventWithPartialState(ctx, rsAPI, "test", partialStateResponse, aliceJoin, "remote", []spec.ServerName{"remote"}, false); err != nil {
+			t.Fatalf("failed to join with partial state: %v", err)
+		}
+		// We know about charlie's join and ban, but only as outliers.
+		if err = api.SendEvents(ctx, rsAPI, api.KindOutlier, []*types.HeaderedEvent{charlieJoin, charlieBan}, "test", "remote", "test", nil, false); err != nil {
+			t.Fatalf("failed to send outliers: %v", err)
+		}
+
+		// The message can only be checked against its auth events while the
+		// room has partial state, so it is accepted.
+		if err = api.SendEvents(ctx, rsAPI, api.KindNew, []*types.HeaderedEvent{{PDU: forbidden}}, "test", "remote", "test", nil, false); err != nil {
+			t.Fatalf("failed to send forbidden event: %v", err)
+		}
+		roomInfo, err := db.RoomInfo(ctx, room.ID)
+		if err != nil || roomInfo == nil {
+			t.Fatalf("failed to get room info: %v", err)
+		}
+		rejected, err := db.IsEventRejected(ctx, roomInfo.RoomNID, forbidden.EventID())
+		assert.NoError(t, err)
+		assert.False(t, rejected)
+
+		// Once the full state is known, the message is rejected and is no
+		// longer a forward extremity.
+		close(fedAPI.release)
+		roomID, err := spec.NewRoomID(room.ID)
+		assert.NoError(t, err)
+		fullState, err := api.WaitForFullState(ctx, rsAPI, *roomID, 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, fullState)
+		rejected, err = db.IsEventRejected(ctx, roomInfo.RoomNID, forbidden.EventID())
+		assert.NoError(t, err)
+		assert.True(t, rejected)
+		extremities, err := rsAPI.QueryAdminForwardExtremities(ctx, room.ID)
+		assert.NoError(t, err)
+		if assert.Len(t, extremities, 1) {
+			assert.Equal(t, aliceJoin.EventID(), extremities[0].EventID)
+		}
+	})
+}
+
+func TestRoomStatisticsDoesNotCreateEventTypes(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, closeDB := testrig.CreateConfig(t, dbType)
+		defer closeDB()
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		caches := caching.NewRistrettoCache(128*1024*1024, time.Hour, caching.DisableMetrics)
+		db, err := storage.Open(processCtx.Context(), cm, &cfg.RoomServer.Database, caches)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := db.RoomStatistics(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.EncryptedRooms != 0 {
+			t.Fatalf("expected no encrypted rooms, got %d", stats.EncryptedRooms)
+		}
+		eventTypeNIDs, err := db.EventTypeNIDs(ctx, []string{spec.MRoomEncryption})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(eventTypeNIDs) != 0 {
+			t.Fatalf("expected the event type not to be created, got %v", eventTypeNIDs)
+		}
+	})
+}
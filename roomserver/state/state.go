@@ -79,11 +79,13 @@ func (p *StateResolution) Resolve(ctx context.Context, eventID string) (*gomatri
 		EventStateKeyNID: types.EmptyStateKeyNID,
 	}
 
-	var plNID types.EventNID
+	var plNID, createNID types.EventNID
 	for _, entry := range stateEntries {
-		if entry.StateKeyTuple == wantTuple {
+		switch {
+		case entry.StateKeyTuple == wantTuple:
 			plNID = entry.EventNID
-			break
+		case entry.IsCreate():
+			createNID = entry.EventNID
 		}
 	}
 	if plNID == 0 {
@@ -93,16 +95,32 @@ func (p *StateResolution) Resolve(ctx context.Context, eventID string) (*gomatri
 	if p.roomInfo == nil {
 		return nil, types.ErrorInvalidRoomInfo
 	}
-	events, err := p.db.Events(ctx, p.roomInfo.RoomVersion, []types.EventNID{plNID})
+	eventNIDs := []types.EventNID{plNID}
+	if createNID != 0 {
+		eventNIDs = append(eventNIDs, createNID)
+	}
+	events, err := p.db.Events(ctx, p.roomInfo.RoomVersion, eventNIDs)
 	if err != nil {
 		return nil, err
 	}
-	if len(events) == 0 {
+	var powerlevels *gomatrixserverlib.PowerLevelContent
+	var creators []string
+	for _, event := range events {
+		switch event.Type() {
+		case spec.MRoomPowerLevels:
+			if powerlevels, err = event.PowerLevels(); err != nil {
+				return nil, err
+			}
+		case spec.MRoomCreate:
+			// Creators have infinite power in some room versions.
+			creators = gomatrixserverlib.PrivilegedCreators(event)
+		}
+	}
+	if powerlevels == nil {
 		return nil, fmt.Errorf("unable to find power level event")
 	}
-	powerlevels, err := events[0].PowerLevels()
-	if err != nil {
-		return nil, err
+	if creators != nil {
+		powerlevels.SetCreators(creators)
 	}
 
 	return powerlevels, nil
@@ -668,6 +686,33 @@ var calculateStateConflictLength = prometheus.NewSummaryVec(
 	[]string{"algorithm", "outcome"},
 )
 
+var stateResolutionDurations = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "dendrite",
+		Subsystem: "roomserver",
+		Name:      "state_resolution_duration_milliseconds",
+		Help:      "How long it takes to resolve conflicted room state",
+		Buckets: []float64{ // milliseconds
+			1, 5, 10, 25, 50, 75, 100, 200, 300, 400, 500,
+			1000, 2000, 3000, 4000, 5000, 10000, 15000, 20000, 30000,
+		},
+	},
+	[]string{"algorithm"},
+)
+
+// stateResolutionSetLength tracks the sizes of the state sets passed to state
+// resolution. The "set" label is one of "conflicted", "unconflicted" or, for
+// state resolution v2 only, "auth" for the combined auth chain that was loaded.
+var stateResolutionSetLength = prometheus.NewSummaryVec(
+	prometheus.SummaryOpts{
+		Namespace: "dendrite",
+		Subsystem: "roomserver",
+		Name:      "state_resolution_set_length",
+		Help:      "The length of the state sets given to state resolution",
+	},
+	[]string{"algorithm", "set"},
+)
+
 type calculateStateMetrics struct {
 	algorithm       string
 	startTime       time.Time
@@ -702,6 +747,7 @@ func init() {
 	prometheus.MustRegister(
 		calculateStateDurations, calculateStatePrevEventLength,
 		calculateStateFullStateLength, calculateStateConflictLength,
+		stateResolutionDurations, stateResolutionSetLength,
 	)
 }
 
@@ -879,6 +925,40 @@ func (v *StateResolution) calculateStateAfterManyEvents(
 	return
 }
 
+// ResolveStateAfterEvents resolves the state after the given events without
+// storing it. Returns the resolved state, along with the conflicting entries
+// that state resolution had to choose between.
+func (v *StateResolution) ResolveStateAfterEvents(
+	ctx context.Context, prevStates []types.StateAtEvent,
+) (resolved, conflicts []types.StateEntry, err error) {
+	trace, ctx := internal.StartRegion(ctx, "StateResolution.ResolveStateAfterEvents")
+	defer trace.EndRegion()
+
+	combined, err := v.LoadCombinedStateAfterEvents(ctx, prevStates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("v.LoadCombinedStateAfterEvents: %w", err)
+	}
+	combined = combined[:util.SortAndUnique(stateEntrySorter(combined))]
+
+	conflicts = findDuplicateStateKeys(combined)
+	if len(conflicts) == 0 {
+		return combined, nil, nil
+	}
+	conflictMap := stateEntryMap(conflicts)
+	var notConflicted []types.StateEntry
+	for _, entry := range combined {
+		if _, ok := conflictMap.lookup(entry.StateKeyTuple); !ok {
+			notConflicted = append(notConflicted, entry)
+		}
+	}
+	// resolveConflicts may reorder the conflicts, so give it a copy.
+	resolved, err = v.resolveConflicts(ctx, v.roomInfo.RoomVersion, notConflicted, append([]types.StateEntry{}, conflicts...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("v.resolveConflicts: %w", err)
+	}
+	return resolved, conflicts, nil
+}
+
 func (v *StateResolution) resolveConflicts(
 	ctx context.Context, version gomatrixserverlib.RoomVersion,
 	notConflicted, conflicted []types.StateEntry,
@@ -892,13 +972,31 @@ func (v *StateResolution) resolveConflicts(
 	}
 
 	stateResAlgo := verImpl.StateResAlgorithm()
+	var algorithm string
 	switch stateResAlgo {
 	case gomatrixserverlib.StateResV1:
-		return v.resolveConflictsV1(ctx, notConflicted, conflicted)
+		algorithm = "v1"
 	case gomatrixserverlib.StateResV2:
-		return v.resolveConflictsV2(ctx, notConflicted, conflicted)
+		algorithm = "v2"
+	case gomatrixserverlib.StateResV21:
+		algorithm = "v2.1"
+	default:
+		return nil, fmt.Errorf("unsupported state resolution algorithm %v", stateResAlgo)
+	}
+
+	start := time.Now()
+	stateResolutionSetLength.WithLabelValues(algorithm, "conflicted").Observe(float64(len(conflicted)))
+	stateResolutionSetLength.WithLabelValues(algorithm, "unconflicted").Observe(float64(len(notConflicted)))
+	defer func() {
+		stateResolutionDurations.WithLabelValues(algorithm).Observe(
+			float64(time.Since(start).Milliseconds()),
+		)
+	}()
+
+	if stateResAlgo == gomatrixserverlib.StateResV1 {
+		return v.resolveConflictsV1(ctx, notConflicted, conflicted)
 	}
-	return nil, fmt.Errorf("unsupported state resolution algorithm %v", stateResAlgo)
+	return v.resolveConflictsV2(ctx, notConflicted, conflicted, stateResAlgo)
 }
 
 // resolveConflicts resolves a list of conflicted state entries. It takes two lists.
@@ -978,6 +1076,7 @@ func (v *StateResolution) resolveConflictsV1(
 func (v *StateResolution) resolveConflictsV2(
 	ctx context.Context,
 	notConflicted, conflicted []types.StateEntry,
+	stateResAlgo gomatrixserverlib.StateResAlgorithm,
 ) ([]types.StateEntry, error) {
 	trace, ctx := internal.StartRegion(ctx, "StateResolution.resolveConflictsV2")
 	defer trace.EndRegion()
@@ -1051,6 +1150,8 @@ func (v *StateResolution) resolveConflictsV2(
 		return nil, err
 	}
 
+	stateResolutionSetLength.WithLabelValues("v2", "auth").Observe(float64(len(authEvents)))
+
 	// Kill the reference to this so that the GC may pick it up, since we no
 	// longer need this after this point.
 	gotAuthEvents = nil // nolint:ineffassign
@@ -1060,7 +1161,11 @@ func (v *StateResolution) resolveConflictsV2(
 		resolvedTrace, _ := internal.StartRegion(ctx, "StateResolution.ResolveStateConflictsV2")
 		defer resolvedTrace.EndRegion()
 
-		return gomatrixserverlib.ResolveStateConflictsV2(
+		resolve := gomatrixserverlib.ResolveStateConflictsV2
+		if stateResAlgo == gomatrixserverlib.StateResV21 {
+			resolve = gomatrixserverlib.ResolveStateConflictsV21
+		}
+		return resolve(
 			conflictedEvents,
 			nonConflictedEvents,
 			authEvents,
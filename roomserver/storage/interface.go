@@ -147,7 +147,7 @@ type Database interface {
 	// PerformPublish publishes or unpublishes a room from the room directory. Returns a database error, if any.
 	PublishRoom(ctx context.Context, roomID, appserviceID, networkID string, publish bool) error
 	// Returns a list of room IDs for rooms which are published.
-	GetPublishedRooms(ctx context.Context, networkID string, includeAllNetworks bool) ([]string, error)
+	GetPublishedRooms(ctx context.Context, networkID string, includeAllNetworks bool) ([]tables.PublishedRoom, error)
 	// Returns whether a given room is published or not.
 	GetPublishedRoom(ctx context.Context, roomID string) (bool, error)
 
@@ -177,6 +177,20 @@ type Database interface {
 	GetHistoryVisibilityState(ctx context.Context, roomInfo *types.RoomInfo, eventID string, domain string) ([]gomatrixserverlib.PDU, error)
 	GetLeftUsers(ctx context.Context, userIDs []string) ([]string, error)
 	PurgeRoom(ctx context.Context, roomID string) error
+	// StateGCRooms returns the NIDs and IDs of up to limit rooms with NIDs greater than
+	// afterRoomNID, in ascending order, so that the state garbage collector can work
+	// through the rooms.
+	StateGCRooms(ctx context.Context, afterRoomNID types.RoomNID, limit int) (roomNIDs []types.RoomNID, roomIDs []string, err error)
+	// StateSnapshotsForRoom returns all of the state snapshots which belong to the room.
+	StateSnapshotsForRoom(ctx context.Context, roomNID types.RoomNID) ([]types.StateBlockNIDList, error)
+	// MergeStateSnapshots points the events at each of the keys of merge at the
+	// state snapshot it maps to instead, which must contain the same state. Returns
+	// how many of the snapshots were merged.
+	MergeStateSnapshots(ctx context.Context, roomNID types.RoomNID, merge map[types.StateSnapshotNID]types.StateSnapshotNID) (int64, error)
+	// CollectStateSnapshots deletes up to limit of the room's state snapshots which are
+	// no longer referenced by any event or by the room itself, along with the state
+	// blocks that were only used by them. Returns how many of each were deleted.
+	CollectStateSnapshots(ctx context.Context, roomNID types.RoomNID, limit int) (snapshots, blocks int64, err error)
 	UpgradeRoom(ctx context.Context, oldRoomID, newRoomID, eventSender string) error
 
 	// GetMembershipForHistoryVisibility queries the membership events for the given eventIDs.
@@ -195,6 +209,15 @@ type Database interface {
 
 	// RoomsWithACLs returns all room IDs for rooms with ACLs
 	RoomsWithACLs(ctx context.Context) ([]string, error)
+	// RoomStatistics returns aggregate counts of the rooms that we know about.
+	RoomStatistics(ctx context.Context) (*types.RoomStatistics, error)
+	// SetRoomPartialState marks the room as having been joined with partial state.
+	SetRoomPartialState(ctx context.Context, roomNID types.RoomNID, joinEventID string, serversInRoom []spec.ServerName) error
+	// PartialStateRoom returns the join event and the servers in the room if the room
+	// was joined with partial state. The join event ID is empty if the room has full state.
+	PartialStateRoom(ctx context.Context, roomNID types.RoomNID) (joinEventID string, serversInRoom []spec.ServerName, err error)
+	// PartialStateRoomIDs returns the IDs of all rooms that still have partial state.
+	PartialStateRoomIDs(ctx context.Context) ([]string, error)
 	QueryAdminEventReports(ctx context.Context, from uint64, limit uint64, backwards bool, userID string, roomID string) ([]api.QueryAdminEventReportsResponse, int64, error)
 	QueryAdminEventReport(ctx context.Context, reportID uint64) (api.QueryAdminEventReportResponse, error)
 	AdminDeleteEventReport(ctx context.Context, reportID uint64) error
@@ -240,6 +263,9 @@ type RoomDatabase interface {
 	GetOrCreateEventTypeNID(ctx context.Context, eventType string) (eventTypeNID types.EventTypeNID, err error)
 	GetOrCreateEventStateKeyNID(ctx context.Context, eventStateKey *string) (types.EventStateKeyNID, error)
 	GetStateEvent(ctx context.Context, roomID, evType, stateKey string) (*types.HeaderedEvent, error)
+	SetRoomPartialState(ctx context.Context, roomNID types.RoomNID, joinEventID string, serversInRoom []spec.ServerName) error
+	PartialStateRoom(ctx context.Context, roomNID types.RoomNID) (joinEventID string, serversInRoom []spec.ServerName, err error)
+	PartialStateRoomIDs(ctx context.Context) ([]string, error)
 }
 
 type EventDatabase interface {
@@ -0,0 +1,55 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpPublishedRoomType adds the room_type column to the published table.
+func UpPublishedRoomType(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE roomserver_published ADD COLUMN IF NOT EXISTS room_type TEXT NOT NULL DEFAULT '';`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
+
+func DownPublishedRoomType(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `ALTER TABLE roomserver_published DROP COLUMN IF EXISTS room_type;`)
+	if err != nil {
+		return fmt.Errorf("failed to execute downgrade: %w", err)
+	}
+	return nil
+}
+
+// UpPublishedRoomTypeBackfill fills in the room_type column from the create
+// events of rooms which were published before the column existed.
+func UpPublishedRoomTypeBackfill(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `UPDATE roomserver_published SET room_type = COALESCE((
+	SELECT roomserver_event_json.event_json::json->'content'->>'type'
+	FROM roomserver_event_json
+	JOIN roomserver_events ON roomserver_events.event_nid = roomserver_event_json.event_nid
+	JOIN roomserver_rooms ON roomserver_rooms.room_nid = roomserver_events.room_nid
+	WHERE roomserver_rooms.room_id = roomserver_published.room_id AND roomserver_events.event_type_nid = 1
+	LIMIT 1
+), '');`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
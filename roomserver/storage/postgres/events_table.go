@@ -72,6 +72,9 @@ CREATE INDEX IF NOT EXISTS roomserver_events_memberships_idx ON roomserver_event
 -- The following indexes are used by bulkSelectStateEventByNIDSQL 
 CREATE INDEX IF NOT EXISTS roomserver_event_event_type_nid_idx ON roomserver_events (event_type_nid);
 CREATE INDEX IF NOT EXISTS roomserver_event_state_key_nid_idx ON roomserver_events (event_state_key_nid);
+
+-- Used by the state garbage collector to find which state snapshots are still referenced.
+CREATE INDEX IF NOT EXISTS roomserver_events_state_snapshot_nid_idx ON roomserver_events (state_snapshot_nid);
 `
 
 const insertEventSQL = "" +
@@ -126,6 +129,9 @@ const selectEventSentToOutputSQL = "" +
 const updateEventSentToOutputSQL = "" +
 	"UPDATE roomserver_events SET sent_to_output = TRUE WHERE event_nid = $1"
 
+const updateEventRejectedSQL = "" +
+	"UPDATE roomserver_events SET is_rejected = TRUE WHERE event_nid = $1"
+
 const selectEventIDSQL = "" +
 	"SELECT event_id FROM roomserver_events WHERE event_nid = $1"
 
@@ -164,6 +170,7 @@ type eventStatements struct {
 	updateEventStateStmt                          *sql.Stmt
 	selectEventSentToOutputStmt                   *sql.Stmt
 	updateEventSentToOutputStmt                   *sql.Stmt
+	updateEventRejectedStmt                       *sql.Stmt
 	selectEventIDStmt                             *sql.Stmt
 	bulkSelectStateAtEventAndReferenceStmt        *sql.Stmt
 	bulkSelectEventIDStmt                         *sql.Stmt
@@ -204,6 +211,7 @@ func PrepareEventsTable(db *sql.DB) (tables.Events, error) {
 		{&s.bulkSelectStateAtEventByIDStmt, bulkSelectStateAtEventByIDSQL},
 		{&s.updateEventStateStmt, updateEventStateSQL},
 		{&s.updateEventSentToOutputStmt, updateEventSentToOutputSQL},
+		{&s.updateEventRejectedStmt, updateEventRejectedSQL},
 		{&s.selectEventSentToOutputStmt, selectEventSentToOutputSQL},
 		{&s.selectEventIDStmt, selectEventIDSQL},
 		{&s.bulkSelectStateAtEventAndReferenceStmt, bulkSelectStateAtEventAndReferenceSQL},
@@ -426,6 +434,12 @@ func (s *eventStatements) UpdateEventSentToOutput(ctx context.Context, txn *sql.
 	return err
 }
 
+func (s *eventStatements) UpdateEventRejected(ctx context.Context, txn *sql.Tx, eventNID types.EventNID) error {
+	updateStmt := sqlutil.TxStmt(txn, s.updateEventRejectedStmt)
+	_, err := updateStmt.ExecContext(ctx, int64(eventNID))
+	return err
+}
+
 func (s *eventStatements) SelectEventID(
 	ctx context.Context, txn *sql.Tx, eventNID types.EventNID,
 ) (eventID string, err error) {
@@ -164,6 +164,11 @@ FROM roomserver_membership m
 WHERE membership_nid > $1 AND target_nid = ANY($2)
 `
 
+const selectJoinedRemoteServerCountSQL = "" +
+	"SELECT COUNT(DISTINCT substring(event_state_key from position(':' in event_state_key) + 1)) FROM roomserver_membership" +
+	" JOIN roomserver_event_state_keys ON roomserver_membership.target_nid = roomserver_event_state_keys.event_state_key_nid" +
+	" WHERE membership_nid = $1 AND target_local = false"
+
 type membershipStatements struct {
 	insertMembershipStmt                            *sql.Stmt
 	selectMembershipForUpdateStmt                   *sql.Stmt
@@ -182,6 +187,7 @@ type membershipStatements struct {
 	selectServerInRoomStmt                          *sql.Stmt
 	deleteMembershipStmt                            *sql.Stmt
 	selectJoinedUsersStmt                           *sql.Stmt
+	selectJoinedRemoteServerCountStmt               *sql.Stmt
 }
 
 func CreateMembershipTable(db *sql.DB) error {
@@ -218,6 +224,7 @@ func PrepareMembershipTable(db *sql.DB) (tables.Membership, error) {
 		{&s.selectServerInRoomStmt, selectServerInRoomSQL},
 		{&s.deleteMembershipStmt, deleteMembershipSQL},
 		{&s.selectJoinedUsersStmt, selectJoinedUsersSQL},
+		{&s.selectJoinedRemoteServerCountStmt, selectJoinedRemoteServerCountSQL},
 	}.Prepare(db)
 }
 
@@ -473,3 +480,11 @@ func (s *membershipStatements) DeleteMembership(
 	)
 	return err
 }
+
+func (s *membershipStatements) SelectJoinedRemoteServerCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectJoinedRemoteServerCountStmt)
+	err = stmt.QueryRowContext(ctx, tables.MembershipStateJoin).Scan(&count)
+	return
+}
@@ -82,6 +82,9 @@ const bulkSelectRoomIDsSQL = "" +
 const bulkSelectRoomNIDsSQL = "" +
 	"SELECT room_nid FROM roomserver_rooms WHERE room_id = ANY($1)"
 
+const selectRoomCountsByVersionSQL = "" +
+	"SELECT room_version, COUNT(*) FROM roomserver_rooms GROUP BY room_version"
+
 type roomStatements struct {
 	insertRoomNIDStmt                  *sql.Stmt
 	selectRoomNIDStmt                  *sql.Stmt
@@ -93,6 +96,7 @@ type roomStatements struct {
 	selectRoomInfoStmt                 *sql.Stmt
 	bulkSelectRoomIDsStmt              *sql.Stmt
 	bulkSelectRoomNIDsStmt             *sql.Stmt
+	selectRoomCountsByVersionStmt      *sql.Stmt
 }
 
 func CreateRoomsTable(db *sql.DB) error {
@@ -114,6 +118,7 @@ func PrepareRoomsTable(db *sql.DB) (tables.Rooms, error) {
 		{&s.selectRoomInfoStmt, selectRoomInfoSQL},
 		{&s.bulkSelectRoomIDsStmt, bulkSelectRoomIDsSQL},
 		{&s.bulkSelectRoomNIDsStmt, bulkSelectRoomNIDsSQL},
+		{&s.selectRoomCountsByVersionStmt, selectRoomCountsByVersionSQL},
 	}.Prepare(db)
 }
 
@@ -287,3 +292,24 @@ func roomNIDsAsArray(roomNIDs []types.RoomNID) pq.Int64Array {
 	}
 	return nids
 }
+
+func (s *roomStatements) SelectRoomCountsByVersion(
+	ctx context.Context, txn *sql.Tx,
+) (map[gomatrixserverlib.RoomVersion]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRoomCountsByVersionStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectRoomCountsByVersionStmt: rows.close() failed")
+	result := make(map[gomatrixserverlib.RoomVersion]int64)
+	var roomVersion gomatrixserverlib.RoomVersion
+	var count int64
+	for rows.Next() {
+		if err = rows.Scan(&roomVersion, &count); err != nil {
+			return nil, err
+		}
+		result[roomVersion] = count
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/lib/pq"
+)
+
+const selectRoomsAfterSQL = "" +
+	"SELECT room_nid, room_id FROM roomserver_rooms WHERE room_nid > $1 ORDER BY room_nid ASC LIMIT $2"
+
+const selectStateSnapshotsForRoomSQL = "" +
+	"SELECT state_snapshot_nid, state_block_nids FROM roomserver_state_snapshots" +
+	" WHERE room_nid = $1 ORDER BY state_snapshot_nid ASC"
+
+const selectReferencedStateSnapshotNIDsSQL = "" +
+	"SELECT DISTINCT s.state_snapshot_nid FROM roomserver_state_snapshots s" +
+	" JOIN roomserver_events e ON e.state_snapshot_nid = s.state_snapshot_nid" +
+	" WHERE s.room_nid = $1"
+
+const updateEventStateSnapshotNIDSQL = "" +
+	"UPDATE roomserver_events SET state_snapshot_nid = $1 WHERE state_snapshot_nid = $2"
+
+const deleteStateSnapshotsSQL = "" +
+	"DELETE FROM roomserver_state_snapshots WHERE state_snapshot_nid = ANY($1)"
+
+const deleteStateBlocksSQL = "" +
+	"DELETE FROM roomserver_state_block WHERE state_block_nid = ANY($1) AND cardinality(event_nids) > 0"
+
+type stateGCStatements struct {
+	selectRoomsAfterStmt                  *sql.Stmt
+	selectStateSnapshotsForRoomStmt       *sql.Stmt
+	selectReferencedStateSnapshotNIDsStmt *sql.Stmt
+	updateEventStateSnapshotNIDStmt       *sql.Stmt
+	deleteStateSnapshotsStmt              *sql.Stmt
+	deleteStateBlocksStmt                 *sql.Stmt
+}
+
+func PrepareStateGCStatements(db *sql.DB) (*stateGCStatements, error) {
+	s := &stateGCStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.selectRoomsAfterStmt, selectRoomsAfterSQL},
+		{&s.selectStateSnapshotsForRoomStmt, selectStateSnapshotsForRoomSQL},
+		{&s.selectReferencedStateSnapshotNIDsStmt, selectReferencedStateSnapshotNIDsSQL},
+		{&s.updateEventStateSnapshotNIDStmt, updateEventStateSnapshotNIDSQL},
+		{&s.deleteStateSnapshotsStmt, deleteStateSnapshotsSQL},
+		{&s.deleteStateBlocksStmt, deleteStateBlocksSQL},
+	}.Prepare(db)
+}
+
+func (s *stateGCStatements) SelectRoomsAfter(
+	ctx context.Context, txn *sql.Tx, afterRoomNID types.RoomNID, limit int,
+) (roomNIDs []types.RoomNID, roomIDs []string, err error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectRoomsAfterStmt).QueryContext(ctx, afterRoomNID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectRoomsAfter: rows.close() failed")
+	var roomNID types.RoomNID
+	var roomID string
+	for rows.Next() {
+		if err = rows.Scan(&roomNID, &roomID); err != nil {
+			return nil, nil, err
+		}
+		roomNIDs = append(roomNIDs, roomNID)
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomNIDs, roomIDs, rows.Err()
+}
+
+func (s *stateGCStatements) SelectStateSnapshotsForRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) ([]types.StateBlockNIDList, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectStateSnapshotsForRoomStmt).QueryContext(ctx, roomNID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectStateSnapshotsForRoom: rows.close() failed")
+	var results []types.StateBlockNIDList
+	var stateBlockNIDs pq.Int64Array
+	for rows.Next() {
+		var result types.StateBlockNIDList
+		if err = rows.Scan(&result.StateSnapshotNID, &stateBlockNIDs); err != nil {
+			return nil, err
+		}
+		result.StateBlockNIDs = make([]types.StateBlockNID, len(stateBlockNIDs))
+		for i := range stateBlockNIDs {
+			result.StateBlockNIDs[i] = types.StateBlockNID(stateBlockNIDs[i])
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func (s *stateGCStatements) SelectReferencedStateSnapshotNIDs(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) ([]types.StateSnapshotNID, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectReferencedStateSnapshotNIDsStmt).QueryContext(ctx, roomNID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectReferencedStateSnapshotNIDs: rows.close() failed")
+	var stateNIDs []types.StateSnapshotNID
+	var stateNID types.StateSnapshotNID
+	for rows.Next() {
+		if err = rows.Scan(&stateNID); err != nil {
+			return nil, err
+		}
+		stateNIDs = append(stateNIDs, stateNID)
+	}
+	return stateNIDs, rows.Err()
+}
+
+func (s *stateGCStatements) UpdateEventStateSnapshotNID(
+	ctx context.Context, txn *sql.Tx, oldNID, newNID types.StateSnapshotNID,
+) (int64, error) {
+	res, err := sqlutil.TxStmt(txn, s.updateEventStateSnapshotNIDStmt).ExecContext(ctx, newNID, oldNID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *stateGCStatements) DeleteStateSnapshots(
+	ctx context.Context, txn *sql.Tx, stateNIDs []types.StateSnapshotNID,
+) (int64, error) {
+	nids := make([]int64, len(stateNIDs))
+	for i := range stateNIDs {
+		nids[i] = int64(stateNIDs[i])
+	}
+	res, err := sqlutil.TxStmt(txn, s.deleteStateSnapshotsStmt).ExecContext(ctx, pq.Int64Array(nids))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *stateGCStatements) DeleteStateBlocks(
+	ctx context.Context, txn *sql.Tx, stateBlockNIDs []types.StateBlockNID,
+) (int64, error) {
+	nids := make([]int64, len(stateBlockNIDs))
+	for i := range stateBlockNIDs {
+		nids[i] = int64(stateBlockNIDs[i])
+	}
+	res, err := sqlutil.TxStmt(txn, s.deleteStateBlocksStmt).ExecContext(ctx, pq.Int64Array(nids))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -52,6 +52,8 @@ CREATE TABLE IF NOT EXISTS roomserver_state_snapshots (
 	-- The state blocks contained within this snapshot.
 	state_block_nids bigint[] NOT NULL
 );
+
+CREATE INDEX IF NOT EXISTS roomserver_state_snapshots_room_nid_idx ON roomserver_state_snapshots (room_nid);
 `
 
 // Insert a new state snapshot. If we conflict on the hash column then
@@ -56,6 +56,17 @@ func Open(ctx context.Context, conMan *sqlutil.Connections, dbProperties *config
 		return nil, err
 	}
 
+	// The published room type backfill reads from the events tables, so it
+	// also needs to run once all tables exist.
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "roomserver: published room type backfill",
+		Up:      deltas.UpPublishedRoomTypeBackfill,
+	})
+	if err = m.Up(ctx); err != nil {
+		return nil, err
+	}
+
 	// Then prepare the statements. Now that the migrations have run, any columns referred
 	// to in the database code should now exist.
 	if err = d.prepare(db, writer, cache); err != nil {
@@ -137,6 +148,9 @@ func (d *Database) create(db *sql.DB) error {
 	if err := CreateReportedEventsTable(db); err != nil {
 		return err
 	}
+	if err := CreatePartialStateRoomsTable(db); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -198,6 +212,10 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 	if err != nil {
 		return err
 	}
+	stateGC, err := PrepareStateGCStatements(db)
+	if err != nil {
+		return err
+	}
 	userRoomKeys, err := PrepareUserRoomKeysTable(db)
 	if err != nil {
 		return err
@@ -206,6 +224,10 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 	if err != nil {
 		return err
 	}
+	partialStateRooms, err := PreparePartialStateRoomsTable(db)
+	if err != nil {
+		return err
+	}
 
 	d.Database = shared.Database{
 		DB: db,
@@ -221,17 +243,19 @@ func (d *Database) prepare(db *sql.DB, writer sqlutil.Writer, cache caching.Room
 			RedactionsTable:     redactions,
 			ReportedEventsTable: reportedEvents,
 		},
-		Cache:              cache,
-		Writer:             writer,
-		RoomsTable:         rooms,
-		StateBlockTable:    stateBlock,
-		StateSnapshotTable: stateSnapshot,
-		RoomAliasesTable:   roomAliases,
-		InvitesTable:       invites,
-		MembershipTable:    membership,
-		PublishedTable:     published,
-		Purge:              purge,
-		UserRoomKeyTable:   userRoomKeys,
+		Cache:                  cache,
+		Writer:                 writer,
+		RoomsTable:             rooms,
+		StateBlockTable:        stateBlock,
+		StateSnapshotTable:     stateSnapshot,
+		RoomAliasesTable:       roomAliases,
+		InvitesTable:           invites,
+		MembershipTable:        membership,
+		PublishedTable:         published,
+		Purge:                  purge,
+		StateGC:                stateGC,
+		UserRoomKeyTable:       userRoomKeys,
+		PartialStateRoomsTable: partialStateRooms,
 	}
 	return nil
 }
@@ -235,6 +235,13 @@ func (u *RoomUpdater) SetLatestEvents(
 	})
 }
 
+// ClearPartialState marks the room as having full state.
+func (u *RoomUpdater) ClearPartialState() error {
+	return u.d.Writer.Do(u.d.DB, u.txn, func(txn *sql.Tx) error {
+		return u.d.PartialStateRoomsTable.DeletePartialStateRoom(u.ctx, txn, u.roomInfo.RoomNID)
+	})
+}
+
 // HasEventBeenSent implements types.RoomRecentEventsUpdater
 func (u *RoomUpdater) HasEventBeenSent(eventNID types.EventNID) (bool, error) {
 	return u.d.EventsTable.SelectEventSentToOutput(u.ctx, u.txn, eventNID)
@@ -247,6 +254,13 @@ func (u *RoomUpdater) MarkEventAsSent(eventNID types.EventNID) error {
 	})
 }
 
+// MarkEventAsRejected marks an event which was accepted before as rejected.
+func (u *RoomUpdater) MarkEventAsRejected(eventNID types.EventNID) error {
+	return u.d.Writer.Do(u.d.DB, u.txn, func(txn *sql.Tx) error {
+		return u.d.EventsTable.UpdateEventRejected(u.ctx, txn, eventNID)
+	})
+}
+
 func (u *RoomUpdater) MembershipUpdater(targetUserNID types.EventStateKeyNID, targetLocal bool) (*MembershipUpdater, error) {
 	return u.d.membershipUpdaterTxn(u.ctx, u.txn, u.roomInfo.RoomNID, targetUserNID, targetLocal)
 }
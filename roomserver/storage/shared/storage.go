@@ -36,18 +36,20 @@ const redactionsArePermanent = true
 type Database struct {
 	DB *sql.DB
 	EventDatabase
-	Cache              caching.RoomServerCaches
-	Writer             sqlutil.Writer
-	RoomsTable         tables.Rooms
-	StateSnapshotTable tables.StateSnapshot
-	StateBlockTable    tables.StateBlock
-	RoomAliasesTable   tables.RoomAliases
-	InvitesTable       tables.Invites
-	MembershipTable    tables.Membership
-	PublishedTable     tables.Published
-	Purge              tables.Purge
-	UserRoomKeyTable   tables.UserRoomKeys
-	GetRoomUpdaterFn   func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
+	Cache                  caching.RoomServerCaches
+	Writer                 sqlutil.Writer
+	RoomsTable             tables.Rooms
+	StateSnapshotTable     tables.StateSnapshot
+	StateBlockTable        tables.StateBlock
+	RoomAliasesTable       tables.RoomAliases
+	InvitesTable           tables.Invites
+	MembershipTable        tables.Membership
+	PublishedTable         tables.Published
+	Purge                  tables.Purge
+	StateGC                tables.StateGC
+	UserRoomKeyTable       tables.UserRoomKeys
+	PartialStateRoomsTable tables.PartialStateRooms
+	GetRoomUpdaterFn       func(ctx context.Context, roomInfo *types.RoomInfo) (*RoomUpdater, error)
 }
 
 // EventDatabase contains all tables needed to work with events
@@ -845,16 +847,34 @@ func (d *EventDatabase) StoreEvent(
 }
 
 func (d *Database) PublishRoom(ctx context.Context, roomID, appserviceID, networkID string, publish bool) error {
+	roomType, err := d.roomType(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("d.roomType: %w", err)
+	}
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		return d.PublishedTable.UpsertRoomPublished(ctx, txn, roomID, appserviceID, networkID, publish)
+		return d.PublishedTable.UpsertRoomPublished(ctx, txn, roomID, appserviceID, networkID, publish, roomType)
 	})
 }
 
+// roomType returns the type from the create event of the room, which is
+// empty for plain rooms and for rooms that we don't have the state of.
+func (d *Database) roomType(ctx context.Context, roomID string) (string, error) {
+	roomInfo, err := d.roomInfo(ctx, nil, roomID)
+	if err != nil || roomInfo == nil || roomInfo.IsStub() {
+		return "", err
+	}
+	createEvent, err := d.GetStateEvent(ctx, roomID, spec.MRoomCreate, "")
+	if err != nil || createEvent == nil {
+		return "", err
+	}
+	return gjson.GetBytes(createEvent.Content(), "type").Str, nil
+}
+
 func (d *Database) GetPublishedRoom(ctx context.Context, roomID string) (bool, error) {
 	return d.PublishedTable.SelectPublishedFromRoomID(ctx, nil, roomID)
 }
 
-func (d *Database) GetPublishedRooms(ctx context.Context, networkID string, includeAllNetworks bool) ([]string, error) {
+func (d *Database) GetPublishedRooms(ctx context.Context, networkID string, includeAllNetworks bool) ([]tables.PublishedRoom, error) {
 	return d.PublishedTable.SelectAllPublishedRooms(ctx, nil, networkID, true, includeAllNetworks)
 }
 
@@ -1646,6 +1666,74 @@ func (d *Database) RoomsWithACLs(ctx context.Context) ([]string, error) {
 	return roomIDs, nil
 }
 
+// SetRoomPartialState marks the room as having been joined with partial state
+// at the given join event.
+func (d *Database) SetRoomPartialState(
+	ctx context.Context, roomNID types.RoomNID, joinEventID string, serversInRoom []spec.ServerName,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.PartialStateRoomsTable.InsertPartialStateRoom(ctx, txn, roomNID, joinEventID, serversInRoom)
+	})
+}
+
+// PartialStateRoom returns the join event and the servers in the room if the
+// room was joined with partial state. The join event ID is empty if the room
+// has full state.
+func (d *Database) PartialStateRoom(
+	ctx context.Context, roomNID types.RoomNID,
+) (joinEventID string, serversInRoom []spec.ServerName, err error) {
+	joinEventID, serversInRoom, err = d.PartialStateRoomsTable.SelectPartialStateRoom(ctx, nil, roomNID)
+	if err == sql.ErrNoRows {
+		return "", nil, nil
+	}
+	return joinEventID, serversInRoom, err
+}
+
+// PartialStateRoomIDs returns the IDs of all rooms that still have partial state.
+func (d *Database) PartialStateRoomIDs(ctx context.Context) ([]string, error) {
+	roomNIDs, err := d.PartialStateRoomsTable.SelectPartialStateRoomNIDs(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(roomNIDs) == 0 {
+		return nil, nil
+	}
+	return d.RoomsTable.BulkSelectRoomIDs(ctx, nil, roomNIDs)
+}
+
+// RoomStatistics returns aggregate counts of the rooms that we know about.
+func (d *Database) RoomStatistics(ctx context.Context) (*types.RoomStatistics, error) {
+	byVersion, err := d.RoomsTable.SelectRoomCountsByVersion(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("d.RoomsTable.SelectRoomCountsByVersion: %w", err)
+	}
+
+	// Don't create the event type if it doesn't exist yet, in which case no
+	// rooms are encrypted.
+	eventTypeNIDs, err := d.eventTypeNIDs(ctx, nil, []string{spec.MRoomEncryption})
+	if err != nil {
+		return nil, fmt.Errorf("d.eventTypeNIDs: %w", err)
+	}
+	var encryptedRoomNIDs []types.RoomNID
+	if eventTypeNID, ok := eventTypeNIDs[spec.MRoomEncryption]; ok {
+		encryptedRoomNIDs, err = d.EventsTable.SelectRoomsWithEventTypeNID(ctx, nil, eventTypeNID)
+		if err != nil {
+			return nil, fmt.Errorf("d.EventsTable.SelectRoomsWithEventTypeNID: %w", err)
+		}
+	}
+
+	remoteServers, err := d.MembershipTable.SelectJoinedRemoteServerCount(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("d.MembershipTable.SelectJoinedRemoteServerCount: %w", err)
+	}
+
+	return &types.RoomStatistics{
+		RoomsByVersion:      byVersion,
+		EncryptedRooms:      int64(len(encryptedRoomNIDs)),
+		JoinedRemoteServers: remoteServers,
+	}, nil
+}
+
 // ForgetRoom sets a users room to forgotten
 func (d *Database) ForgetRoom(ctx context.Context, userID, roomID string, forget bool) error {
 	roomNIDs, err := d.RoomsTable.BulkSelectRoomNIDs(ctx, nil, []string{roomID})
@@ -1680,7 +1768,114 @@ func (d *Database) PurgeRoom(ctx context.Context, roomID string) error {
 	})
 }
 
+func (d *Database) StateGCRooms(
+	ctx context.Context, afterRoomNID types.RoomNID, limit int,
+) ([]types.RoomNID, []string, error) {
+	return d.StateGC.SelectRoomsAfter(ctx, nil, afterRoomNID, limit)
+}
+
+func (d *Database) StateSnapshotsForRoom(ctx context.Context, roomNID types.RoomNID) ([]types.StateBlockNIDList, error) {
+	return d.StateGC.SelectStateSnapshotsForRoom(ctx, nil, roomNID)
+}
+
+func (d *Database) MergeStateSnapshots(
+	ctx context.Context, roomNID types.RoomNID, merge map[types.StateSnapshotNID]types.StateSnapshotNID,
+) (merged int64, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		// Lock the room so that we don't race with new events being stored.
+		_, _, currentStateNID, err := d.RoomsTable.SelectLatestEventsNIDsForUpdate(ctx, txn, roomNID)
+		if err != nil {
+			return fmt.Errorf("failed to lock the room: %w", err)
+		}
+		for oldNID, newNID := range merge {
+			if oldNID == newNID || oldNID == currentStateNID {
+				continue
+			}
+			updated, err := d.StateGC.UpdateEventStateSnapshotNID(ctx, txn, oldNID, newNID)
+			if err != nil {
+				return fmt.Errorf("d.StateGC.UpdateEventStateSnapshotNID: %w", err)
+			}
+			if updated > 0 {
+				merged++
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// CollectStateSnapshots relies on state snapshots and blocks only ever being
+// shared between rooms if they are empty, since otherwise they contain the
+// room's own event NIDs. This means that only the room needs to be checked for
+// references, and the empty ones are never deleted.
+func (d *Database) CollectStateSnapshots(
+	ctx context.Context, roomNID types.RoomNID, limit int,
+) (snapshots, blocks int64, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		// Lock the room so that we don't race with new events being stored.
+		_, _, currentStateNID, err := d.RoomsTable.SelectLatestEventsNIDsForUpdate(ctx, txn, roomNID)
+		if err != nil {
+			return fmt.Errorf("failed to lock the room: %w", err)
+		}
+		roomSnapshots, err := d.StateGC.SelectStateSnapshotsForRoom(ctx, txn, roomNID)
+		if err != nil {
+			return fmt.Errorf("d.StateGC.SelectStateSnapshotsForRoom: %w", err)
+		}
+		referenced, err := d.StateGC.SelectReferencedStateSnapshotNIDs(ctx, txn, roomNID)
+		if err != nil {
+			return fmt.Errorf("d.StateGC.SelectReferencedStateSnapshotNIDs: %w", err)
+		}
+		inUse := make(map[types.StateSnapshotNID]struct{}, len(referenced)+1)
+		inUse[currentStateNID] = struct{}{}
+		for _, stateNID := range referenced {
+			inUse[stateNID] = struct{}{}
+		}
+
+		var unreferenced []types.StateSnapshotNID
+		candidateBlocks := map[types.StateBlockNID]struct{}{}
+		keptBlocks := map[types.StateBlockNID]struct{}{}
+		for _, snapshot := range roomSnapshots {
+			_, used := inUse[snapshot.StateSnapshotNID]
+			if used || len(snapshot.StateBlockNIDs) == 0 || len(unreferenced) >= limit {
+				for _, stateBlockNID := range snapshot.StateBlockNIDs {
+					keptBlocks[stateBlockNID] = struct{}{}
+				}
+				continue
+			}
+			unreferenced = append(unreferenced, snapshot.StateSnapshotNID)
+			for _, stateBlockNID := range snapshot.StateBlockNIDs {
+				candidateBlocks[stateBlockNID] = struct{}{}
+			}
+		}
+		if len(unreferenced) == 0 {
+			return nil
+		}
+		orphaned := make([]types.StateBlockNID, 0, len(candidateBlocks))
+		for stateBlockNID := range candidateBlocks {
+			if _, kept := keptBlocks[stateBlockNID]; !kept {
+				orphaned = append(orphaned, stateBlockNID)
+			}
+		}
+
+		if snapshots, err = d.StateGC.DeleteStateSnapshots(ctx, txn, unreferenced); err != nil {
+			return fmt.Errorf("d.StateGC.DeleteStateSnapshots: %w", err)
+		}
+		if len(orphaned) > 0 {
+			if blocks, err = d.StateGC.DeleteStateBlocks(ctx, txn, orphaned); err != nil {
+				return fmt.Errorf("d.StateGC.DeleteStateBlocks: %w", err)
+			}
+		}
+		return nil
+	})
+	return
+}
+
 func (d *Database) UpgradeRoom(ctx context.Context, oldRoomID, newRoomID, eventSender string) error {
+	// Room upgrades keep the type of the room.
+	roomType, err := d.roomType(ctx, newRoomID)
+	if err != nil {
+		return fmt.Errorf("d.roomType: %w", err)
+	}
 
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		published, err := d.PublishedTable.SelectPublishedFromRoomID(ctx, txn, oldRoomID)
@@ -1689,11 +1884,11 @@ func (d *Database) UpgradeRoom(ctx context.Context, oldRoomID, newRoomID, eventS
 		}
 		if published {
 			// un-publish old room
-			if err = d.PublishedTable.UpsertRoomPublished(ctx, txn, oldRoomID, "", "", false); err != nil {
+			if err = d.PublishedTable.UpsertRoomPublished(ctx, txn, oldRoomID, "", "", false, roomType); err != nil {
 				return fmt.Errorf("failed to unpublish room: %w", err)
 			}
 			// publish new room
-			if err = d.PublishedTable.UpsertRoomPublished(ctx, txn, newRoomID, "", "", true); err != nil {
+			if err = d.PublishedTable.UpsertRoomPublished(ctx, txn, newRoomID, "", "", true, roomType); err != nil {
 				return fmt.Errorf("failed to publish room: %w", err)
 			}
 		}
@@ -139,6 +139,11 @@ FROM roomserver_membership m
 WHERE membership_nid > $1 AND target_nid IN ($2)
 `
 
+const selectJoinedRemoteServerCountSQL = "" +
+	"SELECT COUNT(DISTINCT substr(event_state_key, instr(event_state_key, ':') + 1)) FROM roomserver_membership" +
+	" JOIN roomserver_event_state_keys ON roomserver_membership.target_nid = roomserver_event_state_keys.event_state_key_nid" +
+	" WHERE membership_nid = $1 AND target_local = false"
+
 type membershipStatements struct {
 	db                                              *sql.DB
 	insertMembershipStmt                            *sql.Stmt
@@ -156,6 +161,7 @@ type membershipStatements struct {
 	selectServerInRoomStmt                          *sql.Stmt
 	deleteMembershipStmt                            *sql.Stmt
 	// selectJoinedUsersStmt                           *sql.Stmt // Prepared at runtime
+	selectJoinedRemoteServerCountStmt *sql.Stmt
 }
 
 func CreateMembershipTable(db *sql.DB) error {
@@ -191,6 +197,7 @@ func PrepareMembershipTable(db *sql.DB) (tables.Membership, error) {
 		{&s.selectLocalServerInRoomStmt, selectLocalServerInRoomSQL},
 		{&s.selectServerInRoomStmt, selectServerInRoomSQL},
 		{&s.deleteMembershipStmt, deleteMembershipSQL},
+		{&s.selectJoinedRemoteServerCountStmt, selectJoinedRemoteServerCountSQL},
 	}.Prepare(db)
 }
 
@@ -458,3 +465,11 @@ func (s *membershipStatements) SelectJoinedUsers(
 
 	return result, rows.Err()
 }
+
+func (s *membershipStatements) SelectJoinedRemoteServerCount(
+	ctx context.Context, txn *sql.Tx,
+) (count int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.selectJoinedRemoteServerCountStmt)
+	err = stmt.QueryRowContext(ctx, tables.MembershipStateJoin).Scan(&count)
+	return
+}
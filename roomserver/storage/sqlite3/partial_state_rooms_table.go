@@ -0,0 +1,130 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/roomserver/storage/tables"
+	"github.com/jchv/maidtrix/roomserver/types"
+)
+
+const partialStateRoomsSchema = `
+-- Stores the rooms which were joined over federation with partial state, i.e.
+-- without the membership events. The full state of these rooms still needs to
+-- be fetched from one of the servers in the room.
+CREATE TABLE IF NOT EXISTS roomserver_partial_state_rooms (
+    room_nid INTEGER PRIMARY KEY,
+    -- The event ID of the join event that the partial state was returned for.
+    join_event_id TEXT NOT NULL,
+    -- A JSON array of the servers that were in the room at the time of the join.
+    servers_in_room TEXT NOT NULL
+);
+`
+
+const insertPartialStateRoomSQL = "" +
+	"INSERT INTO roomserver_partial_state_rooms (room_nid, join_event_id, servers_in_room)" +
+	" VALUES ($1, $2, $3)" +
+	" ON CONFLICT (room_nid) DO UPDATE SET join_event_id = $2, servers_in_room = $3"
+
+const selectPartialStateRoomSQL = "" +
+	"SELECT join_event_id, servers_in_room FROM roomserver_partial_state_rooms" +
+	" WHERE room_nid = $1"
+
+const selectPartialStateRoomNIDsSQL = "" +
+	"SELECT room_nid FROM roomserver_partial_state_rooms"
+
+const deletePartialStateRoomSQL = "" +
+	"DELETE FROM roomserver_partial_state_rooms WHERE room_nid = $1"
+
+type partialStateRoomsStatements struct {
+	insertPartialStateRoomStmt     *sql.Stmt
+	selectPartialStateRoomStmt     *sql.Stmt
+	selectPartialStateRoomNIDsStmt *sql.Stmt
+	deletePartialStateRoomStmt     *sql.Stmt
+}
+
+func CreatePartialStateRoomsTable(db *sql.DB) error {
+	_, err := db.Exec(partialStateRoomsSchema)
+	return err
+}
+
+func PreparePartialStateRoomsTable(db *sql.DB) (tables.PartialStateRooms, error) {
+	s := &partialStateRoomsStatements{}
+
+	return s, sqlutil.StatementList{
+		{&s.insertPartialStateRoomStmt, insertPartialStateRoomSQL},
+		{&s.selectPartialStateRoomStmt, selectPartialStateRoomSQL},
+		{&s.selectPartialStateRoomNIDsStmt, selectPartialStateRoomNIDsSQL},
+		{&s.deletePartialStateRoomStmt, deletePartialStateRoomSQL},
+	}.Prepare(db)
+}
+
+func (s *partialStateRoomsStatements) InsertPartialStateRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, joinEventID string, serversInRoom []spec.ServerName,
+) error {
+	servers, err := json.Marshal(serversInRoom)
+	if err != nil {
+		return err
+	}
+	stmt := sqlutil.TxStmt(txn, s.insertPartialStateRoomStmt)
+	_, err = stmt.ExecContext(ctx, roomNID, joinEventID, string(servers))
+	return err
+}
+
+func (s *partialStateRoomsStatements) SelectPartialStateRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) (joinEventID string, serversInRoom []spec.ServerName, err error) {
+	var servers string
+	stmt := sqlutil.TxStmt(txn, s.selectPartialStateRoomStmt)
+	if err = stmt.QueryRowContext(ctx, roomNID).Scan(&joinEventID, &servers); err != nil {
+		return "", nil, err
+	}
+	err = json.Unmarshal([]byte(servers), &serversInRoom)
+	return joinEventID, serversInRoom, err
+}
+
+func (s *partialStateRoomsStatements) SelectPartialStateRoomNIDs(
+	ctx context.Context, txn *sql.Tx,
+) ([]types.RoomNID, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectPartialStateRoomNIDsStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "SelectPartialStateRoomNIDs: rows.close() failed")
+	var roomNIDs []types.RoomNID
+	var roomNID types.RoomNID
+	for rows.Next() {
+		if err = rows.Scan(&roomNID); err != nil {
+			return nil, err
+		}
+		roomNIDs = append(roomNIDs, roomNID)
+	}
+	return roomNIDs, rows.Err()
+}
+
+func (s *partialStateRoomsStatements) DeletePartialStateRoom(
+	ctx context.Context, txn *sql.Tx, roomNID types.RoomNID,
+) error {
+	stmt := sqlutil.TxStmt(txn, s.deletePartialStateRoomStmt)
+	_, err := stmt.ExecContext(ctx, roomNID)
+	return err
+}
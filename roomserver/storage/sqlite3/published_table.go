@@ -35,19 +35,21 @@ CREATE TABLE IF NOT EXISTS roomserver_published (
     network_id TEXT NOT NULL,
     -- Whether it is published or not
     published BOOLEAN NOT NULL DEFAULT false,
+    -- The type of the room from its create event, or empty for plain rooms
+    room_type TEXT NOT NULL DEFAULT '',
     PRIMARY KEY (room_id, appservice_id, network_id)
 );
 `
 
 const upsertPublishedSQL = "" +
-	"INSERT INTO roomserver_published (room_id, appservice_id, network_id, published) VALUES ($1, $2, $3, $4)" +
-	" ON CONFLICT (room_id, appservice_id, network_id) DO UPDATE SET published = $4"
+	"INSERT INTO roomserver_published (room_id, appservice_id, network_id, published, room_type) VALUES ($1, $2, $3, $4, $5)" +
+	" ON CONFLICT (room_id, appservice_id, network_id) DO UPDATE SET published = $4, room_type = $5"
 
 const selectAllPublishedSQL = "" +
-	"SELECT room_id FROM roomserver_published WHERE published = $1 AND CASE WHEN $2 THEN 1=1 ELSE network_id = '' END ORDER BY room_id ASC"
+	"SELECT room_id, room_type FROM roomserver_published WHERE published = $1 AND CASE WHEN $2 THEN 1=1 ELSE network_id = '' END ORDER BY room_id ASC"
 
 const selectNetworkPublishedSQL = "" +
-	"SELECT room_id FROM roomserver_published WHERE published = $1 AND network_id = $2 ORDER BY room_id ASC"
+	"SELECT room_id, room_type FROM roomserver_published WHERE published = $1 AND network_id = $2 ORDER BY room_id ASC"
 
 const selectPublishedSQL = "" +
 	"SELECT published FROM roomserver_published WHERE room_id = $1"
@@ -66,10 +68,16 @@ func CreatePublishedTable(db *sql.DB) error {
 		return err
 	}
 	m := sqlutil.NewMigrator(db)
-	m.AddMigrations(sqlutil.Migration{
-		Version: "roomserver: published appservice",
-		Up:      deltas.UpPulishedAppservice,
-	})
+	m.AddMigrations([]sqlutil.Migration{
+		{
+			Version: "roomserver: published appservice",
+			Up:      deltas.UpPulishedAppservice,
+		},
+		{
+			Version: "roomserver: published room type",
+			Up:      deltas.UpPublishedRoomType,
+		},
+	}...)
 	return m.Up(context.Background())
 }
 
@@ -87,10 +95,10 @@ func PreparePublishedTable(db *sql.DB) (tables.Published, error) {
 }
 
 func (s *publishedStatements) UpsertRoomPublished(
-	ctx context.Context, txn *sql.Tx, roomID, appserviceID, networkID string, published bool,
+	ctx context.Context, txn *sql.Tx, roomID, appserviceID, networkID string, published bool, roomType string,
 ) error {
 	stmt := sqlutil.TxStmt(txn, s.upsertPublishedStmt)
-	_, err := stmt.ExecContext(ctx, roomID, appserviceID, networkID, published)
+	_, err := stmt.ExecContext(ctx, roomID, appserviceID, networkID, published, roomType)
 	return err
 }
 
@@ -107,7 +115,7 @@ func (s *publishedStatements) SelectPublishedFromRoomID(
 
 func (s *publishedStatements) SelectAllPublishedRooms(
 	ctx context.Context, txn *sql.Tx, networkID string, published, includeAllNetworks bool,
-) ([]string, error) {
+) ([]tables.PublishedRoom, error) {
 	var rows *sql.Rows
 	var err error
 	if networkID != "" {
@@ -122,14 +130,14 @@ func (s *publishedStatements) SelectAllPublishedRooms(
 	}
 	defer internal.CloseAndLogIfError(ctx, rows, "selectAllPublishedStmt: rows.close() failed")
 
-	var roomIDs []string
-	var roomID string
+	var rooms []tables.PublishedRoom
 	for rows.Next() {
-		if err = rows.Scan(&roomID); err != nil {
+		var room tables.PublishedRoom
+		if err = rows.Scan(&room.RoomID, &room.RoomType); err != nil {
 			return nil, err
 		}
 
-		roomIDs = append(roomIDs, roomID)
+		rooms = append(rooms, room)
 	}
-	return roomIDs, rows.Err()
+	return rooms, rows.Err()
 }
@@ -46,6 +46,9 @@ const purgePreviousEventsSQL = "" +
 const purgePreviousEvents2SQL = "" +
 	"DELETE FROM roomserver_previous_events AS rpe WHERE EXISTS(SELECT event_id FROM roomserver_events AS re WHERE room_nid = $1 AND re.event_id = rpe.previous_event_id)"
 
+const purgePartialStateRoomSQL = "" +
+	"DELETE FROM roomserver_partial_state_rooms WHERE room_nid = $1"
+
 const purgePublishedSQL = "" +
 	"DELETE FROM roomserver_published WHERE room_id = $1"
 
@@ -68,6 +71,7 @@ type purgeStatements struct {
 	purgeEventsStmt               *sql.Stmt
 	purgeInvitesStmt              *sql.Stmt
 	purgeMembershipsStmt          *sql.Stmt
+	purgePartialStateRoomStmt     *sql.Stmt
 	purgePreviousEventsStmt       *sql.Stmt
 	purgePreviousEvents2Stmt      *sql.Stmt
 	purgePublishedStmt            *sql.Stmt
@@ -86,6 +90,7 @@ func PreparePurgeStatements(db *sql.DB, stateSnapshot *stateSnapshotStatements)
 		{&s.purgeInvitesStmt, purgeInvitesSQL},
 		{&s.purgeMembershipsStmt, purgeMembershipsSQL},
 		{&s.purgePublishedStmt, purgePublishedSQL},
+		{&s.purgePartialStateRoomStmt, purgePartialStateRoomSQL},
 		{&s.purgePreviousEventsStmt, purgePreviousEventsSQL},
 		{&s.purgePreviousEvents2Stmt, purgePreviousEvents2SQL},
 		{&s.purgeRedactionStmt, purgeRedactionsSQL},
@@ -121,6 +126,7 @@ func (s *purgeStatements) PurgeRoom(
 		s.purgeStateSnapshotEntriesStmt,
 		s.purgeInvitesStmt,
 		s.purgeMembershipsStmt,
+		s.purgePartialStateRoomStmt,
 		s.purgePreviousEvents2Stmt, // Fast purge the majority of events
 		s.purgePreviousEventsStmt,  // Slow purge the remaining events
 		s.purgeEventJSONStmt,
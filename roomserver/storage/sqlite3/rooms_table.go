@@ -74,6 +74,9 @@ const bulkSelectRoomNIDsSQL = "" +
 const selectRoomNIDForUpdateSQL = "" +
 	"SELECT room_nid FROM roomserver_rooms WHERE room_id = $1"
 
+const selectRoomCountsByVersionSQL = "" +
+	"SELECT room_version, COUNT(*) FROM roomserver_rooms GROUP BY room_version"
+
 type roomStatements struct {
 	db                                 *sql.DB
 	insertRoomNIDStmt                  *sql.Stmt
@@ -83,7 +86,8 @@ type roomStatements struct {
 	selectLatestEventNIDsForUpdateStmt *sql.Stmt
 	updateLatestEventNIDsStmt          *sql.Stmt
 	//selectRoomVersionForRoomNIDStmt    *sql.Stmt
-	selectRoomInfoStmt *sql.Stmt
+	selectRoomInfoStmt            *sql.Stmt
+	selectRoomCountsByVersionStmt *sql.Stmt
 }
 
 func CreateRoomsTable(db *sql.DB) error {
@@ -105,6 +109,7 @@ func PrepareRoomsTable(db *sql.DB) (tables.Rooms, error) {
 		//{&s.selectRoomVersionForRoomNIDsStmt, selectRoomVersionForRoomNIDsSQL},
 		{&s.selectRoomInfoStmt, selectRoomInfoSQL},
 		{&s.selectRoomNIDForUpdateStmt, selectRoomNIDForUpdateSQL},
+		{&s.selectRoomCountsByVersionStmt, selectRoomCountsByVersionSQL},
 	}.Prepare(db)
 }
 
@@ -300,3 +305,24 @@ func (s *roomStatements) BulkSelectRoomNIDs(ctx context.Context, txn *sql.Tx, ro
 	}
 	return roomNIDs, rows.Err()
 }
+
+func (s *roomStatements) SelectRoomCountsByVersion(
+	ctx context.Context, txn *sql.Tx,
+) (map[gomatrixserverlib.RoomVersion]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.selectRoomCountsByVersionStmt)
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectRoomCountsByVersionStmt: rows.close() failed")
+	result := make(map[gomatrixserverlib.RoomVersion]int64)
+	var roomVersion gomatrixserverlib.RoomVersion
+	var count int64
+	for rows.Next() {
+		if err = rows.Scan(&roomVersion, &count); err != nil {
+			return nil, err
+		}
+		result[roomVersion] = count
+	}
+	return result, rows.Err()
+}
@@ -42,6 +42,8 @@ const stateSnapshotSchema = `
 	-- The state blocks contained within this snapshot, encoded as JSON.
     state_block_nids TEXT NOT NULL DEFAULT '[]'
   );
+
+CREATE INDEX IF NOT EXISTS roomserver_state_snapshots_room_nid_idx ON roomserver_state_snapshots (room_nid);
 `
 
 // Insert a new state snapshot. If we conflict on the hash column then
@@ -59,6 +59,8 @@ type Events interface {
 	UpdateEventState(ctx context.Context, txn *sql.Tx, eventNID types.EventNID, stateNID types.StateSnapshotNID) error
 	SelectEventSentToOutput(ctx context.Context, txn *sql.Tx, eventNID types.EventNID) (sentToOutput bool, err error)
 	UpdateEventSentToOutput(ctx context.Context, txn *sql.Tx, eventNID types.EventNID) error
+	// UpdateEventRejected marks an event which was accepted before as rejected.
+	UpdateEventRejected(ctx context.Context, txn *sql.Tx, eventNID types.EventNID) error
 	SelectEventID(ctx context.Context, txn *sql.Tx, eventNID types.EventNID) (eventID string, err error)
 	BulkSelectStateAtEventAndReference(ctx context.Context, txn *sql.Tx, eventNIDs []types.EventNID) ([]types.StateAtEventAndReference, error)
 	// BulkSelectEventID returns a map from numeric event ID to string event ID.
@@ -85,6 +87,8 @@ type Rooms interface {
 	SelectRoomInfo(ctx context.Context, txn *sql.Tx, roomID string) (*types.RoomInfo, error)
 	BulkSelectRoomIDs(ctx context.Context, txn *sql.Tx, roomNIDs []types.RoomNID) ([]string, error)
 	BulkSelectRoomNIDs(ctx context.Context, txn *sql.Tx, roomIDs []string) ([]types.RoomNID, error)
+	// SelectRoomCountsByVersion returns how many rooms we know about for each room version.
+	SelectRoomCountsByVersion(ctx context.Context, txn *sql.Tx) (map[gomatrixserverlib.RoomVersion]int64, error)
 }
 
 type StateSnapshot interface {
@@ -155,6 +159,15 @@ type ReportedEvents interface {
 	DeleteReportedEvent(ctx context.Context, txn *sql.Tx, reportID uint64) error
 }
 
+// PartialStateRooms tracks the rooms which were joined with partial state.
+type PartialStateRooms interface {
+	InsertPartialStateRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, joinEventID string, serversInRoom []spec.ServerName) error
+	// SelectPartialStateRoom returns sql.ErrNoRows if the room doesn't have partial state.
+	SelectPartialStateRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID) (joinEventID string, serversInRoom []spec.ServerName, err error)
+	SelectPartialStateRoomNIDs(ctx context.Context, txn *sql.Tx) ([]types.RoomNID, error)
+	DeletePartialStateRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID) error
+}
+
 type MembershipState int64
 
 const (
@@ -180,12 +193,21 @@ type Membership interface {
 	SelectServerInRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, serverName spec.ServerName) (bool, error)
 	DeleteMembership(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID, targetUserNID types.EventStateKeyNID) error
 	SelectJoinedUsers(ctx context.Context, txn *sql.Tx, targetUserNIDs []types.EventStateKeyNID) ([]types.EventStateKeyNID, error)
+	// SelectJoinedRemoteServerCount returns the number of remote servers with at least one user joined to any room.
+	SelectJoinedRemoteServerCount(ctx context.Context, txn *sql.Tx) (int64, error)
+}
+
+// PublishedRoom is a room in the room directory, along with the type from
+// its create event, which is empty for plain rooms.
+type PublishedRoom struct {
+	RoomID   string
+	RoomType string
 }
 
 type Published interface {
-	UpsertRoomPublished(ctx context.Context, txn *sql.Tx, roomID, appserviceID, networkID string, published bool) (err error)
+	UpsertRoomPublished(ctx context.Context, txn *sql.Tx, roomID, appserviceID, networkID string, published bool, roomType string) (err error)
 	SelectPublishedFromRoomID(ctx context.Context, txn *sql.Tx, roomID string) (published bool, err error)
-	SelectAllPublishedRooms(ctx context.Context, txn *sql.Tx, networkdID string, published, includeAllNetworks bool) ([]string, error)
+	SelectAllPublishedRooms(ctx context.Context, txn *sql.Tx, networkdID string, published, includeAllNetworks bool) ([]PublishedRoom, error)
 }
 
 type RedactionInfo struct {
@@ -214,6 +236,23 @@ type Purge interface {
 	) error
 }
 
+// StateGC holds the statements used to find and delete state snapshots and
+// blocks which are no longer needed.
+type StateGC interface {
+	// SelectRoomsAfter returns the NIDs and IDs of up to limit rooms with NIDs greater than afterRoomNID, in ascending order.
+	SelectRoomsAfter(ctx context.Context, txn *sql.Tx, afterRoomNID types.RoomNID, limit int) (roomNIDs []types.RoomNID, roomIDs []string, err error)
+	// SelectStateSnapshotsForRoom returns all of the state snapshots which belong to the room.
+	SelectStateSnapshotsForRoom(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID) ([]types.StateBlockNIDList, error)
+	// SelectReferencedStateSnapshotNIDs returns those state snapshots of the room which are referenced by events.
+	SelectReferencedStateSnapshotNIDs(ctx context.Context, txn *sql.Tx, roomNID types.RoomNID) ([]types.StateSnapshotNID, error)
+	// UpdateEventStateSnapshotNID points all events at oldNID to newNID instead.
+	UpdateEventStateSnapshotNID(ctx context.Context, txn *sql.Tx, oldNID, newNID types.StateSnapshotNID) (int64, error)
+	// DeleteStateSnapshots deletes the given state snapshots, returning how many were deleted.
+	DeleteStateSnapshots(ctx context.Context, txn *sql.Tx, stateNIDs []types.StateSnapshotNID) (int64, error)
+	// DeleteStateBlocks deletes the given non-empty state blocks, returning how many were deleted.
+	DeleteStateBlocks(ctx context.Context, txn *sql.Tx, stateBlockNIDs []types.StateBlockNID) (int64, error)
+}
+
 type UserRoomKeys interface {
 	// InsertUserRoomPrivatePublicKey inserts the given private key as well as the public key for it. This should be used
 	// when creating keys locally.
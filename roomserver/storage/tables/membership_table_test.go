@@ -135,5 +135,21 @@ func TestMembershipTable(t *testing.T) {
 		assert.NoError(t, err)
 		// Only userNIDs[0] is actually joined, so we only expect this userNID
 		assert.Equal(t, userNIDs[:1], joinedUsers)
+
+		// only joined remote users count towards the remote servers
+		remoteServers, err := tab.SelectJoinedRemoteServerCount(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), remoteServers)
+		for i := 0; i < 2; i++ {
+			remoteNID, err := stateKeyTab.InsertEventStateKeyNID(ctx, nil, fmt.Sprintf("@remote%d:example.org", i))
+			assert.NoError(t, err)
+			err = tab.InsertMembership(ctx, nil, 1, remoteNID, false)
+			assert.NoError(t, err)
+			_, err = tab.UpdateMembership(ctx, nil, 1, remoteNID, remoteNID, tables.MembershipStateJoin, 1, false)
+			assert.NoError(t, err)
+		}
+		remoteServers, err = tab.SelectJoinedRemoteServerCount(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), remoteServers)
 	})
 }
@@ -0,0 +1,80 @@
+package tables_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/roomserver/storage/postgres"
+	"github.com/jchv/maidtrix/roomserver/storage/sqlite3"
+	"github.com/jchv/maidtrix/roomserver/storage/tables"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustCreatePartialStateRoomsTable(t *testing.T, dbType test.DBType) (tab tables.PartialStateRooms, close func()) {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	assert.NoError(t, err)
+	switch dbType {
+	case test.DBTypePostgres:
+		err = postgres.CreatePartialStateRoomsTable(db)
+		assert.NoError(t, err)
+		tab, err = postgres.PreparePartialStateRoomsTable(db)
+	case test.DBTypeSQLite:
+		err = sqlite3.CreatePartialStateRoomsTable(db)
+		assert.NoError(t, err)
+		tab, err = sqlite3.PreparePartialStateRoomsTable(db)
+	}
+	assert.NoError(t, err)
+
+	return tab, close
+}
+
+func TestPartialStateRoomsTable(t *testing.T) {
+	ctx := context.Background()
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab, close := mustCreatePartialStateRoomsTable(t, dbType)
+		defer close()
+
+		// A room we never joined with partial state has no entry
+		_, _, err := tab.SelectPartialStateRoom(ctx, nil, 1)
+		assert.Equal(t, sql.ErrNoRows, err)
+
+		servers := []spec.ServerName{"a.test", "b.test"}
+		assert.NoError(t, tab.InsertPartialStateRoom(ctx, nil, 1, "$join1", servers))
+		assert.NoError(t, tab.InsertPartialStateRoom(ctx, nil, 2, "$join2", servers[:1]))
+
+		joinEventID, serversInRoom, err := tab.SelectPartialStateRoom(ctx, nil, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "$join1", joinEventID)
+		assert.Equal(t, servers, serversInRoom)
+
+		// Joining again replaces the previous join
+		assert.NoError(t, tab.InsertPartialStateRoom(ctx, nil, 1, "$join3", servers[1:]))
+		joinEventID, serversInRoom, err = tab.SelectPartialStateRoom(ctx, nil, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "$join3", joinEventID)
+		assert.Equal(t, servers[1:], serversInRoom)
+
+		roomNIDs, err := tab.SelectPartialStateRoomNIDs(ctx, nil)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []types.RoomNID{1, 2}, roomNIDs)
+
+		assert.NoError(t, tab.DeletePartialStateRoom(ctx, nil, 1))
+		_, _, err = tab.SelectPartialStateRoom(ctx, nil, 1)
+		assert.Equal(t, sql.ErrNoRows, err)
+
+		roomNIDs, err = tab.SelectPartialStateRoomNIDs(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []types.RoomNID{2}, roomNIDs)
+	})
+}
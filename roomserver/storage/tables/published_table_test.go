@@ -47,33 +47,37 @@ func TestPublishedTable(t *testing.T) {
 		defer close()
 
 		// Publish some rooms
-		publishedRooms := []string{}
+		publishedRooms := []tables.PublishedRoom{}
 		asID := ""
 		nwID := ""
 		for i := 0; i < 10; i++ {
 			room := test.NewRoom(t, alice)
 			published := i%2 == 0
-			err := tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, published)
+			roomType := ""
+			if i%4 == 0 {
+				roomType = "m.space"
+			}
+			err := tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, published, roomType)
 			assert.NoError(t, err)
 			if published {
-				publishedRooms = append(publishedRooms, room.ID)
+				publishedRooms = append(publishedRooms, tables.PublishedRoom{RoomID: room.ID, RoomType: roomType})
 			}
 			publishedRes, err := tab.SelectPublishedFromRoomID(ctx, nil, room.ID)
 			assert.NoError(t, err)
 			assert.Equal(t, published, publishedRes)
 		}
-		sort.Strings(publishedRooms)
+		sortPublishedRooms(publishedRooms)
 
 		// check that we get the expected published rooms
-		roomIDs, err := tab.SelectAllPublishedRooms(ctx, nil, "", true, true)
+		rooms, err := tab.SelectAllPublishedRooms(ctx, nil, "", true, true)
 		assert.NoError(t, err)
-		assert.Equal(t, publishedRooms, roomIDs)
+		assert.Equal(t, publishedRooms, rooms)
 
 		// test an actual upsert
 		room := test.NewRoom(t, alice)
-		err = tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, true)
+		err = tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, true, "")
 		assert.NoError(t, err)
-		err = tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, false)
+		err = tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, false, "")
 		assert.NoError(t, err)
 		// should now be false, due to the upsert
 		publishedRes, err := tab.SelectPublishedFromRoomID(ctx, nil, room.ID)
@@ -83,18 +87,24 @@ func TestPublishedTable(t *testing.T) {
 		// network specific test
 		nwID = "irc"
 		room = test.NewRoom(t, alice)
-		err = tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, true)
+		err = tab.UpsertRoomPublished(ctx, nil, room.ID, asID, nwID, true, "")
 		assert.NoError(t, err)
-		publishedRooms = append(publishedRooms, room.ID)
-		sort.Strings(publishedRooms)
+		publishedRooms = append(publishedRooms, tables.PublishedRoom{RoomID: room.ID})
+		sortPublishedRooms(publishedRooms)
 		// should only return the room for network "irc"
 		allNWPublished, err := tab.SelectAllPublishedRooms(ctx, nil, nwID, true, true)
 		assert.NoError(t, err)
-		assert.Equal(t, []string{room.ID}, allNWPublished)
+		assert.Equal(t, []tables.PublishedRoom{{RoomID: room.ID}}, allNWPublished)
 
 		// check that we still get all published rooms regardless networkID
-		roomIDs, err = tab.SelectAllPublishedRooms(ctx, nil, "", true, true)
+		rooms, err = tab.SelectAllPublishedRooms(ctx, nil, "", true, true)
 		assert.NoError(t, err)
-		assert.Equal(t, publishedRooms, roomIDs)
+		assert.Equal(t, publishedRooms, rooms)
+	})
+}
+
+func sortPublishedRooms(rooms []tables.PublishedRoom) {
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].RoomID < rooms[j].RoomID
 	})
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/sqlutil"
 	"github.com/jchv/maidtrix/internal/util"
 	"github.com/jchv/maidtrix/roomserver/storage/postgres"
@@ -52,6 +53,10 @@ func TestRoomsTable(t *testing.T) {
 		_, err = tab.InsertRoomNID(ctx, nil, util.RandomString(16), room.Version)
 		assert.NoError(t, err)
 
+		roomCounts, err := tab.SelectRoomCountsByVersion(ctx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[gomatrixserverlib.RoomVersion]int64{room.Version: 2}, roomCounts)
+
 		gotRoomNID, err := tab.SelectRoomNID(ctx, nil, room.ID)
 		assert.NoError(t, err)
 		assert.Equal(t, wantRoomNID, gotRoomNID)
@@ -291,6 +291,17 @@ type RejectedError string
 
 func (e RejectedError) Error() string { return string(e) }
 
+// RoomStatistics contains aggregate information about the rooms known to
+// the roomserver, used for usage statistics reporting.
+type RoomStatistics struct {
+	// The number of rooms for each room version.
+	RoomsByVersion map[gomatrixserverlib.RoomVersion]int64
+	// The number of rooms which have had encryption enabled.
+	EncryptedRooms int64
+	// The number of remote servers with at least one user joined to a room.
+	JoinedRemoteServers int64
+}
+
 // RoomInfo contains metadata about a room
 type RoomInfo struct {
 	mu               sync.RWMutex
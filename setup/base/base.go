@@ -104,7 +104,7 @@ func CreateFederationClient(cfg *config.Dendrite, dnsCache *fclient.DNSCache) fc
 	return client
 }
 
-func ConfigureAdminEndpoints(processContext *process.ProcessContext, routers httputil.Routers) {
+func ConfigureAdminEndpoints(processContext *process.ProcessContext, cfg *config.Dendrite, routers httputil.Routers) {
 	routers.DendriteAdmin.HandleFunc("/monitor/up", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 	})
@@ -120,6 +120,8 @@ func ConfigureAdminEndpoints(processContext *process.ProcessContext, routers htt
 		}
 		w.WriteHeader(200)
 	})
+	routers.DendriteAdmin.HandleFunc("/health/live", liveHandler(processContext))
+	routers.DendriteAdmin.HandleFunc("/health/ready", readyHandler(processContext, cfg.Global.Health.CheckTimeout))
 }
 
 // SetupAndServeHTTP sets up the HTTP server to serve client & federation APIs
@@ -151,7 +153,7 @@ func SetupAndServeHTTP(
 		externalRouter.Handle("/metrics", httputil.WrapHandlerInBasicAuth(promhttp.Handler(), cfg.Global.Metrics.BasicAuth))
 	}
 
-	ConfigureAdminEndpoints(processContext, routers)
+	ConfigureAdminEndpoints(processContext, cfg, routers)
 
 	// Parse and execute the landing page template
 	tmpl := template.Must(template.ParseFS(staticContent, "static/*.gotmpl"))
@@ -0,0 +1,186 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package base
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/setup/process"
+)
+
+const (
+	healthStatusOK           = "ok"
+	healthStatusFailed       = "failed"
+	healthStatusShuttingDown = "shutting_down"
+
+	// The readiness endpoint is unauthenticated, so failed checks only
+	// report one of these, and the underlying error is logged instead.
+	healthErrorFailed   = "check failed, see the logs for details"
+	healthErrorTimedOut = "check timed out"
+)
+
+type healthCheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type healthResponse struct {
+	Status   string                       `json:"status"`
+	Checks   map[string]healthCheckResult `json:"checks,omitempty"`
+	Warnings []string                     `json:"warnings,omitempty"`
+}
+
+// RegisterHealthChecks adds the readiness checks which depend only on the
+// configuration, i.e. the signing keys and the free space for the media
+// store. Databases and NATS register their own checks when connecting.
+func RegisterHealthChecks(processContext *process.ProcessContext, cfg *config.Dendrite) {
+	processContext.RegisterHealthCheck("signing keys", func(ctx context.Context) error {
+		return checkSigningKeys(cfg.Global.SigningIdentities())
+	})
+	if minFree := cfg.Global.Health.MinFreeDiskSpace; minFree > 0 {
+		basePath := cfg.MediaAPI.AbsBasePath
+		if basePath == "" {
+			basePath = cfg.MediaAPI.BasePath
+		}
+		processContext.RegisterHealthCheck(
+			"media disk space",
+			process.DiskSpaceCheck(string(basePath), uint64(minFree)),
+		)
+	}
+}
+
+// checkSigningKeys makes sure that each of the signing keys is present and
+// can produce signatures which verify against its own public key.
+func checkSigningKeys(identities []*fclient.SigningIdentity) error {
+	message := []byte("dendrite health check")
+	for _, identity := range identities {
+		if len(identity.PrivateKey) != ed25519.PrivateKeySize {
+			return fmt.Errorf("signing key %q for %q is missing or invalid", identity.KeyID, identity.ServerName)
+		}
+		publicKey, ok := identity.PrivateKey.Public().(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(publicKey, message, ed25519.Sign(identity.PrivateKey, message)) {
+			return fmt.Errorf("signing key %q for %q does not produce valid signatures", identity.KeyID, identity.ServerName)
+		}
+	}
+	return nil
+}
+
+// runHealthChecks runs all of the registered health checks concurrently,
+// giving each one up to the timeout to complete. The errors of failed
+// checks are logged rather than returned.
+func runHealthChecks(ctx context.Context, processContext *process.ProcessContext, timeout time.Duration) (map[string]healthCheckResult, bool) {
+	names := processContext.HealthCheckNames()
+	results := make(map[string]healthCheckResult, len(names))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range names {
+		check, ok := processContext.HealthCheck(name)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, check process.HealthCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := check(checkCtx)
+			result := healthCheckResult{
+				Status:     healthStatusOK,
+				DurationMS: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("check", name).Warn("Health check failed")
+				result.Status = healthStatusFailed
+				result.Error = healthErrorFailed
+				if checkCtx.Err() == context.DeadlineExceeded {
+					result.Error = healthErrorTimedOut
+				}
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	healthy := true
+	for _, result := range results {
+		if result.Status != healthStatusOK {
+			healthy = false
+		}
+	}
+	return results, healthy
+}
+
+func writeHealthResponse(w http.ResponseWriter, healthy bool, res healthResponse) {
+	buf := &bytes.Buffer{}
+	_ = json.NewEncoder(buf).Encode(res)
+	w.Header().Set("Content-Type", "application/json")
+	if healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(buf.Bytes())
+}
+
+// liveHandler reports whether the process is running at all. It doesn't
+// run any of the health checks, so that a slow or broken dependency does
+// not cause the process to be restarted.
+func liveHandler(processContext *process.ProcessContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if processContext.IsShuttingDown() {
+			writeHealthResponse(w, false, healthResponse{Status: healthStatusShuttingDown})
+			return
+		}
+		writeHealthResponse(w, true, healthResponse{Status: healthStatusOK})
+	}
+}
+
+// readyHandler runs all of the registered health checks and reports
+// whether Dendrite is ready to serve requests.
+func readyHandler(processContext *process.ProcessContext, timeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if processContext.IsShuttingDown() {
+			writeHealthResponse(w, false, healthResponse{Status: healthStatusShuttingDown})
+			return
+		}
+		checks, healthy := runHealthChecks(r.Context(), processContext, timeout)
+		res := healthResponse{
+			Status: healthStatusOK,
+			Checks: checks,
+		}
+		if isDegraded, reasons := processContext.IsDegraded(); isDegraded {
+			res.Warnings = reasons
+			healthy = false
+		}
+		if !healthy {
+			res.Status = healthStatusFailed
+		}
+		writeHealthResponse(w, healthy, res)
+	}
+}
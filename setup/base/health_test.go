@@ -0,0 +1,76 @@
+package base
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
+	"github.com/jchv/maidtrix/setup/process"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyHandler(t *testing.T) {
+	processCtx := process.NewProcessContext()
+	processCtx.RegisterHealthCheck("good", func(ctx context.Context) error {
+		return nil
+	})
+
+	serve := func() (int, healthResponse) {
+		rec := httptest.NewRecorder()
+		readyHandler(processCtx, time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		var res healthResponse
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&res))
+		return rec.Code, res
+	}
+
+	code, res := serve()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthStatusOK, res.Status)
+	assert.Equal(t, healthStatusOK, res.Checks["good"].Status)
+
+	processCtx.RegisterHealthCheck("bad", func(ctx context.Context) error {
+		return errors.New("database is on fire")
+	})
+	processCtx.RegisterHealthCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, res = serve()
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthStatusFailed, res.Status)
+	assert.Equal(t, healthStatusOK, res.Checks["good"].Status)
+	assert.Equal(t, healthStatusFailed, res.Checks["bad"].Status)
+	assert.Equal(t, healthErrorFailed, res.Checks["bad"].Error)
+	assert.Equal(t, healthStatusFailed, res.Checks["slow"].Status)
+	assert.Equal(t, healthErrorTimedOut, res.Checks["slow"].Error)
+
+	// Liveness doesn't depend on the checks, only on whether we're shutting down.
+	rec := httptest.NewRecorder()
+	liveHandler(processCtx).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	processCtx.ShutdownDendrite()
+	rec = httptest.NewRecorder()
+	liveHandler(processCtx).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestCheckSigningKeys(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	identities := []*fclient.SigningIdentity{
+		{ServerName: "localhost", KeyID: "ed25519:auto", PrivateKey: privateKey},
+	}
+	assert.NoError(t, checkSigningKeys(identities))
+
+	identities = append(identities, &fclient.SigningIdentity{ServerName: "virtual", KeyID: "ed25519:auto"})
+	assert.Error(t, checkSigningKeys(identities))
+}
@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"encoding/pem"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -30,9 +29,6 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ed25519"
 	"gopkg.in/yaml.v2"
-
-	jaegerconfig "github.com/uber/jaeger-client-go/config"
-	jaegermetrics "github.com/uber/jaeger-lib/metrics"
 )
 
 // keyIDRegexp defines allowable characters in Key IDs.
@@ -67,12 +63,7 @@ type Dendrite struct {
 	MSCs MSCs `yaml:"mscs"`
 
 	// The config for tracing the dendrite servers.
-	Tracing struct {
-		// Set to true to enable tracer hooks. If false, no tracing is set up.
-		Enabled bool `yaml:"enabled"`
-		// The config for the jaeger opentracing reporter.
-		Jaeger jaegerconfig.Configuration `yaml:"jaeger"`
-	} `yaml:"tracing"`
+	Tracing Tracing `yaml:"tracing"`
 
 	// The config for logging informations. Each hook will be added to logrus.
 	Logging []LogrusHook `yaml:"logging"`
@@ -156,6 +147,20 @@ type LogrusHook struct {
 	// The level of the logs to produce. Will output only this level and above.
 	Level string `yaml:"level"`
 
+	// The format of the log lines, either "text" (the default) or "json".
+	// JSON lines include stable fields such as component, request_id,
+	// user_id, room_id, event_id and origin where they are known.
+	Format string `yaml:"format,omitempty"`
+
+	// The name of the field that JSON lines put the component in, which
+	// defaults to "component".
+	ComponentField string `yaml:"component_field,omitempty"`
+
+	// Per-component level overrides, keyed by component or package path
+	// relative to the module root, e.g. "federationapi" or
+	// "roomserver/internal/input". The most specific match wins.
+	Components map[string]string `yaml:"components,omitempty"`
+
 	// The parameters for this hook.
 	Params map[string]interface{} `yaml:"params"`
 }
@@ -297,6 +302,18 @@ func (config *Dendrite) Derive() error {
 		}
 	}
 
+	if config.UserAPI.UserConsent.Enabled {
+		config.Derived.Registration.Params[authtypes.LoginTypeTerms] = config.UserAPI.UserConsent.TermsParams()
+		for i := range config.Derived.Registration.Flows {
+			flow := &config.Derived.Registration.Flows[i]
+			if len(flow.Stages) == 1 && flow.Stages[0] == authtypes.LoginTypeDummy {
+				// Accepting the terms is enough of a stage on its own
+				flow.Stages = nil
+			}
+			flow.Stages = append(flow.Stages, authtypes.LoginTypeTerms)
+		}
+	}
+
 	// Load application service configuration files
 	if err := loadAppServices(&config.AppServiceAPI, &config.Derived); err != nil {
 		return err
@@ -325,6 +342,7 @@ func (c *Dendrite) Defaults(opts DefaultOpts) {
 	c.AppServiceAPI.Defaults(opts)
 	c.RelayAPI.Defaults(opts)
 	c.MSCs.Defaults(opts)
+	c.Tracing.Defaults()
 	c.Wiring()
 }
 
@@ -337,6 +355,7 @@ func (c *Dendrite) Verify(configErrs *ConfigErrors) {
 		&c.KeyServer, &c.MediaAPI, &c.RoomServer,
 		&c.SyncAPI, &c.UserAPI,
 		&c.AppServiceAPI, &c.RelayAPI, &c.MSCs,
+		&c.Tracing,
 	} {
 		c.Verify(configErrs)
 	}
@@ -401,6 +420,16 @@ func (config *Dendrite) checkLogging(configErrs *ConfigErrors) {
 	for _, logrusHook := range config.Logging {
 		checkNotEmpty(configErrs, "logging.type", string(logrusHook.Type))
 		checkNotEmpty(configErrs, "logging.level", string(logrusHook.Level))
+		switch logrusHook.Format {
+		case "", "text", "json":
+		default:
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", "logging.format", logrusHook.Format))
+		}
+		for component, level := range logrusHook.Components {
+			if _, err := logrus.ParseLevel(level); err != nil {
+				configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", "logging.components."+component, level))
+			}
+		}
 	}
 }
 
@@ -472,28 +501,3 @@ func readKeyPEM(path string, data []byte, enforceKeyIDFormat bool) (gomatrixserv
 		}
 	}
 }
-
-// SetupTracing configures the opentracing using the supplied configuration.
-func (config *Dendrite) SetupTracing() (closer io.Closer, err error) {
-	if !config.Tracing.Enabled {
-		return io.NopCloser(bytes.NewReader([]byte{})), nil
-	}
-	return config.Tracing.Jaeger.InitGlobalTracer(
-		"Dendrite",
-		jaegerconfig.Logger(logrusLogger{logrus.StandardLogger()}),
-		jaegerconfig.Metrics(jaegermetrics.NullFactory),
-	)
-}
-
-// logrusLogger is a small wrapper that implements jaeger.Logger using logrus.
-type logrusLogger struct {
-	l *logrus.Logger
-}
-
-func (l logrusLogger) Error(msg string) {
-	l.l.Error(msg)
-}
-
-func (l logrusLogger) Infof(msg string, args ...interface{}) {
-	l.l.Infof(msg, args...)
-}
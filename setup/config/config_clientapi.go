@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"strings"
 	"time"
 )
 
@@ -55,6 +57,15 @@ type ClientAPI struct {
 	// Rate-limiting options
 	RateLimiting RateLimiting `yaml:"rate_limiting"`
 
+	// Options for signing in new devices from an existing session
+	LoginViaExistingSession LoginViaExistingSession `yaml:"login_via_existing_session"`
+
+	// Options for the rendezvous endpoint used to sign in by QR code
+	Rendezvous Rendezvous `yaml:"rendezvous"`
+
+	// Options for delayed events (MSC4140)
+	DelayedEvents DelayedEvents `yaml:"delayed_events"`
+
 	MSCs *MSCs `yaml:"-"`
 }
 
@@ -69,11 +80,16 @@ func (c *ClientAPI) Defaults(opts DefaultOpts) {
 	c.RegistrationDisabled = true
 	c.OpenRegistrationWithoutVerificationEnabled = false
 	c.RateLimiting.Defaults()
+	c.LoginViaExistingSession.Defaults()
+	c.Rendezvous.Defaults()
+	c.DelayedEvents.Defaults()
 }
 
 func (c *ClientAPI) Verify(configErrs *ConfigErrors) {
 	c.TURN.Verify(configErrs)
 	c.RateLimiting.Verify(configErrs)
+	c.Rendezvous.Verify(configErrs)
+	c.DelayedEvents.Verify(configErrs)
 	if c.RecaptchaEnabled {
 		if c.RecaptchaSiteVerifyAPI == "" {
 			c.RecaptchaSiteVerifyAPI = "https://www.google.com/recaptcha/api/siteverify"
@@ -133,6 +149,113 @@ func (c *TURN) Verify(configErrs *ConfigErrors) {
 	}
 }
 
+type LoginViaExistingSession struct {
+	// Whether signed-in users can request a login token with which
+	// to sign in a new device, i.e. the m.get_login_token capability
+	Enabled bool `yaml:"enabled"`
+
+	// Whether the user must authenticate again before a login
+	// token is issued
+	RequireUIAuth bool `yaml:"require_ui_auth"`
+}
+
+func (c *LoginViaExistingSession) Defaults() {
+	c.Enabled = false
+	c.RequireUIAuth = true
+}
+
+type Rendezvous struct {
+	// Whether the rendezvous endpoint is enabled, which lets two
+	// devices exchange messages to sign in by QR code without
+	// needing a third-party rendezvous server
+	Enabled bool `yaml:"enabled"`
+
+	// How long a rendezvous session lasts after it was created
+	SessionLifetime time.Duration `yaml:"session_lifetime"`
+
+	// The maximum number of sessions held at the same time. When
+	// the limit is reached, the oldest session is dropped.
+	MaxSessions int `yaml:"max_sessions"`
+
+	// The maximum number of sessions a single client address can hold
+	// at the same time, so that one client can't push everyone else's
+	// sessions out
+	MaxSessionsPerClient int `yaml:"max_sessions_per_client"`
+
+	// The maximum size of the payload of a session, in bytes
+	MaxPayloadSize int `yaml:"max_payload_size"`
+
+	// The addresses or CIDR ranges of reverse proxies in front of the
+	// server. The X-Forwarded-For and X-Forwarded-Proto headers are only
+	// honoured when the request comes from one of them.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+func (c *Rendezvous) Defaults() {
+	c.Enabled = false
+	c.SessionLifetime = time.Minute
+	c.MaxSessions = 100
+	c.MaxSessionsPerClient = 5
+	c.MaxPayloadSize = 4096
+}
+
+func (c *Rendezvous) Verify(configErrs *ConfigErrors) {
+	if c.Enabled {
+		checkPositive(configErrs, "client_api.rendezvous.session_lifetime", int64(c.SessionLifetime))
+		if c.MaxSessions <= 0 {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %d", "client_api.rendezvous.max_sessions", c.MaxSessions))
+		}
+		if c.MaxSessionsPerClient <= 0 {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %d", "client_api.rendezvous.max_sessions_per_client", c.MaxSessionsPerClient))
+		}
+		checkPositive(configErrs, "client_api.rendezvous.max_payload_size", int64(c.MaxPayloadSize))
+		if _, err := c.TrustedProxyPrefixes(); err != nil {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "client_api.rendezvous.trusted_proxies", err))
+		}
+	}
+}
+
+// TrustedProxyPrefixes parses the trusted proxies, each of which is either a
+// single address or a CIDR range.
+func (c *Rendezvous) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+type DelayedEvents struct {
+	// Whether clients may schedule events to be sent later
+	Enabled bool `yaml:"enabled"`
+
+	// The longest delay a client may ask for
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+func (c *DelayedEvents) Defaults() {
+	c.Enabled = false
+	c.MaxDelay = 24 * time.Hour
+}
+
+func (c *DelayedEvents) Verify(configErrs *ConfigErrors) {
+	if c.Enabled {
+		checkPositive(configErrs, "client_api.delayed_events.max_delay", int64(c.MaxDelay))
+	}
+}
+
 type RateLimiting struct {
 	// Is rate limiting enabled or disabled?
 	Enabled bool `yaml:"enabled"`
@@ -84,6 +84,13 @@ type Global struct {
 
 	// Configuration for the caches.
 	Cache Cache `yaml:"cache"`
+
+	// Thresholds for the readiness health check.
+	Health Health `yaml:"health"`
+
+	// SpamChecker configures an external service which is consulted before
+	// accepting events, invites, registrations and so on.
+	SpamChecker SpamChecker `yaml:"spam_checker"`
 }
 
 func (c *Global) Defaults(opts DefaultOpts) {
@@ -108,6 +115,8 @@ func (c *Global) Defaults(opts DefaultOpts) {
 	c.ServerNotices.Defaults(opts)
 	c.ReportStats.Defaults()
 	c.Cache.Defaults()
+	c.Health.Defaults()
+	c.SpamChecker.Defaults()
 }
 
 func (c *Global) Verify(configErrs *ConfigErrors) {
@@ -130,6 +139,8 @@ func (c *Global) Verify(configErrs *ConfigErrors) {
 	c.ServerNotices.Verify(configErrs)
 	c.ReportStats.Verify(configErrs)
 	c.Cache.Verify(configErrs)
+	c.Health.Verify(configErrs)
+	c.SpamChecker.Verify(configErrs)
 }
 
 func (c *Global) IsLocalServerName(serverName spec.ServerName) bool {
@@ -291,9 +302,12 @@ type ServerNotices struct {
 	AvatarURL string `yaml:"avatar_url"`
 	// The roomname to be used when creating messages
 	RoomName string `yaml:"room_name"`
+	// Pinned notices which are sent while a resource limit is exceeded
+	ResourceLimits ResourceLimitNotices `yaml:"resource_limits"`
 }
 
 func (c *ServerNotices) Defaults(opts DefaultOpts) {
+	c.ResourceLimits.Defaults()
 	if opts.Generate {
 		c.Enabled = true
 		c.LocalPart = "_server"
@@ -303,7 +317,56 @@ func (c *ServerNotices) Defaults(opts DefaultOpts) {
 	}
 }
 
-func (c *ServerNotices) Verify(errors *ConfigErrors) {}
+func (c *ServerNotices) Verify(errors *ConfigErrors) {
+	c.ResourceLimits.Verify(errors)
+}
+
+// ResourceLimitNotices defines the resource limits which, once exceeded,
+// cause a notice to be pinned in the server notices room of the affected
+// users until the limit is no longer exceeded.
+type ResourceLimitNotices struct {
+	// How often to check the resource limits
+	CheckInterval time.Duration `yaml:"check_interval"`
+	// How users can contact the server admin, usually a mailto: URI
+	AdminContact string `yaml:"admin_contact"`
+	// The number of monthly active users at which to send a notice, or 0 to disable
+	MaxMonthlyActiveUsers int64 `yaml:"max_monthly_active_users"`
+	// The size of the media store at which to send a notice, or 0 to disable
+	MaxMediaSize DataUnit `yaml:"max_media_size"`
+	// Whether to send the notices to all users rather than only to admins
+	NotifyAllUsers bool `yaml:"notify_all_users"`
+	// The messages to send for each of the limits
+	MonthlyActiveUsersMessage string `yaml:"monthly_active_users_message"`
+	MediaSizeMessage          string `yaml:"media_size_message"`
+}
+
+func (c *ResourceLimitNotices) Defaults() {
+	c.CheckInterval = 10 * time.Minute
+	c.MonthlyActiveUsersMessage = "This server has exceeded its monthly active user limit."
+	c.MediaSizeMessage = "This server has exceeded its media storage limit."
+}
+
+func (c *ResourceLimitNotices) Verify(configErrs *ConfigErrors) {
+	checkPositive(configErrs, "global.server_notices.resource_limits.max_monthly_active_users", c.MaxMonthlyActiveUsers)
+	checkPositive(configErrs, "global.server_notices.resource_limits.max_media_size", int64(c.MaxMediaSize))
+	if !c.Enabled() {
+		return
+	}
+	if c.CheckInterval <= 0 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "global.server_notices.resource_limits.check_interval", c.CheckInterval))
+	}
+	if c.MaxMonthlyActiveUsers > 0 {
+		checkNotEmpty(configErrs, "global.server_notices.resource_limits.monthly_active_users_message", c.MonthlyActiveUsersMessage)
+	}
+	if c.MaxMediaSize > 0 {
+		checkNotEmpty(configErrs, "global.server_notices.resource_limits.media_size_message", c.MediaSizeMessage)
+	}
+}
+
+// Enabled returns whether any of the resource limits are set.
+func (c *ResourceLimitNotices) Enabled() bool {
+	return c.MaxMonthlyActiveUsers > 0 || c.MaxMediaSize > 0
+}
 
 type Cache struct {
 	EstimatedMaxSize DataUnit      `yaml:"max_size_estimated"`
@@ -319,6 +382,60 @@ func (c *Cache) Verify(errors *ConfigErrors) {
 	checkPositive(errors, "max_size_estimated", int64(c.EstimatedMaxSize))
 }
 
+// Health configures the thresholds used by the readiness health check.
+type Health struct {
+	// How long each individual check may take before it is considered failed.
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// The maximum number of messages that may be waiting for any single
+	// JetStream consumer before Dendrite is considered not ready. Zero disables
+	// the check.
+	MaxConsumerPending uint64 `yaml:"max_consumer_pending"`
+	// The minimum amount of free disk space required for the media store and
+	// the JetStream storage path. Zero disables the check.
+	MinFreeDiskSpace DataUnit `yaml:"min_free_disk_space"`
+}
+
+func (c *Health) Defaults() {
+	c.CheckTimeout = time.Second * 5
+	c.MaxConsumerPending = 10000
+	c.MinFreeDiskSpace = 512 * 1024 * 1024 // 512MB
+}
+
+func (c *Health) Verify(configErrs *ConfigErrors) {
+	if c.CheckTimeout <= 0 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "global.health.check_timeout", c.CheckTimeout))
+	}
+	checkPositive(configErrs, "global.health.min_free_disk_space", int64(c.MinFreeDiskSpace))
+}
+
+// SpamChecker configures an external spam checking service. Each check is
+// POSTed as JSON to the callback name appended to the URL, e.g.
+// "https://spam.example.com/check_event_for_spam".
+type SpamChecker struct {
+	// The base URL of the service. Spam checking is disabled if empty.
+	URL string `yaml:"url"`
+	// How long to wait for the service to respond to each check.
+	Timeout time.Duration `yaml:"timeout"`
+	// Whether to allow the action if the service can't be reached or returns
+	// an invalid response. If false, the action is denied instead.
+	FailOpen bool `yaml:"fail_open"`
+}
+
+func (c *SpamChecker) Defaults() {
+	c.Timeout = time.Second * 5
+	c.FailOpen = true
+}
+
+func (c *SpamChecker) Verify(configErrs *ConfigErrors) {
+	if c.URL == "" {
+		return
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", "global.spam_checker.url", c.URL))
+	}
+	checkPositive(configErrs, "global.spam_checker.timeout", int64(c.Timeout))
+}
+
 // ReportStats configures opt-in phone-home statistics reporting.
 type ReportStats struct {
 	// Enabled configures phone-home statistics of the server
@@ -326,6 +443,43 @@ type ReportStats struct {
 
 	// Endpoint the endpoint to report stats to
 	Endpoint string `yaml:"endpoint"`
+
+	// Push configures additional endpoints, such as self-hosted dashboards,
+	// to periodically send usage statistics to. These are independent of
+	// the phone-home reporting above.
+	Push []ReportStatsPush `yaml:"push"`
+}
+
+const (
+	// ReportStatsFormatPhoneHome is the format used for phone-home reporting.
+	ReportStatsFormatPhoneHome = "phone_home"
+	// ReportStatsFormatDetailed includes breakdowns by client type, room
+	// version and so on which are not part of the phone-home format.
+	ReportStatsFormatDetailed = "detailed"
+)
+
+// ReportStatsPush configures an endpoint to push usage statistics to.
+type ReportStatsPush struct {
+	// Endpoint is the URL to POST the statistics to.
+	Endpoint string `yaml:"endpoint"`
+
+	// Interval is how often to send the statistics.
+	Interval time.Duration `yaml:"interval"`
+
+	// Format is either "phone_home" or "detailed".
+	Format string `yaml:"format"`
+}
+
+// WithDefaults returns a copy of the push configuration with the interval
+// and format filled in if they weren't given.
+func (p ReportStatsPush) WithDefaults() ReportStatsPush {
+	if p.Interval <= 0 {
+		p.Interval = time.Hour
+	}
+	if p.Format == "" {
+		p.Format = ReportStatsFormatDetailed
+	}
+	return p
 }
 
 func (c *ReportStats) Defaults() {
@@ -342,6 +496,15 @@ func (c *ReportStats) Verify(configErrs *ConfigErrors) {
 	if c.Enabled {
 		checkNotEmpty(configErrs, "global.report_stats.endpoint", c.Endpoint)
 	}
+	for i, push := range c.Push {
+		checkNotEmpty(configErrs, fmt.Sprintf("global.report_stats.push[%d].endpoint", i), push.Endpoint)
+		checkPositive(configErrs, fmt.Sprintf("global.report_stats.push[%d].interval", i), int64(push.Interval))
+		switch push.Format {
+		case "", ReportStatsFormatPhoneHome, ReportStatsFormatDetailed:
+		default:
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", fmt.Sprintf("global.report_stats.push[%d].format", i), push.Format))
+		}
+	}
 }
 
 // The configuration to use for Sentry error reporting
@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -13,10 +15,111 @@ type RoomServer struct {
 	DefaultRoomVersion gomatrixserverlib.RoomVersion `yaml:"default_room_version,omitempty"`
 
 	Database DatabaseOptions `yaml:"database,omitempty"`
+
+	// Moderation policy lists to enforce on this server.
+	PolicyLists PolicyLists `yaml:"policy_lists"`
+
+	// Background garbage collection of unreferenced state snapshots and blocks.
+	StateGC StateGC `yaml:"state_gc"`
+
+	// Merging of forward extremities by sending dummy events.
+	ExtremityPruning ExtremityPruning `yaml:"extremity_pruning"`
+}
+
+// StateGC configures the background task which deletes state snapshots that
+// are no longer referenced by any event or room, along with the state blocks
+// that only they used.
+type StateGC struct {
+	// Whether to run the state garbage collector.
+	Enabled bool `yaml:"enabled"`
+	// How long to wait between passes over all rooms.
+	Interval time.Duration `yaml:"interval"`
+	// How long to pause between rooms, so that event input isn't held up.
+	Throttle time.Duration `yaml:"throttle"`
+	// The most state snapshots to delete in a single transaction.
+	BatchSize int `yaml:"batch_size"`
+	// Rooms with at least this many state snapshots also have snapshots with
+	// identical state merged together. Set to 0 to disable.
+	DedupeThreshold int `yaml:"dedupe_threshold"`
+}
+
+func (c *StateGC) Defaults() {
+	c.Enabled = false
+	c.Interval = 24 * time.Hour
+	c.Throttle = 100 * time.Millisecond
+	c.BatchSize = 500
+	c.DedupeThreshold = 0
+}
+
+func (c *StateGC) Verify(configErrs *ConfigErrors) {
+	// The collector can be triggered through the admin API even if it isn't
+	// enabled, so the batch size always matters.
+	if c.BatchSize <= 0 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %d", "room_server.state_gc.batch_size", c.BatchSize))
+	}
+	if c.Enabled && c.Interval <= 0 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %s", "room_server.state_gc.interval", c.Interval))
+	}
+	checkPositive(configErrs, "room_server.state_gc.throttle", int64(c.Throttle))
+	checkPositive(configErrs, "room_server.state_gc.dedupe_threshold", int64(c.DedupeThreshold))
+}
+
+// ExtremityPruning configures the background task which merges the forward
+// extremities of rooms which have too many of them, by having a local member
+// send an org.matrix.dummy_event which references them.
+type ExtremityPruning struct {
+	// Whether to send dummy events automatically.
+	Enabled bool `yaml:"enabled"`
+	// Send a dummy event once a room has more than this many forward
+	// extremities.
+	Threshold int `yaml:"threshold"`
+	// The shortest time between dummy events in the same room.
+	MinInterval time.Duration `yaml:"min_interval"`
+}
+
+func (c *ExtremityPruning) Defaults() {
+	c.Enabled = false
+	c.Threshold = 10
+	c.MinInterval = time.Minute
+}
+
+func (c *ExtremityPruning) Verify(configErrs *ConfigErrors) {
+	if c.Enabled && c.Threshold < 1 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %d", "room_server.extremity_pruning.threshold", c.Threshold))
+	}
+	checkPositive(configErrs, "room_server.extremity_pruning.min_interval", int64(c.MinInterval))
+}
+
+// PolicyLists configures the policy list rooms whose m.ban recommendations
+// (m.policy.rule.user, m.policy.rule.room and m.policy.rule.server) are
+// enforced. The server must be joined to the rooms to receive the rules.
+type PolicyLists struct {
+	// The room IDs of the policy list rooms to watch.
+	Rooms []string `yaml:"rooms"`
+	// A local user which bans users matched by the policy lists from rooms
+	// in which it is joined and has enough power. Leave empty to only reject
+	// joins and invites rather than also banning existing members.
+	AutoBanUserID string `yaml:"auto_ban_user_id"`
+}
+
+func (c *PolicyLists) Verify(configErrs *ConfigErrors, matrix *Global) {
+	for i, roomID := range c.Rooms {
+		if _, err := spec.NewRoomID(roomID); err != nil {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", fmt.Sprintf("room_server.policy_lists.rooms[%d]", i), roomID))
+		}
+	}
+	if c.AutoBanUserID != "" {
+		userID, err := spec.NewUserID(c.AutoBanUserID, true)
+		if err != nil || !matrix.IsLocalServerName(userID.Domain()) {
+			configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q must be a local user ID", "room_server.policy_lists.auto_ban_user_id", c.AutoBanUserID))
+		}
+	}
 }
 
 func (c *RoomServer) Defaults(opts DefaultOpts) {
 	c.DefaultRoomVersion = gomatrixserverlib.RoomVersionV10
+	c.StateGC.Defaults()
+	c.ExtremityPruning.Defaults()
 	if opts.Generate {
 		if !opts.SingleDatabase {
 			c.Database.ConnectionString = "file:roomserver.db"
@@ -34,4 +137,8 @@ func (c *RoomServer) Verify(configErrs *ConfigErrors) {
 	} else if !gomatrixserverlib.StableRoomVersion(c.DefaultRoomVersion) {
 		log.Warnf("WARNING: Provided default room version %q is unstable", c.DefaultRoomVersion)
 	}
+
+	c.PolicyLists.Verify(configErrs, c.Matrix)
+	c.StateGC.Verify(configErrs)
+	c.ExtremityPruning.Verify(configErrs)
 }
@@ -15,13 +15,20 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jchv/maidtrix/internal/matrixserver/fclient"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
 	"gopkg.in/yaml.v2"
 )
 
@@ -174,16 +181,13 @@ mscs:
     connection_string: file:mscs.db
 tracing:
   enabled: false
-  jaeger:
-    serviceName: ""
-    disabled: false
-    rpc_metrics: false
-    tags: []
-    sampler: null
-    reporter: null
-    headers: null
-    baggage_restrictions: null
-    throttler: null
+  service_name: dendrite
+  exporter: otlp
+  sample_ratio: 1
+  otlp:
+    endpoint: localhost:4318
+    url_path: /v1/traces
+    insecure: false
 logging:
 - type: file
   level: info
@@ -322,3 +326,67 @@ func Test_SigningIdentityFor(t *testing.T) {
 		})
 	}
 }
+
+func TestSetupTracingFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	cfg := &Dendrite{}
+	cfg.Defaults(DefaultOpts{Generate: true, SingleDatabase: true})
+	cfg.Tracing.Enabled = true
+	cfg.Tracing.Exporter = TracingExporterFile
+	cfg.Tracing.FilePath = Path(path)
+
+	configErrs := &ConfigErrors{}
+	cfg.Tracing.Verify(configErrs)
+	if len(*configErrs) > 0 {
+		t.Fatalf("unexpected config errors: %v", *configErrs)
+	}
+
+	closer, err := cfg.SetupTracing()
+	if err != nil {
+		t.Fatalf("failed to set up tracing: %s", err)
+	}
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+	_, span := otel.Tracer("test").Start(context.Background(), "TestSpan")
+	span.End()
+	if err = closer.Close(); err != nil {
+		t.Fatalf("failed to close tracing: %s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read trace file: %s", err)
+	}
+	if !strings.Contains(string(data), `"Name":"TestSpan"`) {
+		t.Errorf("expected trace file to contain the span, got %s", string(data))
+	}
+}
+
+func TestTracingVerifyRejectsUnknownExporter(t *testing.T) {
+	var c Tracing
+	c.Defaults()
+	c.Enabled = true
+	c.Exporter = "jaeger"
+	configErrs := &ConfigErrors{}
+	c.Verify(configErrs)
+	if len(*configErrs) != 1 {
+		t.Fatalf("expected 1 config error, got %v", *configErrs)
+	}
+}
+
+func TestReportStatsPushWithDefaults(t *testing.T) {
+	c := ReportStats{Push: []ReportStatsPush{{Endpoint: "https://stats.example.com"}}}
+	configErrs := &ConfigErrors{}
+	c.Verify(configErrs)
+	if len(*configErrs) != 0 {
+		t.Fatalf("expected no config errors, got %v", *configErrs)
+	}
+	if c.Push[0].Interval != 0 || c.Push[0].Format != "" {
+		t.Fatalf("expected Verify not to change the push config, got %+v", c.Push[0])
+	}
+	push := c.Push[0].WithDefaults()
+	if push.Interval != time.Hour || push.Format != ReportStatsFormatDetailed {
+		t.Fatalf("unexpected defaults: %+v", push)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const (
+	// TracingExporterOTLP exports spans using OTLP over HTTP.
+	TracingExporterOTLP = "otlp"
+	// TracingExporterStdout writes spans as JSON to standard output.
+	TracingExporterStdout = "stdout"
+	// TracingExporterFile writes spans as JSON to a file, which is
+	// useful for offline testing.
+	TracingExporterFile = "file"
+)
+
+// Tracing contains the configuration for OpenTelemetry tracing.
+type Tracing struct {
+	// Set to true to enable tracer hooks. If false, no tracing is set up.
+	Enabled bool `yaml:"enabled"`
+
+	// The service name reported to the tracing backend.
+	ServiceName string `yaml:"service_name"`
+
+	// The exporter to use, one of "otlp", "stdout" or "file".
+	Exporter string `yaml:"exporter"`
+
+	// The fraction of new traces that should be sampled, between 0 and 1.
+	// Traces continued from a remote parent follow the parent's decision.
+	SampleRatio float64 `yaml:"sample_ratio"`
+
+	// Configuration for the OTLP exporter.
+	OTLP TracingOTLP `yaml:"otlp"`
+
+	// The path to write spans to when using the "file" exporter.
+	FilePath Path `yaml:"file_path"`
+}
+
+// TracingOTLP contains the configuration for exporting spans using
+// OTLP over HTTP.
+type TracingOTLP struct {
+	// The host and port of the collector, e.g. "localhost:4318".
+	Endpoint string `yaml:"endpoint"`
+
+	// The URL path that traces are sent to. Defaults to "/v1/traces".
+	URLPath string `yaml:"url_path"`
+
+	// Set to true to use plain HTTP rather than HTTPS.
+	Insecure bool `yaml:"insecure"`
+
+	// Additional headers sent with each export request, e.g. for
+	// authentication against a hosted collector.
+	Headers map[string]string `yaml:"headers"`
+
+	// How long to wait for the collector before giving up on an export.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *Tracing) Defaults() {
+	c.Enabled = false
+	c.ServiceName = "dendrite"
+	c.Exporter = TracingExporterOTLP
+	c.SampleRatio = 1.0
+	c.OTLP.Endpoint = "localhost:4318"
+	c.OTLP.URLPath = "/v1/traces"
+	c.OTLP.Timeout = time.Second * 10
+	c.FilePath = "./traces.json"
+}
+
+func (c *Tracing) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "tracing.service_name", c.ServiceName)
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %v", "tracing.sample_ratio", c.SampleRatio))
+	}
+	switch c.Exporter {
+	case TracingExporterOTLP:
+		checkNotEmpty(configErrs, "tracing.otlp.endpoint", c.OTLP.Endpoint)
+	case TracingExporterStdout:
+	case TracingExporterFile:
+		checkNotEmpty(configErrs, "tracing.file_path", string(c.FilePath))
+	default:
+		configErrs.Add(fmt.Sprintf("invalid value for config key %q: %q", "tracing.exporter", c.Exporter))
+	}
+}
+
+// SetupTracing configures OpenTelemetry using the supplied configuration and
+// registers the tracer provider and W3C trace context propagator globally.
+// The returned closer flushes any pending spans and shuts down the exporter.
+func (config *Dendrite) SetupTracing() (closer io.Closer, err error) {
+	// Always install the propagator so that trace context received from
+	// remote servers is passed along, even if we aren't recording spans.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	if !config.Tracing.Enabled {
+		return io.NopCloser(bytes.NewReader([]byte{})), nil
+	}
+
+	ctx := context.Background()
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch config.Tracing.Exporter {
+	case TracingExporterOTLP:
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(config.Tracing.OTLP.Endpoint),
+		}
+		if config.Tracing.OTLP.URLPath != "" {
+			opts = append(opts, otlptracehttp.WithURLPath(config.Tracing.OTLP.URLPath))
+		}
+		if config.Tracing.OTLP.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(config.Tracing.OTLP.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(config.Tracing.OTLP.Headers))
+		}
+		if config.Tracing.OTLP.Timeout > 0 {
+			opts = append(opts, otlptracehttp.WithTimeout(config.Tracing.OTLP.Timeout))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingExporterFile:
+		file, err = os.OpenFile(string(config.Tracing.FilePath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", config.Tracing.Exporter)
+	}
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(config.Tracing.ServiceName),
+		semconv.ServiceInstanceID(string(config.Global.ServerName)),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(
+			sdktrace.TraceIDRatioBased(config.Tracing.SampleRatio),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.WithError(err).Warn("OpenTelemetry error")
+	}))
+
+	return tracingCloser{provider: provider, file: file}, nil
+}
+
+// tracingCloser flushes and shuts down the tracer provider when closed.
+type tracingCloser struct {
+	provider *sdktrace.TracerProvider
+	file     *os.File
+}
+
+func (c tracingCloser) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err := c.provider.Shutdown(ctx)
+	if c.file != nil {
+		if ferr := c.file.Close(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
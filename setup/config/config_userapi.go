@@ -1,6 +1,10 @@
 package config
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 type UserAPI struct {
 	Matrix *Global `yaml:"-"`
@@ -25,6 +29,15 @@ type UserAPI struct {
 	// The number of workers to start for the DeviceListUpdater. Defaults to 8.
 	// This only needs updating if the "InputDeviceListUpdate" stream keeps growing indefinitely.
 	WorkerCount int `yaml:"worker_count"`
+
+	// Options for expiring accounts unless they are renewed.
+	AccountValidity AccountValidity `yaml:"account_validity"`
+
+	// Options for making users accept the policies of this homeserver.
+	UserConsent UserConsent `yaml:"user_consent"`
+
+	// Options for which users can be found in the user directory.
+	UserDirectory UserDirectory `yaml:"user_directory"`
 }
 
 const DefaultOpenIDTokenLifetimeMS = 3600000 // 60 minutes
@@ -33,6 +46,8 @@ func (c *UserAPI) Defaults(opts DefaultOpts) {
 	c.BCryptCost = bcrypt.DefaultCost
 	c.OpenIDTokenLifetimeMS = DefaultOpenIDTokenLifetimeMS
 	c.WorkerCount = 8
+	c.AccountValidity.Defaults()
+	c.UserDirectory.Defaults()
 	if opts.Generate {
 		if !opts.SingleDatabase {
 			c.AccountDatabase.ConnectionString = "file:userapi_accounts.db"
@@ -42,7 +57,152 @@ func (c *UserAPI) Defaults(opts DefaultOpts) {
 
 func (c *UserAPI) Verify(configErrs *ConfigErrors) {
 	checkPositive(configErrs, "user_api.openid_token_lifetime_ms", c.OpenIDTokenLifetimeMS)
+	c.AccountValidity.Verify(configErrs)
+	c.UserConsent.Verify(configErrs)
 	if c.Matrix.DatabaseOptions.ConnectionString == "" {
 		checkNotEmpty(configErrs, "user_api.account_database.connection_string", string(c.AccountDatabase.ConnectionString))
 	}
 }
+
+type AccountValidity struct {
+	// Whether accounts registered on this homeserver expire. Accounts
+	// which existed before this was enabled don't expire until an
+	// expiry time is set for them by an admin.
+	Enabled bool `yaml:"enabled"`
+
+	// How long an account is valid for after registering or renewing.
+	Period time.Duration `yaml:"period"`
+
+	// How long before an account expires to send the renewal email.
+	RenewAt time.Duration `yaml:"renew_at"`
+
+	// The public URL of the client API, used in the renewal link,
+	// e.g. "https://matrix.example.com".
+	PublicBaseURL string `yaml:"public_base_url"`
+
+	// How to send renewal emails. If no SMTP server is given, no
+	// emails are sent and accounts can only be renewed by admins.
+	Email AccountValidityEmail `yaml:"email"`
+}
+
+type AccountValidityEmail struct {
+	// The SMTP server to send emails through, as host:port.
+	SMTPServer string `yaml:"smtp_server"`
+
+	// Credentials for the SMTP server, if it needs authentication.
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+
+	// The address that renewal emails are sent from.
+	From string `yaml:"from"`
+
+	// The subject of renewal emails.
+	Subject string `yaml:"subject"`
+}
+
+func (c *AccountValidity) Defaults() {
+	c.Enabled = false
+	c.Period = 30 * 24 * time.Hour
+	c.RenewAt = 7 * 24 * time.Hour
+	c.Email.Subject = "Renew your Matrix account"
+}
+
+func (c *AccountValidity) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkPositive(configErrs, "user_api.account_validity.period", int64(c.Period))
+	checkPositive(configErrs, "user_api.account_validity.renew_at", int64(c.RenewAt))
+	if c.Email.SMTPServer != "" {
+		checkNotEmpty(configErrs, "user_api.account_validity.public_base_url", c.PublicBaseURL)
+		checkNotEmpty(configErrs, "user_api.account_validity.email.from", c.Email.From)
+	}
+}
+
+type UserConsent struct {
+	// Whether users must accept the policy documents. New users accept
+	// them with the m.login.terms stage when registering.
+	Enabled bool `yaml:"enabled"`
+
+	// The version of the policy documents. Change it whenever the
+	// documents change, so that users are asked to accept them again.
+	Version string `yaml:"version"`
+
+	// The policy documents, keyed by an ID such as "privacy_policy".
+	Policies map[string]PolicyDocument `yaml:"policies"`
+
+	// A page where users can read and accept the policy documents,
+	// which is given to clients when consent is needed.
+	ConsentURI string `yaml:"consent_uri"`
+
+	// Whether users who haven't accepted the current version are
+	// blocked from sending events until they do.
+	BlockUntilConsented bool `yaml:"block_until_consented"`
+
+	// The message sent as a server notice to users who haven't accepted
+	// the current version. No notices are sent if this is empty. Server
+	// notices must be enabled.
+	ServerNoticeMessage string `yaml:"server_notice_message"`
+}
+
+type PolicyDocument struct {
+	// The human-readable name of the document.
+	Name string `yaml:"name"`
+
+	// Where the document can be read.
+	URL string `yaml:"url"`
+
+	// The language of the document. Defaults to "en".
+	Language string `yaml:"language"`
+}
+
+func (c *UserConsent) Verify(configErrs *ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	checkNotEmpty(configErrs, "user_api.user_consent.version", c.Version)
+	if len(c.Policies) == 0 {
+		configErrs.Add("user_api.user_consent.policies must not be empty when user consent is enabled")
+	}
+	for id, policy := range c.Policies {
+		checkNotEmpty(configErrs, "user_api.user_consent.policies."+id+".name", policy.Name)
+		checkNotEmpty(configErrs, "user_api.user_consent.policies."+id+".url", policy.URL)
+	}
+}
+
+// TermsParams returns the parameters of the m.login.terms stage, which
+// describe the policy documents to the client.
+func (c *UserConsent) TermsParams() map[string]interface{} {
+	policies := make(map[string]interface{}, len(c.Policies))
+	for id, policy := range c.Policies {
+		language := policy.Language
+		if language == "" {
+			language = "en"
+		}
+		policies[id] = map[string]interface{}{
+			"version": c.Version,
+			language: map[string]string{
+				"name": policy.Name,
+				"url":  policy.URL,
+			},
+		}
+	}
+	return map[string]interface{}{
+		"policies": policies,
+	}
+}
+
+type UserDirectory struct {
+	// Whether searching the user directory returns all local users. Otherwise
+	// only users who share a room with the searcher, or who are in a public
+	// room, are returned.
+	SearchAllUsers bool `yaml:"search_all_users"`
+
+	// Whether searching the user directory returns remote users who are in a
+	// public room.
+	IncludeRemoteUsers bool `yaml:"include_remote_users"`
+}
+
+func (c *UserDirectory) Defaults() {
+	c.IncludeRemoteUsers = true
+}
@@ -0,0 +1,68 @@
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	natsclient "github.com/nats-io/nats.go"
+
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/setup/process"
+)
+
+// registerHealthChecks adds readiness checks for the NATS connection, the
+// backlog of our durable consumers and, when running the embedded server
+// with on-disk storage, the free space on the JetStream storage path.
+func registerHealthChecks(
+	processCtx *process.ProcessContext, cfg *config.JetStream,
+	js natsclient.JetStreamContext, nc *natsclient.Conn,
+) {
+	if processCtx == nil {
+		return
+	}
+	processCtx.RegisterHealthCheck("jetstream", func(ctx context.Context) error {
+		if status := nc.Status(); status != natsclient.CONNECTED {
+			return fmt.Errorf("NATS connection is %s", status)
+		}
+		if _, err := js.AccountInfo(natsclient.Context(ctx)); err != nil {
+			return fmt.Errorf("js.AccountInfo: %w", err)
+		}
+		return nil
+	})
+	if cfg.Matrix == nil {
+		return
+	}
+	health := cfg.Matrix.Health
+	if health.MaxConsumerPending > 0 {
+		processCtx.RegisterHealthCheck("jetstream consumers", func(ctx context.Context) error {
+			return checkConsumerPending(ctx, js, cfg, health.MaxConsumerPending)
+		})
+	}
+	if len(cfg.Addresses) == 0 && !cfg.InMemory && health.MinFreeDiskSpace > 0 {
+		processCtx.RegisterHealthCheck(
+			"jetstream disk space",
+			process.DiskSpaceCheck(string(cfg.StoragePath), uint64(health.MinFreeDiskSpace)),
+		)
+	}
+}
+
+// checkConsumerPending returns an error listing any durable consumers on our
+// streams which have more than max messages waiting to be delivered.
+func checkConsumerPending(ctx context.Context, js natsclient.JetStreamContext, cfg *config.JetStream, max uint64) error {
+	var lagging []string
+	for _, stream := range streams { // streams are defined in streams.go
+		for info := range js.Consumers(cfg.Prefixed(stream.Name), natsclient.Context(ctx)) {
+			if info.Config.Durable != "" && info.NumPending > max {
+				lagging = append(lagging, fmt.Sprintf("%s (%d pending)", info.Name, info.NumPending))
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(lagging) > 0 {
+		return fmt.Errorf("consumers exceed %d pending messages: %s", max, strings.Join(lagging, ", "))
+	}
+	return nil
+}
@@ -4,12 +4,51 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var consumerPending = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "jetstream",
+		Name:      "consumer_pending_messages",
+		Help:      "How many messages are waiting to be delivered to a durable consumer",
+	},
+	[]string{"durable"},
+)
+
+var consumerLag = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "jetstream",
+		Name:      "consumer_lag_seconds",
+		Help:      "How long ago the most recently delivered message to a durable consumer was published",
+	},
+	[]string{"durable"},
+)
+
+func init() {
+	prometheus.MustRegister(consumerPending, consumerLag)
+}
+
+// observeConsumerLag updates the pending and lag metrics for the durable
+// consumer from the metadata of the last message in the batch.
+func observeConsumerLag(durable string, msgs []*nats.Msg) {
+	meta, err := msgs[len(msgs)-1].Metadata()
+	if err != nil {
+		return
+	}
+	consumerPending.WithLabelValues(durable).Set(float64(meta.NumPending))
+	consumerLag.WithLabelValues(durable).Set(time.Since(meta.Timestamp).Seconds())
+}
+
 // JetStreamConsumer starts a durable consumer on the given subject with the
 // given durable name. The function will be called when one or more messages
 // is available, up to the maximum batch size specified. If the batch is set to
@@ -90,6 +129,7 @@ func JetStreamConsumer(
 			if len(msgs) < 1 {
 				continue
 			}
+			observeConsumerLag(durable, msgs)
 			for _, msg := range msgs {
 				if err = msg.InProgress(nats.Context(ctx)); err != nil {
 					logrus.WithContext(ctx).WithField("subject", subj).Warn(fmt.Errorf("msg.InProgress: %w", err))
@@ -97,7 +137,15 @@ func JetStreamConsumer(
 					continue
 				}
 			}
-			if f(ctx, msgs) {
+			spanCtx, spanOpts := consumerSpanOptions(ctx, msgs)
+			spanCtx, span := otel.Tracer(tracerName).Start(spanCtx, "consume "+durable, spanOpts...)
+			span.SetAttributes(
+				attribute.String("messaging.destination.name", subj),
+				attribute.Int("messaging.batch.message_count", len(msgs)),
+			)
+			ok := f(spanCtx, msgs)
+			span.End()
+			if ok {
 				for _, msg := range msgs {
 					if err = msg.AckSync(nats.Context(ctx)); err != nil {
 						logrus.WithContext(ctx).WithField("subject", subj).Warn(fmt.Errorf("msg.AckSync: %w", err))
@@ -43,6 +43,7 @@ func (s *NATSInstance) Prepare(process *process.ProcessContext, cfg *config.JetS
 			return s.js, s.nc
 		}
 		s.js, s.nc = setupNATS(process, cfg, nil)
+		registerHealthChecks(process, cfg, s.js, s.nc)
 		return s.js, s.nc
 	}
 	if s.Server == nil {
@@ -90,6 +91,7 @@ func (s *NATSInstance) Prepare(process *process.ProcessContext, cfg *config.JetS
 	js, _ := setupNATS(process, cfg, nc)
 	s.js = js
 	s.nc = nc
+	registerHealthChecks(process, cfg, js, nc)
 	return js, nc
 }
 
@@ -0,0 +1,91 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jetstream
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name used for spans created while
+// consuming from JetStream.
+const tracerName = "github.com/jchv/maidtrix/setup/jetstream"
+
+// headerCarrier adapts NATS message headers so that they can be used
+// with OpenTelemetry propagators.
+type headerCarrier nats.Header
+
+func (c headerCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+var _ propagation.TextMapCarrier = headerCarrier{}
+
+// InjectTraceContext adds the trace context from the given context to the
+// headers of the message, so that consumers of the message can continue the
+// same trace.
+func InjectTraceContext(ctx context.Context, msg *nats.Msg) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Header))
+}
+
+// ExtractTraceContext returns a copy of the given context which carries the
+// trace context found in the message headers, if any.
+func ExtractTraceContext(ctx context.Context, msg *nats.Msg) context.Context {
+	if msg.Header == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Header))
+}
+
+// consumerSpanOptions returns the span options for processing a batch of
+// messages. The span continues the trace of the first message and links to
+// the traces of any further messages in the batch.
+func consumerSpanOptions(ctx context.Context, msgs []*nats.Msg) (context.Context, []trace.SpanStartOption) {
+	opts := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindConsumer)}
+	if len(msgs) == 0 {
+		return ctx, opts
+	}
+	parent := ExtractTraceContext(ctx, msgs[0])
+	links := make([]trace.Link, 0, len(msgs)-1)
+	for _, msg := range msgs[1:] {
+		sc := trace.SpanContextFromContext(ExtractTraceContext(context.Background(), msg))
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	if len(links) > 0 {
+		opts = append(opts, trace.WithLinks(links...))
+	}
+	return parent, opts
+}
@@ -0,0 +1,71 @@
+package jetstream
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background()) // nolint: errcheck
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "produce")
+	defer span.End()
+
+	msg := nats.NewMsg("subject")
+	InjectTraceContext(ctx, msg)
+	if msg.Header.Get("traceparent") == "" {
+		t.Fatalf("expected traceparent header to be set")
+	}
+
+	extracted := trace.SpanContextFromContext(ExtractTraceContext(context.Background(), msg))
+	if !extracted.IsRemote() {
+		t.Errorf("expected extracted span context to be remote")
+	}
+	if extracted.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("expected trace ID %s, got %s", span.SpanContext().TraceID(), extracted.TraceID())
+	}
+	if extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("expected span ID %s, got %s", span.SpanContext().SpanID(), extracted.SpanID())
+	}
+}
+
+func TestConsumerSpanOptionsLinksBatch(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	provider := sdktrace.NewTracerProvider()
+	defer provider.Shutdown(context.Background()) // nolint: errcheck
+
+	var msgs []*nats.Msg
+	var spans []trace.Span
+	for i := 0; i < 3; i++ {
+		ctx, span := provider.Tracer("test").Start(context.Background(), "produce")
+		span.End()
+		msg := nats.NewMsg("subject")
+		InjectTraceContext(ctx, msg)
+		msgs = append(msgs, msg)
+		spans = append(spans, span)
+	}
+	// A message without any trace context shouldn't produce a link.
+	msgs = append(msgs, nats.NewMsg("subject"))
+
+	parent, opts := consumerSpanOptions(context.Background(), msgs)
+	if got := trace.SpanContextFromContext(parent).TraceID(); got != spans[0].SpanContext().TraceID() {
+		t.Errorf("expected parent trace ID %s, got %s", spans[0].SpanContext().TraceID(), got)
+	}
+	cfg := trace.NewSpanStartConfig(opts...)
+	if len(cfg.Links()) != 2 {
+		t.Fatalf("expected 2 links, got %d", len(cfg.Links()))
+	}
+	for i, link := range cfg.Links() {
+		if link.SpanContext.TraceID() != spans[i+1].SpanContext().TraceID() {
+			t.Errorf("link %d: expected trace ID %s, got %s", i, spans[i+1].SpanContext().TraceID(), link.SpanContext.TraceID())
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DiskSpaceCheck returns a health check which fails if the filesystem
+// holding the given path has less than minFree bytes available. If the
+// path doesn't exist yet then the nearest parent that does is checked
+// instead. On platforms where free space can't be determined the check
+// always passes.
+func DiskSpaceCheck(path string, minFree uint64) HealthCheck {
+	return func(ctx context.Context) error {
+		dir := existingParent(path)
+		free, err := freeDiskSpace(dir)
+		if err != nil {
+			return fmt.Errorf("unable to determine free space for %q: %w", path, err)
+		}
+		if free < minFree {
+			return fmt.Errorf("only %d bytes free for %q, want at least %d", free, path, minFree)
+		}
+		return nil
+	}
+}
+
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,48 @@
+package process
+
+import (
+	"context"
+	"sort"
+)
+
+// HealthCheck probes a single dependency of Dendrite, such as a database
+// connection, and returns an error if it is not usable.
+type HealthCheck func(ctx context.Context) error
+
+// RegisterHealthCheck adds a check which must pass for Dendrite to be
+// considered ready to serve requests. Registering a check with a name
+// that is already in use replaces the existing check.
+func (b *ProcessContext) RegisterHealthCheck(name string, check HealthCheck) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.healthChecks == nil {
+		b.healthChecks = map[string]HealthCheck{}
+	}
+	b.healthChecks[name] = check
+}
+
+// HealthCheckNames returns the names of all registered health checks in
+// sorted order.
+func (b *ProcessContext) HealthCheckNames() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.healthChecks))
+	for name := range b.healthChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HealthCheck returns the health check registered with the given name.
+func (b *ProcessContext) HealthCheck(name string) (HealthCheck, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	check, ok := b.healthChecks[name]
+	return check, ok
+}
+
+// IsShuttingDown returns true once Dendrite has been asked to shut down.
+func (b *ProcessContext) IsShuttingDown() bool {
+	return b.ctx.Err() != nil
+}
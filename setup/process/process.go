@@ -14,6 +14,8 @@ type ProcessContext struct {
 	ctx      context.Context     // cancelled when Stop is called
 	shutdown context.CancelFunc  // shut down Dendrite
 	degraded map[string]struct{} // reasons why the process is degraded
+
+	healthChecks map[string]HealthCheck // checks used for readiness
 }
 
 func NewProcessContext() *ProcessContext {
@@ -140,6 +140,8 @@ func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msgs []*nats.Ms
 		s.onRetirePeek(s.ctx, *output.RetirePeek)
 	case api.OutputTypeRedactedEvent:
 		err = s.onRedactEvent(s.ctx, *output.RedactedEvent)
+	case api.OutputTypeRoomStateResynced:
+		err = s.onRoomStateResynced(s.ctx, *output.RoomStateResynced)
 	case api.OutputTypePurgeRoom:
 		err = s.onPurgeRoom(s.ctx, *output.PurgeRoom)
 		if err != nil {
@@ -523,6 +525,36 @@ func (s *OutputRoomEventConsumer) onRetirePeek(
 	s.notifier.OnRetirePeek(msg.RoomID, msg.UserID, msg.DeviceID, types.StreamingToken{PDUPosition: sp})
 }
 
+// onRoomStateResynced fills in the current state of a room that was joined
+// with partial state, now that the roomserver has fetched the full state.
+func (s *OutputRoomEventConsumer) onRoomStateResynced(
+	ctx context.Context, msg api.OutputRoomStateResynced,
+) error {
+	var addsStateEvents []*rstypes.HeaderedEvent
+	if len(msg.AddsStateEventIDs) > 0 {
+		eventsReq := &api.QueryEventsByIDRequest{
+			RoomID:   msg.RoomID,
+			EventIDs: msg.AddsStateEventIDs,
+		}
+		eventsRes := &api.QueryEventsByIDResponse{}
+		if err := s.rsAPI.QueryEventsByID(ctx, eventsReq, eventsRes); err != nil {
+			return fmt.Errorf("s.rsAPI.QueryEventsByID: %w", err)
+		}
+		for _, event := range eventsRes.Events {
+			ev, err := s.updateStateEvent(event)
+			if err != nil {
+				return err
+			}
+			addsStateEvents = append(addsStateEvents, ev)
+		}
+	}
+	if err := s.db.UpdateRoomStateAfterResync(ctx, msg.RoomID, addsStateEvents, msg.RemovesStateEventIDs); err != nil {
+		return fmt.Errorf("s.db.UpdateRoomStateAfterResync: %w", err)
+	}
+	log.WithField("room_id", msg.RoomID).Infof("Updated room state after partial state resync")
+	return nil
+}
+
 func (s *OutputRoomEventConsumer) onPurgeRoom(
 	ctx context.Context, req api.OutputPurgeRoom,
 ) error {
@@ -32,7 +32,7 @@ import (
 	"github.com/jchv/maidtrix/userapi/api"
 )
 
-// DeviceOTKCounts adds one-time key counts to the /sync response
+// DeviceOTKCounts adds one-time key counts and unused fallback key types to the /sync response
 func DeviceOTKCounts(ctx context.Context, keyAPI api.SyncKeyAPI, userID, deviceID string, res *types.Response) error {
 	var queryRes api.QueryOneTimeKeysResponse
 	_ = keyAPI.QueryOneTimeKeys(ctx, &api.QueryOneTimeKeysRequest{
@@ -43,6 +43,9 @@ func DeviceOTKCounts(ctx context.Context, keyAPI api.SyncKeyAPI, userID, deviceI
 		return queryRes.Error
 	}
 	res.DeviceListsOTKCount = queryRes.Count.KeyCount
+	if queryRes.UnusedFallbackAlgorithms != nil {
+		res.DeviceUnusedFallbackKeyTypes = queryRes.UnusedFallbackAlgorithms
+	}
 	return nil
 }
 
@@ -25,9 +25,36 @@ import (
 	rstypes "github.com/jchv/maidtrix/roomserver/types"
 	"github.com/jchv/maidtrix/syncapi/storage"
 	"github.com/jchv/maidtrix/syncapi/types"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+func init() {
+	prometheus.MustRegister(userDeviceStreamsCount, userDeviceStreamListeners)
+}
+
+// userDeviceStreamsCount tracks how many user device streams the notifier
+// is currently holding on to.
+var userDeviceStreamsCount = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "syncapi",
+		Name:      "notifier_streams",
+		Help:      "Number of user device streams held by the sync notifier",
+	},
+)
+
+// userDeviceStreamListeners tracks how many sync requests are currently
+// waiting on a user device stream for new data.
+var userDeviceStreamListeners = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "dendrite",
+		Subsystem: "syncapi",
+		Name:      "notifier_listeners",
+		Help:      "Number of sync requests waiting on the sync notifier for new data",
+	},
+)
+
 // NOTE: ALL FUNCTIONS IN THIS FILE PREFIXED WITH _ ARE NOT THREAD-SAFE
 // AND MUST ONLY BE CALLED WHEN THE NOTIFIER LOCK IS HELD!
 
@@ -470,6 +497,7 @@ func (n *Notifier) _fetchUserDeviceStream(userID, deviceID string, makeIfNotExis
 		// TODO: Unbounded growth of streams (1 per user)
 		if stream = NewUserDeviceStream(userID, deviceID, n.currPos); stream != nil {
 			n.userDeviceStreams[userID][deviceID] = stream
+			userDeviceStreamsCount.Inc()
 		}
 	}
 	return stream
@@ -566,6 +594,7 @@ func (n *Notifier) _removeEmptyUserStreams() {
 		for device, stream := range byUser {
 			if stream.TimeOfLastNonEmpty().Before(deleteBefore) {
 				delete(n.userDeviceStreams[user], device)
+				userDeviceStreamsCount.Dec()
 			}
 			if len(n.userDeviceStreams[user]) == 0 {
 				delete(n.userDeviceStreams, user)
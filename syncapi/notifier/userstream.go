@@ -69,6 +69,7 @@ func (s *UserDeviceStream) GetListener(ctx context.Context) UserDeviceStreamList
 	defer s.lock.Unlock()
 
 	s.numWaiting++ // We decrement when UserStreamListener is closed
+	userDeviceStreamListeners.Inc()
 
 	listener := UserDeviceStreamListener{
 		userStream: s,
@@ -161,6 +162,7 @@ func (s *UserDeviceStreamListener) Close() {
 
 	if !s.hasClosed {
 		s.userStream.numWaiting--
+		userDeviceStreamListeners.Dec()
 		s.userStream.timeOfLastChannel = time.Now()
 	}
 
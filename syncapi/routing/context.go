@@ -206,10 +206,11 @@ func Context(
 		}
 	}
 
-	eventsBeforeClient := synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(eventsBeforeFiltered), synctypes.FormatAll, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+	eventFormat := roomEventFormat(filter)
+	eventsBeforeClient := synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(eventsBeforeFiltered), eventFormat, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 		return rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
 	})
-	eventsAfterClient := synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(eventsAfterFiltered), synctypes.FormatAll, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+	eventsAfterClient := synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(eventsAfterFiltered), eventFormat, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 		return rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
 	})
 
@@ -230,14 +231,21 @@ func Context(
 		}
 	}
 
-	ev := synctypes.ToClientEventDefault(func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+	ev, err := synctypes.ToClientEvent(requestedEvent, eventFormat, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 		return rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
-	}, requestedEvent)
+	})
+	if err != nil {
+		logrus.WithError(err).Error("unable to convert requested event")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
 	response := ContextRespsonse{
-		Event:        &ev,
+		Event:        ev,
 		EventsAfter:  eventsAfterClient,
 		EventsBefore: eventsBeforeClient,
-		State: synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(newState), synctypes.FormatAll, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		State: synctypes.ToClientEvents(gomatrixserverlib.ToPDUs(newState), eventFormat, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
 			return rsAPI.QueryUserIDForSender(ctx, roomID, senderID)
 		}),
 	}
@@ -245,6 +253,13 @@ func Context(
 	if len(response.State) > filter.Limit {
 		response.State = response.State[len(response.State)-filter.Limit:]
 	}
+	fields := synctypes.ParseEventFields(filter.EventFields)
+	requested := []synctypes.ClientEvent{*response.Event}
+	synctypes.ApplyEventFields(requested, fields)
+	response.Event = &requested[0]
+	synctypes.ApplyEventFields(response.EventsBefore, fields)
+	synctypes.ApplyEventFields(response.EventsAfter, fields)
+	synctypes.ApplyEventFields(response.State, fields)
 	start, end, err := getStartEnd(ctx, snapshot, eventsBefore, eventsAfter)
 	if err == nil {
 		response.End = end.String()
@@ -370,6 +385,17 @@ func parseRoomEventFilter(req *http.Request) (*synctypes.RoomEventFilter, error)
 			return nil, err
 		}
 	}
+	if filter.EventFormat != "" && filter.EventFormat != synctypes.EventFormatClient && filter.EventFormat != synctypes.EventFormatFederation {
+		return nil, fmt.Errorf("invalid event_format %q", filter.EventFormat)
+	}
 
 	return filter, nil
 }
+
+// roomEventFormat returns the client event format requested by the filter.
+func roomEventFormat(filter *synctypes.RoomEventFilter) synctypes.ClientEventFormat {
+	if filter.EventFormat == synctypes.EventFormatFederation {
+		return synctypes.FormatSyncFederation
+	}
+	return synctypes.FormatAll
+}
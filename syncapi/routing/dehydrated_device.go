@@ -0,0 +1,124 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package routing
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/jchv/maidtrix/clientapi/httputil"
+	"github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/syncapi/storage"
+	"github.com/jchv/maidtrix/syncapi/types"
+	userapi "github.com/jchv/maidtrix/userapi/api"
+)
+
+type dehydratedDeviceEventsRequest struct {
+	NextBatch string `json:"next_batch"`
+}
+
+type dehydratedDeviceEventsResponse struct {
+	Events    []gomatrixserverlib.SendToDeviceEvent `json:"events"`
+	NextBatch string                                `json:"next_batch"`
+}
+
+// GetDehydratedDeviceEvents implements POST /dehydrated_device/{deviceID}/events,
+// as per MSC3814. It returns the to-device messages queued for the user's
+// dehydrated device. Passing the next_batch token from a previous response
+// acknowledges, and deletes, the messages that were already returned.
+func GetDehydratedDeviceEvents(
+	req *http.Request, device *userapi.Device, deviceID string,
+	syncDB storage.Database, userAPI userapi.SyncUserAPI,
+) util.JSONResponse {
+	var r dehydratedDeviceEventsRequest
+	if resErr := httputil.UnmarshalJSONRequest(req, &r); resErr != nil {
+		return *resErr
+	}
+	var from types.StreamPosition
+	if r.NextBatch != "" {
+		pos, err := strconv.ParseInt(r.NextBatch, 10, 64)
+		if err != nil || pos < 0 {
+			return util.JSONResponse{
+				Code: http.StatusBadRequest,
+				JSON: spec.InvalidParam("next_batch is not valid"),
+			}
+		}
+		from = types.StreamPosition(pos)
+	}
+
+	var queryRes userapi.QueryDehydratedDeviceResponse
+	if err := userAPI.QueryDehydratedDevice(req.Context(), &userapi.QueryDehydratedDeviceRequest{
+		UserID: device.UserID,
+	}, &queryRes); err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("userAPI.QueryDehydratedDevice failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	if !queryRes.Exists || queryRes.DeviceID != deviceID {
+		return util.JSONResponse{
+			Code: http.StatusForbidden,
+			JSON: spec.Forbidden("Not a dehydrated device of this user"),
+		}
+	}
+
+	if from > 0 {
+		if err := syncDB.CleanSendToDeviceUpdates(req.Context(), device.UserID, deviceID, from); err != nil {
+			util.GetLogger(req.Context()).WithError(err).Error("syncDB.CleanSendToDeviceUpdates failed")
+			return util.JSONResponse{
+				Code: http.StatusInternalServerError,
+				JSON: spec.InternalServerError{},
+			}
+		}
+	}
+
+	snapshot, err := syncDB.NewDatabaseSnapshot(req.Context())
+	if err != nil {
+		util.GetLogger(req.Context()).WithError(err).Error("Failed to get snapshot for dehydrated device events")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	}
+	var succeeded bool
+	defer sqlutil.EndTransactionWithCheck(snapshot, &succeeded, &err)
+
+	to, err := snapshot.MaxStreamPositionForSendToDeviceMessages(req.Context())
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+	lastPos, events, err := snapshot.SendToDeviceUpdatesForSync(req.Context(), device.UserID, deviceID, from, to)
+	if err != nil {
+		return util.ErrorResponse(err)
+	}
+
+	res := dehydratedDeviceEventsResponse{
+		Events:    make([]gomatrixserverlib.SendToDeviceEvent, 0, len(events)),
+		NextBatch: strconv.FormatInt(int64(lastPos), 10),
+	}
+	for _, event := range events {
+		res.Events = append(res.Events, event.SendToDeviceEvent)
+	}
+
+	succeeded = true
+	return util.JSONResponse{
+		Code: http.StatusOK,
+		JSON: res,
+	}
+}
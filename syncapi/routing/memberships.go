@@ -17,6 +17,7 @@ package routing
 import (
 	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
@@ -68,6 +69,29 @@ func GetMemberships(
 		}
 	}
 
+	// If the room was joined with partial state then we don't know the full
+	// member list yet, so give the roomserver a chance to finish fetching it.
+	validRoomID, err := spec.NewRoomID(roomID)
+	if err != nil {
+		return util.JSONResponse{
+			Code: http.StatusBadRequest,
+			JSON: spec.InvalidParam("invalid room ID"),
+		}
+	}
+	if fullState, waitErr := api.WaitForFullState(req.Context(), rsAPI, *validRoomID, api.PartialStateWaitTimeout); waitErr != nil {
+		util.GetLogger(req.Context()).WithError(waitErr).Error("api.WaitForFullState failed")
+		return util.JSONResponse{
+			Code: http.StatusInternalServerError,
+			JSON: spec.InternalServerError{},
+		}
+	} else if !fullState {
+		return util.JSONResponse{
+			Code:    http.StatusServiceUnavailable,
+			JSON:    spec.LimitExceeded("The member list of this room is still being fetched, try again later", api.PartialStateRetryAfter.Milliseconds()),
+			Headers: map[string]string{"Retry-After": strconv.Itoa(int(api.PartialStateRetryAfter.Seconds()))},
+		}
+	}
+
 	db, err := syncDB.NewDatabaseSnapshot(req.Context())
 	if err != nil {
 		return util.JSONResponse{
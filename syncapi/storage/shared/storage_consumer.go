@@ -299,6 +299,28 @@ func (d *Database) WriteEvent(
 	return pduPosition, returnErr
 }
 
+// UpdateRoomStateAfterResync updates the current state of a room that was
+// joined with partial state once the roomserver has fetched the full state.
+// The state changes aren't tied to a new event, so they are recorded at the
+// latest stream position in the room.
+func (d *Database) UpdateRoomStateAfterResync(
+	ctx context.Context, roomID string,
+	addStateEvents []*rstypes.HeaderedEvent, removeStateEventIDs []string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		maxID, err := d.OutputEvents.SelectMaxEventID(ctx, txn)
+		if err != nil {
+			return fmt.Errorf("d.OutputEvents.SelectMaxEventID: %w", err)
+		}
+		pduPosition := types.StreamPosition(maxID)
+		topoPosition, err := d.Topology.SelectStreamToTopologicalPosition(ctx, txn, roomID, pduPosition, false)
+		if err != nil {
+			return fmt.Errorf("d.Topology.SelectStreamToTopologicalPosition: %w", err)
+		}
+		return d.updateRoomState(ctx, txn, removeStateEventIDs, addStateEvents, pduPosition, topoPosition)
+	})
+}
+
 // This function should always be called within a sqlutil.Writer for safety in SQLite.
 func (d *Database) updateRoomState(
 	ctx context.Context, txn *sql.Tx,
@@ -532,17 +554,17 @@ func getMembershipFromEvent(ctx context.Context, ev gomatrixserverlib.PDU, userI
 }
 
 // StoreReceipt stores user receipts
-func (d *Database) StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId string, timestamp spec.Timestamp) (pos types.StreamPosition, err error) {
+func (d *Database) StoreReceipt(ctx context.Context, roomId, receiptType, userId, eventId, threadId string, timestamp spec.Timestamp) (pos types.StreamPosition, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		pos, err = d.Receipts.UpsertReceipt(ctx, txn, roomId, receiptType, userId, eventId, timestamp)
+		pos, err = d.Receipts.UpsertReceipt(ctx, txn, roomId, receiptType, userId, eventId, threadId, timestamp)
 		return err
 	})
 	return
 }
 
-func (d *Database) UpsertRoomUnreadNotificationCounts(ctx context.Context, userID, roomID string, notificationCount, highlightCount int) (pos types.StreamPosition, err error) {
+func (d *Database) UpsertRoomUnreadNotificationCounts(ctx context.Context, userID, roomID string, notificationCount, highlightCount int, threadCounts map[string]eventutil.ThreadNotificationData) (pos types.StreamPosition, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		pos, err = d.NotificationData.UpsertRoomUnreadCounts(ctx, txn, userID, roomID, notificationCount, highlightCount)
+		pos, err = d.NotificationData.UpsertRoomUnreadCounts(ctx, txn, userID, roomID, notificationCount, highlightCount, threadCounts)
 		return err
 	})
 	return
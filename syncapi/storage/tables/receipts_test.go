@@ -0,0 +1,87 @@
+package tables_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/syncapi/storage/postgres"
+	"github.com/jchv/maidtrix/syncapi/storage/sqlite3"
+	"github.com/jchv/maidtrix/syncapi/storage/tables"
+	"github.com/jchv/maidtrix/test"
+)
+
+func mustReceiptsTable(t *testing.T, dbType test.DBType) (tables.Receipts, func()) {
+	t.Helper()
+	connStr, close := test.PrepareDBConnectionString(t, dbType)
+	db, err := sqlutil.Open(&config.DatabaseOptions{
+		ConnectionString: config.DataSource(connStr),
+	}, sqlutil.NewExclusiveWriter())
+	if err != nil {
+		t.Fatalf("failed to open db: %s", err)
+	}
+
+	var tab tables.Receipts
+	switch dbType {
+	case test.DBTypePostgres:
+		tab, err = postgres.NewPostgresReceiptsTable(db)
+	case test.DBTypeSQLite:
+		var stream sqlite3.StreamIDStatements
+		if err = stream.Prepare(db); err != nil {
+			t.Fatalf("failed to prepare stream stmts: %s", err)
+		}
+		tab, err = sqlite3.NewSqliteReceiptsTable(db, &stream)
+	}
+	if err != nil {
+		t.Fatalf("failed to make new table: %s", err)
+	}
+	return tab, close
+}
+
+func TestThreadedReceipts(t *testing.T) {
+	alice := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	ctx := context.Background()
+	timestamp := spec.AsTimestamp(time.Now())
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		tab, closeDB := mustReceiptsTable(t, dbType)
+		defer closeDB()
+
+		// An unthreaded receipt and receipts for two threads can exist side by side.
+		for _, r := range []struct{ eventID, threadID string }{
+			{"$unthreaded", ""},
+			{"$main", "main"},
+			{"$inthread", "$threadroot"},
+		} {
+			if _, err := tab.UpsertReceipt(ctx, nil, room.ID, "m.read", alice.ID, r.eventID, r.threadID, timestamp); err != nil {
+				t.Fatalf("failed to upsert receipt: %s", err)
+			}
+		}
+		// Moving the receipt in a thread replaces the previous one for that thread only.
+		if _, err := tab.UpsertReceipt(ctx, nil, room.ID, "m.read", alice.ID, "$inthread2", "$threadroot", timestamp); err != nil {
+			t.Fatalf("failed to upsert receipt: %s", err)
+		}
+
+		_, receipts, err := tab.SelectRoomReceiptsAfter(ctx, nil, []string{room.ID}, 0)
+		if err != nil {
+			t.Fatalf("failed to select receipts: %s", err)
+		}
+		got := map[string]string{}
+		for _, r := range receipts {
+			got[r.ThreadID] = r.EventID
+		}
+		want := map[string]string{"": "$unthreaded", "main": "$main", "$threadroot": "$inthread2"}
+		if len(got) != len(want) || len(receipts) != len(want) {
+			t.Fatalf("expected receipts %v, got %v", want, receipts)
+		}
+		for threadID, eventID := range want {
+			if got[threadID] != eventID {
+				t.Errorf("expected receipt for thread %q to be %s, got %s", threadID, eventID, got[threadID])
+			}
+		}
+	})
+}
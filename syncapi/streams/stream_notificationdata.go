@@ -51,14 +51,35 @@ func (p *NotificationDataStreamProvider) IncrementalSync(
 	}
 
 	// We're merely decorating existing rooms.
+	threaded := req.Filter.Room.Timeline.UnreadThreadNotifications
 	for roomID, jr := range req.Response.Rooms.Join {
 		counts := countsByRoom[roomID]
 		if counts == nil {
 			counts = &eventutil.NotificationData{}
 		}
-		jr.UnreadNotifications = &types.UnreadNotifications{
-			HighlightCount:    counts.UnreadHighlightCount,
-			NotificationCount: counts.UnreadNotificationCount,
+		if !threaded {
+			jr.UnreadNotifications = &types.UnreadNotifications{
+				HighlightCount:    counts.UnreadHighlightCount,
+				NotificationCount: counts.UnreadNotificationCount,
+			}
+		} else {
+			// The room counts only cover the main timeline when the client
+			// has asked for the threads to be counted separately.
+			main := counts.UnreadThreadNotifications[eventutil.MainThreadID]
+			jr.UnreadNotifications = &types.UnreadNotifications{
+				HighlightCount:    main.UnreadHighlightCount,
+				NotificationCount: main.UnreadNotificationCount,
+			}
+			jr.UnreadThreadNotifications = make(map[string]*types.UnreadNotifications, len(counts.UnreadThreadNotifications))
+			for threadID, threadCounts := range counts.UnreadThreadNotifications {
+				if threadID == eventutil.MainThreadID {
+					continue
+				}
+				jr.UnreadThreadNotifications[threadID] = &types.UnreadNotifications{
+					HighlightCount:    threadCounts.UnreadHighlightCount,
+					NotificationCount: threadCounts.UnreadNotificationCount,
+				}
+			}
 		}
 		req.Response.Rooms.Join[roomID] = jr
 	}
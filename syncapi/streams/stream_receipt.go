@@ -98,7 +98,7 @@ func (p *ReceiptStreamProvider) IncrementalSync(
 					User: make(map[string]ReceiptTS),
 				}
 			}
-			read.User[receipt.UserID] = ReceiptTS{TS: receipt.Timestamp}
+			read.User[receipt.UserID] = ReceiptTS{TS: receipt.Timestamp, ThreadID: receipt.ThreadID}
 			content[receipt.EventID] = read
 		}
 		ev.Content, err = json.Marshal(content)
@@ -119,5 +119,6 @@ type ReceiptMRead struct {
 }
 
 type ReceiptTS struct {
-	TS spec.Timestamp `json:"ts"`
+	TS       spec.Timestamp `json:"ts"`
+	ThreadID string         `json:"thread_id,omitempty"`
 }
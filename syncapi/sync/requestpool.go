@@ -562,6 +562,7 @@ func (rp *RequestPool) OnIncomingSyncRequest(req *http.Request, device *userapi.
 			}
 		}
 
+		syncReq.Response.ApplyEventFields(syncReq.Filter.EventFields)
 		return util.JSONResponse{
 			Code: http.StatusOK,
 			JSON: syncReq.Response,
@@ -71,6 +71,112 @@ type ClientEvent struct {
 
 	// Only sent to clients when `event_format` == `federation`.
 	ClientFederationFields
+
+	// raw is the event as it appears over federation. If set, it is sent
+	// to clients in place of the fields above.
+	raw spec.RawJSON
+	// fields are the event fields requested with `event_fields`, as parsed
+	// by ParseEventFields. If set, only these fields are sent to clients.
+	fields [][]string
+}
+
+// MarshalJSON implements json.Marshaller, restricting the event to the
+// requested event fields if there are any.
+func (ce ClientEvent) MarshalJSON() ([]byte, error) {
+	type alias ClientEvent
+	event := []byte(ce.raw)
+	if event == nil {
+		var err error
+		if event, err = json.Marshal(alias(ce)); err != nil {
+			return nil, err
+		}
+	}
+	if len(ce.fields) == 0 {
+		return event, nil
+	}
+	return projectEventFields(event, ce.fields)
+}
+
+// ApplyEventFields restricts the given events to the event fields parsed by
+// ParseEventFields when they are marshalled to JSON.
+func ApplyEventFields(evs []ClientEvent, fields [][]string) {
+	for i := range evs {
+		evs[i].fields = fields
+	}
+}
+
+// ApplyEventFieldsRaw restricts the given raw events, such as the stripped
+// state of invites, to the event fields parsed by ParseEventFields. Events
+// which can't be projected are left as they are.
+func ApplyEventFieldsRaw(evs []json.RawMessage, fields [][]string) {
+	if len(fields) == 0 {
+		return
+	}
+	for i := range evs {
+		if !gjson.ValidBytes(evs[i]) {
+			continue
+		}
+		if projected, err := projectEventFields(evs[i], fields); err == nil {
+			evs[i] = projected
+		}
+	}
+}
+
+// projectEventFields returns a copy of the event JSON that only contains the
+// given fields. Fields that don't exist in the event are skipped.
+func projectEventFields(event []byte, fields [][]string) ([]byte, error) {
+	projected := map[string]interface{}{}
+	for _, path := range fields {
+		value := gjson.ParseBytes(event)
+		for _, key := range path {
+			if !value.IsObject() {
+				value = gjson.Result{}
+				break
+			}
+			value = value.Get(gjson.Escape(key))
+		}
+		if !value.Exists() {
+			continue
+		}
+
+		dst := projected
+		for _, key := range path[:len(path)-1] {
+			switch next := dst[key].(type) {
+			case map[string]interface{}:
+				dst = next
+			case json.RawMessage:
+				// The whole of the parent field has already been included.
+				dst = nil
+			default:
+				child := map[string]interface{}{}
+				dst[key] = child
+				dst = child
+			}
+			if dst == nil {
+				break
+			}
+		}
+		if dst != nil {
+			dst[path[len(path)-1]] = json.RawMessage(value.Raw)
+		}
+	}
+	return json.Marshal(projected)
+}
+
+// federationEventJSON returns the event as it appears over federation, along
+// with the event ID and unsigned data that clients expect.
+func federationEventJSON(se gomatrixserverlib.PDU) (spec.RawJSON, error) {
+	event := append([]byte(nil), se.JSON()...)
+	event, err := sjson.SetBytes(event, "event_id", se.EventID())
+	if err != nil {
+		return nil, err
+	}
+	if len(se.Unsigned()) > 0 {
+		if event, err = sjson.SetRawBytes(event, "unsigned", se.Unsigned()); err != nil {
+			return nil, err
+		}
+	}
+	return event, nil
 }
 
 // ToClientEvents converts server events to client events.
@@ -150,7 +256,13 @@ func ToClientEvent(se gomatrixserverlib.PDU, format ClientEventFormat, userIDFor
 		ce.AuthEvents = se.AuthEventIDs()
 		ce.PrevEvents = se.PrevEventIDs()
 		ce.Depth = se.Depth()
-		// TODO: Set Signatures & Hashes fields
+		ce.Signatures = spec.RawJSON(gjson.GetBytes(se.JSON(), "signatures").Raw)
+		ce.Hashes = spec.RawJSON(gjson.GetBytes(se.JSON(), "hashes").Raw)
+		raw, err := federationEventJSON(se)
+		if err != nil {
+			return nil, err
+		}
+		ce.raw = raw
 	}
 
 	if format != FormatSyncFederation && se.Version() == gomatrixserverlib.RoomVersionPseudoIDs {
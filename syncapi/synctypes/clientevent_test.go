@@ -559,3 +559,114 @@ func TestToClientEventsFormatSyncUnknownPrevSender(t *testing.T) { // nolint: go
 			Sender:         testUserID,
 		})
 }
+
+func TestClientEventEventFields(t *testing.T) {
+	ev, err := gomatrixserverlib.MustGetRoomVersion(gomatrixserverlib.RoomVersionV10).NewEventFromTrustedJSON([]byte(`{
+		"type": "m.room.message",
+		"event_id": "$test:localhost",
+		"room_id": "!test:localhost",
+		"sender": "@test:localhost",
+		"content": {
+			"body": "Hello World",
+			"msgtype": "m.text",
+			"m.relates_to": {
+				"rel_type": "m.thread",
+				"event_id": "$root:localhost"
+			}
+		},
+		"origin_server_ts": 123456,
+		"depth": 8,
+		"prev_events": [],
+		"auth_events": []
+	}`), false)
+	if err != nil {
+		t.Fatalf("failed to create event: %s", err)
+	}
+	ce, err := ToClientEvent(ev, FormatSync, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		return queryUserIDForSender(senderID)
+	})
+	if err != nil {
+		t.Fatalf("failed to create ClientEvent: %s", err)
+	}
+
+	evs := []ClientEvent{*ce}
+	ApplyEventFields(evs, ParseEventFields([]string{
+		"type", "content.body", `content.m\.relates_to.rel_type`, "content.missing", "unsigned.age",
+	}))
+	got, err := json.Marshal(evs[0])
+	if err != nil {
+		t.Fatalf("failed to marshal ClientEvent: %s", err)
+	}
+	want := `{"content":{"body":"Hello World","m.relates_to":{"rel_type":"m.thread"}},"type":"m.room.message"}`
+	if string(got) != want {
+		t.Fatalf("Expected %s\ngot %s", want, string(got))
+	}
+
+	// Requesting the whole of a field includes all of it.
+	ApplyEventFields(evs, ParseEventFields([]string{"content", "content.body"}))
+	if got, err = json.Marshal(evs[0]); err != nil {
+		t.Fatalf("failed to marshal ClientEvent: %s", err)
+	}
+	want = `{"content":{"body":"Hello World","msgtype":"m.text","m.relates_to":{"rel_type":"m.thread","event_id":"$root:localhost"}}}`
+	if string(got) != want {
+		t.Fatalf("Expected %s\ngot %s", want, string(got))
+	}
+}
+
+func TestApplyEventFieldsRaw(t *testing.T) {
+	evs := []json.RawMessage{
+		json.RawMessage(`{"type":"m.room.name","state_key":"","sender":"@test:localhost","content":{"name":"Room"}}`),
+		json.RawMessage(`not json`),
+	}
+	ApplyEventFieldsRaw(evs, ParseEventFields([]string{"type", "content.name"}))
+	want := `{"content":{"name":"Room"},"type":"m.room.name"}`
+	if string(evs[0]) != want {
+		t.Fatalf("Expected %s\ngot %s", want, string(evs[0]))
+	}
+	if string(evs[1]) != `not json` {
+		t.Fatalf("Expected the malformed event to be left alone, got %s", string(evs[1]))
+	}
+}
+
+func TestClientEventFederationFormatIsRawPDU(t *testing.T) {
+	ev, err := gomatrixserverlib.MustGetRoomVersion(gomatrixserverlib.RoomVersionV10).NewEventFromTrustedJSON([]byte(`{
+		"type": "m.room.message",
+		"room_id": "!test:localhost",
+		"sender": "@test:localhost",
+		"origin": "localhost",
+		"content": {"body": "Hello World"},
+		"origin_server_ts": 123456,
+		"depth": 8,
+		"prev_events": ["$prev"],
+		"auth_events": ["$auth"],
+		"hashes": {"sha256": "abc"},
+		"signatures": {"localhost": {"ed25519:1": "sig"}},
+		"unsigned": {"age": 10}
+	}`), false)
+	if err != nil {
+		t.Fatalf("failed to create event: %s", err)
+	}
+	ce, err := ToClientEvent(ev, FormatSyncFederation, func(roomID spec.RoomID, senderID spec.SenderID) (*spec.UserID, error) {
+		return queryUserIDForSender(senderID)
+	})
+	if err != nil {
+		t.Fatalf("failed to create ClientEvent: %s", err)
+	}
+	got, err := json.Marshal(ce)
+	if err != nil {
+		t.Fatalf("failed to marshal ClientEvent: %s", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err = json.Unmarshal(got, &raw); err != nil {
+		t.Fatalf("failed to unmarshal ClientEvent: %s", err)
+	}
+	for _, key := range []string{"origin", "hashes", "signatures", "prev_events", "auth_events", "depth", "room_id", "unsigned"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("Expected federation formatted event to have %q, got %s", key, string(got))
+		}
+	}
+	if eventID := string(raw["event_id"]); eventID != `"`+ev.EventID()+`"` {
+		t.Errorf("Expected event ID %s, got %s", ev.EventID(), eventID)
+	}
+}
@@ -16,6 +16,7 @@ package synctypes
 
 import (
 	"errors"
+	"strings"
 )
 
 // Filter is used by clients to specify how the server should filter responses to e.g. sync requests
@@ -76,6 +77,11 @@ type RoomEventFilter struct {
 	Rooms                     *[]string `json:"rooms,omitempty"`
 	UnreadThreadNotifications bool      `json:"unread_thread_notifications,omitempty"`
 	ContainsURL               *bool     `json:"contains_url,omitempty"`
+	// EventFields and EventFormat are not part of a room event filter in the
+	// spec, but are honoured by /messages and /context, where the room event
+	// filter is the only filter, the same way as for /sync.
+	EventFields []string `json:"event_fields,omitempty"`
+	EventFormat string   `json:"event_format,omitempty"`
 }
 
 const (
@@ -91,6 +97,36 @@ func (filter *Filter) Validate() error {
 	return nil
 }
 
+// ParseEventFields splits the dot-separated `event_fields` paths into their
+// components. A literal '.' or '\' in a field name may be escaped with '\'.
+func ParseEventFields(eventFields []string) [][]string {
+	if len(eventFields) == 0 {
+		return nil
+	}
+	paths := make([][]string, 0, len(eventFields))
+	for _, field := range eventFields {
+		var path []string
+		var key strings.Builder
+		escaped := false
+		for _, c := range field {
+			switch {
+			case escaped:
+				key.WriteRune(c)
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '.':
+				path = append(path, key.String())
+				key.Reset()
+			default:
+				key.WriteRune(c)
+			}
+		}
+		paths = append(paths, append(path, key.String()))
+	}
+	return paths
+}
+
 // DefaultFilter returns the default filter used by the Matrix server if no filter is provided in
 // the request
 func DefaultFilter() Filter {
@@ -58,3 +58,24 @@ func Test_Filter(t *testing.T) {
 	}
 
 }
+
+func TestParseEventFields(t *testing.T) {
+	got := ParseEventFields([]string{
+		"type",
+		"content.body",
+		`content.m\.relates_to.rel_type`,
+		`content.back\\slash`,
+	})
+	want := [][]string{
+		{"type"},
+		{"content", "body"},
+		{"content", "m.relates_to", "rel_type"},
+		{"content", `back\slash`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %+v\ngot %+v", want, got)
+	}
+	if got = ParseEventFields(nil); got != nil {
+		t.Fatalf("Expected no fields, got %+v", got)
+	}
+}
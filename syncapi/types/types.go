@@ -365,6 +365,9 @@ type Response struct {
 	ToDevice            *ToDeviceResponse `json:"to_device,omitempty"`
 	DeviceLists         *DeviceLists      `json:"device_lists,omitempty"`
 	DeviceListsOTKCount map[string]int    `json:"device_one_time_keys_count,omitempty"`
+	// DeviceUnusedFallbackKeyTypes is always included, as clients use its presence
+	// to determine whether the server supports fallback keys.
+	DeviceUnusedFallbackKeyTypes []string `json:"device_unused_fallback_key_types"`
 }
 
 func (r Response) MarshalJSON() ([]byte, error) {
@@ -394,7 +397,7 @@ func (r Response) MarshalJSON() ([]byte, error) {
 }
 
 func (r *Response) HasUpdates() bool {
-	// purposefully exclude DeviceListsOTKCount as we always include them
+	// purposefully exclude DeviceListsOTKCount and DeviceUnusedFallbackKeyTypes as we always include them
 	return (len(r.AccountData.Events) > 0 ||
 		len(r.Presence.Events) > 0 ||
 		len(r.Rooms.Invite) > 0 ||
@@ -406,6 +409,45 @@ func (r *Response) HasUpdates() bool {
 		len(r.DeviceLists.Left) > 0)
 }
 
+// ApplyEventFields restricts the events in the response to the fields
+// requested with the `event_fields` filter, if any.
+func (r *Response) ApplyEventFields(eventFields []string) {
+	fields := synctypes.ParseEventFields(eventFields)
+	if fields == nil {
+		return
+	}
+	apply := func(evs *ClientEvents) {
+		if evs != nil {
+			synctypes.ApplyEventFields(evs.Events, fields)
+		}
+	}
+	applyTimeline := func(timeline *Timeline) {
+		if timeline != nil {
+			synctypes.ApplyEventFields(timeline.Events, fields)
+		}
+	}
+	apply(r.AccountData)
+	apply(r.Presence)
+	if r.Rooms == nil {
+		return
+	}
+	for _, rooms := range []map[string]*JoinResponse{r.Rooms.Join, r.Rooms.Peek} {
+		for _, jr := range rooms {
+			apply(jr.State)
+			apply(jr.Ephemeral)
+			apply(jr.AccountData)
+			applyTimeline(jr.Timeline)
+		}
+	}
+	for _, lr := range r.Rooms.Leave {
+		apply(lr.State)
+		applyTimeline(lr.Timeline)
+	}
+	for _, ir := range r.Rooms.Invite {
+		synctypes.ApplyEventFieldsRaw(ir.InviteState.Events, fields)
+	}
+}
+
 // NewResponse creates an empty response with initialised maps.
 func NewResponse() *Response {
 	res := Response{}
@@ -427,6 +469,7 @@ func NewResponse() *Response {
 	res.DeviceLists = &DeviceLists{}
 	res.ToDevice = &ToDeviceResponse{}
 	res.DeviceListsOTKCount = map[string]int{}
+	res.DeviceUnusedFallbackKeyTypes = []string{}
 
 	return &res
 }
@@ -471,6 +514,9 @@ type JoinResponse struct {
 	Ephemeral            *ClientEvents `json:"ephemeral,omitempty"`
 	AccountData          *ClientEvents `json:"account_data,omitempty"`
 	*UnreadNotifications `json:"unread_notifications,omitempty"`
+	// UnreadThreadNotifications is only populated if the client asked for
+	// unread counts to be split by thread, keyed by thread root event ID.
+	UnreadThreadNotifications map[string]*UnreadNotifications `json:"unread_thread_notifications,omitempty"`
 }
 
 func (jr JoinResponse) MarshalJSON() ([]byte, error) {
@@ -508,6 +554,7 @@ func (jr JoinResponse) MarshalJSON() ([]byte, error) {
 		// if everything else is nil, also remove UnreadNotifications
 		if a.State == nil && a.Ephemeral == nil && a.AccountData == nil && a.Timeline == nil && a.Summary == nil {
 			a.UnreadNotifications = nil
+			a.UnreadThreadNotifications = nil
 		}
 	}
 	return json.Marshal(a)
@@ -630,6 +677,7 @@ type OutputReceiptEvent struct {
 	RoomID    string         `json:"room_id"`
 	EventID   string         `json:"event_id"`
 	Type      string         `json:"type"`
+	ThreadID  string         `json:"thread_id,omitempty"`
 	Timestamp spec.Timestamp `json:"timestamp"`
 }
 
@@ -456,6 +456,18 @@ func (d *InMemoryFederationDatabase) GetJoinedHostsForRooms(ctx context.Context,
 	return nil, nil
 }
 
+func (d *InMemoryFederationDatabase) SetPartialStateServers(ctx context.Context, roomID string, serverNames []spec.ServerName) error {
+	return nil
+}
+
+func (d *InMemoryFederationDatabase) GetPartialStateServers(ctx context.Context, roomID string) ([]spec.ServerName, error) {
+	return nil, nil
+}
+
+func (d *InMemoryFederationDatabase) ClearPartialStateServers(ctx context.Context, roomID string) error {
+	return nil
+}
+
 func (d *InMemoryFederationDatabase) RemoveAllServersAssumedOffline(ctx context.Context) error {
 	return nil
 }
@@ -79,6 +79,7 @@ type SyncUserAPI interface {
 	PerformDeviceUpdate(ctx context.Context, req *PerformDeviceUpdateRequest, res *PerformDeviceUpdateResponse) error
 	QueryDevices(ctx context.Context, req *QueryDevicesRequest, res *QueryDevicesResponse) error
 	QueryDeviceInfos(ctx context.Context, req *QueryDeviceInfosRequest, res *QueryDeviceInfosResponse) error
+	QueryDehydratedDevice(ctx context.Context, req *QueryDehydratedDeviceRequest, res *QueryDehydratedDeviceResponse) error
 }
 
 // api functions required by the client api
@@ -104,6 +105,21 @@ type ClientUserAPI interface {
 	PerformDeviceCreation(ctx context.Context, req *PerformDeviceCreationRequest, res *PerformDeviceCreationResponse) error
 	PerformDeviceUpdate(ctx context.Context, req *PerformDeviceUpdateRequest, res *PerformDeviceUpdateResponse) error
 	PerformDeviceDeletion(ctx context.Context, req *PerformDeviceDeletionRequest, res *PerformDeviceDeletionResponse) error
+	PerformDehydratedDeviceCreation(ctx context.Context, req *PerformDehydratedDeviceCreationRequest, res *PerformDehydratedDeviceCreationResponse) error
+	QueryDehydratedDevice(ctx context.Context, req *QueryDehydratedDeviceRequest, res *QueryDehydratedDeviceResponse) error
+	QueryAccountValidity(ctx context.Context, req *QueryAccountValidityRequest, res *QueryAccountValidityResponse) error
+	PerformAccountValidityUpdate(ctx context.Context, req *PerformAccountValidityUpdateRequest, res *PerformAccountValidityUpdateResponse) error
+	PerformAccountRenewal(ctx context.Context, req *PerformAccountRenewalRequest, res *PerformAccountRenewalResponse) error
+	PerformRenewalEmail(ctx context.Context, req *PerformRenewalEmailRequest, res *struct{}) error
+	QuerySearchUserDirectory(ctx context.Context, req *QuerySearchUserDirectoryRequest, res *QuerySearchUserDirectoryResponse) error
+	QueryUserConsent(ctx context.Context, req *QueryUserConsentRequest, res *QueryUserConsentResponse) error
+	PerformUserConsent(ctx context.Context, req *PerformUserConsentRequest, res *struct{}) error
+	QueryConsentNoticeUsers(ctx context.Context, req *struct{}, res *QueryConsentNoticeUsersResponse) error
+	PerformConsentNoticeSent(ctx context.Context, req *PerformConsentNoticeSentRequest, res *struct{}) error
+	PerformDelayedEventCreation(ctx context.Context, req *PerformDelayedEventCreationRequest, res *PerformDelayedEventCreationResponse) error
+	PerformDelayedEventRestart(ctx context.Context, req *PerformDelayedEventRestartRequest, res *PerformDelayedEventRestartResponse) error
+	PerformDelayedEventDeletion(ctx context.Context, req *PerformDelayedEventDeletionRequest, res *PerformDelayedEventDeletionResponse) error
+	QueryDelayedEvents(ctx context.Context, req *QueryDelayedEventsRequest, res *QueryDelayedEventsResponse) error
 	PerformPasswordUpdate(ctx context.Context, req *PerformPasswordUpdateRequest, res *PerformPasswordUpdateResponse) error
 	PerformPusherDeletion(ctx context.Context, req *PerformPusherDeletionRequest, res *struct{}) error
 	PerformPusherSet(ctx context.Context, req *PerformPusherSetRequest, res *struct{}) error
@@ -117,6 +133,10 @@ type ClientUserAPI interface {
 	QueryLocalpartForThreePID(ctx context.Context, req *QueryLocalpartForThreePIDRequest, res *QueryLocalpartForThreePIDResponse) error
 	PerformForgetThreePID(ctx context.Context, req *PerformForgetThreePIDRequest, res *struct{}) error
 	PerformSaveThreePIDAssociation(ctx context.Context, req *PerformSaveThreePIDAssociationRequest, res *struct{}) error
+
+	QueryUsageStatistics(ctx context.Context, req *QueryUsageStatisticsRequest, res *QueryUsageStatisticsResponse) error
+	QueryResourceUsage(ctx context.Context, req *QueryResourceUsageRequest, res *QueryResourceUsageResponse) error
+	QueryServerNoticeRecipients(ctx context.Context, req *QueryServerNoticeRecipientsRequest, res *QueryServerNoticeRecipientsResponse) error
 }
 
 type KeyBackupAPI interface {
@@ -318,6 +338,22 @@ type QuerySearchProfilesResponse struct {
 	Profiles []authtypes.Profile
 }
 
+// QuerySearchUserDirectoryRequest is the request for QuerySearchUserDirectory
+type QuerySearchUserDirectoryRequest struct {
+	// The search string to match
+	SearchString string
+	// How many results to return
+	Limit int
+}
+
+// QuerySearchUserDirectoryResponse is the response for QuerySearchUserDirectory
+type QuerySearchUserDirectoryResponse struct {
+	// Users matching the search, best matches first
+	Profiles []authtypes.FullyQualifiedProfile
+	// Limited is true if there were more matches than the limit
+	Limited bool
+}
+
 // PerformAccountCreationRequest is the request for PerformAccountCreation
 type PerformAccountCreationRequest struct {
 	AccountType AccountType     // Required: whether this is a guest or user account
@@ -391,6 +427,197 @@ type PerformDeviceCreationResponse struct {
 	Device        *Device
 }
 
+// PerformDehydratedDeviceCreationRequest is the request for PerformDehydratedDeviceCreation
+type PerformDehydratedDeviceCreationRequest struct {
+	UserID string
+	// The ID of the dehydrated device, chosen by the client.
+	DeviceID string
+	// The pickled device data, which is opaque to the server.
+	DeviceData json.RawMessage
+	// optional: if nil no display name will be associated with this device.
+	DisplayName *string
+	// The keys of the dehydrated device, uploaded as if by the device itself.
+	DeviceKeys   *DeviceKeys
+	OneTimeKeys  *OneTimeKeys
+	FallbackKeys *OneTimeKeys
+}
+
+// PerformDehydratedDeviceCreationResponse is the response for PerformDehydratedDeviceCreation
+type PerformDehydratedDeviceCreationResponse struct {
+	DeviceID string
+}
+
+// QueryDehydratedDeviceRequest is the request for QueryDehydratedDevice
+type QueryDehydratedDeviceRequest struct {
+	UserID string
+}
+
+// QueryDehydratedDeviceResponse is the response for QueryDehydratedDevice
+type QueryDehydratedDeviceResponse struct {
+	// Exists is false if the user has no dehydrated device.
+	Exists     bool
+	DeviceID   string
+	DeviceData json.RawMessage
+}
+
+// AccountValidity is when an account expires, for accounts which expire.
+type AccountValidity struct {
+	UserID       string
+	ExpirationTS spec.Timestamp
+	// Whether the renewal email was already sent for the current period.
+	EmailSent bool
+}
+
+// Expired returns whether the account has expired at the given time.
+func (v *AccountValidity) Expired(now time.Time) bool {
+	return !now.Before(v.ExpirationTS.Time())
+}
+
+// QueryAccountValidityRequest is the request for QueryAccountValidity
+type QueryAccountValidityRequest struct {
+	UserID string
+}
+
+// QueryAccountValidityResponse is the response for QueryAccountValidity
+type QueryAccountValidityResponse struct {
+	// Expires is false if the account doesn't expire.
+	Expires  bool
+	Validity AccountValidity
+}
+
+// PerformAccountValidityUpdateRequest is the request for PerformAccountValidityUpdate
+type PerformAccountValidityUpdateRequest struct {
+	UserID string
+	// optional: if zero, the account expires one validity period from now.
+	ExpirationTS spec.Timestamp
+	// Whether to send a renewal email before the account expires.
+	EnableRenewalEmails bool
+}
+
+// PerformAccountValidityUpdateResponse is the response for PerformAccountValidityUpdate
+type PerformAccountValidityUpdateResponse struct {
+	ExpirationTS spec.Timestamp
+}
+
+// PerformAccountRenewalRequest is the request for PerformAccountRenewal
+type PerformAccountRenewalRequest struct {
+	// The token from the link in the renewal email.
+	RenewalToken string
+}
+
+// PerformAccountRenewalResponse is the response for PerformAccountRenewal
+type PerformAccountRenewalResponse struct {
+	// Renewed is false if the renewal token is unknown or was already used.
+	Renewed      bool
+	UserID       string
+	ExpirationTS spec.Timestamp
+}
+
+// PerformRenewalEmailRequest is the request for PerformRenewalEmail
+type PerformRenewalEmailRequest struct {
+	UserID string
+}
+
+// QueryUserConsentRequest is the request for QueryUserConsent
+type QueryUserConsentRequest struct {
+	UserID string
+}
+
+// QueryUserConsentResponse is the response for QueryUserConsent
+type QueryUserConsentResponse struct {
+	// The version of the policy documents the user accepted, if any.
+	ConsentVersion string
+	// ConsentRequired is true if the user hasn't accepted the current version.
+	ConsentRequired bool
+}
+
+// PerformUserConsentRequest is the request for PerformUserConsent
+type PerformUserConsentRequest struct {
+	UserID string
+	// optional: the version of the policy documents the user accepted,
+	// if empty the current version.
+	Version string
+}
+
+// QueryConsentNoticeUsersResponse is the response for QueryConsentNoticeUsers
+type QueryConsentNoticeUsersResponse struct {
+	// The users who haven't accepted the current version of the policy
+	// documents, and haven't been sent a server notice about it either.
+	UserIDs []string
+}
+
+// PerformConsentNoticeSentRequest is the request for PerformConsentNoticeSent
+type PerformConsentNoticeSentRequest struct {
+	UserID string
+}
+
+// DelayedEvent is an event which will be sent on behalf of a user once its
+// delay has elapsed, unless it is cancelled or restarted first (MSC4140).
+type DelayedEvent struct {
+	DelayID   string  `json:"delay_id"`
+	UserID    string  `json:"-"`
+	RoomID    string  `json:"room_id"`
+	EventType string  `json:"type"`
+	StateKey  *string `json:"state_key,omitempty"`
+	// The delay in milliseconds, counted from RunningSince.
+	Delay        int64           `json:"delay"`
+	RunningSince spec.Timestamp  `json:"running_since"`
+	Content      json.RawMessage `json:"content"`
+}
+
+// SendAt returns the time at which the delayed event is due to be sent.
+func (e *DelayedEvent) SendAt() time.Time {
+	return e.RunningSince.Time().Add(time.Duration(e.Delay) * time.Millisecond)
+}
+
+// PerformDelayedEventCreationRequest is the request for PerformDelayedEventCreation
+type PerformDelayedEventCreationRequest struct {
+	// The delayed event to store. DelayID and RunningSince are filled in by the user API.
+	Event DelayedEvent
+}
+
+// PerformDelayedEventCreationResponse is the response for PerformDelayedEventCreation
+type PerformDelayedEventCreationResponse struct {
+	Event DelayedEvent
+}
+
+// PerformDelayedEventRestartRequest is the request for PerformDelayedEventRestart
+type PerformDelayedEventRestartRequest struct {
+	DelayID string
+}
+
+// PerformDelayedEventRestartResponse is the response for PerformDelayedEventRestart
+type PerformDelayedEventRestartResponse struct {
+	Event DelayedEvent
+}
+
+// PerformDelayedEventDeletionRequest is the request for PerformDelayedEventDeletion.
+// Either DelayID or all of RoomID, EventType and StateKey must be given. In the
+// latter case, all delayed state events that would set that state are deleted.
+type PerformDelayedEventDeletionRequest struct {
+	DelayID   string
+	RoomID    string
+	EventType string
+	StateKey  *string
+}
+
+// PerformDelayedEventDeletionResponse is the response for PerformDelayedEventDeletion
+type PerformDelayedEventDeletionResponse struct {
+	DelayIDs []string
+}
+
+// QueryDelayedEventsRequest is the request for QueryDelayedEvents.
+// If neither DelayID nor UserID are given, all delayed events are returned.
+type QueryDelayedEventsRequest struct {
+	DelayID string
+	UserID  string
+}
+
+// QueryDelayedEventsResponse is the response for QueryDelayedEvents
+type QueryDelayedEventsResponse struct {
+	DelayedEvents []DelayedEvent
+}
+
 // PerformAccountDeactivationRequest is the request for PerformAccountDeactivation
 type PerformAccountDeactivationRequest struct {
 	Localpart  string
@@ -442,6 +669,12 @@ type Device struct {
 	// this is the appservice ID.
 	AppserviceID string
 	AccountType  AccountType
+	// Whether the account has expired and must be renewed before
+	// it can use most of the client API.
+	AccountExpired bool
+	// Whether the user must accept the current version of the policy
+	// documents before they can send events.
+	ConsentRequired bool
 }
 
 func (d *Device) UserDomain() spec.ServerName {
@@ -580,6 +813,95 @@ type QueryNotificationsResponse struct {
 	Notifications []*Notification `json:"notifications"` // Required.
 }
 
+// QueryServerNoticeRecipientsRequest is the request for QueryServerNoticeRecipients.
+// With no filters set, all local users except guests and appservice users match.
+type QueryServerNoticeRecipientsRequest struct {
+	// Only match admins.
+	AdminsOnly bool
+	// Only match users with a device which was seen since this time, if set.
+	ActiveSince spec.Timestamp
+	// Only match users whose localpart matches this regular expression, if set.
+	LocalpartRegex string
+}
+
+// QueryServerNoticeRecipientsResponse is the response for QueryServerNoticeRecipients
+type QueryServerNoticeRecipientsResponse struct {
+	UserIDs []string
+}
+
+type QueryUsageStatisticsRequest struct {
+	// Format is either config.ReportStatsFormatPhoneHome or
+	// config.ReportStatsFormatDetailed.
+	Format string
+}
+
+type QueryUsageStatisticsResponse struct {
+	// Statistics is a map in the phone-home format, or a *UsageStatistics
+	// in the detailed format.
+	Statistics interface{}
+}
+
+// QueryResourceUsageRequest is the request for QueryResourceUsage
+type QueryResourceUsageRequest struct{}
+
+// QueryResourceUsageResponse is the response for QueryResourceUsage, which
+// only holds the figures that resource limits are checked against, as they
+// are much cheaper to get than the full usage statistics.
+type QueryResourceUsageResponse struct {
+	MonthlyActiveUsers int64
+	// The size of the media store in bytes, which may be a little out of date.
+	MediaSize int64
+}
+
+// UsageStatistics is the detailed usage statistics report, which is meant
+// for self-hosted dashboards rather than phone-home reporting.
+type UsageStatistics struct {
+	ServerName    spec.ServerName           `json:"homeserver"`
+	Version       string                    `json:"version"`
+	Timestamp     int64                     `json:"timestamp"`
+	UptimeSeconds int64                     `json:"uptime_seconds"`
+	Users         UsageStatisticsUsers      `json:"users"`
+	Rooms         UsageStatisticsRooms      `json:"rooms"`
+	Federation    UsageStatisticsFederation `json:"federation"`
+	Storage       UsageStatisticsStorage    `json:"storage"`
+}
+
+type UsageStatisticsUsers struct {
+	Total                      int64            `json:"total"`
+	NonBridged                 int64            `json:"non_bridged"`
+	DailyActive                int64            `json:"daily_active"`
+	MonthlyActive              int64            `json:"monthly_active"`
+	DailyActiveByClientType    map[string]int64 `json:"daily_active_by_client_type"`
+	MonthlyActiveByClientType  map[string]int64 `json:"monthly_active_by_client_type"`
+	DailyRegistrations         int64            `json:"daily_registrations"`
+	MonthlyRegistrations       int64            `json:"monthly_registrations"`
+	MonthlyRegistrationsByType map[string]int64 `json:"monthly_registrations_by_type"`
+}
+
+type UsageStatisticsRooms struct {
+	Total                  int64            `json:"total"`
+	ByVersion              map[string]int64 `json:"by_version"`
+	Encrypted              int64            `json:"encrypted"`
+	DailyActive            int64            `json:"daily_active"`
+	DailyActiveEncrypted   int64            `json:"daily_active_encrypted"`
+	DailyMessages          int64            `json:"daily_messages"`
+	DailyEncryptedMessages int64            `json:"daily_encrypted_messages"`
+}
+
+type UsageStatisticsFederation struct {
+	Disabled            bool  `json:"disabled"`
+	JoinedRemoteServers int64 `json:"joined_remote_servers"`
+}
+
+type UsageStatisticsStorage struct {
+	DatabaseEngine  string `json:"database_engine"`
+	DatabaseVersion string `json:"database_version"`
+	// DatabaseSizes maps each database connection, with any credentials
+	// redacted, to its size in bytes.
+	DatabaseSizes map[string]int64 `json:"database_sizes"`
+	MediaSize     int64            `json:"media_size"`
+}
+
 type Notification struct {
 	Actions    []*pushrules.Action   `json:"actions"`     // Required.
 	Event      synctypes.ClientEvent `json:"event"`       // Required.
@@ -802,6 +1124,9 @@ type PerformUploadKeysRequest struct {
 	DeviceID    string // Optional - Device performing the request, for fetching OTK count
 	DeviceKeys  []DeviceKeys
 	OneTimeKeys []OneTimeKeys
+	// FallbackKeys replace any existing fallback keys for the same algorithms. They use
+	// the same representation as one-time keys.
+	FallbackKeys []OneTimeKeys
 	// OnlyDisplayNameUpdates should be `true` if ALL the DeviceKeys are present to update
 	// the display name for their respective device, and NOT to modify the keys. The key
 	// itself doesn't change but it's easier to pretend upload new keys and reuse the same code paths.
@@ -926,7 +1251,9 @@ type QueryOneTimeKeysRequest struct {
 type QueryOneTimeKeysResponse struct {
 	// OTK key counts, in the extended /sync form described by https://matrix.org/docs/spec/client_server/r0.6.1#id84
 	Count OneTimeKeysCount
-	Error *KeyError
+	// The algorithms for which the device has a fallback key which hasn't been claimed yet
+	UnusedFallbackAlgorithms []string
+	Error                    *KeyError
 }
 
 type QueryDeviceMessagesRequest struct {
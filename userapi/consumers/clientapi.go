@@ -81,14 +81,16 @@ func (s *OutputReceiptEventConsumer) onMessage(ctx context.Context, msgs []*nats
 	roomID := msg.Header.Get(jetstream.RoomID)
 	readPos := msg.Header.Get(jetstream.EventID)
 	evType := msg.Header.Get("type")
+	threadID := msg.Header.Get("thread_id")
 
 	if readPos == "" || (evType != "m.read" && evType != "m.read.private") {
 		return true
 	}
 
 	log := log.WithFields(log.Fields{
-		"room_id": roomID,
-		"user_id": userID,
+		"room_id":   roomID,
+		"user_id":   userID,
+		"thread_id": threadID,
 	})
 
 	localpart, domain, err := gomatrixserverlib.SplitID('@', userID)
@@ -105,7 +107,7 @@ func (s *OutputReceiptEventConsumer) onMessage(ctx context.Context, msgs []*nats
 		return false
 	}
 
-	updated, err := s.db.SetNotificationsRead(ctx, localpart, domain, roomID, uint64(spec.AsTimestamp(metadata.Timestamp)), true)
+	updated, err := s.db.SetNotificationsRead(ctx, localpart, domain, roomID, threadID, uint64(spec.AsTimestamp(metadata.Timestamp)), true)
 	if err != nil {
 		log.WithError(err).Error("userapi EDU consumer")
 		return false
@@ -86,9 +86,62 @@ func (s *OutputRoomEventConsumer) Start() error {
 	); err != nil {
 		return err
 	}
+	go s.backfillUserDirectory(s.ctx)
 	return nil
 }
 
+// backfillUserDirectory adds the members of the public rooms which local users
+// were joined to before the user directory existed, including remote users.
+// It only has to succeed once, later changes come from the room events.
+func (s *OutputRoomEventConsumer) backfillUserDirectory(ctx context.Context) {
+	backfilled, err := s.db.UserDirectoryBackfilled(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to check whether the user directory was backfilled")
+		return
+	}
+	if backfilled {
+		return
+	}
+	userIDs, err := s.db.LocalUserDirectoryUsers(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to get the local users to backfill the user directory with")
+		return
+	}
+	done := map[string]struct{}{}
+	for _, userID := range userIDs {
+		parsed, err := spec.NewUserID(userID, true)
+		if err != nil {
+			continue
+		}
+		roomIDs, err := s.rsAPI.QueryRoomsForUser(ctx, *parsed, spec.Join)
+		if err != nil {
+			log.WithError(err).WithField("user_id", userID).Error("Failed to backfill the user directory")
+			return
+		}
+		for _, roomID := range roomIDs {
+			if _, ok := done[roomID.String()]; ok {
+				continue
+			}
+			done[roomID.String()] = struct{}{}
+			public, err := s.isPublicRoom(ctx, roomID.String())
+			if err != nil {
+				log.WithError(err).WithField("room_id", roomID.String()).Error("Failed to backfill the user directory")
+				return
+			}
+			if !public {
+				continue
+			}
+			if err = s.updateUserDirectoryRoom(ctx, roomID.String(), spec.Public); err != nil {
+				log.WithError(err).WithField("room_id", roomID.String()).Error("Failed to backfill the user directory")
+				return
+			}
+		}
+	}
+	if err = s.db.MarkUserDirectoryBackfilled(ctx); err != nil {
+		log.WithError(err).Error("Failed to record that the user directory was backfilled")
+	}
+}
+
 func (s *OutputRoomEventConsumer) onMessage(ctx context.Context, msgs []*nats.Msg) bool {
 	msg := msgs[0] // Guaranteed to exist if onMessage is called
 	// Only handle events we care about
@@ -329,6 +382,15 @@ func (s *OutputRoomEventConsumer) processMessage(ctx context.Context, event *rst
 			// should also be pushed to the target user.
 			members = append(members, member)
 		}
+		if err = s.updateUserDirectoryMember(ctx, event.RoomID().String(), member); err != nil {
+			// while inconvenient, this shouldn't stop us from sending push notifications
+			log.WithError(err).Errorf("UserAPI: failed to update user directory")
+		}
+	case event.Type() == spec.MRoomJoinRules && event.StateKeyEquals(""):
+		joinRule := gjson.GetBytes(event.Content(), "join_rule").Str
+		if err = s.updateUserDirectoryRoom(ctx, event.RoomID().String(), joinRule); err != nil {
+			log.WithError(err).Errorf("UserAPI: failed to update user directory")
+		}
 	case event.Type() == "m.room.tombstone" && event.StateKeyEquals(""):
 		// Handle room upgrades
 		oldRoomID := event.RoomID().String()
@@ -371,6 +433,67 @@ func (s *OutputRoomEventConsumer) processMessage(ctx context.Context, event *rst
 	return nil
 }
 
+// updateUserDirectoryMember keeps track of who is joined to public rooms, so
+// that they can be found in the user directory.
+func (s *OutputRoomEventConsumer) updateUserDirectoryMember(ctx context.Context, roomID string, member *localMembership) error {
+	switch member.Membership {
+	case spec.Join:
+		public, err := s.isPublicRoom(ctx, roomID)
+		if err != nil || !public {
+			return err
+		}
+		isLocal := s.cfg.Matrix.IsLocalServerName(member.Domain)
+		return s.db.AddPublicRoomUser(ctx, roomID, member.UserID, isLocal, member.DisplayName, member.AvatarURL)
+	case spec.Leave, spec.Ban:
+		return s.db.RemovePublicRoomUser(ctx, roomID, member.UserID)
+	}
+	return nil
+}
+
+// updateUserDirectoryRoom adds everyone joined to a room which became public
+// to the user directory, or removes them if it isn't public any more.
+func (s *OutputRoomEventConsumer) updateUserDirectoryRoom(ctx context.Context, roomID, joinRule string) error {
+	if joinRule != spec.Public {
+		return s.db.RemovePublicRoom(ctx, roomID)
+	}
+	req := &rsapi.QueryMembershipsForRoomRequest{
+		RoomID:     roomID,
+		JoinedOnly: true,
+	}
+	var res rsapi.QueryMembershipsForRoomResponse
+	if err := s.rsAPI.QueryMembershipsForRoom(ctx, req, &res); err != nil {
+		return fmt.Errorf("s.rsAPI.QueryMembershipsForRoom: %w", err)
+	}
+	for _, event := range res.JoinEvents {
+		member, err := newLocalMembership(&event)
+		if err != nil {
+			log.WithError(err).Errorf("Parsing MemberContent")
+			continue
+		}
+		if err = s.updateUserDirectoryMember(ctx, roomID, member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPublicRoom returns whether anyone can join the room.
+func (s *OutputRoomEventConsumer) isPublicRoom(ctx context.Context, roomID string) (bool, error) {
+	req := &rsapi.QueryCurrentStateRequest{
+		RoomID:      roomID,
+		StateTuples: []gomatrixserverlib.StateKeyTuple{joinRulesTuple},
+	}
+	var res rsapi.QueryCurrentStateResponse
+	if err := s.rsAPI.QueryCurrentState(ctx, req, &res); err != nil {
+		return false, fmt.Errorf("s.rsAPI.QueryCurrentState: %w", err)
+	}
+	event := res.StateEvents[joinRulesTuple]
+	if event == nil {
+		return false, nil
+	}
+	return gjson.GetBytes(event.Content(), "join_rule").Str == spec.Public, nil
+}
+
 type localMembership struct {
 	gomatrixserverlib.MemberContent
 	UserID    string
@@ -508,6 +631,7 @@ func (s *OutputRoomEventConsumer) roomName(ctx context.Context, event *rstypes.H
 var (
 	canonicalAliasTuple = gomatrixserverlib.StateKeyTuple{EventType: spec.MRoomCanonicalAlias}
 	roomNameTuple       = gomatrixserverlib.StateKeyTuple{EventType: spec.MRoomName}
+	joinRulesTuple      = gomatrixserverlib.StateKeyTuple{EventType: spec.MRoomJoinRules}
 )
 
 func unmarshalRoomName(event *rstypes.HeaderedEvent) (string, error) {
@@ -528,6 +652,16 @@ func unmarshalCanonicalAlias(event *rstypes.HeaderedEvent) (string, error) {
 	return cac.Alias, nil
 }
 
+// eventThreadID returns the root event ID of the thread that the event is
+// part of, or eventutil.MainThreadID if it isn't part of a thread.
+func eventThreadID(event *rstypes.HeaderedEvent) string {
+	relatesTo := gjson.GetBytes(event.Content(), `m\.relates_to`)
+	if relatesTo.Get("rel_type").Str == "m.thread" && relatesTo.Get("event_id").Str != "" {
+		return relatesTo.Get("event_id").Str
+	}
+	return eventutil.MainThreadID
+}
+
 // notifyLocal finds the right push actions for a local user, given an event.
 func (s *OutputRoomEventConsumer) notifyLocal(ctx context.Context, event *rstypes.HeaderedEvent, mem *localMembership, roomSize int, roomName string, streamPos uint64) error {
 	actions, err := s.evaluatePushRules(ctx, event, mem, roomSize)
@@ -573,7 +707,7 @@ func (s *OutputRoomEventConsumer) notifyLocal(ctx context.Context, event *rstype
 		RoomID:     event.RoomID().String(),
 		TS:         spec.AsTimestamp(time.Now()),
 	}
-	if err = s.db.InsertNotification(ctx, mem.Localpart, mem.Domain, event.EventID(), streamPos, tweaks, n); err != nil {
+	if err = s.db.InsertNotification(ctx, mem.Localpart, mem.Domain, event.EventID(), eventThreadID(event), streamPos, tweaks, n); err != nil {
 		return fmt.Errorf("s.db.InsertNotification: %w", err)
 	}
 
@@ -23,6 +23,7 @@ import (
 	rsapi "github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/setup/config"
 	"github.com/jchv/maidtrix/test"
+	"github.com/jchv/maidtrix/userapi/api"
 	"github.com/jchv/maidtrix/userapi/storage"
 	userAPITypes "github.com/jchv/maidtrix/userapi/types"
 )
@@ -334,3 +335,44 @@ func BenchmarkLocalRoomMembers(b *testing.B) {
 		assert.Equal(b, expectedLocalMember, members[0])
 	}
 }
+
+func TestBackfillUserDirectory(t *testing.T) {
+	alice := test.NewUser(t)
+	_, sk, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	bob := test.NewUser(t, test.WithSigningServer("notlocalhost", "ed25519:abc", sk))
+
+	room := test.NewRoom(t, alice)
+	room.CreateAndInsert(t, bob, spec.MRoomMember, map[string]string{"membership": spec.Join}, test.WithStateKey(bob.ID))
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, close := testrig.CreateConfig(t, dbType)
+		defer close()
+
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		natsInstance := &jetstream.NATSInstance{}
+		caches := caching.NewRistrettoCache(8*1024*1024, time.Hour, caching.DisableMetrics)
+		rsAPI := roomserver.NewInternalAPI(processCtx, cfg, cm, natsInstance, caches, caching.DisableMetrics)
+		rsAPI.SetFederationAPI(nil, nil)
+		db, err := storage.NewUserDatabase(processCtx.Context(), cm, &cfg.UserAPI.AccountDatabase, cfg.Global.ServerName, bcrypt.MinCost, 1000, 1000, "")
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(processCtx.Context(), alice.Localpart, cfg.Global.ServerName, "", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+
+		err = rsapi.SendEvents(processCtx.Context(), rsAPI, rsapi.KindNew, room.Events(), "", "test", "test", nil, false)
+		assert.NoError(t, err)
+
+		consumer := OutputRoomEventConsumer{db: db, rsAPI: rsAPI, serverName: "test", cfg: &cfg.UserAPI}
+		consumer.backfillUserDirectory(processCtx.Context())
+
+		// The remote member of the public room can be found now
+		profiles, err := db.SearchUserDirectory(processCtx.Context(), bob.Localpart, false, true, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, profiles, 1) {
+			assert.Equal(t, bob.ID, profiles[0].UserID)
+		}
+		backfilled, err := db.UserDirectoryBackfilled(processCtx.Context())
+		assert.NoError(t, err)
+		assert.True(t, backfilled)
+	})
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/util"
+	"github.com/jchv/maidtrix/userapi/api"
+	"github.com/sirupsen/logrus"
+)
+
+// renewalPath is the path of the renewal link in renewal emails, relative
+// to the public base URL.
+const renewalPath = "/_matrix/client/unstable/account_validity/renew"
+
+// QueryAccountValidity returns when the account expires, if it does.
+func (a *UserInternalAPI) QueryAccountValidity(ctx context.Context, req *api.QueryAccountValidityRequest, res *api.QueryAccountValidityResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot QueryAccountValidity of remote users (server name %s)", domain)
+	}
+	validity, err := a.DB.GetAccountValidity(ctx, local, domain)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return fmt.Errorf("a.DB.GetAccountValidity: %w", err)
+	}
+	res.Expires = true
+	res.Validity = *validity
+	return nil
+}
+
+// PerformAccountValidityUpdate sets when the account expires. This is how
+// admins renew accounts, and can also make existing accounts expire.
+func (a *UserInternalAPI) PerformAccountValidityUpdate(ctx context.Context, req *api.PerformAccountValidityUpdateRequest, res *api.PerformAccountValidityUpdateResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot PerformAccountValidityUpdate of remote users (server name %s)", domain)
+	}
+	expirationTS := req.ExpirationTS
+	if expirationTS == 0 {
+		expirationTS = spec.AsTimestamp(time.Now().Add(a.Config.AccountValidity.Period))
+	}
+	// Marking the email as sent stops one from being sent for this period.
+	if err = a.DB.SetAccountValidity(ctx, local, domain, expirationTS, !req.EnableRenewalEmails); err != nil {
+		return fmt.Errorf("a.DB.SetAccountValidity: %w", err)
+	}
+	res.ExpirationTS = expirationTS
+	return nil
+}
+
+// PerformAccountRenewal renews the account which was sent the renewal token
+// for another validity period.
+func (a *UserInternalAPI) PerformAccountRenewal(ctx context.Context, req *api.PerformAccountRenewalRequest, res *api.PerformAccountRenewalResponse) error {
+	if req.RenewalToken == "" {
+		return nil
+	}
+	validity, err := a.DB.GetAccountValidityByRenewalToken(ctx, req.RenewalToken)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return fmt.Errorf("a.DB.GetAccountValidityByRenewalToken: %w", err)
+	}
+	local, domain, err := gomatrixserverlib.SplitID('@', validity.UserID)
+	if err != nil {
+		return err
+	}
+	// Renewing clears the token, so that it can't be used again.
+	expirationTS := spec.AsTimestamp(time.Now().Add(a.Config.AccountValidity.Period))
+	if err = a.DB.SetAccountValidity(ctx, local, domain, expirationTS, false); err != nil {
+		return fmt.Errorf("a.DB.SetAccountValidity: %w", err)
+	}
+	res.Renewed = true
+	res.UserID = validity.UserID
+	res.ExpirationTS = expirationTS
+	return nil
+}
+
+// PerformRenewalEmail sends a renewal email to the user straight away,
+// replacing the renewal token of any email sent before.
+func (a *UserInternalAPI) PerformRenewalEmail(ctx context.Context, req *api.PerformRenewalEmailRequest, res *struct{}) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot PerformRenewalEmail of remote users (server name %s)", domain)
+	}
+	return a.sendRenewalEmail(ctx, local, domain)
+}
+
+// SendRenewalEmails sends renewal emails to the users whose accounts are
+// about to expire, unless they were sent one already.
+func (a *UserInternalAPI) SendRenewalEmails(ctx context.Context) error {
+	cfg := &a.Config.AccountValidity
+	if !cfg.Enabled || cfg.Email.SMTPServer == "" {
+		return nil
+	}
+	accounts, err := a.DB.GetAccountsToRemind(ctx, spec.AsTimestamp(time.Now().Add(cfg.RenewAt)))
+	if err != nil {
+		return fmt.Errorf("a.DB.GetAccountsToRemind: %w", err)
+	}
+	for _, account := range accounts {
+		local, domain, err := gomatrixserverlib.SplitID('@', account.UserID)
+		if err != nil {
+			return err
+		}
+		if err = a.sendRenewalEmail(ctx, local, domain); err != nil {
+			logrus.WithError(err).WithField("user_id", account.UserID).Warn("Failed to send renewal email")
+		}
+	}
+	return nil
+}
+
+func (a *UserInternalAPI) sendRenewalEmail(ctx context.Context, localpart string, serverName spec.ServerName) error {
+	cfg := &a.Config.AccountValidity
+	if !cfg.Enabled || cfg.Email.SMTPServer == "" {
+		return fmt.Errorf("renewal emails are not enabled")
+	}
+	threePIDs, err := a.DB.GetThreePIDsForLocalpart(ctx, localpart, serverName)
+	if err != nil {
+		return fmt.Errorf("a.DB.GetThreePIDsForLocalpart: %w", err)
+	}
+	var addresses []string
+	for _, threePID := range threePIDs {
+		if threePID.Medium == "email" {
+			addresses = append(addresses, threePID.Address)
+		}
+	}
+
+	renewalToken := util.RandomString(32)
+	if len(addresses) == 0 {
+		// There is nothing to deliver, but the email is marked as sent so
+		// that we don't try every time for users without an email address.
+		if err = a.DB.SetRenewalToken(ctx, localpart, serverName, renewalToken, true); err != nil {
+			return fmt.Errorf("a.DB.SetRenewalToken: %w", err)
+		}
+		return fmt.Errorf("user has no email address")
+	}
+	// The token is stored first so that the link works as soon as the email
+	// arrives, but the email is only marked as sent once it was delivered,
+	// so that it is tried again if sending fails.
+	if err = a.DB.SetRenewalToken(ctx, localpart, serverName, renewalToken, false); err != nil {
+		return fmt.Errorf("a.DB.SetRenewalToken: %w", err)
+	}
+
+	link := strings.TrimSuffix(cfg.PublicBaseURL, "/") + renewalPath + "?token=" + url.QueryEscape(renewalToken)
+	msg := "From: " + cfg.Email.From + "\r\n" +
+		"To: " + strings.Join(addresses, ", ") + "\r\n" +
+		"Subject: " + cfg.Email.Subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"Your account @" + localpart + ":" + string(serverName) + " is about to expire.\r\n" +
+		"To keep using it, open the following link:\r\n" +
+		"\r\n" +
+		link + "\r\n"
+
+	var auth smtp.Auth
+	if cfg.Email.SMTPUsername != "" {
+		host, _, _ := net.SplitHostPort(cfg.Email.SMTPServer)
+		auth = smtp.PlainAuth("", cfg.Email.SMTPUsername, cfg.Email.SMTPPassword, host)
+	}
+	sendMail := a.SendMail
+	if sendMail == nil {
+		sendMail = smtp.SendMail
+	}
+	if err = sendMail(cfg.Email.SMTPServer, auth, cfg.Email.From, addresses, []byte(msg)); err != nil {
+		return err
+	}
+	if err = a.DB.SetRenewalToken(ctx, localpart, serverName, renewalToken, true); err != nil {
+		return fmt.Errorf("a.DB.SetRenewalToken: %w", err)
+	}
+	return nil
+}
@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/smtp"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -62,6 +64,10 @@ type UserInternalAPI struct {
 	PgClient    pushgateway.Client
 	FedClient   fedsenderapi.KeyserverFederationAPI
 	Updater     *DeviceListUpdater
+	// UsageStatistics is used by the admin usage statistics endpoint.
+	UsageStatistics *userapiUtil.UsageStatistics
+	// SendMail sends account renewal emails. Defaults to smtp.SendMail.
+	SendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
 }
 
 func (a *UserInternalAPI) PerformAdminCreateRegistrationToken(ctx context.Context, registrationToken *clientapi.RegistrationToken) (bool, error) {
@@ -255,6 +261,13 @@ func (a *UserInternalAPI) PerformAccountCreation(ctx context.Context, req *api.P
 		return nil
 	}
 
+	if a.Config.AccountValidity.Enabled && req.AccountType == api.AccountTypeUser {
+		expirationTS := spec.AsTimestamp(time.Now().Add(a.Config.AccountValidity.Period))
+		if err = a.DB.SetAccountValidity(ctx, req.Localpart, serverName, expirationTS, false); err != nil {
+			return fmt.Errorf("a.DB.SetAccountValidity: %w", err)
+		}
+	}
+
 	if _, _, err = a.DB.SetDisplayName(ctx, req.Localpart, serverName, req.Localpart); err != nil {
 		return fmt.Errorf("a.DB.SetDisplayName: %w", err)
 	}
@@ -386,6 +399,195 @@ func (a *UserInternalAPI) deviceListUpdate(userID string, deviceIDs []string, fr
 	return nil
 }
 
+// PerformDehydratedDeviceCreation creates the user's dehydrated device and
+// uploads its keys, replacing any previous dehydrated device. The dehydrated
+// device is otherwise a normal device, so that other users can query its keys
+// and send it to-device messages.
+func (a *UserInternalAPI) PerformDehydratedDeviceCreation(ctx context.Context, req *api.PerformDehydratedDeviceCreationRequest, res *api.PerformDehydratedDeviceCreationResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot PerformDehydratedDeviceCreation of remote users (server name %s)", domain)
+	}
+	dehydratedDeviceID, _, err := a.DB.GetDehydratedDevice(ctx, local, domain)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("a.DB.GetDehydratedDevice: %w", err)
+	}
+	if req.DeviceID != dehydratedDeviceID {
+		existingDev, err := a.DB.GetDeviceByID(ctx, local, domain, req.DeviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if existingDev != nil && existingDev.ID == req.DeviceID {
+			return &api.ErrorConflict{
+				Message: "device ID is already in use",
+			}
+		}
+	}
+
+	deviceID := req.DeviceID
+	var devRes api.PerformDeviceCreationResponse
+	if err = a.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+		Localpart:         local,
+		ServerName:        domain,
+		AccessToken:       util.RandomString(32),
+		DeviceID:          &deviceID,
+		DeviceDisplayName: req.DisplayName,
+	}, &devRes); err != nil {
+		return err
+	}
+	previousDeviceID, err := a.DB.StoreDehydratedDevice(ctx, local, domain, deviceID, req.DeviceData)
+	if err != nil {
+		return fmt.Errorf("a.DB.StoreDehydratedDevice: %w", err)
+	}
+	if previousDeviceID != "" {
+		if err = a.PerformDeviceDeletion(ctx, &api.PerformDeviceDeletionRequest{
+			UserID:    req.UserID,
+			DeviceIDs: []string{previousDeviceID},
+		}, &api.PerformDeviceDeletionResponse{}); err != nil {
+			return err
+		}
+	}
+
+	uploadReq := &api.PerformUploadKeysRequest{
+		UserID:   req.UserID,
+		DeviceID: deviceID,
+	}
+	if req.DeviceKeys != nil {
+		uploadReq.DeviceKeys = []api.DeviceKeys{*req.DeviceKeys}
+	}
+	if req.OneTimeKeys != nil {
+		uploadReq.OneTimeKeys = []api.OneTimeKeys{*req.OneTimeKeys}
+	}
+	if req.FallbackKeys != nil {
+		uploadReq.FallbackKeys = []api.OneTimeKeys{*req.FallbackKeys}
+	}
+	var uploadRes api.PerformUploadKeysResponse
+	if err = a.PerformUploadKeys(ctx, uploadReq, &uploadRes); err != nil {
+		return err
+	}
+	if uploadRes.Error != nil {
+		return fmt.Errorf("failed to upload dehydrated device keys: %v", uploadRes.Error)
+	}
+	if len(uploadRes.KeyErrors) > 0 {
+		return fmt.Errorf("failed to upload dehydrated device keys, key errors: %+v", uploadRes.KeyErrors)
+	}
+	res.DeviceID = deviceID
+	return nil
+}
+
+// QueryDehydratedDevice returns the user's dehydrated device, if they have one.
+func (a *UserInternalAPI) QueryDehydratedDevice(ctx context.Context, req *api.QueryDehydratedDeviceRequest, res *api.QueryDehydratedDeviceResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot QueryDehydratedDevice of remote users (server name %s)", domain)
+	}
+	res.DeviceID, res.DeviceData, err = a.DB.GetDehydratedDevice(ctx, local, domain)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return fmt.Errorf("a.DB.GetDehydratedDevice: %w", err)
+	}
+	res.Exists = true
+	return nil
+}
+
+// PerformDelayedEventCreation stores a new delayed event for the user, whose
+// delay starts running now.
+func (a *UserInternalAPI) PerformDelayedEventCreation(ctx context.Context, req *api.PerformDelayedEventCreationRequest, res *api.PerformDelayedEventCreationResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.Event.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot PerformDelayedEventCreation for remote users (server name %s)", domain)
+	}
+	event := req.Event
+	event.DelayID = util.RandomString(24)
+	event.RunningSince = spec.AsTimestamp(time.Now())
+	if err = a.DB.StoreDelayedEvent(ctx, local, domain, &event); err != nil {
+		return fmt.Errorf("a.DB.StoreDelayedEvent: %w", err)
+	}
+	res.Event = event
+	return nil
+}
+
+// PerformDelayedEventRestart restarts the delay of a delayed event from now.
+// Returns sql.ErrNoRows if there is no delayed event with the given ID.
+func (a *UserInternalAPI) PerformDelayedEventRestart(ctx context.Context, req *api.PerformDelayedEventRestartRequest, res *api.PerformDelayedEventRestartResponse) error {
+	event, err := a.DB.GetDelayedEvent(ctx, req.DelayID)
+	if err != nil {
+		return err
+	}
+	event.RunningSince = spec.AsTimestamp(time.Now())
+	if err = a.DB.RestartDelayedEvent(ctx, event.DelayID, event.RunningSince); err != nil {
+		return fmt.Errorf("a.DB.RestartDelayedEvent: %w", err)
+	}
+	res.Event = *event
+	return nil
+}
+
+// PerformDelayedEventDeletion deletes either a single delayed event, or all
+// of the delayed state events which would set the given state.
+func (a *UserInternalAPI) PerformDelayedEventDeletion(ctx context.Context, req *api.PerformDelayedEventDeletionRequest, res *api.PerformDelayedEventDeletionResponse) error {
+	if req.DelayID != "" {
+		if err := a.DB.RemoveDelayedEvent(ctx, req.DelayID); err != nil {
+			return fmt.Errorf("a.DB.RemoveDelayedEvent: %w", err)
+		}
+		res.DelayIDs = []string{req.DelayID}
+		return nil
+	}
+	if req.RoomID == "" || req.EventType == "" || req.StateKey == nil {
+		return fmt.Errorf("either a delay ID or a room ID, event type and state key must be given")
+	}
+	delayIDs, err := a.DB.RemoveDelayedStateEvents(ctx, req.RoomID, req.EventType, *req.StateKey)
+	if err != nil {
+		return fmt.Errorf("a.DB.RemoveDelayedStateEvents: %w", err)
+	}
+	res.DelayIDs = delayIDs
+	return nil
+}
+
+// QueryDelayedEvents returns a single delayed event by ID, the delayed events
+// of a user, or every pending delayed event if neither is given.
+func (a *UserInternalAPI) QueryDelayedEvents(ctx context.Context, req *api.QueryDelayedEventsRequest, res *api.QueryDelayedEventsResponse) error {
+	res.DelayedEvents = []api.DelayedEvent{}
+	switch {
+	case req.DelayID != "":
+		event, err := a.DB.GetDelayedEvent(ctx, req.DelayID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil
+		case err != nil:
+			return fmt.Errorf("a.DB.GetDelayedEvent: %w", err)
+		}
+		if req.UserID == "" || req.UserID == event.UserID {
+			res.DelayedEvents = append(res.DelayedEvents, *event)
+		}
+		return nil
+	case req.UserID != "":
+		local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+		if err != nil {
+			return err
+		}
+		if !a.Config.Matrix.IsLocalServerName(domain) {
+			return fmt.Errorf("cannot QueryDelayedEvents of remote users (server name %s)", domain)
+		}
+		res.DelayedEvents, err = a.DB.GetDelayedEvents(ctx, local, domain)
+		return err
+	default:
+		var err error
+		res.DelayedEvents, err = a.DB.GetAllDelayedEvents(ctx)
+		return err
+	}
+}
+
 func (a *UserInternalAPI) PerformLastSeenUpdate(
 	ctx context.Context,
 	req *api.PerformLastSeenUpdateRequest,
@@ -486,6 +688,24 @@ func (a *UserInternalAPI) QuerySearchProfiles(ctx context.Context, req *api.Quer
 	return nil
 }
 
+// QuerySearchUserDirectory searches the users who can be found in the user
+// directory: local users in public rooms, or every local user if configured
+// to, and optionally remote users in public rooms.
+func (a *UserInternalAPI) QuerySearchUserDirectory(ctx context.Context, req *api.QuerySearchUserDirectoryRequest, res *api.QuerySearchUserDirectoryResponse) error {
+	cfg := &a.Config.UserDirectory
+	// Ask for one more than the limit to know whether the results are limited.
+	profiles, err := a.DB.SearchUserDirectory(ctx, req.SearchString, cfg.SearchAllUsers, cfg.IncludeRemoteUsers, req.Limit+1)
+	if err != nil {
+		return fmt.Errorf("a.DB.SearchUserDirectory: %w", err)
+	}
+	if len(profiles) > req.Limit {
+		profiles = profiles[:req.Limit]
+		res.Limited = true
+	}
+	res.Profiles = profiles
+	return nil
+}
+
 func (a *UserInternalAPI) QueryDeviceInfos(ctx context.Context, req *api.QueryDeviceInfosRequest, res *api.QueryDeviceInfosResponse) error {
 	devices, err := a.DB.GetDevicesByID(ctx, req.DeviceIDs)
 	if err != nil {
@@ -593,6 +813,22 @@ func (a *UserInternalAPI) QueryAccessToken(ctx context.Context, req *api.QueryAc
 		return err
 	}
 	device.AccountType = acc.AccountType
+	if a.Config.AccountValidity.Enabled {
+		validity, err := a.DB.GetAccountValidity(ctx, localPart, domain)
+		switch {
+		case err == nil:
+			device.AccountExpired = validity.Expired(time.Now())
+		case !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("a.DB.GetAccountValidity: %w", err)
+		}
+	}
+	if a.Config.UserConsent.Enabled && a.Config.UserConsent.BlockUntilConsented && consentApplies(acc.AccountType) {
+		version, err := a.DB.GetConsentVersion(ctx, localPart, domain)
+		if err != nil {
+			return fmt.Errorf("a.DB.GetConsentVersion: %w", err)
+		}
+		device.ConsentRequired = version != a.Config.UserConsent.Version
+	}
 	res.Device = device
 	return nil
 }
@@ -979,3 +1215,58 @@ func (a *UserInternalAPI) PerformSaveThreePIDAssociation(ctx context.Context, re
 }
 
 const pushRulesAccountDataType = "m.push_rules"
+
+// QueryServerNoticeRecipients returns the local users matching the filters,
+// leaving out the server notices user itself.
+func (a *UserInternalAPI) QueryServerNoticeRecipients(ctx context.Context, req *api.QueryServerNoticeRecipientsRequest, res *api.QueryServerNoticeRecipientsResponse) error {
+	var localpartRegex *regexp.Regexp
+	if req.LocalpartRegex != "" {
+		var err error
+		if localpartRegex, err = regexp.Compile(req.LocalpartRegex); err != nil {
+			return fmt.Errorf("invalid localpart regex: %w", err)
+		}
+	}
+	userIDs, err := a.DB.GetServerNoticeRecipients(ctx, req.AdminsOnly, req.ActiveSince)
+	if err != nil {
+		return fmt.Errorf("a.DB.GetServerNoticeRecipients: %w", err)
+	}
+	res.UserIDs = make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		localpart, domain, err := gomatrixserverlib.SplitID('@', userID)
+		if err != nil {
+			continue
+		}
+		if localpart == a.Config.Matrix.ServerNotices.LocalPart && a.Config.Matrix.IsLocalServerName(domain) {
+			continue
+		}
+		if localpartRegex != nil && !localpartRegex.MatchString(localpart) {
+			continue
+		}
+		res.UserIDs = append(res.UserIDs, userID)
+	}
+	return nil
+}
+
+func (a *UserInternalAPI) QueryResourceUsage(ctx context.Context, req *api.QueryResourceUsageRequest, res *api.QueryResourceUsageResponse) error {
+	if a.UsageStatistics == nil {
+		return fmt.Errorf("usage statistics are not available")
+	}
+	var err error
+	if res.MonthlyActiveUsers, err = a.DB.MonthlyActiveUsers(ctx); err != nil {
+		return err
+	}
+	res.MediaSize, err = a.UsageStatistics.MediaStoreSize()
+	return err
+}
+
+func (a *UserInternalAPI) QueryUsageStatistics(ctx context.Context, req *api.QueryUsageStatisticsRequest, res *api.QueryUsageStatisticsResponse) error {
+	if a.UsageStatistics == nil {
+		return fmt.Errorf("usage statistics are not available")
+	}
+	stats, err := a.UsageStatistics.Collect(ctx, req.Format)
+	if err != nil {
+		return err
+	}
+	res.Statistics = stats
+	return nil
+}
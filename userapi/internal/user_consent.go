@@ -0,0 +1,98 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	gomatrixserverlib "github.com/jchv/maidtrix/internal/matrixserver"
+	"github.com/jchv/maidtrix/userapi/api"
+)
+
+// consentApplies returns whether accounts of the given type have to accept
+// the policy documents. Guests, appservices and the server notices user don't.
+func consentApplies(accountType api.AccountType) bool {
+	return accountType == api.AccountTypeUser || accountType == api.AccountTypeAdmin
+}
+
+// QueryUserConsent returns which version of the policy documents the user
+// accepted, and whether they still have to accept the current one.
+func (a *UserInternalAPI) QueryUserConsent(ctx context.Context, req *api.QueryUserConsentRequest, res *api.QueryUserConsentResponse) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot QueryUserConsent of remote users (server name %s)", domain)
+	}
+	res.ConsentVersion, err = a.DB.GetConsentVersion(ctx, local, domain)
+	if err != nil {
+		return fmt.Errorf("a.DB.GetConsentVersion: %w", err)
+	}
+	res.ConsentRequired = a.Config.UserConsent.Enabled && res.ConsentVersion != a.Config.UserConsent.Version
+	return nil
+}
+
+// PerformUserConsent records that the user accepted the given version of the
+// policy documents.
+func (a *UserInternalAPI) PerformUserConsent(ctx context.Context, req *api.PerformUserConsentRequest, res *struct{}) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot PerformUserConsent of remote users (server name %s)", domain)
+	}
+	version := req.Version
+	if version == "" {
+		version = a.Config.UserConsent.Version
+	}
+	if err = a.DB.SetConsentVersion(ctx, local, domain, version); err != nil {
+		return fmt.Errorf("a.DB.SetConsentVersion: %w", err)
+	}
+	return nil
+}
+
+// QueryConsentNoticeUsers returns the users who should be sent a server
+// notice asking them to accept the current version of the policy documents.
+func (a *UserInternalAPI) QueryConsentNoticeUsers(ctx context.Context, req *struct{}, res *api.QueryConsentNoticeUsersResponse) error {
+	if !a.Config.UserConsent.Enabled {
+		return nil
+	}
+	userIDs, err := a.DB.GetUsersToNotifyOfConsent(ctx, a.Config.UserConsent.Version)
+	if err != nil {
+		return fmt.Errorf("a.DB.GetUsersToNotifyOfConsent: %w", err)
+	}
+	res.UserIDs = userIDs
+	return nil
+}
+
+// PerformConsentNoticeSent records that the user was sent a server notice
+// about the current version of the policy documents, so that it isn't sent
+// again.
+func (a *UserInternalAPI) PerformConsentNoticeSent(ctx context.Context, req *api.PerformConsentNoticeSentRequest, res *struct{}) error {
+	local, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return err
+	}
+	if !a.Config.Matrix.IsLocalServerName(domain) {
+		return fmt.Errorf("cannot PerformConsentNoticeSent of remote users (server name %s)", domain)
+	}
+	if err = a.DB.SetConsentNoticeVersion(ctx, local, domain, a.Config.UserConsent.Version); err != nil {
+		return fmt.Errorf("a.DB.SetConsentNoticeVersion: %w", err)
+	}
+	return nil
+}
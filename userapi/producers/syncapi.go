@@ -71,10 +71,23 @@ func (p *SyncAPI) GetAndSendNotificationData(ctx context.Context, userID, roomID
 		return err
 	}
 
+	threadTotals, threadHighlights, err := p.db.GetRoomThreadNotificationCounts(ctx, localpart, domain, roomID)
+	if err != nil {
+		return err
+	}
+	threads := make(map[string]eventutil.ThreadNotificationData, len(threadTotals))
+	for threadID, total := range threadTotals {
+		threads[threadID] = eventutil.ThreadNotificationData{
+			UnreadHighlightCount:    int(threadHighlights[threadID]),
+			UnreadNotificationCount: int(total),
+		}
+	}
+
 	return p.sendNotificationData(userID, &eventutil.NotificationData{
-		RoomID:                  roomID,
-		UnreadHighlightCount:    int(nhighlight),
-		UnreadNotificationCount: int(ntotal),
+		RoomID:                    roomID,
+		UnreadHighlightCount:      int(nhighlight),
+		UnreadNotificationCount:   int(ntotal),
+		UnreadThreadNotifications: threads,
 	})
 }
 
@@ -58,6 +58,9 @@ type Account interface {
 	GetAccountByLocalpart(ctx context.Context, localpart string, serverName spec.ServerName) (*api.Account, error)
 	DeactivateAccount(ctx context.Context, localpart string, serverName spec.ServerName) (err error)
 	SetPassword(ctx context.Context, localpart string, serverName spec.ServerName, plaintextPassword string) error
+	// GetServerNoticeRecipients returns the local users who can be sent server notices, optionally
+	// only the admins, or only those with a device seen since activeSince if it isn't zero.
+	GetServerNoticeRecipients(ctx context.Context, adminsOnly bool, activeSince spec.Timestamp) ([]string, error)
 }
 
 type AccountData interface {
@@ -90,6 +93,71 @@ type Device interface {
 	RemoveAllDevices(ctx context.Context, localpart string, serverName spec.ServerName, exceptDeviceID string) (devices []api.Device, err error)
 }
 
+type DehydratedDevice interface {
+	// StoreDehydratedDevice stores the pickled data for the user's dehydrated device, which must already exist.
+	// Returns the ID of the previous dehydrated device if it was replaced by a different one.
+	StoreDehydratedDevice(ctx context.Context, localpart string, serverName spec.ServerName, deviceID string, deviceData json.RawMessage) (previousDeviceID string, err error)
+	// GetDehydratedDevice returns sql.ErrNoRows if the user has no dehydrated device.
+	GetDehydratedDevice(ctx context.Context, localpart string, serverName spec.ServerName) (deviceID string, deviceData json.RawMessage, err error)
+}
+
+type AccountValidity interface {
+	// SetAccountValidity sets when the account expires, forgetting about any renewal email sent before.
+	SetAccountValidity(ctx context.Context, localpart string, serverName spec.ServerName, expirationTS spec.Timestamp, emailSent bool) error
+	// GetAccountValidity returns sql.ErrNoRows if the account doesn't expire.
+	GetAccountValidity(ctx context.Context, localpart string, serverName spec.ServerName) (*api.AccountValidity, error)
+	// GetAccountValidityByRenewalToken returns sql.ErrNoRows if the renewal token is unknown.
+	GetAccountValidityByRenewalToken(ctx context.Context, renewalToken string) (*api.AccountValidity, error)
+	// SetRenewalToken stores the token of the renewal email for the user, and whether the email has been sent.
+	SetRenewalToken(ctx context.Context, localpart string, serverName spec.ServerName, renewalToken string, emailSent bool) error
+	// GetAccountsToRemind returns the accounts expiring before the given time which haven't been sent a renewal email.
+	GetAccountsToRemind(ctx context.Context, expiringBefore spec.Timestamp) ([]api.AccountValidity, error)
+}
+
+type UserConsent interface {
+	// SetConsentVersion records that the user accepted the given version of the policy documents.
+	SetConsentVersion(ctx context.Context, localpart string, serverName spec.ServerName, version string) error
+	// SetConsentNoticeVersion records that the user was sent a server notice about the given version.
+	SetConsentNoticeVersion(ctx context.Context, localpart string, serverName spec.ServerName, version string) error
+	// GetConsentVersion returns an empty version if the user never gave consent.
+	GetConsentVersion(ctx context.Context, localpart string, serverName spec.ServerName) (string, error)
+	// GetUsersToNotifyOfConsent returns the local users who haven't accepted the given version,
+	// and haven't been sent a server notice about it either.
+	GetUsersToNotifyOfConsent(ctx context.Context, version string) ([]string, error)
+}
+
+type UserDirectory interface {
+	// AddPublicRoomUser records that the user is joined to the public room. The profile
+	// is only stored for remote users, local users keep their global profile.
+	AddPublicRoomUser(ctx context.Context, roomID, userID string, isLocal bool, displayName, avatarURL string) error
+	// RemovePublicRoomUser records that the user left the public room.
+	RemovePublicRoomUser(ctx context.Context, roomID, userID string) error
+	// RemovePublicRoom records that the room is no longer public.
+	RemovePublicRoom(ctx context.Context, roomID string) error
+	// UserDirectoryBackfilled returns whether the members of the public rooms which existed
+	// before the user directory have been added to it, see MarkUserDirectoryBackfilled.
+	UserDirectoryBackfilled(ctx context.Context) (bool, error)
+	MarkUserDirectoryBackfilled(ctx context.Context) error
+	// LocalUserDirectoryUsers returns the IDs of the local users in the user directory.
+	LocalUserDirectoryUsers(ctx context.Context) ([]string, error)
+	// SearchUserDirectory returns the users whose localpart or display name contain the
+	// search string, best matches first.
+	SearchUserDirectory(ctx context.Context, searchString string, searchAllUsers, includeRemoteUsers bool, limit int) ([]authtypes.FullyQualifiedProfile, error)
+}
+
+type DelayedEvents interface {
+	StoreDelayedEvent(ctx context.Context, localpart string, serverName spec.ServerName, event *api.DelayedEvent) error
+	// GetDelayedEvent returns sql.ErrNoRows if there is no delayed event with the given ID.
+	GetDelayedEvent(ctx context.Context, delayID string) (*api.DelayedEvent, error)
+	GetDelayedEvents(ctx context.Context, localpart string, serverName spec.ServerName) ([]api.DelayedEvent, error)
+	GetAllDelayedEvents(ctx context.Context) ([]api.DelayedEvent, error)
+	RestartDelayedEvent(ctx context.Context, delayID string, runningSince spec.Timestamp) error
+	RemoveDelayedEvent(ctx context.Context, delayID string) error
+	// RemoveDelayedStateEvents removes all delayed state events with the given type and state key
+	// in the room, returning the IDs of the removed delayed events.
+	RemoveDelayedStateEvents(ctx context.Context, roomID, eventType, stateKey string) ([]string, error)
+}
+
 type KeyBackup interface {
 	CreateKeyBackup(ctx context.Context, userID, algorithm string, authData json.RawMessage) (version string, err error)
 	UpdateKeyBackupAuthData(ctx context.Context, userID, version string, authData json.RawMessage) (err error)
@@ -133,19 +201,27 @@ type ThreePID interface {
 }
 
 type Notification interface {
-	InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error
+	InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID, threadID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error
 	DeleteNotificationsUpTo(ctx context.Context, localpart string, serverName spec.ServerName, roomID string, pos uint64) (affected bool, err error)
-	SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID string, pos uint64, read bool) (affected bool, err error)
+	// SetNotificationsRead marks notifications in the room up to pos as read. If threadID
+	// is empty then all threads are affected, otherwise only the given one.
+	SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID, threadID string, pos uint64, read bool) (affected bool, err error)
 	GetNotifications(ctx context.Context, localpart string, serverName spec.ServerName, fromID int64, limit int, filter tables.NotificationFilter) ([]*api.Notification, int64, error)
 	GetNotificationCount(ctx context.Context, localpart string, serverName spec.ServerName, filter tables.NotificationFilter) (int64, error)
 	GetRoomNotificationCounts(ctx context.Context, localpart string, serverName spec.ServerName, roomID string) (total int64, highlight int64, _ error)
+	GetRoomThreadNotificationCounts(ctx context.Context, localpart string, serverName spec.ServerName, roomID string) (total map[string]int64, highlight map[string]int64, _ error)
 	DeleteOldNotifications(ctx context.Context) error
 }
 
 type UserDatabase interface {
 	Account
 	AccountData
+	AccountValidity
+	UserConsent
+	UserDirectory
 	Device
+	DehydratedDevice
+	DelayedEvents
 	KeyBackup
 	LoginToken
 	Notification
@@ -175,6 +251,12 @@ type KeyDatabase interface {
 	// OneTimeKeysCount returns a count of all OTKs for this device.
 	OneTimeKeysCount(ctx context.Context, userID, deviceID string) (*api.OneTimeKeysCount, error)
 
+	// StoreFallbackKeys persists the given fallback keys, replacing any existing fallback keys for the same algorithms.
+	StoreFallbackKeys(ctx context.Context, keys api.OneTimeKeys) error
+
+	// UnusedFallbackKeyAlgorithms returns the algorithms for which this device has a fallback key that has not been claimed yet.
+	UnusedFallbackKeyAlgorithms(ctx context.Context, userID, deviceID string) ([]string, error)
+
 	// DeviceKeysJSON populates the KeyJSON for the given keys. If any proided `keys` have a `KeyJSON` or `StreamID` already then it will be replaced.
 	DeviceKeysJSON(ctx context.Context, keys []api.DeviceMessage) error
 
@@ -200,7 +282,8 @@ type KeyDatabase interface {
 	// cross-signing signatures relating to that device.
 	DeleteDeviceKeys(ctx context.Context, userID string, deviceIDs []gomatrixserverlib.KeyID) error
 
-	// ClaimKeys based on the 3-uple of user_id, device_id and algorithm name. Returns the keys claimed. Returns no error if a key
+	// ClaimKeys based on the 3-uple of user_id, device_id and algorithm name. Returns the keys claimed. If there are no one-time keys
+	// left for this (user, device, algorithm) then the fallback key is returned instead, if there is one. Returns no error if a key
 	// cannot be claimed or if none exist for this (user, device, algorithm), instead it is omitted from the returned slice.
 	ClaimKeys(ctx context.Context, userToDeviceToAlgorithm map[string]map[string]string) ([]api.OneTimeKeys, error)
 
@@ -231,6 +314,7 @@ type KeyDatabase interface {
 
 type Statistics interface {
 	UserStatistics(ctx context.Context) (*types.UserStatistics, *types.DatabaseEngine, error)
+	MonthlyActiveUsers(ctx context.Context) (int64, error)
 	DailyRoomsMessages(ctx context.Context, serverName spec.ServerName) (stats types.MessageStats, activeRooms, activeE2EERooms int64, err error)
 	UpsertDailyRoomsMessages(ctx context.Context, serverName spec.ServerName, stats types.MessageStats, activeRooms, activeE2EERooms int64) error
 }
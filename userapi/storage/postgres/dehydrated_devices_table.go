@@ -0,0 +1,93 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/userapi/storage/tables"
+)
+
+const dehydratedDevicesSchema = `
+-- Stores the dehydrated device for each user, as per MSC3814. There is at
+-- most one dehydrated device per user.
+CREATE TABLE IF NOT EXISTS userapi_dehydrated_devices (
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	-- The ID of the device in userapi_devices.
+	device_id TEXT NOT NULL,
+	-- The pickled device data, as provided by the client.
+	device_data TEXT NOT NULL,
+	PRIMARY KEY (localpart, server_name)
+);
+`
+
+const upsertDehydratedDeviceSQL = "" +
+	"INSERT INTO userapi_dehydrated_devices (localpart, server_name, device_id, device_data)" +
+	" VALUES ($1, $2, $3, $4)" +
+	" ON CONFLICT (localpart, server_name)" +
+	" DO UPDATE SET device_id = $3, device_data = $4"
+
+const selectDehydratedDeviceSQL = "" +
+	"SELECT device_id, device_data FROM userapi_dehydrated_devices WHERE localpart = $1 AND server_name = $2"
+
+const deleteDehydratedDeviceSQL = "" +
+	"DELETE FROM userapi_dehydrated_devices WHERE localpart = $1 AND server_name = $2"
+
+type dehydratedDevicesStatements struct {
+	upsertDehydratedDeviceStmt *sql.Stmt
+	selectDehydratedDeviceStmt *sql.Stmt
+	deleteDehydratedDeviceStmt *sql.Stmt
+}
+
+func NewPostgresDehydratedDevicesTable(db *sql.DB) (tables.DehydratedDevicesTable, error) {
+	s := &dehydratedDevicesStatements{}
+	_, err := db.Exec(dehydratedDevicesSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertDehydratedDeviceStmt, upsertDehydratedDeviceSQL},
+		{&s.selectDehydratedDeviceStmt, selectDehydratedDeviceSQL},
+		{&s.deleteDehydratedDeviceStmt, deleteDehydratedDeviceSQL},
+	}.Prepare(db)
+}
+
+func (s *dehydratedDevicesStatements) UpsertDehydratedDevice(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+	deviceID string, deviceData json.RawMessage,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertDehydratedDeviceStmt).ExecContext(ctx, localpart, serverName, deviceID, string(deviceData))
+	return err
+}
+
+func (s *dehydratedDevicesStatements) SelectDehydratedDevice(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+) (deviceID string, deviceData json.RawMessage, err error) {
+	var data string
+	err = sqlutil.TxStmt(txn, s.selectDehydratedDeviceStmt).QueryRowContext(ctx, localpart, serverName).Scan(&deviceID, &data)
+	return deviceID, json.RawMessage(data), err
+}
+
+func (s *dehydratedDevicesStatements) DeleteDehydratedDevice(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteDehydratedDeviceStmt).ExecContext(ctx, localpart, serverName)
+	return err
+}
@@ -0,0 +1,207 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jchv/maidtrix/clientapi/userutil"
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/userapi/api"
+	"github.com/jchv/maidtrix/userapi/storage/tables"
+)
+
+const delayedEventsSchema = `
+-- Stores events which users asked to be sent later, as per MSC4140.
+CREATE TABLE IF NOT EXISTS userapi_delayed_events (
+	delay_id TEXT NOT NULL PRIMARY KEY,
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	-- NULL for message events.
+	state_key TEXT,
+	content TEXT NOT NULL,
+	-- How long to wait before sending the event, in milliseconds.
+	delay BIGINT NOT NULL,
+	-- When the delay started, which is moved forward when the delay is restarted.
+	running_since BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS userapi_delayed_events_user_idx ON userapi_delayed_events(localpart, server_name);
+CREATE INDEX IF NOT EXISTS userapi_delayed_events_state_idx ON userapi_delayed_events(room_id, event_type, state_key);
+`
+
+const insertDelayedEventSQL = "" +
+	"INSERT INTO userapi_delayed_events (delay_id, localpart, server_name, room_id, event_type, state_key, content, delay, running_since)" +
+	" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+
+const selectDelayedEventSQL = "" +
+	"SELECT delay_id, localpart, server_name, room_id, event_type, state_key, content, delay, running_since" +
+	" FROM userapi_delayed_events WHERE delay_id = $1"
+
+const selectDelayedEventsByLocalpartSQL = "" +
+	"SELECT delay_id, localpart, server_name, room_id, event_type, state_key, content, delay, running_since" +
+	" FROM userapi_delayed_events WHERE localpart = $1 AND server_name = $2 ORDER BY running_since + delay ASC"
+
+const selectAllDelayedEventsSQL = "" +
+	"SELECT delay_id, localpart, server_name, room_id, event_type, state_key, content, delay, running_since" +
+	" FROM userapi_delayed_events ORDER BY running_since + delay ASC"
+
+const updateDelayedEventRunningSinceSQL = "" +
+	"UPDATE userapi_delayed_events SET running_since = $1 WHERE delay_id = $2"
+
+const deleteDelayedEventSQL = "" +
+	"DELETE FROM userapi_delayed_events WHERE delay_id = $1"
+
+const selectDelayedStateEventIDsSQL = "" +
+	"SELECT delay_id FROM userapi_delayed_events WHERE room_id = $1 AND event_type = $2 AND state_key = $3"
+
+type delayedEventsStatements struct {
+	insertDelayedEventStmt             *sql.Stmt
+	selectDelayedEventStmt             *sql.Stmt
+	selectDelayedEventsByLocalpartStmt *sql.Stmt
+	selectAllDelayedEventsStmt         *sql.Stmt
+	updateDelayedEventRunningSinceStmt *sql.Stmt
+	deleteDelayedEventStmt             *sql.Stmt
+	selectDelayedStateEventIDsStmt     *sql.Stmt
+}
+
+func NewPostgresDelayedEventsTable(db *sql.DB) (tables.DelayedEventsTable, error) {
+	s := &delayedEventsStatements{}
+	_, err := db.Exec(delayedEventsSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.insertDelayedEventStmt, insertDelayedEventSQL},
+		{&s.selectDelayedEventStmt, selectDelayedEventSQL},
+		{&s.selectDelayedEventsByLocalpartStmt, selectDelayedEventsByLocalpartSQL},
+		{&s.selectAllDelayedEventsStmt, selectAllDelayedEventsSQL},
+		{&s.updateDelayedEventRunningSinceStmt, updateDelayedEventRunningSinceSQL},
+		{&s.deleteDelayedEventStmt, deleteDelayedEventSQL},
+		{&s.selectDelayedStateEventIDsStmt, selectDelayedStateEventIDsSQL},
+	}.Prepare(db)
+}
+
+func (s *delayedEventsStatements) InsertDelayedEvent(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, event *api.DelayedEvent,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.insertDelayedEventStmt).ExecContext(
+		ctx, event.DelayID, localpart, serverName, event.RoomID, event.EventType,
+		event.StateKey, string(event.Content), event.Delay, event.RunningSince,
+	)
+	return err
+}
+
+func (s *delayedEventsStatements) SelectDelayedEvent(
+	ctx context.Context, txn *sql.Tx, delayID string,
+) (*api.DelayedEvent, error) {
+	return scanDelayedEvent(sqlutil.TxStmt(txn, s.selectDelayedEventStmt).QueryRowContext(ctx, delayID))
+}
+
+func (s *delayedEventsStatements) SelectDelayedEventsByLocalpart(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+) ([]api.DelayedEvent, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectDelayedEventsByLocalpartStmt).QueryContext(ctx, localpart, serverName)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectDelayedEventsByLocalpartStmt: rows.close() failed")
+	return scanDelayedEvents(rows)
+}
+
+func (s *delayedEventsStatements) SelectAllDelayedEvents(
+	ctx context.Context, txn *sql.Tx,
+) ([]api.DelayedEvent, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectAllDelayedEventsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectAllDelayedEventsStmt: rows.close() failed")
+	return scanDelayedEvents(rows)
+}
+
+func (s *delayedEventsStatements) UpdateDelayedEventRunningSince(
+	ctx context.Context, txn *sql.Tx, delayID string, runningSince spec.Timestamp,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.updateDelayedEventRunningSinceStmt).ExecContext(ctx, runningSince, delayID)
+	return err
+}
+
+func (s *delayedEventsStatements) DeleteDelayedEvent(
+	ctx context.Context, txn *sql.Tx, delayID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteDelayedEventStmt).ExecContext(ctx, delayID)
+	return err
+}
+
+func (s *delayedEventsStatements) SelectDelayedStateEventIDs(
+	ctx context.Context, txn *sql.Tx, roomID, eventType, stateKey string,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectDelayedStateEventIDsStmt).QueryContext(ctx, roomID, eventType, stateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectDelayedStateEventIDsStmt: rows.close() failed")
+	var delayIDs []string
+	for rows.Next() {
+		var delayID string
+		if err = rows.Scan(&delayID); err != nil {
+			return nil, err
+		}
+		delayIDs = append(delayIDs, delayID)
+	}
+	return delayIDs, rows.Err()
+}
+
+type delayedEventScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanDelayedEvent(row delayedEventScanner) (*api.DelayedEvent, error) {
+	var event api.DelayedEvent
+	var localpart, content string
+	var serverName spec.ServerName
+	var stateKey sql.NullString
+	if err := row.Scan(
+		&event.DelayID, &localpart, &serverName, &event.RoomID, &event.EventType,
+		&stateKey, &content, &event.Delay, &event.RunningSince,
+	); err != nil {
+		return nil, err
+	}
+	event.UserID = userutil.MakeUserID(localpart, serverName)
+	if stateKey.Valid {
+		event.StateKey = &stateKey.String
+	}
+	event.Content = json.RawMessage(content)
+	return &event, nil
+}
+
+func scanDelayedEvents(rows *sql.Rows) ([]api.DelayedEvent, error) {
+	events := []api.DelayedEvent{}
+	for rows.Next() {
+		event, err := scanDelayedEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,26 @@
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpPopulateUserDirectory adds the local users who existed before the user
+// directory table to it. Guests and the server notices user aren't listed.
+// The members of public rooms come from the roomserver, so the userapi
+// consumer backfills them at startup instead.
+func UpPopulateUserDirectory(ctx context.Context, tx *sql.Tx, serverNoticesLocalpart string) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO userapi_user_directory (user_id, localpart, server_name, is_local, display_name, avatar_url)
+	SELECT '@' || p.localpart || ':' || p.server_name, p.localpart, p.server_name, TRUE,
+		COALESCE(p.display_name, ''), COALESCE(p.avatar_url, '')
+	FROM userapi_profiles p
+	JOIN userapi_accounts a ON a.localpart = p.localpart AND a.server_name = p.server_name
+	WHERE a.account_type != 2 AND a.is_deactivated = FALSE AND a.localpart != $1
+ON CONFLICT (user_id) DO NOTHING;`, serverNoticesLocalpart)
+	if err != nil {
+		return fmt.Errorf("failed to populate user directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpNotificationThreadID adds the thread ID to notifications. Notifications
+// stored before threads were tracked are counted against the main timeline.
+func UpNotificationThreadID(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+ALTER TABLE userapi_notifications ADD COLUMN IF NOT EXISTS thread_id TEXT NOT NULL DEFAULT 'main';`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
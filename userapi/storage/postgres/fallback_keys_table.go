@@ -0,0 +1,135 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/userapi/api"
+	"github.com/jchv/maidtrix/userapi/storage/tables"
+)
+
+var fallbackKeysSchema = `
+-- Stores fallback keys for users, which are handed out when there are no
+-- one-time keys left. There is at most one fallback key per algorithm.
+CREATE TABLE IF NOT EXISTS keyserver_fallback_keys (
+    user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	algorithm TEXT NOT NULL,
+	key_id TEXT NOT NULL,
+	key_json TEXT NOT NULL,
+	-- Whether the key has been claimed since it was uploaded.
+	used BOOLEAN NOT NULL DEFAULT FALSE,
+    CONSTRAINT keyserver_fallback_keys_unique UNIQUE (user_id, device_id, algorithm)
+);
+`
+
+const upsertFallbackKeySQL = "" +
+	"INSERT INTO keyserver_fallback_keys (user_id, device_id, algorithm, key_id, key_json, used)" +
+	" VALUES ($1, $2, $3, $4, $5, FALSE)" +
+	" ON CONFLICT ON CONSTRAINT keyserver_fallback_keys_unique" +
+	" DO UPDATE SET key_id = $4, key_json = $5, used = FALSE"
+
+const selectUnusedFallbackKeyAlgorithmsSQL = "" +
+	"SELECT algorithm FROM keyserver_fallback_keys WHERE user_id = $1 AND device_id = $2 AND used = FALSE"
+
+const selectFallbackKeyByAlgorithmSQL = "" +
+	"SELECT key_id, key_json FROM keyserver_fallback_keys WHERE user_id = $1 AND device_id = $2 AND algorithm = $3"
+
+const markFallbackKeyUsedSQL = "" +
+	"UPDATE keyserver_fallback_keys SET used = TRUE WHERE user_id = $1 AND device_id = $2 AND algorithm = $3"
+
+const deleteFallbackKeysSQL = "" +
+	"DELETE FROM keyserver_fallback_keys WHERE user_id = $1 AND device_id = $2"
+
+type fallbackKeysStatements struct {
+	upsertFallbackKeyStmt                 *sql.Stmt
+	selectUnusedFallbackKeyAlgorithmsStmt *sql.Stmt
+	selectFallbackKeyByAlgorithmStmt      *sql.Stmt
+	markFallbackKeyUsedStmt               *sql.Stmt
+	deleteFallbackKeysStmt                *sql.Stmt
+}
+
+func NewPostgresFallbackKeysTable(db *sql.DB) (tables.FallbackKeys, error) {
+	s := &fallbackKeysStatements{}
+	_, err := db.Exec(fallbackKeysSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertFallbackKeyStmt, upsertFallbackKeySQL},
+		{&s.selectUnusedFallbackKeyAlgorithmsStmt, selectUnusedFallbackKeyAlgorithmsSQL},
+		{&s.selectFallbackKeyByAlgorithmStmt, selectFallbackKeyByAlgorithmSQL},
+		{&s.markFallbackKeyUsedStmt, markFallbackKeyUsedSQL},
+		{&s.deleteFallbackKeysStmt, deleteFallbackKeysSQL},
+	}.Prepare(db)
+}
+
+func (s *fallbackKeysStatements) InsertFallbackKeys(ctx context.Context, txn *sql.Tx, keys api.OneTimeKeys) error {
+	for keyIDWithAlgo, keyJSON := range keys.KeyJSON {
+		algo, keyID := keys.Split(keyIDWithAlgo)
+		_, err := sqlutil.TxStmt(txn, s.upsertFallbackKeyStmt).ExecContext(
+			ctx, keys.UserID, keys.DeviceID, algo, keyID, string(keyJSON),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fallbackKeysStatements) SelectUnusedFallbackKeyAlgorithms(ctx context.Context, userID, deviceID string) ([]string, error) {
+	rows, err := s.selectUnusedFallbackKeyAlgorithmsStmt.QueryContext(ctx, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectUnusedFallbackKeyAlgorithmsStmt: rows.close() failed")
+	algorithms := []string{}
+	for rows.Next() {
+		var algorithm string
+		if err = rows.Scan(&algorithm); err != nil {
+			return nil, err
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+	return algorithms, rows.Err()
+}
+
+func (s *fallbackKeysStatements) SelectAndMarkFallbackKey(
+	ctx context.Context, txn *sql.Tx, userID, deviceID, algorithm string,
+) (map[string]json.RawMessage, error) {
+	var keyID string
+	var keyJSON string
+	err := sqlutil.TxStmtContext(ctx, txn, s.selectFallbackKeyByAlgorithmStmt).QueryRowContext(ctx, userID, deviceID, algorithm).Scan(&keyID, &keyJSON)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	_, err = sqlutil.TxStmtContext(ctx, txn, s.markFallbackKeyUsedStmt).ExecContext(ctx, userID, deviceID, algorithm)
+	return map[string]json.RawMessage{
+		algorithm + ":" + keyID: json.RawMessage(keyJSON),
+	}, err
+}
+
+func (s *fallbackKeysStatements) DeleteFallbackKeys(ctx context.Context, txn *sql.Tx, userID, deviceID string) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteFallbackKeysStmt).ExecContext(ctx, userID, deviceID)
+	return err
+}
@@ -69,6 +69,22 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresDevicesTable: %w", err)
 	}
+	dehydratedDevicesTable, err := NewPostgresDehydratedDevicesTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresDehydratedDevicesTable: %w", err)
+	}
+	delayedEventsTable, err := NewPostgresDelayedEventsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresDelayedEventsTable: %w", err)
+	}
+	accountValidityTable, err := NewPostgresAccountValidityTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresAccountValidityTable: %w", err)
+	}
+	userConsentTable, err := NewPostgresUserConsentTable(db, serverNoticesLocalpart)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresUserConsentTable: %w", err)
+	}
 	keyBackupTable, err := NewPostgresKeyBackupTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresKeyBackupTable: %w", err)
@@ -89,6 +105,10 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresProfilesTable: %w", err)
 	}
+	userDirectoryTable, err := NewPostgresUserDirectoryTable(db, serverNoticesLocalpart)
+	if err != nil {
+		return nil, fmt.Errorf("NewPostgresUserDirectoryTable: %w", err)
+	}
 	threePIDTable, err := NewPostgresThreePIDTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewPostgresThreePIDTable: %w", err)
@@ -121,6 +141,11 @@ func NewDatabase(ctx context.Context, conMan *sqlutil.Connections, dbProperties
 		AccountDatas:          accountDataTable,
 		Accounts:              accountsTable,
 		Devices:               devicesTable,
+		DehydratedDevices:     dehydratedDevicesTable,
+		DelayedEvents:         delayedEventsTable,
+		AccountValidity:       accountValidityTable,
+		UserConsent:           userConsentTable,
+		UserDirectory:         userDirectoryTable,
 		KeyBackups:            keyBackupTable,
 		KeyBackupVersions:     keyBackupVersionTable,
 		LoginTokens:           loginTokenTable,
@@ -149,6 +174,10 @@ func NewKeyDatabase(conMan *sqlutil.Connections, dbProperties *config.DatabaseOp
 	if err != nil {
 		return nil, err
 	}
+	fk, err := NewPostgresFallbackKeysTable(db)
+	if err != nil {
+		return nil, err
+	}
 	dk, err := NewPostgresDeviceKeysTable(db)
 	if err != nil {
 		return nil, err
@@ -172,6 +201,7 @@ func NewKeyDatabase(conMan *sqlutil.Connections, dbProperties *config.DatabaseOp
 
 	return &shared.KeyDatabase{
 		OneTimeKeysTable:      otk,
+		FallbackKeysTable:     fk,
 		DeviceKeysTable:       dk,
 		KeyChangesTable:       kc,
 		StaleDeviceListsTable: sdl,
@@ -0,0 +1,280 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/jchv/maidtrix/clientapi/auth/authtypes"
+	"github.com/jchv/maidtrix/clientapi/userutil"
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/userapi/storage/postgres/deltas"
+	"github.com/jchv/maidtrix/userapi/storage/tables"
+)
+
+const userDirectorySchema = `
+-- Stores the users who can be found in the user directory: local users,
+-- and remote users who are in a public room.
+CREATE TABLE IF NOT EXISTS userapi_user_directory (
+	user_id TEXT NOT NULL PRIMARY KEY,
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	is_local BOOLEAN NOT NULL,
+	-- The profile of local users comes from their global profile, the profile
+	-- of remote users from their latest membership event in a public room.
+	display_name TEXT NOT NULL DEFAULT '',
+	avatar_url TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS userapi_user_directory_localpart_idx ON userapi_user_directory(localpart);
+CREATE INDEX IF NOT EXISTS userapi_user_directory_display_name_idx ON userapi_user_directory(display_name);
+
+-- Stores which users are joined to which public rooms.
+CREATE TABLE IF NOT EXISTS userapi_user_directory_public_rooms (
+	user_id TEXT NOT NULL,
+	room_id TEXT NOT NULL,
+	PRIMARY KEY (user_id, room_id)
+);
+
+CREATE INDEX IF NOT EXISTS userapi_user_directory_public_rooms_room_id_idx ON userapi_user_directory_public_rooms(room_id);
+`
+
+const upsertUserDirectoryUserSQL = "" +
+	"INSERT INTO userapi_user_directory (user_id, localpart, server_name, is_local, display_name, avatar_url)" +
+	" VALUES ($1, $2, $3, $4, $5, $6)" +
+	" ON CONFLICT (user_id)" +
+	" DO UPDATE SET display_name = $5, avatar_url = $6"
+
+const updateUserDirectoryUserSQL = "" +
+	"UPDATE userapi_user_directory SET display_name = $1, avatar_url = $2 WHERE user_id = $3"
+
+const deleteUserDirectoryUserSQL = "" +
+	"DELETE FROM userapi_user_directory WHERE user_id = $1"
+
+const insertPublicRoomUserSQL = "" +
+	"INSERT INTO userapi_user_directory_public_rooms (user_id, room_id) VALUES ($1, $2)" +
+	" ON CONFLICT (user_id, room_id) DO NOTHING"
+
+const deletePublicRoomUserSQL = "" +
+	"DELETE FROM userapi_user_directory_public_rooms WHERE user_id = $1 AND room_id = $2"
+
+const deletePublicRoomUsersByUserSQL = "" +
+	"DELETE FROM userapi_user_directory_public_rooms WHERE user_id = $1"
+
+const deletePublicRoomSQL = "" +
+	"DELETE FROM userapi_user_directory_public_rooms WHERE room_id = $1"
+
+// Removes a remote user who left a public room, unless they are still in
+// another public room.
+const deleteRemoteUserNotInPublicRoomsSQL = "" +
+	"DELETE FROM userapi_user_directory WHERE user_id = $1 AND is_local = FALSE AND NOT EXISTS (" +
+	" SELECT 1 FROM userapi_user_directory_public_rooms p WHERE p.user_id = $1" +
+	")"
+
+// Removes the remote users of a room which is no longer public, unless they
+// are in another public room. This must run before the room is deleted from
+// userapi_user_directory_public_rooms.
+const deleteRemoteUsersOnlyInPublicRoomSQL = "" +
+	"DELETE FROM userapi_user_directory WHERE is_local = FALSE AND user_id IN (" +
+	" SELECT user_id FROM userapi_user_directory_public_rooms WHERE room_id = $1" +
+	") AND NOT EXISTS (" +
+	" SELECT 1 FROM userapi_user_directory_public_rooms p" +
+	" WHERE p.user_id = userapi_user_directory.user_id AND p.room_id != $1" +
+	")"
+
+const selectLocalUserIDsSQL = "" +
+	"SELECT user_id FROM userapi_user_directory WHERE is_local = TRUE"
+
+// Local users are listed if every local user can be found or they are in a
+// public room, remote users if remote users can be found and they are in a
+// public room. The best matches come first: the exact localpart, then a
+// localpart starting with the search term, then a display name with a word
+// starting with it, then anything else containing it.
+const selectUsersBySearchSQL = "" +
+	"SELECT d.user_id, d.display_name, d.avatar_url FROM userapi_user_directory d" +
+	" WHERE (LOWER(d.localpart) LIKE $1 ESCAPE '\\' OR LOWER(d.display_name) LIKE $1 ESCAPE '\\')" +
+	" AND (" +
+	"  (d.is_local = TRUE AND ($2 OR EXISTS (SELECT 1 FROM userapi_user_directory_public_rooms p WHERE p.user_id = d.user_id)))" +
+	"  OR (d.is_local = FALSE AND $3 AND EXISTS (SELECT 1 FROM userapi_user_directory_public_rooms p WHERE p.user_id = d.user_id))" +
+	" )" +
+	" ORDER BY CASE" +
+	"  WHEN LOWER(d.localpart) = $4 THEN 0" +
+	"  WHEN LOWER(d.localpart) LIKE $5 ESCAPE '\\' THEN 1" +
+	"  WHEN LOWER(d.display_name) LIKE $5 ESCAPE '\\' OR LOWER(d.display_name) LIKE $6 ESCAPE '\\' THEN 2" +
+	"  ELSE 3" +
+	" END, d.user_id" +
+	" LIMIT $7"
+
+type userDirectoryStatements struct {
+	serverNoticesLocalpart                string
+	upsertUserStmt                        *sql.Stmt
+	updateUserStmt                        *sql.Stmt
+	deleteUserStmt                        *sql.Stmt
+	insertPublicRoomUserStmt              *sql.Stmt
+	deletePublicRoomUserStmt              *sql.Stmt
+	deletePublicRoomUsersByUserStmt       *sql.Stmt
+	deletePublicRoomStmt                  *sql.Stmt
+	deleteRemoteUserNotInPublicRoomsStmt  *sql.Stmt
+	deleteRemoteUsersOnlyInPublicRoomStmt *sql.Stmt
+	selectLocalUserIDsStmt                *sql.Stmt
+	selectUsersBySearchStmt               *sql.Stmt
+}
+
+func NewPostgresUserDirectoryTable(db *sql.DB, serverNoticesLocalpart string) (tables.UserDirectoryTable, error) {
+	s := &userDirectoryStatements{
+		serverNoticesLocalpart: serverNoticesLocalpart,
+	}
+	_, err := db.Exec(userDirectorySchema)
+	if err != nil {
+		return nil, err
+	}
+	m := sqlutil.NewMigrator(db)
+	m.AddMigrations(sqlutil.Migration{
+		Version: "userapi: populate user directory",
+		Up: func(ctx context.Context, txn *sql.Tx) error {
+			return deltas.UpPopulateUserDirectory(ctx, txn, serverNoticesLocalpart)
+		},
+	})
+	if err = m.Up(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertUserStmt, upsertUserDirectoryUserSQL},
+		{&s.updateUserStmt, updateUserDirectoryUserSQL},
+		{&s.deleteUserStmt, deleteUserDirectoryUserSQL},
+		{&s.insertPublicRoomUserStmt, insertPublicRoomUserSQL},
+		{&s.deletePublicRoomUserStmt, deletePublicRoomUserSQL},
+		{&s.deletePublicRoomUsersByUserStmt, deletePublicRoomUsersByUserSQL},
+		{&s.deletePublicRoomStmt, deletePublicRoomSQL},
+		{&s.deleteRemoteUserNotInPublicRoomsStmt, deleteRemoteUserNotInPublicRoomsSQL},
+		{&s.deleteRemoteUsersOnlyInPublicRoomStmt, deleteRemoteUsersOnlyInPublicRoomSQL},
+		{&s.selectLocalUserIDsStmt, selectLocalUserIDsSQL},
+		{&s.selectUsersBySearchStmt, selectUsersBySearchSQL},
+	}.Prepare(db)
+}
+
+func (s *userDirectoryStatements) UpsertUser(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, isLocal bool,
+	displayName, avatarURL string,
+) error {
+	// The server notices user can't be messaged, so there's no point finding it.
+	if isLocal && localpart == s.serverNoticesLocalpart {
+		return nil
+	}
+	userID := userutil.MakeUserID(localpart, serverName)
+	_, err := sqlutil.TxStmt(txn, s.upsertUserStmt).ExecContext(ctx, userID, localpart, serverName, isLocal, displayName, avatarURL)
+	return err
+}
+
+func (s *userDirectoryStatements) UpdateUser(
+	ctx context.Context, txn *sql.Tx, userID, displayName, avatarURL string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.updateUserStmt).ExecContext(ctx, displayName, avatarURL, userID)
+	return err
+}
+
+func (s *userDirectoryStatements) DeleteUser(
+	ctx context.Context, txn *sql.Tx, userID string,
+) error {
+	if _, err := sqlutil.TxStmt(txn, s.deletePublicRoomUsersByUserStmt).ExecContext(ctx, userID); err != nil {
+		return err
+	}
+	_, err := sqlutil.TxStmt(txn, s.deleteUserStmt).ExecContext(ctx, userID)
+	return err
+}
+
+func (s *userDirectoryStatements) InsertPublicRoomUser(
+	ctx context.Context, txn *sql.Tx, roomID, userID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.insertPublicRoomUserStmt).ExecContext(ctx, userID, roomID)
+	return err
+}
+
+func (s *userDirectoryStatements) DeletePublicRoomUser(
+	ctx context.Context, txn *sql.Tx, roomID, userID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deletePublicRoomUserStmt).ExecContext(ctx, userID, roomID)
+	return err
+}
+
+func (s *userDirectoryStatements) DeletePublicRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deletePublicRoomStmt).ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *userDirectoryStatements) DeleteRemoteUserNotInPublicRooms(
+	ctx context.Context, txn *sql.Tx, userID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteRemoteUserNotInPublicRoomsStmt).ExecContext(ctx, userID)
+	return err
+}
+
+func (s *userDirectoryStatements) DeleteRemoteUsersOnlyInPublicRoom(
+	ctx context.Context, txn *sql.Tx, roomID string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.deleteRemoteUsersOnlyInPublicRoomStmt).ExecContext(ctx, roomID)
+	return err
+}
+
+func (s *userDirectoryStatements) SelectLocalUserIDs(
+	ctx context.Context, txn *sql.Tx,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectLocalUserIDsStmt).QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectLocalUserIDsStmt: rows.close() failed")
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+func (s *userDirectoryStatements) SelectUsersBySearch(
+	ctx context.Context, txn *sql.Tx, searchString string, searchAllUsers, includeRemoteUsers bool, limit int,
+) ([]authtypes.FullyQualifiedProfile, error) {
+	term := likeEscaper.Replace(strings.ToLower(searchString))
+	rows, err := sqlutil.TxStmt(txn, s.selectUsersBySearchStmt).QueryContext(
+		ctx, "%"+term+"%", searchAllUsers, includeRemoteUsers,
+		strings.ToLower(searchString), term+"%", "% "+term+"%", limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectUsersBySearchStmt: rows.close() failed")
+	var profiles []authtypes.FullyQualifiedProfile
+	for rows.Next() {
+		var profile authtypes.FullyQualifiedProfile
+		if err = rows.Scan(&profile.UserID, &profile.DisplayName, &profile.AvatarURL); err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+// likeEscaper escapes the wildcards in a search term, so that they match literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
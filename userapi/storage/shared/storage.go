@@ -33,6 +33,7 @@ import (
 
 	clientapi "github.com/jchv/maidtrix/clientapi/api"
 	"github.com/jchv/maidtrix/clientapi/auth/authtypes"
+	"github.com/jchv/maidtrix/clientapi/userutil"
 	"github.com/jchv/maidtrix/internal/pushrules"
 	"github.com/jchv/maidtrix/internal/sqlutil"
 	"github.com/jchv/maidtrix/userapi/api"
@@ -53,6 +54,11 @@ type Database struct {
 	KeyBackups            tables.KeyBackupTable
 	KeyBackupVersions     tables.KeyBackupVersionTable
 	Devices               tables.DevicesTable
+	DehydratedDevices     tables.DehydratedDevicesTable
+	DelayedEvents         tables.DelayedEventsTable
+	AccountValidity       tables.AccountValidityTable
+	UserConsent           tables.UserConsentTable
+	UserDirectory         tables.UserDirectoryTable
 	LoginTokens           tables.LoginTokenTable
 	Notifications         tables.NotificationTable
 	Pushers               tables.PusherTable
@@ -65,6 +71,7 @@ type Database struct {
 
 type KeyDatabase struct {
 	OneTimeKeysTable      tables.OneTimeKeys
+	FallbackKeysTable     tables.FallbackKeys
 	DeviceKeysTable       tables.DeviceKeys
 	KeyChangesTable       tables.KeyChanges
 	StaleDeviceListsTable tables.StaleDeviceLists
@@ -135,6 +142,14 @@ func (d *Database) GetAccountByPassword(
 	return d.Accounts.SelectAccountByLocalpart(ctx, localpart, serverName)
 }
 
+// GetServerNoticeRecipients returns the local users who can be sent server
+// notices, which excludes guests, appservice users and deactivated accounts.
+func (d *Database) GetServerNoticeRecipients(
+	ctx context.Context, adminsOnly bool, activeSince spec.Timestamp,
+) ([]string, error) {
+	return d.Stats.SelectNoticeRecipients(ctx, nil, adminsOnly, activeSince)
+}
+
 // GetProfileByLocalpart returns the profile associated with the given localpart.
 // Returns sql.ErrNoRows if no profile exists which matches the given localpart.
 func (d *Database) GetProfileByLocalpart(
@@ -153,7 +168,10 @@ func (d *Database) SetAvatarURL(
 ) (profile *authtypes.Profile, changed bool, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		profile, changed, err = d.Profiles.SetAvatarURL(ctx, txn, localpart, serverName, avatarURL)
-		return err
+		if err != nil || !changed {
+			return err
+		}
+		return d.UserDirectory.UpdateUser(ctx, txn, userutil.MakeUserID(localpart, serverName), profile.DisplayName, profile.AvatarURL)
 	})
 	return
 }
@@ -167,7 +185,10 @@ func (d *Database) SetDisplayName(
 ) (profile *authtypes.Profile, changed bool, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		profile, changed, err = d.Profiles.SetDisplayName(ctx, txn, localpart, serverName, displayName)
-		return err
+		if err != nil || !changed {
+			return err
+		}
+		return d.UserDirectory.UpdateUser(ctx, txn, userutil.MakeUserID(localpart, serverName), profile.DisplayName, profile.AvatarURL)
 	})
 	return
 }
@@ -234,6 +255,12 @@ func (d *Database) createAccount(
 	if err = d.Profiles.InsertProfile(ctx, txn, localpart, serverName); err != nil {
 		return nil, fmt.Errorf("d.Profiles.InsertProfile: %w", err)
 	}
+	// Guests can't be found in the user directory.
+	if accountType != api.AccountTypeGuest {
+		if err = d.UserDirectory.UpsertUser(ctx, txn, localpart, serverName, true, "", ""); err != nil {
+			return nil, fmt.Errorf("d.UserDirectory.UpsertUser: %w", err)
+		}
+	}
 	pushRuleSets := pushrules.DefaultAccountRuleSets(localpart, serverName)
 	prbs, err := json.Marshal(pushRuleSets)
 	if err != nil {
@@ -427,7 +454,10 @@ func (d *Database) SearchProfiles(ctx context.Context, searchString string, limi
 // DeactivateAccount deactivates the user's account, removing all ability for the user to login again.
 func (d *Database) DeactivateAccount(ctx context.Context, localpart string, serverName spec.ServerName) (err error) {
 	return d.Writer.Do(nil, nil, func(txn *sql.Tx) error {
-		return d.Accounts.DeactivateAccount(ctx, localpart, serverName)
+		if err = d.Accounts.DeactivateAccount(ctx, localpart, serverName); err != nil {
+			return err
+		}
+		return d.UserDirectory.DeleteUser(ctx, txn, userutil.MakeUserID(localpart, serverName))
 	})
 }
 
@@ -732,15 +762,17 @@ func (d *Database) RemoveDevices(
 	devices []string,
 ) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		if err := d.Devices.DeleteDevices(ctx, txn, localpart, serverName, devices); err != sql.ErrNoRows {
+		if err := d.Devices.DeleteDevices(ctx, txn, localpart, serverName, devices); err != nil && err != sql.ErrNoRows {
 			return err
 		}
-		return nil
+		return d.removeDehydratedDevice(ctx, txn, localpart, serverName, devices)
 	})
 }
 
 // RemoveAllDevices revokes devices by deleting the entry in the
-// database matching the given user ID localpart.
+// database matching the given user ID localpart. The user's dehydrated
+// device, if any, is kept, as it must survive the user logging out
+// everywhere, and isn't included in the returned devices.
 // If something went wrong during the deletion, it will return the SQL error.
 func (d *Database) RemoveAllDevices(
 	ctx context.Context,
@@ -748,13 +780,319 @@ func (d *Database) RemoveAllDevices(
 	exceptDeviceID string,
 ) (devices []api.Device, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		devices, err = d.Devices.SelectDevicesByLocalpart(ctx, txn, localpart, serverName, exceptDeviceID)
+		dehydratedDeviceID, _, err := d.DehydratedDevices.SelectDehydratedDevice(ctx, txn, localpart, serverName)
+		if err == sql.ErrNoRows {
+			devices, err = d.Devices.SelectDevicesByLocalpart(ctx, txn, localpart, serverName, exceptDeviceID)
+			if err != nil {
+				return err
+			}
+			if err = d.Devices.DeleteDevicesByLocalpart(ctx, txn, localpart, serverName, exceptDeviceID); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		allDevices, err := d.Devices.SelectDevicesByLocalpart(ctx, txn, localpart, serverName, exceptDeviceID)
 		if err != nil {
 			return err
 		}
-		if err := d.Devices.DeleteDevicesByLocalpart(ctx, txn, localpart, serverName, exceptDeviceID); err != sql.ErrNoRows {
+		devices = make([]api.Device, 0, len(allDevices))
+		deviceIDs := make([]string, 0, len(allDevices))
+		for _, device := range allDevices {
+			if device.ID == dehydratedDeviceID {
+				continue
+			}
+			devices = append(devices, device)
+			deviceIDs = append(deviceIDs, device.ID)
+		}
+		if len(deviceIDs) == 0 {
+			return nil
+		}
+		if err = d.Devices.DeleteDevices(ctx, txn, localpart, serverName, deviceIDs); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// removeDehydratedDevice forgets about the user's dehydrated device if it
+// is one of the given devices, which are being deleted.
+func (d *Database) removeDehydratedDevice(
+	ctx context.Context, txn *sql.Tx,
+	localpart string, serverName spec.ServerName,
+	deviceIDs []string,
+) error {
+	dehydratedDeviceID, _, err := d.DehydratedDevices.SelectDehydratedDevice(ctx, txn, localpart, serverName)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, deviceID := range deviceIDs {
+		if deviceID == dehydratedDeviceID {
+			return d.DehydratedDevices.DeleteDehydratedDevice(ctx, txn, localpart, serverName)
+		}
+	}
+	return nil
+}
+
+// StoreDehydratedDevice stores the pickled data for the user's dehydrated
+// device, replacing any existing dehydrated device. The device itself must
+// already exist. Returns the ID of the dehydrated device that was replaced,
+// if any, so that it can be deleted.
+func (d *Database) StoreDehydratedDevice(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+	deviceID string, deviceData json.RawMessage,
+) (previousDeviceID string, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		previousDeviceID, _, err = d.DehydratedDevices.SelectDehydratedDevice(ctx, txn, localpart, serverName)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		return d.DehydratedDevices.UpsertDehydratedDevice(ctx, txn, localpart, serverName, deviceID, deviceData)
+	})
+	if previousDeviceID == deviceID {
+		previousDeviceID = ""
+	}
+	return
+}
+
+// GetDehydratedDevice returns the user's dehydrated device, or
+// sql.ErrNoRows if the user doesn't have one.
+func (d *Database) GetDehydratedDevice(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+) (deviceID string, deviceData json.RawMessage, err error) {
+	return d.DehydratedDevices.SelectDehydratedDevice(ctx, nil, localpart, serverName)
+}
+
+// SetAccountValidity sets when the account expires. Any renewal email sent
+// before is forgotten about, so that a new one is sent before the new
+// expiry time unless emailSent is true.
+func (d *Database) SetAccountValidity(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+	expirationTS spec.Timestamp, emailSent bool,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.AccountValidity.UpsertAccountValidity(ctx, txn, localpart, serverName, expirationTS, emailSent)
+	})
+}
+
+// GetAccountValidity returns when the account expires, or sql.ErrNoRows
+// if it doesn't.
+func (d *Database) GetAccountValidity(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+) (*api.AccountValidity, error) {
+	return d.AccountValidity.SelectAccountValidity(ctx, nil, localpart, serverName)
+}
+
+// GetAccountValidityByRenewalToken returns the account which was sent the
+// renewal token, or sql.ErrNoRows if the token is unknown.
+func (d *Database) GetAccountValidityByRenewalToken(ctx context.Context, renewalToken string) (*api.AccountValidity, error) {
+	return d.AccountValidity.SelectAccountValidityByRenewalToken(ctx, nil, renewalToken)
+}
+
+// SetRenewalToken stores the token of the renewal email for the user, and
+// whether the email has been sent.
+func (d *Database) SetRenewalToken(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+	renewalToken string, emailSent bool,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.AccountValidity.UpdateRenewalToken(ctx, txn, localpart, serverName, renewalToken, emailSent)
+	})
+}
+
+// GetAccountsToRemind returns the accounts expiring before the given time
+// which haven't been sent a renewal email yet.
+func (d *Database) GetAccountsToRemind(ctx context.Context, expiringBefore spec.Timestamp) ([]api.AccountValidity, error) {
+	return d.AccountValidity.SelectAccountsToRemind(ctx, nil, expiringBefore)
+}
+
+// AddPublicRoomUser records that the user is joined to the public room, so
+// that they can be found in the user directory.
+func (d *Database) AddPublicRoomUser(
+	ctx context.Context, roomID, userID string, isLocal bool, displayName, avatarURL string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if !isLocal {
+			localpart, domain, err := gomatrixserverlib.SplitID('@', userID)
+			if err != nil {
+				return err
+			}
+			if err = d.UserDirectory.UpsertUser(ctx, txn, localpart, domain, false, displayName, avatarURL); err != nil {
+				return err
+			}
+		}
+		return d.UserDirectory.InsertPublicRoomUser(ctx, txn, roomID, userID)
+	})
+}
+
+// RemovePublicRoomUser records that the user left the public room. Remote
+// users who aren't in any public room any more are removed from the user
+// directory.
+func (d *Database) RemovePublicRoomUser(ctx context.Context, roomID, userID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if err := d.UserDirectory.DeletePublicRoomUser(ctx, txn, roomID, userID); err != nil {
+			return err
+		}
+		return d.UserDirectory.DeleteRemoteUserNotInPublicRooms(ctx, txn, userID)
+	})
+}
+
+// RemovePublicRoom records that the room is no longer public. Its remote
+// users who aren't in another public room are removed from the user directory.
+func (d *Database) RemovePublicRoom(ctx context.Context, roomID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		if err := d.UserDirectory.DeleteRemoteUsersOnlyInPublicRoom(ctx, txn, roomID); err != nil {
+			return err
+		}
+		return d.UserDirectory.DeletePublicRoom(ctx, txn, roomID)
+	})
+}
+
+// userDirectoryBackfill is recorded as a migration once the members of the
+// public rooms that existed before the user directory have been added to it.
+const userDirectoryBackfill = "userapi: backfill user directory public rooms"
+
+// UserDirectoryBackfilled returns whether MarkUserDirectoryBackfilled was called.
+func (d *Database) UserDirectoryBackfilled(ctx context.Context) (bool, error) {
+	executed, err := sqlutil.NewMigrator(d.DB).ExecutedMigrations(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, ok := executed[userDirectoryBackfill]
+	return ok, nil
+}
+
+// LocalUserDirectoryUsers returns the IDs of the local users in the user directory.
+func (d *Database) LocalUserDirectoryUsers(ctx context.Context) ([]string, error) {
+	return d.UserDirectory.SelectLocalUserIDs(ctx, nil)
+}
+
+// MarkUserDirectoryBackfilled records that the members of the public rooms
+// have been added to the user directory.
+func (d *Database) MarkUserDirectoryBackfilled(ctx context.Context) error {
+	return d.Writer.Do(d.DB, nil, func(_ *sql.Tx) error {
+		return sqlutil.InsertMigration(ctx, d.DB, userDirectoryBackfill)
+	})
+}
+
+// SearchUserDirectory returns the users whose localpart or display name
+// contain the search string, best matches first.
+func (d *Database) SearchUserDirectory(
+	ctx context.Context, searchString string, searchAllUsers, includeRemoteUsers bool, limit int,
+) ([]authtypes.FullyQualifiedProfile, error) {
+	return d.UserDirectory.SelectUsersBySearch(ctx, nil, searchString, searchAllUsers, includeRemoteUsers, limit)
+}
+
+// SetConsentVersion records that the user accepted the given version of the
+// policy documents.
+func (d *Database) SetConsentVersion(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+	version string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.UserConsent.UpsertConsentVersion(ctx, txn, localpart, serverName, version, spec.AsTimestamp(time.Now()))
+	})
+}
+
+// SetConsentNoticeVersion records that the user was sent a server notice
+// asking them to accept the given version of the policy documents.
+func (d *Database) SetConsentNoticeVersion(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+	version string,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.UserConsent.UpsertNoticeVersion(ctx, txn, localpart, serverName, version)
+	})
+}
+
+// GetConsentVersion returns the version of the policy documents the user
+// accepted, or an empty string if they never did.
+func (d *Database) GetConsentVersion(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+) (string, error) {
+	return d.UserConsent.SelectConsentVersion(ctx, nil, localpart, serverName)
+}
+
+// GetUsersToNotifyOfConsent returns the local users who haven't accepted
+// the given version of the policy documents, and haven't been sent a server
+// notice about it either.
+func (d *Database) GetUsersToNotifyOfConsent(ctx context.Context, version string) ([]string, error) {
+	return d.UserConsent.SelectUsersToNotify(ctx, nil, version)
+}
+
+// StoreDelayedEvent stores a new delayed event for the user.
+func (d *Database) StoreDelayedEvent(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+	event *api.DelayedEvent,
+) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.DelayedEvents.InsertDelayedEvent(ctx, txn, localpart, serverName, event)
+	})
+}
+
+// GetDelayedEvent returns the delayed event with the given ID, or
+// sql.ErrNoRows if there isn't one.
+func (d *Database) GetDelayedEvent(ctx context.Context, delayID string) (*api.DelayedEvent, error) {
+	return d.DelayedEvents.SelectDelayedEvent(ctx, nil, delayID)
+}
+
+// GetDelayedEvents returns the user's delayed events, soonest first.
+func (d *Database) GetDelayedEvents(
+	ctx context.Context,
+	localpart string, serverName spec.ServerName,
+) ([]api.DelayedEvent, error) {
+	return d.DelayedEvents.SelectDelayedEventsByLocalpart(ctx, nil, localpart, serverName)
+}
+
+// GetAllDelayedEvents returns every pending delayed event, soonest first.
+func (d *Database) GetAllDelayedEvents(ctx context.Context) ([]api.DelayedEvent, error) {
+	return d.DelayedEvents.SelectAllDelayedEvents(ctx, nil)
+}
+
+// RestartDelayedEvent restarts the delay of the delayed event from the given time.
+func (d *Database) RestartDelayedEvent(ctx context.Context, delayID string, runningSince spec.Timestamp) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.DelayedEvents.UpdateDelayedEventRunningSince(ctx, txn, delayID, runningSince)
+	})
+}
+
+// RemoveDelayedEvent removes the delayed event with the given ID.
+func (d *Database) RemoveDelayedEvent(ctx context.Context, delayID string) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.DelayedEvents.DeleteDelayedEvent(ctx, txn, delayID)
+	})
+}
+
+// RemoveDelayedStateEvents removes all delayed state events which would
+// overwrite the given state, returning the IDs of the removed delayed events.
+func (d *Database) RemoveDelayedStateEvents(
+	ctx context.Context,
+	roomID, eventType, stateKey string,
+) (delayIDs []string, err error) {
+	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		delayIDs, err = d.DelayedEvents.SelectDelayedStateEventIDs(ctx, txn, roomID, eventType, stateKey)
+		if err != nil {
 			return err
 		}
+		for _, delayID := range delayIDs {
+			if err = d.DelayedEvents.DeleteDelayedEvent(ctx, txn, delayID); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	return
@@ -811,9 +1149,9 @@ func (d *Database) GetLoginTokenDataByToken(ctx context.Context, token string) (
 	return d.LoginTokens.SelectLoginToken(ctx, token)
 }
 
-func (d *Database) InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error {
+func (d *Database) InsertNotification(ctx context.Context, localpart string, serverName spec.ServerName, eventID, threadID string, pos uint64, tweaks map[string]interface{}, n *api.Notification) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		return d.Notifications.Insert(ctx, txn, localpart, serverName, eventID, pos, pushrules.BoolTweakOr(tweaks, pushrules.HighlightTweak, false), n)
+		return d.Notifications.Insert(ctx, txn, localpart, serverName, eventID, threadID, pos, pushrules.BoolTweakOr(tweaks, pushrules.HighlightTweak, false), n)
 	})
 }
 
@@ -825,9 +1163,9 @@ func (d *Database) DeleteNotificationsUpTo(ctx context.Context, localpart string
 	return
 }
 
-func (d *Database) SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID string, pos uint64, b bool) (affected bool, err error) {
+func (d *Database) SetNotificationsRead(ctx context.Context, localpart string, serverName spec.ServerName, roomID, threadID string, pos uint64, b bool) (affected bool, err error) {
 	err = d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
-		affected, err = d.Notifications.UpdateRead(ctx, txn, localpart, serverName, roomID, pos, b)
+		affected, err = d.Notifications.UpdateRead(ctx, txn, localpart, serverName, roomID, threadID, pos, b)
 		return err
 	})
 	return
@@ -845,6 +1183,10 @@ func (d *Database) GetRoomNotificationCounts(ctx context.Context, localpart stri
 	return d.Notifications.SelectRoomCounts(ctx, nil, localpart, serverName, roomID)
 }
 
+func (d *Database) GetRoomThreadNotificationCounts(ctx context.Context, localpart string, serverName spec.ServerName, roomID string) (total map[string]int64, highlight map[string]int64, _ error) {
+	return d.Notifications.SelectRoomThreadCounts(ctx, nil, localpart, serverName, roomID)
+}
+
 func (d *Database) DeleteOldNotifications(ctx context.Context) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		return d.Notifications.Clean(ctx, txn)
@@ -915,6 +1257,10 @@ func (d *Database) UserStatistics(ctx context.Context) (*types.UserStatistics, *
 	return d.Stats.UserStatistics(ctx, nil)
 }
 
+func (d *Database) MonthlyActiveUsers(ctx context.Context) (int64, error) {
+	return d.Stats.MonthlyActiveUsers(ctx, nil)
+}
+
 func (d *Database) UpsertDailyRoomsMessages(ctx context.Context, serverName spec.ServerName, stats types.MessageStats, activeRooms, activeE2EERooms int64) error {
 	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
 		return d.Stats.UpsertDailyStats(ctx, txn, serverName, stats, activeRooms, activeE2EERooms)
@@ -945,6 +1291,16 @@ func (d *KeyDatabase) OneTimeKeysCount(ctx context.Context, userID, deviceID str
 	return d.OneTimeKeysTable.CountOneTimeKeys(ctx, userID, deviceID)
 }
 
+func (d *KeyDatabase) StoreFallbackKeys(ctx context.Context, keys api.OneTimeKeys) error {
+	return d.Writer.Do(d.DB, nil, func(txn *sql.Tx) error {
+		return d.FallbackKeysTable.InsertFallbackKeys(ctx, txn, keys)
+	})
+}
+
+func (d *KeyDatabase) UnusedFallbackKeyAlgorithms(ctx context.Context, userID, deviceID string) ([]string, error) {
+	return d.FallbackKeysTable.SelectUnusedFallbackKeyAlgorithms(ctx, userID, deviceID)
+}
+
 func (d *KeyDatabase) DeviceKeysJSON(ctx context.Context, keys []api.DeviceMessage) error {
 	return d.DeviceKeysTable.SelectDeviceKeysJSON(ctx, keys)
 }
@@ -1007,6 +1363,13 @@ func (d *KeyDatabase) ClaimKeys(ctx context.Context, userToDeviceToAlgorithm map
 				if err != nil {
 					return err
 				}
+				if keyJSON == nil {
+					// There are no one-time keys left, so hand out the fallback key instead.
+					keyJSON, err = d.FallbackKeysTable.SelectAndMarkFallbackKey(ctx, txn, userID, deviceID, algo)
+					if err != nil {
+						return err
+					}
+				}
 				if keyJSON != nil {
 					result = append(result, api.OneTimeKeys{
 						UserID:   userID,
@@ -1060,6 +1423,9 @@ func (d *KeyDatabase) DeleteDeviceKeys(ctx context.Context, userID string, devic
 			if err := d.OneTimeKeysTable.DeleteOneTimeKeys(ctx, txn, userID, string(deviceID)); err != nil && err != sql.ErrNoRows {
 				return fmt.Errorf("d.OneTimeKeysTable.DeleteOneTimeKeys: %w", err)
 			}
+			if err := d.FallbackKeysTable.DeleteFallbackKeys(ctx, txn, userID, string(deviceID)); err != nil && err != sql.ErrNoRows {
+				return fmt.Errorf("d.FallbackKeysTable.DeleteFallbackKeys: %w", err)
+			}
 		}
 		return nil
 	})
@@ -0,0 +1,150 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jchv/maidtrix/clientapi/userutil"
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/userapi/api"
+	"github.com/jchv/maidtrix/userapi/storage/tables"
+)
+
+const accountValiditySchema = `
+-- Stores when accounts expire, for accounts which expire at all.
+CREATE TABLE IF NOT EXISTS userapi_account_validity (
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	-- When the account expires, in milliseconds.
+	expiration_ts BIGINT NOT NULL,
+	-- Whether the renewal email was already sent for the current period.
+	email_sent BOOLEAN NOT NULL DEFAULT FALSE,
+	-- The token in the link of the renewal email, if one was sent.
+	renewal_token TEXT,
+	PRIMARY KEY (localpart, server_name)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS userapi_account_validity_renewal_token_idx ON userapi_account_validity(renewal_token);
+`
+
+const upsertAccountValiditySQL = "" +
+	"INSERT INTO userapi_account_validity (localpart, server_name, expiration_ts, email_sent, renewal_token)" +
+	" VALUES ($1, $2, $3, $4, NULL)" +
+	" ON CONFLICT (localpart, server_name)" +
+	" DO UPDATE SET expiration_ts = $3, email_sent = $4, renewal_token = NULL"
+
+const selectAccountValiditySQL = "" +
+	"SELECT localpart, server_name, expiration_ts, email_sent FROM userapi_account_validity" +
+	" WHERE localpart = $1 AND server_name = $2"
+
+const selectAccountValidityByRenewalTokenSQL = "" +
+	"SELECT localpart, server_name, expiration_ts, email_sent FROM userapi_account_validity" +
+	" WHERE renewal_token = $1"
+
+const updateRenewalTokenSQL = "" +
+	"UPDATE userapi_account_validity SET renewal_token = $1, email_sent = $2" +
+	" WHERE localpart = $3 AND server_name = $4"
+
+const selectAccountsToRemindSQL = "" +
+	"SELECT localpart, server_name, expiration_ts, email_sent FROM userapi_account_validity" +
+	" WHERE email_sent = FALSE AND expiration_ts <= $1"
+
+type accountValidityStatements struct {
+	upsertAccountValidityStmt               *sql.Stmt
+	selectAccountValidityStmt               *sql.Stmt
+	selectAccountValidityByRenewalTokenStmt *sql.Stmt
+	updateRenewalTokenStmt                  *sql.Stmt
+	selectAccountsToRemindStmt              *sql.Stmt
+}
+
+func NewSQLiteAccountValidityTable(db *sql.DB) (tables.AccountValidityTable, error) {
+	s := &accountValidityStatements{}
+	_, err := db.Exec(accountValiditySchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertAccountValidityStmt, upsertAccountValiditySQL},
+		{&s.selectAccountValidityStmt, selectAccountValiditySQL},
+		{&s.selectAccountValidityByRenewalTokenStmt, selectAccountValidityByRenewalTokenSQL},
+		{&s.updateRenewalTokenStmt, updateRenewalTokenSQL},
+		{&s.selectAccountsToRemindStmt, selectAccountsToRemindSQL},
+	}.Prepare(db)
+}
+
+func (s *accountValidityStatements) UpsertAccountValidity(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+	expirationTS spec.Timestamp, emailSent bool,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertAccountValidityStmt).ExecContext(ctx, localpart, serverName, expirationTS, emailSent)
+	return err
+}
+
+func (s *accountValidityStatements) SelectAccountValidity(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+) (*api.AccountValidity, error) {
+	return scanAccountValidity(sqlutil.TxStmt(txn, s.selectAccountValidityStmt).QueryRowContext(ctx, localpart, serverName))
+}
+
+func (s *accountValidityStatements) SelectAccountValidityByRenewalToken(
+	ctx context.Context, txn *sql.Tx, renewalToken string,
+) (*api.AccountValidity, error) {
+	return scanAccountValidity(sqlutil.TxStmt(txn, s.selectAccountValidityByRenewalTokenStmt).QueryRowContext(ctx, renewalToken))
+}
+
+func (s *accountValidityStatements) UpdateRenewalToken(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, renewalToken string, emailSent bool,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.updateRenewalTokenStmt).ExecContext(ctx, renewalToken, emailSent, localpart, serverName)
+	return err
+}
+
+func (s *accountValidityStatements) SelectAccountsToRemind(
+	ctx context.Context, txn *sql.Tx, expiringBefore spec.Timestamp,
+) ([]api.AccountValidity, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectAccountsToRemindStmt).QueryContext(ctx, expiringBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectAccountsToRemindStmt: rows.close() failed")
+	var result []api.AccountValidity
+	for rows.Next() {
+		validity, err := scanAccountValidity(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *validity)
+	}
+	return result, rows.Err()
+}
+
+type accountValidityScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccountValidity(row accountValidityScanner) (*api.AccountValidity, error) {
+	var validity api.AccountValidity
+	var localpart string
+	var serverName spec.ServerName
+	if err := row.Scan(&localpart, &serverName, &validity.ExpirationTS, &validity.EmailSent); err != nil {
+		return nil, err
+	}
+	validity.UserID = userutil.MakeUserID(localpart, serverName)
+	return &validity, nil
+}
@@ -0,0 +1,22 @@
+package deltas
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// UpNotificationThreadID adds the thread ID to notifications. Notifications
+// stored before threads were tracked are counted against the main timeline.
+func UpNotificationThreadID(ctx context.Context, tx *sql.Tx) error {
+	// SQLite doesn't have "if exists", so check if the column exists first.
+	if _, err := tx.QueryContext(ctx, "SELECT thread_id FROM userapi_notifications LIMIT 1"); err == nil {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+ALTER TABLE userapi_notifications ADD COLUMN thread_id TEXT NOT NULL DEFAULT 'main';`)
+	if err != nil {
+		return fmt.Errorf("failed to execute upgrade: %w", err)
+	}
+	return nil
+}
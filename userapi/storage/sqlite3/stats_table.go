@@ -23,6 +23,7 @@ import (
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/sirupsen/logrus"
 
+	"github.com/jchv/maidtrix/clientapi/userutil"
 	"github.com/jchv/maidtrix/internal"
 	"github.com/jchv/maidtrix/internal/sqlutil"
 	"github.com/jchv/maidtrix/userapi/api"
@@ -173,6 +174,33 @@ SELECT user_type, COUNT(*) AS count FROM (
 ) AS t GROUP BY user_type
 `
 
+// countActiveUsersByClientTypeSQL counts the users seen since $1, broken
+// down by the client type guessed from the user agent of their devices.
+const countActiveUsersByClientTypeSQL = `
+SELECT client_type, COUNT(DISTINCT localpart) FROM (
+	SELECT
+		localpart,
+		CASE
+			WHEN
+			LOWER(user_agent) LIKE '%riot%' OR
+			LOWER(user_agent) LIKE '%element%'
+			THEN CASE
+				WHEN LOWER(user_agent) LIKE '%electron%' THEN 'electron'
+				WHEN LOWER(user_agent) LIKE '%android%' THEN 'android'
+				WHEN LOWER(user_agent) LIKE '%ios%' THEN 'ios'
+				ELSE 'unknown'
+			END
+			WHEN LOWER(user_agent) LIKE '%mozilla%' OR LOWER(user_agent) LIKE '%gecko%' THEN 'web'
+			ELSE 'unknown'
+		END AS client_type
+	FROM userapi_devices
+	WHERE last_seen_ts > $1
+) AS d GROUP BY client_type
+`
+
+const countRegistrationsAfterSQL = "" +
+	"SELECT COUNT(*) FROM userapi_accounts WHERE created_ts > $1"
+
 // account_type 1 = users; 3 = admins
 const updateUserDailyVisitsSQL = `
 INSERT INTO userapi_daily_visits(localpart, device_id, timestamp, user_agent)
@@ -194,19 +222,34 @@ ON CONFLICT (localpart, device_id, timestamp) DO NOTHING
 
 const queryDBEngineVersion = "select sqlite_version();"
 
+// selectNoticeRecipientsSQL selects the active user and admin accounts, along
+// with the devices table to find those which were seen recently.
+const selectNoticeRecipientsSQL = "" +
+	"SELECT a.localpart, a.server_name FROM userapi_accounts a" +
+	" WHERE a.account_type IN ($1, $2) AND a.is_deactivated = 0" +
+	" AND ($3 = 0 OR a.account_type = $2)" +
+	" AND ($4 = 0 OR EXISTS (" +
+	"  SELECT 1 FROM userapi_devices d" +
+	"  WHERE d.localpart = a.localpart AND d.server_name = a.server_name AND d.last_seen_ts >= $4" +
+	" ))" +
+	" ORDER BY a.server_name, a.localpart"
+
 type statsStatements struct {
-	serverName                    spec.ServerName
-	db                            *sql.DB
-	lastUpdate                    time.Time
-	countUsersLastSeenAfterStmt   *sql.Stmt
-	countR30UsersStmt             *sql.Stmt
-	countR30UsersV2Stmt           *sql.Stmt
-	updateUserDailyVisitsStmt     *sql.Stmt
-	countUserByAccountTypeStmt    *sql.Stmt
-	countRegisteredUserByTypeStmt *sql.Stmt
-	dbEngineVersionStmt           *sql.Stmt
-	upsertMessagesStmt            *sql.Stmt
-	selectDailyMessagesStmt       *sql.Stmt
+	serverName                       spec.ServerName
+	db                               *sql.DB
+	lastUpdate                       time.Time
+	countUsersLastSeenAfterStmt      *sql.Stmt
+	countR30UsersStmt                *sql.Stmt
+	countR30UsersV2Stmt              *sql.Stmt
+	updateUserDailyVisitsStmt        *sql.Stmt
+	countUserByAccountTypeStmt       *sql.Stmt
+	countRegisteredUserByTypeStmt    *sql.Stmt
+	dbEngineVersionStmt              *sql.Stmt
+	upsertMessagesStmt               *sql.Stmt
+	selectDailyMessagesStmt          *sql.Stmt
+	countActiveUsersByClientTypeStmt *sql.Stmt
+	countRegistrationsAfterStmt      *sql.Stmt
+	selectNoticeRecipientsStmt       *sql.Stmt
 }
 
 func NewSQLiteStatsTable(db *sql.DB, serverName spec.ServerName) (tables.StatsTable, error) {
@@ -235,6 +278,9 @@ func NewSQLiteStatsTable(db *sql.DB, serverName spec.ServerName) (tables.StatsTa
 		{&s.dbEngineVersionStmt, queryDBEngineVersion},
 		{&s.upsertMessagesStmt, upsertDailyMessagesSQL},
 		{&s.selectDailyMessagesStmt, selectDailyMessagesSQL},
+		{&s.countActiveUsersByClientTypeStmt, countActiveUsersByClientTypeSQL},
+		{&s.countRegistrationsAfterStmt, countRegistrationsAfterSQL},
+		{&s.selectNoticeRecipientsStmt, selectNoticeRecipientsSQL},
 	}.Prepare(db)
 }
 
@@ -329,6 +375,10 @@ func (s *statsStatements) dailyUsers(ctx context.Context, txn *sql.Tx) (result i
 	return
 }
 
+func (s *statsStatements) MonthlyActiveUsers(ctx context.Context, txn *sql.Tx) (int64, error) {
+	return s.monthlyUsers(ctx, txn)
+}
+
 func (s *statsStatements) monthlyUsers(ctx context.Context, txn *sql.Tx) (result int64, err error) {
 	stmt := sqlutil.TxStmt(txn, s.countUsersLastSeenAfterStmt)
 	lastSeenAfter := time.Now().AddDate(0, 0, -30)
@@ -338,6 +388,42 @@ func (s *statsStatements) monthlyUsers(ctx context.Context, txn *sql.Tx) (result
 	return
 }
 
+// activeUsersByClientType counts the users seen in the given period, grouped
+// by the type of client they used.
+func (s *statsStatements) activeUsersByClientType(ctx context.Context, txn *sql.Tx, period time.Duration) (map[string]int64, error) {
+	stmt := sqlutil.TxStmt(txn, s.countActiveUsersByClientTypeStmt)
+	rows, err := stmt.QueryContext(ctx, spec.AsTimestamp(time.Now().Add(-period)))
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "activeUsersByClientType: failed to close rows")
+
+	var clientType string
+	var count int64
+	var result = map[string]int64{
+		"ios":      0,
+		"android":  0,
+		"web":      0,
+		"electron": 0,
+		"unknown":  0,
+	}
+	for rows.Next() {
+		if err = rows.Scan(&clientType, &count); err != nil {
+			return nil, err
+		}
+		result[clientType] = count
+	}
+	return result, rows.Err()
+}
+
+func (s *statsStatements) registrations(ctx context.Context, txn *sql.Tx, period time.Duration) (result int64, err error) {
+	stmt := sqlutil.TxStmt(txn, s.countRegistrationsAfterStmt)
+	err = stmt.QueryRowContext(ctx,
+		spec.AsTimestamp(time.Now().Add(-period)),
+	).Scan(&result)
+	return
+}
+
 // R30Users counts the number of 30 day retained users, defined as:
 //   - Users who have created their accounts more than 30 days ago
 //   - Where last seen at most 30 days ago
@@ -464,6 +550,22 @@ func (s *statsStatements) UserStatistics(ctx context.Context, txn *sql.Tx) (*typ
 	if err != nil {
 		return stats, dbEngine, err
 	}
+	stats.DailyUsersByClientType, err = s.activeUsersByClientType(ctx, txn, time.Hour*24)
+	if err != nil {
+		return stats, dbEngine, err
+	}
+	stats.MonthlyUsersByClientType, err = s.activeUsersByClientType(ctx, txn, time.Hour*24*30)
+	if err != nil {
+		return stats, dbEngine, err
+	}
+	stats.DailyRegistrations, err = s.registrations(ctx, txn, time.Hour*24)
+	if err != nil {
+		return stats, dbEngine, err
+	}
+	stats.MonthlyRegistrations, err = s.registrations(ctx, txn, time.Hour*24*30)
+	if err != nil {
+		return stats, dbEngine, err
+	}
 
 	stmt := sqlutil.TxStmt(txn, s.dbEngineVersionStmt)
 	err = stmt.QueryRowContext(ctx).Scan(&dbEngine.Version)
@@ -522,3 +624,25 @@ func (s *statsStatements) DailyRoomsMessages(
 	}
 	return msgStats, activeRooms, activeE2EERooms, nil
 }
+
+func (s *statsStatements) SelectNoticeRecipients(
+	ctx context.Context, txn *sql.Tx, adminsOnly bool, activeSince spec.Timestamp,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectNoticeRecipientsStmt).QueryContext(
+		ctx, api.AccountTypeUser, api.AccountTypeAdmin, adminsOnly, int64(activeSince),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectNoticeRecipientsStmt: rows.close() failed")
+	var userIDs []string
+	for rows.Next() {
+		var localpart string
+		var serverName spec.ServerName
+		if err = rows.Scan(&localpart, &serverName); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userutil.MakeUserID(localpart, serverName))
+	}
+	return userIDs, rows.Err()
+}
@@ -66,6 +66,22 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteDevicesTable: %w", err)
 	}
+	dehydratedDevicesTable, err := NewSQLiteDehydratedDevicesTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteDehydratedDevicesTable: %w", err)
+	}
+	delayedEventsTable, err := NewSQLiteDelayedEventsTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteDelayedEventsTable: %w", err)
+	}
+	accountValidityTable, err := NewSQLiteAccountValidityTable(db)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteAccountValidityTable: %w", err)
+	}
+	userConsentTable, err := NewSQLiteUserConsentTable(db, serverNoticesLocalpart)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteUserConsentTable: %w", err)
+	}
 	keyBackupTable, err := NewSQLiteKeyBackupTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteKeyBackupTable: %w", err)
@@ -86,6 +102,10 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteProfilesTable: %w", err)
 	}
+	userDirectoryTable, err := NewSQLiteUserDirectoryTable(db, serverNoticesLocalpart)
+	if err != nil {
+		return nil, fmt.Errorf("NewSQLiteUserDirectoryTable: %w", err)
+	}
 	threePIDTable, err := NewSQLiteThreePIDTable(db)
 	if err != nil {
 		return nil, fmt.Errorf("NewSQLiteThreePIDTable: %w", err)
@@ -118,6 +138,11 @@ func NewUserDatabase(ctx context.Context, conMan *sqlutil.Connections, dbPropert
 		AccountDatas:          accountDataTable,
 		Accounts:              accountsTable,
 		Devices:               devicesTable,
+		DehydratedDevices:     dehydratedDevicesTable,
+		DelayedEvents:         delayedEventsTable,
+		AccountValidity:       accountValidityTable,
+		UserConsent:           userConsentTable,
+		UserDirectory:         userDirectoryTable,
 		KeyBackups:            keyBackupTable,
 		KeyBackupVersions:     keyBackupVersionTable,
 		LoginTokens:           loginTokenTable,
@@ -146,6 +171,10 @@ func NewKeyDatabase(conMan *sqlutil.Connections, dbProperties *config.DatabaseOp
 	if err != nil {
 		return nil, err
 	}
+	fk, err := NewSqliteFallbackKeysTable(db)
+	if err != nil {
+		return nil, err
+	}
 	dk, err := NewSqliteDeviceKeysTable(db)
 	if err != nil {
 		return nil, err
@@ -169,6 +198,7 @@ func NewKeyDatabase(conMan *sqlutil.Connections, dbProperties *config.DatabaseOp
 
 	return &shared.KeyDatabase{
 		OneTimeKeysTable:      otk,
+		FallbackKeysTable:     fk,
 		DeviceKeysTable:       dk,
 		KeyChangesTable:       kc,
 		StaleDeviceListsTable: sdl,
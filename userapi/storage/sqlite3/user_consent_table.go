@@ -0,0 +1,135 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jchv/maidtrix/clientapi/userutil"
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"github.com/jchv/maidtrix/userapi/storage/tables"
+)
+
+const userConsentSchema = `
+-- Stores which version of the policy documents users accepted.
+CREATE TABLE IF NOT EXISTS userapi_user_consent (
+	localpart TEXT NOT NULL,
+	server_name TEXT NOT NULL,
+	-- The version of the policy documents the user accepted, if any.
+	consent_version TEXT,
+	-- When the user accepted them, in milliseconds.
+	consent_ts BIGINT NOT NULL DEFAULT 0,
+	-- The version of the policy documents the user was last sent a server notice about.
+	notice_version TEXT,
+	PRIMARY KEY (localpart, server_name)
+);
+`
+
+const upsertConsentVersionSQL = "" +
+	"INSERT INTO userapi_user_consent (localpart, server_name, consent_version, consent_ts)" +
+	" VALUES ($1, $2, $3, $4)" +
+	" ON CONFLICT (localpart, server_name)" +
+	" DO UPDATE SET consent_version = $3, consent_ts = $4"
+
+const upsertNoticeVersionSQL = "" +
+	"INSERT INTO userapi_user_consent (localpart, server_name, notice_version)" +
+	" VALUES ($1, $2, $3)" +
+	" ON CONFLICT (localpart, server_name)" +
+	" DO UPDATE SET notice_version = $3"
+
+const selectConsentVersionSQL = "" +
+	"SELECT consent_version FROM userapi_user_consent WHERE localpart = $1 AND server_name = $2"
+
+// Guests, appservice users and the server notices user never need to consent.
+const selectUsersToNotifySQL = "" +
+	"SELECT a.localpart, a.server_name FROM userapi_accounts a" +
+	" LEFT JOIN userapi_user_consent c ON a.localpart = c.localpart AND a.server_name = c.server_name" +
+	" WHERE a.account_type IN (1, 3) AND a.is_deactivated = 0" +
+	" AND (c.consent_version IS NULL OR c.consent_version != $1)" +
+	" AND (c.notice_version IS NULL OR c.notice_version != $1)" +
+	" AND a.localpart != $2"
+
+type userConsentStatements struct {
+	serverNoticesLocalpart   string
+	upsertConsentVersionStmt *sql.Stmt
+	upsertNoticeVersionStmt  *sql.Stmt
+	selectConsentVersionStmt *sql.Stmt
+	selectUsersToNotifyStmt  *sql.Stmt
+}
+
+func NewSQLiteUserConsentTable(db *sql.DB, serverNoticesLocalpart string) (tables.UserConsentTable, error) {
+	s := &userConsentStatements{
+		serverNoticesLocalpart: serverNoticesLocalpart,
+	}
+	_, err := db.Exec(userConsentSchema)
+	if err != nil {
+		return nil, err
+	}
+	return s, sqlutil.StatementList{
+		{&s.upsertConsentVersionStmt, upsertConsentVersionSQL},
+		{&s.upsertNoticeVersionStmt, upsertNoticeVersionSQL},
+		{&s.selectConsentVersionStmt, selectConsentVersionSQL},
+		{&s.selectUsersToNotifyStmt, selectUsersToNotifySQL},
+	}.Prepare(db)
+}
+
+func (s *userConsentStatements) UpsertConsentVersion(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+	version string, consentTS spec.Timestamp,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertConsentVersionStmt).ExecContext(ctx, localpart, serverName, version, consentTS)
+	return err
+}
+
+func (s *userConsentStatements) UpsertNoticeVersion(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, version string,
+) error {
+	_, err := sqlutil.TxStmt(txn, s.upsertNoticeVersionStmt).ExecContext(ctx, localpart, serverName, version)
+	return err
+}
+
+func (s *userConsentStatements) SelectConsentVersion(
+	ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName,
+) (string, error) {
+	var version sql.NullString
+	err := sqlutil.TxStmt(txn, s.selectConsentVersionStmt).QueryRowContext(ctx, localpart, serverName).Scan(&version)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return version.String, err
+}
+
+func (s *userConsentStatements) SelectUsersToNotify(
+	ctx context.Context, txn *sql.Tx, version string,
+) ([]string, error) {
+	rows, err := sqlutil.TxStmt(txn, s.selectUsersToNotifyStmt).QueryContext(ctx, version, s.serverNoticesLocalpart)
+	if err != nil {
+		return nil, err
+	}
+	defer internal.CloseAndLogIfError(ctx, rows, "selectUsersToNotifyStmt: rows.close() failed")
+	var userIDs []string
+	for rows.Next() {
+		var localpart string
+		var serverName spec.ServerName
+		if err = rows.Scan(&localpart, &serverName); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userutil.MakeUserID(localpart, serverName))
+	}
+	return userIDs, rows.Err()
+}
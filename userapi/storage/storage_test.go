@@ -3,6 +3,7 @@ package storage_test
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"reflect"
@@ -10,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jchv/maidtrix/internal/eventutil"
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/sqlutil"
@@ -156,6 +158,47 @@ func Test_Accounts(t *testing.T) {
 	})
 }
 
+func Test_ServerNoticeRecipients(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+		domain := spec.ServerName("localhost")
+
+		_, err := db.CreateAccount(ctx, "alice", domain, "", "", api.AccountTypeAdmin)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "bob", domain, "", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		_, err = db.CreateDevice(ctx, "bob", domain, nil, util.RandomString(16), nil, "", "")
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "charlie", domain, "", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		// Guests, appservice users and deactivated users never get notices.
+		_, err = db.CreateAccount(ctx, "", domain, "", "", api.AccountTypeGuest)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "bridge", domain, "", "", api.AccountTypeAppService)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "dave", domain, "", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		assert.NoError(t, db.DeactivateAccount(ctx, "dave", domain))
+
+		userIDs, err := db.GetServerNoticeRecipients(ctx, false, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost", "@bob:localhost", "@charlie:localhost"}, userIDs)
+
+		userIDs, err = db.GetServerNoticeRecipients(ctx, true, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost"}, userIDs)
+
+		userIDs, err = db.GetServerNoticeRecipients(ctx, false, spec.AsTimestamp(time.Now().Add(-time.Hour)))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@bob:localhost"}, userIDs)
+
+		userIDs, err = db.GetServerNoticeRecipients(ctx, true, spec.AsTimestamp(time.Now().Add(-time.Hour)))
+		assert.NoError(t, err)
+		assert.Empty(t, userIDs)
+	})
+}
+
 func Test_Devices(t *testing.T) {
 	alice := test.NewUser(t)
 	localpart, domain, err := gomatrixserverlib.SplitID('@', alice.ID)
@@ -239,6 +282,298 @@ func Test_Devices(t *testing.T) {
 	})
 }
 
+func Test_DehydratedDevices(t *testing.T) {
+	alice := test.NewUser(t)
+	localpart, domain, err := gomatrixserverlib.SplitID('@', alice.ID)
+	assert.NoError(t, err)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		_, _, err := db.GetDehydratedDevice(ctx, localpart, domain)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		firstID, secondID := "DEHYDRATED1", "DEHYDRATED2"
+		for _, deviceID := range []string{firstID, secondID} {
+			_, err = db.CreateDevice(ctx, localpart, domain, &deviceID, util.RandomString(16), nil, "", "")
+			assert.NoError(t, err, "unable to create device")
+		}
+
+		previousID, err := db.StoreDehydratedDevice(ctx, localpart, domain, firstID, json.RawMessage(`{"pickle":"1"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "", previousID)
+
+		// Re-uploading the same device doesn't report it as replaced
+		previousID, err = db.StoreDehydratedDevice(ctx, localpart, domain, firstID, json.RawMessage(`{"pickle":"2"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, "", previousID)
+
+		previousID, err = db.StoreDehydratedDevice(ctx, localpart, domain, secondID, json.RawMessage(`{"pickle":"3"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, firstID, previousID)
+
+		deviceID, deviceData, err := db.GetDehydratedDevice(ctx, localpart, domain)
+		assert.NoError(t, err)
+		assert.Equal(t, secondID, deviceID)
+		assert.JSONEq(t, `{"pickle":"3"}`, string(deviceData))
+
+		// Removing some other device keeps the dehydrated device
+		err = db.RemoveDevices(ctx, localpart, domain, []string{firstID})
+		assert.NoError(t, err)
+		_, _, err = db.GetDehydratedDevice(ctx, localpart, domain)
+		assert.NoError(t, err)
+
+		// Logging out everywhere keeps the dehydrated device
+		otherID := "OTHERDEVICE"
+		_, err = db.CreateDevice(ctx, localpart, domain, &otherID, util.RandomString(16), nil, "", "")
+		assert.NoError(t, err, "unable to create device")
+		deleted, err := db.RemoveAllDevices(ctx, localpart, domain, "")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(deleted))
+		assert.Equal(t, otherID, deleted[0].ID)
+		deviceID, _, err = db.GetDehydratedDevice(ctx, localpart, domain)
+		assert.NoError(t, err)
+		assert.Equal(t, secondID, deviceID)
+		device, err := db.GetDeviceByID(ctx, localpart, domain, secondID)
+		assert.NoError(t, err)
+		assert.Equal(t, secondID, device.ID)
+
+		// Removing the dehydrated device itself forgets about it
+		err = db.RemoveDevices(ctx, localpart, domain, []string{secondID})
+		assert.NoError(t, err)
+		_, _, err = db.GetDehydratedDevice(ctx, localpart, domain)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func Test_UserConsent(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		_, err := db.CreateAccount(ctx, "alice", "localhost", "testing", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "bob", "localhost", "testing", "", api.AccountTypeAdmin)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "_server", "localhost", "testing", "", api.AccountTypeUser)
+		assert.NoError(t, err)
+		_, err = db.CreateAccount(ctx, "", "localhost", "testing", "", api.AccountTypeGuest)
+		assert.NoError(t, err)
+
+		version, err := db.GetConsentVersion(ctx, "alice", "localhost")
+		assert.NoError(t, err)
+		assert.Equal(t, "", version)
+
+		// Neither alice nor bob accepted the policy documents yet,
+		// the server notices user and guests don't have to.
+		userIDs, err := db.GetUsersToNotifyOfConsent(ctx, "1.0")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"@alice:localhost", "@bob:localhost"}, userIDs)
+
+		assert.NoError(t, db.SetConsentVersion(ctx, "alice", "localhost", "1.0"))
+		version, err = db.GetConsentVersion(ctx, "alice", "localhost")
+		assert.NoError(t, err)
+		assert.Equal(t, "1.0", version)
+
+		assert.NoError(t, db.SetConsentNoticeVersion(ctx, "bob", "localhost", "1.0"))
+		userIDs, err = db.GetUsersToNotifyOfConsent(ctx, "1.0")
+		assert.NoError(t, err)
+		assert.Len(t, userIDs, 0)
+
+		// A new version needs everyone to be notified again
+		userIDs, err = db.GetUsersToNotifyOfConsent(ctx, "2.0")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"@alice:localhost", "@bob:localhost"}, userIDs)
+
+		// Recording the notice keeps the version the user accepted
+		assert.NoError(t, db.SetConsentNoticeVersion(ctx, "alice", "localhost", "2.0"))
+		version, err = db.GetConsentVersion(ctx, "alice", "localhost")
+		assert.NoError(t, err)
+		assert.Equal(t, "1.0", version)
+	})
+}
+
+func Test_UserDirectory(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		for _, localpart := range []string{"alice", "alicia", "bob", "_server"} {
+			_, err := db.CreateAccount(ctx, localpart, "localhost", "testing", "", api.AccountTypeUser)
+			assert.NoError(t, err)
+		}
+		_, err := db.CreateAccount(ctx, "", "localhost", "testing", "", api.AccountTypeGuest)
+		assert.NoError(t, err)
+		_, _, err = db.SetDisplayName(ctx, "bob", "localhost", "Bob Alison")
+		assert.NoError(t, err)
+
+		userIDs := func(profiles []authtypes.FullyQualifiedProfile) []string {
+			ids := make([]string, 0, len(profiles))
+			for _, profile := range profiles {
+				ids = append(ids, profile.UserID)
+			}
+			return ids
+		}
+
+		// Local users who aren't in a public room are only found when searching all users
+		profiles, err := db.SearchUserDirectory(ctx, "ali", false, true, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, profiles)
+
+		// Exact localpart first, then localpart prefix, then display name words.
+		// Guests and the server notices user aren't listed.
+		profiles, err = db.SearchUserDirectory(ctx, "ALI", true, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost", "@alicia:localhost", "@bob:localhost"}, userIDs(profiles))
+		assert.Equal(t, "Bob Alison", profiles[2].DisplayName)
+		profiles, err = db.SearchUserDirectory(ctx, "alicia", true, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alicia:localhost"}, userIDs(profiles))
+
+		// Wildcards match literally
+		profiles, err = db.SearchUserDirectory(ctx, "%", true, true, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, profiles)
+
+		// Users in public rooms are found, remote ones only if configured to
+		assert.NoError(t, db.AddPublicRoomUser(ctx, "!public:localhost", "@alice:localhost", true, "", ""))
+		assert.NoError(t, db.AddPublicRoomUser(ctx, "!public:localhost", "@alison:remote", false, "Alison", ""))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", false, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost", "@alison:remote"}, userIDs(profiles))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", false, false, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost"}, userIDs(profiles))
+
+		// Remote users who left every public room are removed
+		assert.NoError(t, db.AddPublicRoomUser(ctx, "!other:localhost", "@alison:remote", false, "Alison", ""))
+		assert.NoError(t, db.RemovePublicRoomUser(ctx, "!public:localhost", "@alison:remote"))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", false, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost", "@alison:remote"}, userIDs(profiles))
+		assert.NoError(t, db.RemovePublicRoomUser(ctx, "!other:localhost", "@alison:remote"))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", false, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost"}, userIDs(profiles))
+
+		// Remote users of a room which isn't public any more are removed, unless
+		// they are in another public room
+		assert.NoError(t, db.AddPublicRoomUser(ctx, "!public:localhost", "@alison:remote", false, "Alison", ""))
+		assert.NoError(t, db.AddPublicRoomUser(ctx, "!public:localhost", "@alistair:remote", false, "", ""))
+		assert.NoError(t, db.AddPublicRoomUser(ctx, "!other:localhost", "@alistair:remote", false, "", ""))
+		assert.NoError(t, db.RemovePublicRoom(ctx, "!public:localhost"))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", false, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alistair:remote"}, userIDs(profiles))
+		assert.NoError(t, db.RemovePublicRoom(ctx, "!other:localhost"))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", false, true, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, profiles)
+
+		// The backfill from the roomserver starts from the local users and only runs once
+		localUsers, err := db.LocalUserDirectoryUsers(ctx)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"@alice:localhost", "@alicia:localhost", "@bob:localhost"}, localUsers)
+		backfilled, err := db.UserDirectoryBackfilled(ctx)
+		assert.NoError(t, err)
+		assert.False(t, backfilled)
+		assert.NoError(t, db.MarkUserDirectoryBackfilled(ctx))
+		backfilled, err = db.UserDirectoryBackfilled(ctx)
+		assert.NoError(t, err)
+		assert.True(t, backfilled)
+
+		// Deactivated users can't be found
+		assert.NoError(t, db.DeactivateAccount(ctx, "alicia", "localhost"))
+		profiles, err = db.SearchUserDirectory(ctx, "ali", true, true, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"@alice:localhost", "@bob:localhost"}, userIDs(profiles))
+	})
+}
+
+func Test_DelayedEvents(t *testing.T) {
+	alice := test.NewUser(t)
+	bob := test.NewUser(t)
+	room := test.NewRoom(t, alice)
+	localpart, domain, err := gomatrixserverlib.SplitID('@', alice.ID)
+	assert.NoError(t, err)
+	bobLocalpart, bobDomain, err := gomatrixserverlib.SplitID('@', bob.ID)
+	assert.NoError(t, err)
+
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+
+		_, err := db.GetDelayedEvent(ctx, "unknown")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		stateKey := ""
+		message := api.DelayedEvent{
+			DelayID:      "message",
+			RoomID:       room.ID,
+			EventType:    "m.room.message",
+			Delay:        2000,
+			RunningSince: 1000,
+			Content:      json.RawMessage(`{"body":"hello"}`),
+		}
+		state := api.DelayedEvent{
+			DelayID:      "state",
+			RoomID:       room.ID,
+			EventType:    "m.room.topic",
+			StateKey:     &stateKey,
+			Delay:        1000,
+			RunningSince: 1000,
+			Content:      json.RawMessage(`{"topic":"later"}`),
+		}
+		bobs := api.DelayedEvent{
+			DelayID:      "bob",
+			RoomID:       room.ID,
+			EventType:    "m.room.topic",
+			StateKey:     &stateKey,
+			Delay:        5000,
+			RunningSince: 1000,
+			Content:      json.RawMessage(`{"topic":"much later"}`),
+		}
+		assert.NoError(t, db.StoreDelayedEvent(ctx, localpart, domain, &message))
+		assert.NoError(t, db.StoreDelayedEvent(ctx, localpart, domain, &state))
+		assert.NoError(t, db.StoreDelayedEvent(ctx, bobLocalpart, bobDomain, &bobs))
+
+		event, err := db.GetDelayedEvent(ctx, "state")
+		assert.NoError(t, err)
+		assert.Equal(t, alice.ID, event.UserID)
+		assert.Equal(t, &stateKey, event.StateKey)
+		assert.JSONEq(t, `{"topic":"later"}`, string(event.Content))
+
+		// Events are returned soonest first
+		events, err := db.GetDelayedEvents(ctx, localpart, domain)
+		assert.NoError(t, err)
+		assert.Len(t, events, 2)
+		assert.Equal(t, "state", events[0].DelayID)
+		assert.Equal(t, "message", events[1].DelayID)
+		assert.Nil(t, events[1].StateKey)
+
+		assert.NoError(t, db.RestartDelayedEvent(ctx, "state", 5000))
+		events, err = db.GetDelayedEvents(ctx, localpart, domain)
+		assert.NoError(t, err)
+		assert.Equal(t, "message", events[0].DelayID)
+		assert.Equal(t, spec.Timestamp(5000), events[1].RunningSince)
+
+		events, err = db.GetAllDelayedEvents(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, events, 3)
+
+		// Setting the state removes the delayed state events of every user
+		delayIDs, err := db.RemoveDelayedStateEvents(ctx, room.ID, "m.room.topic", stateKey)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"state", "bob"}, delayIDs)
+
+		assert.NoError(t, db.RemoveDelayedEvent(ctx, "message"))
+		events, err = db.GetAllDelayedEvents(ctx)
+		assert.NoError(t, err)
+		assert.Len(t, events, 0)
+	})
+}
+
 func Test_KeyBackup(t *testing.T) {
 	alice := test.NewUser(t)
 	room := test.NewRoom(t, alice)
@@ -536,7 +871,7 @@ func Test_Notification(t *testing.T) {
 				RoomID: roomID,
 				TS:     spec.AsTimestamp(ts),
 			}
-			err = db.InsertNotification(ctx, aliceLocalpart, aliceDomain, eventID, uint64(i+1), nil, notification)
+			err = db.InsertNotification(ctx, aliceLocalpart, aliceDomain, eventID, eventutil.MainThreadID, uint64(i+1), nil, notification)
 			assert.NoError(t, err, "unable to insert notification")
 		}
 
@@ -554,7 +889,7 @@ func Test_Notification(t *testing.T) {
 		assert.Equal(t, int64(4), total)
 
 		// mark notification as read
-		affected, err := db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room2.ID, 7, true)
+		affected, err := db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room2.ID, "", 7, true)
 		assert.NoError(t, err, "unable to set notifications read")
 		assert.True(t, affected)
 
@@ -578,6 +913,53 @@ func Test_Notification(t *testing.T) {
 	})
 }
 
+func Test_ThreadNotification(t *testing.T) {
+	alice := test.NewUser(t)
+	aliceLocalpart, aliceDomain, err := gomatrixserverlib.SplitID('@', alice.ID)
+	assert.NoError(t, err)
+	room := test.NewRoom(t, alice)
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, close := mustCreateUserDatabase(t, dbType)
+		defer close()
+		// notifications 1-3 are in the main timeline, 4-6 are in a thread
+		for i := 0; i < 6; i++ {
+			threadID := eventutil.MainThreadID
+			if i >= 3 {
+				threadID = "$threadroot"
+			}
+			notification := &api.Notification{
+				Event: synctypes.ClientEvent{
+					Content: spec.RawJSON("{}"),
+				},
+				RoomID: room.ID,
+				TS:     spec.AsTimestamp(time.Now()),
+			}
+			err = db.InsertNotification(ctx, aliceLocalpart, aliceDomain, util.RandomString(16), threadID, uint64(i+1), nil, notification)
+			assert.NoError(t, err, "unable to insert notification")
+		}
+
+		totals, _, err := db.GetRoomThreadNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{eventutil.MainThreadID: 3, "$threadroot": 3}, totals)
+
+		// a threaded receipt only affects its own thread
+		affected, err := db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room.ID, "$threadroot", 6, true)
+		assert.NoError(t, err)
+		assert.True(t, affected)
+		totals, _, err = db.GetRoomThreadNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{eventutil.MainThreadID: 3}, totals)
+
+		// an unthreaded receipt affects every thread
+		affected, err = db.SetNotificationsRead(ctx, aliceLocalpart, aliceDomain, room.ID, "", 2, true)
+		assert.NoError(t, err)
+		assert.True(t, affected)
+		total, _, err := db.GetRoomNotificationCounts(ctx, aliceLocalpart, aliceDomain, room.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+	})
+}
+
 func mustCreateKeyDatabase(t *testing.T, dbType test.DBType) (storage.KeyDatabase, func()) {
 	cfg, processCtx, close := testrig.CreateConfig(t, dbType)
 	cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
@@ -809,3 +1191,58 @@ func TestOneTimeKeys(t *testing.T) {
 		}
 	})
 }
+
+func TestFallbackKeys(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		db, clean := mustCreateKeyDatabase(t, dbType)
+		defer clean()
+		userID := "@alice:localhost"
+		deviceID := "alice_device"
+		otk := api.OneTimeKeys{
+			UserID:   userID,
+			DeviceID: deviceID,
+			KeyJSON:  map[string]json.RawMessage{"signed_curve25519:KEY1": []byte(`{"key":"otk"}`)},
+		}
+		fallback := api.OneTimeKeys{
+			UserID:   userID,
+			DeviceID: deviceID,
+			KeyJSON:  map[string]json.RawMessage{"signed_curve25519:FALLBACK1": []byte(`{"key":"fallback","fallback":true}`)},
+		}
+		_, err := db.StoreOneTimeKeys(ctx, otk)
+		MustNotError(t, err)
+		MustNotError(t, db.StoreFallbackKeys(ctx, fallback))
+
+		algorithms, err := db.UnusedFallbackKeyAlgorithms(ctx, userID, deviceID)
+		MustNotError(t, err)
+		assert.Equal(t, []string{"signed_curve25519"}, algorithms)
+
+		// The one-time key is handed out first, then the fallback key as many times as needed.
+		claim := map[string]map[string]string{userID: {deviceID: "signed_curve25519"}}
+		for _, want := range []api.OneTimeKeys{otk, fallback, fallback} {
+			claimedKeys, err := db.ClaimKeys(ctx, claim)
+			MustNotError(t, err)
+			assert.Equal(t, []api.OneTimeKeys{want}, claimedKeys)
+		}
+
+		// The fallback key has now been used.
+		algorithms, err = db.UnusedFallbackKeyAlgorithms(ctx, userID, deviceID)
+		MustNotError(t, err)
+		assert.Empty(t, algorithms)
+
+		// Uploading a new fallback key replaces the used one.
+		fallback.KeyJSON = map[string]json.RawMessage{"signed_curve25519:FALLBACK2": []byte(`{"key":"fallback2","fallback":true}`)}
+		MustNotError(t, db.StoreFallbackKeys(ctx, fallback))
+		algorithms, err = db.UnusedFallbackKeyAlgorithms(ctx, userID, deviceID)
+		MustNotError(t, err)
+		assert.Equal(t, []string{"signed_curve25519"}, algorithms)
+		claimedKeys, err := db.ClaimKeys(ctx, claim)
+		MustNotError(t, err)
+		assert.Equal(t, []api.OneTimeKeys{fallback}, claimedKeys)
+
+		// Deleting the device removes the fallback key.
+		MustNotError(t, db.DeleteDeviceKeys(ctx, userID, []gomatrixserverlib.KeyID{gomatrixserverlib.KeyID(deviceID)}))
+		claimedKeys, err = db.ClaimKeys(ctx, claim)
+		MustNotError(t, err)
+		assert.Empty(t, claimedKeys)
+	})
+}
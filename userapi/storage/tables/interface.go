@@ -68,6 +68,60 @@ type DevicesTable interface {
 	UpdateDeviceLastSeen(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, deviceID, ipAddr, userAgent string) error
 }
 
+type DehydratedDevicesTable interface {
+	UpsertDehydratedDevice(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, deviceID string, deviceData json.RawMessage) error
+	// SelectDehydratedDevice returns sql.ErrNoRows if the user has no dehydrated device.
+	SelectDehydratedDevice(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName) (deviceID string, deviceData json.RawMessage, err error)
+	DeleteDehydratedDevice(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName) error
+}
+
+type AccountValidityTable interface {
+	UpsertAccountValidity(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, expirationTS spec.Timestamp, emailSent bool) error
+	// SelectAccountValidity returns sql.ErrNoRows if the account doesn't expire.
+	SelectAccountValidity(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName) (*api.AccountValidity, error)
+	// SelectAccountValidityByRenewalToken returns sql.ErrNoRows if the token is unknown.
+	SelectAccountValidityByRenewalToken(ctx context.Context, txn *sql.Tx, renewalToken string) (*api.AccountValidity, error)
+	UpdateRenewalToken(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, renewalToken string, emailSent bool) error
+	SelectAccountsToRemind(ctx context.Context, txn *sql.Tx, expiringBefore spec.Timestamp) ([]api.AccountValidity, error)
+}
+
+type UserConsentTable interface {
+	UpsertConsentVersion(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, version string, consentTS spec.Timestamp) error
+	UpsertNoticeVersion(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, version string) error
+	// SelectConsentVersion returns an empty version if the user never gave consent.
+	SelectConsentVersion(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName) (string, error)
+	SelectUsersToNotify(ctx context.Context, txn *sql.Tx, version string) ([]string, error)
+}
+
+type UserDirectoryTable interface {
+	UpsertUser(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, isLocal bool, displayName, avatarURL string) error
+	// UpdateUser updates the profile of a user who is already in the directory.
+	UpdateUser(ctx context.Context, txn *sql.Tx, userID, displayName, avatarURL string) error
+	DeleteUser(ctx context.Context, txn *sql.Tx, userID string) error
+	InsertPublicRoomUser(ctx context.Context, txn *sql.Tx, roomID, userID string) error
+	DeletePublicRoomUser(ctx context.Context, txn *sql.Tx, roomID, userID string) error
+	DeletePublicRoom(ctx context.Context, txn *sql.Tx, roomID string) error
+	// DeleteRemoteUserNotInPublicRooms removes a remote user from the directory if they
+	// aren't in any public room.
+	DeleteRemoteUserNotInPublicRooms(ctx context.Context, txn *sql.Tx, userID string) error
+	// DeleteRemoteUsersOnlyInPublicRoom removes the remote users of the room from the
+	// directory if they aren't in any other public room.
+	DeleteRemoteUsersOnlyInPublicRoom(ctx context.Context, txn *sql.Tx, roomID string) error
+	SelectLocalUserIDs(ctx context.Context, txn *sql.Tx) ([]string, error)
+	SelectUsersBySearch(ctx context.Context, txn *sql.Tx, searchString string, searchAllUsers, includeRemoteUsers bool, limit int) ([]authtypes.FullyQualifiedProfile, error)
+}
+
+type DelayedEventsTable interface {
+	InsertDelayedEvent(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, event *api.DelayedEvent) error
+	// SelectDelayedEvent returns sql.ErrNoRows if there is no delayed event with the given ID.
+	SelectDelayedEvent(ctx context.Context, txn *sql.Tx, delayID string) (*api.DelayedEvent, error)
+	SelectDelayedEventsByLocalpart(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName) ([]api.DelayedEvent, error)
+	SelectAllDelayedEvents(ctx context.Context, txn *sql.Tx) ([]api.DelayedEvent, error)
+	UpdateDelayedEventRunningSince(ctx context.Context, txn *sql.Tx, delayID string, runningSince spec.Timestamp) error
+	DeleteDelayedEvent(ctx context.Context, txn *sql.Tx, delayID string) error
+	SelectDelayedStateEventIDs(ctx context.Context, txn *sql.Tx, roomID, eventType, stateKey string) ([]string, error)
+}
+
 type KeyBackupTable interface {
 	CountKeys(ctx context.Context, txn *sql.Tx, userID, version string) (count int64, err error)
 	InsertBackupKey(ctx context.Context, txn *sql.Tx, userID, version string, key api.InternalKeyBackupSession) (err error)
@@ -120,16 +174,22 @@ type PusherTable interface {
 
 type NotificationTable interface {
 	Clean(ctx context.Context, txn *sql.Tx) error
-	Insert(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, eventID string, pos uint64, highlight bool, n *api.Notification) error
+	Insert(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, eventID, threadID string, pos uint64, highlight bool, n *api.Notification) error
 	DeleteUpTo(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string, pos uint64) (affected bool, _ error)
-	UpdateRead(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string, pos uint64, v bool) (affected bool, _ error)
+	UpdateRead(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID, threadID string, pos uint64, v bool) (affected bool, _ error)
 	Select(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, fromID int64, limit int, filter NotificationFilter) ([]*api.Notification, int64, error)
 	SelectCount(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, filter NotificationFilter) (int64, error)
 	SelectRoomCounts(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string) (total int64, highlight int64, _ error)
+	SelectRoomThreadCounts(ctx context.Context, txn *sql.Tx, localpart string, serverName spec.ServerName, roomID string) (total map[string]int64, highlight map[string]int64, _ error)
 }
 
 type StatsTable interface {
 	UserStatistics(ctx context.Context, txn *sql.Tx) (*types.UserStatistics, *types.DatabaseEngine, error)
+	// MonthlyActiveUsers counts the users seen in the last 30 days.
+	MonthlyActiveUsers(ctx context.Context, txn *sql.Tx) (int64, error)
+	// SelectNoticeRecipients returns the user IDs of the active user and admin accounts, optionally
+	// only the admins, or only those with a device seen since activeSince if it isn't zero.
+	SelectNoticeRecipients(ctx context.Context, txn *sql.Tx, adminsOnly bool, activeSince spec.Timestamp) ([]string, error)
 	DailyRoomsMessages(ctx context.Context, txn *sql.Tx, serverName spec.ServerName) (msgStats types.MessageStats, activeRooms, activeE2EERooms int64, err error)
 	UpdateUserDailyVisits(ctx context.Context, txn *sql.Tx, startTime, lastUpdate time.Time) error
 	UpsertDailyStats(ctx context.Context, txn *sql.Tx, serverName spec.ServerName, stats types.MessageStats, activeRooms, activeE2EERooms int64) error
@@ -168,6 +228,19 @@ type OneTimeKeys interface {
 	DeleteOneTimeKeys(ctx context.Context, txn *sql.Tx, userID, deviceID string) error
 }
 
+// FallbackKeys stores at most one fallback key per user, device and algorithm,
+// which is handed out when a device has run out of one-time keys.
+type FallbackKeys interface {
+	// InsertFallbackKeys replaces any existing fallback keys for the algorithms given.
+	InsertFallbackKeys(ctx context.Context, txn *sql.Tx, keys api.OneTimeKeys) error
+	// SelectUnusedFallbackKeyAlgorithms returns the algorithms for which the device has a fallback key that has not been claimed.
+	SelectUnusedFallbackKeyAlgorithms(ctx context.Context, userID, deviceID string) ([]string, error)
+	// SelectAndMarkFallbackKey selects the fallback key for the user/device/algorithm specified, marks it as used and
+	// returns the algo:key_id => JSON. Fallback keys may be claimed more than once. Returns nil if the key does not exist.
+	SelectAndMarkFallbackKey(ctx context.Context, txn *sql.Tx, userID, deviceID, algorithm string) (map[string]json.RawMessage, error)
+	DeleteFallbackKeys(ctx context.Context, txn *sql.Tx, userID, deviceID string) error
+}
+
 type DeviceKeys interface {
 	SelectDeviceKeysJSON(ctx context.Context, keys []api.DeviceMessage) error
 	InsertDeviceKeys(ctx context.Context, txn *sql.Tx, keys []api.DeviceMessage) error
@@ -180,6 +180,14 @@ func Test_UserStatistics(t *testing.T) {
 				NonBridgedUsers: 5,
 				DailyUsers:      6,
 				MonthlyUsers:    6,
+				DailyUsersByClientType: map[string]int64{
+					"ios": 1, "android": 1, "web": 2, "electron": 1, "unknown": 1,
+				},
+				MonthlyUsersByClientType: map[string]int64{
+					"ios": 1, "android": 1, "web": 2, "electron": 1, "unknown": 1,
+				},
+				DailyRegistrations:   6,
+				MonthlyRegistrations: 6,
 			}
 			if !reflect.DeepEqual(gotStats, wantStats) {
 				t.Errorf("UserStatistics() gotStats = \n%+v\nwant\n%+v", gotStats, wantStats)
@@ -212,6 +220,14 @@ func Test_UserStatistics(t *testing.T) {
 				NonBridgedUsers: 5,
 				DailyUsers:      4,
 				MonthlyUsers:    4,
+				DailyUsersByClientType: map[string]int64{
+					"ios": 0, "android": 0, "web": 2, "electron": 1, "unknown": 1,
+				},
+				MonthlyUsersByClientType: map[string]int64{
+					"ios": 0, "android": 0, "web": 2, "electron": 1, "unknown": 1,
+				},
+				DailyRegistrations:   6,
+				MonthlyRegistrations: 6,
 			}
 			if !reflect.DeepEqual(gotStats, wantStats) {
 				t.Errorf("UserStatistics() gotStats = \n%+v\nwant\n%+v", gotStats, wantStats)
@@ -260,6 +276,14 @@ func Test_UserStatistics(t *testing.T) {
 				NonBridgedUsers: 5,
 				DailyUsers:      5,
 				MonthlyUsers:    5,
+				DailyUsersByClientType: map[string]int64{
+					"ios": 0, "android": 1, "web": 2, "electron": 1, "unknown": 1,
+				},
+				MonthlyUsersByClientType: map[string]int64{
+					"ios": 0, "android": 1, "web": 2, "electron": 1, "unknown": 1,
+				},
+				DailyRegistrations:   4,
+				MonthlyRegistrations: 4,
 			}
 			if !reflect.DeepEqual(gotStats, wantStats) {
 				t.Errorf("UserStatistics() gotStats = \n%+v\nwant\n%+v", gotStats, wantStats)
@@ -311,6 +335,14 @@ func Test_UserStatistics(t *testing.T) {
 				NonBridgedUsers: 5,
 				DailyUsers:      3,
 				MonthlyUsers:    5,
+				DailyUsersByClientType: map[string]int64{
+					"ios": 0, "android": 0, "web": 1, "electron": 1, "unknown": 1,
+				},
+				MonthlyUsersByClientType: map[string]int64{
+					"ios": 0, "android": 1, "web": 2, "electron": 1, "unknown": 1,
+				},
+				DailyRegistrations:   4,
+				MonthlyRegistrations: 4,
 			}
 			if !reflect.DeepEqual(gotStats, wantStats) {
 				t.Errorf("UserStatistics() gotStats = \n%+v\nwant\n%+v", gotStats, wantStats)
@@ -22,6 +22,12 @@ type UserStatistics struct {
 	NonBridgedUsers       int64
 	DailyUsers            int64
 	MonthlyUsers          int64
+	// DailyUsersByClientType and MonthlyUsersByClientType break down the
+	// active users by the client type guessed from their user agents.
+	DailyUsersByClientType   map[string]int64
+	MonthlyUsersByClientType map[string]int64
+	DailyRegistrations       int64
+	MonthlyRegistrations     int64
 }
 
 type DatabaseEngine struct {
@@ -154,9 +154,23 @@ func NewInternalAPI(
 	}
 	time.AfterFunc(time.Minute, cleanOldNotifs)
 
+	if dendriteCfg.UserAPI.AccountValidity.Enabled {
+		var sendRenewalEmails func()
+		sendRenewalEmails = func() {
+			if err := userAPI.SendRenewalEmails(processContext.Context()); err != nil {
+				logrus.WithError(err).Error("Failed to send renewal emails")
+			}
+			time.AfterFunc(time.Hour, sendRenewalEmails)
+		}
+		time.AfterFunc(time.Minute, sendRenewalEmails)
+	}
+
+	usageStats := util.NewUsageStatistics(time.Now(), dendriteCfg, db, rsAPI, cm)
+	userAPI.UsageStatistics = usageStats
 	if dendriteCfg.Global.ReportStats.Enabled {
-		go util.StartPhoneHomeCollector(time.Now(), dendriteCfg, db)
+		go usageStats.StartPhoneHomeCollector()
 	}
+	usageStats.StartPushes(processContext)
 
 	return userAPI
 }
@@ -17,7 +17,9 @@ package userapi_test
 import (
 	"context"
 	"fmt"
+	"net/smtp"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -665,3 +667,219 @@ func TestDeviceIDReuse(t *testing.T) {
 		})
 	})
 }
+
+func TestAccountValidity(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, accountDB, close := MustMakeInternalAPI(t, apiTestOpts{}, dbType, nil)
+		defer close()
+
+		intAPI := userAPI.(*internal.UserInternalAPI)
+		intAPI.Config.AccountValidity.Enabled = true
+		intAPI.Config.AccountValidity.Period = time.Hour
+		intAPI.Config.AccountValidity.RenewAt = time.Minute
+		intAPI.Config.AccountValidity.PublicBaseURL = "https://matrix.example.com"
+		intAPI.Config.AccountValidity.Email.SMTPServer = "localhost:25"
+		intAPI.Config.AccountValidity.Email.From = "noreply@example.com"
+		var sentTo []string
+		var sentMsg string
+		intAPI.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			sentTo, sentMsg = to, string(msg)
+			return nil
+		}
+
+		accRes := api.PerformAccountCreationResponse{}
+		if err := userAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+			AccountType: api.AccountTypeUser,
+			Localpart:   "alice",
+			ServerName:  serverName,
+			Password:    "hunter2",
+		}, &accRes); err != nil {
+			t.Fatal(err)
+		}
+		if err := accountDB.SaveThreePIDAssociation(ctx, "alice@example.com", "alice", serverName, "email"); err != nil {
+			t.Fatal(err)
+		}
+		devRes := api.PerformDeviceCreationResponse{}
+		if err := userAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+			Localpart:   "alice",
+			ServerName:  serverName,
+			AccessToken: util.RandomString(8),
+		}, &devRes); err != nil {
+			t.Fatal(err)
+		}
+		accountExpired := func() bool {
+			t.Helper()
+			res := api.QueryAccessTokenResponse{}
+			if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: devRes.Device.AccessToken}, &res); err != nil {
+				t.Fatal(err)
+			}
+			return res.Device.AccountExpired
+		}
+
+		// New accounts are valid for one period
+		validityRes := api.QueryAccountValidityResponse{}
+		if err := userAPI.QueryAccountValidity(ctx, &api.QueryAccountValidityRequest{UserID: accRes.Account.UserID}, &validityRes); err != nil {
+			t.Fatal(err)
+		}
+		if !validityRes.Expires || validityRes.Validity.Expired(time.Now().Add(time.Minute)) {
+			t.Fatalf("expected account to expire in an hour, got %+v", validityRes)
+		}
+		if accountExpired() {
+			t.Fatalf("expected account not to have expired yet")
+		}
+
+		// No renewal email is sent until the account is about to expire
+		if err := intAPI.SendRenewalEmails(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if sentTo != nil {
+			t.Fatalf("expected no renewal email, but sent one to %v", sentTo)
+		}
+
+		if err := userAPI.PerformAccountValidityUpdate(ctx, &api.PerformAccountValidityUpdateRequest{
+			UserID:              accRes.Account.UserID,
+			ExpirationTS:        spec.AsTimestamp(time.Now().Add(-time.Second)),
+			EnableRenewalEmails: true,
+		}, &api.PerformAccountValidityUpdateResponse{}); err != nil {
+			t.Fatal(err)
+		}
+		if !accountExpired() {
+			t.Fatalf("expected account to have expired")
+		}
+
+		// A renewal email which fails to send is tried again
+		sendMail := intAPI.SendMail
+		intAPI.SendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			return fmt.Errorf("connection refused")
+		}
+		if err := intAPI.SendRenewalEmails(ctx); err != nil {
+			t.Fatal(err)
+		}
+		intAPI.SendMail = sendMail
+
+		if err := intAPI.SendRenewalEmails(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sentTo, []string{"alice@example.com"}) {
+			t.Fatalf("expected renewal email to alice, but sent to %v", sentTo)
+		}
+		_, token, found := strings.Cut(sentMsg, "https://matrix.example.com/_matrix/client/unstable/account_validity/renew?token=")
+		if !found {
+			t.Fatalf("renewal email has no renewal link: %s", sentMsg)
+		}
+		token = strings.TrimSpace(token)
+
+		// The email is only sent once
+		sentTo = nil
+		if err := intAPI.SendRenewalEmails(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if sentTo != nil {
+			t.Fatalf("expected no second renewal email, but sent one to %v", sentTo)
+		}
+
+		renewRes := api.PerformAccountRenewalResponse{}
+		if err := userAPI.PerformAccountRenewal(ctx, &api.PerformAccountRenewalRequest{RenewalToken: token}, &renewRes); err != nil {
+			t.Fatal(err)
+		}
+		if !renewRes.Renewed || renewRes.UserID != accRes.Account.UserID {
+			t.Fatalf("expected account to be renewed, got %+v", renewRes)
+		}
+		if accountExpired() {
+			t.Fatalf("expected account not to have expired after renewal")
+		}
+
+		// Renewal tokens can only be used once
+		renewRes = api.PerformAccountRenewalResponse{}
+		if err := userAPI.PerformAccountRenewal(ctx, &api.PerformAccountRenewalRequest{RenewalToken: token}, &renewRes); err != nil {
+			t.Fatal(err)
+		}
+		if renewRes.Renewed {
+			t.Fatalf("expected renewal token not to be usable twice")
+		}
+	})
+}
+
+func TestUserConsent(t *testing.T) {
+	ctx := context.Background()
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		userAPI, _, close := MustMakeInternalAPI(t, apiTestOpts{}, dbType, nil)
+		defer close()
+
+		intAPI := userAPI.(*internal.UserInternalAPI)
+		intAPI.Config.UserConsent.Enabled = true
+		intAPI.Config.UserConsent.Version = "1.0"
+		intAPI.Config.UserConsent.BlockUntilConsented = true
+
+		accRes := api.PerformAccountCreationResponse{}
+		if err := userAPI.PerformAccountCreation(ctx, &api.PerformAccountCreationRequest{
+			AccountType: api.AccountTypeUser,
+			Localpart:   "alice",
+			ServerName:  serverName,
+			Password:    "hunter2",
+		}, &accRes); err != nil {
+			t.Fatal(err)
+		}
+		devRes := api.PerformDeviceCreationResponse{}
+		if err := userAPI.PerformDeviceCreation(ctx, &api.PerformDeviceCreationRequest{
+			Localpart:   "alice",
+			ServerName:  serverName,
+			AccessToken: util.RandomString(8),
+		}, &devRes); err != nil {
+			t.Fatal(err)
+		}
+		consentRequired := func() bool {
+			t.Helper()
+			res := api.QueryAccessTokenResponse{}
+			if err := userAPI.QueryAccessToken(ctx, &api.QueryAccessTokenRequest{AccessToken: devRes.Device.AccessToken}, &res); err != nil {
+				t.Fatal(err)
+			}
+			return res.Device.ConsentRequired
+		}
+
+		if !consentRequired() {
+			t.Fatalf("expected consent to be required before accepting the policy documents")
+		}
+		noticeRes := api.QueryConsentNoticeUsersResponse{}
+		if err := userAPI.QueryConsentNoticeUsers(ctx, &struct{}{}, &noticeRes); err != nil {
+			t.Fatal(err)
+		}
+		if len(noticeRes.UserIDs) != 1 || noticeRes.UserIDs[0] != accRes.Account.UserID {
+			t.Fatalf("expected a consent notice for %s, got %v", accRes.Account.UserID, noticeRes.UserIDs)
+		}
+
+		// An empty version accepts the current one
+		if err := userAPI.PerformUserConsent(ctx, &api.PerformUserConsentRequest{UserID: accRes.Account.UserID}, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+		if consentRequired() {
+			t.Fatalf("expected consent not to be required after accepting the policy documents")
+		}
+
+		// Changing the policy documents requires consent again
+		intAPI.Config.UserConsent.Version = "2.0"
+		if !consentRequired() {
+			t.Fatalf("expected consent to be required after the policy documents changed")
+		}
+		queryRes := api.QueryUserConsentResponse{}
+		if err := userAPI.QueryUserConsent(ctx, &api.QueryUserConsentRequest{UserID: accRes.Account.UserID}, &queryRes); err != nil {
+			t.Fatal(err)
+		}
+		if queryRes.ConsentVersion != "1.0" || !queryRes.ConsentRequired {
+			t.Fatalf("expected version 1.0 to be accepted and consent required, got %+v", queryRes)
+		}
+
+		// Consent notices are only sent once per version
+		if err := userAPI.PerformConsentNoticeSent(ctx, &api.PerformConsentNoticeSentRequest{UserID: accRes.Account.UserID}, &struct{}{}); err != nil {
+			t.Fatal(err)
+		}
+		noticeRes = api.QueryConsentNoticeUsersResponse{}
+		if err := userAPI.QueryConsentNoticeUsers(ctx, &struct{}{}, &noticeRes); err != nil {
+			t.Fatal(err)
+		}
+		if len(noticeRes.UserIDs) != 0 {
+			t.Fatalf("expected no consent notices, got %v", noticeRes.UserIDs)
+		}
+	})
+}
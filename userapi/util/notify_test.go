@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jchv/maidtrix/internal/eventutil"
 	"github.com/jchv/maidtrix/internal/matrixserver"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
 	"github.com/jchv/maidtrix/internal/sqlutil"
@@ -114,7 +115,7 @@ func TestNotifyUserCountsAsync(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
-		if err := db.InsertNotification(ctx, aliceLocalpart, serverName, dummyEvent.EventID(), 0, nil, &api.Notification{
+		if err := db.InsertNotification(ctx, aliceLocalpart, serverName, dummyEvent.EventID(), eventutil.MainThreadID, 0, nil, &api.Notification{
 			Event: *ev,
 		}); err != nil {
 			t.Error(err)
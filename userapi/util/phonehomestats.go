@@ -21,6 +21,7 @@ import (
 	"math"
 	"net/http"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 
@@ -28,17 +29,20 @@ import (
 
 	"github.com/jchv/maidtrix/internal"
 	"github.com/jchv/maidtrix/internal/matrixserver/spec"
+	rsapi "github.com/jchv/maidtrix/roomserver/api"
 	"github.com/jchv/maidtrix/setup/config"
 	"github.com/jchv/maidtrix/userapi/storage"
 )
 
 type phoneHomeStats struct {
+	mu         sync.Mutex // protects prevData and stats
 	prevData   timestampToRUUsage
 	stats      map[string]interface{}
 	serverName spec.ServerName
 	startTime  time.Time
 	cfg        *config.Dendrite
 	db         storage.Statistics
+	rsAPI      rsapi.UserRoomserverAPI
 	isMonolith bool
 	client     *http.Client
 }
@@ -48,31 +52,29 @@ type timestampToRUUsage struct {
 	usage     syscall.Rusage
 }
 
-func StartPhoneHomeCollector(startTime time.Time, cfg *config.Dendrite, statsDB storage.Statistics) {
-
-	p := phoneHomeStats{
-		startTime:  startTime,
-		serverName: cfg.Global.ServerName,
-		cfg:        cfg,
-		db:         statsDB,
-		isMonolith: true,
-		client: &http.Client{
-			Timeout:   time.Second * 30,
-			Transport: http.DefaultTransport,
-		},
+func (p *phoneHomeStats) collect() {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute*1)
+	defer cancel()
+
+	output := bytes.Buffer{}
+	if err := json.NewEncoder(&output).Encode(p.gather(ctx)); err != nil {
+		logrus.WithError(err).Error("Unable to encode phone-home statistics")
+		return
 	}
 
-	// start initial run after 5min
-	time.AfterFunc(time.Minute*5, p.collect)
+	logrus.Infof("Reporting stats to %s: %s", p.cfg.Global.ReportStats.Endpoint, output.String())
 
-	// run every 3 hours
-	ticker := time.NewTicker(time.Hour * 3)
-	for range ticker.C {
-		p.collect()
+	if err := postStatistics(ctx, p.client, p.cfg.Global.ReportStats.Endpoint, &output); err != nil {
+		logrus.WithError(err).Error("Unable to send phone-home statistics")
 	}
 }
 
-func (p *phoneHomeStats) collect() {
+// gather collects the statistics in the phone-home format. The returned
+// map is not modified afterwards, so it is safe to use after further calls.
+func (p *phoneHomeStats) gather(ctx context.Context) map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	p.stats = make(map[string]interface{})
 	// general information
 	p.stats["homeserver"] = p.serverName
@@ -86,9 +88,6 @@ func (p *phoneHomeStats) collect() {
 	p.stats["num_go_routine"] = runtime.NumGoroutine()
 	p.stats["uptime_seconds"] = math.Floor(time.Since(p.startTime).Seconds())
 
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute*1)
-	defer cancel()
-
 	// cpu and memory usage information
 	err := getMemoryStats(p)
 	if err != nil {
@@ -108,8 +107,19 @@ func (p *phoneHomeStats) collect() {
 	}
 
 	// message and room stats
-	// TODO: Find a solution to actually set this value
 	p.stats["total_room_count"] = 0
+	if p.rsAPI != nil {
+		roomStats, roomErr := p.rsAPI.QueryRoomStatistics(ctx)
+		if roomErr != nil {
+			logrus.WithError(roomErr).Warn("unable to query room stats, using default values")
+		} else {
+			var total int64
+			for _, count := range roomStats.RoomsByVersion {
+				total += count
+			}
+			p.stats["total_room_count"] = total
+		}
+	}
 
 	messageStats, activeRooms, activeE2EERooms, err := p.db.DailyRoomsMessages(ctx, p.serverName)
 	if err != nil {
@@ -143,24 +153,5 @@ func (p *phoneHomeStats) collect() {
 		p.stats["r30v2_users_"+t] = c
 	}
 
-	output := bytes.Buffer{}
-	if err = json.NewEncoder(&output).Encode(p.stats); err != nil {
-		logrus.WithError(err).Error("Unable to encode phone-home statistics")
-		return
-	}
-
-	logrus.Infof("Reporting stats to %s: %s", p.cfg.Global.ReportStats.Endpoint, output.String())
-
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.Global.ReportStats.Endpoint, &output)
-	if err != nil {
-		logrus.WithError(err).Error("Unable to create phone-home statistics request")
-		return
-	}
-	request.Header.Set("User-Agent", "Dendrite/"+internal.VersionString())
-
-	_, err = p.client.Do(request)
-	if err != nil {
-		logrus.WithError(err).Error("Unable to send phone-home statistics")
-		return
-	}
+	return p.stats
 }
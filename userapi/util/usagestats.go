@@ -0,0 +1,302 @@
+// Copyright 2024 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jchv/maidtrix/internal"
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	rsapi "github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/setup/process"
+	"github.com/jchv/maidtrix/userapi/api"
+	"github.com/jchv/maidtrix/userapi/storage"
+)
+
+// UsageStatistics collects usage statistics about this server, either in
+// the phone-home format or in the detailed format, and reports them to the
+// configured endpoints.
+type UsageStatistics struct {
+	phoneHome *phoneHomeStats
+	startTime time.Time
+	cfg       *config.Dendrite
+	db        storage.Statistics
+	rsAPI     rsapi.UserRoomserverAPI
+	cm        *sqlutil.Connections
+	mediaSize *mediaSizeCache
+	client    *http.Client
+}
+
+func NewUsageStatistics(
+	startTime time.Time, cfg *config.Dendrite, statsDB storage.Statistics,
+	rsAPI rsapi.UserRoomserverAPI, cm *sqlutil.Connections,
+) *UsageStatistics {
+	client := &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: http.DefaultTransport,
+	}
+	mediaPath := cfg.MediaAPI.AbsBasePath
+	if mediaPath == "" {
+		mediaPath = cfg.MediaAPI.BasePath
+	}
+	return &UsageStatistics{
+		phoneHome: &phoneHomeStats{
+			startTime:  startTime,
+			serverName: cfg.Global.ServerName,
+			cfg:        cfg,
+			db:         statsDB,
+			rsAPI:      rsAPI,
+			isMonolith: true,
+			client:     client,
+		},
+		startTime: startTime,
+		cfg:       cfg,
+		db:        statsDB,
+		rsAPI:     rsAPI,
+		cm:        cm,
+		mediaSize: &mediaSizeCache{path: string(mediaPath)},
+		client:    client,
+	}
+}
+
+// StartPhoneHomeCollector periodically reports statistics to the phone-home
+// endpoint. It never returns, so should be called in a goroutine.
+func (u *UsageStatistics) StartPhoneHomeCollector() {
+	// start initial run after 5min
+	time.AfterFunc(time.Minute*5, u.phoneHome.collect)
+
+	// run every 3 hours
+	ticker := time.NewTicker(time.Hour * 3)
+	for range ticker.C {
+		u.phoneHome.collect()
+	}
+}
+
+// StartPushes starts periodically sending statistics to each of the
+// endpoints in the report_stats.push configuration.
+func (u *UsageStatistics) StartPushes(processCtx *process.ProcessContext) {
+	for _, push := range u.cfg.Global.ReportStats.Push {
+		go u.push(processCtx, push.WithDefaults())
+	}
+}
+
+func (u *UsageStatistics) push(processCtx *process.ProcessContext, push config.ReportStatsPush) {
+	ticker := time.NewTicker(push.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-processCtx.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(processCtx.Context(), time.Minute)
+		if err := u.pushOnce(ctx, push); err != nil {
+			logrus.WithError(err).WithField("endpoint", push.Endpoint).Error("Unable to push usage statistics")
+		}
+		cancel()
+	}
+}
+
+func (u *UsageStatistics) pushOnce(ctx context.Context, push config.ReportStatsPush) error {
+	stats, err := u.Collect(ctx, push.Format)
+	if err != nil {
+		return err
+	}
+	output := bytes.Buffer{}
+	if err = json.NewEncoder(&output).Encode(stats); err != nil {
+		return fmt.Errorf("json.Encode: %w", err)
+	}
+	return postStatistics(ctx, u.client, push.Endpoint, &output)
+}
+
+// Collect returns the statistics in the given format, which is one of
+// config.ReportStatsFormatPhoneHome or config.ReportStatsFormatDetailed.
+func (u *UsageStatistics) Collect(ctx context.Context, format string) (interface{}, error) {
+	switch format {
+	case config.ReportStatsFormatPhoneHome:
+		return u.phoneHome.gather(ctx), nil
+	case config.ReportStatsFormatDetailed:
+		return u.Detailed(ctx)
+	default:
+		return nil, fmt.Errorf("unknown usage statistics format %q", format)
+	}
+}
+
+// Detailed collects the statistics in the detailed format. Failing to
+// work out the size of the databases or the media store is not fatal,
+// in which case those sizes are left empty.
+func (u *UsageStatistics) Detailed(ctx context.Context) (*api.UsageStatistics, error) {
+	userStats, dbEngine, err := u.db.UserStatistics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("u.db.UserStatistics: %w", err)
+	}
+	messageStats, activeRooms, activeE2EERooms, err := u.db.DailyRoomsMessages(ctx, u.cfg.Global.ServerName)
+	if err != nil {
+		return nil, fmt.Errorf("u.db.DailyRoomsMessages: %w", err)
+	}
+	roomStats, err := u.rsAPI.QueryRoomStatistics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("u.rsAPI.QueryRoomStatistics: %w", err)
+	}
+
+	stats := &api.UsageStatistics{
+		ServerName:    u.cfg.Global.ServerName,
+		Version:       internal.VersionString(),
+		Timestamp:     time.Now().Unix(),
+		UptimeSeconds: int64(math.Floor(time.Since(u.startTime).Seconds())),
+		Users: api.UsageStatisticsUsers{
+			Total:                      userStats.AllUsers,
+			NonBridged:                 userStats.NonBridgedUsers,
+			DailyActive:                userStats.DailyUsers,
+			MonthlyActive:              userStats.MonthlyUsers,
+			DailyActiveByClientType:    userStats.DailyUsersByClientType,
+			MonthlyActiveByClientType:  userStats.MonthlyUsersByClientType,
+			DailyRegistrations:         userStats.DailyRegistrations,
+			MonthlyRegistrations:       userStats.MonthlyRegistrations,
+			MonthlyRegistrationsByType: userStats.RegisteredUsersByType,
+		},
+		Rooms: api.UsageStatisticsRooms{
+			ByVersion:              make(map[string]int64, len(roomStats.RoomsByVersion)),
+			Encrypted:              roomStats.EncryptedRooms,
+			DailyActive:            activeRooms,
+			DailyActiveEncrypted:   activeE2EERooms,
+			DailyMessages:          messageStats.Messages,
+			DailyEncryptedMessages: messageStats.MessagesE2EE,
+		},
+		Federation: api.UsageStatisticsFederation{
+			Disabled:            u.cfg.Global.DisableFederation,
+			JoinedRemoteServers: roomStats.JoinedRemoteServers,
+		},
+		Storage: api.UsageStatisticsStorage{
+			DatabaseEngine:  dbEngine.Engine,
+			DatabaseVersion: dbEngine.Version,
+		},
+	}
+	for roomVersion, count := range roomStats.RoomsByVersion {
+		stats.Rooms.ByVersion[string(roomVersion)] = count
+		stats.Rooms.Total += count
+	}
+
+	if u.cm != nil {
+		if stats.Storage.DatabaseSizes, err = u.cm.DatabaseSizes(ctx); err != nil {
+			logrus.WithError(err).Warn("unable to get database sizes")
+		}
+	}
+	if stats.Storage.MediaSize, err = u.MediaStoreSize(); err != nil {
+		logrus.WithError(err).Warn("unable to get media store size")
+	}
+	return stats, nil
+}
+
+// MediaStoreSize returns the total size in bytes of the media store. The
+// size is cached, as it means walking the whole media store, and may be
+// up to mediaSizeRefreshInterval out of date.
+func (u *UsageStatistics) MediaStoreSize() (int64, error) {
+	return u.mediaSize.get()
+}
+
+// mediaSizeRefreshInterval is how long the size of the media store is
+// cached for before it is measured again.
+const mediaSizeRefreshInterval = time.Minute * 15
+
+type mediaSizeCache struct {
+	path       string
+	mu         sync.Mutex
+	size       int64
+	err        error
+	updated    time.Time
+	refreshing bool
+}
+
+// get returns the cached size of the media store. The first call measures
+// it straight away. Later calls return the cached size, refreshing it in
+// the background once it is out of date.
+func (c *mediaSizeCache) get() (int64, error) {
+	if c.path == "" {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updated.IsZero() {
+		c.size, c.err = directorySize(c.path)
+		c.updated = time.Now()
+	} else if time.Since(c.updated) > mediaSizeRefreshInterval && !c.refreshing {
+		c.refreshing = true
+		go c.refresh()
+	}
+	return c.size, c.err
+}
+
+func (c *mediaSizeCache) refresh() {
+	size, err := directorySize(c.path)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size, c.err = size, err
+	c.updated = time.Now()
+	c.refreshing = false
+}
+
+// directorySize returns the total size in bytes of the regular files
+// within the given directory and its subdirectories.
+func directorySize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+func postStatistics(ctx context.Context, client *http.Client, endpoint string, body io.Reader) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Dendrite/"+internal.VersionString())
+
+	res, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer internal.CloseAndLogIfError(ctx, res.Body, "postStatistics: failed to close response body")
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jchv/maidtrix/internal/sqlutil"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jchv/maidtrix/internal/matrixserver"
+	rsapi "github.com/jchv/maidtrix/roomserver/api"
+	"github.com/jchv/maidtrix/roomserver/types"
+	"github.com/jchv/maidtrix/setup/config"
+	"github.com/jchv/maidtrix/test"
+	"github.com/jchv/maidtrix/test/testrig"
+	"github.com/jchv/maidtrix/userapi/api"
+	"github.com/jchv/maidtrix/userapi/storage"
+)
+
+type roomStatsRoomserverAPI struct {
+	rsapi.UserRoomserverAPI
+}
+
+func (r *roomStatsRoomserverAPI) QueryRoomStatistics(ctx context.Context) (*types.RoomStatistics, error) {
+	return &types.RoomStatistics{
+		RoomsByVersion: map[gomatrixserverlib.RoomVersion]int64{
+			gomatrixserverlib.RoomVersionV9:  2,
+			gomatrixserverlib.RoomVersionV10: 3,
+		},
+		EncryptedRooms:      4,
+		JoinedRemoteServers: 5,
+	}, nil
+}
+
+func TestUsageStatistics(t *testing.T) {
+	test.WithAllDatabases(t, func(t *testing.T, dbType test.DBType) {
+		cfg, processCtx, closeDB := testrig.CreateConfig(t, dbType)
+		defer closeDB()
+		cm := sqlutil.NewConnectionManager(processCtx, cfg.Global.DatabaseOptions)
+		db, err := storage.NewUserDatabase(processCtx.Context(), cm, &cfg.UserAPI.AccountDatabase, "localhost", bcrypt.MinCost, 1000, 1000, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mediaPath := t.TempDir()
+		if err = os.WriteFile(filepath.Join(mediaPath, "file"), make([]byte, 1234), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg.MediaAPI.AbsBasePath = config.Path(mediaPath)
+
+		received := make(chan api.UsageStatistics, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var data api.UsageStatistics
+			if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+				t.Error(err)
+			}
+			select {
+			case received <- data:
+			default:
+			}
+		}))
+		defer srv.Close()
+
+		cfg.Global.ReportStats.Push = []config.ReportStatsPush{
+			{Endpoint: srv.URL, Interval: time.Millisecond * 100, Format: config.ReportStatsFormatDetailed},
+		}
+		usageStats := NewUsageStatistics(time.Now(), cfg, db, &roomStatsRoomserverAPI{}, cm)
+		usageStats.StartPushes(processCtx)
+		defer processCtx.ShutdownDendrite()
+
+		var stats api.UsageStatistics
+		select {
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for usage statistics")
+		case stats = <-received:
+		}
+
+		if stats.Rooms.Total != 5 {
+			t.Errorf("expected 5 rooms, got %d", stats.Rooms.Total)
+		}
+		if stats.Rooms.ByVersion["10"] != 3 {
+			t.Errorf("expected 3 rooms with version 10, got %d", stats.Rooms.ByVersion["10"])
+		}
+		if stats.Federation.JoinedRemoteServers != 5 {
+			t.Errorf("expected 5 remote servers, got %d", stats.Federation.JoinedRemoteServers)
+		}
+		if stats.Storage.MediaSize != 1234 {
+			t.Errorf("expected media size of 1234, got %d", stats.Storage.MediaSize)
+		}
+		// Resource limits are checked against these rather than the full statistics
+		monthlyActive, err := db.MonthlyActiveUsers(processCtx.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if monthlyActive != stats.Users.MonthlyActive {
+			t.Errorf("expected %d monthly active users, got %d", stats.Users.MonthlyActive, monthlyActive)
+		}
+		if mediaSize, _ := usageStats.MediaStoreSize(); mediaSize != 1234 {
+			t.Errorf("expected media size of 1234, got %d", mediaSize)
+		}
+		if len(stats.Storage.DatabaseSizes) == 0 {
+			t.Errorf("expected database sizes to be reported")
+		}
+		if _, ok := stats.Users.DailyActiveByClientType["web"]; !ok {
+			t.Errorf("expected daily active users by client type, got %+v", stats.Users.DailyActiveByClientType)
+		}
+
+		phoneHome, err := usageStats.Collect(processCtx.Context(), config.ReportStatsFormatPhoneHome)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total := phoneHome.(map[string]interface{})["total_room_count"]; total != int64(5) {
+			t.Errorf("expected total_room_count of 5, got %v", total)
+		}
+	})
+}
+
+func TestMediaSizeCache(t *testing.T) {
+	mediaPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(mediaPath, "file"), make([]byte, 1234), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cache := &mediaSizeCache{path: mediaPath}
+	size, err := cache.get()
+	if err != nil || size != 1234 {
+		t.Fatalf("expected media size of 1234, got %d (%v)", size, err)
+	}
+
+	// The cached size is used until it is out of date
+	if err = os.WriteFile(filepath.Join(mediaPath, "other"), make([]byte, 100), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if size, _ = cache.get(); size != 1234 {
+		t.Fatalf("expected cached media size of 1234, got %d", size)
+	}
+
+	cache.mu.Lock()
+	cache.updated = time.Now().Add(-mediaSizeRefreshInterval * 2)
+	cache.mu.Unlock()
+	_, _ = cache.get()
+	deadline := time.Now().Add(time.Second * 5)
+	for size != 1334 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected refreshed media size of 1334, got %d", size)
+		}
+		time.Sleep(time.Millisecond * 10)
+		size, _ = cache.get()
+	}
+}